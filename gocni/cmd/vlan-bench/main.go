@@ -0,0 +1,141 @@
+// vlan-bench runs parallel ADD/DEL storms of the plugin binary against a
+// farm of throwaway network namespaces and reports latency distributions.
+// It needs root and exits non-zero when a latency budget is exceeded.
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/invoke"
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/containernetworking/plugins/pkg/testutils"
+)
+
+func main() {
+    pluginPath := flag.String("plugin", "/opt/cni/bin/vlan-cni", "plugin binary to exercise")
+    configPath := flag.String("config", "", "network configuration passed on stdin")
+    cniPath := flag.String("cni-path", "/opt/cni/bin", "CNI_PATH for IPAM plugins")
+    iterations := flag.Int("n", 100, "ADD/DEL cycles per worker")
+    parallel := flag.Int("parallel", 4, "concurrent workers, each with its own netns")
+    ifName := flag.String("ifname", "net1", "container interface name")
+    addBudget := flag.Duration("budget-add-p99", 0, "fail when ADD p99 exceeds this")
+    delBudget := flag.Duration("budget-del-p99", 0, "fail when DEL p99 exceeds this")
+    flag.Parse()
+    
+    if *configPath == "" {
+        log.Fatal("-config is required")
+    }
+    netconf, err := ioutil.ReadFile(*configPath)
+    if err != nil {
+        log.Fatalf("failed to read config: %v", err)
+    }
+    
+    var (
+        mu       sync.Mutex
+        adds     []time.Duration
+        dels     []time.Duration
+        failures int
+        wg       sync.WaitGroup
+    )
+    
+    for w := 0; w < *parallel; w++ {
+        netns, err := testutils.NewNS()
+        if err != nil {
+            log.Fatalf("failed to create netns: %v", err)
+        }
+        defer testutils.UnmountNS(netns)
+        
+        wg.Add(1)
+        go func(w int, netns ns.NetNS) {
+            defer wg.Done()
+            for i := 0; i < *iterations; i++ {
+                args := &invoke.Args{
+                    ContainerID: fmt.Sprintf("bench-%d-%d", w, i),
+                    NetNS:       netns.Path(),
+                    IfName:      *ifName,
+                    Path:        *cniPath,
+                }
+                
+                add, err := run(*pluginPath, "ADD", netconf, args)
+                if err == nil {
+                    var del time.Duration
+                    del, err = run(*pluginPath, "DEL", netconf, args)
+                    mu.Lock()
+                    adds = append(adds, add)
+                    if err == nil {
+                        dels = append(dels, del)
+                    }
+                    mu.Unlock()
+                }
+                if err != nil {
+                    log.Printf("worker %d cycle %d: %v", w, i, err)
+                    mu.Lock()
+                    failures++
+                    mu.Unlock()
+                }
+            }
+        }(w, netns)
+    }
+    wg.Wait()
+    
+    addP99 := report("ADD", adds)
+    delP99 := report("DEL", dels)
+    fmt.Printf("failures: %d\n", failures)
+    
+    exit := 0
+    if failures > 0 {
+        exit = 1
+    }
+    if *addBudget > 0 && addP99 > *addBudget {
+        fmt.Printf("ADD p99 %s exceeds budget %s\n", addP99, *addBudget)
+        exit = 1
+    }
+    if *delBudget > 0 && delP99 > *delBudget {
+        fmt.Printf("DEL p99 %s exceeds budget %s\n", delP99, *delBudget)
+        exit = 1
+    }
+    os.Exit(exit)
+}
+
+// run invokes the plugin once and returns how long it took
+func run(pluginPath, command string, netconf []byte, args *invoke.Args) (time.Duration, error) {
+    a := *args
+    a.Command = command
+    
+    start := time.Now()
+    var err error
+    if command == "ADD" {
+        _, err = invoke.ExecPluginWithResult(context.Background(), pluginPath, netconf, &a, nil)
+    } else {
+        err = invoke.ExecPluginWithoutResult(context.Background(), pluginPath, netconf, &a, nil)
+    }
+    if err != nil {
+        return 0, fmt.Errorf("%s %s: %v", filepath.Base(pluginPath), command, err)
+    }
+    return time.Since(start), nil
+}
+
+// report prints the latency distribution and returns its p99
+func report(name string, samples []time.Duration) time.Duration {
+    if len(samples) == 0 {
+        fmt.Printf("%s: no samples\n", name)
+        return 0
+    }
+    sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+    
+    pct := func(p float64) time.Duration {
+        return samples[int(p*float64(len(samples)-1))]
+    }
+    fmt.Printf("%s: n=%d min=%s p50=%s p90=%s p99=%s max=%s\n",
+        name, len(samples), samples[0], pct(0.50), pct(0.90), pct(0.99), samples[len(samples)-1])
+    return pct(0.99)
+}
@@ -0,0 +1,172 @@
+// vlan-cni-conf maintains the CNI network configuration files on a node.
+// The installer runs it after writing the configuration.
+//
+//   vlan-cni-conf upgrade [-bin-dir DIRS] [-runtime-versions LIST] FILE...
+//   vlan-cni-conf merge [-kubeconfig PATH] [-namespace NS] [-selector LABELS] FILE...
+//   vlan-cni-conf check [-kubeconfig PATH] [-nads] FILE...
+//
+// upgrade negotiates each file's cniVersion with the runtime and the
+// plugins it lists and rewrites it to the newest mutually supported one.
+//
+// merge applies the per-network overrides of labeled ConfigMaps to each
+// file's plugin chain, see install.Override.
+//
+// check refuses networks that use the same VLAN of a master with different
+// IPAM, across the files and, with -nads, NetworkAttachmentDefinitions. It
+// also refuses sops-encrypted conflists, as only .conf files can be
+// encrypted.
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "example.com/vlan-cni/pkg/install"
+    "example.com/vlan-cni/pkg/kube"
+)
+
+// defaultRuntimeVersions are understood by the CNI library in containerd
+// 2.0 and later. Pass -runtime-versions without 1.1.0 for older runtimes,
+// such as containerd 1.7; install.sh detects those.
+const defaultRuntimeVersions = "0.3.0,0.3.1,0.4.0,1.0.0,1.1.0"
+
+func main() {
+    log.SetFlags(0)
+    if len(os.Args) < 2 {
+        usage()
+    }
+    
+    switch os.Args[1] {
+    case "upgrade":
+        upgrade(os.Args[2:])
+    case "merge":
+        merge(os.Args[2:])
+    case "check":
+        check(os.Args[2:])
+    default:
+        usage()
+    }
+}
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: vlan-cni-conf upgrade [-bin-dir DIRS] [-runtime-versions LIST] FILE...")
+    fmt.Fprintln(os.Stderr, "       vlan-cni-conf merge [-kubeconfig PATH] [-namespace NS] [-selector LABELS] FILE...")
+    fmt.Fprintln(os.Stderr, "       vlan-cni-conf check [-kubeconfig PATH] [-nads] FILE...")
+    os.Exit(2)
+}
+
+func upgrade(args []string) {
+    fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+    binDir := fs.String("bin-dir", "/opt/cni/bin", "plugin directories, separated like PATH")
+    runtimeVersions := fs.String("runtime-versions", defaultRuntimeVersions, "comma separated CNI versions the container runtime supports")
+    fs.Parse(args)
+    if fs.NArg() == 0 {
+        usage()
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    
+    failed := false
+    for _, path := range fs.Args() {
+        n, err := install.UpgradeVersion(ctx, path, filepath.SplitList(*binDir), strings.Split(*runtimeVersions, ","))
+        if n != nil {
+            log.Print(n)
+        }
+        if err != nil {
+            log.Print(err)
+            failed = true
+        }
+    }
+    if failed {
+        os.Exit(1)
+    }
+}
+
+func merge(args []string) {
+    fs := flag.NewFlagSet("merge", flag.ExitOnError)
+    kubeconfig := fs.String("kubeconfig", "", "kubeconfig, the in-cluster configuration when empty")
+    namespace := fs.String("namespace", "kube-system", "namespace of the override ConfigMaps")
+    selector := fs.String("selector", install.DefaultOverridesSelector, "label selector of the override ConfigMaps")
+    fs.Parse(args)
+    if fs.NArg() == 0 {
+        usage()
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    
+    client, err := kube.NewClient(*kubeconfig)
+    if err != nil {
+        log.Fatal(err)
+    }
+    overrides, err := install.ListOverrides(ctx, client, *namespace, *selector)
+    if err != nil {
+        log.Fatal(err)
+    }
+    
+    failed := false
+    for _, path := range fs.Args() {
+        m, err := install.MergeOverrides(path, overrides)
+        if m != nil {
+            log.Print(m)
+        }
+        if err != nil {
+            log.Print(err)
+            failed = true
+        }
+    }
+    if failed {
+        os.Exit(1)
+    }
+}
+
+func check(args []string) {
+    fs := flag.NewFlagSet("check", flag.ExitOnError)
+    kubeconfig := fs.String("kubeconfig", "", "kubeconfig, the in-cluster configuration when empty")
+    nads := fs.Bool("nads", false, "also check the cluster's NetworkAttachmentDefinitions")
+    fs.Parse(args)
+    if fs.NArg() == 0 {
+        usage()
+    }
+    
+    var segments []*install.Segment
+    for _, path := range fs.Args() {
+        if err := install.CheckEncryption(path); err != nil {
+            log.Fatal(err)
+        }
+        found, err := install.FileSegments(path)
+        if err != nil {
+            log.Fatal(err)
+        }
+        segments = append(segments, found...)
+    }
+    if *nads {
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        
+        client, err := kube.NewDynamicClient(*kubeconfig)
+        if err != nil {
+            log.Fatal(err)
+        }
+        found, err := install.NADSegments(ctx, client)
+        if err != nil {
+            log.Fatal(err)
+        }
+        segments = append(segments, found...)
+    }
+    
+    duplicates := install.FindDuplicates(segments)
+    for _, d := range duplicates {
+        log.Print(d)
+    }
+    if len(duplicates) > 0 {
+        os.Exit(1)
+    }
+}
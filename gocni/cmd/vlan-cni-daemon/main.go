@@ -0,0 +1,56 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "log"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "example.com/vlan-cni/pkg/daemon"
+    "example.com/vlan-cni/pkg/features"
+)
+
+func main() {
+    configPath := flag.String("config", daemon.DefaultConfigPath, "path to the daemon configuration file")
+    maintenance := flag.Bool("maintenance", false, "fail new ADDs with ErrTryAgainLater while serving DELs")
+    featureGates := flag.String("feature-gates", "", "feature gates to turn on or off over the configuration, as in BGP=false,LinkCache=true")
+    flag.Parse()
+    
+    conf, err := daemon.LoadConfig(*configPath)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if *maintenance {
+        if conf.Maintenance == nil {
+            conf.Maintenance = &daemon.MaintenanceConfig{}
+        }
+        conf.Maintenance.Enabled = true
+    }
+    if *featureGates != "" {
+        overrides, err := features.ParseFlag(*featureGates)
+        if err != nil {
+            log.Fatal(err)
+        }
+        if conf.FeatureGates == nil {
+            conf.FeatureGates = map[string]bool{}
+        }
+        for name, on := range overrides {
+            conf.FeatureGates[name] = on
+        }
+    }
+    
+    d, err := daemon.New(conf)
+    if err != nil {
+        log.Fatal(err)
+    }
+    
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    
+    log.Printf("vlan-cni daemon starting on node %s", conf.NodeName)
+    if err := d.Run(ctx); err != nil {
+        log.Fatal(err)
+    }
+}
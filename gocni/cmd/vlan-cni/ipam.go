@@ -0,0 +1,213 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "time"
+
+    "example.com/vlan-cni/pkg/daemon"
+    vlanipam "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/ipam/upstream"
+    "example.com/vlan-cni/pkg/kube"
+    "example.com/vlan-cni/pkg/state"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+const ipamUsage = `usage: vlan-cni ipam export [-from vlan-cni|host-local|whereabouts] [flags]
+       vlan-cni ipam import [-dry-run] [flags] FILE`
+
+// ipamCommand dumps and restores a network's allocations, for backups,
+// node replacement and migrations from host-local or whereabouts
+func ipamCommand(args []string) {
+    if len(args) == 0 {
+        fmt.Fprintln(os.Stderr, ipamUsage)
+        os.Exit(2)
+    }
+    var err error
+    switch args[0] {
+    case "export":
+        err = ipamExport(args[1:])
+    case "import":
+        err = ipamImport(args[1:])
+    default:
+        fmt.Fprintln(os.Stderr, ipamUsage)
+        os.Exit(2)
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
+
+func ipamExport(args []string) error {
+    fs := flag.NewFlagSet("ipam export", flag.ExitOnError)
+    from := fs.String("from", vlanv1.SourceVlanCNI, "allocations to export: vlan-cni, host-local or whereabouts")
+    confFile := fs.String("config", daemon.DefaultReadinessConfFile, "network configuration naming the network and its ipam")
+    ifName := fs.String("ifname", "", "attachment whose ipam to use, for networks with several")
+    network := fs.String("network", "", "network name, instead of the configuration's")
+    hostLocalDir := fs.String("host-local-dir", upstream.DefaultHostLocalDir, "host-local state directory")
+    kubeconfig := fs.String("kubeconfig", "", "kubeconfig for whereabouts, in-cluster when empty")
+    namespace := fs.String("namespace", "kube-system", "namespace of the whereabouts IPPools")
+    cidr := fs.String("range", "", "range of the whereabouts IPPool, all pools when empty")
+    out := fs.String("o", "-", "output file, - for stdout")
+    fs.Parse(args)
+    
+    ctx := context.Background()
+    list := &vlanv1.AllocationList{TypeMeta: vlanv1.Meta(vlanv1.KindAllocationList), Network: *network, Source: *from, Exported: time.Now().UTC()}
+    var err error
+    switch *from {
+    case vlanv1.SourceVlanCNI:
+        var porter vlanipam.Porter
+        var name string
+        name, porter, err = ipamPorter(*confFile, *ifName)
+        if err != nil {
+            return err
+        }
+        if list.Network == "" {
+            list.Network = name
+        }
+        list.Allocations, err = porter.Export(ctx, list.Network)
+    case vlanv1.SourceHostLocal:
+        if list.Network == "" {
+            if list.Network, err = networkName(*confFile); err != nil {
+                return fmt.Errorf("%v, set -network", err)
+            }
+        }
+        list.Allocations, err = upstream.ReadHostLocal(*hostLocalDir, list.Network)
+    case vlanv1.SourceWhereabouts:
+        dyn, kerr := kube.NewDynamicClient(*kubeconfig)
+        if kerr != nil {
+            return kerr
+        }
+        list.Allocations, err = upstream.ReadWhereabouts(ctx, dyn, *namespace, *cidr)
+    default:
+        return fmt.Errorf("unknown source %q", *from)
+    }
+    if err != nil {
+        return err
+    }
+    if list.Allocations == nil {
+        list.Allocations = []*vlanv1.Allocation{}
+    }
+    
+    data, err := json.MarshalIndent(list, "", "  ")
+    if err != nil {
+        return err
+    }
+    data = append(data, '\n')
+    if *out == "-" {
+        _, err = os.Stdout.Write(data)
+        return err
+    }
+    if err := ioutil.WriteFile(*out, data, 0600); err != nil {
+        return err
+    }
+    fmt.Fprintf(os.Stderr, "exported %d allocations of %s to %s\n", len(list.Allocations), list.Network, *out)
+    return nil
+}
+
+func ipamImport(args []string) error {
+    fs := flag.NewFlagSet("ipam import", flag.ExitOnError)
+    confFile := fs.String("config", daemon.DefaultReadinessConfFile, "network configuration naming the network and its ipam")
+    ifName := fs.String("ifname", "", "attachment whose ipam to use, for networks with several")
+    dryRun := fs.Bool("dry-run", false, "report conflicts without importing")
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        fmt.Fprintln(os.Stderr, ipamUsage)
+        os.Exit(2)
+    }
+    
+    var data []byte
+    var err error
+    if path := fs.Arg(0); path == "-" {
+        data, err = ioutil.ReadAll(os.Stdin)
+    } else {
+        data, err = ioutil.ReadFile(path)
+    }
+    if err != nil {
+        return err
+    }
+    list := &vlanv1.AllocationList{}
+    if err := json.Unmarshal(data, list); err != nil {
+        return fmt.Errorf("failed to parse %s: %v", fs.Arg(0), err)
+    }
+    if err := list.Check(vlanv1.KindAllocationList); err != nil {
+        return err
+    }
+    
+    network, porter, err := ipamPorter(*confFile, *ifName)
+    if err != nil {
+        return err
+    }
+    if list.Network != network {
+        fmt.Fprintf(os.Stderr, "importing allocations of %s into %s\n", list.Network, network)
+    }
+    imported, err := porter.Import(context.Background(), network, list.Allocations, *dryRun)
+    if conflicts, ok := err.(vlanipam.ImportConflicts); ok {
+        for _, c := range conflicts {
+            fmt.Fprintf(os.Stderr, "conflict: %s (%s/%s): %s\n", c.Allocation.IP, c.Allocation.ContainerID, c.Allocation.IfName, c.Reason)
+        }
+        return fmt.Errorf("%d conflicting allocations, nothing imported", len(conflicts))
+    }
+    if err != nil {
+        return err
+    }
+    
+    verb := "imported"
+    if *dryRun {
+        verb = "would import"
+    }
+    fmt.Fprintf(os.Stderr, "%s %d of %d allocations into %s, %d held already\n", verb, imported, len(list.Allocations), network, len(list.Allocations)-imported)
+    return nil
+}
+
+// ipamPorter builds the IPAM driver of the configuration's network
+func ipamPorter(confFile, ifName string) (string, vlanipam.Porter, error) {
+    data, err := ioutil.ReadFile(confFile)
+    if err != nil {
+        return "", nil, err
+    }
+    network, raw, err := vlanipam.Section(data, ifName)
+    if err != nil {
+        return "", nil, fmt.Errorf("%s: %v", confFile, err)
+    }
+    var section struct {
+        Type string `json:"type"`
+    }
+    if err := json.Unmarshal(raw, &section); err != nil {
+        return "", nil, err
+    }
+    store, err := state.NewStore(state.DefaultDir)
+    if err != nil {
+        return "", nil, err
+    }
+    
+    driver, err := vlanipam.New(section.Type, raw, store)
+    if err != nil {
+        return "", nil, err
+    }
+    porter, ok := driver.(vlanipam.Porter)
+    if !ok {
+        return "", nil, fmt.Errorf("ipam type %q cannot export or import allocations", section.Type)
+    }
+    return network, porter, nil
+}
+
+// networkName returns the name of the configuration's network
+func networkName(confFile string) (string, error) {
+    data, err := ioutil.ReadFile(confFile)
+    if err != nil {
+        return "", err
+    }
+    var doc struct {
+        Name string `json:"name"`
+    }
+    if err := json.Unmarshal(data, &doc); err != nil || doc.Name == "" {
+        return "", fmt.Errorf("%s names no network", confFile)
+    }
+    return doc.Name, nil
+}
@@ -1,51 +1,195 @@
 package main
 
 import (
+    "context"
     "encoding/json"
+    "flag"
     "fmt"
+    "io/ioutil"
     "os"
+    "time"
 
     "github.com/containernetworking/cni/pkg/skel"
     "github.com/containernetworking/cni/pkg/types"
     current "github.com/containernetworking/cni/pkg/types/100"
     "github.com/containernetworking/cni/pkg/version"
-    
+
+    "example.com/vlan-cni/pkg/caps"
+    "example.com/vlan-cni/pkg/daemon"
     "example.com/vlan-cni/pkg/plugin"
+    "example.com/vlan-cni/pkg/privsep"
     "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+    "example.com/vlan-cni/pkg/support"
 )
 
 func main() {
+    if privsep.IsWorker() {
+        if err := privsep.Serve(plugin.WorkerHandlers()); err != nil {
+            fmt.Fprintf(os.Stderr, "level=error msg=%q error=%q\n", "privsep worker failed", err)
+            os.Exit(1)
+        }
+        return
+    }
+    
+    // Best effort, the plugin works with the runtime's full set as well
+    if err := caps.Minimize(); err != nil {
+        fmt.Fprintf(os.Stderr, "level=warn msg=%q error=%q\n", "running with full capabilities", err)
+    }
+    
+    // Runtimes pass no arguments, so these are administrators
+    if len(os.Args) > 1 && os.Args[1] == "support-bundle" {
+        supportBundle(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "ipam" {
+        ipamCommand(os.Args[2:])
+        return
+    }
+    
+    // Also best effort: without the worker, parsing and IPAM run here
+    worker, err := privsep.Start()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "level=warn msg=%q error=%q\n", "running without privsep worker", err)
+    }
+    if worker != nil {
+        plugin.UseWorker(worker)
+        defer worker.Close()
+    }
+    
+    // STATUS is newer than the CNI library's dispatcher
+    if os.Getenv("CNI_COMMAND") == "STATUS" {
+        if err := cmdStatus(); err != nil {
+            e, ok := err.(*types.Error)
+            if !ok {
+                e = types.NewError(types.ErrInternal, err.Error(), "")
+            }
+            e.Print()
+            if worker != nil {
+                worker.Close()
+            }
+            os.Exit(1)
+        }
+        return
+    }
+    
     skel.PluginMain(cmdAdd, cmdCheck, cmdDel, version.All, "VLAN CNI plugin v0.1.0")
 }
 
-func cmdAdd(args *skel.CmdArgs) error {
-    conf, err := config.ParseConfig(args.StdinData)
+func cmdAdd(args *skel.CmdArgs) (err error) {
+    defer plugin.RecoverPanic(args.StdinData, &err)
+    
+    conf, err := parseConfig(args)
+    if err != nil {
+        return err
+    }
+    for _, msg := range []string{conf.VersionWarning(), conf.CompatWarning()} {
+        if msg != "" {
+            fmt.Fprintf(os.Stderr, "level=warn msg=%q\n", msg)
+        }
+    }
+    
+    var result *current.Result
+    err = plugin.WithDeadline(conf, func(ctx context.Context) (err error) {
+        result, err = plugin.AddVlanNetwork(ctx, args, conf)
+        return err
+    })
     if err != nil {
         return err
     }
     
-    result, err := plugin.AddVlanNetwork(args, conf)
+    return plugin.PrintResult(result, conf)
+}
+
+func cmdDel(args *skel.CmdArgs) (err error) {
+    defer plugin.RecoverPanic(args.StdinData, &err)
+    
+    conf, err := parseConfig(args)
     if err != nil {
         return err
     }
     
-    return types.PrintResult(result, conf.CNIVersion)
+    return plugin.WithDeadline(conf, func(ctx context.Context) error {
+        return plugin.DelVlanNetwork(ctx, args, conf)
+    })
 }
 
-func cmdDel(args *skel.CmdArgs) error {
-    conf, err := config.ParseConfig(args.StdinData)
+func cmdCheck(args *skel.CmdArgs) (err error) {
+    defer plugin.RecoverPanic(args.StdinData, &err)
+    
+    conf, err := parseConfig(args)
     if err != nil {
         return err
     }
     
-    return plugin.DelVlanNetwork(args, conf)
+    return plugin.WithDeadline(conf, func(ctx context.Context) error {
+        return plugin.CheckVlanNetwork(ctx, args, conf)
+    })
 }
 
-func cmdCheck(args *skel.CmdArgs) error {
-    conf, err := config.ParseConfig(args.StdinData)
+// cmdStatus reports whether ADDs can work on this node. It prints nothing
+// when they can.
+func cmdStatus() (err error) {
+    stdin, err := ioutil.ReadAll(os.Stdin)
+    if err != nil {
+        return fmt.Errorf("failed to read network configuration: %v", err)
+    }
+    defer plugin.RecoverPanic(stdin, &err)
+    
+    conf, err := parseConfig(&skel.CmdArgs{StdinData: stdin})
     if err != nil {
         return err
     }
+    return plugin.StatusVlanNetwork(conf)
+}
+
+// supportBundle writes a tarball for issue triage, see pkg/support
+func supportBundle(args []string) {
+    hostname, _ := os.Hostname()
+    fs := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+    out := fs.String("o", fmt.Sprintf("vlan-cni-support-%s-%s.tar.gz", hostname, time.Now().Format("20060102-150405")), "output file, - for stdout")
+    confDir := fs.String("conf-dir", "/etc/cni/net.d", "CNI network configuration directory")
+    stateDir := fs.String("state-dir", state.DefaultDir, "plugin state directory")
+    logFile := fs.String("log-file", config.DefaultLogFile, "plugin log file")
+    daemonConfig := fs.String("daemon-config", daemon.DefaultConfigPath, "daemon configuration file")
+    fs.Parse(args)
     
-    return plugin.CheckVlanNetwork(args, conf)
-}
\ No newline at end of file
+    w := os.Stdout
+    if *out != "-" {
+        f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        defer f.Close()
+        w = f
+    }
+    
+    opts := support.Options{
+        ConfDir:      *confDir,
+        StateDir:     *stateDir,
+        LogFiles:     []string{*logFile},
+        DaemonConfig: *daemonConfig,
+    }
+    if err := support.Collect(context.Background(), opts, w); err != nil {
+        fmt.Fprintf(os.Stderr, "failed to write support bundle: %v\n", err)
+        os.Exit(1)
+    }
+    if *out != "-" {
+        fmt.Fprintf(os.Stderr, "wrote %s\n", *out)
+    }
+}
+
+// parseConfig parses the network configuration, rendering node templates,
+// and hands its decrypted form to everything downstream that reads stdin,
+// such as IPAM plugins
+func parseConfig(args *skel.CmdArgs) (*config.NetConf, error) {
+    conf, err := plugin.ParseConfig(context.Background(), args.StdinData)
+    if err != nil {
+        return nil, err
+    }
+    if plain := conf.Decrypted(); plain != nil {
+        args.StdinData = plain
+    }
+    return conf, nil
+}
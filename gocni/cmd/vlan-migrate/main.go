@@ -0,0 +1,120 @@
+// vlan-migrate moves a node's networks from the upstream vlan and macvlan
+// plugins to vlan-cni without restarting its pods. Install the vlan-cni
+// binary next to the upstream ones first.
+//
+//   vlan-migrate [-conf-dir DIR] [-cache-dir DIR] [-state-dir DIR] [-apply]
+//
+// For each upstream network it prints the conversion and checks that the
+// pods the runtime has results for are still attached. With -apply it then
+// rewrites the configuration files, keeping the originals in -backup-dir,
+// records the pods in the state directory for DEL and CHECK, and checks
+// them again. Addresses stay with the network's IPAM; host-local keeps its
+// files under the same network name.
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+
+    "example.com/vlan-cni/pkg/adopt"
+    "example.com/vlan-cni/pkg/state"
+)
+
+func main() {
+    log.SetFlags(0)
+    confDir := flag.String("conf-dir", "/etc/cni/net.d", "CNI network configuration directory")
+    cacheDir := flag.String("cache-dir", adopt.DefaultCacheDir, "libcni result cache")
+    stateDir := flag.String("state-dir", state.DefaultDir, "vlan-cni state directory")
+    binDir := flag.String("bin-dir", "/opt/cni/bin", "plugin directory vlan-cni is installed in")
+    backupDir := flag.String("backup-dir", "/var/lib/vlan-cni/upstream-backup", "where the original files are kept")
+    apply := flag.Bool("apply", false, "convert the networks rather than only report")
+    flag.Parse()
+    
+    networks, err := adopt.Find(*confDir)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if len(networks) == 0 {
+        log.Printf("no vlan or macvlan networks in %s", *confDir)
+        return
+    }
+    if _, err := os.Stat(filepath.Join(*binDir, "vlan-cni")); err != nil && *apply {
+        log.Fatalf("vlan-cni is not installed in %s", *binDir)
+    }
+    store, err := state.NewStore(*stateDir)
+    if err != nil {
+        log.Fatal(err)
+    }
+    
+    failed := false
+    for _, n := range networks {
+        if !migrate(n, store, *cacheDir, *backupDir, *apply) {
+            failed = true
+        }
+    }
+    if failed {
+        os.Exit(1)
+    }
+}
+
+// migrate reports on and, with apply, converts one network. It returns
+// false when the network cannot be converted or a pod is not attached.
+func migrate(n *adopt.Network, store *state.Store, cacheDir, backupDir string, apply bool) bool {
+    log.Printf("%s: %s network %q on %s, vlan-cni master %s vlan %d", n.File, n.Type, n.Name, n.UpstreamMaster, n.Master, n.VlanID)
+    for _, p := range n.Problems {
+        log.Printf("  problem: %s", p)
+    }
+    if len(n.Problems) > 0 {
+        return false
+    }
+    
+    attachments, warnings, err := n.Attachments(cacheDir)
+    if err != nil {
+        log.Printf("  %v", err)
+        return false
+    }
+    for _, w := range warnings {
+        log.Printf("  warning: %s", w)
+    }
+    ok := true
+    for _, a := range attachments {
+        if err := n.Verify(a); err != nil {
+            log.Printf("  pod %s/%s %s: %v", a.PodNamespace, a.PodName, a.IfName, err)
+            ok = false
+            continue
+        }
+        log.Printf("  pod %s/%s %s: attached", a.PodNamespace, a.PodName, a.IfName)
+    }
+    if !apply {
+        fmt.Printf("%s", n.Converted)
+        return ok
+    }
+    if !ok {
+        log.Printf("  not converted, pods are detached")
+        return false
+    }
+    
+    if err := n.Apply(backupDir); err != nil {
+        log.Printf("  %v", err)
+        return false
+    }
+    for _, a := range attachments {
+        if err := store.SaveAttachment(a); err != nil {
+            log.Printf("  %v", err)
+            return false
+        }
+        recorded, err := store.GetAttachment(a.ContainerID, a.IfName)
+        if err == nil && recorded != nil {
+            err = n.Verify(recorded)
+        }
+        if err != nil {
+            log.Printf("  pod %s/%s %s: not attached after conversion: %v", a.PodNamespace, a.PodName, a.IfName, err)
+            ok = false
+        }
+    }
+    log.Printf("  converted, %d pods adopted, original in %s", len(attachments), backupDir)
+    return ok
+}
@@ -3,9 +3,17 @@ module example.com/vlan-cni
 go 1.20
 
 require (
+	filippo.io/age v1.1.1
 	github.com/containernetworking/cni v1.1.2
 	github.com/containernetworking/plugins v1.2.0
+	github.com/miekg/dns v1.1.55
+	github.com/osrg/gobgp/v3 v3.17.0
 	github.com/vishvananda/netlink v1.2.1-beta.2
+	google.golang.org/grpc v1.53.0
+	google.golang.org/protobuf v1.30.0
+	k8s.io/api v0.27.4
+	k8s.io/apimachinery v0.27.4
+	k8s.io/client-go v0.27.4
 )
 
 require (
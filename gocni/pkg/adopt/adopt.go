@@ -0,0 +1,210 @@
+// Package adopt takes over networks of the upstream vlan and macvlan
+// plugins on a running node. Their configuration files are rewritten for
+// this plugin, and the pods they set up get attachment records, read from
+// the runtime's result cache and host-local's state, so that their DEL and
+// CHECK find what to tear down.
+package adopt
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/ipam/upstream"
+)
+
+// Upstream plugin types and ours
+const (
+    TypeVlan    = "vlan"
+    TypeMacvlan = "macvlan"
+    pluginType  = "vlan-cni"
+)
+
+// typeKey is a configuration key of an upstream plugin type
+type typeKey struct {
+    typ, key string
+}
+
+// dropped are upstream keys this plugin has no use for
+var dropped = map[typeKey]bool{
+    {TypeVlan, "vlanId"}:  true,
+    {TypeMacvlan, "mode"}: true,
+}
+
+// Network is an upstream network defined in a configuration file
+type Network struct {
+    File string
+    Name string
+    Type string
+
+    // UpstreamMaster is the master of the upstream entry
+    UpstreamMaster string
+
+    // Master and VlanID are this plugin's, VLAN 0 for macvlan networks,
+    // which keep their masters
+    Master string
+    VlanID int
+
+    // The master is in the pods' namespaces
+    LinkInContainer bool
+
+    // HostLocalDir is host-local's state directory when the network
+    // allocates with it
+    HostLocalDir string
+
+    // Converted is the file with the upstream entry replaced by this
+    // plugin's
+    Converted []byte
+
+    // Problems keep the network from being converted
+    Problems []string
+}
+
+// Find reads the upstream networks of the conf and conflist files in dir
+func Find(dir string) ([]*Network, error) {
+    var paths []string
+    for _, pattern := range []string{"*.conf", "*.conflist"} {
+        matches, err := filepath.Glob(filepath.Join(dir, pattern))
+        if err != nil {
+            return nil, err
+        }
+        paths = append(paths, matches...)
+    }
+    sort.Strings(paths)
+    
+    var networks []*Network
+    for _, path := range paths {
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read %s: %v", path, err)
+        }
+        n, err := Convert(path, data)
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+        }
+        if n != nil {
+            networks = append(networks, n)
+        }
+    }
+    return networks, nil
+}
+
+// Convert replaces the upstream entry of a conf or conflist document with
+// this plugin's. It returns nil when the document has no upstream entry.
+func Convert(path string, data []byte) (*Network, error) {
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, err
+    }
+    plugins := []interface{}{doc}
+    if list, ok := doc["plugins"].([]interface{}); ok {
+        plugins = list
+    }
+    var entry map[string]interface{}
+    for _, p := range plugins {
+        m, ok := p.(map[string]interface{})
+        if ok && (m["type"] == TypeVlan || m["type"] == TypeMacvlan) {
+            entry = m
+            break
+        }
+    }
+    if entry == nil {
+        return nil, nil
+    }
+    
+    n := &Network{File: path, Type: entry["type"].(string)}
+    n.Name, _ = doc["name"].(string)
+    n.UpstreamMaster, _ = entry["master"].(string)
+    if n.UpstreamMaster == "" {
+        n.Problems = append(n.Problems, "no master; the upstream default route interface cannot be adopted")
+    }
+    switch n.Type {
+    case TypeVlan:
+        id, _ := entry["vlanId"].(float64)
+        n.Master, n.VlanID = n.UpstreamMaster, int(id)
+        n.LinkInContainer, _ = entry["linkInContainer"].(bool)
+    case TypeMacvlan:
+        // The upstream master keeps its VLAN device, the kernel allowing
+        // one per VLAN ID, so pods stay macvlans of it
+        n.Master = n.UpstreamMaster
+        n.LinkInContainer, _ = entry["linkInContainer"].(bool)
+        entry["untaggedMode"] = config.UntaggedModeMacvlan
+        switch mode, _ := entry["mode"].(string); mode {
+        case "", "bridge":
+        case "private":
+            entry["isolated"] = true
+        default:
+            n.Problems = append(n.Problems, fmt.Sprintf("macvlan mode %s isolates pods differently than this plugin does", mode))
+        }
+    }
+    if ipam, ok := entry["ipam"].(map[string]interface{}); ok && ipam["type"] == "host-local" {
+        n.HostLocalDir, _ = ipam["dataDir"].(string)
+        if n.HostLocalDir == "" {
+            n.HostLocalDir = upstream.DefaultHostLocalDir
+        }
+    }
+    
+    for key := range entry {
+        if dropped[typeKey{n.Type, key}] {
+            delete(entry, key)
+        }
+    }
+    entry["type"] = pluginType
+    entry["master"] = n.Master
+    entry["vlan"] = n.VlanID
+    
+    // The runtime passes plugins their network's name and version
+    plugin := map[string]interface{}{}
+    for k, v := range entry {
+        plugin[k] = v
+    }
+    plugin["name"] = n.Name
+    if _, ok := plugin["cniVersion"]; !ok {
+        plugin["cniVersion"] = doc["cniVersion"]
+    }
+    raw, _ := json.Marshal(plugin)
+    if _, err := config.ParseConfig(raw); err != nil && len(n.Problems) == 0 {
+        n.Problems = append(n.Problems, err.Error())
+    }
+    
+    out, err := json.MarshalIndent(doc, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    n.Converted = append(out, '\n')
+    return n, nil
+}
+
+// Apply replaces the network's file with its converted form, keeping the
+// original in backupDir
+func (n *Network) Apply(backupDir string) error {
+    if len(n.Problems) > 0 {
+        return fmt.Errorf("%s cannot be converted", n.File)
+    }
+    original, err := ioutil.ReadFile(n.File)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(backupDir, 0700); err != nil {
+        return fmt.Errorf("failed to create %s: %v", backupDir, err)
+    }
+    backup := filepath.Join(backupDir, filepath.Base(n.File))
+    if err := ioutil.WriteFile(backup, original, 0600); err != nil {
+        return fmt.Errorf("failed to back up %s: %v", n.File, err)
+    }
+    
+    // Rename so the runtime never reads a partly written file
+    tmp := n.File + ".tmp"
+    if err := ioutil.WriteFile(tmp, n.Converted, 0644); err != nil {
+        return fmt.Errorf("failed to write %s: %v", n.File, err)
+    }
+    if err := os.Rename(tmp, n.File); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("failed to replace %s: %v", n.File, err)
+    }
+    return nil
+}
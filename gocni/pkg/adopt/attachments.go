@@ -0,0 +1,115 @@
+package adopt
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "time"
+
+    "example.com/vlan-cni/pkg/cri"
+    "example.com/vlan-cni/pkg/ipam/upstream"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// DefaultCacheDir is where libcni caches the results of ADDs
+const DefaultCacheDir = "/var/lib/cni/results"
+
+// cachedResult is the part of libcni's cniCacheV1 documents read here
+type cachedResult struct {
+    ContainerID string      `json:"containerId"`
+    IfName      string      `json:"ifName"`
+    NetworkName string      `json:"networkName"`
+    CniArgs     [][2]string `json:"cniArgs"`
+    Result      struct {
+        Interfaces []struct {
+            Name    string `json:"name"`
+            Sandbox string `json:"sandbox"`
+        } `json:"interfaces"`
+        IPs []struct {
+            Address string `json:"address"`
+        } `json:"ips"`
+    } `json:"result"`
+}
+
+// Attachments returns records of the network's pods, one per result the
+// runtime cached. host-local allocations of containers without one are
+// returned as warnings: their pods cannot be found, though DEL still
+// releases them.
+func (n *Network) Attachments(cacheDir string) ([]*state.Attachment, []string, error) {
+    if cacheDir == "" {
+        cacheDir = DefaultCacheDir
+    }
+    paths, err := filepath.Glob(filepath.Join(cacheDir, n.Name+"-*"))
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    var attachments []*state.Attachment
+    cached := map[string]bool{}
+    for _, path := range paths {
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to read %s: %v", path, err)
+        }
+        r := &cachedResult{}
+        if err := json.Unmarshal(data, r); err != nil {
+            return nil, nil, fmt.Errorf("failed to parse %s: %v", path, err)
+        }
+        // Names of other networks may share the prefix
+        if r.NetworkName != n.Name {
+            continue
+        }
+        attachments = append(attachments, n.attachment(r))
+        cached[r.ContainerID+"/"+r.IfName] = true
+    }
+    
+    var warnings []string
+    if n.HostLocalDir == "" {
+        return attachments, warnings, nil
+    }
+    if _, err := os.Stat(filepath.Join(n.HostLocalDir, n.Name)); os.IsNotExist(err) {
+        return attachments, warnings, nil
+    }
+    allocations, err := upstream.ReadHostLocal(n.HostLocalDir, n.Name)
+    if err != nil {
+        return nil, nil, err
+    }
+    for _, a := range allocations {
+        if !cached[a.ContainerID+"/"+a.IfName] {
+            warnings = append(warnings, fmt.Sprintf("%s is allocated to container %s without a cached result", a.IP, a.ContainerID))
+        }
+    }
+    return attachments, warnings, nil
+}
+
+func (n *Network) attachment(r *cachedResult) *state.Attachment {
+    a := &state.Attachment{
+        ContainerID: r.ContainerID,
+        IfName:      r.IfName,
+        Network:     n.Name,
+        Master:      n.Master,
+        VlanID:      n.VlanID,
+        Created:     time.Now().UTC(),
+    }
+    for _, iface := range r.Result.Interfaces {
+        if iface.Name == r.IfName && iface.Sandbox != "" {
+            a.Netns = cri.Netns(iface.Sandbox)
+        }
+    }
+    for _, ip := range r.Result.IPs {
+        a.IPs = append(a.IPs, ip.Address)
+    }
+    for _, arg := range r.CniArgs {
+        switch arg[0] {
+        case "K8S_POD_NAME":
+            a.PodName = arg[1]
+        case "K8S_POD_NAMESPACE":
+            a.PodNamespace = arg[1]
+        case "K8S_POD_UID":
+            a.PodUID = arg[1]
+        }
+    }
+    return a
+}
@@ -0,0 +1,81 @@
+package adopt
+
+import (
+    "fmt"
+    "net"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Verify checks that the pod still has the attachment's interface, on the
+// network's VLAN and holding its addresses
+func (n *Network) Verify(a *state.Attachment) error {
+    if a.Netns == "" {
+        return fmt.Errorf("the cached result names no network namespace")
+    }
+    var master netlink.Link
+    var err error
+    if !n.LinkInContainer {
+        master, err = netlink.LinkByName(n.UpstreamMaster)
+        if err != nil {
+            return fmt.Errorf("failed to lookup master %q: %v", n.UpstreamMaster, err)
+        }
+    }
+    
+    return ns.WithNetNSPath(a.Netns, func(ns.NetNS) error {
+        if n.LinkInContainer {
+            m, err := netlink.LinkByName(n.UpstreamMaster)
+            if err != nil {
+                return fmt.Errorf("failed to lookup master %q in %s: %v", n.UpstreamMaster, a.Netns, err)
+            }
+            master = m
+        }
+        link, err := netlink.LinkByName(a.IfName)
+        if err != nil {
+            return fmt.Errorf("failed to lookup %q in %s: %v", a.IfName, a.Netns, err)
+        }
+        switch l := link.(type) {
+        case *netlink.Vlan:
+            if n.Type != TypeVlan || l.VlanId != a.VlanID {
+                return fmt.Errorf("%q is VLAN %d, not VLAN %d", a.IfName, l.VlanId, a.VlanID)
+            }
+        case *netlink.Macvlan:
+            if n.Type != TypeMacvlan {
+                return fmt.Errorf("%q is a macvlan, not a VLAN", a.IfName)
+            }
+        default:
+            return fmt.Errorf("%q is a %s, not a %s", a.IfName, link.Type(), n.Type)
+        }
+        // The parent is an index of the master's namespace
+        if link.Attrs().ParentIndex != master.Attrs().Index {
+            return fmt.Errorf("%q is not on %q", a.IfName, n.UpstreamMaster)
+        }
+        
+        addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+        if err != nil {
+            return fmt.Errorf("failed to list addresses of %q: %v", a.IfName, err)
+        }
+        for _, ip := range a.IPs {
+            want, _, err := net.ParseCIDR(ip)
+            if err != nil {
+                return fmt.Errorf("invalid cached address %q", ip)
+            }
+            if !hasAddr(addrs, want) {
+                return fmt.Errorf("%q lost %s", a.IfName, ip)
+            }
+        }
+        return nil
+    })
+}
+
+func hasAddr(addrs []netlink.Addr, ip net.IP) bool {
+    for _, addr := range addrs {
+        if addr.IP.Equal(ip) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,80 @@
+package arp
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+    "syscall"
+)
+
+const (
+    ethPArp    = 0x0806
+    arpReply   = 2
+    hwEthernet = 1
+    protoIPv4  = 0x0800
+)
+
+var broadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// SendGratuitous broadcasts a gratuitous ARP reply for ip out of the named
+// interface so neighbours and switches update their caches. IPv6 addresses
+// are ignored; the kernel announces those itself.
+func SendGratuitous(ifName string, ip net.IP) error {
+    ip4 := ip.To4()
+    if ip4 == nil {
+        return nil
+    }
+    
+    iface, err := net.InterfaceByName(ifName)
+    if err != nil {
+        return fmt.Errorf("failed to lookup interface %q: %v", ifName, err)
+    }
+    if len(iface.HardwareAddr) != 6 {
+        return fmt.Errorf("interface %q has no ethernet address", ifName)
+    }
+    
+    fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPArp)))
+    if err != nil {
+        return fmt.Errorf("failed to open packet socket: %v", err)
+    }
+    defer syscall.Close(fd)
+    
+    addr := &syscall.SockaddrLinklayer{
+        Protocol: htons(ethPArp),
+        Ifindex:  iface.Index,
+        Halen:    6,
+    }
+    copy(addr.Addr[:], broadcast)
+    
+    if err := syscall.Sendto(fd, packet(iface.HardwareAddr, ip4), 0, addr); err != nil {
+        return fmt.Errorf("failed to send gratuitous ARP for %s on %q: %v", ip4, ifName, err)
+    }
+    return nil
+}
+
+// packet builds an ethernet frame carrying an ARP reply announcing ip
+func packet(mac net.HardwareAddr, ip net.IP) []byte {
+    buf := make([]byte, 42)
+    
+    // Ethernet header
+    copy(buf[0:6], broadcast)
+    copy(buf[6:12], mac)
+    binary.BigEndian.PutUint16(buf[12:14], ethPArp)
+    
+    // ARP payload, sender and target both carry the announced address
+    binary.BigEndian.PutUint16(buf[14:16], hwEthernet)
+    binary.BigEndian.PutUint16(buf[16:18], protoIPv4)
+    buf[18] = 6
+    buf[19] = 4
+    binary.BigEndian.PutUint16(buf[20:22], arpReply)
+    copy(buf[22:28], mac)
+    copy(buf[28:32], ip)
+    copy(buf[32:38], broadcast)
+    copy(buf[38:42], ip)
+    
+    return buf
+}
+
+func htons(v uint16) uint16 {
+    return v<<8 | v>>8
+}
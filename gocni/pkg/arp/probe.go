@@ -0,0 +1,102 @@
+package arp
+
+import (
+    "context"
+    "encoding/binary"
+    "fmt"
+    "net"
+    "syscall"
+    "time"
+)
+
+const arpRequest = 1
+
+// Probe sends ARP probes for target out of the named interface every
+// interval until target answers or ctx is done. Probes carry no sender
+// address, as in RFC 5227, so they work before the interface has one.
+func Probe(ctx context.Context, ifName string, target net.IP, interval time.Duration) error {
+    target4 := target.To4()
+    if target4 == nil {
+        return fmt.Errorf("cannot ARP for %s", target)
+    }
+    
+    iface, err := net.InterfaceByName(ifName)
+    if err != nil {
+        return fmt.Errorf("failed to lookup interface %q: %v", ifName, err)
+    }
+    if len(iface.HardwareAddr) != 6 {
+        return fmt.Errorf("interface %q has no ethernet address", ifName)
+    }
+    
+    fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPArp)))
+    if err != nil {
+        return fmt.Errorf("failed to open packet socket: %v", err)
+    }
+    defer syscall.Close(fd)
+    
+    addr := &syscall.SockaddrLinklayer{
+        Protocol: htons(ethPArp),
+        Ifindex:  iface.Index,
+        Halen:    6,
+    }
+    copy(addr.Addr[:], broadcast)
+    if err := syscall.Bind(fd, addr); err != nil {
+        return fmt.Errorf("failed to bind packet socket to %q: %v", ifName, err)
+    }
+    tv := syscall.NsecToTimeval(interval.Nanoseconds())
+    if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+        return fmt.Errorf("failed to set receive timeout: %v", err)
+    }
+    
+    probe := requestPacket(iface.HardwareAddr, target4)
+    buf := make([]byte, 1500)
+    for {
+        if err := ctx.Err(); err != nil {
+            return fmt.Errorf("no ARP reply from %s on %q: %v", target4, ifName, err)
+        }
+        if err := syscall.Sendto(fd, probe, 0, addr); err != nil {
+            return fmt.Errorf("failed to send ARP probe for %s on %q: %v", target4, ifName, err)
+        }
+        
+        // Read until the interval passes without a reply
+        deadline := time.Now().Add(interval)
+        for time.Now().Before(deadline) {
+            n, _, err := syscall.Recvfrom(fd, buf, 0)
+            if err != nil {
+                break
+            }
+            if isReplyFrom(buf[:n], target4) {
+                return nil
+            }
+        }
+    }
+}
+
+// requestPacket builds an ethernet frame carrying an ARP probe for target
+func requestPacket(mac net.HardwareAddr, target net.IP) []byte {
+    buf := make([]byte, 42)
+    
+    // Ethernet header
+    copy(buf[0:6], broadcast)
+    copy(buf[6:12], mac)
+    binary.BigEndian.PutUint16(buf[12:14], ethPArp)
+    
+    // ARP payload, the sender address stays zero and the target MAC unknown
+    binary.BigEndian.PutUint16(buf[14:16], hwEthernet)
+    binary.BigEndian.PutUint16(buf[16:18], protoIPv4)
+    buf[18] = 6
+    buf[19] = 4
+    binary.BigEndian.PutUint16(buf[20:22], arpRequest)
+    copy(buf[22:28], mac)
+    copy(buf[38:42], target)
+    
+    return buf
+}
+
+// isReplyFrom reports whether frame is an ARP reply sent by ip
+func isReplyFrom(frame []byte, ip net.IP) bool {
+    if len(frame) < 42 || binary.BigEndian.Uint16(frame[12:14]) != ethPArp {
+        return false
+    }
+    return binary.BigEndian.Uint16(frame[20:22]) == arpReply && net.IP(frame[28:32]).Equal(ip)
+}
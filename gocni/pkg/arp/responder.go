@@ -0,0 +1,148 @@
+package arp
+
+import (
+    "context"
+    "encoding/binary"
+    "fmt"
+    "net"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// Responder answers ARP requests for a set of addresses that are not
+// assigned to its interface, as the L2 mode of load balancers does, so
+// the addresses are announced without the kernel taking them as local
+type Responder struct {
+    ifName string
+    mac    net.HardwareAddr
+    fd     int
+
+    mu    sync.Mutex
+    addrs map[string]bool
+}
+
+// NewResponder opens a packet socket for ARP on the named interface
+func NewResponder(ifName string) (*Responder, error) {
+    iface, err := net.InterfaceByName(ifName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to lookup interface %q: %v", ifName, err)
+    }
+    if len(iface.HardwareAddr) != 6 {
+        return nil, fmt.Errorf("interface %q has no ethernet address", ifName)
+    }
+    
+    fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPArp)))
+    if err != nil {
+        return nil, fmt.Errorf("failed to open packet socket: %v", err)
+    }
+    addr := &syscall.SockaddrLinklayer{Protocol: htons(ethPArp), Ifindex: iface.Index}
+    if err := syscall.Bind(fd, addr); err != nil {
+        syscall.Close(fd)
+        return nil, fmt.Errorf("failed to bind packet socket to %q: %v", ifName, err)
+    }
+    // Wake up regularly to notice ctx being done
+    tv := syscall.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+    if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+        syscall.Close(fd)
+        return nil, fmt.Errorf("failed to set receive timeout: %v", err)
+    }
+    return &Responder{ifName: ifName, mac: iface.HardwareAddr, fd: fd, addrs: map[string]bool{}}, nil
+}
+
+// SetAddresses replaces the answered addresses, announcing the new ones
+// with gratuitous ARP. IPv6 addresses are ignored.
+func (r *Responder) SetAddresses(ips []net.IP) []net.IP {
+    addrs := map[string]bool{}
+    var added []net.IP
+    r.mu.Lock()
+    for _, ip := range ips {
+        ip4 := ip.To4()
+        if ip4 == nil {
+            continue
+        }
+        addrs[string(ip4)] = true
+        if !r.addrs[string(ip4)] {
+            added = append(added, ip4)
+        }
+    }
+    r.addrs = addrs
+    r.mu.Unlock()
+    
+    for _, ip := range added {
+        _ = SendGratuitous(r.ifName, ip)
+    }
+    return added
+}
+
+// Run answers requests until ctx is done, then closes the socket
+func (r *Responder) Run(ctx context.Context) error {
+    defer syscall.Close(r.fd)
+    
+    buf := make([]byte, 1500)
+    for ctx.Err() == nil {
+        n, from, err := syscall.Recvfrom(r.fd, buf, 0)
+        if err != nil {
+            if err == syscall.EAGAIN || err == syscall.EINTR {
+                continue
+            }
+            return fmt.Errorf("failed to read ARP on %q: %v", r.ifName, err)
+        }
+        sender, senderIP, target, ok := parseRequest(buf[:n])
+        if !ok {
+            continue
+        }
+        r.mu.Lock()
+        answer := r.addrs[string(target)]
+        r.mu.Unlock()
+        if !answer {
+            continue
+        }
+        
+        to, _ := from.(*syscall.SockaddrLinklayer)
+        if to == nil {
+            continue
+        }
+        reply := &syscall.SockaddrLinklayer{Protocol: htons(ethPArp), Ifindex: to.Ifindex, Halen: 6}
+        copy(reply.Addr[:], sender)
+        if err := syscall.Sendto(r.fd, replyPacket(r.mac, target, sender, senderIP), 0, reply); err != nil {
+            return fmt.Errorf("failed to answer ARP for %s on %q: %v", net.IP(target), r.ifName, err)
+        }
+    }
+    return nil
+}
+
+// parseRequest returns the sender and target of an ARP request
+func parseRequest(frame []byte) (sender net.HardwareAddr, senderIP, target net.IP, ok bool) {
+    if len(frame) < 42 || binary.BigEndian.Uint16(frame[12:14]) != ethPArp {
+        return nil, nil, nil, false
+    }
+    if binary.BigEndian.Uint16(frame[20:22]) != arpRequest {
+        return nil, nil, nil, false
+    }
+    sender = append(net.HardwareAddr{}, frame[22:28]...)
+    senderIP = append(net.IP{}, frame[28:32]...)
+    target = append(net.IP{}, frame[38:42]...)
+    return sender, senderIP, target, true
+}
+
+// replyPacket builds an ethernet frame answering who-has ip
+func replyPacket(mac net.HardwareAddr, ip net.IP, to net.HardwareAddr, toIP net.IP) []byte {
+    buf := make([]byte, 42)
+    
+    copy(buf[0:6], to)
+    copy(buf[6:12], mac)
+    binary.BigEndian.PutUint16(buf[12:14], ethPArp)
+    
+    binary.BigEndian.PutUint16(buf[14:16], hwEthernet)
+    binary.BigEndian.PutUint16(buf[16:18], protoIPv4)
+    buf[18] = 6
+    buf[19] = 4
+    binary.BigEndian.PutUint16(buf[20:22], arpReply)
+    copy(buf[22:28], mac)
+    copy(buf[28:32], ip)
+    copy(buf[32:38], to)
+    copy(buf[38:42], toIP)
+    
+    return buf
+}
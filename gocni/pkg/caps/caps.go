@@ -0,0 +1,162 @@
+// Package caps narrows the capabilities of the plugin process. The runtime
+// runs CNI plugins as root with every capability; the plugin only needs a
+// few of them to configure links and namespaces.
+package caps
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "runtime"
+    "strconv"
+    "strings"
+    "syscall"
+    "unsafe"
+)
+
+// Capabilities kept, see capability(7)
+const (
+    capDacOverride = 1
+    capSetpcap     = 8
+    capNetAdmin    = 12
+    capNetRaw      = 13
+    capSysModule   = 16
+    capSysAdmin    = 21
+)
+
+const (
+    prCapbsetDrop   = 24
+    prSetNoNewPrivs = 38
+    prCapAmbient    = 47
+
+    prCapAmbientClearAll = 4
+
+    linuxCapabilityVersion3 = 0x20080522
+)
+
+// envBounded marks a process that already runs with the reduced set. It is
+// inherited by IPAM and delegate plugins, so nested invocations of this
+// binary do not bound themselves again.
+const envBounded = "VLAN_CNI_CAPS_BOUNDED"
+
+// keep is what the plugin needs: NET_ADMIN for netlink and sysctls,
+// SYS_ADMIN to enter network namespaces, NET_RAW for ARP announcements,
+// SYS_MODULE to load link modules, DAC_OVERRIDE for state and IPAM files
+// owned by other users and SETPCAP to start the privsep worker with none
+var keep = map[int]bool{
+    capDacOverride: true,
+    capSetpcap:     true,
+    capNetAdmin:    true,
+    capNetRaw:      true,
+    capSysModule:   true,
+    capSysAdmin:    true,
+}
+
+// Minimize drops every other capability from the bounding set and
+// re-executes the binary, so the whole process, and every plugin or hook it
+// runs, starts with no more than the kept set. It also sets no_new_privs so
+// no setuid binary can win them back. Capabilities are per thread, which is
+// why a re-exec is needed rather than dropping them in place. It returns
+// without doing anything when not running as root or already bounded; on
+// success it does not return.
+func Minimize() error {
+    if os.Getenv(envBounded) != "" || os.Geteuid() != 0 {
+        return nil
+    }
+    
+    last, err := lastCap()
+    if err != nil {
+        return err
+    }
+    
+    // The bounding set changed below belongs to this thread, which is the
+    // one that execs
+    runtime.LockOSThread()
+    defer runtime.UnlockOSThread()
+    
+    if err := prctl(prSetNoNewPrivs, 1); err != nil {
+        return fmt.Errorf("failed to set no_new_privs: %v", err)
+    }
+    for c := 0; c <= last; c++ {
+        if keep[c] {
+            continue
+        }
+        if err := prctl(prCapbsetDrop, uintptr(c)); err != nil {
+            return fmt.Errorf("failed to drop capability %d: %v", c, err)
+        }
+    }
+    
+    exe, err := os.Executable()
+    if err != nil {
+        return fmt.Errorf("failed to find own executable: %v", err)
+    }
+    env := append(os.Environ(), envBounded+"=1")
+    if err := syscall.Exec(exe, os.Args, env); err != nil {
+        return fmt.Errorf("failed to re-exec %s: %v", exe, err)
+    }
+    return nil
+}
+
+// Clear empties the calling thread's bounding and inheritable sets and
+// sets no_new_privs, so whatever the thread execs runs with no
+// capabilities, even as root. The thread keeps its own. Capabilities are
+// per thread, so the caller locks the goroutine to its thread and lets it
+// exit locked, which retires the thread rather than leaving others to run
+// on it.
+func Clear() error {
+    last, err := lastCap()
+    if err != nil {
+        return err
+    }
+    if err := prctl(prSetNoNewPrivs, 1); err != nil {
+        return fmt.Errorf("failed to set no_new_privs: %v", err)
+    }
+    for c := 0; c <= last; c++ {
+        if err := prctl(prCapbsetDrop, uintptr(c)); err != nil {
+            return fmt.Errorf("failed to drop capability %d: %v", c, err)
+        }
+    }
+    if err := prctl(prCapAmbient, prCapAmbientClearAll); err != nil {
+        return fmt.Errorf("failed to clear ambient capabilities: %v", err)
+    }
+    
+    // Root's exec adds the inheritable set to the permitted one
+    hdr := capHeader{version: linuxCapabilityVersion3}
+    var data [2]capData
+    if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+        return fmt.Errorf("failed to get capabilities: %v", errno)
+    }
+    data[0].inheritable, data[1].inheritable = 0, 0
+    if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+        return fmt.Errorf("failed to clear inheritable capabilities: %v", errno)
+    }
+    return nil
+}
+
+// capHeader and capData are the kernel's capget(2) structures
+type capHeader struct {
+    version uint32
+    pid     int32
+}
+
+type capData struct {
+    effective   uint32
+    permitted   uint32
+    inheritable uint32
+}
+
+// lastCap returns the highest capability the kernel knows
+func lastCap() (int, error) {
+    data, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+    if err != nil {
+        return 0, fmt.Errorf("failed to read cap_last_cap: %v", err)
+    }
+    return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+func prctl(option, arg uintptr) error {
+    if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, option, arg, 0); errno != 0 {
+        return errno
+    }
+    return nil
+}
@@ -0,0 +1,161 @@
+// Package chain helps plugins that run in a chain check their share of the
+// result. CHECK hands every plugin the chain's final result, which holds
+// the interfaces, addresses and routes of all of them; each plugin should
+// verify only what it contributed.
+package chain
+
+import (
+    "fmt"
+    "net"
+    "strings"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/vishvananda/netlink"
+)
+
+// Contribution is what one plugin added to a chain's result
+type Contribution struct {
+    Interfaces []*current.Interface
+
+    // IPs of the interfaces, indexed like Interfaces through Interface
+    IPs []*current.IPConfig
+
+    // Routes whose gateway is reached through the interfaces' subnets
+    Routes []*net.IPNet
+}
+
+// Owned returns the contribution of the pod interfaces named names to
+// result. Plugins name the interfaces they create, so that is what tells
+// their share apart from the other plugins'.
+func Owned(result *current.Result, names ...string) *Contribution {
+    c := &Contribution{}
+    byIndex := map[int]int{}
+    for i, iface := range result.Interfaces {
+        if iface.Sandbox == "" {
+            continue
+        }
+        for _, name := range names {
+            if iface.Name == name {
+                byIndex[i] = len(c.Interfaces)
+                c.Interfaces = append(c.Interfaces, iface)
+            }
+        }
+    }
+    
+    var subnets []*net.IPNet
+    for _, ipc := range result.IPs {
+        if ipc.Interface == nil {
+            continue
+        }
+        j, ok := byIndex[*ipc.Interface]
+        if !ok {
+            continue
+        }
+        own := *ipc
+        own.Interface = current.Int(j)
+        c.IPs = append(c.IPs, &own)
+        subnets = append(subnets, &net.IPNet{IP: ipc.Address.IP.Mask(ipc.Address.Mask), Mask: ipc.Address.Mask})
+    }
+    for _, r := range result.Routes {
+        if r.GW != nil && contains(subnets, r.GW) {
+            dst := r.Dst
+            c.Routes = append(c.Routes, &dst)
+        }
+    }
+    return c
+}
+
+// Verify checks, from within the pod's namespace, that the contribution is
+// in place: each interface with its MAC address and addresses, and a route
+// through one of them to each destination
+func (c *Contribution) Verify() error {
+    links := make([]netlink.Link, len(c.Interfaces))
+    for i, iface := range c.Interfaces {
+        link, err := netlink.LinkByName(iface.Name)
+        if err != nil {
+            return fmt.Errorf("failed to find interface %q: %v", iface.Name, err)
+        }
+        if iface.Mac != "" && link.Attrs().HardwareAddr.String() != iface.Mac {
+            return fmt.Errorf("interface %q has MAC address %s, not %s", iface.Name, link.Attrs().HardwareAddr, iface.Mac)
+        }
+        links[i] = link
+    }
+    
+    for _, ipc := range c.IPs {
+        link := links[*ipc.Interface]
+        addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+        if err != nil {
+            return fmt.Errorf("failed to list addresses of %q: %v", link.Attrs().Name, err)
+        }
+        if !hasAddr(addrs, ipc.Address) {
+            return fmt.Errorf("interface %q lost %s", link.Attrs().Name, ipc.Address.String())
+        }
+    }
+    
+    for _, dst := range c.Routes {
+        if !routed(links, dst) {
+            return fmt.Errorf("no route to %s through %s", dst, names(c.Interfaces))
+        }
+    }
+    return nil
+}
+
+// routed reports whether one of the links has a route to dst
+func routed(links []netlink.Link, dst *net.IPNet) bool {
+    family := netlink.FAMILY_V4
+    if dst.IP.To4() == nil {
+        family = netlink.FAMILY_V6
+    }
+    for _, link := range links {
+        routes, err := netlink.RouteList(link, family)
+        if err != nil {
+            continue
+        }
+        for _, r := range routes {
+            if sameNet(r.Dst, dst) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// sameNet compares route destinations, a nil one being the default route
+func sameNet(a, b *net.IPNet) bool {
+    ones, _ := b.Mask.Size()
+    if a == nil {
+        return ones == 0
+    }
+    aOnes, _ := a.Mask.Size()
+    return aOnes == ones && a.IP.Equal(b.IP.Mask(b.Mask))
+}
+
+func hasAddr(addrs []netlink.Addr, want net.IPNet) bool {
+    wantOnes, _ := want.Mask.Size()
+    for _, addr := range addrs {
+        if addr.IPNet == nil || !addr.IP.Equal(want.IP) {
+            continue
+        }
+        if ones, _ := addr.Mask.Size(); ones == wantOnes {
+            return true
+        }
+    }
+    return false
+}
+
+func contains(subnets []*net.IPNet, ip net.IP) bool {
+    for _, n := range subnets {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+func names(ifaces []*current.Interface) string {
+    var list []string
+    for _, iface := range ifaces {
+        list = append(list, iface.Name)
+    }
+    return strings.Join(list, ", ")
+}
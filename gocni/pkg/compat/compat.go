@@ -0,0 +1,350 @@
+package compat
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Link kinds the plugin creates, and VRFs
+const (
+    KindVlan    = "vlan"
+    KindMacvlan = "macvlan"
+    KindIPVlan  = "ipvlan"
+    KindVRF     = "vrf"
+)
+
+// LinkKind is the kind of host link an attachment to the VLAN needs
+func LinkKind(vlanID int, untaggedMode string) string {
+    switch {
+    case vlanID != 0:
+        return KindVlan
+    case untaggedMode == config.UntaggedModeIPVlan:
+        return KindIPVlan
+    }
+    return KindMacvlan
+}
+
+// Module states. Unknown means the kernel's module index is not readable,
+// as in containers without /lib/modules; such modules are not refused.
+const (
+    ModuleLoaded   = "loaded"
+    ModuleBuiltin  = "builtin"
+    ModuleLoadable = "loadable"
+    ModuleMissing  = "missing"
+    ModuleUnknown  = "unknown"
+)
+
+// kindModules maps link kinds to the kernel modules implementing them
+var kindModules = map[string]string{
+    KindVlan:    "8021q",
+    KindMacvlan: "macvlan",
+    KindIPVlan:  "ipvlan",
+    KindVRF:     "vrf",
+}
+
+// Kernels older than these lack the feature
+var (
+    minIPVlan         = [2]int{3, 19}
+    minIPVlanIsolated = [2]int{4, 15}
+)
+
+// quirk describes what a NIC driver breaks
+type quirk struct {
+    unsupported map[string]string
+    warnings    []string
+}
+
+// driverQuirks lists drivers known to break some link kinds
+var driverQuirks = map[string]quirk{
+    "ena": {unsupported: map[string]string{
+        KindVlan:    "AWS ENA does not pass 802.1Q tagged frames",
+        KindMacvlan: "AWS ENA drops frames for MAC addresses other than the interface's",
+    }},
+    "gve": {unsupported: map[string]string{
+        KindVlan:    "Google gVNIC does not pass 802.1Q tagged frames",
+        KindMacvlan: "Google gVNIC drops frames for MAC addresses other than the interface's",
+    }},
+    "hv_netvsc": {warnings: []string{
+        "macvlan needs MAC address spoofing enabled on the Hyper-V switch port",
+    }},
+    "vmxnet3": {warnings: []string{
+        "macvlan needs promiscuous mode and forged transmits on the vSphere port group",
+        "tagged VLANs need VLAN ID 4095 (guest tagging) on the vSphere port group",
+    }},
+}
+
+// Report is the outcome of probing the node
+type Report struct {
+    Kernel  string            `json:"kernel"`
+    Modules map[string]string `json:"modules"`
+    Masters []*MasterReport   `json:"masters,omitempty"`
+    Probed  time.Time         `json:"probed"`
+
+    version [2]int
+}
+
+// MasterReport is what a master's driver supports
+type MasterReport struct {
+    Name   string `json:"name"`
+    Driver string `json:"driver,omitempty"`
+
+    // Link kinds that cannot work on the master, with the reason
+    Unsupported map[string]string `json:"unsupported,omitempty"`
+    Warnings    []string          `json:"warnings,omitempty"`
+
+    // Slave health of bond and team masters
+    Team *TeamReport `json:"team,omitempty"`
+}
+
+// Probe inspects the running kernel, its modules and the drivers of the
+// given masters. The module index is read once per boot and kept in store,
+// which may be nil to read it every time.
+func Probe(masters []string, store *state.Store) *Report {
+    r := &Report{Modules: map[string]string{}, Probed: time.Now()}
+    
+    var uts syscall.Utsname
+    if err := syscall.Uname(&uts); err == nil {
+        var release []byte
+        for _, c := range uts.Release {
+            if c == 0 {
+                break
+            }
+            release = append(release, byte(c))
+        }
+        r.Kernel = string(release)
+        r.version = parseVersion(r.Kernel)
+    }
+    
+    builtin, loadable := cachedModuleIndex(r.Kernel, store)
+    for _, kind := range []string{KindVlan, KindMacvlan, KindIPVlan, KindVRF} {
+        name := kindModules[kind]
+        switch {
+        case exists(filepath.Join("/sys/module", name)):
+            r.Modules[name] = ModuleLoaded
+        case builtin == nil:
+            r.Modules[name] = ModuleUnknown
+        case builtin[name]:
+            r.Modules[name] = ModuleBuiltin
+        case loadable[name]:
+            r.Modules[name] = ModuleLoadable
+        default:
+            r.Modules[name] = ModuleMissing
+        }
+    }
+    
+    seen := map[string]bool{}
+    for _, m := range masters {
+        if m == "" || seen[m] {
+            continue
+        }
+        seen[m] = true
+        r.Masters = append(r.Masters, probeMaster(m))
+    }
+    return r
+}
+
+// Check returns why a link of the given kind cannot work on master, or nil
+func (r *Report) Check(kind, master string, isolated bool) error {
+    if name := kindModules[kind]; r.Modules[name] == ModuleMissing {
+        return fmt.Errorf("kernel %s has no %s module", r.Kernel, name)
+    }
+    if kind == KindIPVlan && r.version != [2]int{} {
+        if older(r.version, minIPVlan) {
+            return fmt.Errorf("kernel %s is too old for ipvlan, %d.%d or later is needed", r.Kernel, minIPVlan[0], minIPVlan[1])
+        }
+        if isolated && older(r.version, minIPVlanIsolated) {
+            return fmt.Errorf("kernel %s is too old for isolated ipvlan, %d.%d or later is needed", r.Kernel, minIPVlanIsolated[0], minIPVlanIsolated[1])
+        }
+    }
+    for _, m := range r.Masters {
+        if m.Name == master {
+            if reason, ok := m.Unsupported[kind]; ok {
+                return fmt.Errorf("%s cannot be used on %s: %s", kind, master, reason)
+            }
+        }
+    }
+    return nil
+}
+
+// LoadModule loads the module implementing a link kind with modprobe,
+// unless the kernel already has it. Minimal distributions often lack the
+// module aliases the kernel autoloads link types with, and creating the
+// link then fails with a bare "operation not supported".
+func LoadModule(ctx context.Context, kind string) error {
+    name, ok := kindModules[kind]
+    if !ok || exists(filepath.Join("/sys/module", name)) {
+        return nil
+    }
+    out, err := exec.CommandContext(ctx, "modprobe", name).CombinedOutput()
+    if err != nil {
+        return fmt.Errorf("modprobe %s failed: %v: %s", name, err, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
+
+// NestedNode reports whether this node is itself a container, as kind and
+// k3d nodes are
+func NestedNode() bool {
+    for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+        if _, err := os.Stat(marker); err == nil {
+            return true
+        }
+    }
+    // Set by container engines for the init process they start
+    environ, err := os.ReadFile("/proc/1/environ")
+    if err != nil {
+        return false
+    }
+    for _, kv := range strings.Split(string(environ), "\x00") {
+        if strings.HasPrefix(kv, "container=") {
+            return true
+        }
+    }
+    return false
+}
+
+// Module returns the kernel module implementing a link kind
+func Module(kind string) string {
+    return kindModules[kind]
+}
+
+func probeMaster(name string) *MasterReport {
+    m := &MasterReport{Name: name}
+    if target, err := os.Readlink(filepath.Join("/sys/class/net", name, "device", "driver")); err == nil {
+        m.Driver = filepath.Base(target)
+    }
+    if q, ok := driverQuirks[m.Driver]; ok {
+        m.Unsupported = q.unsupported
+        m.Warnings = q.warnings
+    }
+    m.Team = ProbeTeam(name)
+    return m
+}
+
+// moduleCache is the part of a kernel's module index Probe looks at
+type moduleCache struct {
+    BootID   string          `json:"bootID"`
+    Builtin  map[string]bool `json:"builtin"`
+    Loadable map[string]bool `json:"loadable"`
+}
+
+func moduleCacheName(release string) string {
+    return filepath.Join("compat", "modules-"+release+".json")
+}
+
+// cachedModuleIndex returns the kernel's module index as recorded this
+// boot, reading and recording it when it was not. Package upgrades rewrite
+// the index for a release, so an entry of an earlier boot is read again.
+func cachedModuleIndex(release string, store *state.Store) (builtin, loadable map[string]bool) {
+    bootID := readBootID()
+    if store == nil || release == "" || bootID == "" {
+        return moduleIndex(release)
+    }
+    
+    cache := &moduleCache{}
+    if err := store.Load(moduleCacheName(release), cache); err == nil && cache.BootID == bootID && cache.Builtin != nil {
+        return cache.Builtin, cache.Loadable
+    }
+    
+    builtin, loadable = moduleIndex(release)
+    if builtin == nil {
+        return nil, nil
+    }
+    
+    // Only the modules of the link kinds are kept, not the whole index
+    cache = &moduleCache{BootID: bootID, Builtin: map[string]bool{}, Loadable: map[string]bool{}}
+    for _, name := range kindModules {
+        cache.Builtin[name] = builtin[name]
+        cache.Loadable[name] = loadable[name]
+    }
+    _ = store.Save(moduleCacheName(release), cache)
+    return cache.Builtin, cache.Loadable
+}
+
+// readBootID returns the kernel's identifier of the current boot
+func readBootID() string {
+    data, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(data))
+}
+
+// moduleIndex reads the names of the kernel's builtin and loadable
+// modules, returning nil sets when the index is not available
+func moduleIndex(release string) (builtin, loadable map[string]bool) {
+    dir := filepath.Join("/lib/modules", release)
+    builtin, err := moduleNames(filepath.Join(dir, "modules.builtin"), false)
+    if err != nil {
+        return nil, nil
+    }
+    loadable, err = moduleNames(filepath.Join(dir, "modules.dep"), true)
+    if err != nil {
+        return nil, nil
+    }
+    return builtin, loadable
+}
+
+// moduleNames reads module paths such as kernel/net/8021q/8021q.ko.xz, one
+// per line and followed by their dependencies in modules.dep
+func moduleNames(path string, deps bool) (map[string]bool, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    
+    names := map[string]bool{}
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if deps {
+            line, _, _ = strings.Cut(line, ":")
+        }
+        base := filepath.Base(strings.TrimSpace(line))
+        if i := strings.Index(base, ".ko"); i > 0 {
+            // The kernel treats - and _ in module names alike
+            names[strings.ReplaceAll(base[:i], "-", "_")] = true
+        }
+    }
+    return names, scanner.Err()
+}
+
+// parseVersion reads the major and minor version of a kernel release such
+// as 6.1.0-13-amd64
+func parseVersion(release string) [2]int {
+    parts := strings.SplitN(release, ".", 3)
+    if len(parts) < 2 {
+        return [2]int{}
+    }
+    minor := parts[1]
+    if i := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+        minor = minor[:i]
+    }
+    major, err1 := strconv.Atoi(parts[0])
+    minorN, err2 := strconv.Atoi(minor)
+    if err1 != nil || err2 != nil {
+        return [2]int{}
+    }
+    return [2]int{major, minorN}
+}
+
+func older(v, min [2]int) bool {
+    return v[0] < min[0] || v[0] == min[0] && v[1] < min[1]
+}
+
+func exists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
+}
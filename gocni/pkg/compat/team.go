@@ -0,0 +1,186 @@
+package compat
+
+import (
+    "bufio"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Teaming drivers a master may be
+const (
+    TeamBond = "bond"
+    TeamTeam = "team"
+)
+
+// TeamReport is the health of a bond or team master
+type TeamReport struct {
+    Kind        string         `json:"kind"`
+    Mode        string         `json:"mode,omitempty"`
+    ActiveSlave string         `json:"activeSlave,omitempty"`
+    Slaves      []*SlaveReport `json:"slaves"`
+
+    // Partner of the active 802.3ad aggregator
+    LACPPartner string `json:"lacpPartner,omitempty"`
+
+    aggregator string
+}
+
+// SlaveReport is the state of one bond or team member
+type SlaveReport struct {
+    Name   string `json:"name"`
+    Link   string `json:"link"`
+    Active bool   `json:"active"`
+
+    aggregator string
+}
+
+// noLACPPartner is the partner address of aggregators no switch answers
+const noLACPPartner = "00:00:00:00:00:00"
+
+// ProbeTeam returns the health of master, or nil if it is not a bond or
+// team
+func ProbeTeam(master string) *TeamReport {
+    switch devType(master) {
+    case TeamBond:
+        return probeBond(master)
+    case TeamTeam:
+        return probeTeamDev(master)
+    }
+    return nil
+}
+
+// Check returns an error when no slave carries traffic
+func (t *TeamReport) Check(master string) error {
+    for _, s := range t.Slaves {
+        if s.Active {
+            return nil
+        }
+    }
+    var states []string
+    for _, s := range t.Slaves {
+        states = append(states, s.Name+" "+s.Link)
+    }
+    if len(states) == 0 {
+        return fmt.Errorf("%s %s has no slaves", t.Kind, master)
+    }
+    return fmt.Errorf("%s %s has no active slaves (%s)", t.Kind, master, strings.Join(states, ", "))
+}
+
+// Warnings describes degraded but working teams
+func (t *TeamReport) Warnings() []string {
+    var warnings []string
+    for _, s := range t.Slaves {
+        if s.Link != "up" {
+            warnings = append(warnings, fmt.Sprintf("slave %s is %s", s.Name, s.Link))
+        }
+    }
+    if t.LACPPartner == noLACPPartner {
+        warnings = append(warnings, "802.3ad aggregator has no LACP partner, check that the switch ports run LACP")
+    }
+    return warnings
+}
+
+// Summary is a one-line description of the team for logs
+func (t *TeamReport) Summary() string {
+    var slaves []string
+    for _, s := range t.Slaves {
+        state := s.Link
+        if s.Active {
+            state += ",active"
+        }
+        slaves = append(slaves, s.Name+"="+state)
+    }
+    return fmt.Sprintf("kind=%s mode=%q slaves=%q", t.Kind, t.Mode, strings.Join(slaves, " "))
+}
+
+// devType reads the device type the kernel reports for a link
+func devType(name string) string {
+    data, err := ioutil.ReadFile(filepath.Join("/sys/class/net", name, "uevent"))
+    if err != nil {
+        return ""
+    }
+    for _, line := range strings.Split(string(data), "\n") {
+        if v := strings.TrimPrefix(line, "DEVTYPE="); v != line {
+            return v
+        }
+    }
+    return ""
+}
+
+// probeBond parses /proc/net/bonding, which unlike sysfs also has the
+// 802.3ad aggregator of each slave
+func probeBond(name string) *TeamReport {
+    t := &TeamReport{Kind: TeamBond}
+    f, err := os.Open(filepath.Join("/proc/net/bonding", name))
+    if err != nil {
+        return t
+    }
+    defer f.Close()
+    
+    var slave *SlaveReport
+    activeAggregator := false
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        key, value, ok := strings.Cut(scanner.Text(), ":")
+        if !ok {
+            continue
+        }
+        key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+        switch {
+        case key == "Slave Interface":
+            slave = &SlaveReport{Name: value}
+            t.Slaves = append(t.Slaves, slave)
+        case slave != nil:
+            switch key {
+            case "MII Status":
+                slave.Link = value
+            case "Aggregator ID":
+                slave.aggregator = value
+            }
+        case key == "Bonding Mode":
+            t.Mode = value
+        case key == "Currently Active Slave" && value != "None":
+            t.ActiveSlave = value
+        case key == "Active Aggregator Info":
+            activeAggregator = true
+        case activeAggregator && key == "Aggregator ID":
+            t.aggregator = value
+        case activeAggregator && key == "Partner Mac Address":
+            t.LACPPartner = value
+        }
+    }
+    
+    for _, s := range t.Slaves {
+        switch {
+        case s.Link != "up":
+        case t.ActiveSlave != "":
+            s.Active = s.Name == t.ActiveSlave
+        case t.aggregator != "":
+            s.Active = s.aggregator == t.aggregator
+        default:
+            s.Active = true
+        }
+    }
+    return t
+}
+
+// probeTeamDev reads the ports of a team from sysfs. Which ports teamd
+// selected is only known to teamd, so every port with carrier counts as
+// active.
+func probeTeamDev(name string) *TeamReport {
+    t := &TeamReport{Kind: TeamTeam}
+    lowers, _ := filepath.Glob(filepath.Join("/sys/class/net", name, "lower_*"))
+    for _, lower := range lowers {
+        port := strings.TrimPrefix(filepath.Base(lower), "lower_")
+        state, err := ioutil.ReadFile(filepath.Join("/sys/class/net", port, "operstate"))
+        link := "unknown"
+        if err == nil {
+            link = strings.TrimSpace(string(state))
+        }
+        t.Slaves = append(t.Slaves, &SlaveReport{Name: port, Link: link, Active: link == "up"})
+    }
+    return t
+}
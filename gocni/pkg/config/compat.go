@@ -0,0 +1,42 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+)
+
+// upstreamConf holds the keys of the upstream vlan plugin whose names differ
+// from this plugin's, so its NetworkAttachmentDefinitions work unmodified
+type upstreamConf struct {
+    VlanID *int `json:"vlanId"`
+}
+
+// applyUpstreamKeys takes the upstream names of fields the configuration
+// leaves out under this plugin's, noting a deprecation warning for each.
+// vlanId counts as the vlan key in keys.
+func applyUpstreamKeys(plain []byte, conf *NetConf, keys *vlanKeys) error {
+    up := &upstreamConf{}
+    if err := json.Unmarshal(plain, up); err != nil {
+        return fmt.Errorf("failed to parse network configuration: %v", err)
+    }
+    
+    if up.VlanID != nil {
+        if keys.top && conf.VlanID != *up.VlanID {
+            return fmt.Errorf("vlanId %d contradicts vlan %d, set only vlan", *up.VlanID, conf.VlanID)
+        }
+        conf.VlanID = *up.VlanID
+        keys.top = true
+        conf.compatWarnings = append(conf.compatWarnings, "vlanId is deprecated (feature gate UpstreamKeys), rename it to vlan")
+    }
+    return nil
+}
+
+// CompatWarning lists the upstream field names the configuration used, or
+// returns ""
+func (c *NetConf) CompatWarning() string {
+    if len(c.compatWarnings) == 0 {
+        return ""
+    }
+    return "upstream vlan plugin configuration: " + strings.Join(c.compatWarnings, "; ")
+}
@@ -3,9 +3,80 @@ package config
 import (
     "encoding/json"
     "fmt"
+    "math"
+    "net"
+    "path"
+    "strings"
+    "text/template"
+    "time"
     
     "github.com/containernetworking/cni/pkg/types"
-    "example.com/vlan-cni/pkg/types"
+    "example.com/vlan-cni/pkg/secrets"
+    "example.com/vlan-cni/pkg/tlsconf"
+    vlantypes "example.com/vlan-cni/pkg/types"
+)
+
+// Link types used to attach untagged (VLAN 0) networks to the master
+const (
+    UntaggedModeMacvlan = "macvlan"
+    UntaggedModeIPVlan  = "ipvlan"
+)
+
+// IPv6 address generation modes, see addr_gen_mode in ip-sysctl
+const (
+    AddrGenModeEUI64         = "eui64"
+    AddrGenModeNone          = "none"
+    AddrGenModeStablePrivacy = "stable-privacy"
+    AddrGenModeRandom        = "random"
+)
+
+// ARP presets for arp_announce, arp_ignore and arp_notify in the pod:
+// "strict" only answers and announces with addresses of the interface,
+// "loose" keeps the kernel defaults, "cloud" also ignores requests from
+// outside the subnet and leaves announcements to the fabric
+const (
+    ArpModeStrict = "strict"
+    ArpModeLoose  = "loose"
+    ArpModeCloud  = "cloud"
+)
+
+// IPv6TokenAuto derives the IPv6 interface token from the pod identity, or
+// from the container ID when the runtime passes no K8S_* args
+const IPv6TokenAuto = "auto"
+
+// Hardware VLAN steering modes: "auto" offloads when the NIC supports tc
+// offload, "on" fails ADD when it does not
+const (
+    OffloadOff  = "off"
+    OffloadAuto = "auto"
+    OffloadOn   = "on"
+)
+
+// Simulation modes. Simulated VLANs are node-local bridges the pods are
+// attached to with veth pairs, for clusters such as kind and k3d whose
+// nodes are containers without the physical VLANs. "auto" simulates when
+// the node is a container and the master does not exist.
+const (
+    SimulationOff  = "off"
+    SimulationAuto = "auto"
+    SimulationOn   = "on"
+)
+
+// DEL error policies: "permissive" logs and ignores failures to release
+// resources that are already gone, "strict" returns every failure
+const (
+    DelPolicyPermissive = "permissive"
+    DelPolicyStrict     = "strict"
+)
+
+// Integrations with the cluster's primary network. "multus-flannel" is
+// this plugin as a Multus secondary network beside flannel, the k3s
+// default; "auto" recognizes it from flannel's subnet file and a pod
+// interface other than eth0.
+const (
+    IntegrationAuto          = "auto"
+    IntegrationNone          = "none"
+    IntegrationMultusFlannel = "multus-flannel"
 )
 
 // NetConf extends types.NetConf for VLAN-specific configuration
@@ -14,24 +85,1666 @@ type NetConf struct {
     Master     string `json:"master"`
     VlanID     int    `json:"vlan"`
     MTU        int    `json:"mtu,omitempty"`
-    IPAMConfig *types.IPAMConfig `json:"ipam"`
+    IPAMConfig *vlantypes.IPAMConfig `json:"ipam"`
+
+    // Only create, rename and set up the interface, for pods that manage
+    // their own addressing. "ipam": {} means the same.
+    L2Only bool `json:"l2Only,omitempty"`
+
+    // Masters by VLAN, keyed by ranges such as "100-199" or "300,310",
+    // for networks and attachments that name no master of their own
+    Masters map[string]string `json:"masters,omitempty"`
+
+    // Link type for untagged attachments (vlan 0), macvlan or ipvlan
+    UntaggedMode string `json:"untaggedMode,omitempty"`
+
+    // Emulate the VLAN with a node-local bridge, off by default
+    Simulation string `json:"simulation,omitempty"`
+
+    // The master is in the pod's namespace, pushed in by another plugin
+    // or a device plugin, and the VLAN is created there
+    LinkInContainer bool `json:"linkInContainer,omitempty"`
+
+    // Create the VLAN on the pod interface the previous plugin of the
+    // chain added, such as an SR-IOV VF, rather than on master. Implies
+    // linkInContainer.
+    MasterFromPrevResult bool `json:"masterFromPrevResult,omitempty"`
+
+    // Do not run modprobe for the 8021q, macvlan or ipvlan module when the
+    // kernel has not loaded it
+    DisableModuleLoading bool `json:"disableModuleLoading,omitempty"`
+
+    // Put untagged links in private mode, so pods on this node reach the
+    // uplink but not each other. Tagged VLAN links only ever meet through
+    // the uplink already.
+    Isolated bool `json:"isolated,omitempty"`
+
+    // Wait up to this long after ADD brings the pod's interface up for it
+    // to report carrier, as switch ports may block new MACs for seconds.
+    // Off when 0.
+    CarrierWaitSeconds int `json:"carrierWaitSeconds,omitempty"`
+
+    // Settings of the tuning plugin, applied to the pod interface without
+    // chaining it
+    Tuning *TuningConfig `json:"tuning,omitempty"`
+
+    // Set by runtimes for the capabilities the configuration declares
+    RuntimeConfig RuntimeConfig `json:"runtimeConfig,omitempty"`
+
+    // Work around access ports that drop a new MAC's frames while spanning
+    // tree takes them to forwarding
+    STP *STPConfig `json:"stp,omitempty"`
+
+    // Interface naming templates, rendered with the master name, VLAN ID,
+    // shortened container ID and pod name
+    HostIfNameTemplate      string `json:"hostIfNameTemplate,omitempty"`
+    ContainerIfNameTemplate string `json:"containerIfNameTemplate,omitempty"`
+
+    // Integration with the primary network, auto by default. Beside
+    // flannel, default routes from IPAM are left out so pod egress stays
+    // on flannel unless ipam.routes asks for one.
+    Integration string `json:"integration,omitempty"`
+
+    // Move the pod's default routes to the VLAN interface, removing those
+    // of its other interfaces, for pods whose entire egress has to use the
+    // VLAN. Only the first of several attachments takes them.
+    DefaultRoute bool `json:"defaultRoute,omitempty"`
+
+    // Service and pod CIDRs of the cluster, routed via the pod's other
+    // interface when defaultRoute takes its egress, so ClusterIP services
+    // and cluster DNS stay reachable. Beside flannel its networks are
+    // added.
+    ClusterCIDRs []string `json:"clusterCIDRs,omitempty"`
+
+    // Policy routing keeping kubelet probes on the pod's other interface
+    // once defaultRoute takes its egress, on unless disabled
+    ProbeRouting *ProbeRoutingConfig `json:"probeRouting,omitempty"`
+
+    // Directory for node-local plugin state, defaults to /var/run/vlan-cni.
+    // State on tmpfs there does not survive a reboot.
+    StateDir string `json:"stateDir,omitempty"`
+
+    // Additional addresses (CIDR notation) applied alongside the IPAM address
+    SecondaryIPs []string `json:"secondaryIPs,omitempty"`
+
+    // Accept addresses from args.cni.secondaryIPs that fall within these.
+    // Without them pods cannot ask for addresses of their own, which would
+    // let any pod claim any address on the segment.
+    SecondaryIPsAllowedFromArgs []string `json:"secondaryIPsAllowedFromArgs,omitempty"`
+
+    // Address families in the pod, both enabled by default. A disabled
+    // family gets no addresses or routes, and IPv6 is turned off on the
+    // interface altogether so no link-local address appears.
+    EnableIPv4 *bool `json:"enableIPv4,omitempty"`
+    EnableIPv6 *bool `json:"enableIPv6,omitempty"`
+
+    // ARP sysctl preset for the container interface, unset leaves them alone
+    ArpMode string `json:"arpMode,omitempty"`
+
+    // Drop frames the pod sends with a source MAC or IP other than its
+    // own. The filters live in the pod namespace, so they bind pods that
+    // run without NET_ADMIN.
+    AntiSpoof bool `json:"antiSpoof,omitempty"`
+
+    // Namespaces whose pods may attach to this network, as names or shell
+    // patterns such as "storage-*". Empty allows every namespace.
+    AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+    // IPv6 address generation mode and interface token ("auto" derives the
+    // token from the pod namespace and name)
+    AddrGenMode string `json:"addrGenMode,omitempty"`
+    IPv6Token   string `json:"ipv6Token,omitempty"`
+
+    // Options sent by the dhcp IPAM plugin, rendered from pod metadata
+    DHCP *DHCPConfig `json:"dhcp,omitempty"`
+
+    // Lease the pod's IPv6 address or a delegated prefix over DHCPv6, from
+    // the pod once its interface is up
+    DHCPv6 *DHCPv6Config `json:"dhcpv6,omitempty"`
+
+    // Extend the VLAN to this node over a pseudowire instead of a master
+    Pseudowire *PseudowireConfig `json:"pseudowire,omitempty"`
+
+    // Bond VLAN children of several masters inside the pod, active-backup
+    Bond *BondConfig `json:"bond,omitempty"`
+
+    // Virtual gateway of the network, whose active router CHECK and the
+    // daemon track
+    Gateway *GatewayConfig `json:"gateway,omitempty"`
+
+    // Redundant routers of a VLAN without VRRP. ADD routes the pod via one
+    // of each family in place of IPAM's gateway, picked by
+    // gatewaySelection: first (the default), random, or probe for the
+    // IPv4 router answering ARP first. With attachments they route the
+    // first one, like defaultRoute.
+    Gateways         []string `json:"gateways,omitempty"`
+    GatewaySelection string   `json:"gatewaySelection,omitempty"`
+
+    // Route whole IPv6 prefixes to the pod
+    DelegatedPrefixes *DelegatedPrefixesConfig `json:"delegatedPrefixes,omitempty"`
+
+    // Routes application teams add through a pod annotation, merged with
+    // ipam.routes at ADD
+    PodRoutes *PodRoutesConfig `json:"podRoutes,omitempty"`
+
+    // Optional DNS registration of the pod's VLAN addresses
+    DDNS *DDNSConfig `json:"ddns,omitempty"`
+
+    // Commands or webhooks run around the attachment lifecycle
+    Hooks []HookConfig `json:"hooks,omitempty"`
+
+    // Kubeconfig used by features that talk to the API server, such as the
+    // one Multus writes to /etc/cni/net.d/multus.d
+    Kubeconfig string `json:"kubeconfig,omitempty"`
+
+    // Name of the node as Kubernetes knows it, which IPAM backends tag
+    // allocations with. The runtime's K8S_NODE_NAME arg wins; without
+    // either the host name stands in.
+    NodeName string `json:"nodeName,omitempty"`
+
+    // Steer the VLAN's ingress frames in hardware with a tc flower filter,
+    // into hardware traffic class OffloadTrafficClass
+    Offload             string `json:"offload,omitempty"`
+    OffloadTrafficClass int    `json:"offloadTrafficClass,omitempty"`
+
+    // Record the kernel operations of ADD, DEL and CHECK to this file
+    // instead of performing them, for golden tests in unprivileged CI. MACs
+    // are derived from the container ID so results are deterministic; IPAM
+    // plugins still run.
+    PlanFile string `json:"planFile,omitempty"`
+
+    // File panic stacks are appended to, defaults to DefaultLogFile
+    LogFile string `json:"logFile,omitempty"`
+
+    // Overall budget for one invocation, defaults to DefaultTimeoutSeconds
+    // which stays below the runtime's own CNI timeout
+    TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+    // Netlink and IPAM calls slower than this are logged to stderr with
+    // their duration, defaults to DefaultSlowOpThresholdMs, -1 disables
+    SlowOpThresholdMs int `json:"slowOpThresholdMs,omitempty"`
+
+    // What DEL does when releasing a resource fails, defaults to permissive
+    DelPolicy string `json:"delPolicy,omitempty"`
+
+    // Leave the host link the pod's hangs off out of ADD results, which
+    // otherwise list it before the pod's interface
+    OmitHostInterfaces bool `json:"omitHostInterfaces,omitempty"`
+
+    // Skip flushing host conntrack entries for released addresses on DEL
+    DisableConntrackFlush bool `json:"disableConntrackFlush,omitempty"`
+
+    // Several interfaces created by one ADD, in place of master, vlan and
+    // ipam, for clusters without Multus
+    Attachments []*AttachmentConfig `json:"attachments,omitempty"`
+
+    // Delegating mode: attach the networks listed in a pod annotation by
+    // invoking their plugins, for clusters without Multus
+    Meta *MetaConfig `json:"meta,omitempty"`
+
+    // Per-invocation arguments, populated by Multus from the pod's
+    // network selection annotation ("cni-args")
+    Args *Args `json:"args,omitempty"`
+
+    // Set when cniVersion was missing and has been defaulted
+    versionDefaulted bool
+
+    // Upstream vlan plugin keys that were taken, see CompatWarning
+    compatWarnings []string
+
+    // The configuration with sops-encrypted values decrypted and node
+    // templates rendered
+    decrypted []byte
+
+    // Hash of the configuration as passed, before decryption
+    hash string
+}
+
+// DHCPConfig holds templates over the pod values (PodName, PodNamespace,
+// ContainerID, Master, VlanID) for DHCP options sent on behalf of the pod
+type DHCPConfig struct {
+    Hostname    string `json:"hostname,omitempty"`
+    ClientID    string `json:"clientID,omitempty"`
+    VendorClass string `json:"vendorClass,omitempty"`
+}
+
+// DHCPv6Config is what is asked of the DHCPv6 server. Addresses are
+// leased without an on-link prefix, which router advertisements normally
+// provide along with the default route.
+type DHCPv6Config struct {
+    // Ask for a delegated prefix (IA_PD), of PrefixLength if set
+    PrefixDelegation bool `json:"prefixDelegation,omitempty"`
+    PrefixLength     int  `json:"prefixLength,omitempty"`
+
+    // Only ask for the prefix, not an address (IA_NA)
+    PrefixOnly bool `json:"prefixOnly,omitempty"`
+
+    // Prefix length the leased address is added with, 128 by default
+    AddressPrefixLength int `json:"addressPrefixLength,omitempty"`
+
+    RapidCommit bool `json:"rapidCommit,omitempty"`
+
+    // How long ADD waits for a lease, 10 seconds by default
+    TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// ProbeRoutingConfig is how the replies to kubelet probes find their way
+// back when defaultRoute moved the pod's default routes. Traffic from the
+// addresses of the interface that had them, to the node's networks and
+// to the kubelet port looks up a table with that interface's routes.
+type ProbeRoutingConfig struct {
+    Disabled bool `json:"disabled,omitempty"`
+
+    // Networks of the node, those of its default route's interface unless
+    // set
+    NodeCIDRs []string `json:"nodeCIDRs,omitempty"`
+
+    KubeletPort int `json:"kubeletPort,omitempty"`
+    Table       int `json:"table,omitempty"`
+    Priority    int `json:"priority,omitempty"`
+}
+
+// Defaults of probe routing
+const (
+    DefaultKubeletPort          = 10250
+    DefaultProbeRoutingTable    = 10250
+    DefaultProbeRoutingPriority = 1000
+)
+
+// GatewayConfig is a VRRP virtual router serving as the network's gateway.
+// Only its master advertises, so the advertisements heard from the pod tell
+// which physical router holds the address; failovers are logged to line
+// them up with connectivity blips.
+type GatewayConfig struct {
+    Address string `json:"address"`
+    VRID    int    `json:"vrid"`
+
+    // How long to listen for an advertisement, 3 seconds by default, three
+    // times VRRP's default interval
+    ListenSeconds int `json:"listenSeconds,omitempty"`
+
+    // Fail CHECK unless the address answers ARP, IPv4 only
+    HealthCheck bool `json:"healthCheck,omitempty"`
+}
+
+// BondConfig gives the pod NIC-level redundancy: a VLAN child is created on
+// each master and the children are enslaved to an active-backup bond inside
+// the pod, which the workload sees as its one interface. master, when set,
+// must be the first of masters.
+type BondConfig struct {
+    Masters []string `json:"masters"`
+
+    // Link monitoring interval, 100 by default
+    MIIMonMs int `json:"miimonMs,omitempty"`
+
+    // Master whose child carries traffic whenever its link is up, the
+    // first master by default
+    Primary string `json:"primary,omitempty"`
+}
+
+// Pseudowire encapsulations
+const (
+    PseudowireGRETap = "gretap"
+    PseudowireL2TPv3 = "l2tpv3"
+)
+
+// PseudowireConfig extends an on-prem VLAN to a node without a trunk port.
+// The node's pods meet on a bridge, like a simulated VLAN's, whose uplink is
+// a pseudowire to a router or switch on the VLAN; frames cross it untagged
+// and the far end maps the pseudowire to the VLAN. The pseudowire takes the
+// place of master, named pw<vlan>.
+type PseudowireConfig struct {
+    // gretap, the default, or l2tpv3
+    Type   string `json:"type,omitempty"`
+    Remote string `json:"remote"`
+
+    // Source address, required for l2tpv3 and picked by routing for gretap
+    // when empty
+    Local string `json:"local,omitempty"`
+
+    // GRE key or L2TPv3 session ID, the same on both ends and the VLAN ID
+    // by default
+    Key int `json:"key,omitempty"`
+
+    // L2TPv3 tunnel ID on both ends, the VLAN ID by default
+    TunnelID int `json:"tunnelID,omitempty"`
+
+    // L2TPv3 encapsulation, udp to Port (1701 by default) or ip
+    Encap string `json:"encap,omitempty"`
+    Port  int    `json:"port,omitempty"`
+}
+
+// DefaultL2TPPort is the UDP port of L2TPv3 pseudowires
+const DefaultL2TPPort = 1701
+
+// DefaultBondMIIMonMs is the bond's link monitoring interval
+const DefaultBondMIIMonMs = 100
+
+// Gateway selection strategies
+const (
+    GatewaySelectionFirst  = "first"
+    GatewaySelectionRandom = "random"
+    GatewaySelectionProbe  = "probe"
+)
+
+// DefaultGatewayListenSeconds is how long advertisements are waited for
+const DefaultGatewayListenSeconds = 3
+
+// ListenTimeout returns how long to wait for an advertisement
+func (g *GatewayConfig) ListenTimeout() time.Duration {
+    if g.ListenSeconds > 0 {
+        return time.Duration(g.ListenSeconds) * time.Second
+    }
+    return DefaultGatewayListenSeconds * time.Second
+}
+
+// DelegatedPrefixesConfig hands whole IPv6 prefixes to the pod, for
+// workloads such as VMs or CNFs that number their own hosts behind it. The
+// pod forwards IPv6, and the prefixes get unreachable routes there that the
+// workload's more specific routes override. Upstream, the DHCPv6 server
+// routes the prefixes it delegates, and the daemon's BGP speaker, when
+// enabled, advertises all of them via the pod's address.
+type DelegatedPrefixesConfig struct {
+    // Take the prefixes delegated in the DHCPv6 lease
+    FromDHCPv6 bool `json:"fromDHCPv6,omitempty"`
+
+    // Take the IPv6 routes of the IPAM result whose gateway is one of the
+    // pod's own addresses, which is how IPAM hands a prefix to the pod.
+    // They are not installed in the pod.
+    FromIPAM bool `json:"fromIPAM,omitempty"`
+
+    // Accept prefixes from args.cni.delegatedPrefixes that fall within
+    // these
+    AllowedFromArgs []string `json:"allowedFromArgs,omitempty"`
+}
+
+// DefaultDHCPv6TimeoutSeconds bounds the lease exchange of ADD
+const DefaultDHCPv6TimeoutSeconds = 10
+
+// Timeout returns how long ADD waits for a lease
+func (d *DHCPv6Config) Timeout() time.Duration {
+    if d.TimeoutSeconds > 0 {
+        return time.Duration(d.TimeoutSeconds) * time.Second
+    }
+    return DefaultDHCPv6TimeoutSeconds * time.Second
+}
+
+// RuntimeConfig holds the capability arguments the plugin supports
+type RuntimeConfig struct {
+    // Pod MAC address, with "capabilities": {"mac": true}
+    Mac string `json:"mac,omitempty"`
+}
+
+// TuningConfig mirrors the tuning plugin for the pod interface
+type TuningConfig struct {
+    // MAC address, runtimeConfig.mac takes precedence
+    Mac string `json:"mac,omitempty"`
+
+    // MTU, instead of the network's mtu
+    MTU int `json:"mtu,omitempty"`
+
+    Promisc      bool `json:"promisc,omitempty"`
+    AllMulticast bool `json:"allmulti,omitempty"`
+
+    // Network sysctls of the pod, such as net.ipv4.conf.IFNAME.arp_notify;
+    // IFNAME stands for the pod interface
+    Sysctl map[string]string `json:"sysctl,omitempty"`
+
+    // Ring sizes and queue counts of the pod interface's master, which
+    // must be in the pod
+    Ethtool *EthtoolConfig `json:"ethtool,omitempty"`
+}
+
+// EthtoolConfig sizes the rings and queues of the master, for
+// latency-sensitive workloads. VLAN, macvlan and ipvlan links have no rings
+// of their own and use their master's, which has to be in the pod, such as
+// an SR-IOV VF with linkInContainer or masterFromPrevResult: a NIC on the
+// host is shared by every pod and network on it, and resizing it resets
+// the node's uplink. Zero leaves a setting as the driver has it. CHECK
+// fails when the master no longer has the settings.
+type EthtoolConfig struct {
+    RxRing int `json:"rxRing,omitempty"`
+    TxRing int `json:"txRing,omitempty"`
+
+    RxQueues       int `json:"rxQueues,omitempty"`
+    TxQueues       int `json:"txQueues,omitempty"`
+    CombinedQueues int `json:"combinedQueues,omitempty"`
+}
+
+// STPConfig holds the spanning tree mitigations. Classic STP keeps a new
+// port listening and learning for two forward delays, 30 seconds by
+// default, during which the pod's traffic is blackholed.
+type STPConfig struct {
+    // Hold IP configuration until the IPv4 gateway answers ARP probes from
+    // the pod's interface, for up to ForwardingTimeoutSeconds (default
+    // DefaultSTPForwardingTimeoutSeconds). ADD carries on with a warning
+    // once the timeout passes.
+    WaitForwarding           bool `json:"waitForwarding,omitempty"`
+    ForwardingTimeoutSeconds int  `json:"forwardingTimeoutSeconds,omitempty"`
+
+    // Have the node daemon send gratuitous ARPs from the pod every second
+    // for this long after ADD, so the switch learns the pod's MAC as soon
+    // as the port forwards. Needs the daemon's keepalives feature.
+    KeepaliveSeconds int `json:"keepaliveSeconds,omitempty"`
+}
+
+// DefaultSTPForwardingTimeoutSeconds covers two forward delays and the
+// max age of classic STP
+const DefaultSTPForwardingTimeoutSeconds = 50
+
+// ForwardingTimeout returns how long ADD waits for the port to forward
+func (c *STPConfig) ForwardingTimeout() time.Duration {
+    if c.ForwardingTimeoutSeconds > 0 {
+        return time.Duration(c.ForwardingTimeoutSeconds) * time.Second
+    }
+    return DefaultSTPForwardingTimeoutSeconds * time.Second
+}
+
+// DNS registration providers
+const (
+    DDNSProviderRFC2136     = "rfc2136"
+    DDNSProviderDNSEndpoint = "dnsendpoint"
+)
+
+// DDNSConfig configures registering pod addresses in DNS at ADD and
+// removing them at DEL
+type DDNSConfig struct {
+    // "rfc2136" for dynamic updates, "dnsendpoint" for external-dns CRDs
+    Provider string `json:"provider"`
+    Zone     string `json:"zone"`
+
+    // Record name template relative to the zone, defaults to
+    // "{{.PodName}}.{{.PodNamespace}}"
+    NameTemplate string `json:"nameTemplate,omitempty"`
+    TTL          uint32 `json:"ttl,omitempty"`
+
+    // Fail ADD when registration fails instead of carrying on
+    Required bool `json:"required,omitempty"`
+
+    // rfc2136: server address (host:port) and optional TSIG key
+    Server        string `json:"server,omitempty"`
+    TSIGKeyName   string `json:"tsigKeyName,omitempty"`
+    TSIGSecret    string `json:"tsigSecret,omitempty"`
+    TSIGAlgorithm string `json:"tsigAlgorithm,omitempty"`
+
+    // Reference to the TSIG secret instead of carrying it inline
+    TSIGSecretFrom *secrets.Ref `json:"tsigSecretFrom,omitempty"`
+
+    // dnsendpoint: namespace for DNSEndpoint objects, defaults to the pod's
+    Namespace string `json:"namespace,omitempty"`
+}
+
+// Lifecycle hook events and failure policies
+const (
+    HookEventPostAdd = "post-add"
+    HookEventPreDel  = "pre-del"
+
+    HookFailurePolicyIgnore = "ignore"
+    HookFailurePolicyFail   = "fail"
+)
+
+// HookConfig is a binary or URL notified with a JSON payload describing the
+// attachment
+type HookConfig struct {
+    Name  string `json:"name"`
+    Event string `json:"event"`
+
+    // Exactly one of Exec (argv, payload on stdin) or URL (payload POSTed)
+    Exec []string `json:"exec,omitempty"`
+    URL  string   `json:"url,omitempty"`
+
+    TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+    FailurePolicy  string `json:"failurePolicy,omitempty"`
+
+    // TLS settings for https webhooks
+    TLS *tlsconf.Config `json:"tls,omitempty"`
+
+    // Bearer token sent to webhooks in the Authorization header
+    BearerTokenFrom *secrets.Ref `json:"bearerTokenFrom,omitempty"`
+}
+
+// AttachmentConfig is one interface of a multi-NIC configuration. Settings
+// not listed here are shared with the enclosing configuration; secondary
+// IPs, DNS registration and the runtime's MAC address apply to the first
+// attachment only.
+type AttachmentConfig struct {
+    IfName       string                `json:"ifName"`
+    Master       string                `json:"master"`
+    VlanID       int                   `json:"vlan"`
+    MTU          int                   `json:"mtu,omitempty"`
+    UntaggedMode string                `json:"untaggedMode,omitempty"`
+    IPAMConfig   *vlantypes.IPAMConfig `json:"ipam,omitempty"`
+    EnableIPv4   *bool                 `json:"enableIPv4,omitempty"`
+    EnableIPv6   *bool                 `json:"enableIPv6,omitempty"`
+}
+
+// ForAttachment returns the single-interface configuration for the ith
+// attachment
+func (c *NetConf) ForAttachment(i int) *NetConf {
+    a := c.Attachments[i]
+    sub := *c
+    sub.Attachments = nil
+    sub.Master = a.Master
+    sub.VlanID = a.VlanID
+    sub.IPAMConfig = a.IPAMConfig
+    sub.ContainerIfNameTemplate = ""
+    if a.MTU != 0 {
+        sub.MTU = a.MTU
+    }
+    if a.UntaggedMode != "" {
+        sub.UntaggedMode = a.UntaggedMode
+    }
+    if a.EnableIPv4 != nil {
+        sub.EnableIPv4 = a.EnableIPv4
+    }
+    if a.EnableIPv6 != nil {
+        sub.EnableIPv6 = a.EnableIPv6
+    }
+    if i > 0 {
+        sub.SecondaryIPs = nil
+        sub.Args = nil
+        sub.DDNS = nil
+        sub.RuntimeConfig = RuntimeConfig{}
+        sub.DefaultRoute = false
+        sub.Gateways = nil
+        sub.GatewaySelection = ""
+    }
+    return &sub
+}
+
+// DefaultCNIVersion is assumed when the configuration has no cniVersion.
+// The runtime's CNI library reads a missing version the same way, so the
+// result is printed in the format it expects.
+const DefaultCNIVersion = "0.1.0"
+
+// deprecatedCNIVersions carry no interface or CHECK support in their results
+var deprecatedCNIVersions = map[string]bool{"0.1.0": true, "0.2.0": true}
+
+// VersionWarning explains a missing or deprecated cniVersion, or returns ""
+func (c *NetConf) VersionWarning() string {
+    switch {
+    case c.versionDefaulted:
+        return fmt.Sprintf("cniVersion is not set, assuming %s; set it to the newest version the runtime supports", DefaultCNIVersion)
+    case deprecatedCNIVersions[c.CNIVersion]:
+        return fmt.Sprintf("cniVersion %s is deprecated and cannot report interfaces or support CHECK; upgrade the configuration to 0.4.0 or later", c.CNIVersion)
+    }
+    return ""
+}
+
+// Decrypted returns the configuration with its sops-encrypted values in the
+// clear and node templates rendered, for passing on to IPAM plugins, or nil
+// when nothing was encrypted or templated
+func (c *NetConf) Decrypted() []byte {
+    return c.decrypted
+}
+
+// DefaultLogFile receives diagnostics the CNI result cannot carry
+const DefaultLogFile = "/var/log/vlan-cni.log"
+
+// DefaultTimeoutSeconds bounds an ADD, DEL or CHECK. Kubelet gives up on
+// sandbox requests after two minutes.
+const DefaultTimeoutSeconds = 90
+
+// Timeout returns the overall budget for one invocation
+func (c *NetConf) Timeout() time.Duration {
+    if c.TimeoutSeconds > 0 {
+        return time.Duration(c.TimeoutSeconds) * time.Second
+    }
+    return DefaultTimeoutSeconds * time.Second
+}
+
+// DefaultSlowOpThresholdMs is the slow-operation logging threshold
+const DefaultSlowOpThresholdMs = 500
+
+// SlowOpThreshold returns the duration above which calls are logged, zero
+// when slow-operation logging is disabled
+func (c *NetConf) SlowOpThreshold() time.Duration {
+    if c.SlowOpThresholdMs < 0 {
+        return 0
+    }
+    return time.Duration(c.SlowOpThresholdMs) * time.Millisecond
+}
+
+// DefaultChainedIfNameTemplate names the VLAN beside the interface it is
+// created on when chained with masterFromPrevResult, such as net1.100
+const DefaultChainedIfNameTemplate = "{{.Master}}.{{.VlanID}}"
+
+// DefaultPodRoutesAnnotation carries a pod's own routes
+const DefaultPodRoutesAnnotation = "vlan-cni.io/routes"
+
+// PodRoutesConfig reads routes from a pod annotation, one per line or
+// separated by ";", as "10.1.0.0/16 via 192.168.100.1 [metric 10] [table
+// 100]", or a JSON list of ipam.routes entries. Routes of ipam.routes win
+// for the same destination.
+type PodRoutesConfig struct {
+    Annotation string `json:"annotation,omitempty"`
+
+    // Destinations the annotation may route, as CIDRs it has to fall in.
+    // Empty allows any.
+    AllowedDestinations []string `json:"allowedDestinations,omitempty"`
+}
+
+// Defaults for delegating mode
+const (
+    DefaultMetaAnnotation  = "vlan-cni.io/networks"
+    DefaultMetaNetworksDir = "/etc/cni/vlan-cni.d"
+)
+
+// MetaConfig configures delegating mode. The annotation lists network names,
+// either comma separated with an optional "@ifname" suffix or as a JSON list
+// of {"name", "interface"} objects; each name refers to a network
+// configuration file <name>.conf in NetworksDir.
+type MetaConfig struct {
+    Annotation  string `json:"annotation,omitempty"`
+    NetworksDir string `json:"networksDir,omitempty"`
+}
+
+// Args follows the CNI convention of a top-level "args" object
+type Args struct {
+    CNI *CNIArgs `json:"cni,omitempty"`
+}
+
+// CNIArgs holds the per-pod settings accepted under args.cni
+type CNIArgs struct {
+    // Addresses within secondaryIPsAllowedFromArgs
+    SecondaryIPs []string `json:"secondaryIPs,omitempty"`
+
+    // Shaping class the node daemon puts the pod's traffic in
+    TrafficClass string `json:"trafficClass,omitempty"`
+
+    // IPv6 prefixes routed to the pod, see DelegatedPrefixesConfig
+    DelegatedPrefixes []string `json:"delegatedPrefixes,omitempty"`
+}
+
+// IPv4Enabled reports whether the pod gets IPv4 on the interface
+func (c *NetConf) IPv4Enabled() bool {
+    return c.EnableIPv4 == nil || *c.EnableIPv4
+}
+
+// IPv6Enabled reports whether the pod gets IPv6 on the interface
+func (c *NetConf) IPv6Enabled() bool {
+    return c.EnableIPv6 == nil || *c.EnableIPv6
+}
+
+// FamilyEnabled reports whether ip's address family is enabled
+func (c *NetConf) FamilyEnabled(ip net.IP) bool {
+    if ip.To4() != nil {
+        return c.IPv4Enabled()
+    }
+    return c.IPv6Enabled()
+}
+
+// TrafficClass returns the shaping class requested for the pod, if any
+func (c *NetConf) TrafficClass() string {
+    if c.Args != nil && c.Args.CNI != nil {
+        return c.Args.CNI.TrafficClass
+    }
+    return ""
+}
+
+// SecondaryAddrs returns the configured and pod-requested secondary addresses
+func (c *NetConf) SecondaryAddrs() ([]*net.IPNet, error) {
+    cidrs := append([]string{}, c.SecondaryIPs...)
+    if c.Args != nil && c.Args.CNI != nil {
+        cidrs = append(cidrs, c.Args.CNI.SecondaryIPs...)
+    }
+    
+    addrs := make([]*net.IPNet, 0, len(cidrs))
+    for _, cidr := range cidrs {
+        ip, ipnet, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return nil, fmt.Errorf("invalid secondary IP %q: %v", cidr, err)
+        }
+        ipnet.IP = ip
+        addrs = append(addrs, ipnet)
+    }
+    return addrs, nil
+}
+
+// ArgsDelegatedPrefixes returns the prefixes the pod asked to be routed
+func (c *NetConf) ArgsDelegatedPrefixes() ([]*net.IPNet, error) {
+    if c.Args == nil || c.Args.CNI == nil {
+        return nil, nil
+    }
+    var prefixes []*net.IPNet
+    for _, cidr := range c.Args.CNI.DelegatedPrefixes {
+        _, ipnet, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return nil, fmt.Errorf("invalid delegated prefix %q: %v", cidr, err)
+        }
+        prefixes = append(prefixes, ipnet)
+    }
+    return prefixes, nil
+}
+
+// AllowsNamespace reports whether pods in namespace may use this network
+func (c *NetConf) AllowsNamespace(namespace string) bool {
+    if len(c.AllowedNamespaces) == 0 {
+        return true
+    }
+    for _, pattern := range c.AllowedNamespaces {
+        if ok, _ := path.Match(pattern, namespace); ok && namespace != "" {
+            return true
+        }
+    }
+    return false
+}
+
+// K8sArgs holds the Kubernetes pod metadata passed through CNI_ARGS
+type K8sArgs struct {
+    types.CommonArgs
+    K8S_POD_NAME               types.UnmarshallableString
+    K8S_POD_NAMESPACE          types.UnmarshallableString
+    K8S_POD_INFRA_CONTAINER_ID types.UnmarshallableString
+    K8S_POD_UID                types.UnmarshallableString
+    K8S_NODE_NAME              types.UnmarshallableString
+}
+
+// LoadK8sArgs parses the CNI_ARGS string into K8sArgs
+func LoadK8sArgs(args string) (*K8sArgs, error) {
+    k8sArgs := &K8sArgs{}
+    if err := types.LoadArgs(args, k8sArgs); err != nil {
+        return nil, fmt.Errorf("failed to parse CNI_ARGS: %v", err)
+    }
+    return k8sArgs, nil
 }
 
 // ParseConfig parses the supplied configuration from bytes
 func ParseConfig(bytes []byte) (*NetConf, error) {
+    return parseConfig(bytes, nil)
+}
+
+func parseConfig(bytes []byte, node NodeValuesFunc) (*NetConf, error) {
+    plain, err := decryptSOPS(bytes)
+    if err != nil {
+        return nil, err
+    }
+    if hasNodeTemplates(plain) {
+        if plain, err = renderNodeTemplates(plain, node); err != nil {
+            return nil, err
+        }
+    }
+    
     conf := &NetConf{}
-    if err := json.Unmarshal(bytes, conf); err != nil {
+    if err := json.Unmarshal(plain, conf); err != nil {
         return nil, fmt.Errorf("failed to parse network configuration: %v", err)
     }
+    keys, err := findVlanKeys(plain)
+    if err != nil {
+        return nil, err
+    }
+    if err := applyUpstreamKeys(plain, conf, keys); err != nil {
+        return nil, err
+    }
+    if string(plain) != string(bytes) {
+        conf.decrypted = plain
+    }
+    conf.hash, _ = Hash(bytes)
+    
+    if conf.CNIVersion == "" {
+        conf.CNIVersion = DefaultCNIVersion
+        conf.versionDefaulted = true
+    }
     
     // Validation
-    if conf.VlanID < 1 || conf.VlanID > 4094 {
-        return nil, fmt.Errorf("invalid VLAN ID %d (must be between 1 and 4094)", conf.VlanID)
+    if conf.VlanID < 0 || conf.VlanID > 4094 {
+        return nil, fmt.Errorf("invalid VLAN ID %d (must be between 0 and 4094, 0 for untagged)", conf.VlanID)
+    }
+    
+    switch conf.UntaggedMode {
+    case "":
+        conf.UntaggedMode = UntaggedModeMacvlan
+    case UntaggedModeMacvlan, UntaggedModeIPVlan:
+    default:
+        return nil, fmt.Errorf("invalid untaggedMode %q (must be %q or %q)", conf.UntaggedMode, UntaggedModeMacvlan, UntaggedModeIPVlan)
     }
     
-    if conf.Master == "" {
+    if err := validatePseudowire(conf); err != nil {
+        return nil, err
+    }
+    
+    if err := validateBond(conf); err != nil {
+        return nil, err
+    }
+    
+    if err := resolveMasters(conf); err != nil {
+        return nil, err
+    }
+    
+    if err := validateL2Only(conf); err != nil {
+        return nil, err
+    }
+    
+    if err := validateLinkInContainer(conf); err != nil {
+        return nil, err
+    }
+    
+    switch {
+    case conf.Meta != nil:
+        if conf.Master != "" || len(conf.Attachments) > 0 {
+            return nil, fmt.Errorf("meta mode cannot be combined with master or attachments")
+        }
+        if conf.Meta.Annotation == "" {
+            conf.Meta.Annotation = DefaultMetaAnnotation
+        }
+        if conf.Meta.NetworksDir == "" {
+            conf.Meta.NetworksDir = DefaultMetaNetworksDir
+        }
+    case len(conf.Attachments) > 0:
+        if err := validateAttachments(conf, keys); err != nil {
+            return nil, err
+        }
+    case conf.Master == "" && !conf.MasterFromPrevResult:
         return nil, fmt.Errorf("master interface name is required")
+    case !keys.top:
+        return nil, fmt.Errorf("vlan is required (0 for untagged)")
+    }
+    
+    if err := validateIPAM(conf.IPAMConfig); err != nil {
+        return nil, err
+    }
+    
+    switch conf.Offload {
+    case "":
+        conf.Offload = OffloadOff
+    case OffloadOff, OffloadAuto, OffloadOn:
+    default:
+        return nil, fmt.Errorf("invalid offload %q (must be %q, %q or %q)", conf.Offload, OffloadAuto, OffloadOn, OffloadOff)
+    }
+    if conf.OffloadTrafficClass < 0 || conf.OffloadTrafficClass > 15 {
+        return nil, fmt.Errorf("invalid offloadTrafficClass %d (must be between 0 and 15)", conf.OffloadTrafficClass)
+    }
+    
+    switch conf.Simulation {
+    case "":
+        conf.Simulation = SimulationOff
+    case SimulationOff, SimulationAuto, SimulationOn:
+    default:
+        return nil, fmt.Errorf("invalid simulation %q (must be %q, %q or %q)", conf.Simulation, SimulationOff, SimulationAuto, SimulationOn)
+    }
+    if conf.Simulation == SimulationOn && conf.Offload == OffloadOn {
+        return nil, fmt.Errorf("offload %q cannot be used with simulated VLANs", OffloadOn)
+    }
+    
+    switch conf.DelPolicy {
+    case "":
+        conf.DelPolicy = DelPolicyPermissive
+    case DelPolicyPermissive, DelPolicyStrict:
+    default:
+        return nil, fmt.Errorf("invalid delPolicy %q (must be %q or %q)", conf.DelPolicy, DelPolicyPermissive, DelPolicyStrict)
+    }
+    
+    if conf.LogFile == "" {
+        conf.LogFile = DefaultLogFile
+    }
+    if conf.TimeoutSeconds < 0 {
+        return nil, fmt.Errorf("invalid timeoutSeconds %d", conf.TimeoutSeconds)
+    }
+    if err := validateTuning(conf); err != nil {
+        return nil, err
+    }
+    if err := validateDHCPv6(conf); err != nil {
+        return nil, err
+    }
+    if err := validateDelegatedPrefixes(conf); err != nil {
+        return nil, err
+    }
+    if g := conf.Gateway; g != nil {
+        ip := net.ParseIP(g.Address)
+        if ip == nil || !conf.FamilyEnabled(ip) {
+            return nil, fmt.Errorf("invalid gateway address %q", g.Address)
+        }
+        if g.VRID < 1 || g.VRID > 255 {
+            return nil, fmt.Errorf("invalid gateway vrid %d", g.VRID)
+        }
+        if g.ListenSeconds < 0 {
+            return nil, fmt.Errorf("invalid gateway listenSeconds %d", g.ListenSeconds)
+        }
+        if g.HealthCheck && ip.To4() == nil {
+            return nil, fmt.Errorf("gateway healthCheck probes with ARP, which needs an IPv4 address")
+        }
+    }
+    if err := validateGateways(conf); err != nil {
+        return nil, err
+    }
+    if conf.CarrierWaitSeconds < 0 {
+        return nil, fmt.Errorf("invalid carrierWaitSeconds %d", conf.CarrierWaitSeconds)
+    }
+    if stp := conf.STP; stp != nil && (stp.ForwardingTimeoutSeconds < 0 || stp.KeepaliveSeconds < 0) {
+        return nil, fmt.Errorf("invalid stp: forwardingTimeoutSeconds and keepaliveSeconds cannot be negative")
+    }
+    if conf.SlowOpThresholdMs == 0 {
+        conf.SlowOpThresholdMs = DefaultSlowOpThresholdMs
+    }
+    
+    if conf.DefaultRoute && conf.IPAMConfig == nil && len(conf.Attachments) == 0 && conf.Meta == nil {
+        return nil, fmt.Errorf("defaultRoute needs ipam for the gateway")
+    }
+    
+    for _, cidr := range conf.ClusterCIDRs {
+        if _, _, err := net.ParseCIDR(cidr); err != nil {
+            return nil, fmt.Errorf("invalid clusterCIDR %q: %v", cidr, err)
+        }
+    }
+    if pr := conf.PodRoutes; pr != nil {
+        if conf.Kubeconfig == "" {
+            return nil, fmt.Errorf("podRoutes needs a kubeconfig to read the pod's annotations")
+        }
+        if pr.Annotation == "" {
+            pr.Annotation = DefaultPodRoutesAnnotation
+        }
+        for _, cidr := range pr.AllowedDestinations {
+            if _, _, err := net.ParseCIDR(cidr); err != nil {
+                return nil, fmt.Errorf("invalid podRoutes allowedDestination %q: %v", cidr, err)
+            }
+        }
+    }
+    
+    if err := validateProbeRouting(conf); err != nil {
+        return nil, err
+    }
+    
+    switch conf.Integration {
+    case "":
+        conf.Integration = IntegrationAuto
+    case IntegrationAuto, IntegrationNone, IntegrationMultusFlannel:
+    default:
+        return nil, fmt.Errorf("invalid integration %q (must be %q, %q or %q)", conf.Integration, IntegrationAuto, IntegrationNone, IntegrationMultusFlannel)
+    }
+    
+    switch conf.ArpMode {
+    case "", ArpModeStrict, ArpModeLoose, ArpModeCloud:
+    default:
+        return nil, fmt.Errorf("invalid arpMode %q (must be %q, %q or %q)", conf.ArpMode, ArpModeStrict, ArpModeLoose, ArpModeCloud)
+    }
+    
+    for _, pattern := range conf.AllowedNamespaces {
+        if _, err := path.Match(pattern, ""); err != nil {
+            return nil, fmt.Errorf("invalid allowedNamespaces pattern %q: %v", pattern, err)
+        }
+    }
+    
+    switch conf.AddrGenMode {
+    case "", AddrGenModeEUI64, AddrGenModeNone, AddrGenModeStablePrivacy, AddrGenModeRandom:
+    default:
+        return nil, fmt.Errorf("invalid addrGenMode %q", conf.AddrGenMode)
+    }
+    
+    if conf.IPv6Token != "" && conf.IPv6Token != IPv6TokenAuto {
+        token := net.ParseIP(conf.IPv6Token)
+        if token == nil || token.To4() != nil {
+            return nil, fmt.Errorf("invalid ipv6Token %q", conf.IPv6Token)
+        }
+    }
+    
+    for i := range conf.Hooks {
+        h := &conf.Hooks[i]
+        if h.Event != HookEventPostAdd && h.Event != HookEventPreDel {
+            return nil, fmt.Errorf("hook %q: unknown event %q", h.Name, h.Event)
+        }
+        if (len(h.Exec) == 0) == (h.URL == "") {
+            return nil, fmt.Errorf("hook %q: exactly one of exec or url is required", h.Name)
+        }
+        if h.TLS != nil {
+            if _, err := h.TLS.ClientConfig(false); err != nil {
+                return nil, fmt.Errorf("hook %q: %v", h.Name, err)
+            }
+        }
+        if h.BearerTokenFrom != nil {
+            if h.URL == "" {
+                return nil, fmt.Errorf("hook %q: bearerTokenFrom needs a url", h.Name)
+            }
+            if err := h.BearerTokenFrom.Validate(); err != nil {
+                return nil, fmt.Errorf("hook %q: bearerTokenFrom: %v", h.Name, err)
+            }
+        }
+        switch h.FailurePolicy {
+        case "":
+            h.FailurePolicy = HookFailurePolicyIgnore
+        case HookFailurePolicyIgnore, HookFailurePolicyFail:
+        default:
+            return nil, fmt.Errorf("hook %q: unknown failurePolicy %q", h.Name, h.FailurePolicy)
+        }
+    }
+    
+    if err := validateFamilies(conf); err != nil {
+        return nil, err
+    }
+    for i := range conf.Attachments {
+        if err := validateFamilies(conf.ForAttachment(i)); err != nil {
+            return nil, fmt.Errorf("attachment %q: %v", conf.Attachments[i].IfName, err)
+        }
+    }
+    
+    templates := map[string]string{
+        "hostIfNameTemplate":      conf.HostIfNameTemplate,
+        "containerIfNameTemplate": conf.ContainerIfNameTemplate,
+    }
+    if conf.DDNS != nil {
+        switch conf.DDNS.Provider {
+        case DDNSProviderRFC2136:
+            if conf.DDNS.Server == "" {
+                return nil, fmt.Errorf("ddns: server is required for provider %q", conf.DDNS.Provider)
+            }
+            if conf.DDNS.TSIGSecretFrom != nil {
+                if err := conf.DDNS.TSIGSecretFrom.Validate(); err != nil {
+                    return nil, fmt.Errorf("ddns: tsigSecretFrom: %v", err)
+                }
+            }
+        case DDNSProviderDNSEndpoint:
+        default:
+            return nil, fmt.Errorf("ddns: unknown provider %q", conf.DDNS.Provider)
+        }
+        if conf.DDNS.Zone == "" {
+            return nil, fmt.Errorf("ddns: zone is required")
+        }
+        if conf.DDNS.NameTemplate == "" {
+            conf.DDNS.NameTemplate = "{{.PodName}}.{{.PodNamespace}}"
+        }
+        if conf.DDNS.TTL == 0 {
+            conf.DDNS.TTL = 300
+        }
+        templates["ddns.nameTemplate"] = conf.DDNS.NameTemplate
+    }
+    if conf.DHCP != nil {
+        templates["dhcp.hostname"] = conf.DHCP.Hostname
+        templates["dhcp.clientID"] = conf.DHCP.ClientID
+        templates["dhcp.vendorClass"] = conf.DHCP.VendorClass
+    }
+    for field, tmpl := range templates {
+        if tmpl == "" {
+            continue
+        }
+        if _, err := template.New(field).Option("missingkey=error").Parse(tmpl); err != nil {
+            return nil, fmt.Errorf("invalid %s %q: %v", field, tmpl, err)
+        }
     }
     
     return conf, nil
+}
+
+// validateFamilies checks the enabled address families against the
+// settings that need them
+func validateFamilies(conf *NetConf) error {
+    if !conf.IPv4Enabled() && !conf.IPv6Enabled() {
+        return fmt.Errorf("enableIPv4 and enableIPv6 cannot both be false")
+    }
+    if !conf.IPv6Enabled() && (conf.AddrGenMode != "" || conf.IPv6Token != "") {
+        return fmt.Errorf("addrGenMode and ipv6Token need IPv6 enabled")
+    }
+    if !conf.IPv4Enabled() && conf.ArpMode != "" {
+        return fmt.Errorf("arpMode needs IPv4 enabled")
+    }
+    
+    addrs, err := conf.SecondaryAddrs()
+    if err != nil {
+        return err
+    }
+    for _, addr := range addrs {
+        if !conf.FamilyEnabled(addr.IP) {
+            return fmt.Errorf("secondary IP %s belongs to a disabled address family", addr)
+        }
+    }
+    return validateArgsSecondaryIPs(conf)
+}
+
+// validateArgsSecondaryIPs keeps the addresses pods ask for within
+// secondaryIPsAllowedFromArgs
+func validateArgsSecondaryIPs(conf *NetConf) error {
+    var allowed []*net.IPNet
+    for _, cidr := range conf.SecondaryIPsAllowedFromArgs {
+        _, ipnet, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return fmt.Errorf("invalid secondaryIPsAllowedFromArgs %q", cidr)
+        }
+        allowed = append(allowed, ipnet)
+    }
+    if conf.Args == nil || conf.Args.CNI == nil {
+        return nil
+    }
+    for _, cidr := range conf.Args.CNI.SecondaryIPs {
+        ip, _, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return fmt.Errorf("invalid secondary IP %q: %v", cidr, err)
+        }
+        if !addrWithin(ip, allowed) {
+            return fmt.Errorf("args.cni.secondaryIPs %s is not within secondaryIPsAllowedFromArgs", cidr)
+        }
+    }
+    return nil
+}
+
+// validateIPAM checks an optional ipam section
+func validateIPAM(ipam *vlantypes.IPAMConfig) error {
+    if ipam == nil {
+        return nil
+    }
+    if ipam.Type == "" {
+        return fmt.Errorf("ipam type is required")
+    }
+    if ipam.TimeoutSeconds < 0 {
+        return fmt.Errorf("invalid ipam timeoutSeconds %d", ipam.TimeoutSeconds)
+    }
+    if cb := ipam.CircuitBreaker; cb != nil && cb.CooldownSeconds < 0 {
+        return fmt.Errorf("invalid ipam circuitBreaker cooldownSeconds %d", cb.CooldownSeconds)
+    }
+    for _, r := range ipam.Routes {
+        if err := r.Validate(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// validateDHCPv6 checks the dhcpv6 block
+func validateDHCPv6(conf *NetConf) error {
+    d := conf.DHCPv6
+    if d == nil {
+        return nil
+    }
+    if !conf.IPv6Enabled() {
+        return fmt.Errorf("dhcpv6 needs IPv6, which the network disables")
+    }
+    if d.PrefixOnly && !d.PrefixDelegation {
+        return fmt.Errorf("dhcpv6 prefixOnly needs prefixDelegation")
+    }
+    if d.PrefixLength < 0 || d.PrefixLength > 128 {
+        return fmt.Errorf("invalid dhcpv6 prefixLength %d", d.PrefixLength)
+    }
+    if d.AddressPrefixLength < 0 || d.AddressPrefixLength > 128 {
+        return fmt.Errorf("invalid dhcpv6 addressPrefixLength %d", d.AddressPrefixLength)
+    }
+    if d.TimeoutSeconds < 0 {
+        return fmt.Errorf("invalid dhcpv6 timeoutSeconds %d", d.TimeoutSeconds)
+    }
+    return nil
+}
+
+func (e *EthtoolConfig) validate() error {
+    if e == nil {
+        return nil
+    }
+    for _, v := range []int{e.RxRing, e.TxRing, e.RxQueues, e.TxQueues, e.CombinedQueues} {
+        if v < 0 || int64(v) > math.MaxUint32 {
+            return fmt.Errorf("invalid ethtool setting %d", v)
+        }
+    }
+    return nil
+}
+
+// validateL2Only drops empty ipam sections and rejects the features that
+// configure addresses on l2Only attachments
+func validateL2Only(conf *NetConf) error {
+    if conf.IPAMConfig != nil && conf.IPAMConfig.Empty() {
+        conf.IPAMConfig, conf.L2Only = nil, true
+    }
+    for _, a := range conf.Attachments {
+        if a.IPAMConfig != nil && a.IPAMConfig.Empty() {
+            a.IPAMConfig = nil
+        }
+    }
+    if !conf.L2Only {
+        return nil
+    }
+    switch {
+    case conf.IPAMConfig != nil:
+        return fmt.Errorf("l2Only cannot be combined with ipam")
+    case len(conf.SecondaryIPs) > 0, conf.DHCPv6 != nil, conf.DelegatedPrefixes != nil, conf.DDNS != nil:
+        return fmt.Errorf("l2Only cannot be combined with secondaryIPs, dhcpv6, delegatedPrefixes or ddns")
+    case conf.AntiSpoof:
+        return fmt.Errorf("l2Only cannot be combined with antiSpoof, which pins the addresses IPAM gave")
+    }
+    return nil
+}
+
+// validateGateways checks the routers to pick from and the strategy
+func validateGateways(conf *NetConf) error {
+    if len(conf.Gateways) == 0 {
+        if conf.GatewaySelection != "" {
+            return fmt.Errorf("gatewaySelection needs gateways")
+        }
+        return nil
+    }
+    switch conf.GatewaySelection {
+    case "":
+        conf.GatewaySelection = GatewaySelectionFirst
+    case GatewaySelectionFirst, GatewaySelectionRandom, GatewaySelectionProbe:
+    default:
+        return fmt.Errorf("invalid gatewaySelection %q", conf.GatewaySelection)
+    }
+    if conf.Gateway != nil {
+        return fmt.Errorf("gateways cannot be combined with gateway, whose virtual router needs no choosing")
+    }
+    if conf.IPAMConfig == nil && len(conf.Attachments) == 0 && conf.Meta == nil {
+        return fmt.Errorf("gateways needs ipam for the pod's addresses")
+    }
+    seen := map[string]bool{}
+    for _, g := range conf.Gateways {
+        ip := net.ParseIP(g)
+        if ip == nil || !conf.FamilyEnabled(ip) {
+            return fmt.Errorf("invalid gateway address %q", g)
+        }
+        if seen[ip.String()] {
+            return fmt.Errorf("duplicate gateway %q", g)
+        }
+        seen[ip.String()] = true
+    }
+    return nil
+}
+
+// validatePseudowire fills the pseudowire's defaults and makes it the
+// network's master
+func validatePseudowire(conf *NetConf) error {
+    p := conf.Pseudowire
+    if p == nil {
+        return nil
+    }
+    if conf.Meta != nil || len(conf.Attachments) > 0 || conf.Bond != nil {
+        return fmt.Errorf("pseudowire cannot be combined with meta mode, attachments or bond")
+    }
+    if conf.Master != "" {
+        return fmt.Errorf("pseudowire takes the place of master, which must be empty")
+    }
+    if conf.VlanID == 0 {
+        return fmt.Errorf("pseudowire needs a tagged VLAN")
+    }
+    if conf.Offload == OffloadOn {
+        return fmt.Errorf("offload %q cannot be used with pseudowires", OffloadOn)
+    }
+    remote := net.ParseIP(p.Remote)
+    if remote == nil {
+        return fmt.Errorf("invalid pseudowire remote %q", p.Remote)
+    }
+    if p.Local != "" {
+        local := net.ParseIP(p.Local)
+        if local == nil || (local.To4() == nil) != (remote.To4() == nil) {
+            return fmt.Errorf("invalid pseudowire local %q", p.Local)
+        }
+    }
+    if p.Key == 0 {
+        p.Key = conf.VlanID
+    }
+    if p.Key < 0 || int64(p.Key) > math.MaxUint32 {
+        return fmt.Errorf("invalid pseudowire key %d", p.Key)
+    }
+    
+    switch p.Type {
+    case "":
+        p.Type = PseudowireGRETap
+    case PseudowireGRETap:
+    case PseudowireL2TPv3:
+        if p.Local == "" {
+            return fmt.Errorf("l2tpv3 pseudowires need a local address")
+        }
+        if p.TunnelID == 0 {
+            p.TunnelID = conf.VlanID
+        }
+        if p.TunnelID < 0 || int64(p.TunnelID) > math.MaxUint32 {
+            return fmt.Errorf("invalid pseudowire tunnelID %d", p.TunnelID)
+        }
+        switch p.Encap {
+        case "":
+            p.Encap = "udp"
+        case "udp", "ip":
+        default:
+            return fmt.Errorf("invalid pseudowire encap %q (must be \"udp\" or \"ip\")", p.Encap)
+        }
+        if p.Port == 0 {
+            p.Port = DefaultL2TPPort
+        }
+        if p.Port < 1 || p.Port > 65535 {
+            return fmt.Errorf("invalid pseudowire port %d", p.Port)
+        }
+    default:
+        return fmt.Errorf("invalid pseudowire type %q (must be %q or %q)", p.Type, PseudowireGRETap, PseudowireL2TPv3)
+    }
+    conf.Master = fmt.Sprintf("pw%d", conf.VlanID)
+    return nil
+}
+
+// validateLinkInContainer turns masterFromPrevResult into linkInContainer
+// and refuses what needs the master on the host
+func validateLinkInContainer(conf *NetConf) error {
+    if conf.MasterFromPrevResult {
+        switch {
+        case conf.Master != "" || len(conf.Masters) > 0:
+            return fmt.Errorf("masterFromPrevResult cannot be combined with master or masters")
+        case conf.VlanID == 0:
+            return fmt.Errorf("masterFromPrevResult needs a tagged VLAN")
+        }
+        conf.LinkInContainer = true
+        if conf.ContainerIfNameTemplate == "" {
+            conf.ContainerIfNameTemplate = DefaultChainedIfNameTemplate
+        }
+    }
+    if !conf.LinkInContainer {
+        return nil
+    }
+    switch {
+    case conf.Meta != nil || len(conf.Attachments) > 0:
+        return fmt.Errorf("linkInContainer cannot be combined with meta mode or attachments")
+    case conf.Bond != nil || conf.Pseudowire != nil:
+        return fmt.Errorf("linkInContainer cannot be combined with bond or pseudowire")
+    case conf.Simulation == SimulationOn:
+        return fmt.Errorf("linkInContainer cannot be simulated")
+    }
+    return nil
+}
+
+// validateBond checks the bond's masters and makes the first of them the
+// network's master
+func validateBond(conf *NetConf) error {
+    b := conf.Bond
+    if b == nil {
+        return nil
+    }
+    if conf.Meta != nil || len(conf.Attachments) > 0 {
+        return fmt.Errorf("bond cannot be combined with meta mode or attachments")
+    }
+    if conf.VlanID == 0 {
+        return fmt.Errorf("bond needs a tagged VLAN")
+    }
+    if len(b.Masters) < 2 {
+        return fmt.Errorf("bond needs at least two masters")
+    }
+    seen := map[string]bool{}
+    for _, m := range b.Masters {
+        if m == "" || seen[m] {
+            return fmt.Errorf("invalid bond masters %q: names must be set and unique", b.Masters)
+        }
+        seen[m] = true
+    }
+    if b.Primary == "" {
+        b.Primary = b.Masters[0]
+    }
+    if !seen[b.Primary] {
+        return fmt.Errorf("bond primary %q is not one of its masters", b.Primary)
+    }
+    if b.MIIMonMs == 0 {
+        b.MIIMonMs = DefaultBondMIIMonMs
+    }
+    if b.MIIMonMs < 0 {
+        return fmt.Errorf("invalid bond miimonMs %d", b.MIIMonMs)
+    }
+    switch conf.Master {
+    case "":
+        conf.Master = b.Masters[0]
+    case b.Masters[0]:
+    default:
+        return fmt.Errorf("master %q must be the first of the bond's masters", conf.Master)
+    }
+    return nil
+}
+
+// validateProbeRouting fills the probe routing defaults when defaultRoute
+// is set
+func validateProbeRouting(conf *NetConf) error {
+    if !conf.DefaultRoute {
+        return nil
+    }
+    if conf.ProbeRouting == nil {
+        conf.ProbeRouting = &ProbeRoutingConfig{}
+    }
+    p := conf.ProbeRouting
+    for _, cidr := range p.NodeCIDRs {
+        if _, _, err := net.ParseCIDR(cidr); err != nil {
+            return fmt.Errorf("invalid probeRouting nodeCIDR %q: %v", cidr, err)
+        }
+    }
+    if p.KubeletPort == 0 {
+        p.KubeletPort = DefaultKubeletPort
+    }
+    if p.KubeletPort < 1 || p.KubeletPort > 65535 {
+        return fmt.Errorf("invalid probeRouting kubeletPort %d", p.KubeletPort)
+    }
+    if p.Table == 0 {
+        p.Table = DefaultProbeRoutingTable
+    }
+    // The local, main and default tables are the kernel's
+    if p.Table < 1 || (p.Table >= 253 && p.Table <= 255) {
+        return fmt.Errorf("invalid probeRouting table %d", p.Table)
+    }
+    if p.Priority == 0 {
+        p.Priority = DefaultProbeRoutingPriority
+    }
+    if p.Priority < 1 || p.Priority >= 32766 {
+        return fmt.Errorf("invalid probeRouting priority %d (must be below the main table's 32766)", p.Priority)
+    }
+    return nil
+}
+
+// validateDelegatedPrefixes checks the delegatedPrefixes block and the
+// prefixes the pod asked for against it
+func validateDelegatedPrefixes(conf *NetConf) error {
+    requested, err := conf.ArgsDelegatedPrefixes()
+    if err != nil {
+        return err
+    }
+    d := conf.DelegatedPrefixes
+    if d == nil {
+        if len(requested) > 0 {
+            return fmt.Errorf("args.cni.delegatedPrefixes needs delegatedPrefixes in the network configuration")
+        }
+        return nil
+    }
+    if !conf.IPv6Enabled() {
+        return fmt.Errorf("delegatedPrefixes needs IPv6, which the network disables")
+    }
+    if d.FromDHCPv6 && (conf.DHCPv6 == nil || !conf.DHCPv6.PrefixDelegation) {
+        return fmt.Errorf("delegatedPrefixes fromDHCPv6 needs dhcpv6 prefixDelegation")
+    }
+    if d.FromIPAM && conf.IPAMConfig == nil {
+        return fmt.Errorf("delegatedPrefixes fromIPAM needs ipam")
+    }
+    
+    var allowed []*net.IPNet
+    for _, cidr := range d.AllowedFromArgs {
+        _, ipnet, err := net.ParseCIDR(cidr)
+        if err != nil || ipnet.IP.To4() != nil {
+            return fmt.Errorf("invalid delegatedPrefixes allowedFromArgs %q", cidr)
+        }
+        allowed = append(allowed, ipnet)
+    }
+    for _, p := range requested {
+        if !prefixWithin(p, allowed) {
+            return fmt.Errorf("delegated prefix %s is not within delegatedPrefixes allowedFromArgs", p)
+        }
+    }
+    return nil
+}
+
+// addrWithin reports whether ip lies inside one of the prefixes
+func addrWithin(ip net.IP, prefixes []*net.IPNet) bool {
+    for _, p := range prefixes {
+        if p.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// prefixWithin reports whether p lies inside one of the prefixes
+func prefixWithin(p *net.IPNet, prefixes []*net.IPNet) bool {
+    ones, _ := p.Mask.Size()
+    for _, outer := range prefixes {
+        outerOnes, _ := outer.Mask.Size()
+        if outerOnes <= ones && outer.Contains(p.IP) {
+            return true
+        }
+    }
+    return false
+}
+
+// validateTuning checks the tuning block and the runtime's MAC address
+func validateTuning(conf *NetConf) error {
+    if mac := conf.RuntimeConfig.Mac; mac != "" {
+        if _, err := net.ParseMAC(mac); err != nil {
+            return fmt.Errorf("invalid runtimeConfig mac %q: %v", mac, err)
+        }
+    }
+    t := conf.Tuning
+    if t == nil {
+        return nil
+    }
+    if t.Mac != "" {
+        if _, err := net.ParseMAC(t.Mac); err != nil {
+            return fmt.Errorf("invalid tuning mac %q: %v", t.Mac, err)
+        }
+    }
+    if t.MTU < 0 {
+        return fmt.Errorf("invalid tuning mtu %d", t.MTU)
+    }
+    if err := t.Ethtool.validate(); err != nil {
+        return err
+    }
+    if t.Ethtool != nil && !conf.LinkInContainer {
+        return fmt.Errorf("tuning ethtool needs the master in the pod, with linkInContainer or masterFromPrevResult")
+    }
+    for key := range t.Sysctl {
+        if err := CheckSysctl(key); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// CheckSysctl accepts keys of network sysctls only, in either notation.
+// Others are not namespaced and would change the host, and the key becomes
+// a path under /proc/sys, which it must not leave.
+func CheckSysctl(key string) error {
+    name := key
+    // Like sysctl, a dot before any slash makes dots the separator
+    if i := strings.IndexAny(key, "./"); i >= 0 && key[i] == '.' {
+        name = strings.NewReplacer(".", "/", "/", ".").Replace(key)
+    }
+    for _, elem := range strings.Split(name, "/") {
+        if elem == ".." {
+            return fmt.Errorf("tuning sysctl %q leaves /proc/sys", key)
+        }
+    }
+    if !strings.HasPrefix(path.Clean("/"+name), "/net/") {
+        return fmt.Errorf("tuning sysctl %q is not a network sysctl", key)
+    }
+    return nil
+}
+
+// resolveMasters fills in the masters of the network and its attachments
+// from the masters table, by VLAN
+func resolveMasters(conf *NetConf) error {
+    if len(conf.Masters) == 0 {
+        return nil
+    }
+    if conf.Meta != nil {
+        return fmt.Errorf("meta mode cannot be combined with masters")
+    }
+    
+    type entry struct {
+        vlans  VlanSet
+        key    string
+        master string
+    }
+    var table []entry
+    for key, master := range conf.Masters {
+        vlans, err := ParseVlanSet(key)
+        if err != nil {
+            return fmt.Errorf("masters: %v", err)
+        }
+        if master == "" {
+            return fmt.Errorf("masters: %q maps to no interface", key)
+        }
+        for _, e := range table {
+            if e.vlans.Overlaps(vlans) {
+                return fmt.Errorf("masters: %q and %q overlap", e.key, key)
+            }
+        }
+        table = append(table, entry{vlans: vlans, key: key, master: master})
+    }
+    lookup := func(vlanID int) (string, error) {
+        for _, e := range table {
+            if e.vlans.Contains(vlanID) {
+                return e.master, nil
+            }
+        }
+        return "", fmt.Errorf("masters: no master for VLAN %d", vlanID)
+    }
+    
+    var err error
+    if len(conf.Attachments) == 0 && conf.Master == "" {
+        if conf.Master, err = lookup(conf.VlanID); err != nil {
+            return err
+        }
+    }
+    for _, a := range conf.Attachments {
+        if a.Master == "" {
+            if a.Master, err = lookup(a.VlanID); err != nil {
+                return fmt.Errorf("attachment %q: %v", a.IfName, err)
+            }
+        }
+    }
+    return nil
+}
+
+// vlanKeys records which parts of the configuration name a VLAN, since
+// a missing vlan key and vlan 0 (untagged) decode alike
+type vlanKeys struct {
+    top         bool
+    attachments []bool
+}
+
+func findVlanKeys(plain []byte) (*vlanKeys, error) {
+    var doc struct {
+        Vlan        json.RawMessage `json:"vlan"`
+        Attachments []struct {
+            Vlan json.RawMessage `json:"vlan"`
+        } `json:"attachments"`
+    }
+    if err := json.Unmarshal(plain, &doc); err != nil {
+        return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+    }
+    set := func(v json.RawMessage) bool {
+        return len(v) > 0 && string(v) != "null"
+    }
+    
+    keys := &vlanKeys{top: set(doc.Vlan)}
+    for _, a := range doc.Attachments {
+        keys.attachments = append(keys.attachments, set(a.Vlan))
+    }
+    return keys, nil
+}
+
+// validateAttachments checks a multi-NIC configuration
+func validateAttachments(conf *NetConf, keys *vlanKeys) error {
+    if conf.Master != "" || keys.top || conf.IPAMConfig != nil {
+        return fmt.Errorf("attachments cannot be combined with top-level master, vlan or ipam")
+    }
+    
+    seen := map[string]bool{}
+    for i, a := range conf.Attachments {
+        if a.IfName == "" {
+            return fmt.Errorf("attachment ifName is required")
+        }
+        if seen[a.IfName] {
+            return fmt.Errorf("attachment ifName %q is used twice", a.IfName)
+        }
+        seen[a.IfName] = true
+        
+        if a.Master == "" {
+            return fmt.Errorf("attachment %q: master interface name is required", a.IfName)
+        }
+        if !keys.attachments[i] {
+            return fmt.Errorf("attachment %q: vlan is required (0 for untagged)", a.IfName)
+        }
+        if a.VlanID < 0 || a.VlanID > 4094 {
+            return fmt.Errorf("attachment %q: invalid VLAN ID %d", a.IfName, a.VlanID)
+        }
+        switch a.UntaggedMode {
+        case "", UntaggedModeMacvlan, UntaggedModeIPVlan:
+        default:
+            return fmt.Errorf("attachment %q: invalid untaggedMode %q", a.IfName, a.UntaggedMode)
+        }
+        if err := validateIPAM(a.IPAMConfig); err != nil {
+            return fmt.Errorf("attachment %q: %v", a.IfName, err)
+        }
+    }
+    return nil
 }
\ No newline at end of file
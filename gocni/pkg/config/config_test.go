@@ -0,0 +1,147 @@
+package config
+
+import (
+    "strings"
+    "testing"
+)
+
+var benchConf = []byte(`{
+    "cniVersion": "1.0.0",
+    "name": "vlan100",
+    "type": "vlan-cni",
+    "master": "eth0",
+    "vlan": 100,
+    "hostIfNameTemplate": "{{.Master}}.{{.VlanID}}",
+    "ipam": {
+        "type": "host-local",
+        "subnet": "10.100.0.0/24",
+        "routes": [{"dst": "0.0.0.0/0", "metric": 100}]
+    }
+}`)
+
+func BenchmarkParseConfig(b *testing.B) {
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        if _, err := ParseConfig(benchConf); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func TestTuningSysctl(t *testing.T) {
+    tests := []struct {
+        key string
+        ok  bool
+    }{
+        {"net.ipv4.conf.IFNAME.arp_notify", true},
+        {"net/ipv4/conf/IFNAME/arp_notify", true},
+        {"net/ipv6/conf/eth0.100/disable_ipv6", true},
+        {"kernel.pid_max", false},
+        {"net", false},
+        {"net/../../../etc/cron.d/x", false},
+        {"net/ipv4/../../kernel/pid_max", false},
+        {"net.//.//.etc.cron/d.x", false},
+    }
+    for _, tt := range tests {
+        conf := strings.Replace(string(benchConf), `"ipam"`, `"tuning": {"sysctl": {"`+tt.key+`": "1"}}, "ipam"`, 1)
+        _, err := ParseConfig([]byte(conf))
+        if (err == nil) != tt.ok {
+            t.Errorf("sysctl %q: got error %v, want ok %v", tt.key, err, tt.ok)
+        }
+    }
+}
+
+func TestTuningEthtool(t *testing.T) {
+    tests := []struct {
+        name    string
+        replace string
+        ok      bool
+    }{
+        {"host master", `"master": "eth0"`, false},
+        {"linkInContainer", `"master": "eth0", "linkInContainer": true`, true},
+        {"masterFromPrevResult", `"masterFromPrevResult": true`, true},
+    }
+    for _, tt := range tests {
+        conf := strings.Replace(string(benchConf), `"master": "eth0"`, tt.replace, 1)
+        conf = strings.Replace(conf, `"ipam"`, `"tuning": {"ethtool": {"rxRing": 4096}}, "ipam"`, 1)
+        _, err := ParseConfig([]byte(conf))
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: got error %v, want ok %v", tt.name, err, tt.ok)
+        }
+    }
+}
+
+func TestArgsSecondaryIPs(t *testing.T) {
+    tests := []struct {
+        name    string
+        allowed string
+        ok      bool
+    }{
+        {"no allowlist", ``, false},
+        {"within", `"secondaryIPsAllowedFromArgs": ["10.100.0.128/25"],`, true},
+        {"outside", `"secondaryIPsAllowedFromArgs": ["10.100.1.0/24"],`, false},
+    }
+    for _, tt := range tests {
+        conf := strings.Replace(string(benchConf), `"ipam"`, tt.allowed+` "args": {"cni": {"secondaryIPs": ["10.100.0.200/24"]}}, "ipam"`, 1)
+        _, err := ParseConfig([]byte(conf))
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: got error %v, want ok %v", tt.name, err, tt.ok)
+        }
+    }
+}
+
+func TestArpModeNeedsIPv4(t *testing.T) {
+    tests := []struct {
+        name    string
+        replace string
+        ok      bool
+    }{
+        {"IPv4 enabled", `"arpMode": "strict",`, true},
+        {"IPv4 disabled", `"arpMode": "strict", "enableIPv4": false,`, false},
+        {"IPv4 disabled without arpMode", `"enableIPv4": false,`, true},
+    }
+    for _, tt := range tests {
+        conf := strings.Replace(string(benchConf), `"ipam"`, tt.replace+` "ipam"`, 1)
+        conf = strings.Replace(conf, `"subnet": "10.100.0.0/24",`, `"subnet": "fd00:100::/64",`, 1)
+        conf = strings.Replace(conf, `"0.0.0.0/0"`, `"::/0"`, 1)
+        _, err := ParseConfig([]byte(conf))
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: got error %v, want ok %v", tt.name, err, tt.ok)
+        }
+    }
+}
+
+func TestVlanRequired(t *testing.T) {
+    attachments := `"attachments": [{"ifName": "net1", "master": "eth0", "vlan": 100}, {"ifName": "net2", "master": "eth0", "vlan": 0}],`
+    tests := []struct {
+        name    string
+        replace string
+        ok      bool
+        vlan    int
+    }{
+        {"tagged", `"vlan": 100,`, true, 100},
+        {"untagged", `"vlan": 0,`, true, 0},
+        {"missing", ``, false, 0},
+        {"null", `"vlan": null,`, false, 0},
+        {"upstream vlanId", `"vlanId": 0,`, true, 0},
+        {"vlanId contradicts vlan", `"vlan": 0, "vlanId": 100,`, false, 0},
+        {"attachments", attachments, true, 0},
+        {"attachment missing vlan", strings.Replace(attachments, `, "vlan": 0`, ``, 1), false, 0},
+        {"attachments with top-level vlan 0", `"vlan": 0, ` + attachments, false, 0},
+    }
+    for _, tt := range tests {
+        conf := strings.Replace(string(benchConf), `"vlan": 100,`, tt.replace, 1)
+        if strings.Contains(tt.replace, "attachments") {
+            conf = strings.Replace(conf, `"master": "eth0",`, ``, 1)
+            conf = conf[:strings.Index(conf, `"hostIfNameTemplate"`)] + `"hostIfNameTemplate": "{{.Master}}.{{.VlanID}}"}`
+        }
+        c, err := ParseConfig([]byte(conf))
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: got error %v, want ok %v", tt.name, err, tt.ok)
+            continue
+        }
+        if err == nil && c.VlanID != tt.vlan {
+            t.Errorf("%s: got VLAN %d, want %d", tt.name, c.VlanID, tt.vlan)
+        }
+    }
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+)
+
+// runtimeKeys are filled in per invocation by the runtime and Multus, or
+// rewritten by vlan-cni-conf upgrade, rather than by the network's
+// definition
+var runtimeKeys = []string{"args", "runtimeConfig", "prevResult", "cniVersion"}
+
+// Hash identifies the definition a plugin configuration came from. Keys
+// are sorted by encoding/json, so the node daemon arrives at the same hash
+// from a conflist's plugin entry with the network's name added.
+func Hash(data []byte) (string, error) {
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return "", err
+    }
+    for _, key := range runtimeKeys {
+        delete(doc, key)
+    }
+    canonical, err := json.Marshal(doc)
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256(canonical)
+    return hex.EncodeToString(sum[:8]), nil
+}
+
+// Hash returns the hash of the configuration as the runtime passed it
+func (c *NetConf) Hash() string {
+    return c.hash
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "text/template"
+)
+
+// NodeValues are what templated configuration values see of the node
+type NodeValues struct {
+    Name        string            `json:"name"`
+    Labels      map[string]string `json:"labels,omitempty"`
+    Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// NodeValuesFunc looks up the node's values for a configuration with the
+// given stateDir and kubeconfig, as they appear in it
+type NodeValuesFunc func(stateDir, kubeconfig string) (*NodeValues, error)
+
+// numericTemplateKeys hold numbers, so their rendered values are decoded as
+// such: "mtu": "{{ label \"vlan-cni.io/mtu\" }}" becomes "mtu": 9000. vlanId
+// is the upstream vlan plugin's name for vlan.
+var numericTemplateKeys = map[string]bool{"mtu": true, "vlan": true, "vlanId": true}
+
+// ParseConfigForNode parses the configuration like ParseConfig, after
+// rendering string values holding templates with the node's labels and
+// annotations, so one configuration fits nodes with different hardware:
+//
+//	"master": "{{ label \"vlan-cni.io/uplink\" | default \"eth1\" }}"
+//
+// Templates calling label or annotation may appear in any string, the IPAM
+// section included. node is only called when the configuration has them.
+func ParseConfigForNode(bytes []byte, node NodeValuesFunc) (*NetConf, error) {
+    return parseConfig(bytes, node)
+}
+
+// nodeTemplate matches templates calling the node functions. Templates
+// without them, such as hostIfNameTemplate, are rendered per pod later.
+var nodeTemplate = regexp.MustCompile(`\{\{[^}]*\b(label|annotation)\b`)
+
+// hasNodeTemplates reports whether the configuration needs node values
+func hasNodeTemplates(data []byte) bool {
+    return nodeTemplate.Match(data)
+}
+
+// renderNodeTemplates renders the templated values of the configuration
+func renderNodeTemplates(data []byte, node NodeValuesFunc) ([]byte, error) {
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+    }
+    if node == nil {
+        return nil, fmt.Errorf("configuration has node templates, but no node values are available here")
+    }
+    
+    stateDir, _ := doc["stateDir"].(string)
+    kubeconfig, _ := doc["kubeconfig"].(string)
+    values, err := node(stateDir, kubeconfig)
+    if err != nil {
+        return nil, fmt.Errorf("failed to look up node values: %v", err)
+    }
+    
+    rendered, err := renderValue("", doc, values)
+    if err != nil {
+        return nil, err
+    }
+    return json.Marshal(rendered)
+}
+
+// renderValue renders the strings in v, key is the field holding it
+func renderValue(key string, v interface{}, node *NodeValues) (interface{}, error) {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for k, item := range v {
+            r, err := renderValue(k, item, node)
+            if err != nil {
+                return nil, err
+            }
+            v[k] = r
+        }
+        return v, nil
+    case []interface{}:
+        for i, item := range v {
+            r, err := renderValue(key, item, node)
+            if err != nil {
+                return nil, err
+            }
+            v[i] = r
+        }
+        return v, nil
+    case string:
+        if !nodeTemplate.MatchString(v) {
+            return v, nil
+        }
+        s, err := renderNodeTemplate(key, v, node)
+        if err != nil {
+            return nil, err
+        }
+        if numericTemplateKeys[key] {
+            n, err := strconv.Atoi(s)
+            if err != nil {
+                return nil, fmt.Errorf("%s %q rendered %q on node %s, which is not a number", key, v, s, node.Name)
+            }
+            return n, nil
+        }
+        return s, nil
+    }
+    return v, nil
+}
+
+// renderNodeTemplate executes one templated value. Missing labels and
+// annotations render empty, and an empty result is an error unless a
+// default covers it.
+func renderNodeTemplate(key, tmpl string, node *NodeValues) (string, error) {
+    funcs := template.FuncMap{
+        "label":      func(name string) string { return node.Labels[name] },
+        "annotation": func(name string) string { return node.Annotations[name] },
+        "default": func(def, value string) string {
+            if value == "" {
+                return def
+            }
+            return value
+        },
+    }
+    t, err := template.New(key).Funcs(funcs).Option("missingkey=error").Parse(tmpl)
+    if err != nil {
+        return "", fmt.Errorf("invalid template %q in %s: %v", tmpl, key, err)
+    }
+    
+    var buf bytes.Buffer
+    if err := t.Execute(&buf, node); err != nil {
+        return "", fmt.Errorf("failed to render %s %q: %v", key, tmpl, err)
+    }
+    s := strings.TrimSpace(buf.String())
+    if s == "" {
+        return "", fmt.Errorf("%s %q rendered empty on node %s", key, tmpl, node.Name)
+    }
+    return s, nil
+}
@@ -0,0 +1,313 @@
+package config
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/sha512"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "hash"
+    "io"
+    "io/ioutil"
+    "os"
+    "strconv"
+    "strings"
+
+    "filippo.io/age"
+    "filippo.io/age/armor"
+)
+
+// DefaultAgeKeyFile holds the node's age identities used to decrypt
+// sops-encrypted configuration
+const DefaultAgeKeyFile = "/etc/vlan-cni/age.key"
+
+// sopsMetadataKey is where sops keeps the encrypted data key
+const sopsMetadataKey = "sops"
+
+// sopsMACOnlyEncryptedInit seeds the MAC of files encrypted with
+// mac_only_encrypted, as sops does
+var sopsMACOnlyEncryptedInit = []byte{
+    0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0x0b,
+    0x0b, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69,
+}
+
+// sopsMetadata is the subset of the sops metadata needed to decrypt with age
+type sopsMetadata struct {
+    Age []struct {
+        Recipient string `json:"recipient"`
+        Enc       string `json:"enc"`
+    } `json:"age"`
+    LastModified     string `json:"lastmodified"`
+    MAC              string `json:"mac"`
+    MACOnlyEncrypted bool   `json:"mac_only_encrypted"`
+}
+
+// decryptSOPS returns the configuration with its sops-encrypted values
+// decrypted and the sops metadata removed, or data itself when it is not
+// encrypted. The runtime adds name, cniVersion and the like to every
+// plugin's configuration, so files encrypted without mac_only_encrypted
+// only verify when they already carry those fields.
+//
+// Fields the runtime reads, such as type, must stay in the clear; encrypt
+// with an --encrypted-regex that leaves them out. Only .conf files can be
+// encrypted: the runtime hands each plugin of a conflist its own entry,
+// without the list's sops metadata.
+func decryptSOPS(data []byte) ([]byte, error) {
+    var doc map[string]json.RawMessage
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+    }
+    raw, ok := doc[sopsMetadataKey]
+    if !ok {
+        if bytes.Contains(data, []byte(`"ENC[AES256_GCM,`)) {
+            return nil, fmt.Errorf("sops: encrypted values without sops metadata, only .conf files can be encrypted, not conflists")
+        }
+        return data, nil
+    }
+    
+    meta := &sopsMetadata{}
+    if err := json.Unmarshal(raw, meta); err != nil {
+        return nil, fmt.Errorf("sops: invalid metadata: %v", err)
+    }
+    key, err := sopsDataKey(meta)
+    if err != nil {
+        return nil, err
+    }
+    
+    d := &sopsDecrypter{
+        key:              key,
+        dec:              json.NewDecoder(bytes.NewReader(data)),
+        mac:              sha512.New(),
+        macOnlyEncrypted: meta.MACOnlyEncrypted,
+    }
+    if meta.MACOnlyEncrypted {
+        d.mac.Write(sopsMACOnlyEncryptedInit)
+    }
+    d.dec.UseNumber()
+    if err := d.value(nil, true); err != nil {
+        return nil, err
+    }
+    
+    // The MAC covers the plaintext values, catching tampering with any of
+    // them, encrypted or not
+    if meta.MAC == "" {
+        return nil, fmt.Errorf("sops: metadata has no mac")
+    }
+    mac, _, err := d.decrypt(meta.MAC, meta.LastModified)
+    if err != nil {
+        return nil, fmt.Errorf("sops: failed to decrypt mac: %v", err)
+    }
+    if !strings.EqualFold(mac, fmt.Sprintf("%X", d.mac.Sum(nil))) {
+        return nil, fmt.Errorf("sops: mac mismatch, the configuration was modified after encryption")
+    }
+    return d.out.Bytes(), nil
+}
+
+// sopsDataKey decrypts the data key with the node's age identities
+func sopsDataKey(meta *sopsMetadata) ([]byte, error) {
+    path := DefaultAgeKeyFile
+    if p := os.Getenv("SOPS_AGE_KEY_FILE"); p != "" {
+        path = p
+    }
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("sops: failed to open age key: %v", err)
+    }
+    defer f.Close()
+    identities, err := age.ParseIdentities(f)
+    if err != nil {
+        return nil, fmt.Errorf("sops: invalid age key %s: %v", path, err)
+    }
+    
+    for _, r := range meta.Age {
+        plain, err := age.Decrypt(armor.NewReader(strings.NewReader(r.Enc)), identities...)
+        if err != nil {
+            continue
+        }
+        key, err := ioutil.ReadAll(plain)
+        if err != nil {
+            return nil, fmt.Errorf("sops: failed to read data key: %v", err)
+        }
+        return key, nil
+    }
+    return nil, fmt.Errorf("sops: no age recipient matches the key in %s", path)
+}
+
+// sopsDecrypter copies a JSON document token by token, decrypting values
+// and hashing them in document order the way sops does
+type sopsDecrypter struct {
+    key              []byte
+    dec              *json.Decoder
+    out              bytes.Buffer
+    mac              hash.Hash
+    macOnlyEncrypted bool
+}
+
+// value copies the next value. Values are authenticated with the path of
+// keys leading to them; array items share the path of their array.
+func (d *sopsDecrypter) value(path []string, top bool) error {
+    tok, err := d.dec.Token()
+    if err != nil {
+        return fmt.Errorf("sops: failed to parse configuration: %v", err)
+    }
+    
+    switch t := tok.(type) {
+    case json.Delim:
+        if t == '[' {
+            d.out.WriteByte('[')
+            for i := 0; d.dec.More(); i++ {
+                if i > 0 {
+                    d.out.WriteByte(',')
+                }
+                if err := d.value(path, false); err != nil {
+                    return err
+                }
+            }
+            d.dec.Token()
+            d.out.WriteByte(']')
+            return nil
+        }
+        
+        d.out.WriteByte('{')
+        for first := true; d.dec.More(); {
+            tok, err := d.dec.Token()
+            if err != nil {
+                return fmt.Errorf("sops: failed to parse configuration: %v", err)
+            }
+            name := tok.(string)
+            if top && name == sopsMetadataKey {
+                var skip json.RawMessage
+                if err := d.dec.Decode(&skip); err != nil {
+                    return fmt.Errorf("sops: failed to parse configuration: %v", err)
+                }
+                continue
+            }
+            if !first {
+                d.out.WriteByte(',')
+            }
+            first = false
+            encoded, _ := json.Marshal(name)
+            d.out.Write(encoded)
+            d.out.WriteByte(':')
+            if err := d.value(append(path[:len(path):len(path)], name), false); err != nil {
+                return err
+            }
+        }
+        d.dec.Token()
+        d.out.WriteByte('}')
+        return nil
+    
+    case string:
+        if !strings.HasPrefix(t, "ENC[") {
+            d.hash(t, false)
+            encoded, _ := json.Marshal(t)
+            d.out.Write(encoded)
+            return nil
+        }
+        plain, typ, err := d.decrypt(t, strings.Join(path, ":")+":")
+        if err != nil {
+            return fmt.Errorf("sops: failed to decrypt %s: %v", strings.Join(path, "."), err)
+        }
+        switch typ {
+        case "int":
+            i, err := strconv.Atoi(plain)
+            if err != nil {
+                return fmt.Errorf("sops: %s is not an integer", strings.Join(path, "."))
+            }
+            d.hash(strconv.Itoa(i), true)
+            d.out.WriteString(strconv.Itoa(i))
+        case "float":
+            f, err := strconv.ParseFloat(plain, 64)
+            if err != nil {
+                return fmt.Errorf("sops: %s is not a number", strings.Join(path, "."))
+            }
+            d.hash(strconv.FormatFloat(f, 'f', -1, 64), true)
+            d.out.WriteString(plain)
+        case "bool":
+            b, err := strconv.ParseBool(plain)
+            if err != nil {
+                return fmt.Errorf("sops: %s is not a bool", strings.Join(path, "."))
+            }
+            d.hash(sopsBool(b), true)
+            d.out.WriteString(strconv.FormatBool(b))
+        default:
+            d.hash(plain, true)
+            encoded, _ := json.Marshal(plain)
+            d.out.Write(encoded)
+        }
+        return nil
+    
+    case json.Number:
+        // sops reads JSON numbers as float64
+        if f, err := t.Float64(); err == nil {
+            d.hash(strconv.FormatFloat(f, 'f', -1, 64), false)
+        }
+        d.out.WriteString(t.String())
+        return nil
+    
+    case bool:
+        d.hash(sopsBool(t), false)
+        d.out.WriteString(strconv.FormatBool(t))
+        return nil
+    }
+    
+    // null, which sops neither encrypts nor hashes
+    d.out.WriteString("null")
+    return nil
+}
+
+// sopsBool is how sops hashes booleans
+func sopsBool(b bool) string {
+    if b {
+        return "True"
+    }
+    return "False"
+}
+
+func (d *sopsDecrypter) hash(plain string, encrypted bool) {
+    if encrypted || !d.macOnlyEncrypted {
+        io.WriteString(d.mac, plain)
+    }
+}
+
+// decrypt opens an ENC[AES256_GCM,data:...,iv:...,tag:...,type:...] value
+func (d *sopsDecrypter) decrypt(value, additionalData string) (string, string, error) {
+    if !strings.HasPrefix(value, "ENC[AES256_GCM,") || !strings.HasSuffix(value, "]") {
+        return "", "", fmt.Errorf("unsupported encrypted value")
+    }
+    fields := map[string]string{}
+    for _, part := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(value, "ENC[AES256_GCM,"), "]"), ",") {
+        if k, v, ok := strings.Cut(part, ":"); ok {
+            fields[k] = v
+        }
+    }
+    
+    var raw [3][]byte
+    for i, k := range []string{"data", "iv", "tag"} {
+        b, err := base64.StdEncoding.DecodeString(fields[k])
+        if err != nil {
+            return "", "", fmt.Errorf("invalid %s: %v", k, err)
+        }
+        raw[i] = b
+    }
+    data, iv, tag := raw[0], raw[1], raw[2]
+    if len(iv) == 0 {
+        return "", "", fmt.Errorf("invalid iv")
+    }
+    
+    block, err := aes.NewCipher(d.key)
+    if err != nil {
+        return "", "", err
+    }
+    gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+    if err != nil {
+        return "", "", err
+    }
+    plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+    if err != nil {
+        return "", "", err
+    }
+    return string(plain), fields["type"], nil
+}
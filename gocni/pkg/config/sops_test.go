@@ -0,0 +1,175 @@
+package config
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// The fixtures are encrypted the way sops 3.8 encrypts JSON with age and
+// --encrypted-regex '^(password|username|secrets|vlan)$', to the key in
+// testdata/sops-age.key
+var sopsPlain = `{
+    "cniVersion": "1.0.0",
+    "name": "storage",
+    "type": "vlan-cni",
+    "master": "eth0",
+    "vlan": 120,
+    "ipam": {
+        "type": "infoblox",
+        "url": "https://infoblox.example.com/wapi/v2.12",
+        "username": "cni",
+        "password": "s3cr3t-pa55",
+        "networkView": "default",
+        "network": "10.120.0.0/24",
+        "insecureSkipVerify": false,
+        "timeoutSeconds": 5,
+        "secrets": ["alpha", "beta"]
+    }
+}`
+
+func readFixture(t *testing.T, name string) string {
+    t.Helper()
+    data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(data)
+}
+
+func TestDecryptSOPS(t *testing.T) {
+    t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("testdata", "sops-age.key"))
+    var want interface{}
+    if err := json.Unmarshal([]byte(sopsPlain), &want); err != nil {
+        t.Fatal(err)
+    }
+    
+    tests := []struct {
+        name    string
+        fixture string
+        edit    func(string) string
+        err     string
+    }{
+        {"golden", "sops.json", nil, ""},
+        {"golden mac_only_encrypted", "sops-mac-only-encrypted.json", nil, ""},
+        
+        // Without mac_only_encrypted every value is covered, so the
+        // runtime's additions must already be there
+        {"added clear field", "sops.json", addField, "mac mismatch"},
+        {"added clear field, mac_only_encrypted", "sops-mac-only-encrypted.json", addField, ""},
+        
+        {"changed clear value", "sops.json", changeMaster, "mac mismatch"},
+        {"changed clear value, mac_only_encrypted", "sops-mac-only-encrypted.json", changeMaster, ""},
+        {"swapped encrypted values", "sops-mac-only-encrypted.json", swapSecrets, "mac mismatch"},
+        {"moved encrypted value", "sops.json", moveUsername, "failed to decrypt"},
+        {"tampered mac", "sops.json", tamperMAC, "failed to decrypt mac"},
+        {"no mac", "sops.json", dropMAC, "no mac"},
+    }
+    for _, tt := range tests {
+        data := readFixture(t, tt.fixture)
+        if tt.edit != nil {
+            edited := tt.edit(data)
+            if edited == data {
+                t.Fatalf("%s: edit changed nothing", tt.name)
+            }
+            data = edited
+        }
+        plain, err := decryptSOPS([]byte(data))
+        if tt.err != "" {
+            if err == nil || !strings.Contains(err.Error(), tt.err) {
+                t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if tt.edit != nil {
+            continue
+        }
+        var got interface{}
+        if err := json.Unmarshal(plain, &got); err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: decrypted to %s", tt.name, plain)
+        }
+    }
+}
+
+func TestDecryptSOPSWrongKey(t *testing.T) {
+    t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("testdata", "sops-other-age.key"))
+    _, err := decryptSOPS([]byte(readFixture(t, "sops.json")))
+    if err == nil || !strings.Contains(err.Error(), "no age recipient matches") {
+        t.Errorf("got error %v, want no matching recipient", err)
+    }
+}
+
+func TestParseConfigSOPS(t *testing.T) {
+    t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("testdata", "sops-age.key"))
+    conf, err := ParseConfig([]byte(readFixture(t, "sops-mac-only-encrypted.json")))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if conf.VlanID != 120 {
+        t.Errorf("vlan = %d, want 120", conf.VlanID)
+    }
+    if !strings.Contains(string(conf.IPAMConfig.Raw()), `"s3cr3t-pa55"`) {
+        t.Errorf("ipam section not decrypted: %s", conf.IPAMConfig.Raw())
+    }
+    if conf.Decrypted() == nil {
+        t.Error("no decrypted configuration for IPAM plugins")
+    }
+}
+
+func addField(data string) string {
+    return strings.Replace(data, `"name": "storage",`, `"name": "storage", "runtimeConfig": {"mac": "02:00:00:00:00:01"},`, 1)
+}
+
+func changeMaster(data string) string {
+    return strings.Replace(data, `"master": "eth0"`, `"master": "eth1"`, 1)
+}
+
+// swapSecrets exchanges the two encrypted array items, which share their
+// path and so still decrypt
+func swapSecrets(data string) string {
+    i := strings.Index(data, `"secrets": [`)
+    lines := strings.Split(data[i:], "\n")
+    a, b := strings.TrimSuffix(strings.TrimSpace(lines[1]), ","), strings.TrimSpace(lines[2])
+    return data[:i] + strings.Replace(strings.Replace(strings.Replace(data[i:], a, "SWAP", 1), b, a, 1), "SWAP", b, 1)
+}
+
+// moveUsername puts the username's ciphertext under password, whose path
+// it was not encrypted with
+func moveUsername(data string) string {
+    var user, pass string
+    for _, line := range strings.Split(data, "\n") {
+        line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+        if strings.HasPrefix(line, `"username": `) {
+            user = strings.TrimPrefix(line, `"username": `)
+        }
+        if strings.HasPrefix(line, `"password": `) {
+            pass = strings.TrimPrefix(line, `"password": `)
+        }
+    }
+    return strings.Replace(data, pass, user, 1)
+}
+
+func tamperMAC(data string) string {
+    i := strings.Index(data, `"mac": "ENC[AES256_GCM,data:`) + len(`"mac": "ENC[AES256_GCM,data:`)
+    c := "A"
+    if data[i] == 'A' {
+        c = "B"
+    }
+    return data[:i] + c + data[i+1:]
+}
+
+func dropMAC(data string) string {
+    i := strings.Index(data, `"mac": "ENC[`)
+    j := strings.Index(data[i:], "\n")
+    return data[:i] + `"mac": "",` + data[i+j:]
+}
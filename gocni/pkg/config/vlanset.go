@@ -0,0 +1,62 @@
+package config
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// DefaultVlanAllowlistFile lists the VLAN IDs the plugin may use on a
+// node, such as "100,200-299"; without it every VLAN is allowed
+const DefaultVlanAllowlistFile = "/etc/vlan-cni/allowed-vlans"
+
+// VlanSet is a set of VLAN IDs, 0 standing for untagged attachments
+type VlanSet [][2]int
+
+// ParseVlanSet reads IDs and inclusive ranges separated by commas or
+// whitespace. Lines starting with # are comments.
+func ParseVlanSet(s string) (VlanSet, error) {
+    var set VlanSet
+    for _, line := range strings.Split(s, "\n") {
+        if i := strings.Index(line, "#"); i >= 0 {
+            line = line[:i]
+        }
+        for _, item := range strings.FieldsFunc(line, func(r rune) bool {
+            return r == ',' || r == ' ' || r == '\t' || r == '\r'
+        }) {
+            lo, hi, isRange := strings.Cut(item, "-")
+            if !isRange {
+                hi = lo
+            }
+            first, err1 := strconv.Atoi(lo)
+            last, err2 := strconv.Atoi(hi)
+            if err1 != nil || err2 != nil || first < 0 || last > 4094 || first > last {
+                return nil, fmt.Errorf("invalid VLAN or range %q", item)
+            }
+            set = append(set, [2]int{first, last})
+        }
+    }
+    return set, nil
+}
+
+// Contains reports whether id is in the set
+func (s VlanSet) Contains(id int) bool {
+    for _, r := range s {
+        if id >= r[0] && id <= r[1] {
+            return true
+        }
+    }
+    return false
+}
+
+// Overlaps reports whether the sets share a VLAN
+func (s VlanSet) Overlaps(o VlanSet) bool {
+    for _, a := range s {
+        for _, b := range o {
+            if a[0] <= b[1] && b[0] <= a[1] {
+                return true
+            }
+        }
+    }
+    return false
+}
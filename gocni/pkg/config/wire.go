@@ -0,0 +1,51 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+)
+
+// wireConf carries a parsed configuration from the privsep worker, which
+// parses it, to the privileged process, which acts on it without parsing
+// the runtime's input itself
+type wireConf struct {
+    Conf             json.RawMessage `json:"conf"`
+    VersionDefaulted bool            `json:"versionDefaulted,omitempty"`
+    CompatWarnings   []string        `json:"compatWarnings,omitempty"`
+    Decrypted        []byte          `json:"decrypted,omitempty"`
+    Hash             string          `json:"hash"`
+}
+
+// MarshalWire encodes the parsed configuration, including what parsing
+// derived beyond its JSON
+func (c *NetConf) MarshalWire() ([]byte, error) {
+    data, err := json.Marshal(c)
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode network configuration: %v", err)
+    }
+    return json.Marshal(wireConf{
+        Conf:             data,
+        VersionDefaulted: c.versionDefaulted,
+        CompatWarnings:   c.compatWarnings,
+        Decrypted:        c.decrypted,
+        Hash:             c.hash,
+    })
+}
+
+// UnmarshalWire decodes a configuration encoded by MarshalWire. It does not
+// validate it again, that was done by the parser.
+func UnmarshalWire(data []byte) (*NetConf, error) {
+    var w wireConf
+    if err := json.Unmarshal(data, &w); err != nil {
+        return nil, fmt.Errorf("failed to decode network configuration: %v", err)
+    }
+    conf := &NetConf{}
+    if err := json.Unmarshal(w.Conf, conf); err != nil {
+        return nil, fmt.Errorf("failed to decode network configuration: %v", err)
+    }
+    conf.versionDefaulted = w.VersionDefaulted
+    conf.compatWarnings = w.CompatWarnings
+    conf.decrypted = w.Decrypted
+    conf.hash = w.Hash
+    return conf, nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+    "bytes"
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestWireRoundTrip(t *testing.T) {
+    var compact bytes.Buffer
+    if err := json.Compact(&compact, benchConf); err != nil {
+        t.Fatal(err)
+    }
+    base := compact.String()
+    
+    tests := []struct {
+        name string
+        conf string
+    }{
+        {"plain", base},
+        {"defaulted version", strings.Replace(base, `"cniVersion":"1.0.0",`, ``, 1)},
+        {"upstream vlanId", strings.Replace(base, `"vlan":100`, `"vlanId":100`, 1)},
+        {"masters table", strings.Replace(base, `"master":"eth0",`, `"masters":{"100-199":"eth1"},`, 1)},
+        {"attachments", `{"cniVersion":"1.0.0","name":"multi","type":"vlan-cni","attachments":[{"ifName":"net1","master":"eth0","vlan":100,"ipam":{"type":"host-local","subnet":"10.100.0.0/24"}},{"ifName":"net2","master":"eth0","vlan":200,"ipam":{"type":"host-local","subnet":"10.200.0.0/24"}}]}`},
+    }
+    for _, tt := range tests {
+        conf, err := ParseConfig([]byte(tt.conf))
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        data, err := conf.MarshalWire()
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        back, err := UnmarshalWire(data)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        if !reflect.DeepEqual(conf, back) {
+            t.Errorf("%s: round trip changed the configuration\n got %+v\nwant %+v", tt.name, back, conf)
+        }
+        if back.Hash() != conf.Hash() || back.VersionWarning() != conf.VersionWarning() || back.CompatWarning() != conf.CompatWarning() {
+            t.Errorf("%s: round trip lost derived values", tt.name)
+        }
+    }
+}
@@ -0,0 +1,75 @@
+// Package cri irons out how container runtimes call CNI plugins, so the
+// plugin behaves alike under the containerd embedded in k3s and CRI-O:
+//
+//   - netns paths: containerd passes /var/run/netns/cni-<uuid>, CRI-O
+//     /var/run/netns/<uuid> or /run/netns/<uuid>, and /var/run is usually a
+//     link to /run
+//   - repeated DEL: containerd sends one on StopPodSandbox and another on
+//     RemovePodSandbox, and both runtimes retry failed ones
+//   - DEL without CNI_NETNS, or with the path of a namespace already
+//     removed, once the sandbox is gone
+package cri
+
+import (
+    "os"
+    "path/filepath"
+    "time"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// RepeatWindow is how long a finished DEL answers repeats of itself
+const RepeatWindow = 10 * time.Minute
+
+// Netns returns the canonical form of a netns path: cleaned, with links in
+// its directory resolved, so the paths runtimes pass compare equal
+func Netns(path string) string {
+    if path == "" {
+        return ""
+    }
+    path = filepath.Clean(path)
+    if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+        path = filepath.Join(dir, filepath.Base(path))
+    }
+    return path
+}
+
+// DelNetns returns the netns DEL can enter, or "" when it is gone. The
+// netns recorded at ADD stands in for one the runtime left out.
+func DelNetns(passed, recorded string) string {
+    path := passed
+    if path == "" {
+        path = recorded
+    }
+    if path == "" {
+        return ""
+    }
+    if _, err := os.Stat(path); err != nil {
+        return ""
+    }
+    return Netns(path)
+}
+
+// RepeatedDel reports whether DEL of the attachment finished within
+// RepeatWindow, so this one has nothing left to do
+func RepeatedDel(store *state.Store, containerID, ifName string, now time.Time) (bool, error) {
+    finished, err := store.GetDeletion(containerID, ifName)
+    if err != nil {
+        return false, err
+    }
+    return !finished.IsZero() && now.Sub(finished) < RepeatWindow, nil
+}
+
+// FinishDel records a finished DEL, and forgets those past RepeatWindow
+func FinishDel(store *state.Store, containerID, ifName string, now time.Time) error {
+    if err := store.SaveDeletion(containerID, ifName, now); err != nil {
+        return err
+    }
+    return store.PruneDeletions(now.Add(-RepeatWindow))
+}
+
+// StartAdd forgets a DEL of the attachment, so a runtime reusing the
+// container ID gets a full DEL again
+func StartAdd(store *state.Store, containerID, ifName string) error {
+    return store.ForgetDeletion(containerID, ifName)
+}
@@ -0,0 +1,130 @@
+package cri
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// TestNetns checks that the paths containerd and CRI-O pass for a netns
+// compare equal once canonical, /var/run being a link to /run
+func TestNetns(t *testing.T) {
+    root := t.TempDir()
+    run := filepath.Join(root, "run", "netns")
+    if err := os.MkdirAll(run, 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.MkdirAll(filepath.Join(root, "var"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Symlink(filepath.Join(root, "run"), filepath.Join(root, "var", "run")); err != nil {
+        t.Fatal(err)
+    }
+    want := filepath.Join(run, "cni-1234")
+    
+    for _, path := range []string{
+        want,
+        filepath.Join(root, "var", "run", "netns", "cni-1234"),
+        filepath.Join(root, "var", "run", "netns", "", "cni-1234"),
+        filepath.Join(root, "run", "netns", "..", "netns", "cni-1234"),
+    } {
+        if got := Netns(path); got != want {
+            t.Errorf("Netns(%q) = %q, want %q", path, got, want)
+        }
+    }
+    if got := Netns(""); got != "" {
+        t.Errorf("Netns(\"\") = %q, want \"\"", got)
+    }
+}
+
+func TestDelNetns(t *testing.T) {
+    dir := t.TempDir()
+    present := filepath.Join(dir, "present")
+    if err := ioutil.WriteFile(present, nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    gone := filepath.Join(dir, "gone")
+    
+    for _, tc := range []struct {
+        name             string
+        passed, recorded string
+        want             string
+    }{
+        {name: "containerd", passed: present, recorded: present, want: present},
+        {name: "crio without netns", passed: "", recorded: present, want: present},
+        {name: "netns removed", passed: gone, recorded: gone, want: ""},
+        {name: "passed netns removed", passed: gone, recorded: present, want: ""},
+        {name: "recorded netns removed", passed: "", recorded: gone, want: ""},
+        {name: "nothing recorded", passed: "", recorded: "", want: ""},
+    } {
+        if got := DelNetns(tc.passed, tc.recorded); got != tc.want {
+            t.Errorf("%s: DelNetns(%q, %q) = %q, want %q", tc.name, tc.passed, tc.recorded, got, tc.want)
+        }
+    }
+}
+
+// TestRepeatedDel walks the DELs containerd sends on StopPodSandbox and
+// RemovePodSandbox, and a retried one from CRI-O past RepeatWindow
+func TestRepeatedDel(t *testing.T) {
+    store, err := state.NewStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now()
+    
+    repeatedAt := func(at time.Time) bool {
+        t.Helper()
+        repeated, err := RepeatedDel(store, "c1", "net1", at)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return repeated
+    }
+    if repeatedAt(now) {
+        t.Fatal("first DEL reported as repeated")
+    }
+    if err := FinishDel(store, "c1", "net1", now); err != nil {
+        t.Fatal(err)
+    }
+    if !repeatedAt(now.Add(time.Second)) {
+        t.Error("second DEL not reported as repeated")
+    }
+    if repeated, err := RepeatedDel(store, "c1", "net2", now); err != nil || repeated {
+        t.Errorf("DEL of another interface reported as repeated: %v", err)
+    }
+    if repeatedAt(now.Add(RepeatWindow)) {
+        t.Error("DEL past RepeatWindow reported as repeated")
+    }
+    
+    // A container ID reused by ADD gets a full DEL again
+    if err := StartAdd(store, "c1", "net1"); err != nil {
+        t.Fatal(err)
+    }
+    if repeatedAt(now.Add(time.Second)) {
+        t.Error("DEL after ADD reported as repeated")
+    }
+}
+
+func TestFinishDelPrunes(t *testing.T) {
+    store, err := state.NewStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now()
+    if err := FinishDel(store, "old", "net1", now.Add(-2*RepeatWindow)); err != nil {
+        t.Fatal(err)
+    }
+    if err := FinishDel(store, "new", "net1", now); err != nil {
+        t.Fatal(err)
+    }
+    if finished, err := store.GetDeletion("old", "net1"); err != nil || !finished.IsZero() {
+        t.Errorf("deletion past RepeatWindow kept: %v %v", finished, err)
+    }
+    if finished, err := store.GetDeletion("new", "net1"); err != nil || finished.IsZero() {
+        t.Errorf("recent deletion pruned: %v", err)
+    }
+}
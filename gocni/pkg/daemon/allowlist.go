@@ -0,0 +1,74 @@
+package daemon
+
+import (
+    "context"
+    "log"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// NodeAnnotationAllowedVlans lists the VLAN IDs pods may attach to on a
+// node, such as "100,200-299"
+const NodeAnnotationAllowedVlans = "vlan-cni.io/allowed-vlans"
+
+// allowlistSync copies the node's allowed VLANs annotation into the state
+// directory, where the plugin checks ADDs against it
+type allowlistSync struct {
+    conf     *VlanAllowlistConfig
+    nodeName string
+    client   kubernetes.Interface
+    store    *state.Store
+}
+
+func newAllowlistSync(conf *VlanAllowlistConfig, nodeName string, client kubernetes.Interface, store *state.Store) *allowlistSync {
+    return &allowlistSync{conf: conf, nodeName: nodeName, client: client, store: store}
+}
+
+// run resyncs the allowlist until ctx is done
+func (a *allowlistSync) run(ctx context.Context) {
+    ticker := time.NewTicker(a.conf.Interval.Or(30 * time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := a.sync(ctx); err != nil {
+            log.Printf("vlan allowlist: sync failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// sync records the current annotation. A failed read keeps the last synced
+// list in force; an invalid annotation is recorded anyway, so the plugin
+// refuses every VLAN until it is fixed.
+func (a *allowlistSync) sync(ctx context.Context) error {
+    node, err := a.client.CoreV1().Nodes().Get(ctx, a.nodeName, metav1.GetOptions{})
+    if err != nil {
+        return err
+    }
+    
+    value, ok := node.Annotations[NodeAnnotationAllowedVlans]
+    if !ok {
+        return a.store.SaveVlanAllowlist(nil)
+    }
+    if _, err := config.ParseVlanSet(value); err != nil {
+        log.Printf("vlan allowlist: invalid %s annotation, refusing all VLANs: %v", NodeAnnotationAllowedVlans, err)
+    }
+    
+    current, err := a.store.GetVlanAllowlist()
+    if err != nil {
+        return err
+    }
+    if current != nil && current.VLANs == value {
+        return nil
+    }
+    return a.store.SaveVlanAllowlist(&state.VlanAllowlist{VLANs: value, Updated: time.Now()})
+}
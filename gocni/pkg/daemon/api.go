@@ -0,0 +1,71 @@
+package daemon
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "time"
+)
+
+// apiSocketName is the daemon API socket in the state directory
+const apiSocketName = "daemon.sock"
+
+// apiServer serves node state to local clients over a unix socket
+type apiServer struct {
+    listener net.Listener
+    mux      *http.ServeMux
+    srv      *http.Server
+}
+
+func newAPIServer(path string) (*apiServer, error) {
+    // A socket left by a daemon that did not shut down cleanly
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        return nil, fmt.Errorf("failed to remove stale API socket %q: %v", path, err)
+    }
+    l, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to listen on API socket %q: %v", path, err)
+    }
+    if err := os.Chmod(path, 0600); err != nil {
+        l.Close()
+        return nil, fmt.Errorf("failed to restrict API socket %q: %v", path, err)
+    }
+    
+    mux := http.NewServeMux()
+    return &apiServer{
+        listener: l,
+        mux:      mux,
+        srv:      &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+    }, nil
+}
+
+// handle registers a feature's endpoint
+func (s *apiServer) handle(pattern string, handler http.HandlerFunc) {
+    s.mux.HandleFunc(pattern, handler)
+}
+
+// run serves until ctx is done
+func (s *apiServer) run(ctx context.Context) {
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        _ = s.srv.Shutdown(shutdownCtx)
+    }()
+    
+    log.Printf("api: serving on %s", s.listener.Addr())
+    if err := s.srv.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+        log.Printf("api: server failed: %v", err)
+    }
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        log.Printf("api: failed to write response: %v", err)
+    }
+}
@@ -0,0 +1,284 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "sort"
+    "time"
+
+    api "github.com/osrg/gobgp/v3/api"
+    "github.com/osrg/gobgp/v3/pkg/server"
+    "google.golang.org/protobuf/types/known/anypb"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// bgpSpeaker advertises pod VLAN addresses found in attachment state
+type bgpSpeaker struct {
+    conf   *BGPConfig
+    store  *state.Store
+    server *server.BgpServer
+
+    aggregates []*net.IPNet
+
+    // Readiness of pods, when advertisements are gated on it
+    health *podHealth
+
+    // Routes currently in the RIB, by attachment and prefix
+    advertised map[string]bgpRoute
+
+    // Prefixes last found claimed by more than one attachment, so each
+    // conflict is logged once
+    conflicts map[string]bool
+
+    // Whether the missing nextHopV6 was logged
+    noNextHopV6 bool
+}
+
+// bgpRoute is a prefix and the next hop it is advertised with
+type bgpRoute struct {
+    prefix  *net.IPNet
+    nextHop string
+}
+
+func newBGPSpeaker(conf *BGPConfig, store *state.Store, health *podHealth) (*bgpSpeaker, error) {
+    b := &bgpSpeaker{
+        conf:       conf,
+        store:      store,
+        health:     health,
+        server:     server.NewBgpServer(),
+        advertised: map[string]bgpRoute{},
+        conflicts:  map[string]bool{},
+    }
+    for _, cidr := range conf.Aggregates {
+        _, ipnet, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return nil, fmt.Errorf("bgp: invalid aggregate %q: %v", cidr, err)
+        }
+        b.aggregates = append(b.aggregates, ipnet)
+    }
+    return b, nil
+}
+
+// start brings up the BGP server and configures peers
+func (b *bgpSpeaker) start(ctx context.Context) error {
+    go b.server.Serve()
+    
+    err := b.server.StartBgp(ctx, &api.StartBgpRequest{
+        Global: &api.Global{
+            Asn:        b.conf.ASN,
+            RouterId:   b.conf.RouterID,
+            ListenPort: b.conf.ListenPort,
+        },
+    })
+    if err != nil {
+        return fmt.Errorf("bgp: failed to start: %v", err)
+    }
+    
+    for _, n := range b.conf.Neighbors {
+        families := []*api.AfiSafi{
+            {Config: &api.AfiSafiConfig{Family: familyV4, Enabled: true}},
+            {Config: &api.AfiSafiConfig{Family: familyV6, Enabled: true}},
+        }
+        err := b.server.AddPeer(ctx, &api.AddPeerRequest{
+            Peer: &api.Peer{
+                Conf:     &api.PeerConf{NeighborAddress: n.Address, PeerAsn: n.ASN},
+                AfiSafis: families,
+            },
+        })
+        if err != nil {
+            return fmt.Errorf("bgp: failed to add neighbor %s: %v", n.Address, err)
+        }
+    }
+    
+    return nil
+}
+
+// run keeps the RIB in line with attachment state until ctx is done
+func (b *bgpSpeaker) run(ctx context.Context) {
+    ticker := time.NewTicker(b.conf.SyncInterval.Or(10 * time.Second))
+    defer ticker.Stop()
+    
+    // Readiness flips are synced right away rather than on the next tick
+    var changed <-chan struct{}
+    if b.health != nil {
+        changed = b.health.changed
+    }
+    for {
+        if err := b.sync(ctx); err != nil {
+            log.Printf("bgp: sync failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            b.server.Stop()
+            return
+        case <-ticker.C:
+        case <-changed:
+        }
+    }
+}
+
+// sync withdraws stale routes and advertises new ones. Withdrawing first
+// lets a prefix pass from one attachment to another without the withdrawal
+// of the old route taking the new one with it. A route that fails is
+// logged and retried on the next sync, the others go ahead.
+func (b *bgpSpeaker) sync(ctx context.Context) error {
+    desired, err := b.desired()
+    if err != nil {
+        return err
+    }
+    
+    for key, route := range b.advertised {
+        if _, ok := desired[key]; ok {
+            continue
+        }
+        if err := b.server.DeletePath(ctx, &api.DeletePathRequest{Path: b.path(route)}); err != nil {
+            log.Printf("bgp: failed to withdraw %s: %v", key, err)
+            continue
+        }
+        delete(b.advertised, key)
+    }
+    
+    for key, route := range desired {
+        if _, ok := b.advertised[key]; ok {
+            continue
+        }
+        if _, err := b.server.AddPath(ctx, &api.AddPathRequest{Path: b.path(route)}); err != nil {
+            log.Printf("bgp: failed to advertise %s: %v", key, err)
+            continue
+        }
+        b.advertised[key] = route
+    }
+    
+    return nil
+}
+
+// desired computes the routes to advertise from local attachments of
+// ready pods, keyed by the attachment they come from. A prefix has one
+// route: when attachments claim the same one, the oldest keeps it.
+func (b *bgpSpeaker) desired() (map[string]bgpRoute, error) {
+    attachments, err := b.store.ListAttachments()
+    if err != nil {
+        return nil, err
+    }
+    sort.SliceStable(attachments, func(i, j int) bool {
+        return attachments[i].Created.Before(attachments[j].Created)
+    })
+    
+    desired := map[string]bgpRoute{}
+    owners := map[string]string{}
+    conflicts := map[string]bool{}
+    claim := func(a *state.Attachment, route bgpRoute) {
+        id := a.ContainerID + "/" + a.IfName
+        prefix := route.prefix.String()
+        if owner, ok := owners[prefix]; ok && owner != id {
+            if !b.conflicts[prefix] {
+                log.Printf("bgp: %s of %s is already advertised for %s, not advertising it twice", prefix, id, owner)
+            }
+            conflicts[prefix] = true
+            return
+        }
+        owners[prefix] = id
+        desired[id+" "+prefix] = route
+    }
+    
+    skippedV6 := false
+    for _, a := range attachments {
+        if !b.health.announced(a) {
+            continue
+        }
+        for _, cidr := range a.IPs {
+            ip, _, err := net.ParseCIDR(cidr)
+            if err != nil {
+                continue
+            }
+            nextHop, bits := b.conf.NextHop, 32
+            if ip.To4() == nil {
+                nextHop, bits = b.conf.NextHopV6, 128
+            }
+            if nextHop == "" {
+                skippedV6 = true
+                continue
+            }
+            
+            if b.conf.Mode == BGPModeAggregate {
+                for _, agg := range b.aggregates {
+                    if agg.Contains(ip) {
+                        desired[agg.String()] = bgpRoute{agg, nextHop}
+                    }
+                }
+                continue
+            }
+            
+            host := &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+            claim(a, bgpRoute{host, nextHop})
+        }
+        
+        // Delegated prefixes are routed through the pod itself, which
+        // peers on its VLAN reach directly
+        nextHop := podIPv6(a)
+        if nextHop == "" {
+            continue
+        }
+        for _, cidr := range a.DelegatedPrefixes {
+            if _, prefix, err := net.ParseCIDR(cidr); err == nil {
+                claim(a, bgpRoute{prefix, nextHop})
+            }
+        }
+    }
+    b.conflicts = conflicts
+    if skippedV6 && !b.noNextHopV6 {
+        log.Printf("bgp: no nextHopV6 is configured, not advertising pods' IPv6 addresses")
+    }
+    b.noNextHopV6 = skippedV6
+    return desired, nil
+}
+
+// podIPv6 returns the pod's global IPv6 address, if it has one
+func podIPv6(a *state.Attachment) string {
+    for _, cidr := range a.IPs {
+        ip, _, err := net.ParseCIDR(cidr)
+        if err == nil && ip.To4() == nil && ip.IsGlobalUnicast() {
+            return ip.String()
+        }
+    }
+    return ""
+}
+
+var (
+    familyV4 = &api.Family{Afi: api.Family_AFI_IP, Safi: api.Family_SAFI_UNICAST}
+    familyV6 = &api.Family{Afi: api.Family_AFI_IP6, Safi: api.Family_SAFI_UNICAST}
+)
+
+// path builds the gobgp path for a route
+func (b *bgpSpeaker) path(route bgpRoute) *api.Path {
+    prefix := route.prefix
+    ones, _ := prefix.Mask.Size()
+    nlri, _ := anypb.New(&api.IPAddressPrefix{
+        Prefix:    prefix.IP.String(),
+        PrefixLen: uint32(ones),
+    })
+    origin, _ := anypb.New(&api.OriginAttribute{Origin: 0})
+    
+    if prefix.IP.To4() != nil {
+        nextHop, _ := anypb.New(&api.NextHopAttribute{NextHop: route.nextHop})
+        return &api.Path{
+            Family: familyV4,
+            Nlri:   nlri,
+            Pattrs: []*anypb.Any{origin, nextHop},
+        }
+    }
+    
+    mpReach, _ := anypb.New(&api.MpReachNLRIAttribute{
+        Family:   familyV6,
+        NextHops: []string{route.nextHop},
+        Nlris:    []*anypb.Any{nlri},
+    })
+    return &api.Path{
+        Family: familyV6,
+        Nlri:   nlri,
+        Pattrs: []*anypb.Any{origin, mpReach},
+    }
+}
@@ -0,0 +1,232 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+
+    "example.com/vlan-cni/pkg/pcap"
+    "example.com/vlan-cni/pkg/state"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+const (
+    defaultCaptureDuration    = 10 * time.Second
+    defaultCaptureMaxDuration = 5 * time.Minute
+)
+
+// capturer runs packet captures on pod attachments for the daemon API, so
+// troubleshooting needs no tcpdump in workload images
+type capturer struct {
+    conf  *CaptureConfig
+    store *state.Store
+
+    mu      sync.Mutex
+    running map[string]bool
+}
+
+func newCapturer(conf *CaptureConfig, store *state.Store) *capturer {
+    return &capturer{conf: conf, store: store, running: map[string]bool{}}
+}
+
+// serveCapture is the /v1/capture endpoint of the daemon API:
+//
+//	POST /v1/capture?namespace=<ns>&pod=<name>[&ifName=net1][&duration=30s][&count=N][&snapLen=N][&file=<name>]
+//
+// The capture ends after duration, count packets or when the client goes
+// away. It is streamed back as pcap unless file names one in the capture
+// directory.
+func (c *capturer) serveCapture(w http.ResponseWriter, req *http.Request) {
+    if req.Method != http.MethodPost {
+        http.Error(w, "captures are started with POST", http.StatusMethodNotAllowed)
+        return
+    }
+    q := req.URL.Query()
+    duration := defaultCaptureDuration
+    if v := q.Get("duration"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil || d <= 0 {
+            http.Error(w, fmt.Sprintf("invalid duration %q", v), http.StatusBadRequest)
+            return
+        }
+        duration = d
+    }
+    if max := c.conf.MaxDuration.Or(defaultCaptureMaxDuration); duration > max {
+        http.Error(w, fmt.Sprintf("duration %s exceeds the maximum of %s", duration, max), http.StatusBadRequest)
+        return
+    }
+    count, err := intParam(q.Get("count"))
+    if err != nil {
+        http.Error(w, fmt.Sprintf("invalid count: %v", err), http.StatusBadRequest)
+        return
+    }
+    snapLen, err := intParam(q.Get("snapLen"))
+    if err != nil {
+        http.Error(w, fmt.Sprintf("invalid snapLen: %v", err), http.StatusBadRequest)
+        return
+    }
+    
+    var path string
+    if file := q.Get("file"); file != "" {
+        if c.conf.Dir == "" {
+            http.Error(w, "no capture directory configured, captures can only be streamed", http.StatusBadRequest)
+            return
+        }
+        if file != filepath.Base(file) || strings.HasPrefix(file, ".") {
+            http.Error(w, fmt.Sprintf("invalid file name %q", file), http.StatusBadRequest)
+            return
+        }
+        path = filepath.Join(c.conf.Dir, file)
+    }
+    
+    a, err := c.findAttachment(q.Get("namespace"), q.Get("pod"), q.Get("ifName"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    key := a.ContainerID + "/" + a.IfName
+    if !c.start(key) {
+        http.Error(w, fmt.Sprintf("a capture of %s in pod %s/%s is already running", a.IfName, a.PodNamespace, a.PodName), http.StatusConflict)
+        return
+    }
+    defer c.done(key)
+    
+    h, err := openCapture(a, snapLen)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    defer h.Close()
+    
+    ctx, cancel := context.WithTimeout(req.Context(), duration)
+    defer cancel()
+    log.Printf("capture: capturing %s of pod %s/%s for %s", a.IfName, a.PodNamespace, a.PodName, duration)
+    
+    if path == "" {
+        w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", a.PodName+"-"+a.IfName+".pcap"))
+        if _, err := h.WriteTo(ctx, flushWriter{w}, count); err != nil {
+            log.Printf("capture: capture of pod %s/%s failed: %v", a.PodNamespace, a.PodName, err)
+        }
+        return
+    }
+    
+    if err := os.MkdirAll(c.conf.Dir, 0700); err != nil {
+        http.Error(w, fmt.Sprintf("failed to create capture directory: %v", err), http.StatusInternalServerError)
+        return
+    }
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("failed to create capture file: %v", err), http.StatusConflict)
+        return
+    }
+    stats, err := h.WriteTo(ctx, f, count)
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        http.Error(w, fmt.Sprintf("capture failed: %v", err), http.StatusInternalServerError)
+        return
+    }
+    writeJSON(w, &vlanv1.CaptureResult{
+        TypeMeta: vlanv1.Meta(vlanv1.KindCaptureResult),
+        Path:     path,
+        Packets:  stats.Packets,
+        Bytes:    stats.Bytes,
+    })
+}
+
+// findAttachment returns the pod's attachment on ifName, or its only one
+func (c *capturer) findAttachment(namespace, pod, ifName string) (*state.Attachment, error) {
+    if namespace == "" || pod == "" {
+        return nil, fmt.Errorf("namespace and pod are required")
+    }
+    return findAttachment(c.store, namespace, pod, ifName)
+}
+
+// findAttachment returns the pod's attachment on ifName, or its only one
+func findAttachment(store *state.Store, namespace, pod, ifName string) (*state.Attachment, error) {
+    attachments, err := store.ListAttachments()
+    if err != nil {
+        return nil, err
+    }
+    var found []*state.Attachment
+    for _, a := range attachments {
+        if a.PodNamespace == namespace && a.PodName == pod && (ifName == "" || a.IfName == ifName || a.PodInterface() == ifName) {
+            found = append(found, a)
+        }
+    }
+    switch {
+    case len(found) == 0:
+        return nil, fmt.Errorf("pod %s/%s has no attachment %s on this node", namespace, pod, ifName)
+    case len(found) > 1:
+        return nil, fmt.Errorf("pod %s/%s has %d attachments, pick one with ifName", namespace, pod, len(found))
+    }
+    return found[0], nil
+}
+
+func (c *capturer) start(key string) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.running[key] {
+        return false
+    }
+    c.running[key] = true
+    return true
+}
+
+func (c *capturer) done(key string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    delete(c.running, key)
+}
+
+// openCapture opens the packet socket inside the pod's namespace
+func openCapture(a *state.Attachment, snapLen int) (*pcap.Handle, error) {
+    netns, err := ns.GetNS(a.Netns)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open netns %q: %v", a.Netns, err)
+    }
+    defer netns.Close()
+    
+    var h *pcap.Handle
+    err = netns.Do(func(ns.NetNS) error {
+        var err error
+        h, err = pcap.Open(a.PodInterface(), snapLen)
+        return err
+    })
+    return h, err
+}
+
+func intParam(v string) (int, error) {
+    if v == "" {
+        return 0, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err == nil && n < 0 {
+        err = fmt.Errorf("%d is negative", n)
+    }
+    return n, err
+}
+
+// flushWriter sends streamed captures as they are written
+type flushWriter struct {
+    w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+    n, err := f.w.Write(p)
+    if fl, ok := f.w.(http.Flusher); ok {
+        fl.Flush()
+    }
+    return n, err
+}
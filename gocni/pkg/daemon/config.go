@@ -0,0 +1,1006 @@
+package daemon
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "math"
+    "net"
+    "os"
+    "strings"
+    "time"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/secrets"
+)
+
+// DefaultConfigPath is where the daemon looks for its configuration
+const DefaultConfigPath = "/etc/vlan-cni/config/daemon.json"
+
+// Config is the node daemon configuration
+type Config struct {
+    // Name this node identifies itself with, defaults to $NODE_NAME or the hostname
+    NodeName string `json:"nodeName,omitempty"`
+
+    // Path to a kubeconfig, in-cluster configuration is used when empty
+    Kubeconfig string `json:"kubeconfig,omitempty"`
+
+    // Plugin state directory shared with the CNI binary
+    StateDir string `json:"stateDir,omitempty"`
+
+    FloatingIPs []FloatingIPConfig `json:"floatingIPs,omitempty"`
+
+    // Optional BGP speaker announcing pod VLAN addresses
+    BGP *BGPConfig `json:"bgp,omitempty"`
+
+    // Bandwidth sharing between VLAN networks on shared uplinks
+    Shaping []ShapingConfig `json:"shaping,omitempty"`
+
+    // Copies of VLAN traffic for analyzers, local or remote
+    Mirrors []MirrorConfig `json:"mirrors,omitempty"`
+
+    // Announcement of MetalLB or kube-vip LoadBalancer addresses on VLANs
+    LoadBalancers []LoadBalancerConfig `json:"loadBalancers,omitempty"`
+
+    // VLANs stretched to other sites over IPsec
+    Sites []SiteConfig `json:"sites,omitempty"`
+
+    // Links pre-created for the plugin to hand out on ADD
+    WarmPools []WarmPoolConfig `json:"warmPools,omitempty"`
+
+    // Readiness reporting, on by default
+    Readiness *ReadinessConfig `json:"readiness,omitempty"`
+
+    // Token buckets limiting how fast pods attach to each network
+    RateLimits []RateLimitConfig `json:"rateLimits,omitempty"`
+
+    // Compare network definitions in files with NetworkAttachmentDefinitions
+    Drift *DriftConfig `json:"drift,omitempty"`
+
+    // Sync the node's VLAN allowlist annotation for the plugin to enforce
+    VlanAllowlist *VlanAllowlistConfig `json:"vlanAllowlist,omitempty"`
+
+    // Measure the throughput of pod attachments, and optionally serve it
+    // through the custom metrics API
+    PodMetrics *PodMetricsConfig `json:"podMetrics,omitempty"`
+
+    // Send the keepalives of networks with stp.keepaliveSeconds
+    Keepalives *KeepalivesConfig `json:"keepalives,omitempty"`
+
+    // Sync the node's labels and annotations for templated network
+    // configuration
+    NodeValues *NodeValuesConfig `json:"nodeValues,omitempty"`
+
+    // Export the connections of pod attachments to an IPFIX collector
+    FlowExport *FlowExportConfig `json:"flowExport,omitempty"`
+
+    // Evicting pods attached with outdated network configurations
+    Rollout *RolloutConfig `json:"rollout,omitempty"`
+
+    // Track the active routers of networks with a VRRP gateway
+    Gateways *GatewaysConfig `json:"gateways,omitempty"`
+
+    // Renewal of the DHCPv6 leases of pods, always on
+    DHCPv6 *DHCPv6Config `json:"dhcpv6,omitempty"`
+
+    // Packet captures of pod attachments through the daemon API
+    Capture *CaptureConfig `json:"capture,omitempty"`
+
+    // Attachment lifecycle events streamed by the daemon API, always on
+    Events *EventsConfig `json:"events,omitempty"`
+
+    // Failing ADDs while the node's networking is in maintenance
+    Maintenance *MaintenanceConfig `json:"maintenance,omitempty"`
+
+    // Bounded concurrency of DELs, served through the daemon API
+    DelQueue *DelQueueConfig `json:"delQueue,omitempty"`
+
+    // Cache of the master links the plugin looks up, served through the
+    // daemon API
+    LinkCache *LinkCacheConfig `json:"linkCache,omitempty"`
+
+    // Removal of host devices the plugin left behind unused
+    Janitor *JanitorConfig `json:"janitor,omitempty"`
+
+    // Feature gates turned on or off by name, over the defaults of their
+    // stage: alpha gates are off, the others on
+    FeatureGates map[string]bool `json:"featureGates,omitempty"`
+
+    // Unix socket serving the daemon API, daemon.sock in the state
+    // directory when empty
+    APISocket string `json:"apiSocket,omitempty"`
+
+    // host:port serving /debug/pprof, off when empty. Profiles expose
+    // process internals, so bind to loopback unless access is restricted.
+    DebugAddress string `json:"debugAddress,omitempty"`
+}
+
+// ReadinessConfig controls how the daemon tells the node whether the plugin
+// is usable. The ready file in the state directory is always maintained,
+// for the daemon container's readinessProbe.
+type ReadinessConfig struct {
+    // Network configuration the plugin is expected in, defaults to
+    // DefaultReadinessConfFile
+    ConfFile string `json:"confFile,omitempty"`
+
+    // Also set the VlanNetworkReady node condition and the
+    // vlan-cni.io/network-ready node label
+    NodeCondition bool `json:"nodeCondition,omitempty"`
+
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// VlanAllowlistConfig controls how often the node annotation
+// vlan-cni.io/allowed-vlans is read. The plugin enforces both it and
+// the administrator's /etc/vlan-cni/allowed-vlans file.
+type VlanAllowlistConfig struct {
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// MaintenanceConfig controls maintenance mode, in which the plugin fails
+// ADDs with ErrTryAgainLater and keeps serving DELs. The node is in
+// maintenance while Enabled is set, or the daemon's -maintenance flag, and
+// otherwise while it has the vlan-cni.io/maintenance annotation.
+type MaintenanceConfig struct {
+    Enabled bool `json:"enabled,omitempty"`
+
+    // How often the node annotation is read
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// PodMetricsConfig controls how often the byte counters of attachments
+// are sampled. The rates are kept in memory and served to the adapters on
+// Address.
+type PodMetricsConfig struct {
+    Interval Duration `json:"interval,omitempty"`
+
+    // host:port serving the node's rates to the adapters, which reach it
+    // on the node's address with the same port. Callers must present a
+    // token of the daemon's own service account.
+    Address string `json:"address"`
+
+    // Serve custom.metrics.k8s.io from the rates of every node, for
+    // HorizontalPodAutoscalers. The daemons running it label their pod
+    // vlan-cni.io/metrics-adapter=true, which the Service of
+    // deployments/custom-metrics.yaml selects.
+    Adapter *MetricsAdapterConfig `json:"adapter,omitempty"`
+}
+
+// EventsConfig controls how often attachment records are compared for
+// the events of /v1/events, every second by default
+type EventsConfig struct {
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// JanitorConfig ages out host devices the plugin created that no
+// attachment uses, such as VLAN links of ADDs that failed or were cut short
+// by a crash. Devices created before the plugin marked them are left alone.
+type JanitorConfig struct {
+    // How long an unused device is kept after it was created or last
+    // reused, 1h by default
+    TTL Duration `json:"ttl,omitempty"`
+
+    SyncInterval Duration `json:"syncInterval,omitempty"`
+}
+
+// DefaultJanitorTTL is how long unused host devices are kept
+const DefaultJanitorTTL = time.Hour
+
+// DelQueueConfig bounds how many DELs release addresses or tear down host
+// state at once, DefaultDelConcurrency by default. Plugins find the queue
+// on the daemon.sock of their state directory; with another APISocket, or
+// no daemon, DELs run unqueued.
+type DelQueueConfig struct {
+    Concurrency int `json:"concurrency,omitempty"`
+}
+
+// LinkCacheConfig controls the cache of link lookups. Netlink events drop
+// the entries of links that change; TTL, DefaultLinkCacheTTL by default,
+// bounds how long an entry is served regardless.
+type LinkCacheConfig struct {
+    TTL Duration `json:"ttl,omitempty"`
+}
+
+// MetricsAdapterConfig is where the custom metrics API is served
+type MetricsAdapterConfig struct {
+    // host:port of the HTTPS listener
+    Address  string `json:"address"`
+    CertFile string `json:"certFile"`
+    KeyFile  string `json:"keyFile"`
+
+    // Client certificates must be signed by this CA, which signs those the
+    // API server presents when proxying (requestheader-client-ca-file)
+    ClientCAFile string `json:"clientCAFile"`
+}
+
+// KeepalivesConfig controls how often pods still within their
+// stp.keepaliveSeconds announce themselves
+type KeepalivesConfig struct {
+    Interval Duration `json:"interval,omitempty"`
+
+    // Skip pods that are not Ready, as watched through the API server
+    ReadinessGated bool `json:"readinessGated,omitempty"`
+}
+
+// NodeValuesConfig controls how often the node's labels and annotations
+// are read for the plugin to render templated configuration values with
+type NodeValuesConfig struct {
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// FlowExportConfig is where and how often flow records are sent. Records
+// are read from the conntrack table of each pod's namespace, which needs
+// nf_conntrack and the nft tool; the exporter turns tracking on there.
+type FlowExportConfig struct {
+    // host:port of the collector, reached over UDP
+    Collector string `json:"collector"`
+
+    // Networks whose attachments are exported, all when empty
+    Networks []string `json:"networks,omitempty"`
+
+    ObservationDomainID uint32 `json:"observationDomainID,omitempty"`
+
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// GatewaysConfig controls how often the gateways are listened for
+type GatewaysConfig struct {
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// DHCPv6Config controls how often leases are checked for renewal
+type DHCPv6Config struct {
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// RolloutConfig controls /v1/rollout, which lists pods attached with
+// outdated network configurations and can evict them
+type RolloutConfig struct {
+    // Let POST evict the pods of outdated attachments
+    AllowRecreate bool `json:"allowRecreate,omitempty"`
+}
+
+// CaptureConfig bounds the captures of /v1/capture
+type CaptureConfig struct {
+    // Directory captures are written to when the request names a file.
+    // Captures are only streamed back when empty.
+    Dir string `json:"dir,omitempty"`
+
+    // Longest capture accepted, five minutes by default
+    MaxDuration Duration `json:"maxDuration,omitempty"`
+}
+
+// DriftConfig controls how often the installed network configuration and
+// the meta mode networks directory are compared with the cluster's
+// NetworkAttachmentDefinitions. Networks of the same name that disagree on
+// masters, VLAN IDs or subnets are reported as metrics on the daemon API
+// and as Events on the NetworkAttachmentDefinition.
+type DriftConfig struct {
+    Interval Duration `json:"interval,omitempty"`
+}
+
+// RateLimitConfig limits ADDs to a network on this node, sparing the
+// switch's MAC learning and DHCP servers during large rollouts. Every
+// attachment created counts, so pods with several attachments to the
+// network take several tokens.
+type RateLimitConfig struct {
+    // Network name as in the CNI configuration
+    Network string `json:"network"`
+
+    // ADDs per second, sustained
+    Rate float64 `json:"rate"`
+
+    // ADDs allowed back to back, defaults to Rate rounded up
+    Burst int `json:"burst,omitempty"`
+}
+
+// WarmPoolConfig keeps Size idle links ready for a network. The kernel
+// allows one VLAN device per VLAN ID and master, so pools for tagged
+// networks hold at most one link; untagged macvlan and ipvlan pools can be
+// any size.
+type WarmPoolConfig struct {
+    Master       string `json:"master"`
+    VlanID       int    `json:"vlan"`
+    UntaggedMode string `json:"untaggedMode,omitempty"`
+    MTU          int    `json:"mtu,omitempty"`
+    Size         int    `json:"size"`
+
+    // How often the pool is topped up
+    RefillInterval Duration `json:"refillInterval,omitempty"`
+}
+
+// ShapingConfig is an HTB hierarchy on one master interface. Rates are
+// strings such as "10gbit", "500mbit" or "64kbit".
+type ShapingConfig struct {
+    Master string `json:"master"`
+    Rate   string `json:"rate"`
+
+    // Share of traffic from VLANs not listed, defaults to what the
+    // networks' rates leave of Rate
+    DefaultRate string `json:"defaultRate,omitempty"`
+
+    Networks []NetworkShare `json:"networks"`
+
+    // How often the hierarchy and pod filters are reconciled
+    SyncInterval Duration `json:"syncInterval,omitempty"`
+}
+
+// NetworkShare is the guaranteed rate and ceiling of one VLAN
+type NetworkShare struct {
+    VlanID int    `json:"vlan"`
+    Rate   string `json:"rate"`
+    Ceil   string `json:"ceil,omitempty"`
+
+    // Pod classes within the VLAN, picked with args.cni.trafficClass
+    Classes []ClassShare `json:"classes,omitempty"`
+}
+
+// ClassShare is a pod class within a VLAN's share
+type ClassShare struct {
+    Name     string `json:"name"`
+    Rate     string `json:"rate"`
+    Ceil     string `json:"ceil,omitempty"`
+    Priority uint32 `json:"priority,omitempty"`
+}
+
+// MirrorConfig copies the frames of a VLAN on a master to a local
+// interface, or encapsulated to a remote analyzer
+type MirrorConfig struct {
+    // Names the tunnel device of remote mirrors, mir-<name>
+    Name   string `json:"name"`
+    Master string `json:"master"`
+    VlanID int    `json:"vlan"`
+
+    // Local interface receiving the copies
+    Interface string `json:"interface,omitempty"`
+
+    // Or the analyzer they are tunnelled to
+    Remote *RemoteMirrorConfig `json:"remote,omitempty"`
+
+    SyncInterval Duration `json:"syncInterval,omitempty"`
+}
+
+// LoadBalancerConfig announces the LoadBalancer addresses of a pool on a
+// VLAN, in place of the L2 mode of MetalLB's speaker or kube-vip
+type LoadBalancerConfig struct {
+    // Names the responder's macvlan, lb-<name>
+    Name string `json:"name"`
+
+    // The macvlan hangs off the host's device of the VLAN on the master,
+    // created when there is none. The kernel allows one device per VLAN ID
+    // on the master, so pods on the VLAN attach to that device, as master
+    // with vlan 0 and untaggedMode macvlan.
+    Master string `json:"master"`
+    VlanID int    `json:"vlan"`
+
+    // Ranges of the pool as CIDRs, IPv4 only
+    Addresses []string `json:"addresses"`
+
+    SyncInterval Duration `json:"syncInterval,omitempty"`
+}
+
+func (l *LoadBalancerConfig) validate() error {
+    if l.Name == "" {
+        return fmt.Errorf("load balancer name is required")
+    }
+    if l.Master == "" || l.VlanID < 1 || l.VlanID > 4094 {
+        return fmt.Errorf("load balancer %q: master and a vlan from 1 to 4094 are required", l.Name)
+    }
+    if len(l.Addresses) == 0 {
+        return fmt.Errorf("load balancer %q: addresses are required", l.Name)
+    }
+    for _, cidr := range l.Addresses {
+        _, network, err := net.ParseCIDR(cidr)
+        if err != nil || network.IP.To4() == nil {
+            return fmt.Errorf("load balancer %q: invalid address range %q, IPv4 CIDRs are answered", l.Name, cidr)
+        }
+    }
+    return nil
+}
+
+// SiteConfig stretches VLANs of a trunk to a remote site. Each VLAN is
+// bridged into a VXLAN tunnel with a UDP port of its own, basePort plus the
+// VLAN ID, so IPsec selects a separate pair of security associations for
+// every VLAN.
+type SiteConfig struct {
+    // Names the devices of the site, up to 8 characters
+    Name string `json:"name"`
+
+    // Trunk facing the local segments. The host's device of each VLAN on
+    // it is bridged, created when there is none. The kernel allows one
+    // device per VLAN ID on the trunk, so pods on a stretched VLAN attach
+    // to its bridge, sb<vlan>-<name>, with vlan 0 and untaggedMode macvlan.
+    Master  string `json:"master"`
+    VlanIDs []int  `json:"vlans"`
+
+    // Tunnel endpoints of this node and the remote site's gateway
+    Local  string `json:"local"`
+    Remote string `json:"remote"`
+
+    // Both sites must agree on it, DefaultSiteBasePort by default
+    BasePort int `json:"basePort,omitempty"`
+
+    // MTU of the tunnels, left to the kernel when 0
+    MTU int `json:"mtu,omitempty"`
+
+    IPsec *IPsecConfig `json:"ipsec"`
+
+    SyncInterval Duration `json:"syncInterval,omitempty"`
+}
+
+// DefaultSiteBasePort puts the tunnels of VLANs 1 to 4094 at 20001-24094
+const DefaultSiteBasePort = 20000
+
+// How the security associations of a site are keyed
+const (
+    IPsecNative     = "native"
+    IPsecStrongSwan = "strongswan"
+)
+
+// IPsecConfig encrypts the tunnels of a site
+type IPsecConfig struct {
+    // strongswan, the default, has charon negotiate and rekey the SAs.
+    // native installs XFRM states keyed from the PSK by the daemon, which
+    // are never rekeyed and lack forward secrecy, so it takes
+    // allowStaticKeys as well.
+    Mode string `json:"mode,omitempty"`
+
+    // Acknowledges the static keys of native mode
+    AllowStaticKeys bool `json:"allowStaticKeys,omitempty"`
+
+    // Packets of native SAs received out of order and still accepted, up
+    // to 32, DefaultReplayWindow by default; captured ones sent again are
+    // dropped. Sequence numbers begin anew when a daemon restarts, so its
+    // peer drops the site's traffic until it restarts as well.
+    ReplayWindow int `json:"replayWindow,omitempty"`
+
+    // Pre-shared key of the two sites
+    PSKFrom *secrets.Ref `json:"pskFrom"`
+
+    // Where swanctl picks up connections, DefaultSwanctlDir by default
+    SwanctlDir string `json:"swanctlDir,omitempty"`
+}
+
+// DefaultReplayWindow is the replay window of native SAs
+const DefaultReplayWindow = 32
+
+// DefaultSwanctlDir is the conf.d directory of swanctl.conf
+const DefaultSwanctlDir = "/etc/swanctl/conf.d"
+
+func (s *SiteConfig) validate() error {
+    if s.Name == "" || len(s.Name) > 8 {
+        return fmt.Errorf("site name %q must be 1 to 8 characters", s.Name)
+    }
+    if s.Master == "" || len(s.VlanIDs) == 0 {
+        return fmt.Errorf("site %q: master and vlans are required", s.Name)
+    }
+    vlans := map[int]bool{}
+    for _, id := range s.VlanIDs {
+        if id < 1 || id > 4094 || vlans[id] {
+            return fmt.Errorf("site %q: invalid or repeated vlan %d", s.Name, id)
+        }
+        vlans[id] = true
+    }
+    local, remote := net.ParseIP(s.Local), net.ParseIP(s.Remote)
+    if local == nil || remote == nil || (local.To4() == nil) != (remote.To4() == nil) {
+        return fmt.Errorf("site %q: local and remote must be addresses of one family", s.Name)
+    }
+    if s.BasePort == 0 {
+        s.BasePort = DefaultSiteBasePort
+    }
+    if s.BasePort < 1 || s.BasePort+4094 > 65535 {
+        return fmt.Errorf("site %q: invalid basePort %d", s.Name, s.BasePort)
+    }
+    if s.MTU < 0 {
+        return fmt.Errorf("site %q: invalid mtu %d", s.Name, s.MTU)
+    }
+    
+    p := s.IPsec
+    if p == nil || p.PSKFrom == nil {
+        return fmt.Errorf("site %q: ipsec with a pskFrom is required", s.Name)
+    }
+    if err := p.PSKFrom.Validate(); err != nil {
+        return fmt.Errorf("site %q: pskFrom: %v", s.Name, err)
+    }
+    switch p.Mode {
+    case "":
+        p.Mode = IPsecStrongSwan
+    case IPsecNative:
+        if !p.AllowStaticKeys {
+            return fmt.Errorf("site %q: ipsec mode native uses static keys and requires allowStaticKeys", s.Name)
+        }
+    case IPsecStrongSwan:
+    default:
+        return fmt.Errorf("site %q: unknown ipsec mode %q", s.Name, p.Mode)
+    }
+    if p.ReplayWindow == 0 {
+        p.ReplayWindow = DefaultReplayWindow
+    }
+    if p.ReplayWindow < 1 || p.ReplayWindow > 32 {
+        return fmt.Errorf("site %q: invalid ipsec replayWindow %d", s.Name, p.ReplayWindow)
+    }
+    if p.SwanctlDir == "" {
+        p.SwanctlDir = DefaultSwanctlDir
+    }
+    return nil
+}
+
+// RemoteMirrorConfig is an analyzer reached over ERSPAN or GRE
+type RemoteMirrorConfig struct {
+    // erspan, the default, or gretap
+    Type    string `json:"type,omitempty"`
+    Address string `json:"address"`
+
+    // Source address of the tunnel, picked by routing when empty
+    Local string `json:"local,omitempty"`
+
+    // ERSPAN session ID, or the GRE key of gretap tunnels
+    Key uint32 `json:"key,omitempty"`
+
+    // ERSPAN version 1 index, identifying the source port to the analyzer
+    Index int `json:"index,omitempty"`
+
+    TTL int `json:"ttl,omitempty"`
+}
+
+// BGP advertisement modes
+const (
+    BGPModePod       = "pod"
+    BGPModeAggregate = "aggregate"
+)
+
+// BGPConfig configures the embedded BGP speaker
+type BGPConfig struct {
+    ASN      uint32 `json:"asn"`
+    RouterID string `json:"routerID"`
+
+    // Port to accept sessions on, -1 (the default) to only dial out
+    ListenPort int32 `json:"listenPort,omitempty"`
+
+    Neighbors []BGPNeighbor `json:"neighbors"`
+
+    // "pod" advertises a host route per pod address, "aggregate" advertises
+    // the listed subnets while at least one pod on this node is in them
+    Mode       string   `json:"mode,omitempty"`
+    Aggregates []string `json:"aggregates,omitempty"`
+
+    // Next hop for advertised IPv4 routes, defaults to the router ID
+    NextHop string `json:"nextHop,omitempty"`
+
+    // Next hop for advertised IPv6 routes, a global address of the node.
+    // Without it pods' IPv6 addresses are not advertised.
+    NextHopV6 string `json:"nextHopV6,omitempty"`
+
+    // How often attachment state is resynced into the RIB
+    SyncInterval Duration `json:"syncInterval,omitempty"`
+
+    // Withdraw the addresses of pods that are not Ready, as watched
+    // through the API server
+    ReadinessGated bool `json:"readinessGated,omitempty"`
+}
+
+// BGPNeighbor is an upstream router to peer with
+type BGPNeighbor struct {
+    Address string `json:"address"`
+    ASN     uint32 `json:"asn"`
+}
+
+// FloatingIPConfig describes a VIP the daemon moves between holders
+type FloatingIPConfig struct {
+    Name      string `json:"name"`
+    Address   string `json:"address"`
+    Interface string `json:"interface"`
+
+    // Network namespace holding the interface, the host namespace when empty
+    Netns string `json:"netns,omitempty"`
+
+    // Lease used to elect a single holder across nodes. Without one the
+    // address is held whenever the health check passes.
+    Lease *LeaseConfig `json:"lease,omitempty"`
+
+    HealthCheck *HealthCheckConfig `json:"healthCheck,omitempty"`
+}
+
+// LeaseConfig identifies a coordination.k8s.io Lease and its timings
+type LeaseConfig struct {
+    Name          string   `json:"name"`
+    Namespace     string   `json:"namespace"`
+    LeaseDuration Duration `json:"leaseDuration,omitempty"`
+    RenewDeadline Duration `json:"renewDeadline,omitempty"`
+    RetryPeriod   Duration `json:"retryPeriod,omitempty"`
+}
+
+// HealthCheckConfig describes how to probe whether this holder is eligible
+type HealthCheckConfig struct {
+    // One of "tcp", "http" or "exec"
+    Type string `json:"type"`
+
+    // host:port for tcp, a URL for http, a command line for exec
+    Target  string   `json:"target,omitempty"`
+    Command []string `json:"command,omitempty"`
+
+    Interval         Duration `json:"interval,omitempty"`
+    Timeout          Duration `json:"timeout,omitempty"`
+    FailureThreshold int      `json:"failureThreshold,omitempty"`
+}
+
+// Duration is a time.Duration that unmarshals from strings like "5s"
+type Duration struct {
+    time.Duration
+}
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+    var s string
+    if err := json.Unmarshal(b, &s); err != nil {
+        return fmt.Errorf("duration must be a string: %v", err)
+    }
+    v, err := time.ParseDuration(s)
+    if err != nil {
+        return fmt.Errorf("invalid duration %q: %v", s, err)
+    }
+    d.Duration = v
+    return nil
+}
+
+// MarshalJSON renders the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(d.Duration.String())
+}
+
+// Or returns the duration, or def when unset
+func (d Duration) Or(def time.Duration) time.Duration {
+    if d.Duration == 0 {
+        return def
+    }
+    return d.Duration
+}
+
+// LoadConfig reads and validates the daemon configuration
+func LoadConfig(path string) (*Config, error) {
+    conf := &Config{}
+    
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            return nil, fmt.Errorf("failed to read daemon config %q: %v", path, err)
+        }
+    } else if err := json.Unmarshal(data, conf); err != nil {
+        return nil, fmt.Errorf("failed to parse daemon config %q: %v", path, err)
+    }
+    
+    if conf.NodeName == "" {
+        conf.NodeName = os.Getenv("NODE_NAME")
+    }
+    if conf.NodeName == "" {
+        if conf.NodeName, err = os.Hostname(); err != nil {
+            return nil, fmt.Errorf("failed to determine node name: %v", err)
+        }
+    }
+    
+    if conf.BGP != nil {
+        if err := conf.BGP.validate(); err != nil {
+            return nil, err
+        }
+    }
+    
+    if conf.Readiness == nil {
+        conf.Readiness = &ReadinessConfig{}
+    }
+    if conf.Readiness.ConfFile == "" {
+        conf.Readiness.ConfFile = DefaultReadinessConfFile
+    }
+    
+    if conf.DebugAddress != "" {
+        if _, _, err := net.SplitHostPort(conf.DebugAddress); err != nil {
+            return nil, fmt.Errorf("invalid debugAddress %q: %v", conf.DebugAddress, err)
+        }
+    }
+    
+    for i := range conf.WarmPools {
+        if err := conf.WarmPools[i].validate(); err != nil {
+            return nil, err
+        }
+    }
+    
+    for i := range conf.Shaping {
+        if err := conf.Shaping[i].validate(); err != nil {
+            return nil, err
+        }
+    }
+    
+    mirrors := map[string]bool{}
+    for i := range conf.Mirrors {
+        if err := conf.Mirrors[i].validate(); err != nil {
+            return nil, err
+        }
+        if mirrors[conf.Mirrors[i].Name] {
+            return nil, fmt.Errorf("mirror %q given twice", conf.Mirrors[i].Name)
+        }
+        mirrors[conf.Mirrors[i].Name] = true
+    }
+    
+    sites := map[string]bool{}
+    for i := range conf.Sites {
+        if err := conf.Sites[i].validate(); err != nil {
+            return nil, err
+        }
+        if sites[conf.Sites[i].Name] {
+            return nil, fmt.Errorf("site %q given twice", conf.Sites[i].Name)
+        }
+        sites[conf.Sites[i].Name] = true
+    }
+    
+    balancers := map[string]bool{}
+    for i := range conf.LoadBalancers {
+        if err := conf.LoadBalancers[i].validate(); err != nil {
+            return nil, err
+        }
+        if balancers[conf.LoadBalancers[i].Name] {
+            return nil, fmt.Errorf("load balancer %q given twice", conf.LoadBalancers[i].Name)
+        }
+        balancers[conf.LoadBalancers[i].Name] = true
+    }
+    
+    seen := map[string]bool{}
+    for i := range conf.RateLimits {
+        if err := conf.RateLimits[i].validate(); err != nil {
+            return nil, err
+        }
+        if seen[conf.RateLimits[i].Network] {
+            return nil, fmt.Errorf("rate limit for network %q given twice", conf.RateLimits[i].Network)
+        }
+        seen[conf.RateLimits[i].Network] = true
+    }
+    
+    for i := range conf.FloatingIPs {
+        if err := conf.FloatingIPs[i].validate(); err != nil {
+            return nil, err
+        }
+    }
+    
+    if conf.PodMetrics != nil {
+        if _, _, err := net.SplitHostPort(conf.PodMetrics.Address); err != nil {
+            return nil, fmt.Errorf("invalid podMetrics address %q: %v", conf.PodMetrics.Address, err)
+        }
+    }
+    if conf.PodMetrics != nil && conf.PodMetrics.Adapter != nil {
+        a := conf.PodMetrics.Adapter
+        if _, _, err := net.SplitHostPort(a.Address); err != nil {
+            return nil, fmt.Errorf("invalid podMetrics adapter address %q: %v", a.Address, err)
+        }
+        if a.CertFile == "" || a.KeyFile == "" {
+            return nil, fmt.Errorf("podMetrics adapter needs certFile and keyFile")
+        }
+        if a.ClientCAFile == "" {
+            return nil, fmt.Errorf("podMetrics adapter needs clientCAFile, it serves every pod's metrics")
+        }
+    }
+    
+    if conf.FlowExport != nil {
+        if _, _, err := net.SplitHostPort(conf.FlowExport.Collector); err != nil {
+            return nil, fmt.Errorf("invalid flowExport collector %q: %v", conf.FlowExport.Collector, err)
+        }
+    }
+    
+    return conf, nil
+}
+
+func (m *MirrorConfig) validate() error {
+    if m.Name == "" {
+        return fmt.Errorf("mirror name is required")
+    }
+    if m.Master == "" || m.VlanID < 1 || m.VlanID > 4094 {
+        return fmt.Errorf("mirror %q: master and a vlan from 1 to 4094 are required", m.Name)
+    }
+    if (m.Interface == "") == (m.Remote == nil) {
+        return fmt.Errorf("mirror %q: one of interface and remote is required", m.Name)
+    }
+    r := m.Remote
+    if r == nil {
+        return nil
+    }
+    switch r.Type {
+    case "":
+        r.Type = MirrorERSPAN
+    case MirrorERSPAN, MirrorGRETap:
+    default:
+        return fmt.Errorf("mirror %q: unknown remote type %q", m.Name, r.Type)
+    }
+    remote := net.ParseIP(r.Address)
+    if remote == nil {
+        return fmt.Errorf("mirror %q: invalid remote address %q", m.Name, r.Address)
+    }
+    if r.Local != "" {
+        local := net.ParseIP(r.Local)
+        if local == nil || (local.To4() == nil) != (remote.To4() == nil) {
+            return fmt.Errorf("mirror %q: invalid local address %q", m.Name, r.Local)
+        }
+    }
+    if r.Type == MirrorERSPAN && (r.Key > 1023 || r.Index < 0 || r.Index > 0xfffff) {
+        return fmt.Errorf("mirror %q: ERSPAN key is a session ID up to 1023 and index is 20 bits", m.Name)
+    }
+    if r.TTL < 0 || r.TTL > 255 {
+        return fmt.Errorf("mirror %q: invalid ttl %d", m.Name, r.TTL)
+    }
+    return nil
+}
+
+func (r *RateLimitConfig) validate() error {
+    if r.Network == "" || strings.Contains(r.Network, "/") {
+        return fmt.Errorf("rate limit: invalid network name %q", r.Network)
+    }
+    if r.Rate <= 0 {
+        return fmt.Errorf("rate limit for network %q: rate must be positive", r.Network)
+    }
+    if r.Burst < 0 {
+        return fmt.Errorf("rate limit for network %q: burst must not be negative", r.Network)
+    }
+    if r.Burst == 0 {
+        r.Burst = int(math.Ceil(r.Rate))
+    }
+    return nil
+}
+
+func (f *FloatingIPConfig) validate() error {
+    if f.Name == "" {
+        return fmt.Errorf("floating IP name is required")
+    }
+    if _, _, err := net.ParseCIDR(f.Address); err != nil {
+        return fmt.Errorf("floating IP %q: invalid address %q: %v", f.Name, f.Address, err)
+    }
+    if f.Interface == "" {
+        return fmt.Errorf("floating IP %q: interface is required", f.Name)
+    }
+    if f.Lease != nil && (f.Lease.Name == "" || f.Lease.Namespace == "") {
+        return fmt.Errorf("floating IP %q: lease name and namespace are required", f.Name)
+    }
+    if hc := f.HealthCheck; hc != nil {
+        switch hc.Type {
+        case "tcp", "http":
+            if hc.Target == "" {
+                return fmt.Errorf("floating IP %q: %s health check needs a target", f.Name, hc.Type)
+            }
+        case "exec":
+            if len(hc.Command) == 0 {
+                return fmt.Errorf("floating IP %q: exec health check needs a command", f.Name)
+            }
+        default:
+            return fmt.Errorf("floating IP %q: unknown health check type %q", f.Name, hc.Type)
+        }
+    }
+    return nil
+}
+
+func (b *BGPConfig) validate() error {
+    if b.ASN == 0 {
+        return fmt.Errorf("bgp: asn is required")
+    }
+    if net.ParseIP(b.RouterID).To4() == nil {
+        return fmt.Errorf("bgp: routerID %q must be an IPv4 address", b.RouterID)
+    }
+    if len(b.Neighbors) == 0 {
+        return fmt.Errorf("bgp: at least one neighbor is required")
+    }
+    for _, n := range b.Neighbors {
+        if net.ParseIP(n.Address) == nil || n.ASN == 0 {
+            return fmt.Errorf("bgp: invalid neighbor %q (asn %d)", n.Address, n.ASN)
+        }
+    }
+    
+    switch b.Mode {
+    case "":
+        b.Mode = BGPModePod
+    case BGPModePod:
+    case BGPModeAggregate:
+        if len(b.Aggregates) == 0 {
+            return fmt.Errorf("bgp: aggregate mode needs at least one aggregate")
+        }
+        for _, cidr := range b.Aggregates {
+            if _, _, err := net.ParseCIDR(cidr); err != nil {
+                return fmt.Errorf("bgp: invalid aggregate %q: %v", cidr, err)
+            }
+        }
+    default:
+        return fmt.Errorf("bgp: unknown mode %q", b.Mode)
+    }
+    
+    if b.ListenPort == 0 {
+        b.ListenPort = -1
+    }
+    if b.NextHop == "" {
+        b.NextHop = b.RouterID
+    }
+    if net.ParseIP(b.NextHop).To4() == nil {
+        return fmt.Errorf("bgp: nextHop %q must be an IPv4 address", b.NextHop)
+    }
+    if b.NextHopV6 != "" {
+        if ip := net.ParseIP(b.NextHopV6); ip == nil || ip.To4() != nil {
+            return fmt.Errorf("bgp: nextHopV6 %q must be an IPv6 address", b.NextHopV6)
+        }
+    }
+    return nil
+}
+
+// maxShapingClasses is how many pod classes fit the class ID scheme
+const maxShapingClasses = 14
+
+func (s *ShapingConfig) validate() error {
+    if s.Master == "" {
+        return fmt.Errorf("shaping: master is required")
+    }
+    rates := []string{s.Rate, s.DefaultRate}
+    
+    seen := map[int]bool{}
+    for _, n := range s.Networks {
+        if n.VlanID < 1 || n.VlanID > 4094 || seen[n.VlanID] {
+            return fmt.Errorf("shaping %s: invalid or duplicate vlan %d", s.Master, n.VlanID)
+        }
+        seen[n.VlanID] = true
+        if len(n.Classes) > maxShapingClasses {
+            return fmt.Errorf("shaping %s: vlan %d has more than %d classes", s.Master, n.VlanID, maxShapingClasses)
+        }
+        rates = append(rates, n.Rate, n.Ceil)
+        for _, c := range n.Classes {
+            if c.Name == "" {
+                return fmt.Errorf("shaping %s: vlan %d has a class without a name", s.Master, n.VlanID)
+            }
+            rates = append(rates, c.Rate, c.Ceil)
+        }
+    }
+    
+    if _, err := parseRate(s.Rate); err != nil {
+        return fmt.Errorf("shaping %s: %v", s.Master, err)
+    }
+    for _, r := range rates[1:] {
+        if r == "" {
+            continue
+        }
+        if _, err := parseRate(r); err != nil {
+            return fmt.Errorf("shaping %s: %v", s.Master, err)
+        }
+    }
+    
+    // Guarantees cannot add up to more than their parent has
+    uplink, _ := parseRate(s.Rate)
+    shares := orRate(s.DefaultRate, 0)
+    for _, n := range s.Networks {
+        rate, _ := parseRate(n.Rate)
+        shares += rate
+        named := uint64(0)
+        for _, c := range n.Classes {
+            cRate, _ := parseRate(c.Rate)
+            named += cRate
+        }
+        if named > rate {
+            return fmt.Errorf("shaping %s: vlan %d classes add up to more than its rate", s.Master, n.VlanID)
+        }
+    }
+    if shares > uplink {
+        return fmt.Errorf("shaping %s: network rates and defaultRate add up to more than rate", s.Master)
+    }
+    return nil
+}
+
+func (p *WarmPoolConfig) validate() error {
+    if p.Master == "" {
+        return fmt.Errorf("warm pool: master is required")
+    }
+    if p.VlanID < 0 || p.VlanID > 4094 {
+        return fmt.Errorf("warm pool %s: invalid vlan %d", p.Master, p.VlanID)
+    }
+    switch p.UntaggedMode {
+    case "":
+        p.UntaggedMode = config.UntaggedModeMacvlan
+    case config.UntaggedModeMacvlan, config.UntaggedModeIPVlan:
+    default:
+        return fmt.Errorf("warm pool %s: invalid untaggedMode %q", p.Master, p.UntaggedMode)
+    }
+    if p.Size < 1 {
+        return fmt.Errorf("warm pool %s/%d: size must be at least 1", p.Master, p.VlanID)
+    }
+    if p.VlanID != 0 && p.Size > 1 {
+        p.Size = 1
+    }
+    return nil
+}
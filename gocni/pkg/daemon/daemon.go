@@ -0,0 +1,421 @@
+package daemon
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "path/filepath"
+    "sync"
+
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/features"
+    "example.com/vlan-cni/pkg/kube"
+    "example.com/vlan-cni/pkg/state"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// Daemon runs the node-level features that outlive single CNI invocations
+type Daemon struct {
+    conf    *Config
+    client  kubernetes.Interface
+    dynamic dynamic.Interface
+    store   *state.Store
+    gates   *features.Set
+}
+
+// New creates a daemon, connecting to the API server when a feature needs it
+func New(conf *Config) (*Daemon, error) {
+    gates, warnings, err := features.New(conf.FeatureGates)
+    if err != nil {
+        return nil, err
+    }
+    for _, w := range warnings {
+        log.Printf("features: %s", w)
+    }
+    disableGated(conf, gates)
+    
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return nil, err
+    }
+    
+    d := &Daemon{conf: conf, store: store, gates: gates}
+    
+    if d.needsClient() {
+        client, err := kube.NewClient(conf.Kubeconfig)
+        if err != nil {
+            return nil, err
+        }
+        d.client = client
+    }
+    if d.conf.Drift != nil {
+        client, err := kube.NewDynamicClient(conf.Kubeconfig)
+        if err != nil {
+            return nil, err
+        }
+        d.dynamic = client
+    }
+    
+    return d, nil
+}
+
+// Run starts all configured features and blocks until ctx is done
+func (d *Daemon) Run(ctx context.Context) error {
+    var wg sync.WaitGroup
+    
+    if d.conf.DebugAddress != "" {
+        srv, err := newDebugServer(d.conf.DebugAddress)
+        if err != nil {
+            return err
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            srv.run(ctx)
+        }()
+    }
+    
+    for _, fipConf := range d.conf.FloatingIPs {
+        fip, err := newFloatingIP(fipConf, d.conf.NodeName, d.client)
+        if err != nil {
+            return err
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            fip.run(ctx)
+        }()
+    }
+    
+    // Announcements gated on readiness share one watch of the node's pods
+    var health *podHealth
+    if d.readinessGated() {
+        health = newPodHealth(d.conf.NodeName, d.client)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            health.run(ctx)
+        }()
+    }
+    
+    if d.conf.BGP != nil {
+        speaker, err := newBGPSpeaker(d.conf.BGP, d.store, gate(d.conf.BGP.ReadinessGated, health))
+        if err != nil {
+            return err
+        }
+        if err := speaker.start(ctx); err != nil {
+            return err
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            speaker.run(ctx)
+        }()
+    }
+    
+    for _, poolConf := range d.conf.WarmPools {
+        p := newWarmPool(poolConf, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            p.run(ctx)
+        }()
+    }
+    
+    for _, mirrorConf := range d.conf.Mirrors {
+        m := newMirror(mirrorConf)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            m.run(ctx)
+        }()
+    }
+    
+    for _, siteConf := range d.conf.Sites {
+        s := newSite(siteConf, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            s.run(ctx)
+        }()
+    }
+    
+    for _, lbConf := range d.conf.LoadBalancers {
+        lb := newLoadBalancer(lbConf, d.conf.NodeName, d.client, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            lb.run(ctx)
+        }()
+    }
+    
+    for _, shapingConf := range d.conf.Shaping {
+        s := newShaper(shapingConf, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            s.run(ctx)
+        }()
+    }
+    
+    // Buckets are refilled by the plugin, so they keep limiting ADDs
+    // while the daemon restarts
+    if err := publishRateLimits(d.conf.RateLimits, d.store); err != nil {
+        return err
+    }
+    
+    if d.conf.VlanAllowlist != nil {
+        a := newAllowlistSync(d.conf.VlanAllowlist, d.conf.NodeName, d.client, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            a.run(ctx)
+        }()
+    }
+    
+    if d.conf.Maintenance != nil {
+        m := newMaintenanceSync(d.conf.Maintenance, d.conf.NodeName, d.client, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            m.run(ctx)
+        }()
+    } else if err := d.store.SaveMaintenance(nil); err != nil {
+        return err
+    }
+    
+    if pm := d.conf.PodMetrics; pm != nil {
+        auth, err := newPeerAuth(ctx, d.client)
+        if err != nil {
+            return err
+        }
+        p := newPodMetrics(pm, d.store)
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            p.run(ctx)
+        }()
+        go func() {
+            defer wg.Done()
+            p.serve(ctx, auth)
+        }()
+        if pm.Adapter != nil {
+            adapter, err := newMetricsAdapter(pm, d.client)
+            if err != nil {
+                return err
+            }
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                adapter.run(ctx)
+            }()
+        }
+    }
+    
+    if d.conf.Keepalives != nil {
+        k := newKeepalives(d.conf.Keepalives, d.store, gate(d.conf.Keepalives.ReadinessGated, health))
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            k.run(ctx)
+        }()
+    }
+    
+    if d.conf.FlowExport != nil {
+        f, err := newFlowExporter(d.conf.FlowExport, d.store)
+        if err != nil {
+            return err
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            f.run(ctx)
+        }()
+    }
+    
+    if d.conf.NodeValues != nil {
+        n := newNodeValuesSync(d.conf.NodeValues, d.conf.NodeName, d.client, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            n.run(ctx)
+        }()
+    }
+    
+    if d.conf.Gateways != nil {
+        gw := newGatewayWatch(d.conf.Gateways, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            gw.run(ctx)
+        }()
+    }
+    
+    if d.conf.Janitor != nil {
+        j := newJanitor(d.conf.Janitor, d.store)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            j.run(ctx)
+        }()
+    }
+    
+    dr := newDHCPv6Renewer(d.conf.DHCPv6, d.store)
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        dr.run(ctx)
+    }()
+    
+    r := newReadiness(d.conf.Readiness, d.conf.NodeName, d.client, d.store)
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        r.run(ctx)
+    }()
+    
+    apiSocket := d.conf.APISocket
+    if apiSocket == "" {
+        apiSocket = filepath.Join(d.store.Dir(), apiSocketName)
+    }
+    api, err := newAPIServer(apiSocket)
+    if err != nil {
+        return err
+    }
+    api.handle("/v1/compat", r.serveCompat)
+    api.handle("/v1/features", d.serveFeatures)
+    
+    events := newEventStream(d.conf.Events, d.conf.NodeName, d.store)
+    api.handle("/v1/events", events.serveEvents)
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        events.run(ctx)
+    }()
+    api.handle("/v1/migrate", newMigrator(d.conf.Readiness.ConfFile, d.conf.NodeName, d.store).serveMigrate)
+    api.handle("/v1/rollout", newRollout(d.conf.Rollout, d.conf.Readiness.ConfFile, d.client, d.dynamic, d.store).serveRollout)
+    
+    if d.conf.LinkCache != nil {
+        lc := newLinkCache(d.conf.LinkCache)
+        api.handle("/v1/links", lc.serveLinks)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            lc.run(ctx)
+        }()
+    }
+    
+    if d.conf.DelQueue != nil {
+        api.handle("/v1/delqueue", newDelQueue(d.conf.DelQueue).serveDelQueue)
+    }
+    
+    if d.conf.Capture != nil {
+        api.handle("/v1/capture", newCapturer(d.conf.Capture, d.store).serveCapture)
+    }
+    
+    if d.conf.Drift != nil {
+        dd := newDriftDetector(d.conf.Drift, d.conf.Readiness.ConfFile, d.conf.NodeName, d.client, d.dynamic)
+        api.handle("/metrics", dd.serveMetrics)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            dd.run(ctx)
+        }()
+    }
+    
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        api.run(ctx)
+    }()
+    
+    <-ctx.Done()
+    wg.Wait()
+    return nil
+}
+
+// disableGated drops the configuration of subsystems whose gates are off,
+// so they do not start
+func disableGated(conf *Config, gates *features.Set) {
+    off := func(gate, key string) bool {
+        if gates.Enabled(gate) {
+            return false
+        }
+        log.Printf("features: %s is disabled, ignoring %s", gate, key)
+        return true
+    }
+    if conf.BGP != nil && off(features.BGP, "bgp") {
+        conf.BGP = nil
+    }
+    if len(conf.WarmPools) > 0 && off(features.WarmPools, "warmPools") {
+        conf.WarmPools = nil
+    }
+    if len(conf.Sites) > 0 && off(features.Sites, "sites") {
+        conf.Sites = nil
+    }
+    if len(conf.FloatingIPs) > 0 && off(features.FloatingIPs, "floatingIPs") {
+        conf.FloatingIPs = nil
+    }
+    if len(conf.LoadBalancers) > 0 && off(features.LoadBalancers, "loadBalancers") {
+        conf.LoadBalancers = nil
+    }
+    if conf.PodMetrics != nil && conf.PodMetrics.Adapter != nil && off(features.MetricsAdapter, "podMetrics.adapter") {
+        conf.PodMetrics.Adapter = nil
+    }
+    if conf.LinkCache != nil && (off(features.DaemonMode, "linkCache") || off(features.LinkCache, "linkCache")) {
+        conf.LinkCache = nil
+    }
+    if conf.DelQueue != nil && (off(features.DaemonMode, "delQueue") || off(features.DelQueue, "delQueue")) {
+        conf.DelQueue = nil
+    }
+}
+
+// serveFeatures is the /v1/features endpoint of the daemon API, listing
+// the node's gates
+func (d *Daemon) serveFeatures(w http.ResponseWriter, req *http.Request) {
+    list := &vlanv1.FeatureGateList{TypeMeta: vlanv1.Meta(vlanv1.KindFeatureGateList), Gates: []*vlanv1.FeatureGate{}}
+    for _, g := range features.Gates() {
+        list.Gates = append(list.Gates, &vlanv1.FeatureGate{
+            Name:        g.Name,
+            Stage:       string(g.Stage),
+            Enabled:     d.gates.Enabled(g.Name),
+            Description: g.Description,
+        })
+    }
+    writeJSON(w, list)
+}
+
+func (d *Daemon) readinessGated() bool {
+    return (d.conf.BGP != nil && d.conf.BGP.ReadinessGated) || (d.conf.Keepalives != nil && d.conf.Keepalives.ReadinessGated)
+}
+
+// gate returns health for features gated on pod readiness, nil for others
+func gate(gated bool, health *podHealth) *podHealth {
+    if !gated {
+        return nil
+    }
+    return health
+}
+
+func (d *Daemon) needsClient() bool {
+    if d.readinessGated() {
+        return true
+    }
+    if d.conf.Readiness.NodeCondition || d.conf.VlanAllowlist != nil || d.conf.NodeValues != nil || d.conf.PodMetrics != nil || d.conf.Drift != nil || len(d.conf.LoadBalancers) > 0 {
+        return true
+    }
+    if d.conf.Rollout != nil && d.conf.Rollout.AllowRecreate {
+        return true
+    }
+    if d.conf.Maintenance != nil && !d.conf.Maintenance.Enabled {
+        return true
+    }
+    for _, fip := range d.conf.FloatingIPs {
+        if fip.Lease != nil {
+            return true
+        }
+    }
+    return false
+}
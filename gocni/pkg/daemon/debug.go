@@ -0,0 +1,51 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/http/pprof"
+    "time"
+)
+
+// debugServer serves the Go runtime profiles under /debug/pprof
+type debugServer struct {
+    listener net.Listener
+    srv      *http.Server
+}
+
+func newDebugServer(addr string) (*debugServer, error) {
+    l, err := net.Listen("tcp", addr)
+    if err != nil {
+        return nil, fmt.Errorf("failed to listen on debug address %q: %v", addr, err)
+    }
+    
+    mux := http.NewServeMux()
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    
+    return &debugServer{
+        listener: l,
+        srv:      &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+    }, nil
+}
+
+// run serves until ctx is done
+func (s *debugServer) run(ctx context.Context) {
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        _ = s.srv.Shutdown(shutdownCtx)
+    }()
+    
+    log.Printf("debug: serving pprof on %s", s.listener.Addr())
+    if err := s.srv.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+        log.Printf("debug: server failed: %v", err)
+    }
+}
@@ -0,0 +1,152 @@
+package daemon
+
+import (
+    "fmt"
+    "net/http"
+    "sync"
+)
+
+// Phases of a DEL the queue admits, in the order waiters are served.
+// Releasing addresses first hands them to replacement pods sooner during a
+// drain; tearing down host state can wait.
+const (
+    DelPhaseIPAM     = "ipam"
+    DelPhaseTeardown = "teardown"
+)
+
+var delPhases = []string{DelPhaseIPAM, DelPhaseTeardown}
+
+// DefaultDelConcurrency is how many DEL phases run at once
+const DefaultDelConcurrency = 4
+
+// delQueue bounds how many DELs work on the node at once, so a drain
+// deleting many pods does not overload netlink and the IPAM backends. The
+// plugin holds a slot for as long as its request to /v1/delqueue is open,
+// so a DEL that dies frees its slot with its connection.
+type delQueue struct {
+    limit int
+
+    mu      sync.Mutex
+    running int
+    waiting map[string][]chan struct{}
+}
+
+func newDelQueue(conf *DelQueueConfig) *delQueue {
+    limit := conf.Concurrency
+    if limit <= 0 {
+        limit = DefaultDelConcurrency
+    }
+    return &delQueue{limit: limit, waiting: map[string][]chan struct{}{}}
+}
+
+// acquire waits for a slot for phase until done is closed, reporting
+// whether it got one
+func (q *delQueue) acquire(phase string, done <-chan struct{}) bool {
+    q.mu.Lock()
+    if q.running < q.limit && q.queued() == 0 {
+        q.running++
+        q.mu.Unlock()
+        return true
+    }
+    ch := make(chan struct{})
+    q.waiting[phase] = append(q.waiting[phase], ch)
+    q.mu.Unlock()
+    
+    select {
+    case <-ch:
+        return true
+    case <-done:
+    }
+    
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    for i, w := range q.waiting[phase] {
+        if w == ch {
+            q.waiting[phase] = append(q.waiting[phase][:i], q.waiting[phase][i+1:]...)
+            return false
+        }
+    }
+    // Granted as the client went away
+    q.running--
+    q.grant()
+    return false
+}
+
+// release frees a slot for the next waiter
+func (q *delQueue) release() {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    
+    q.running--
+    q.grant()
+}
+
+// grant hands free slots to waiters, earlier phases first. It is called
+// with mu held.
+func (q *delQueue) grant() {
+    for _, phase := range delPhases {
+        for q.running < q.limit && len(q.waiting[phase]) > 0 {
+            ch := q.waiting[phase][0]
+            q.waiting[phase] = q.waiting[phase][1:]
+            q.running++
+            close(ch)
+        }
+    }
+}
+
+func (q *delQueue) queued() int {
+    n := 0
+    for _, w := range q.waiting {
+        n += len(w)
+    }
+    return n
+}
+
+// delQueueStatus is the answer of GET /v1/delqueue
+type delQueueStatus struct {
+    Concurrency int            `json:"concurrency"`
+    Running     int            `json:"running"`
+    Waiting     map[string]int `json:"waiting"`
+}
+
+// serveDelQueue is the /v1/delqueue endpoint of the daemon API:
+//
+//	POST /v1/delqueue?phase=ipam|teardown
+//
+// answers once the DEL may run its phase and holds the slot until the
+// client closes the connection. GET reports the queue.
+func (q *delQueue) serveDelQueue(w http.ResponseWriter, req *http.Request) {
+    if req.Method == http.MethodGet {
+        q.mu.Lock()
+        status := &delQueueStatus{Concurrency: q.limit, Running: q.running, Waiting: map[string]int{}}
+        for _, phase := range delPhases {
+            status.Waiting[phase] = len(q.waiting[phase])
+        }
+        q.mu.Unlock()
+        writeJSON(w, status)
+        return
+    }
+    if req.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    phase := req.URL.Query().Get("phase")
+    if phase != DelPhaseIPAM && phase != DelPhaseTeardown {
+        http.Error(w, fmt.Sprintf("unknown phase %q", phase), http.StatusBadRequest)
+        return
+    }
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+    
+    if !q.acquire(phase, req.Context().Done()) {
+        return
+    }
+    defer q.release()
+    
+    w.WriteHeader(http.StatusOK)
+    flusher.Flush()
+    <-req.Context().Done()
+}
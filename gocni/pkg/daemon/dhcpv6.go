@@ -0,0 +1,123 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/dhcp6"
+    "example.com/vlan-cni/pkg/state"
+)
+
+const (
+    defaultDHCPv6Interval = 30 * time.Second
+    dhcpv6RenewTimeout    = 10 * time.Second
+)
+
+// dhcpv6Renewer renews the DHCPv6 leases the plugin obtained, which
+// otherwise run out with the pods still using them
+type dhcpv6Renewer struct {
+    conf  *DHCPv6Config
+    store *state.Store
+}
+
+func newDHCPv6Renewer(conf *DHCPv6Config, store *state.Store) *dhcpv6Renewer {
+    if conf == nil {
+        conf = &DHCPv6Config{}
+    }
+    return &dhcpv6Renewer{conf: conf, store: store}
+}
+
+// run renews due leases until ctx is done
+func (r *dhcpv6Renewer) run(ctx context.Context) {
+    ticker := time.NewTicker(r.conf.Interval.Or(defaultDHCPv6Interval))
+    defer ticker.Stop()
+    
+    for {
+        if err := r.renewDue(ctx); err != nil {
+            log.Printf("dhcpv6: renewal failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (r *dhcpv6Renewer) renewDue(ctx context.Context) error {
+    attachments, err := r.store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    
+    now := time.Now()
+    for _, a := range attachments {
+        lease := &dhcp6.Lease{}
+        found, err := r.store.GetDHCPv6Lease(a.ContainerID, a.IfName, lease)
+        if err != nil || !found || now.Before(lease.RenewAt()) {
+            continue
+        }
+        renewed, err := renewLease(ctx, a, lease, !now.Before(lease.RebindAt()))
+        if err != nil {
+            log.Printf("dhcpv6: pod %s/%s: %v", a.PodNamespace, a.PodName, err)
+            continue
+        }
+        if err := r.store.SaveDHCPv6Lease(a.ContainerID, a.IfName, renewed); err != nil {
+            log.Printf("dhcpv6: pod %s/%s: %v", a.PodNamespace, a.PodName, err)
+        }
+    }
+    return nil
+}
+
+// renewLease extends the lease from inside the pod and refreshes the
+// lifetimes of its addresses
+func renewLease(ctx context.Context, a *state.Attachment, lease *dhcp6.Lease, rebind bool) (*dhcp6.Lease, error) {
+    netns, err := ns.GetNS(a.Netns)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open netns %q: %v", a.Netns, err)
+    }
+    defer netns.Close()
+    
+    ctx, cancel := context.WithTimeout(ctx, dhcpv6RenewTimeout)
+    defer cancel()
+    
+    var renewed *dhcp6.Lease
+    err = netns.Do(func(ns.NetNS) error {
+        client, err := dhcp6.NewClient(ctx, a.PodInterface(), lease.IAID)
+        if err != nil {
+            return err
+        }
+        defer client.Close()
+        
+        renewed, err = client.Renew(ctx, lease, rebind)
+        if err != nil {
+            return err
+        }
+        link, err := netlink.LinkByName(a.PodInterface())
+        if err != nil {
+            return fmt.Errorf("failed to look up %q: %v", a.PodInterface(), err)
+        }
+        addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+        if err != nil {
+            return fmt.Errorf("failed to list addresses of %q: %v", a.PodInterface(), err)
+        }
+        for _, leased := range renewed.Addresses {
+            for _, addr := range addrs {
+                if !addr.IP.Equal(leased.IP) {
+                    continue
+                }
+                addr.PreferedLft, addr.ValidLft = int(leased.Preferred), int(leased.Valid)
+                if err := netlink.AddrReplace(link, &addr); err != nil {
+                    return fmt.Errorf("failed to refresh %s on %q: %v", addr.IPNet, a.PodInterface(), err)
+                }
+            }
+        }
+        return nil
+    })
+    return renewed, err
+}
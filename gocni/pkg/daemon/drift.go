@@ -0,0 +1,376 @@
+package daemon
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+var nadGVR = schema.GroupVersionResource{
+    Group:    "k8s.cni.cncf.io",
+    Version:  "v1",
+    Resource: "network-attachment-definitions",
+}
+
+// networkDef is what two definitions of a network must agree on
+type networkDef struct {
+    // File path, or namespace/name of the NetworkAttachmentDefinition
+    source string
+    nad    *unstructured.Unstructured
+
+    fields map[string]string
+
+    // config.Hash of the entry as the plugin receives it
+    hash string
+
+    // The plugin entry itself
+    plugin map[string]interface{}
+}
+
+func newNetworkDef(source, name string, p map[string]interface{}) *networkDef {
+    def := &networkDef{source: source, fields: defFields(p), plugin: p}
+    
+    // Runtimes pass a conflist's name down to its plugins
+    if _, ok := p["name"]; !ok && name != "" {
+        p["name"] = name
+    }
+    if data, err := json.Marshal(p); err == nil {
+        def.hash, _ = config.Hash(data)
+    }
+    return def
+}
+
+// driftFields are compared in this order
+var driftFields = []string{"master", "vlan", "subnets"}
+
+// drift is one field two definitions of a network disagree on
+type drift struct {
+    network   string
+    field     string
+    file, nad *networkDef
+}
+
+func (d *drift) message() string {
+    return fmt.Sprintf("network %q: %s is %q in %s but %q in this NetworkAttachmentDefinition",
+        d.network, d.field, d.file.fields[d.field], d.file.source, d.nad.fields[d.field])
+}
+
+// driftDetector reports networks whose file and cluster definitions differ
+type driftDetector struct {
+    conf     *DriftConfig
+    confFile string
+    nodeName string
+    client   kubernetes.Interface
+    dynamic  dynamic.Interface
+
+    mu     sync.Mutex
+    drifts []*drift
+
+    // Messages already posted as Events
+    reported map[string]bool
+}
+
+func newDriftDetector(conf *DriftConfig, confFile, nodeName string, client kubernetes.Interface, dyn dynamic.Interface) *driftDetector {
+    return &driftDetector{
+        conf:     conf,
+        confFile: confFile,
+        nodeName: nodeName,
+        client:   client,
+        dynamic:  dyn,
+        reported: map[string]bool{},
+    }
+}
+
+// run compares the definitions until ctx is done
+func (d *driftDetector) run(ctx context.Context) {
+    ticker := time.NewTicker(d.conf.Interval.Or(time.Minute))
+    defer ticker.Stop()
+    
+    for {
+        if err := d.check(ctx); err != nil {
+            log.Printf("drift: check failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (d *driftDetector) check(ctx context.Context) error {
+    files := fileDefs(d.confFile)
+    nads, err := nadDefs(ctx, d.dynamic)
+    if err != nil {
+        return err
+    }
+    
+    var drifts []*drift
+    names := make([]string, 0, len(files))
+    for name := range files {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        for _, f := range files[name] {
+            for _, n := range nads[name] {
+                for _, field := range driftFields {
+                    if f.fields[field] != n.fields[field] {
+                        drifts = append(drifts, &drift{network: name, field: field, file: f, nad: n})
+                    }
+                }
+            }
+        }
+    }
+    
+    d.mu.Lock()
+    d.drifts = drifts
+    d.mu.Unlock()
+    
+    current := map[string]bool{}
+    for _, dr := range drifts {
+        msg := dr.message()
+        current[msg] = true
+        if d.reported[msg] {
+            continue
+        }
+        log.Printf("drift: %s (%s)", msg, dr.nad.source)
+        if err := d.postEvent(ctx, dr, msg); err != nil {
+            log.Printf("drift: failed to post event for %s: %v", dr.nad.source, err)
+            continue
+        }
+        d.reported[msg] = true
+    }
+    // Report again should a resolved drift come back
+    for msg := range d.reported {
+        if !current[msg] {
+            delete(d.reported, msg)
+        }
+    }
+    return nil
+}
+
+// fileDefs reads this plugin's networks from the installed configuration
+// and from the networks directory of meta mode plugins in it
+func fileDefs(confFile string) map[string][]*networkDef {
+    defs := map[string][]*networkDef{}
+    data, err := ioutil.ReadFile(confFile)
+    if err != nil {
+        return defs
+    }
+    name, plugins, err := parseNetwork(data)
+    if err != nil {
+        log.Printf("drift: failed to parse %s: %v", confFile, err)
+        return defs
+    }
+    
+    for _, p := range plugins {
+        if meta, ok := p["meta"].(map[string]interface{}); ok {
+            dir, _ := meta["networksDir"].(string)
+            if dir == "" {
+                dir = config.DefaultMetaNetworksDir
+            }
+            dirDefs(dir, defs)
+            continue
+        }
+        defs[name] = append(defs[name], newNetworkDef(confFile, name, p))
+    }
+    return defs
+}
+
+// dirDefs reads the networks of a meta mode networks directory, named
+// after their file when they carry no name
+func dirDefs(dir string, defs map[string][]*networkDef) {
+    paths, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+    for _, path := range paths {
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            continue
+        }
+        name, plugins, err := parseNetwork(data)
+        if err != nil {
+            log.Printf("drift: failed to parse %s: %v", path, err)
+            continue
+        }
+        if name == "" {
+            name = strings.TrimSuffix(filepath.Base(path), ".conf")
+        }
+        for _, p := range plugins {
+            defs[name] = append(defs[name], newNetworkDef(path, name, p))
+        }
+    }
+}
+
+// nadDefs reads this plugin's networks from NetworkAttachmentDefinitions,
+// named after the definition when their configuration carries no name
+func nadDefs(ctx context.Context, dyn dynamic.Interface) (map[string][]*networkDef, error) {
+    list, err := dyn.Resource(nadGVR).List(ctx, metav1.ListOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to list NetworkAttachmentDefinitions: %v", err)
+    }
+    
+    defs := map[string][]*networkDef{}
+    for i := range list.Items {
+        item := &list.Items[i]
+        raw, _, _ := unstructured.NestedString(item.Object, "spec", "config")
+        if raw == "" {
+            continue
+        }
+        name, plugins, err := parseNetwork([]byte(raw))
+        if err != nil {
+            continue
+        }
+        if name == "" {
+            name = item.GetName()
+        }
+        for _, p := range plugins {
+            def := newNetworkDef(item.GetNamespace()+"/"+item.GetName(), name, p)
+            def.nad = item
+            defs[name] = append(defs[name], def)
+        }
+    }
+    return defs, nil
+}
+
+// parseNetwork returns the name of a conf or conflist document and its
+// entries of this plugin
+func parseNetwork(data []byte) (string, []map[string]interface{}, error) {
+    var doc struct {
+        Name string `json:"name"`
+    }
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return "", nil, err
+    }
+    raws, err := vlanPlugins(data)
+    if err != nil {
+        return "", nil, err
+    }
+    var plugins []map[string]interface{}
+    for _, raw := range raws {
+        var p map[string]interface{}
+        if err := json.Unmarshal(raw, &p); err != nil {
+            return "", nil, err
+        }
+        plugins = append(plugins, p)
+    }
+    return doc.Name, plugins, nil
+}
+
+// defFields extracts the compared fields of a plugin entry, joining those
+// of every attachment
+func defFields(p map[string]interface{}) map[string]string {
+    links := []map[string]interface{}{p}
+    if attachments, ok := p["attachments"].([]interface{}); ok && len(attachments) > 0 {
+        links = nil
+        for _, a := range attachments {
+            if m, ok := a.(map[string]interface{}); ok {
+                links = append(links, m)
+            }
+        }
+    }
+    
+    var masters, vlans, subnets []string
+    for _, l := range links {
+        master, _ := l["master"].(string)
+        vlan, _ := l["vlan"].(float64)
+        masters = append(masters, master)
+        vlans = append(vlans, strconv.Itoa(int(vlan)))
+        subnets = append(subnets, ipamSubnets(l["ipam"])...)
+    }
+    sort.Strings(subnets)
+    return map[string]string{
+        "master":  strings.Join(masters, ","),
+        "vlan":    strings.Join(vlans, ","),
+        "subnets": strings.Join(subnets, ","),
+    }
+}
+
+// ipamSubnets returns the subnets of host-local style IPAM sections, with
+// a single subnet or ranges
+func ipamSubnets(v interface{}) []string {
+    ipam, ok := v.(map[string]interface{})
+    if !ok {
+        return nil
+    }
+    var subnets []string
+    if s, ok := ipam["subnet"].(string); ok {
+        subnets = append(subnets, s)
+    }
+    ranges, _ := ipam["ranges"].([]interface{})
+    for _, set := range ranges {
+        items, _ := set.([]interface{})
+        for _, r := range items {
+            if m, ok := r.(map[string]interface{}); ok {
+                if s, ok := m["subnet"].(string); ok {
+                    subnets = append(subnets, s)
+                }
+            }
+        }
+    }
+    return subnets
+}
+
+// postEvent records the drift on the NetworkAttachmentDefinition
+func (d *driftDetector) postEvent(ctx context.Context, dr *drift, msg string) error {
+    now := metav1.Now()
+    nad := dr.nad.nad
+    event := &corev1.Event{
+        ObjectMeta: metav1.ObjectMeta{
+            GenerateName: nad.GetName() + ".",
+            Namespace:    nad.GetNamespace(),
+        },
+        InvolvedObject: corev1.ObjectReference{
+            APIVersion: nadGVR.GroupVersion().String(),
+            Kind:       "NetworkAttachmentDefinition",
+            Namespace:  nad.GetNamespace(),
+            Name:       nad.GetName(),
+            UID:        nad.GetUID(),
+        },
+        Reason:         "ConfigDrift",
+        Message:        fmt.Sprintf("node %s: %s", d.nodeName, msg),
+        Type:           corev1.EventTypeWarning,
+        Source:         corev1.EventSource{Component: "vlan-cni-daemon", Host: d.nodeName},
+        FirstTimestamp: now,
+        LastTimestamp:  now,
+        Count:          1,
+    }
+    _, err := d.client.CoreV1().Events(nad.GetNamespace()).Create(ctx, event, metav1.CreateOptions{})
+    return err
+}
+
+// serveMetrics is the /metrics endpoint of the daemon API, in the
+// Prometheus text format
+func (d *driftDetector) serveMetrics(w http.ResponseWriter, req *http.Request) {
+    d.mu.Lock()
+    drifts := d.drifts
+    d.mu.Unlock()
+    
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    fmt.Fprintln(w, "# HELP vlan_cni_config_drift Fields a network definition file and a NetworkAttachmentDefinition of the same name disagree on.")
+    fmt.Fprintln(w, "# TYPE vlan_cni_config_drift gauge")
+    for _, dr := range drifts {
+        fmt.Fprintf(w, "vlan_cni_config_drift{network=%s,field=%s,file=%s,definition=%s} 1\n",
+            strconv.Quote(dr.network), strconv.Quote(dr.field), strconv.Quote(dr.file.source), strconv.Quote(dr.nad.source))
+    }
+    fmt.Fprintln(w, "# HELP vlan_cni_config_drift_total Number of drifting fields.")
+    fmt.Fprintln(w, "# TYPE vlan_cni_config_drift_total gauge")
+    fmt.Fprintf(w, "vlan_cni_config_drift_total %d\n", len(drifts))
+}
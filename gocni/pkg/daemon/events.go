@@ -0,0 +1,218 @@
+package daemon
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+
+    "example.com/vlan-cni/pkg/state"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// eventBuffer is how many events a subscriber may fall behind by before
+// its stream is closed
+const eventBuffer = 256
+
+// eventStream turns changes of the attachment records the plugin keeps into
+// events, and streams them to subscribers of /v1/events
+type eventStream struct {
+    conf     *EventsConfig
+    nodeName string
+    store    *state.Store
+
+    mu          sync.Mutex
+    subscribers map[chan *vlanv1.Event]bool
+    attachments map[string]*state.Attachment
+    synced      bool
+}
+
+func newEventStream(conf *EventsConfig, nodeName string, store *state.Store) *eventStream {
+    if conf == nil {
+        conf = &EventsConfig{}
+    }
+    return &eventStream{
+        conf:        conf,
+        nodeName:    nodeName,
+        store:       store,
+        subscribers: map[chan *vlanv1.Event]bool{},
+        attachments: map[string]*state.Attachment{},
+    }
+}
+
+// run publishes changes until ctx is done, then ends the streams
+func (e *eventStream) run(ctx context.Context) {
+    ticker := time.NewTicker(e.conf.Interval.Or(time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := e.poll(); err != nil {
+            log.Printf("events: poll failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            e.mu.Lock()
+            for ch := range e.subscribers {
+                close(ch)
+                delete(e.subscribers, ch)
+            }
+            e.mu.Unlock()
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// poll compares the attachment records with those seen last. The records
+// present at the first poll are the baseline, not new attachments.
+func (e *eventStream) poll() error {
+    list, err := e.store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    current := map[string]*state.Attachment{}
+    for _, a := range list {
+        current[a.ContainerID+"/"+a.IfName] = a
+    }
+    
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    
+    if e.synced {
+        now := time.Now().UTC()
+        for key, a := range current {
+            prev, ok := e.attachments[key]
+            switch {
+            case !ok || !prev.Created.Equal(a.Created):
+                e.publish(e.event(vlanv1.EventAttached, a, now))
+                if a.CheckError != "" {
+                    e.publish(e.event(vlanv1.EventCheckFailed, a, now))
+                }
+            case a.CheckError != "" && a.CheckError != prev.CheckError:
+                e.publish(e.event(vlanv1.EventCheckFailed, a, now))
+            case a.CheckError == "" && prev.CheckError != "":
+                e.publish(e.event(vlanv1.EventRepaired, a, now))
+            }
+        }
+        for key, a := range e.attachments {
+            if _, ok := current[key]; !ok {
+                e.publish(e.event(vlanv1.EventDetached, a, now))
+            }
+        }
+    }
+    e.attachments = current
+    e.synced = true
+    return nil
+}
+
+func (e *eventStream) event(typ string, a *state.Attachment, now time.Time) *vlanv1.Event {
+    ev := &vlanv1.Event{
+        TypeMeta:     vlanv1.Meta(vlanv1.KindEvent),
+        Type:         typ,
+        Time:         now,
+        Node:         e.nodeName,
+        ContainerID:  a.ContainerID,
+        IfName:       a.IfName,
+        Network:      a.Network,
+        Master:       a.Master,
+        VlanID:       a.VlanID,
+        PodNamespace: a.PodNamespace,
+        PodName:      a.PodName,
+        IPs:          a.IPs,
+    }
+    if typ == vlanv1.EventCheckFailed {
+        ev.Error = a.CheckError
+    }
+    return ev
+}
+
+// publish hands ev to every subscriber, dropping those too slow to keep up
+// rather than holding the others back. It is called with mu held.
+func (e *eventStream) publish(ev *vlanv1.Event) {
+    for ch := range e.subscribers {
+        select {
+        case ch <- ev:
+        default:
+            log.Printf("events: subscriber fell %d events behind, closing its stream", eventBuffer)
+            close(ch)
+            delete(e.subscribers, ch)
+        }
+    }
+}
+
+// subscribe returns a channel of events, preceded by Attached events for
+// the current attachments when initial is set
+func (e *eventStream) subscribe(initial bool) chan *vlanv1.Event {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    
+    ch := make(chan *vlanv1.Event, eventBuffer)
+    if initial {
+        keys := make([]string, 0, len(e.attachments))
+        for key := range e.attachments {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        now := time.Now().UTC()
+        for _, key := range keys {
+            // Attachments beyond the buffer arrive in the first poll
+            // instead of blocking
+            select {
+            case ch <- e.event(vlanv1.EventAttached, e.attachments[key], now):
+            default:
+            }
+        }
+    }
+    e.subscribers[ch] = true
+    return ch
+}
+
+func (e *eventStream) unsubscribe(ch chan *vlanv1.Event) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    
+    if e.subscribers[ch] {
+        close(ch)
+        delete(e.subscribers, ch)
+    }
+}
+
+// serveEvents is the /v1/events endpoint of the daemon API. It streams
+// events as JSON lines until the client goes away; ?network= limits them to
+// one network and ?initial=true starts with the current attachments.
+func (e *eventStream) serveEvents(w http.ResponseWriter, req *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+    network := req.URL.Query().Get("network")
+    ch := e.subscribe(req.URL.Query().Get("initial") == "true")
+    defer e.unsubscribe(ch)
+    
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    w.WriteHeader(http.StatusOK)
+    flusher.Flush()
+    
+    enc := json.NewEncoder(w)
+    for {
+        select {
+        case <-req.Context().Done():
+            return
+        case ev, ok := <-ch:
+            if !ok {
+                return
+            }
+            if network != "" && ev.Network != network {
+                continue
+            }
+            if err := enc.Encode(ev); err != nil {
+                return
+            }
+            flusher.Flush()
+        }
+    }
+}
@@ -0,0 +1,234 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "sync"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/leaderelection"
+    "k8s.io/client-go/tools/leaderelection/resourcelock"
+
+    "example.com/vlan-cni/pkg/arp"
+)
+
+// garpCount is how many gratuitous ARPs are sent when taking over an address
+const garpCount = 3
+
+// floatingIPBackoff spaces out attempts to take an address after failures,
+// so a missing interface does not spin the loop or churn the Lease
+func floatingIPBackoff() wait.Backoff {
+    return wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.5, Steps: 8, Cap: 2 * time.Minute}
+}
+
+// floatingIP keeps a VIP on this node while it is healthy and, when a Lease
+// is configured, while this node holds the Lease
+type floatingIP struct {
+    conf     FloatingIPConfig
+    addr     *net.IPNet
+    identity string
+    client   kubernetes.Interface
+    health   *healthChecker
+}
+
+func newFloatingIP(conf FloatingIPConfig, identity string, client kubernetes.Interface) (*floatingIP, error) {
+    ip, ipnet, err := net.ParseCIDR(conf.Address)
+    if err != nil {
+        return nil, fmt.Errorf("floating IP %q: invalid address %q: %v", conf.Name, conf.Address, err)
+    }
+    ipnet.IP = ip
+    
+    if conf.Lease != nil && client == nil {
+        return nil, fmt.Errorf("floating IP %q: lease election needs a Kubernetes client", conf.Name)
+    }
+    
+    return &floatingIP{
+        conf:     conf,
+        addr:     ipnet,
+        identity: identity,
+        client:   client,
+        health:   &healthChecker{conf: conf.HealthCheck},
+    }, nil
+}
+
+// run manages the address until ctx is done, backing off while taking it
+// keeps failing
+func (f *floatingIP) run(ctx context.Context) {
+    backoff := floatingIPBackoff()
+    for ctx.Err() == nil {
+        if err := f.health.waitHealthy(ctx); err != nil {
+            return
+        }
+        
+        // Stop holding or contending for the address once health fails
+        holdCtx, cancel := context.WithCancel(ctx)
+        go f.health.watch(holdCtx, func() {
+            log.Printf("floating IP %s: health check failing, stepping down", f.conf.Name)
+            cancel()
+        })
+        
+        var err error
+        if f.conf.Lease == nil {
+            err = f.hold(holdCtx)
+        } else {
+            err = f.elect(holdCtx)
+        }
+        cancel()
+        if err == nil {
+            backoff = floatingIPBackoff()
+            continue
+        }
+        
+        delay := backoff.Step()
+        log.Printf("floating IP %s: %v, retrying in %s", f.conf.Name, err, delay.Round(time.Millisecond))
+        select {
+        case <-ctx.Done():
+        case <-time.After(delay):
+        }
+    }
+}
+
+// elect contends for the Lease and holds the address while leading. When
+// the address cannot be taken it gives the Lease up and returns why.
+func (f *floatingIP) elect(ctx context.Context) error {
+    electCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    
+    holdErr := make(chan error, 1)
+    
+    // The elector runs OnStartedLeading in its own goroutine and returns
+    // without waiting for it, so the hold is joined below: a stale one
+    // left running would release the address after the next election
+    // took it again
+    var (
+        mu      sync.Mutex
+        stopped bool
+        held    sync.WaitGroup
+    )
+    lease := f.conf.Lease
+    lock := &resourcelock.LeaseLock{
+        LeaseMeta: metav1.ObjectMeta{
+            Name:      lease.Name,
+            Namespace: lease.Namespace,
+        },
+        Client: f.client.CoordinationV1(),
+        LockConfig: resourcelock.ResourceLockConfig{
+            Identity: f.identity,
+        },
+    }
+    
+    leaderelection.RunOrDie(electCtx, leaderelection.LeaderElectionConfig{
+        Lock:            lock,
+        LeaseDuration:   lease.LeaseDuration.Or(15 * time.Second),
+        RenewDeadline:   lease.RenewDeadline.Or(10 * time.Second),
+        RetryPeriod:     lease.RetryPeriod.Or(2 * time.Second),
+        ReleaseOnCancel: true,
+        Name:            f.conf.Name,
+        Callbacks: leaderelection.LeaderCallbacks{
+            OnStartedLeading: func(ctx context.Context) {
+                mu.Lock()
+                if stopped {
+                    mu.Unlock()
+                    return
+                }
+                held.Add(1)
+                mu.Unlock()
+                defer held.Done()
+                
+                if err := f.hold(ctx); err != nil {
+                    holdErr <- err
+                    cancel()
+                }
+            },
+            OnStoppedLeading: func() {},
+        },
+    })
+    cancel()
+    mu.Lock()
+    stopped = true
+    mu.Unlock()
+    held.Wait()
+    
+    select {
+    case err := <-holdErr:
+        return err
+    default:
+        return nil
+    }
+}
+
+// hold assigns the address until ctx is done, then removes it
+func (f *floatingIP) hold(ctx context.Context) error {
+    if err := f.acquire(); err != nil {
+        _ = f.release()
+        return fmt.Errorf("failed to acquire %s: %v", f.addr, err)
+    }
+    log.Printf("floating IP %s: holding %s on %s", f.conf.Name, f.addr, f.conf.Interface)
+    
+    <-ctx.Done()
+    
+    if err := f.release(); err != nil {
+        log.Printf("floating IP %s: failed to release %s: %v", f.conf.Name, f.addr, err)
+        return nil
+    }
+    log.Printf("floating IP %s: released %s", f.conf.Name, f.addr)
+    return nil
+}
+
+// acquire adds the address to the interface and announces it
+func (f *floatingIP) acquire() error {
+    return f.inNetns(func() error {
+        link, err := netlink.LinkByName(f.conf.Interface)
+        if err != nil {
+            return fmt.Errorf("failed to lookup interface %q: %v", f.conf.Interface, err)
+        }
+        if err := netlink.AddrReplace(link, &netlink.Addr{IPNet: f.addr}); err != nil {
+            return fmt.Errorf("failed to add %s to %q: %v", f.addr, f.conf.Interface, err)
+        }
+        for i := 0; i < garpCount; i++ {
+            if err := arp.SendGratuitous(f.conf.Interface, f.addr.IP); err != nil {
+                return err
+            }
+            time.Sleep(100 * time.Millisecond)
+        }
+        return nil
+    })
+}
+
+// release removes the address from the interface
+func (f *floatingIP) release() error {
+    return f.inNetns(func() error {
+        link, err := netlink.LinkByName(f.conf.Interface)
+        if err != nil {
+            return fmt.Errorf("failed to lookup interface %q: %v", f.conf.Interface, err)
+        }
+        if err := netlink.AddrDel(link, &netlink.Addr{IPNet: f.addr}); err != nil {
+            return fmt.Errorf("failed to remove %s from %q: %v", f.addr, f.conf.Interface, err)
+        }
+        return nil
+    })
+}
+
+// inNetns runs fn in the configured network namespace
+func (f *floatingIP) inNetns(fn func() error) error {
+    if f.conf.Netns == "" {
+        return fn()
+    }
+    
+    netns, err := ns.GetNS(f.conf.Netns)
+    if err != nil {
+        return fmt.Errorf("failed to open netns %q: %v", f.conf.Netns, err)
+    }
+    defer netns.Close()
+    
+    return netns.Do(func(ns.NetNS) error {
+        return fn()
+    })
+}
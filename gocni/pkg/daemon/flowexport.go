@@ -0,0 +1,248 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "os/exec"
+    "strings"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/containernetworking/plugins/pkg/utils/sysctl"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/ipfix"
+    "example.com/vlan-cni/pkg/state"
+)
+
+const defaultFlowExportInterval = 60 * time.Second
+
+// flowTrackingRules make the kernel track the connections of a pod's
+// namespace, which it otherwise only does once a rule there needs it. The
+// rules carry the pod's identity, so whoever lists them can tell whose they
+// are.
+func flowTrackingRules(id state.Identity) string {
+    comment := nftComment(fmt.Sprintf("vlan-cni pod=%s/%s uid=%s sa=%s", id.PodNamespace, id.PodName, id.PodUID, id.ServiceAccount))
+    return fmt.Sprintf(`add table inet vlan_cni_flows
+flush table inet vlan_cni_flows
+table inet vlan_cni_flows {
+    chain prerouting { type filter hook prerouting priority -150; ct state new accept comment "%[1]s"; }
+    chain output { type filter hook output priority -150; ct state new accept comment "%[1]s"; }
+}
+`, comment)
+}
+
+// nftMaxComment is the longest comment nftables stores
+const nftMaxComment = 128
+
+// nftComment makes s safe to quote in an nftables comment. Kubernetes names
+// and UIDs pass unchanged.
+func nftComment(s string) string {
+    s = strings.Map(func(r rune) rune {
+        if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+            return '_'
+        }
+        return r
+    }, s)
+    if len(s) > nftMaxComment-1 {
+        s = s[:nftMaxComment-1]
+    }
+    return s
+}
+
+// flowKey identifies one direction of a tracked connection of a pod
+type flowKey struct {
+    containerID      string
+    src, dst         string
+    srcPort, dstPort uint16
+    protocol         uint8
+}
+
+type flowCounters struct {
+    octets, packets uint64
+    start           time.Time
+}
+
+// flowExporter exports the connections of attachments as IPFIX records,
+// read from the conntrack table of each pod's namespace
+type flowExporter struct {
+    conf     *FlowExportConfig
+    store    *state.Store
+    networks map[string]bool
+
+    exporter *ipfix.Exporter
+    tracking map[string]bool
+    last     map[flowKey]flowCounters
+}
+
+func newFlowExporter(conf *FlowExportConfig, store *state.Store) (*flowExporter, error) {
+    exporter, err := ipfix.Dial(conf.Collector, conf.ObservationDomainID)
+    if err != nil {
+        return nil, err
+    }
+    f := &flowExporter{
+        conf:     conf,
+        store:    store,
+        exporter: exporter,
+        tracking: map[string]bool{},
+        last:     map[flowKey]flowCounters{},
+    }
+    if len(conf.Networks) > 0 {
+        f.networks = map[string]bool{}
+        for _, n := range conf.Networks {
+            f.networks[n] = true
+        }
+    }
+    return f, nil
+}
+
+// run exports until ctx is done
+func (f *flowExporter) run(ctx context.Context) {
+    defer f.exporter.Close()
+    ticker := time.NewTicker(f.conf.Interval.Or(defaultFlowExportInterval))
+    defer ticker.Stop()
+    
+    for {
+        if err := f.export(); err != nil {
+            log.Printf("flow export: export failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (f *flowExporter) export() error {
+    attachments, err := f.store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    
+    now := time.Now()
+    var records []ipfix.Record
+    seen := map[flowKey]bool{}
+    pods := map[string]bool{}
+    for _, a := range attachments {
+        if f.networks != nil && !f.networks[a.Network] {
+            continue
+        }
+        pods[a.ContainerID] = true
+        flows, err := f.conntrack(a)
+        if err != nil {
+            log.Printf("flow export: pod %s/%s: %v", a.PodNamespace, a.PodName, err)
+            continue
+        }
+        addrs := attachmentIPs(a)
+        for _, flow := range flows {
+            // The pod's namespace also tracks its other interfaces
+            if !addrs[flow.Forward.SrcIP.String()] && !addrs[flow.Forward.DstIP.String()] {
+                continue
+            }
+            for _, t := range []struct {
+                src, dst         net.IP
+                srcPort, dstPort uint16
+                octets, packets  uint64
+            }{
+                {flow.Forward.SrcIP, flow.Forward.DstIP, flow.Forward.SrcPort, flow.Forward.DstPort, flow.Forward.Bytes, flow.Forward.Packets},
+                {flow.Reverse.SrcIP, flow.Reverse.DstIP, flow.Reverse.SrcPort, flow.Reverse.DstPort, flow.Reverse.Bytes, flow.Reverse.Packets},
+            } {
+                key := flowKey{a.ContainerID, t.src.String(), t.dst.String(), t.srcPort, t.dstPort, flow.Forward.Protocol}
+                seen[key] = true
+                prev, ok := f.last[key]
+                if !ok {
+                    prev.start = now
+                    if flow.TimeStart != 0 {
+                        prev.start = time.Unix(0, int64(flow.TimeStart))
+                    }
+                }
+                f.last[key] = flowCounters{octets: t.octets, packets: t.packets, start: prev.start}
+                if t.packets <= prev.packets {
+                    continue
+                }
+                records = append(records, ipfix.Record{
+                    Src:      t.src,
+                    Dst:      t.dst,
+                    SrcPort:  t.srcPort,
+                    DstPort:  t.dstPort,
+                    Protocol: flow.Forward.Protocol,
+                    Octets:   t.octets - prev.octets,
+                    Packets:  t.packets - prev.packets,
+                    VlanID:   uint16(a.VlanID),
+                    Start:    prev.start,
+                    End:      now,
+                    IfName:   a.IfName,
+                    Pod:      a.PodNamespace + "/" + a.PodName,
+                })
+            }
+        }
+    }
+    for key := range f.last {
+        if !seen[key] {
+            delete(f.last, key)
+        }
+    }
+    for id := range f.tracking {
+        if !pods[id] {
+            delete(f.tracking, id)
+        }
+    }
+    return f.exporter.Export(records)
+}
+
+// conntrack lists the pod's connections, making sure they are tracked with
+// their byte counts first
+func (f *flowExporter) conntrack(a *state.Attachment) ([]*netlink.ConntrackFlow, error) {
+    netns, err := ns.GetNS(a.Netns)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open netns %q: %v", a.Netns, err)
+    }
+    defer netns.Close()
+    
+    var flows []*netlink.ConntrackFlow
+    err = netns.Do(func(ns.NetNS) error {
+        if !f.tracking[a.ContainerID] {
+            if err := trackFlows(a.Identity); err != nil {
+                return err
+            }
+            f.tracking[a.ContainerID] = true
+        }
+        for _, family := range []netlink.InetFamily{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+            l, err := netlink.ConntrackTableList(netlink.ConntrackTable, family)
+            if err != nil {
+                return fmt.Errorf("failed to list connections: %v", err)
+            }
+            flows = append(flows, l...)
+        }
+        return nil
+    })
+    return flows, err
+}
+
+// trackFlows turns on connection tracking and its accounting in the
+// current namespace
+func trackFlows(id state.Identity) error {
+    if _, err := sysctl.Sysctl("net/netfilter/nf_conntrack_acct", "1"); err != nil {
+        return fmt.Errorf("failed to enable conntrack accounting (is nf_conntrack loaded?): %v", err)
+    }
+    cmd := exec.Command("nft", "-f", "-")
+    cmd.Stdin = strings.NewReader(flowTrackingRules(id))
+    if out, err := cmd.CombinedOutput(); err != nil {
+        return fmt.Errorf("failed to add connection tracking rules: %v: %s", err, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
+
+// attachmentIPs is the set of the attachment's addresses
+func attachmentIPs(a *state.Attachment) map[string]bool {
+    addrs := map[string]bool{}
+    for _, cidr := range a.IPs {
+        if ip, _, err := net.ParseCIDR(cidr); err == nil {
+            addrs[ip.String()] = true
+        }
+    }
+    return addrs
+}
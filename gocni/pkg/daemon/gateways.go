@@ -0,0 +1,121 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+
+    "example.com/vlan-cni/pkg/state"
+    "example.com/vlan-cni/pkg/vrrp"
+)
+
+const (
+    defaultGatewaysInterval = 10 * time.Second
+    gatewayListenTimeout    = 3 * time.Second
+)
+
+// gatewayWatch tracks the active router of each network's virtual gateway
+// from one of its pods, logging failovers as they happen
+type gatewayWatch struct {
+    conf  *GatewaysConfig
+    store *state.Store
+}
+
+func newGatewayWatch(conf *GatewaysConfig, store *state.Store) *gatewayWatch {
+    return &gatewayWatch{conf: conf, store: store}
+}
+
+// run watches until ctx is done
+func (g *gatewayWatch) run(ctx context.Context) {
+    ticker := time.NewTicker(g.conf.Interval.Or(defaultGatewaysInterval))
+    defer ticker.Stop()
+    
+    for {
+        if err := g.watch(ctx); err != nil {
+            log.Printf("gateways: watch failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (g *gatewayWatch) watch(ctx context.Context) error {
+    attachments, err := g.store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    
+    // Any pod of a network hears its gateway
+    watched := map[string]bool{}
+    for _, a := range attachments {
+        if a.Gateway == nil || watched[a.Network] {
+            continue
+        }
+        router, err := listenGateway(ctx, a)
+        if err != nil {
+            // The next pod of the network may still be able to listen
+            if _, ok := err.(*gatewaySilentError); !ok {
+                log.Printf("gateways: pod %s/%s: %v", a.PodNamespace, a.PodName, err)
+                continue
+            }
+        }
+        watched[a.Network] = true
+        
+        previous, changed, err := g.store.RecordGatewayRouter(a.Network, a.Gateway.Address, a.Gateway.VRID, router)
+        if err != nil {
+            return err
+        }
+        switch {
+        case changed && router == "":
+            log.Printf("gateways: network %s lost its gateway %s, no VRRP master heard since %s was", a.Network, a.Gateway.Address, previous)
+        case changed && previous == "":
+            log.Printf("gateways: network %s gateway %s is held by %s again", a.Network, a.Gateway.Address, router)
+        case changed:
+            log.Printf("gateways: network %s gateway %s failed over from %s to %s", a.Network, a.Gateway.Address, previous, router)
+        }
+    }
+    return nil
+}
+
+// gatewaySilentError is no advertisement heard within the timeout
+type gatewaySilentError struct {
+    err error
+}
+
+func (e *gatewaySilentError) Error() string {
+    return e.err.Error()
+}
+
+// listenGateway returns the address of the router advertising the
+// attachment's virtual gateway
+func listenGateway(ctx context.Context, a *state.Attachment) (string, error) {
+    netns, err := ns.GetNS(a.Netns)
+    if err != nil {
+        return "", fmt.Errorf("failed to open netns %q: %v", a.Netns, err)
+    }
+    defer netns.Close()
+    
+    ctx, cancel := context.WithTimeout(ctx, gatewayListenTimeout)
+    defer cancel()
+    
+    var router string
+    err = netns.Do(func(ns.NetNS) error {
+        advert, err := vrrp.Listen(ctx, a.PodInterface(), a.Gateway.VRID, net.ParseIP(a.Gateway.Address))
+        if err != nil {
+            if ctx.Err() != nil {
+                return &gatewaySilentError{err}
+            }
+            return err
+        }
+        router = advert.Router.String()
+        return nil
+    })
+    return router, err
+}
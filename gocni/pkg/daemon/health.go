@@ -0,0 +1,109 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/http"
+    "os/exec"
+    "time"
+)
+
+// healthChecker probes whether this node may hold a floating IP. A nil
+// checker always reports healthy.
+type healthChecker struct {
+    conf *HealthCheckConfig
+}
+
+func (h *healthChecker) interval() time.Duration {
+    return h.conf.Interval.Or(5 * time.Second)
+}
+
+// check runs a single probe
+func (h *healthChecker) check(ctx context.Context) error {
+    if h == nil || h.conf == nil {
+        return nil
+    }
+    
+    ctx, cancel := context.WithTimeout(ctx, h.conf.Timeout.Or(2*time.Second))
+    defer cancel()
+    
+    switch h.conf.Type {
+    case "tcp":
+        var d net.Dialer
+        conn, err := d.DialContext(ctx, "tcp", h.conf.Target)
+        if err != nil {
+            return err
+        }
+        return conn.Close()
+    case "http":
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.conf.Target, nil)
+        if err != nil {
+            return err
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            return err
+        }
+        resp.Body.Close()
+        if resp.StatusCode >= 400 {
+            return fmt.Errorf("health check returned %s", resp.Status)
+        }
+        return nil
+    case "exec":
+        return exec.CommandContext(ctx, h.conf.Command[0], h.conf.Command[1:]...).Run()
+    }
+    return fmt.Errorf("unknown health check type %q", h.conf.Type)
+}
+
+// waitHealthy blocks until a probe succeeds or ctx is done
+func (h *healthChecker) waitHealthy(ctx context.Context) error {
+    if h == nil || h.conf == nil {
+        return ctx.Err()
+    }
+    
+    for {
+        if err := h.check(ctx); err == nil {
+            return nil
+        }
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(h.interval()):
+        }
+    }
+}
+
+// watch probes until ctx is done and calls onFail once the failure threshold
+// is reached
+func (h *healthChecker) watch(ctx context.Context, onFail func()) {
+    if h == nil || h.conf == nil {
+        return
+    }
+    
+    threshold := h.conf.FailureThreshold
+    if threshold < 1 {
+        threshold = 3
+    }
+    
+    failures := 0
+    ticker := time.NewTicker(h.interval())
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        
+        if err := h.check(ctx); err != nil {
+            failures++
+            if failures >= threshold {
+                onFail()
+                return
+            }
+            continue
+        }
+        failures = 0
+    }
+}
@@ -0,0 +1,238 @@
+package daemon
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "syscall"
+
+    "github.com/vishvananda/netlink"
+)
+
+// Algorithms of natively keyed SAs. The keys are static, so rather than
+// GCM, whose nonces would repeat once sequence numbers restart with the
+// daemon, CBC with a chained IV.
+const (
+    xfrmCrypt = "cbc(aes)"
+    xfrmAuth  = "hmac(sha256)"
+)
+
+// xfrmSPIBase keeps the SPIs of sites apart from those charon allocates
+const xfrmSPIBase = 0x7e000000
+
+// direction is one way of a VLAN's traffic between the sites
+type direction struct {
+    src, dst net.IP
+    dir      netlink.Dir
+}
+
+func (s *site) directions() []direction {
+    return []direction{
+        {src: s.local, dst: s.remote, dir: netlink.XFRM_DIR_OUT},
+        {src: s.remote, dst: s.local, dir: netlink.XFRM_DIR_IN},
+    }
+}
+
+// installXfrm keys a pair of transport mode SAs per VLAN from the PSK, so
+// both sites derive the same ones without negotiating
+func (s *site) installXfrm(psk string) error {
+    for _, vlanID := range s.conf.VlanIDs {
+        for _, d := range s.directions() {
+            if err := ensureXfrmState(s.xfrmState(psk, d, vlanID)); err != nil {
+                return fmt.Errorf("vlan %d: %v", vlanID, err)
+            }
+            if err := netlink.XfrmPolicyUpdate(s.xfrmPolicy(d, vlanID)); err != nil {
+                return fmt.Errorf("vlan %d: failed to install %s policy: %v", vlanID, d.dir, err)
+            }
+        }
+    }
+    return nil
+}
+
+// ensureXfrmState adds the SA, replacing one whose keys changed with the
+// PSK. The kernel does not update the keys of existing SAs.
+func ensureXfrmState(sa *netlink.XfrmState) error {
+    err := netlink.XfrmStateAdd(sa)
+    if err == nil || !os.IsExist(err) {
+        return err
+    }
+    existing, err := netlink.XfrmStateGet(sa)
+    if err != nil {
+        return fmt.Errorf("failed to look up SA 0x%x: %v", sa.Spi, err)
+    }
+    if existing.Crypt != nil && bytes.Equal(existing.Crypt.Key, sa.Crypt.Key) &&
+        existing.Auth != nil && bytes.Equal(existing.Auth.Key, sa.Auth.Key) {
+        return nil
+    }
+    if err := netlink.XfrmStateDel(sa); err != nil {
+        return fmt.Errorf("failed to remove SA 0x%x: %v", sa.Spi, err)
+    }
+    if err := netlink.XfrmStateAdd(sa); err != nil {
+        return fmt.Errorf("failed to rekey SA 0x%x: %v", sa.Spi, err)
+    }
+    return nil
+}
+
+// removeXfrm deletes the policies and SAs of the site's VLANs
+func (s *site) removeXfrm() error {
+    var errs []string
+    for _, vlanID := range s.conf.VlanIDs {
+        for _, d := range s.directions() {
+            if err := netlink.XfrmPolicyDel(s.xfrmPolicy(d, vlanID)); err != nil && !errors.Is(err, syscall.ENOENT) {
+                errs = append(errs, fmt.Sprintf("vlan %d: failed to remove %s policy: %v", vlanID, d.dir, err))
+            }
+            if err := netlink.XfrmStateDel(s.xfrmState("", d, vlanID)); err != nil && !errors.Is(err, syscall.ESRCH) {
+                errs = append(errs, fmt.Sprintf("vlan %d: failed to remove SA: %v", vlanID, err))
+            }
+        }
+    }
+    if len(errs) > 0 {
+        return fmt.Errorf("%s", strings.Join(errs, "; "))
+    }
+    return nil
+}
+
+// xfrmState is the SA of one direction of a VLAN. The replay window
+// rejects captured packets sent again.
+func (s *site) xfrmState(psk string, d direction, vlanID int) *netlink.XfrmState {
+    spi := s.spi(d, vlanID)
+    return &netlink.XfrmState{
+        Src:          d.src,
+        Dst:          d.dst,
+        Proto:        netlink.XFRM_PROTO_ESP,
+        Mode:         netlink.XFRM_MODE_TRANSPORT,
+        Spi:          spi,
+        Reqid:        spi,
+        ReplayWindow: s.conf.IPsec.ReplayWindow,
+        Crypt:        &netlink.XfrmStateAlgo{Name: xfrmCrypt, Key: siteKey(psk, "crypt", d, vlanID)},
+        Auth:         &netlink.XfrmStateAlgo{Name: xfrmAuth, Key: siteKey(psk, "auth", d, vlanID), TruncateLen: 128},
+    }
+}
+
+// xfrmPolicy requires ESP for the VLAN's tunnel port in one direction
+func (s *site) xfrmPolicy(d direction, vlanID int) *netlink.XfrmPolicy {
+    port := s.port(vlanID)
+    return &netlink.XfrmPolicy{
+        Src:     hostNet(d.src),
+        Dst:     hostNet(d.dst),
+        Proto:   netlink.Proto(syscall.IPPROTO_UDP),
+        SrcPort: port,
+        DstPort: port,
+        Dir:     d.dir,
+        Tmpls: []netlink.XfrmPolicyTmpl{{
+            Src:   d.src,
+            Dst:   d.dst,
+            Proto: netlink.XFRM_PROTO_ESP,
+            Mode:  netlink.XFRM_MODE_TRANSPORT,
+            Reqid: s.spi(d, vlanID),
+        }},
+    }
+}
+
+// spi tells the directions of a VLAN apart by which end has the lower
+// address, which both sites agree on
+func (s *site) spi(d direction, vlanID int) int {
+    spi := xfrmSPIBase | vlanID
+    if bytes.Compare(d.src.To16(), d.dst.To16()) > 0 {
+        spi |= 1 << 16
+    }
+    return spi
+}
+
+// siteKey derives a 256-bit key of one direction of a VLAN from the PSK
+func siteKey(psk, label string, d direction, vlanID int) []byte {
+    mac := hmac.New(sha256.New, []byte(psk))
+    fmt.Fprintf(mac, "vlan-cni site %s %s>%s vlan %d", label, d.src, d.dst, vlanID)
+    return mac.Sum(nil)
+}
+
+func hostNet(ip net.IP) *net.IPNet {
+    bits := 128
+    if ip.To4() != nil {
+        ip, bits = ip.To4(), 32
+    }
+    return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// loadSwanctl writes the site's connection for charon, one CHILD_SA per
+// VLAN trapping its tunnel port, and loads it unless charon has it already
+func (s *site) loadSwanctl(ctx context.Context, psk string) error {
+    path := s.swanctlFile()
+    conf := s.swanctlConf(psk)
+    current, err := ioutil.ReadFile(path)
+    changed := err != nil || !bytes.Equal(current, conf)
+    if changed {
+        if err := ioutil.WriteFile(path, conf, 0600); err != nil {
+            return fmt.Errorf("failed to write %s: %v", path, err)
+        }
+    }
+    if !changed {
+        out, err := exec.CommandContext(ctx, "swanctl", "--list-conns").CombinedOutput()
+        if err == nil && strings.Contains(string(out), s.swanctlConn()+":") {
+            return nil
+        }
+    }
+    return swanctl(ctx, "--load-all", "--noprompt")
+}
+
+// unloadSwanctl removes the connection and closes its SAs
+func (s *site) unloadSwanctl() error {
+    if err := os.Remove(s.swanctlFile()); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("failed to remove %s: %v", s.swanctlFile(), err)
+    }
+    // Loading drops connections that left the configuration
+    if err := swanctl(context.Background(), "--load-all", "--noprompt"); err != nil {
+        return err
+    }
+    _ = swanctl(context.Background(), "--terminate", "--ike", s.swanctlConn())
+    return nil
+}
+
+func (s *site) swanctlConf(psk string) []byte {
+    var b bytes.Buffer
+    conn := s.swanctlConn()
+    fmt.Fprintf(&b, "# Written by the vlan-cni daemon for site %s\n", s.conf.Name)
+    fmt.Fprintf(&b, "connections {\n    %s {\n", conn)
+    fmt.Fprintf(&b, "        version = 2\n        local_addrs = %s\n        remote_addrs = %s\n", s.conf.Local, s.conf.Remote)
+    fmt.Fprintf(&b, "        local {\n            auth = psk\n            id = %s\n        }\n", s.conf.Local)
+    fmt.Fprintf(&b, "        remote {\n            auth = psk\n            id = %s\n        }\n", s.conf.Remote)
+    fmt.Fprintf(&b, "        children {\n")
+    for _, vlanID := range s.conf.VlanIDs {
+        ts := fmt.Sprintf("dynamic[udp/%d]", s.port(vlanID))
+        fmt.Fprintf(&b, "            vlan%d {\n", vlanID)
+        fmt.Fprintf(&b, "                mode = transport\n                local_ts = %s\n                remote_ts = %s\n", ts, ts)
+        fmt.Fprintf(&b, "                esp_proposals = aes256gcm16\n                start_action = trap\n")
+        fmt.Fprintf(&b, "            }\n")
+    }
+    fmt.Fprintf(&b, "        }\n    }\n}\n")
+    fmt.Fprintf(&b, "secrets {\n    ike-%s {\n", conn)
+    fmt.Fprintf(&b, "        id-local = %s\n        id-remote = %s\n        secret = 0x%s\n", s.conf.Local, s.conf.Remote, hex.EncodeToString([]byte(psk)))
+    fmt.Fprintf(&b, "    }\n}\n")
+    return b.Bytes()
+}
+
+func (s *site) swanctlConn() string {
+    return "vlan-cni-" + s.conf.Name
+}
+
+func (s *site) swanctlFile() string {
+    return filepath.Join(s.conf.IPsec.SwanctlDir, s.swanctlConn()+".conf")
+}
+
+func swanctl(ctx context.Context, args ...string) error {
+    out, err := exec.CommandContext(ctx, "swanctl", args...).CombinedOutput()
+    if err != nil {
+        return fmt.Errorf("swanctl %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
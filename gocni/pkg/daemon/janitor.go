@@ -0,0 +1,118 @@
+package daemon
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/hostlink"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// janitor deletes the host devices the plugin marked that no attachment
+// has used for the TTL. The marks carry the creation time, so devices left
+// by an unclean shutdown age out like any other.
+type janitor struct {
+    conf  *JanitorConfig
+    store *state.Store
+}
+
+func newJanitor(conf *JanitorConfig, store *state.Store) *janitor {
+    return &janitor{conf: conf, store: store}
+}
+
+// run sweeps until ctx is done
+func (j *janitor) run(ctx context.Context) {
+    ticker := time.NewTicker(j.conf.SyncInterval.Or(5 * time.Minute))
+    defer ticker.Stop()
+    
+    for {
+        if err := j.sweep(); err != nil {
+            log.Printf("janitor: sweep failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// sweep deletes the stale devices of the host namespace
+func (j *janitor) sweep() error {
+    links, err := netlink.LinkList()
+    if err != nil {
+        return fmt.Errorf("failed to list links: %v", err)
+    }
+    attachments, err := j.store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    
+    var errs []string
+    for _, link := range stale(links, attachments, j.conf.TTL.Or(DefaultJanitorTTL), time.Now()) {
+        name := link.Attrs().Name
+        // Deleting one end of a veth takes the other with it
+        if err := netlink.LinkDel(link); errors.Is(err, syscall.ENODEV) {
+            continue
+        } else if err != nil {
+            errs = append(errs, fmt.Sprintf("failed to delete %q: %v", name, err))
+            continue
+        }
+        log.Printf("janitor: deleted unused %s %s", link.Type(), name)
+    }
+    if len(errs) > 0 {
+        return fmt.Errorf("%s", strings.Join(errs, "; "))
+    }
+    return nil
+}
+
+// stale returns the marked links older than ttl that nothing uses. A link
+// is used when an attachment names it, when it is a veth whose peer is in
+// a pod, or when it is a bridge with a used or unmarked port. Ports of a
+// bridge, such as its pseudowire, live as long as the bridge.
+func stale(links []netlink.Link, attachments []*state.Attachment, ttl time.Duration, now time.Time) []netlink.Link {
+    named := map[string]bool{}
+    for _, a := range attachments {
+        named[a.HostIfName] = true
+    }
+    byIndex := map[int]netlink.Link{}
+    for _, l := range links {
+        byIndex[l.Attrs().Index] = l
+    }
+    
+    used := map[int]bool{}
+    for _, l := range links {
+        attrs := l.Attrs()
+        _, marked := hostlink.Created(l)
+        if !marked || named[attrs.Name] || (l.Type() == "veth" && attrs.NetNsID >= 0) {
+            used[attrs.Index] = true
+        }
+    }
+    for _, l := range links {
+        attrs := l.Attrs()
+        if bridge, ok := byIndex[attrs.MasterIndex].(*netlink.Bridge); ok && used[attrs.Index] {
+            used[bridge.Attrs().Index] = true
+        }
+    }
+    
+    var found []netlink.Link
+    for _, l := range links {
+        attrs := l.Attrs()
+        created, marked := hostlink.Created(l)
+        if !marked || used[attrs.Index] || now.Sub(created) < ttl {
+            continue
+        }
+        if _, ok := byIndex[attrs.MasterIndex].(*netlink.Bridge); ok && used[attrs.MasterIndex] {
+            continue
+        }
+        found = append(found, l)
+    }
+    return found
+}
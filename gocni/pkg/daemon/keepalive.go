@@ -0,0 +1,84 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+
+    "example.com/vlan-cni/pkg/arp"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// keepalives sends gratuitous ARPs from pods attached with
+// stp.keepaliveSeconds, so switch ports coming out of spanning tree
+// learning find their MACs right away
+type keepalives struct {
+    conf   *KeepalivesConfig
+    store  *state.Store
+    health *podHealth
+}
+
+func newKeepalives(conf *KeepalivesConfig, store *state.Store, health *podHealth) *keepalives {
+    return &keepalives{conf: conf, store: store, health: health}
+}
+
+// run sends keepalives until ctx is done
+func (k *keepalives) run(ctx context.Context) {
+    ticker := time.NewTicker(k.conf.Interval.Or(time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := k.send(); err != nil {
+            log.Printf("keepalives: send failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (k *keepalives) send() error {
+    attachments, err := k.store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    now := time.Now()
+    for _, a := range attachments {
+        if a.KeepaliveUntil == nil || now.After(*a.KeepaliveUntil) || !k.health.announced(a) {
+            continue
+        }
+        if err := announce(a); err != nil {
+            log.Printf("keepalives: pod %s/%s: %v", a.PodNamespace, a.PodName, err)
+        }
+    }
+    return nil
+}
+
+// announce sends a gratuitous ARP for each IPv4 address of the attachment
+// from inside the pod
+func announce(a *state.Attachment) error {
+    netns, err := ns.GetNS(a.Netns)
+    if err != nil {
+        return fmt.Errorf("failed to open netns %q: %v", a.Netns, err)
+    }
+    defer netns.Close()
+    
+    return netns.Do(func(ns.NetNS) error {
+        for _, addr := range a.IPs {
+            ip, _, err := net.ParseCIDR(addr)
+            if err != nil {
+                continue
+            }
+            if err := arp.SendGratuitous(a.PodInterface(), ip); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
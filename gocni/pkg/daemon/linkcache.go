@@ -0,0 +1,158 @@
+package daemon
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/vishvananda/netlink"
+
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// DefaultLinkCacheTTL bounds how long a link is served from the cache
+const DefaultLinkCacheTTL = 5 * time.Second
+
+// linkCache answers the plugin's lookups of master links during bursts of
+// ADDs, so hundreds of them do not each ask the kernel. Entries are
+// dropped by the netlink events of their link; while the subscription is
+// down, nothing is cached.
+type linkCache struct {
+    ttl time.Duration
+
+    mu      sync.Mutex
+    entries map[string]*vlanv1.Link
+    live    bool
+
+    // Bumped by every invalidation, so a lookup racing an event does not
+    // cache what the event changed
+    generation uint64
+}
+
+func newLinkCache(conf *LinkCacheConfig) *linkCache {
+    return &linkCache{ttl: conf.TTL.Or(DefaultLinkCacheTTL), entries: map[string]*vlanv1.Link{}}
+}
+
+// run follows link events until ctx is done, resubscribing after failures
+func (c *linkCache) run(ctx context.Context) {
+    for {
+        updates := make(chan netlink.LinkUpdate, 64)
+        done := make(chan struct{})
+        err := netlink.LinkSubscribeWithOptions(updates, done, netlink.LinkSubscribeOptions{
+            ErrorCallback: func(err error) {
+                log.Printf("link cache: subscription failed: %v", err)
+            },
+        })
+        if err != nil {
+            log.Printf("link cache: failed to subscribe to link events: %v", err)
+        } else {
+            c.setLive(true)
+            c.follow(ctx, updates)
+        }
+        close(done)
+        c.setLive(false)
+        
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(5 * time.Second):
+        }
+    }
+}
+
+func (c *linkCache) follow(ctx context.Context, updates <-chan netlink.LinkUpdate) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case u, ok := <-updates:
+            if !ok {
+                return
+            }
+            c.invalidate(u.Attrs().Name, int(u.Index))
+        }
+    }
+}
+
+func (c *linkCache) setLive(live bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    c.live = live
+    c.entries = map[string]*vlanv1.Link{}
+    c.generation++
+}
+
+// invalidate drops the entries of a link, by name and by index for renames
+func (c *linkCache) invalidate(name string, index int) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    for key, l := range c.entries {
+        if key == name || l.Index == index {
+            delete(c.entries, key)
+        }
+    }
+    c.generation++
+}
+
+// get returns the link, from the cache while its entry is fresh
+func (c *linkCache) get(name string) (*vlanv1.Link, error) {
+    c.mu.Lock()
+    l, ok := c.entries[name]
+    live, generation := c.live, c.generation
+    c.mu.Unlock()
+    if ok && time.Since(l.Cached) < c.ttl {
+        return l, nil
+    }
+    
+    link, err := netlink.LinkByName(name)
+    if err != nil {
+        return nil, err
+    }
+    attrs := link.Attrs()
+    l = &vlanv1.Link{
+        TypeMeta:     vlanv1.Meta(vlanv1.KindLink),
+        Name:         attrs.Name,
+        Index:        attrs.Index,
+        Type:         link.Type(),
+        MTU:          attrs.MTU,
+        HardwareAddr: attrs.HardwareAddr.String(),
+        Up:           attrs.Flags&net.FlagUp != 0,
+        OperState:    attrs.OperState.String(),
+        MasterIndex:  attrs.MasterIndex,
+        Cached:       time.Now().UTC(),
+    }
+    
+    c.mu.Lock()
+    if live && c.generation == generation {
+        c.entries[name] = l
+    }
+    c.mu.Unlock()
+    return l, nil
+}
+
+// serveLinks is the /v1/links endpoint of the daemon API:
+//
+//	GET /v1/links?name=<link>
+func (c *linkCache) serveLinks(w http.ResponseWriter, req *http.Request) {
+    name := req.URL.Query().Get("name")
+    if name == "" {
+        http.Error(w, "name is required", http.StatusBadRequest)
+        return
+    }
+    l, err := c.get(name)
+    var notFound netlink.LinkNotFoundError
+    switch {
+    case errors.As(err, &notFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    default:
+        writeJSON(w, l)
+    }
+}
@@ -0,0 +1,263 @@
+package daemon
+
+import (
+    "context"
+    "crypto/sha256"
+    "fmt"
+    "log"
+    "net"
+    "sort"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/utils/sysctl"
+    "github.com/vishvananda/netlink"
+    corev1 "k8s.io/api/core/v1"
+    discoveryv1 "k8s.io/api/discovery/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/arp"
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/hostlink"
+    "example.com/vlan-cni/pkg/state"
+)
+
+const defaultLoadBalancerInterval = 10 * time.Second
+
+// loadBalancer announces the LoadBalancer addresses MetalLB or kube-vip
+// assign from a pool on a VLAN, answering ARP for them on a macvlan of the
+// host's device of the VLAN, which it shares with other features. The addresses
+// stay off the link, kube-proxy takes their traffic wherever it arrives.
+// Each address is announced by one node among those with ready endpoints
+// of its service, picked by hashing as MetalLB's L2 mode does, so the
+// nodes agree without talking to each other.
+type loadBalancer struct {
+    conf     LoadBalancerConfig
+    nodeName string
+    client   kubernetes.Interface
+    store    *state.Store
+    pool     []*net.IPNet
+
+    responder *arp.Responder
+    stopped   chan struct{}
+    announced map[string]bool
+}
+
+func newLoadBalancer(conf LoadBalancerConfig, nodeName string, client kubernetes.Interface, store *state.Store) *loadBalancer {
+    lb := &loadBalancer{conf: conf, nodeName: nodeName, client: client, store: store, announced: map[string]bool{}}
+    for _, cidr := range conf.Addresses {
+        _, network, _ := net.ParseCIDR(cidr)
+        lb.pool = append(lb.pool, network)
+    }
+    return lb
+}
+
+// run announces until ctx is done, then removes the link
+func (lb *loadBalancer) run(ctx context.Context) {
+    ticker := time.NewTicker(lb.conf.SyncInterval.Or(defaultLoadBalancerInterval))
+    defer ticker.Stop()
+    
+    for {
+        if err := lb.sync(ctx); err != nil {
+            log.Printf("load balancer %s: sync failed: %v", lb.conf.Name, err)
+        }
+        select {
+        case <-ctx.Done():
+            if err := lb.remove(); err != nil {
+                log.Printf("load balancer %s: cleanup failed: %v", lb.conf.Name, err)
+            }
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (lb *loadBalancer) sync(ctx context.Context) error {
+    // Start over when the responder stopped
+    if lb.responder != nil {
+        select {
+        case <-lb.stopped:
+            lb.responder = nil
+        default:
+        }
+    }
+    if lb.responder == nil {
+        if err := lb.ensureLink(); err != nil {
+            return err
+        }
+        responder, err := arp.NewResponder(lb.linkName())
+        if err != nil {
+            return err
+        }
+        stopped := make(chan struct{})
+        lb.responder, lb.stopped = responder, stopped
+        go func() {
+            defer close(stopped)
+            if err := responder.Run(ctx); err != nil {
+                log.Printf("load balancer %s: ARP responder failed: %v", lb.conf.Name, err)
+            }
+        }()
+    }
+    
+    ips, err := lb.winning(ctx)
+    if err != nil {
+        return err
+    }
+    lb.responder.SetAddresses(ips)
+    
+    now := map[string]bool{}
+    for _, ip := range ips {
+        now[ip.String()] = true
+        if !lb.announced[ip.String()] {
+            log.Printf("load balancer %s: announcing %s on %s", lb.conf.Name, ip, lb.linkName())
+        }
+    }
+    for ip := range lb.announced {
+        if !now[ip] {
+            log.Printf("load balancer %s: no longer announcing %s", lb.conf.Name, ip)
+        }
+    }
+    lb.announced = now
+    return nil
+}
+
+// winning returns the pool's service addresses this node announces
+func (lb *loadBalancer) winning(ctx context.Context) ([]net.IP, error) {
+    services, err := lb.client.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to list services: %v", err)
+    }
+    
+    var ips []net.IP
+    for i := range services.Items {
+        svc := &services.Items[i]
+        if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+            continue
+        }
+        var candidates []net.IP
+        for _, ingress := range svc.Status.LoadBalancer.Ingress {
+            if ip := net.ParseIP(ingress.IP); ip != nil && ip.To4() != nil && lb.inPool(ip) {
+                candidates = append(candidates, ip)
+            }
+        }
+        if len(candidates) == 0 {
+            continue
+        }
+        
+        nodes, err := lb.readyNodes(ctx, svc)
+        if err != nil {
+            log.Printf("load balancer %s: service %s/%s: %v", lb.conf.Name, svc.Namespace, svc.Name, err)
+            continue
+        }
+        for _, ip := range candidates {
+            if electNode(nodes, ip) == lb.nodeName {
+                ips = append(ips, ip)
+            }
+        }
+    }
+    return ips, nil
+}
+
+// readyNodes returns the nodes with ready endpoints of the service
+func (lb *loadBalancer) readyNodes(ctx context.Context, svc *corev1.Service) ([]string, error) {
+    slices, err := lb.client.DiscoveryV1().EndpointSlices(svc.Namespace).List(ctx, metav1.ListOptions{
+        LabelSelector: discoveryv1.LabelServiceName + "=" + svc.Name,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to list endpoint slices: %v", err)
+    }
+    seen := map[string]bool{}
+    var nodes []string
+    for _, slice := range slices.Items {
+        for _, ep := range slice.Endpoints {
+            if ep.NodeName == nil || seen[*ep.NodeName] {
+                continue
+            }
+            if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+                continue
+            }
+            seen[*ep.NodeName] = true
+            nodes = append(nodes, *ep.NodeName)
+        }
+    }
+    return nodes, nil
+}
+
+// electNode picks the announcing node of ip, the same on every node
+func electNode(nodes []string, ip net.IP) string {
+    if len(nodes) == 0 {
+        return ""
+    }
+    hash := func(node string) string {
+        sum := sha256.Sum256([]byte(node + "#" + ip.String()))
+        return string(sum[:])
+    }
+    sorted := append([]string{}, nodes...)
+    sort.Slice(sorted, func(i, j int) bool {
+        return hash(sorted[i]) < hash(sorted[j])
+    })
+    return sorted[0]
+}
+
+func (lb *loadBalancer) inPool(ip net.IP) bool {
+    for _, network := range lb.pool {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// ensureLink creates the responder's macvlan on the VLAN. Clients on the
+// VLAN are answered through the node's routes, so the link filters loosely.
+func (lb *loadBalancer) ensureLink() error {
+    master, err := netlink.LinkByName(lb.conf.Master)
+    if err != nil {
+        return fmt.Errorf("failed to look up master: %v", err)
+    }
+    name := lb.linkName()
+    if _, err := netlink.LinkByName(name); err != nil {
+        vlan, err := hostlink.SharedVlan(master, lb.conf.VlanID)
+        if err != nil {
+            return err
+        }
+        link := hostlink.New(vlan, 0, config.UntaggedModeMacvlan, 0, name, false)
+        if err := netlink.LinkAdd(link); err != nil {
+            return fmt.Errorf("failed to add %q: %v", name, err)
+        }
+    }
+    link, err := netlink.LinkByName(name)
+    if err != nil {
+        return fmt.Errorf("failed to look up %q: %v", name, err)
+    }
+    if _, err := sysctl.Sysctl(fmt.Sprintf("net/ipv4/conf/%s/rp_filter", name), "2"); err != nil {
+        return fmt.Errorf("failed to loosen rp_filter of %q: %v", name, err)
+    }
+    if err := netlink.LinkSetUp(link); err != nil {
+        return fmt.Errorf("failed to set %q up: %v", name, err)
+    }
+    return nil
+}
+
+// remove deletes the macvlan, which stops the responder, and the VLAN's
+// device once nothing else uses it
+func (lb *loadBalancer) remove() error {
+    if link, err := netlink.LinkByName(lb.linkName()); err == nil {
+        if err := netlink.LinkDel(link); err != nil {
+            return fmt.Errorf("failed to remove %q: %v", lb.linkName(), err)
+        }
+    }
+    master, err := netlink.LinkByName(lb.conf.Master)
+    if err != nil {
+        return nil
+    }
+    return hostlink.ReleaseSharedVlan(master, lb.conf.VlanID, lb.store)
+}
+
+func (lb *loadBalancer) linkName() string {
+    name := "lb-" + lb.conf.Name
+    if len(name) > 15 {
+        name = name[:15]
+    }
+    return name
+}
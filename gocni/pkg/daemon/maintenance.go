@@ -0,0 +1,96 @@
+package daemon
+
+import (
+    "context"
+    "log"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// NodeAnnotationMaintenance puts a node's networking in maintenance, its
+// value being the reason. "false" leaves it out of maintenance.
+const NodeAnnotationMaintenance = "vlan-cni.io/maintenance"
+
+// maintenanceSync records whether the node's networking is in maintenance,
+// where the plugin fails ADDs with ErrTryAgainLater so operators can drain
+// the node's attachments for switch-side work without cordoning it
+type maintenanceSync struct {
+    conf     *MaintenanceConfig
+    nodeName string
+    client   kubernetes.Interface
+    store    *state.Store
+}
+
+func newMaintenanceSync(conf *MaintenanceConfig, nodeName string, client kubernetes.Interface, store *state.Store) *maintenanceSync {
+    return &maintenanceSync{conf: conf, nodeName: nodeName, client: client, store: store}
+}
+
+// run resyncs the maintenance until ctx is done. It outlives the daemon, so
+// ADDs keep failing while the daemon restarts.
+func (m *maintenanceSync) run(ctx context.Context) {
+    ticker := time.NewTicker(m.conf.Interval.Or(10 * time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := m.sync(ctx); err != nil {
+            log.Printf("maintenance: sync failed: %v", err)
+        }
+        if m.conf.Enabled {
+            // Nothing changes until the daemon restarts without it
+            <-ctx.Done()
+            return
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// sync records the current maintenance. A failed read of the node keeps
+// the last recorded one in force.
+func (m *maintenanceSync) sync(ctx context.Context) error {
+    reason, err := m.reason(ctx)
+    if err != nil {
+        return err
+    }
+    current, err := m.store.GetMaintenance()
+    if err != nil {
+        return err
+    }
+    if reason == "" {
+        if current != nil {
+            log.Printf("maintenance: ended, admitting ADDs")
+        }
+        return m.store.SaveMaintenance(nil)
+    }
+    if current != nil && current.Reason == reason {
+        return nil
+    }
+    log.Printf("maintenance: failing ADDs: %s", reason)
+    return m.store.SaveMaintenance(&state.Maintenance{Reason: reason, Since: time.Now()})
+}
+
+// reason is why the node is in maintenance, empty when it is not
+func (m *maintenanceSync) reason(ctx context.Context) (string, error) {
+    if m.conf.Enabled {
+        return "enabled on the daemon", nil
+    }
+    node, err := m.client.CoreV1().Nodes().Get(ctx, m.nodeName, metav1.GetOptions{})
+    if err != nil {
+        return "", err
+    }
+    value, ok := node.Annotations[NodeAnnotationMaintenance]
+    switch {
+    case !ok || value == "false":
+        return "", nil
+    case value == "" || value == "true":
+        return NodeAnnotationMaintenance + " node annotation", nil
+    }
+    return value, nil
+}
@@ -0,0 +1,304 @@
+package daemon
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/labels"
+    k8stypes "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/kubernetes"
+)
+
+// customMetricsPath is the API group version the adapter serves, which the
+// APIService in deployments/custom-metrics.yaml routes to it
+const customMetricsPath = "/apis/custom.metrics.k8s.io/v1beta2"
+
+// PodLabelMetricsAdapter marks the daemon pods serving the adapter
+const PodLabelMetricsAdapter = "vlan-cni.io/metrics-adapter"
+
+// Metrics served for pods, summed over their VLAN interfaces. A
+// metricSelector of network=<name> restricts them to one network.
+const (
+    metricRxBytes = "vlan_rx_bytes_per_second"
+    metricTxBytes = "vlan_tx_bytes_per_second"
+)
+
+// metricsAdapter serves the custom metrics API from the rates the daemons
+// of the pods' nodes keep in memory, asking each on podMetrics' address,
+// so any one adapter can answer for the whole cluster
+type metricsAdapter struct {
+    conf     *MetricsAdapterConfig
+    client   kubernetes.Interface
+    peerPort string
+    maxAge   time.Duration
+    listener net.Listener
+    srv      *http.Server
+}
+
+func newMetricsAdapter(pm *PodMetricsConfig, client kubernetes.Interface) (*metricsAdapter, error) {
+    conf := pm.Adapter
+    _, peerPort, err := net.SplitHostPort(pm.Address)
+    if err != nil {
+        return nil, fmt.Errorf("invalid podMetrics address %q: %v", pm.Address, err)
+    }
+    cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load metrics adapter certificate: %v", err)
+    }
+    pem, err := ioutil.ReadFile(conf.ClientCAFile)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read metrics adapter client CA: %v", err)
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("no certificates in %s", conf.ClientCAFile)
+    }
+    tlsConf := &tls.Config{
+        Certificates: []tls.Certificate{cert},
+        MinVersion:   tls.VersionTLS12,
+        ClientCAs:    pool,
+        ClientAuth:   tls.RequireAndVerifyClientCert,
+    }
+    
+    l, err := net.Listen("tcp", conf.Address)
+    if err != nil {
+        return nil, fmt.Errorf("failed to listen on metrics adapter address %q: %v", conf.Address, err)
+    }
+    a := &metricsAdapter{
+        conf:     conf,
+        client:   client,
+        peerPort: peerPort,
+        maxAge:   3 * pm.Interval.Or(defaultPodMetricsInterval),
+        listener: tls.NewListener(l, tlsConf),
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc(customMetricsPath, a.serveResources)
+    mux.HandleFunc(customMetricsPath+"/", a.serveMetrics)
+    a.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+    return a, nil
+}
+
+// run serves until ctx is done
+func (a *metricsAdapter) run(ctx context.Context) {
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        _ = a.srv.Shutdown(shutdownCtx)
+    }()
+    
+    if err := a.advertise(ctx); err != nil {
+        log.Printf("metrics adapter: the Service will not select this daemon: %v", err)
+    }
+    log.Printf("metrics adapter: serving on %s", a.listener.Addr())
+    if err := a.srv.Serve(a.listener); err != nil && err != http.ErrServerClosed {
+        log.Printf("metrics adapter: server failed: %v", err)
+    }
+}
+
+// advertise labels the daemon's pod for the adapter Service, which would
+// otherwise send requests to daemons that do not serve them. The daemon
+// finds its pod through the POD_NAME and POD_NAMESPACE variables.
+func (a *metricsAdapter) advertise(ctx context.Context) error {
+    name, namespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+    if name == "" || namespace == "" {
+        return fmt.Errorf("POD_NAME and POD_NAMESPACE are not set")
+    }
+    patch, err := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "labels": map[string]string{PodLabelMetricsAdapter: "true"},
+        },
+    })
+    if err != nil {
+        return err
+    }
+    _, err = a.client.CoreV1().Pods(namespace).Patch(ctx, name, k8stypes.MergePatchType, patch, metav1.PatchOptions{})
+    return err
+}
+
+// serveResources is the discovery document of the API group version
+func (a *metricsAdapter) serveResources(w http.ResponseWriter, req *http.Request) {
+    list := &metav1.APIResourceList{
+        TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+        GroupVersion: "custom.metrics.k8s.io/v1beta2",
+    }
+    for _, metric := range []string{metricRxBytes, metricTxBytes} {
+        list.APIResources = append(list.APIResources, metav1.APIResource{
+            Name:       "pods/" + metric,
+            Namespaced: true,
+            Kind:       "MetricValueList",
+            Verbs:      metav1.Verbs{"get"},
+        })
+    }
+    writeJSON(w, list)
+}
+
+// metricValue is an item of a custom.metrics.k8s.io/v1beta2 MetricValueList
+type metricValue struct {
+    DescribedObject corev1.ObjectReference `json:"describedObject"`
+    Metric          metricIdentifier       `json:"metric"`
+    Timestamp       metav1.Time            `json:"timestamp"`
+    Value           string                 `json:"value"`
+}
+
+type metricIdentifier struct {
+    Name     string                `json:"name"`
+    Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+type metricValueList struct {
+    metav1.TypeMeta `json:",inline"`
+    Metadata        metav1.ListMeta `json:"metadata"`
+    Items           []metricValue   `json:"items"`
+}
+
+// serveMetrics answers GET namespaces/<ns>/pods/<name or *>/<metric>
+func (a *metricsAdapter) serveMetrics(w http.ResponseWriter, req *http.Request) {
+    parts := strings.Split(strings.TrimPrefix(req.URL.Path, customMetricsPath+"/"), "/")
+    if len(parts) != 5 || parts[0] != "namespaces" || parts[2] != "pods" {
+        http.Error(w, "only namespaced pod metrics are served", http.StatusNotFound)
+        return
+    }
+    namespace, podName, metric := parts[1], parts[3], parts[4]
+    if metric != metricRxBytes && metric != metricTxBytes {
+        http.Error(w, fmt.Sprintf("unknown metric %q", metric), http.StatusNotFound)
+        return
+    }
+    metricSelector, err := labels.Parse(req.URL.Query().Get("metricSelector"))
+    if err != nil {
+        http.Error(w, fmt.Sprintf("invalid metricSelector: %v", err), http.StatusBadRequest)
+        return
+    }
+    
+    opts := metav1.ListOptions{LabelSelector: req.URL.Query().Get("labelSelector")}
+    if podName != "*" {
+        opts.FieldSelector = "metadata.name=" + podName
+    }
+    pods, err := a.client.CoreV1().Pods(namespace).List(req.Context(), opts)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("failed to list pods: %v", err), http.StatusInternalServerError)
+        return
+    }
+    
+    list := &metricValueList{
+        TypeMeta: metav1.TypeMeta{Kind: "MetricValueList", APIVersion: "custom.metrics.k8s.io/v1beta2"},
+        Items:    []metricValue{},
+    }
+    rates := a.nodeRates(req.Context(), pods.Items)
+    for i := range pods.Items {
+        pod := &pods.Items[i]
+        t, ok := a.throughput(rates, pod)
+        if !ok {
+            continue
+        }
+        var sum uint64
+        for _, iface := range t.Interfaces {
+            if !metricSelector.Matches(labels.Set{"network": iface.Network, "interface": iface.IfName}) {
+                continue
+            }
+            if metric == metricRxBytes {
+                sum += iface.RxBytesPerSecond
+            } else {
+                sum += iface.TxBytesPerSecond
+            }
+        }
+        list.Items = append(list.Items, metricValue{
+            DescribedObject: corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: pod.Namespace, Name: pod.Name},
+            Metric:          metricIdentifier{Name: metric},
+            Timestamp:       metav1.NewTime(t.Updated),
+            Value:           fmt.Sprint(sum),
+        })
+    }
+    if podName != "*" && len(list.Items) == 0 {
+        http.Error(w, fmt.Sprintf("no %s for pod %s/%s", metric, namespace, podName), http.StatusNotFound)
+        return
+    }
+    writeJSON(w, list)
+}
+
+// nodeRates fetches the rates of the pods' nodes, by node address. A node
+// that does not answer leaves its pods out.
+func (a *metricsAdapter) nodeRates(ctx context.Context, pods []corev1.Pod) map[string]map[string]*Throughput {
+    hosts := map[string]bool{}
+    for i := range pods {
+        if ip := pods[i].Status.HostIP; ip != "" {
+            hosts[ip] = true
+        }
+    }
+    token, err := readToken()
+    if err != nil {
+        log.Printf("metrics adapter: %v", err)
+        return nil
+    }
+    
+    var (
+        mu    sync.Mutex
+        wg    sync.WaitGroup
+        rates = map[string]map[string]*Throughput{}
+    )
+    for host := range hosts {
+        wg.Add(1)
+        go func(host string) {
+            defer wg.Done()
+            r, err := a.fetchRates(ctx, host, token)
+            if err != nil {
+                log.Printf("metrics adapter: node %s: %v", host, err)
+                return
+            }
+            mu.Lock()
+            rates[host] = r
+            mu.Unlock()
+        }(host)
+    }
+    wg.Wait()
+    return rates
+}
+
+func (a *metricsAdapter) fetchRates(ctx context.Context, host, token string) (map[string]*Throughput, error) {
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    defer cancel()
+    
+    u := url.URL{Scheme: "http", Host: net.JoinHostPort(host, a.peerPort), Path: throughputPath}
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Authorization", "Bearer "+token)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("%s", resp.Status)
+    }
+    rates := map[string]*Throughput{}
+    if err := json.NewDecoder(resp.Body).Decode(&rates); err != nil {
+        return nil, fmt.Errorf("failed to decode rates: %v", err)
+    }
+    return rates, nil
+}
+
+// throughput looks up a pod's rates, ignoring values its node stopped
+// refreshing
+func (a *metricsAdapter) throughput(rates map[string]map[string]*Throughput, pod *corev1.Pod) (*Throughput, bool) {
+    t, ok := rates[pod.Status.HostIP][pod.Namespace+"/"+pod.Name]
+    if !ok || t == nil {
+        return nil, false
+    }
+    return t, time.Since(t.Updated) <= a.maxAge
+}
@@ -0,0 +1,185 @@
+package daemon
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    vlanipam "example.com/vlan-cni/pkg/ipam"
+    _ "example.com/vlan-cni/pkg/ipam/kubeapi"
+    "example.com/vlan-cni/pkg/state"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+const (
+    defaultMigrationTTL = 10 * time.Minute
+    maxMigrationTTL     = 24 * time.Hour
+)
+
+// migrator hands the addresses of a pod being drained to its replacement
+// before the pod goes away. The pod loses them at once, which is the
+// point: the replacement can take them on another node without the two
+// answering for the same address.
+type migrator struct {
+    confFile string
+    nodeName string
+    store    *state.Store
+}
+
+func newMigrator(confFile, nodeName string, store *state.Store) *migrator {
+    return &migrator{confFile: confFile, nodeName: nodeName, store: store}
+}
+
+// serveMigrate is the /v1/migrate endpoint of the daemon API:
+//
+//	POST /v1/migrate?namespace=<ns>&pod=<name>&to=<[ns/]name>[&ifName=net1][&ttl=10m]
+//
+// The addresses stay held for the replacement until ttl passes. Only IPAM
+// backends keeping allocations off the node, such as kube, can migrate.
+func (m *migrator) serveMigrate(w http.ResponseWriter, req *http.Request) {
+    if req.Method != http.MethodPost {
+        http.Error(w, "migrations are started with POST", http.StatusMethodNotAllowed)
+        return
+    }
+    q := req.URL.Query()
+    namespace, pod, to := q.Get("namespace"), q.Get("pod"), q.Get("to")
+    if namespace == "" || pod == "" || to == "" {
+        http.Error(w, "namespace, pod and to are required", http.StatusBadRequest)
+        return
+    }
+    if !strings.Contains(to, "/") {
+        to = namespace + "/" + to
+    }
+    ttl := defaultMigrationTTL
+    if v := q.Get("ttl"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil || d <= 0 || d > maxMigrationTTL {
+            http.Error(w, fmt.Sprintf("invalid ttl %q", v), http.StatusBadRequest)
+            return
+        }
+        ttl = d
+    }
+    
+    a, err := findAttachment(m.store, namespace, pod, q.Get("ifName"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    driver, err := m.driver(a)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotImplemented)
+        return
+    }
+    
+    ips, err := driver.Migrate(req.Context(), &vlanipam.Request{
+        ContainerID:  a.ContainerID,
+        IfName:       a.IfName,
+        Network:      a.Network,
+        PodName:      a.PodName,
+        PodNamespace: a.PodNamespace,
+        PodUID:       a.PodUID,
+        NodeName:     m.nodeName,
+        VlanID:       a.VlanID,
+    }, to, ttl)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    }
+    if err := m.unassign(a, ips); err != nil {
+        // The allocation moved already, the old pod just keeps answering
+        log.Printf("migrate: pod %s/%s: %v", namespace, pod, err)
+    }
+    log.Printf("migrate: %v of pod %s/%s held for %s until %s", ips, namespace, pod, to, time.Now().Add(ttl).UTC().Format(time.RFC3339))
+    
+    mig := &vlanv1.Migration{TypeMeta: vlanv1.Meta(vlanv1.KindMigration), Network: a.Network, IfName: a.IfName, To: to, Until: time.Now().Add(ttl).UTC()}
+    for _, ip := range ips {
+        mig.IPs = append(mig.IPs, ip.String())
+    }
+    writeJSON(w, mig)
+}
+
+// driver builds the IPAM driver of the attachment's network from its
+// installed definition
+func (m *migrator) driver(a *state.Attachment) (vlanipam.Migrator, error) {
+    defs := fileDefs(m.confFile)[a.Network]
+    if len(defs) == 0 {
+        return nil, fmt.Errorf("network %q is not defined in %s", a.Network, m.confFile)
+    }
+    ipamConf := defs[0].plugin["ipam"]
+    if list, ok := defs[0].plugin["attachments"].([]interface{}); ok {
+        for _, item := range list {
+            if sub, ok := item.(map[string]interface{}); ok && sub["ifName"] == a.IfName {
+                ipamConf = sub["ipam"]
+            }
+        }
+    }
+    section, ok := ipamConf.(map[string]interface{})
+    if !ok {
+        return nil, fmt.Errorf("network %q has no ipam", a.Network)
+    }
+    typeName, _ := section["type"].(string)
+    raw, err := json.Marshal(section)
+    if err != nil {
+        return nil, err
+    }
+    
+    driver, err := vlanipam.New(typeName, raw, m.store)
+    if err != nil {
+        return nil, err
+    }
+    migrating, ok := driver.(vlanipam.Migrator)
+    if !ok {
+        return nil, fmt.Errorf("ipam type %q cannot migrate addresses", typeName)
+    }
+    return migrating, nil
+}
+
+// unassign removes the migrated addresses from the pod and its record
+func (m *migrator) unassign(a *state.Attachment, ips []net.IP) error {
+    netns, err := ns.GetNS(a.Netns)
+    if err != nil {
+        return fmt.Errorf("failed to open netns %q: %v", a.Netns, err)
+    }
+    defer netns.Close()
+    
+    var kept []string
+    err = netns.Do(func(ns.NetNS) error {
+        link, err := netlink.LinkByName(a.PodInterface())
+        if err != nil {
+            return fmt.Errorf("failed to look up %q: %v", a.PodInterface(), err)
+        }
+        for _, cidr := range a.IPs {
+            ip, ipnet, err := net.ParseCIDR(cidr)
+            if err != nil || !containsIP(ips, ip) {
+                kept = append(kept, cidr)
+                continue
+            }
+            ipnet.IP = ip
+            if err := netlink.AddrDel(link, &netlink.Addr{IPNet: ipnet}); err != nil {
+                return fmt.Errorf("failed to remove %s from %q: %v", cidr, a.PodInterface(), err)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    a.IPs = kept
+    return m.store.SaveAttachment(a)
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+    for _, candidate := range ips {
+        if candidate.Equal(ip) {
+            return true
+        }
+    }
+    return false
+}
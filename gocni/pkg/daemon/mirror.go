@@ -0,0 +1,215 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "os/exec"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/vishvananda/netlink"
+)
+
+// Remote mirror encapsulations
+const (
+    MirrorERSPAN = "erspan"
+    MirrorGRETap = "gretap"
+)
+
+// mirror copies the frames of one VLAN on a master to an analyzer. Flower
+// filters on the master's clsact hooks match the VLAN in both directions,
+// so the copies carry the tag whether or not the NIC offloads it.
+type mirror struct {
+    conf MirrorConfig
+}
+
+func newMirror(conf MirrorConfig) *mirror {
+    return &mirror{conf: conf}
+}
+
+// run reconciles until ctx is done, then removes the filters
+func (m *mirror) run(ctx context.Context) {
+    ticker := time.NewTicker(m.conf.SyncInterval.Or(30 * time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := m.sync(); err != nil {
+            log.Printf("mirror %s: sync failed: %v", m.conf.Name, err)
+        }
+        select {
+        case <-ctx.Done():
+            if err := m.remove(); err != nil {
+                log.Printf("mirror %s: cleanup failed: %v", m.conf.Name, err)
+            }
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (m *mirror) sync() error {
+    master, err := netlink.LinkByName(m.conf.Master)
+    if err != nil {
+        return fmt.Errorf("failed to look up master: %v", err)
+    }
+    target, err := m.target()
+    if err != nil {
+        return err
+    }
+    
+    clsact := &netlink.GenericQdisc{
+        QdiscAttrs: netlink.QdiscAttrs{
+            LinkIndex: master.Attrs().Index,
+            Handle:    netlink.MakeHandle(0xffff, 0),
+            Parent:    netlink.HANDLE_CLSACT,
+        },
+        QdiscType: "clsact",
+    }
+    if err := netlink.QdiscReplace(clsact); err != nil {
+        return fmt.Errorf("failed to add clsact qdisc: %v", err)
+    }
+    
+    // netlink's flower has no VLAN keys, so the filters go through tc
+    for _, hook := range []string{"ingress", "egress"} {
+        err := tc("filter", "replace", "dev", m.conf.Master, hook, "pref", m.pref(), "handle", "1",
+            "protocol", "802.1Q", "flower", "vlan_id", strconv.Itoa(m.conf.VlanID),
+            "action", "mirred", "egress", "mirror", "dev", target)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// target returns the device receiving the copies, creating the tunnel of
+// remote mirrors
+func (m *mirror) target() (string, error) {
+    if m.conf.Remote == nil {
+        if _, err := netlink.LinkByName(m.conf.Interface); err != nil {
+            return "", fmt.Errorf("failed to look up mirror interface %q: %v", m.conf.Interface, err)
+        }
+        return m.conf.Interface, nil
+    }
+    
+    name := m.tunnelName()
+    link, err := netlink.LinkByName(name)
+    if err != nil {
+        if _, ok := err.(netlink.LinkNotFoundError); !ok {
+            return "", fmt.Errorf("failed to look up mirror tunnel %q: %v", name, err)
+        }
+        if err := m.addTunnel(name); err != nil {
+            return "", err
+        }
+        if link, err = netlink.LinkByName(name); err != nil {
+            return "", fmt.Errorf("failed to look up mirror tunnel %q: %v", name, err)
+        }
+    }
+    if err := netlink.LinkSetUp(link); err != nil {
+        return "", fmt.Errorf("failed to set mirror tunnel %q up: %v", name, err)
+    }
+    return name, nil
+}
+
+func (m *mirror) addTunnel(name string) error {
+    r := m.conf.Remote
+    remote := net.ParseIP(r.Address)
+    local := net.ParseIP(r.Local)
+    
+    if r.Type == MirrorGRETap {
+        tap := &netlink.Gretap{
+            LinkAttrs: netlink.LinkAttrs{Name: name},
+            Remote:    remote,
+            Local:     local,
+            Ttl:       uint8(r.TTL),
+            PMtuDisc:  1,
+        }
+        if local == nil {
+            // The family of Local picks gretap or ip6gretap
+            tap.Local = net.IPv4zero
+            if remote.To4() == nil {
+                tap.Local = net.IPv6zero
+            }
+        }
+        if r.Key != 0 {
+            tap.IKey, tap.OKey = r.Key, r.Key
+            tap.IFlags, tap.OFlags = greKeyFlag, greKeyFlag
+        }
+        if err := netlink.LinkAdd(tap); err != nil {
+            return fmt.Errorf("failed to add mirror tunnel %q: %v", name, err)
+        }
+        return nil
+    }
+    
+    // Nor does netlink know ERSPAN links. The session ID goes in the GRE
+    // key, as in the kernel's erspan driver.
+    kind := "erspan"
+    if remote.To4() == nil {
+        kind = "ip6erspan"
+    }
+    argv := []string{"link", "add", name, "type", kind, "remote", r.Address}
+    if r.Local != "" {
+        argv = append(argv, "local", r.Local)
+    }
+    if r.TTL != 0 {
+        argv = append(argv, "ttl", strconv.Itoa(r.TTL))
+    }
+    argv = append(argv, "seq", "key", strconv.FormatUint(uint64(r.Key), 10),
+        "erspan_ver", "1", "erspan", strconv.Itoa(r.Index))
+    out, err := exec.Command("ip", argv...).CombinedOutput()
+    if err != nil {
+        return fmt.Errorf("failed to add mirror tunnel %q: %v: %s (is the erspan module available?)", name, err, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
+
+// remove takes the filters and the tunnel down again
+func (m *mirror) remove() error {
+    var errs []string
+    for _, hook := range []string{"ingress", "egress"} {
+        err := tc("filter", "del", "dev", m.conf.Master, hook, "pref", m.pref(), "protocol", "802.1Q", "flower")
+        if err != nil {
+            errs = append(errs, err.Error())
+        }
+    }
+    if m.conf.Remote != nil {
+        if link, err := netlink.LinkByName(m.tunnelName()); err == nil {
+            if err := netlink.LinkDel(link); err != nil {
+                errs = append(errs, fmt.Sprintf("failed to delete mirror tunnel: %v", err))
+            }
+        }
+    }
+    if len(errs) > 0 {
+        return fmt.Errorf("%s", strings.Join(errs, "; "))
+    }
+    return nil
+}
+
+// pref keeps the filters of each VLAN apart on the master
+func (m *mirror) pref() string {
+    return strconv.Itoa(mirrorPrefBase + m.conf.VlanID)
+}
+
+func (m *mirror) tunnelName() string {
+    name := "mir-" + m.conf.Name
+    if len(name) > 15 {
+        name = name[:15]
+    }
+    return name
+}
+
+// mirrorPrefBase puts mirror filters after filters of other tools
+const mirrorPrefBase = 10000
+
+// greKeyFlag is GRE_KEY of the tunnel's i/o flags
+const greKeyFlag = 0x2000
+
+func tc(args ...string) error {
+    out, err := exec.Command("tc", args...).CombinedOutput()
+    if err != nil {
+        return fmt.Errorf("tc %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
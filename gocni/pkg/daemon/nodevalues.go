@@ -0,0 +1,95 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "reflect"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Annotations too large and too volatile to be worth templating with
+var skippedNodeAnnotations = map[string]bool{
+    "kubectl.kubernetes.io/last-applied-configuration": true,
+}
+
+// nodeValuesSync copies the node's labels and annotations into the state
+// directory, where the plugin renders templated configuration with them
+type nodeValuesSync struct {
+    conf     *NodeValuesConfig
+    nodeName string
+    client   kubernetes.Interface
+    store    *state.Store
+}
+
+func newNodeValuesSync(conf *NodeValuesConfig, nodeName string, client kubernetes.Interface, store *state.Store) *nodeValuesSync {
+    return &nodeValuesSync{conf: conf, nodeName: nodeName, client: client, store: store}
+}
+
+// run resyncs the node values until ctx is done
+func (n *nodeValuesSync) run(ctx context.Context) {
+    ticker := time.NewTicker(n.conf.Interval.Or(30 * time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := n.sync(ctx); err != nil {
+            log.Printf("node values: sync failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// sync records the node's current labels and annotations. A failed read
+// keeps the last synced values in force.
+func (n *nodeValuesSync) sync(ctx context.Context) error {
+    node, err := n.client.CoreV1().Nodes().Get(ctx, n.nodeName, metav1.GetOptions{})
+    if err != nil {
+        return err
+    }
+    
+    annotations := map[string]string{}
+    for k, v := range node.Annotations {
+        if !skippedNodeAnnotations[k] {
+            annotations[k] = v
+        }
+    }
+    
+    current, err := n.store.GetNodeValues()
+    if err != nil {
+        return err
+    }
+    if current != nil && reflect.DeepEqual(current.Labels, node.Labels) && reflect.DeepEqual(current.Annotations, annotations) {
+        return nil
+    }
+    return n.store.SaveNodeValues(&state.NodeValues{
+        Name:        node.Name,
+        Labels:      node.Labels,
+        Annotations: annotations,
+        Updated:     time.Now(),
+    })
+}
+
+// storeNodeValues serves the synced node values to config.ParseConfigForNode,
+// for checking templated configuration the way the plugin renders it
+func storeNodeValues(store *state.Store) config.NodeValuesFunc {
+    return func(stateDir, kubeconfig string) (*config.NodeValues, error) {
+        v, err := store.GetNodeValues()
+        if err != nil {
+            return nil, err
+        }
+        if v == nil {
+            return nil, fmt.Errorf("node values are not synced, enable nodeValues in the daemon configuration")
+        }
+        return &config.NodeValues{Name: v.Name, Labels: v.Labels, Annotations: v.Annotations}, nil
+    }
+}
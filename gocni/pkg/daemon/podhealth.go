@@ -0,0 +1,180 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/fields"
+    k8stypes "k8s.io/apimachinery/pkg/types"
+    "k8s.io/apimachinery/pkg/watch"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// podHealth watches the readiness of this node's pods, so announcements
+// stop attracting traffic from the physical network to pods that are not
+// ready to serve it
+type podHealth struct {
+    nodeName string
+    client   kubernetes.Interface
+
+    mu     sync.Mutex
+    pods   map[k8stypes.NamespacedName]podState
+    synced bool
+
+    // Signalled when a pod's readiness flips
+    changed chan struct{}
+}
+
+// podState is what gating needs of a pod
+type podState struct {
+    uid   k8stypes.UID
+    ready bool
+}
+
+func newPodHealth(nodeName string, client kubernetes.Interface) *podHealth {
+    return &podHealth{
+        nodeName: nodeName,
+        client:   client,
+        pods:     map[k8stypes.NamespacedName]podState{},
+        changed:  make(chan struct{}, 1),
+    }
+}
+
+// run lists and watches the node's pods until ctx is done, listing anew
+// whenever the watch ends
+func (h *podHealth) run(ctx context.Context) {
+    for {
+        if err := h.watch(ctx); err != nil {
+            log.Printf("pod health: watch failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(5 * time.Second):
+        }
+    }
+}
+
+func (h *podHealth) watch(ctx context.Context) error {
+    selector := fields.OneTermEqualSelector("spec.nodeName", h.nodeName).String()
+    list, err := h.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: selector})
+    if err != nil {
+        return fmt.Errorf("failed to list pods: %v", err)
+    }
+    pods := map[k8stypes.NamespacedName]podState{}
+    for i := range list.Items {
+        pods[podKey(&list.Items[i])] = stateOf(&list.Items[i])
+    }
+    h.replace(pods)
+    
+    w, err := h.client.CoreV1().Pods("").Watch(ctx, metav1.ListOptions{
+        FieldSelector:   selector,
+        ResourceVersion: list.ResourceVersion,
+    })
+    if err != nil {
+        return fmt.Errorf("failed to watch pods: %v", err)
+    }
+    defer w.Stop()
+    
+    for ev := range w.ResultChan() {
+        switch ev.Type {
+        case watch.Added, watch.Modified:
+            if pod, ok := ev.Object.(*corev1.Pod); ok {
+                h.set(podKey(pod), stateOf(pod), true)
+            }
+        case watch.Deleted:
+            if pod, ok := ev.Object.(*corev1.Pod); ok {
+                h.set(podKey(pod), podState{}, false)
+            }
+        case watch.Error:
+            return fmt.Errorf("watch error: %v", ev.Object)
+        }
+    }
+    return nil
+}
+
+func (h *podHealth) replace(pods map[k8stypes.NamespacedName]podState) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    changed := !h.synced || len(pods) != len(h.pods)
+    for key, s := range pods {
+        if h.pods[key] != s {
+            changed = true
+        }
+    }
+    h.pods = pods
+    h.synced = true
+    if changed {
+        h.notify()
+    }
+}
+
+func (h *podHealth) set(key k8stypes.NamespacedName, s podState, present bool) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    prev, ok := h.pods[key]
+    if present {
+        h.pods[key] = s
+    } else {
+        delete(h.pods, key)
+    }
+    if ok != present || prev != s {
+        h.notify()
+    }
+}
+
+func (h *podHealth) notify() {
+    select {
+    case h.changed <- struct{}{}:
+    default:
+    }
+}
+
+// announced reports whether the attachment's addresses may be announced.
+// Until the first list they are, so losing the API server does not
+// withdraw the node's pods, as are attachments recorded without a pod
+// name. Once listed, an attachment whose pod is gone from the API, or was
+// recreated under the same name, is not announced.
+func (h *podHealth) announced(a *state.Attachment) bool {
+    if h == nil || a.PodName == "" {
+        return true
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    if !h.synced {
+        return true
+    }
+    s, ok := h.pods[k8stypes.NamespacedName{Namespace: a.PodNamespace, Name: a.PodName}]
+    if !ok || (a.PodUID != "" && string(s.uid) != a.PodUID) {
+        return false
+    }
+    return s.ready
+}
+
+func podKey(pod *corev1.Pod) k8stypes.NamespacedName {
+    return k8stypes.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+}
+
+// stateOf takes terminating pods for not ready, ahead of their endpoints
+func stateOf(pod *corev1.Pod) podState {
+    s := podState{uid: pod.UID}
+    if pod.DeletionTimestamp != nil {
+        return s
+    }
+    for _, c := range pod.Status.Conditions {
+        if c.Type == corev1.PodReady {
+            s.ready = c.Status == corev1.ConditionTrue
+        }
+    }
+    return s
+}
@@ -0,0 +1,273 @@
+package daemon
+
+import (
+    "context"
+    "crypto/sha256"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+    authv1 "k8s.io/api/authentication/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// throughputPath is where the daemon serves its pods' rates to the metrics
+// adapters
+const throughputPath = "/v1/throughput"
+
+const defaultPodMetricsInterval = 30 * time.Second
+
+// Throughput is the rate of a pod's VLAN interfaces, as measured by the
+// daemon on its node
+type Throughput struct {
+    Interfaces []InterfaceThroughput `json:"interfaces"`
+    Updated    time.Time             `json:"updated"`
+}
+
+// InterfaceThroughput is the rate of one attachment over the last interval
+type InterfaceThroughput struct {
+    IfName           string `json:"ifName"`
+    Network          string `json:"network"`
+    RxBytesPerSecond uint64 `json:"rxBytesPerSecond"`
+    TxBytesPerSecond uint64 `json:"txBytesPerSecond"`
+}
+
+// counterSample is an attachment's byte counters at a point in time
+type counterSample struct {
+    rx, tx uint64
+    at     time.Time
+}
+
+// podMetrics samples the byte counters of attachments and keeps their
+// rates in memory, serving them to the metrics adapters on podMetrics'
+// address. Nothing is written to the API server.
+type podMetrics struct {
+    conf  *PodMetricsConfig
+    store *state.Store
+
+    last map[string]counterSample
+
+    mu    sync.Mutex
+    rates map[string]*Throughput
+}
+
+func newPodMetrics(conf *PodMetricsConfig, store *state.Store) *podMetrics {
+    return &podMetrics{conf: conf, store: store, last: map[string]counterSample{}, rates: map[string]*Throughput{}}
+}
+
+// run samples until ctx is done
+func (p *podMetrics) run(ctx context.Context) {
+    ticker := time.NewTicker(p.conf.Interval.Or(defaultPodMetricsInterval))
+    defer ticker.Stop()
+    
+    for {
+        if err := p.sample(); err != nil {
+            log.Printf("pod metrics: sample failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (p *podMetrics) sample() error {
+    attachments, err := p.store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    
+    now := time.Now().UTC()
+    rates := map[string]*Throughput{}
+    seen := map[string]bool{}
+    for _, a := range attachments {
+        if a.PodName == "" {
+            continue
+        }
+        key := a.ContainerID + "/" + a.IfName
+        seen[key] = true
+        
+        cur, err := readCounters(a)
+        if err != nil {
+            log.Printf("pod metrics: pod %s/%s: %v", a.PodNamespace, a.PodName, err)
+            continue
+        }
+        prev, ok := p.last[key]
+        p.last[key] = cur
+        if !ok {
+            continue
+        }
+        pod := a.PodNamespace + "/" + a.PodName
+        if rates[pod] == nil {
+            rates[pod] = &Throughput{Updated: now}
+        }
+        rates[pod].Interfaces = append(rates[pod].Interfaces, InterfaceThroughput{
+            IfName:           a.IfName,
+            Network:          a.Network,
+            RxBytesPerSecond: rate(prev.rx, cur.rx, cur.at.Sub(prev.at)),
+            TxBytesPerSecond: rate(prev.tx, cur.tx, cur.at.Sub(prev.at)),
+        })
+    }
+    for key := range p.last {
+        if !seen[key] {
+            delete(p.last, key)
+        }
+    }
+    
+    p.mu.Lock()
+    p.rates = rates
+    p.mu.Unlock()
+    return nil
+}
+
+// serve answers the metrics adapters on the configured address until ctx
+// is done
+func (p *podMetrics) serve(ctx context.Context, auth *peerAuth) {
+    mux := http.NewServeMux()
+    mux.HandleFunc(throughputPath, auth.wrap(p.serveThroughput))
+    srv := &http.Server{Addr: p.conf.Address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        _ = srv.Shutdown(shutdownCtx)
+    }()
+    
+    log.Printf("pod metrics: serving on %s", p.conf.Address)
+    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+        log.Printf("pod metrics: server failed: %v", err)
+    }
+}
+
+// serveThroughput returns the rates of the node's pods by namespace/name
+func (p *podMetrics) serveThroughput(w http.ResponseWriter, req *http.Request) {
+    p.mu.Lock()
+    rates := p.rates
+    p.mu.Unlock()
+    writeJSON(w, rates)
+}
+
+// peerAuth admits callers presenting a token of the daemon's own service
+// account, which is what the adapters on other nodes run as
+type peerAuth struct {
+    client kubernetes.Interface
+    self   string
+
+    mu     sync.Mutex
+    admits map[[32]byte]time.Time
+}
+
+// peerAuthTTL is how long a reviewed token is admitted without asking the
+// API server again
+const peerAuthTTL = time.Minute
+
+// serviceAccountToken is the daemon's own token, mounted by Kubernetes
+const serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// newPeerAuth learns the daemon's identity by reviewing its own token
+func newPeerAuth(ctx context.Context, client kubernetes.Interface) (*peerAuth, error) {
+    token, err := readToken()
+    if err != nil {
+        return nil, err
+    }
+    a := &peerAuth{client: client, admits: map[[32]byte]time.Time{}}
+    if a.self, err = a.review(ctx, token); err != nil {
+        return nil, fmt.Errorf("failed to review own service account token: %v", err)
+    }
+    return a, nil
+}
+
+func readToken() (string, error) {
+    data, err := ioutil.ReadFile(serviceAccountToken)
+    if err != nil {
+        return "", fmt.Errorf("failed to read service account token: %v", err)
+    }
+    return strings.TrimSpace(string(data)), nil
+}
+
+// review returns the user a token authenticates as
+func (a *peerAuth) review(ctx context.Context, token string) (string, error) {
+    review, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authv1.TokenReview{
+        Spec: authv1.TokenReviewSpec{Token: token},
+    }, metav1.CreateOptions{})
+    if err != nil {
+        return "", err
+    }
+    if !review.Status.Authenticated {
+        return "", fmt.Errorf("token not authenticated: %s", review.Status.Error)
+    }
+    return review.Status.User.Username, nil
+}
+
+func (a *peerAuth) wrap(handler http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, req *http.Request) {
+        token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+        if token == "" || token == req.Header.Get("Authorization") {
+            http.Error(w, "bearer token required", http.StatusUnauthorized)
+            return
+        }
+        
+        key := sha256.Sum256([]byte(token))
+        a.mu.Lock()
+        admitted := time.Now().Before(a.admits[key])
+        a.mu.Unlock()
+        if !admitted {
+            user, err := a.review(req.Context(), token)
+            if err != nil || user != a.self {
+                http.Error(w, "forbidden", http.StatusForbidden)
+                return
+            }
+            a.mu.Lock()
+            for k, until := range a.admits {
+                if time.Now().After(until) {
+                    delete(a.admits, k)
+                }
+            }
+            a.admits[key] = time.Now().Add(peerAuthTTL)
+            a.mu.Unlock()
+        }
+        handler(w, req)
+    }
+}
+
+// readCounters reads the byte counters of the pod's interface
+func readCounters(a *state.Attachment) (counterSample, error) {
+    netns, err := ns.GetNS(a.Netns)
+    if err != nil {
+        return counterSample{}, fmt.Errorf("failed to open netns %q: %v", a.Netns, err)
+    }
+    defer netns.Close()
+    
+    var sample counterSample
+    err = netns.Do(func(ns.NetNS) error {
+        link, err := netlink.LinkByName(a.PodInterface())
+        if err != nil {
+            return fmt.Errorf("failed to look up %q: %v", a.PodInterface(), err)
+        }
+        stats := link.Attrs().Statistics
+        if stats == nil {
+            return fmt.Errorf("%q has no statistics", a.PodInterface())
+        }
+        sample = counterSample{rx: stats.RxBytes, tx: stats.TxBytes, at: time.Now()}
+        return nil
+    })
+    return sample, err
+}
+
+// rate is the per-second increase of a counter, zero when it was reset
+func rate(prev, now uint64, elapsed time.Duration) uint64 {
+    if now < prev || elapsed <= 0 {
+        return 0
+    }
+    return uint64(float64(now-prev) / elapsed.Seconds())
+}
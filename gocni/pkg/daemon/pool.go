@@ -0,0 +1,134 @@
+package daemon
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+    "syscall"
+    "time"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/hostlink"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// poolLinkPrefix marks links owned by a warm pool
+const poolLinkPrefix = "vwp"
+
+// warmPool keeps idle links ready for the plugin to claim. Links are created
+// down in the host namespace; ADD moves one into the pod and renames it.
+type warmPool struct {
+    conf  WarmPoolConfig
+    key   string
+    store *state.Store
+    // full is set once the kernel refused another link on the VLAN
+    full bool
+}
+
+func newWarmPool(conf WarmPoolConfig, store *state.Store) *warmPool {
+    return &warmPool{
+        conf:  conf,
+        key:   state.PoolKey(conf.Master, conf.VlanID, conf.UntaggedMode),
+        store: store,
+    }
+}
+
+// run tops the pool up until ctx is done, then removes the idle links
+func (p *warmPool) run(ctx context.Context) {
+    ticker := time.NewTicker(p.conf.RefillInterval.Or(5 * time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := p.refill(); err != nil {
+            log.Printf("warm pool %s: refill failed: %v", p.key, err)
+        }
+        select {
+        case <-ctx.Done():
+            p.drain()
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// refill drops idle links that disappeared and creates new ones up to size.
+// A master takes one VLAN device per VID, so a link that already exists
+// means the pool is as full as it gets.
+func (p *warmPool) refill() error {
+    if err := p.store.Lock(); err != nil {
+        return err
+    }
+    defer p.store.Unlock()
+    
+    names, err := p.store.PoolLinks(p.key)
+    if err != nil {
+        return err
+    }
+    
+    var idle []string
+    for _, name := range names {
+        if _, err := netlink.LinkByName(name); err == nil {
+            idle = append(idle, name)
+        }
+    }
+    
+    master, err := netlink.LinkByName(p.conf.Master)
+    if err != nil {
+        return fmt.Errorf("failed to look up master: %v", err)
+    }
+    
+    for len(idle) < p.conf.Size {
+        name, err := poolLinkName()
+        if err != nil {
+            return err
+        }
+        link := hostlink.New(master, p.conf.VlanID, p.conf.UntaggedMode, p.conf.MTU, name, false)
+        if err := netlink.LinkAdd(link); errors.Is(err, syscall.EEXIST) {
+            if !p.full {
+                log.Printf("warm pool %s: %s already has a link for VLAN %d, keeping %d of %d idle links",
+                    p.key, p.conf.Master, p.conf.VlanID, len(idle), p.conf.Size)
+                p.full = true
+            }
+            break
+        } else if err != nil {
+            // Keep what was created so far
+            _ = p.store.SetPoolLinks(p.key, idle)
+            return fmt.Errorf("failed to create %s: %v", name, err)
+        }
+        idle = append(idle, name)
+    }
+    
+    return p.store.SetPoolLinks(p.key, idle)
+}
+
+// drain deletes the idle links so they do not outlive the daemon
+func (p *warmPool) drain() {
+    if err := p.store.Lock(); err != nil {
+        return
+    }
+    defer p.store.Unlock()
+    
+    names, err := p.store.PoolLinks(p.key)
+    if err != nil {
+        return
+    }
+    for _, name := range names {
+        if link, err := netlink.LinkByName(name); err == nil {
+            _ = netlink.LinkDel(link)
+        }
+    }
+    _ = p.store.SetPoolLinks(p.key, nil)
+}
+
+// poolLinkName returns a random name that fits IFNAMSIZ
+func poolLinkName() (string, error) {
+    b := make([]byte, 5)
+    if _, err := rand.Read(b); err != nil {
+        return "", fmt.Errorf("failed to generate link name: %v", err)
+    }
+    return poolLinkPrefix + hex.EncodeToString(b), nil
+}
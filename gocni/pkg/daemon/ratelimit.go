@@ -0,0 +1,52 @@
+package daemon
+
+import (
+    "time"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// publishRateLimits sets up a token bucket for each rate limited network
+// and drops the buckets of networks no longer limited. Existing buckets
+// keep their tokens, so restarting the daemon does not refill them.
+func publishRateLimits(confs []RateLimitConfig, store *state.Store) error {
+    if err := store.Lock(); err != nil {
+        return err
+    }
+    defer store.Unlock()
+    
+    limited := map[string]bool{}
+    now := time.Now()
+    for _, c := range confs {
+        limited[c.Network] = true
+        b, err := store.GetTokenBucket(c.Network)
+        if err != nil {
+            return err
+        }
+        if b == nil {
+            b = &state.TokenBucket{Tokens: float64(c.Burst), Updated: now}
+        } else {
+            b.Refill(now)
+        }
+        b.Rate, b.Burst = c.Rate, c.Burst
+        if b.Tokens > float64(b.Burst) {
+            b.Tokens = float64(b.Burst)
+        }
+        if err := store.SaveTokenBucket(c.Network, b); err != nil {
+            return err
+        }
+    }
+    
+    networks, err := store.RateLimitedNetworks()
+    if err != nil {
+        return err
+    }
+    for _, n := range networks {
+        if !limited[n] {
+            if err := store.SaveTokenBucket(n, nil); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
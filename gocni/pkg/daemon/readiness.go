@@ -0,0 +1,302 @@
+package daemon
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/vishvananda/netlink"
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    k8stypes "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/compat"
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Readiness defaults
+const (
+    DefaultReadinessConfFile = "/etc/cni/net.d/10-vlan.conflist"
+    readyFileName            = "ready"
+
+    // NodeConditionReady is the node condition set when the plugin is usable
+    NodeConditionReady = "VlanNetworkReady"
+
+    // NodeLabelReady is set to "true" alongside the condition, so pods that
+    // need VLAN networks can require it with node affinity
+    NodeLabelReady = "vlan-cni.io/network-ready"
+
+    pluginType = "vlan-cni"
+)
+
+// readiness reports whether the plugin can serve ADDs on this node: its
+// network configuration is installed and valid, the masters it names exist
+// and the state directory is writable
+type readiness struct {
+    conf     *ReadinessConfig
+    nodeName string
+    client   kubernetes.Interface
+    store    *state.Store
+
+    // Latest kernel and driver probe, served by the daemon API
+    mu     sync.Mutex
+    compat *compat.Report
+
+    // Last reported state, so only changes are logged
+    reported *bool
+}
+
+func newReadiness(conf *ReadinessConfig, nodeName string, client kubernetes.Interface, store *state.Store) *readiness {
+    return &readiness{conf: conf, nodeName: nodeName, client: client, store: store}
+}
+
+// setCompat records a probe, logging what it found the first time and
+// whenever the kernel or masters change
+func (r *readiness) setCompat(report *compat.Report) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    
+    if prev := r.compat; prev == nil || !sameProbe(prev, report) {
+        log.Printf("compat: kernel %s, modules %v", report.Kernel, report.Modules)
+        for _, m := range report.Masters {
+            for kind, reason := range m.Unsupported {
+                log.Printf("compat: %s unsupported on %s (driver %s): %s", kind, m.Name, m.Driver, reason)
+            }
+            for _, w := range m.Warnings {
+                log.Printf("compat: %s (driver %s): %s", m.Name, m.Driver, w)
+            }
+        }
+    }
+    r.compat = report
+}
+
+// lastCompat returns the latest probe, or nil before the first one
+func (r *readiness) lastCompat() *compat.Report {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.compat
+}
+
+// serveCompat is the /v1/compat endpoint of the daemon API
+func (r *readiness) serveCompat(w http.ResponseWriter, req *http.Request) {
+    report := r.lastCompat()
+    if report == nil {
+        http.Error(w, "no probe yet", http.StatusServiceUnavailable)
+        return
+    }
+    writeJSON(w, report)
+}
+
+func sameProbe(a, b *compat.Report) bool {
+    if a.Kernel != b.Kernel || len(a.Masters) != len(b.Masters) || fmt.Sprint(a.Modules) != fmt.Sprint(b.Modules) {
+        return false
+    }
+    for i := range a.Masters {
+        if a.Masters[i].Name != b.Masters[i].Name || a.Masters[i].Driver != b.Masters[i].Driver {
+            return false
+        }
+    }
+    return true
+}
+
+// run re-evaluates readiness until ctx is done, then withdraws it
+func (r *readiness) run(ctx context.Context) {
+    ticker := time.NewTicker(r.conf.Interval.Or(10 * time.Second))
+    defer ticker.Stop()
+    
+    for {
+        r.report(ctx, r.check())
+        select {
+        case <-ctx.Done():
+            // The daemon stopping means nothing maintains the node anymore
+            shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+            r.report(shutdownCtx, fmt.Errorf("daemon stopped"))
+            cancel()
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// check returns why the plugin is not ready, or nil
+func (r *readiness) check() error {
+    data, err := ioutil.ReadFile(r.conf.ConfFile)
+    if err != nil {
+        return fmt.Errorf("network configuration not installed: %v", err)
+    }
+    plugins, err := vlanPlugins(data)
+    if err != nil {
+        return fmt.Errorf("invalid network configuration %s: %v", r.conf.ConfFile, err)
+    }
+    if len(plugins) == 0 {
+        return fmt.Errorf("%s has no %s plugin", r.conf.ConfFile, pluginType)
+    }
+    
+    var confs []*config.NetConf
+    var masters []string
+    for _, raw := range plugins {
+        conf, err := config.ParseConfigForNode(raw, storeNodeValues(r.store))
+        if err != nil {
+            return fmt.Errorf("invalid network configuration %s: %v", r.conf.ConfFile, err)
+        }
+        confs = append(confs, conf)
+        for i := range conf.Attachments {
+            confs = append(confs, conf.ForAttachment(i))
+        }
+    }
+    var probed []*config.NetConf
+    for _, c := range confs {
+        if c.Master == "" || c.Simulation == config.SimulationOn {
+            continue
+        }
+        if _, err := netlink.LinkByName(c.Master); err != nil {
+            if c.Simulation == config.SimulationAuto && compat.NestedNode() {
+                continue
+            }
+            return fmt.Errorf("master %q not found: %v", c.Master, err)
+        }
+        masters = append(masters, c.Master)
+        probed = append(probed, c)
+    }
+    
+    report := compat.Probe(masters, r.store)
+    r.setCompat(report)
+    for _, c := range probed {
+        if err := report.Check(compat.LinkKind(c.VlanID, c.UntaggedMode), c.Master, c.Isolated); err != nil {
+            return err
+        }
+    }
+    
+    probe := filepath.Join(r.store.Dir(), ".ready-probe")
+    if err := ioutil.WriteFile(probe, nil, 0600); err != nil {
+        return fmt.Errorf("state directory not writable: %v", err)
+    }
+    os.Remove(probe)
+    return nil
+}
+
+// vlanPlugins returns this plugin's entries of a conf or conflist document
+func vlanPlugins(data []byte) ([]json.RawMessage, error) {
+    var doc struct {
+        Type    string            `json:"type"`
+        Plugins []json.RawMessage `json:"plugins"`
+    }
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, err
+    }
+    if doc.Plugins == nil {
+        if doc.Type == pluginType {
+            return []json.RawMessage{data}, nil
+        }
+        return nil, nil
+    }
+    
+    var found []json.RawMessage
+    for _, raw := range doc.Plugins {
+        var p struct {
+            Type string `json:"type"`
+        }
+        if err := json.Unmarshal(raw, &p); err != nil {
+            return nil, err
+        }
+        if p.Type == pluginType {
+            found = append(found, raw)
+        }
+    }
+    return found, nil
+}
+
+// report publishes the readiness state through the ready file and, when
+// enabled, the node condition and label
+func (r *readiness) report(ctx context.Context, notReady error) {
+    ready := notReady == nil
+    
+    readyFile := filepath.Join(r.store.Dir(), readyFileName)
+    if ready {
+        if err := ioutil.WriteFile(readyFile, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+            log.Printf("readiness: failed to write %s: %v", readyFile, err)
+        }
+    } else if err := os.Remove(readyFile); err != nil && !os.IsNotExist(err) {
+        log.Printf("readiness: failed to remove %s: %v", readyFile, err)
+    }
+    
+    if r.reported == nil || *r.reported != ready {
+        if ready {
+            log.Printf("readiness: plugin ready")
+        } else {
+            log.Printf("readiness: plugin not ready: %v", notReady)
+        }
+    }
+    r.reported = &ready
+    
+    // Patched on every pass so the heartbeat shows the daemon is alive
+    if r.conf.NodeCondition {
+        if err := r.patchNode(ctx, notReady); err != nil {
+            log.Printf("readiness: failed to update node %s: %v", r.nodeName, err)
+        }
+    }
+}
+
+// patchNode sets the readiness condition and label on this node
+func (r *readiness) patchNode(ctx context.Context, notReady error) error {
+    now := metav1.Now()
+    cond := corev1.NodeCondition{
+        Type:              NodeConditionReady,
+        Status:            corev1.ConditionTrue,
+        Reason:            "PluginReady",
+        Message:           "vlan-cni network configuration is installed and valid",
+        LastHeartbeatTime: now,
+    }
+    if notReady != nil {
+        cond.Status = corev1.ConditionFalse
+        cond.Reason = "PluginNotReady"
+        cond.Message = notReady.Error()
+    }
+    
+    // Keep the transition time unless the status flips
+    node, err := r.client.CoreV1().Nodes().Get(ctx, r.nodeName, metav1.GetOptions{})
+    if err != nil {
+        return err
+    }
+    cond.LastTransitionTime = now
+    for _, c := range node.Status.Conditions {
+        if c.Type == NodeConditionReady && c.Status == cond.Status {
+            cond.LastTransitionTime = c.LastTransitionTime
+        }
+    }
+    
+    status, err := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{"conditions": []corev1.NodeCondition{cond}},
+    })
+    if err != nil {
+        return err
+    }
+    if _, err := r.client.CoreV1().Nodes().PatchStatus(ctx, r.nodeName, status); err != nil {
+        return err
+    }
+    
+    label := "false"
+    if notReady == nil {
+        label = "true"
+    }
+    if node.Labels[NodeLabelReady] == label {
+        return nil
+    }
+    labels, err := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{"labels": map[string]string{NodeLabelReady: label}},
+    })
+    if err != nil {
+        return err
+    }
+    _, err = r.client.CoreV1().Nodes().Patch(ctx, r.nodeName, k8stypes.StrategicMergePatchType, labels, metav1.PatchOptions{})
+    return err
+}
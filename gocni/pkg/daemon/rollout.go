@@ -0,0 +1,167 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+
+    policyv1 "k8s.io/api/policy/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/state"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// rollout finds attachments made by outdated network configurations and
+// evicts their pods on request, so a configuration change can be rolled
+// out a few pods at a time. Evictions honour PodDisruptionBudgets.
+type rollout struct {
+    conf     *RolloutConfig
+    confFile string
+    client   kubernetes.Interface
+    dynamic  dynamic.Interface
+    store    *state.Store
+}
+
+func newRollout(conf *RolloutConfig, confFile string, client kubernetes.Interface, dyn dynamic.Interface, store *state.Store) *rollout {
+    return &rollout{conf: conf, confFile: confFile, client: client, dynamic: dyn, store: store}
+}
+
+// serveRollout is the /v1/rollout endpoint of the daemon API:
+//
+//	GET  /v1/rollout[?network=<name>]
+//	POST /v1/rollout[?network=<name>][&max=N]
+//
+// GET reports the outdated attachments, POST also evicts the pods of up
+// to max of them, one by default.
+func (r *rollout) serveRollout(w http.ResponseWriter, req *http.Request) {
+    q := req.URL.Query()
+    max := 1
+    if v := q.Get("max"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            http.Error(w, fmt.Sprintf("invalid max %q", v), http.StatusBadRequest)
+            return
+        }
+        max = n
+    }
+    switch req.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        if r.conf == nil || !r.conf.AllowRecreate {
+            http.Error(w, "recreating pods is not enabled, set rollout.allowRecreate", http.StatusForbidden)
+            return
+        }
+    default:
+        http.Error(w, "use GET to list or POST to recreate", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    report, err := r.report(req.Context(), q.Get("network"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if req.Method == http.MethodPost {
+        r.recreate(req.Context(), report, max)
+    }
+    writeJSON(w, report)
+}
+
+// report compares the attachments with the current definitions
+func (r *rollout) report(ctx context.Context, network string) (*vlanv1.RolloutReport, error) {
+    defs := fileDefs(r.confFile)
+    if r.dynamic != nil {
+        nads, err := nadDefs(ctx, r.dynamic)
+        if err != nil {
+            return nil, err
+        }
+        for name, list := range nads {
+            defs[name] = append(defs[name], list...)
+        }
+    }
+    
+    attachments, err := r.store.ListAttachments()
+    if err != nil {
+        return nil, err
+    }
+    report := &vlanv1.RolloutReport{TypeMeta: vlanv1.Meta(vlanv1.KindRolloutReport), Outdated: []*vlanv1.OutdatedAttachment{}}
+    for _, a := range attachments {
+        if network != "" && a.Network != network {
+            continue
+        }
+        report.Attachments++
+        if a.ConfigHash == "" {
+            report.Unversioned++
+            continue
+        }
+        // Networks defined elsewhere cannot be judged
+        current := definitionHashes(defs[a.Network])
+        if len(current) == 0 || contains(current, a.ConfigHash) {
+            continue
+        }
+        report.Outdated = append(report.Outdated, &vlanv1.OutdatedAttachment{
+            Namespace:  a.PodNamespace,
+            Pod:        a.PodName,
+            Network:    a.Network,
+            IfName:     a.IfName,
+            ConfigHash: a.ConfigHash,
+            Current:    current,
+        })
+    }
+    sort.Slice(report.Outdated, func(i, j int) bool {
+        a, b := report.Outdated[i], report.Outdated[j]
+        return a.Namespace+"/"+a.Pod < b.Namespace+"/"+b.Pod
+    })
+    return report, nil
+}
+
+// recreate evicts the pods of up to max outdated attachments
+func (r *rollout) recreate(ctx context.Context, report *vlanv1.RolloutReport, max int) {
+    evicted := map[string]bool{}
+    for _, o := range report.Outdated {
+        pod := o.Namespace + "/" + o.Pod
+        if o.Pod == "" {
+            continue
+        }
+        if evicted[pod] {
+            o.Evicted = true
+            continue
+        }
+        if len(evicted) >= max {
+            break
+        }
+        err := r.client.PolicyV1().Evictions(o.Namespace).Evict(ctx, &policyv1.Eviction{
+            ObjectMeta: metav1.ObjectMeta{Name: o.Pod, Namespace: o.Namespace},
+        })
+        if err != nil {
+            o.Error = fmt.Sprintf("failed to evict: %v", err)
+            continue
+        }
+        evicted[pod] = true
+        o.Evicted = true
+    }
+}
+
+func definitionHashes(defs []*networkDef) []string {
+    var hashes []string
+    for _, d := range defs {
+        if d.hash != "" && !contains(hashes, d.hash) {
+            hashes = append(hashes, d.hash)
+        }
+    }
+    return hashes
+}
+
+func contains(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
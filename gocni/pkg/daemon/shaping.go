@@ -0,0 +1,333 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Class IDs of the HTB hierarchy under root qdisc 1:
+//
+//    1:1                uplink, the configured rate
+//    1:<vlan+1>         a VLAN's share
+//    1:<0x1000+vlan>    default pod class of a VLAN
+//    1:<0x1000*(n+2)+vlan> nth named pod class of a VLAN
+//    1:ffff             traffic of VLANs not configured
+//
+// Pod interfaces get a clsact egress filter setting skb->priority to their
+// class ID, which HTB on the master uses to classify the frame. Pod classes
+// only exist while an attachment uses them.
+const (
+    htbMajor       = 1
+    uplinkMinor    = 1
+    defaultMinor   = 0xffff
+    podClassStride = 0x1000
+)
+
+// minRate is what a class is guaranteed when the rates of its siblings
+// leave it nothing; HTB needs a nonzero rate, and the class borrows the
+// rest up to its ceiling (bytes per second, 8kbit)
+const minRate = 1000
+
+// shaper keeps the HTB hierarchy of one master interface in place
+type shaper struct {
+    conf  ShapingConfig
+    store *state.Store
+}
+
+func newShaper(conf ShapingConfig, store *state.Store) *shaper {
+    return &shaper{conf: conf, store: store}
+}
+
+// run reconciles until ctx is done
+func (s *shaper) run(ctx context.Context) {
+    ticker := time.NewTicker(s.conf.SyncInterval.Or(30 * time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := s.sync(); err != nil {
+            log.Printf("shaping %s: sync failed: %v", s.conf.Master, err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (s *shaper) sync() error {
+    master, err := netlink.LinkByName(s.conf.Master)
+    if err != nil {
+        return fmt.Errorf("failed to look up master: %v", err)
+    }
+    
+    attachments, err := s.store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    shaped := map[*state.Attachment]uint32{}
+    used := map[uint32]bool{}
+    for _, a := range attachments {
+        if a.Master != s.conf.Master {
+            continue
+        }
+        if classID, ok := s.podClass(a); ok {
+            shaped[a] = classID
+            used[classID] = true
+        }
+    }
+    
+    // Pod classes of attachments deleted since the last sync go with them
+    if err := s.syncHierarchy(master, used); err != nil {
+        return err
+    }
+    
+    for a, classID := range shaped {
+        if err := setPodClass(a, classID); err != nil {
+            log.Printf("shaping %s: pod %s/%s: %v", s.conf.Master, a.PodNamespace, a.PodName, err)
+        }
+    }
+    return nil
+}
+
+// syncHierarchy creates or updates the qdisc, the uplink, VLAN and default
+// classes and the pod classes in used, and deletes every other class
+func (s *shaper) syncHierarchy(master netlink.Link, used map[uint32]bool) error {
+    uplink, _ := parseRate(s.conf.Rate)
+    
+    // Unclassified traffic is guaranteed what the VLANs leave
+    shares := uint64(0)
+    for _, n := range s.conf.Networks {
+        rate, _ := parseRate(n.Rate)
+        shares += rate
+    }
+    defaultRate := remainder(uplink, shares)
+    if s.conf.DefaultRate != "" {
+        defaultRate, _ = parseRate(s.conf.DefaultRate)
+    }
+    
+    qdisc := netlink.NewHtb(netlink.QdiscAttrs{
+        LinkIndex: master.Attrs().Index,
+        Handle:    netlink.MakeHandle(htbMajor, 0),
+        Parent:    netlink.HANDLE_ROOT,
+    })
+    qdisc.Defcls = defaultMinor
+    if err := ensureQdisc(master, qdisc); err != nil {
+        return err
+    }
+    
+    root := netlink.MakeHandle(htbMajor, uplinkMinor)
+    classes := []*netlink.HtbClass{
+        htbClass(master, netlink.MakeHandle(htbMajor, 0), root, uplink, uplink, 0),
+        htbClass(master, root, netlink.MakeHandle(htbMajor, defaultMinor), defaultRate, uplink, 7),
+    }
+    
+    for _, n := range s.conf.Networks {
+        rate, _ := parseRate(n.Rate)
+        ceil := orRate(n.Ceil, uplink)
+        vlanClass := netlink.MakeHandle(htbMajor, uint16(n.VlanID+1))
+        classes = append(classes, htbClass(master, root, vlanClass, rate, ceil, 0))
+        
+        // The default pod class gets whatever the named classes leave
+        named := uint64(0)
+        for i, c := range n.Classes {
+            cRate, _ := parseRate(c.Rate)
+            named += cRate
+            if id := podClassID(n.VlanID, i+1); used[id] {
+                classes = append(classes, htbClass(master, vlanClass, id, cRate, orRate(c.Ceil, ceil), c.Priority))
+            }
+        }
+        if id := podClassID(n.VlanID, 0); used[id] {
+            classes = append(classes, htbClass(master, vlanClass, id, remainder(rate, named), ceil, 7))
+        }
+    }
+    
+    wanted := map[uint32]bool{}
+    for _, c := range classes {
+        if err := netlink.ClassReplace(c); err != nil {
+            return fmt.Errorf("failed to set HTB class %s: %v", netlink.HandleStr(c.Handle), err)
+        }
+        wanted[c.Handle] = true
+    }
+    return pruneClasses(master, wanted)
+}
+
+// ensureQdisc adds the HTB root qdisc unless it is in place. HTB qdiscs
+// cannot be changed, so replacing an existing one fails.
+func ensureQdisc(master netlink.Link, qdisc *netlink.Htb) error {
+    qdiscs, err := netlink.QdiscList(master)
+    if err != nil {
+        return fmt.Errorf("failed to list qdiscs: %v", err)
+    }
+    for _, q := range qdiscs {
+        htb, ok := q.(*netlink.Htb)
+        if q.Attrs().Parent != netlink.HANDLE_ROOT || !ok {
+            continue
+        }
+        if htb.Handle == qdisc.Handle && htb.Defcls == qdisc.Defcls {
+            return nil
+        }
+    }
+    if err := netlink.QdiscReplace(qdisc); err != nil {
+        return fmt.Errorf("failed to set HTB qdisc: %v", err)
+    }
+    return nil
+}
+
+// pruneClasses deletes the HTB classes not wanted, those of pods and of
+// VLANs no longer configured. Pod classes have the higher minors, so
+// deleting in descending order removes children before their parents.
+func pruneClasses(master netlink.Link, wanted map[uint32]bool) error {
+    existing, err := netlink.ClassList(master, netlink.MakeHandle(htbMajor, 0))
+    if err != nil {
+        return fmt.Errorf("failed to list HTB classes: %v", err)
+    }
+    var stale []netlink.Class
+    for _, c := range existing {
+        if !wanted[c.Attrs().Handle] {
+            stale = append(stale, c)
+        }
+    }
+    sort.Slice(stale, func(i, j int) bool {
+        return stale[i].Attrs().Handle&0xffff > stale[j].Attrs().Handle&0xffff
+    })
+    for _, c := range stale {
+        if err := netlink.ClassDel(c); err != nil {
+            return fmt.Errorf("failed to delete HTB class %s: %v", netlink.HandleStr(c.Attrs().Handle), err)
+        }
+    }
+    return nil
+}
+
+// remainder returns what of rate is left after used, or minRate when
+// nothing is
+func remainder(rate, used uint64) uint64 {
+    if used+minRate > rate {
+        return minRate
+    }
+    return rate - used
+}
+
+// podClass returns the class a pod's traffic belongs in, if its VLAN is
+// shaped
+func (s *shaper) podClass(a *state.Attachment) (uint32, bool) {
+    for _, n := range s.conf.Networks {
+        if n.VlanID != a.VlanID {
+            continue
+        }
+        for i, c := range n.Classes {
+            if c.Name == a.TrafficClass {
+                return podClassID(n.VlanID, i+1), true
+            }
+        }
+        return podClassID(n.VlanID, 0), true
+    }
+    return 0, false
+}
+
+// setPodClass tags traffic leaving the pod interface with its class ID
+func setPodClass(a *state.Attachment, classID uint32) error {
+    netns, err := ns.GetNS(a.Netns)
+    if err != nil {
+        return fmt.Errorf("failed to open netns %q: %v", a.Netns, err)
+    }
+    defer netns.Close()
+    
+    return netns.Do(func(ns.NetNS) error {
+        link, err := netlink.LinkByName(a.PodInterface())
+        if err != nil {
+            return fmt.Errorf("failed to look up %q: %v", a.PodInterface(), err)
+        }
+        
+        clsact := &netlink.GenericQdisc{
+            QdiscAttrs: netlink.QdiscAttrs{
+                LinkIndex: link.Attrs().Index,
+                Handle:    netlink.MakeHandle(0xffff, 0),
+                Parent:    netlink.HANDLE_CLSACT,
+            },
+            QdiscType: "clsact",
+        }
+        if err := netlink.QdiscReplace(clsact); err != nil {
+            return fmt.Errorf("failed to add clsact qdisc: %v", err)
+        }
+        
+        prio := classID
+        filter := &netlink.MatchAll{
+            FilterAttrs: netlink.FilterAttrs{
+                LinkIndex: link.Attrs().Index,
+                Parent:    netlink.HANDLE_MIN_EGRESS,
+                Handle:    1,
+                Priority:  1,
+                Protocol:  syscall.ETH_P_ALL,
+            },
+            Actions: []netlink.Action{&netlink.SkbEditAction{
+                ActionAttrs: netlink.ActionAttrs{Action: netlink.TC_ACT_PIPE},
+                Priority:    &prio,
+            }},
+        }
+        if err := netlink.FilterReplace(filter); err != nil {
+            return fmt.Errorf("failed to set class filter: %v", err)
+        }
+        return nil
+    })
+}
+
+// htbClass returns a class of rate and ceil bytes per second
+func htbClass(link netlink.Link, parent, handle uint32, rate, ceil uint64, prio uint32) *netlink.HtbClass {
+    if ceil < rate {
+        ceil = rate
+    }
+    // netlink takes bits per second
+    return netlink.NewHtbClass(netlink.ClassAttrs{
+        LinkIndex: link.Attrs().Index,
+        Parent:    parent,
+        Handle:    handle,
+    }, netlink.HtbClassAttrs{Rate: rate * 8, Ceil: ceil * 8, Prio: prio})
+}
+
+// podClassID returns the nth pod class of a VLAN, 0 being the default
+func podClassID(vlanID, n int) uint32 {
+    return netlink.MakeHandle(htbMajor, uint16(podClassStride*(n+1)+vlanID))
+}
+
+func orRate(s string, def uint64) uint64 {
+    if s == "" {
+        return def
+    }
+    r, _ := parseRate(s)
+    return r
+}
+
+// parseRate converts a tc style rate into bytes per second
+func parseRate(s string) (uint64, error) {
+    units := []struct {
+        suffix string
+        bits   uint64
+    }{
+        {"gbit", 1e9}, {"mbit", 1e6}, {"kbit", 1e3}, {"bit", 1},
+    }
+    
+    lower := strings.ToLower(strings.TrimSpace(s))
+    for _, u := range units {
+        if !strings.HasSuffix(lower, u.suffix) {
+            continue
+        }
+        v, err := strconv.ParseFloat(strings.TrimSuffix(lower, u.suffix), 64)
+        if err != nil || v <= 0 {
+            break
+        }
+        return uint64(v*float64(u.bits)) / 8, nil
+    }
+    return 0, fmt.Errorf("invalid rate %q", s)
+}
@@ -0,0 +1,183 @@
+package daemon
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/hostlink"
+    "example.com/vlan-cni/pkg/secrets"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// site bridges VLANs of a trunk into VXLAN tunnels to a remote site's
+// gateway. Each VLAN gets a bridge joining its link on the trunk and its
+// tunnel, whose frames IPsec carries.
+type site struct {
+    conf  SiteConfig
+    store *state.Store
+
+    local, remote net.IP
+}
+
+func newSite(conf SiteConfig, store *state.Store) *site {
+    return &site{conf: conf, store: store, local: net.ParseIP(conf.Local), remote: net.ParseIP(conf.Remote)}
+}
+
+// run reconciles until ctx is done, then removes the tunnels and their keys
+func (s *site) run(ctx context.Context) {
+    ticker := time.NewTicker(s.conf.SyncInterval.Or(30 * time.Second))
+    defer ticker.Stop()
+    
+    for {
+        if err := s.sync(ctx); err != nil {
+            log.Printf("site %s: sync failed: %v", s.conf.Name, err)
+        }
+        select {
+        case <-ctx.Done():
+            if err := s.remove(); err != nil {
+                log.Printf("site %s: cleanup failed: %v", s.conf.Name, err)
+            }
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (s *site) sync(ctx context.Context) error {
+    master, err := netlink.LinkByName(s.conf.Master)
+    if err != nil {
+        return fmt.Errorf("failed to look up master: %v", err)
+    }
+    psk, err := secrets.Resolve(ctx, s.conf.IPsec.PSKFrom, s.store)
+    if err != nil {
+        return fmt.Errorf("failed to resolve psk: %v", err)
+    }
+    
+    // Tunnels only come up once their traffic is encrypted
+    if s.conf.IPsec.Mode == IPsecStrongSwan {
+        err = s.loadSwanctl(ctx, psk)
+    } else {
+        err = s.installXfrm(psk)
+    }
+    if err != nil {
+        return err
+    }
+    
+    var errs []string
+    for _, vlanID := range s.conf.VlanIDs {
+        if err := s.syncVlan(master, vlanID); err != nil {
+            errs = append(errs, fmt.Sprintf("vlan %d: %v", vlanID, err))
+        }
+    }
+    if len(errs) > 0 {
+        return fmt.Errorf("%s", strings.Join(errs, "; "))
+    }
+    return nil
+}
+
+// syncVlan creates the VLAN's bridge and tunnel, and bridges the host's
+// device of the VLAN on the trunk
+func (s *site) syncVlan(master netlink.Link, vlanID int) error {
+    bridge, err := s.ensureLink(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: s.linkName("sb", vlanID)}})
+    if err != nil {
+        return err
+    }
+    
+    port := s.port(vlanID)
+    tunnel := &netlink.Vxlan{
+        LinkAttrs: netlink.LinkAttrs{Name: s.linkName("sx", vlanID), MTU: s.conf.MTU},
+        VxlanId:   vlanID,
+        SrcAddr:   s.local,
+        Group:     s.remote,
+        Port:      port,
+        // A fixed source port lets the IPsec selectors match both
+        // directions of the VLAN alike
+        PortLow:  port,
+        PortHigh: port,
+        Learning: true,
+    }
+    link, err := s.ensureLink(tunnel)
+    if err != nil {
+        return err
+    }
+    trunk, err := hostlink.SharedVlan(master, vlanID)
+    if err != nil {
+        return err
+    }
+    for _, link := range []netlink.Link{link, trunk} {
+        if link.Attrs().MasterIndex != bridge.Attrs().Index {
+            if err := netlink.LinkSetMaster(link, bridge); err != nil {
+                return fmt.Errorf("failed to attach %q to %q: %v", link.Attrs().Name, bridge.Attrs().Name, err)
+            }
+        }
+    }
+    return nil
+}
+
+// ensureLink creates link unless a link of its name exists, and sets it up
+func (s *site) ensureLink(link netlink.Link) (netlink.Link, error) {
+    name := link.Attrs().Name
+    if err := netlink.LinkAdd(link); err != nil && !os.IsExist(err) {
+        return nil, fmt.Errorf("failed to add %q: %v", name, err)
+    }
+    existing, err := netlink.LinkByName(name)
+    if err != nil {
+        return nil, fmt.Errorf("failed to look up %q: %v", name, err)
+    }
+    if err := netlink.LinkSetUp(existing); err != nil {
+        return nil, fmt.Errorf("failed to set %q up: %v", name, err)
+    }
+    return existing, nil
+}
+
+// remove deletes the site's links, then its IPsec configuration
+func (s *site) remove() error {
+    var errs []string
+    master, _ := netlink.LinkByName(s.conf.Master)
+    for _, vlanID := range s.conf.VlanIDs {
+        for _, prefix := range []string{"sx", "sb"} {
+            link, err := netlink.LinkByName(s.linkName(prefix, vlanID))
+            if err != nil {
+                continue
+            }
+            if err := netlink.LinkDel(link); err != nil {
+                errs = append(errs, fmt.Sprintf("failed to delete %q: %v", link.Attrs().Name, err))
+            }
+        }
+        if master != nil {
+            if err := hostlink.ReleaseSharedVlan(master, vlanID, s.store); err != nil {
+                errs = append(errs, err.Error())
+            }
+        }
+    }
+    var err error
+    if s.conf.IPsec.Mode == IPsecStrongSwan {
+        err = s.unloadSwanctl()
+    } else {
+        err = s.removeXfrm()
+    }
+    if err != nil {
+        errs = append(errs, err.Error())
+    }
+    if len(errs) > 0 {
+        return fmt.Errorf("%s", strings.Join(errs, "; "))
+    }
+    return nil
+}
+
+// port is the UDP port carrying the VLAN, on both ends of its tunnel
+func (s *site) port(vlanID int) int {
+    return s.conf.BasePort + vlanID
+}
+
+// linkName names the VLAN's devices, such as sx100-dc2 for its tunnel
+func (s *site) linkName(prefix string, vlanID int) string {
+    return fmt.Sprintf("%s%d-%s", prefix, vlanID, s.conf.Name)
+}
@@ -0,0 +1,51 @@
+package ddns
+
+import (
+    "context"
+    "fmt"
+    "net"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Record is the DNS data registered for one attachment
+type Record struct {
+    // Stable identifier of the attachment, used to name provider objects
+    ID string
+
+    // Fully qualified name and the addresses it should resolve to
+    FQDN      string
+    IPs       []net.IP
+    Namespace string
+}
+
+// Registrar publishes and retracts attachment records
+type Registrar interface {
+    Register(ctx context.Context, r *Record) error
+    Deregister(ctx context.Context, r *Record) error
+}
+
+// New returns the registrar for the configured provider. Credentials read
+// from Secrets are cached in store.
+func New(conf *config.DDNSConfig, kubeconfig string, store *state.Store) (Registrar, error) {
+    switch conf.Provider {
+    case config.DDNSProviderRFC2136:
+        return newRFC2136(conf, store), nil
+    case config.DDNSProviderDNSEndpoint:
+        return newDNSEndpoint(conf, kubeconfig)
+    }
+    return nil, fmt.Errorf("ddns: unknown provider %q", conf.Provider)
+}
+
+// FQDN joins a record name to the zone
+func FQDN(name, zone string) string {
+    fqdn := name
+    if zone != "" {
+        fqdn = name + "." + zone
+    }
+    if fqdn[len(fqdn)-1] != '.' {
+        fqdn += "."
+    }
+    return fqdn
+}
@@ -0,0 +1,106 @@
+package ddns
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/kube"
+)
+
+var dnsEndpointGVR = schema.GroupVersionResource{
+    Group:    "externaldns.k8s.io",
+    Version:  "v1alpha1",
+    Resource: "dnsendpoints",
+}
+
+// dnsEndpoint publishes records as external-dns DNSEndpoint objects
+type dnsEndpoint struct {
+    conf   *config.DDNSConfig
+    client dynamic.Interface
+}
+
+func newDNSEndpoint(conf *config.DDNSConfig, kubeconfig string) (*dnsEndpoint, error) {
+    client, err := kube.NewDynamicClient(kubeconfig)
+    if err != nil {
+        return nil, err
+    }
+    return &dnsEndpoint{conf: conf, client: client}, nil
+}
+
+// Register creates or updates the DNSEndpoint for the attachment
+func (e *dnsEndpoint) Register(ctx context.Context, r *Record) error {
+    obj := e.object(r)
+    res := e.client.Resource(dnsEndpointGVR).Namespace(obj.GetNamespace())
+    
+    existing, err := res.Get(ctx, obj.GetName(), metav1.GetOptions{})
+    switch {
+    case apierrors.IsNotFound(err):
+        _, err = res.Create(ctx, obj, metav1.CreateOptions{})
+    case err == nil:
+        obj.SetResourceVersion(existing.GetResourceVersion())
+        _, err = res.Update(ctx, obj, metav1.UpdateOptions{})
+    }
+    if err != nil {
+        return fmt.Errorf("ddns: failed to write DNSEndpoint %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+    }
+    return nil
+}
+
+// Deregister deletes the DNSEndpoint for the attachment
+func (e *dnsEndpoint) Deregister(ctx context.Context, r *Record) error {
+    obj := e.object(r)
+    err := e.client.Resource(dnsEndpointGVR).Namespace(obj.GetNamespace()).Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+    if err != nil && !apierrors.IsNotFound(err) {
+        return fmt.Errorf("ddns: failed to delete DNSEndpoint %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+    }
+    return nil
+}
+
+func (e *dnsEndpoint) object(r *Record) *unstructured.Unstructured {
+    namespace := e.conf.Namespace
+    if namespace == "" {
+        namespace = r.Namespace
+    }
+    
+    var endpoints []interface{}
+    for _, rrtype := range []string{"A", "AAAA"} {
+        var targets []interface{}
+        for _, ip := range r.IPs {
+            if (ip.To4() != nil) == (rrtype == "A") {
+                targets = append(targets, ip.String())
+            }
+        }
+        if len(targets) == 0 {
+            continue
+        }
+        endpoints = append(endpoints, map[string]interface{}{
+            "dnsName":    strings.TrimSuffix(r.FQDN, "."),
+            "recordType": rrtype,
+            "recordTTL":  int64(e.conf.TTL),
+            "targets":    targets,
+        })
+    }
+    
+    return &unstructured.Unstructured{Object: map[string]interface{}{
+        "apiVersion": "externaldns.k8s.io/v1alpha1",
+        "kind":       "DNSEndpoint",
+        "metadata": map[string]interface{}{
+            "name":      "vlan-cni-" + strings.ToLower(r.ID),
+            "namespace": namespace,
+            "labels": map[string]interface{}{
+                "app.kubernetes.io/managed-by": "vlan-cni",
+            },
+        },
+        "spec": map[string]interface{}{
+            "endpoints": endpoints,
+        },
+    }}
+}
@@ -0,0 +1,103 @@
+package ddns
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/miekg/dns"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/secrets"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// rfc2136 registers records with DNS UPDATE messages
+type rfc2136 struct {
+    conf  *config.DDNSConfig
+    store *state.Store
+}
+
+func newRFC2136(conf *config.DDNSConfig, store *state.Store) *rfc2136 {
+    return &rfc2136{conf: conf, store: store}
+}
+
+// Register replaces the A/AAAA rrsets for the name with the record's addresses
+func (u *rfc2136) Register(ctx context.Context, r *Record) error {
+    m := u.message()
+    for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+        m.RemoveRRset([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: r.FQDN, Rrtype: rrtype, Class: dns.ClassINET}}})
+    }
+    
+    rrs, err := u.rrs(r)
+    if err != nil {
+        return err
+    }
+    m.Insert(rrs)
+    
+    return u.exchange(ctx, m)
+}
+
+// Deregister removes only the record's addresses, leaving others untouched
+func (u *rfc2136) Deregister(ctx context.Context, r *Record) error {
+    rrs, err := u.rrs(r)
+    if err != nil || len(rrs) == 0 {
+        return err
+    }
+    
+    m := u.message()
+    m.Remove(rrs)
+    return u.exchange(ctx, m)
+}
+
+func (u *rfc2136) message() *dns.Msg {
+    m := new(dns.Msg)
+    m.SetUpdate(dns.Fqdn(u.conf.Zone))
+    return m
+}
+
+func (u *rfc2136) rrs(r *Record) ([]dns.RR, error) {
+    var rrs []dns.RR
+    for _, ip := range r.IPs {
+        rrtype := "AAAA"
+        if ip.To4() != nil {
+            rrtype = "A"
+        }
+        rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", r.FQDN, u.conf.TTL, rrtype, ip))
+        if err != nil {
+            return nil, fmt.Errorf("ddns: failed to build record for %s: %v", ip, err)
+        }
+        rrs = append(rrs, rr)
+    }
+    return rrs, nil
+}
+
+func (u *rfc2136) exchange(ctx context.Context, m *dns.Msg) error {
+    c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+    
+    if u.conf.TSIGKeyName != "" {
+        keyName := dns.Fqdn(u.conf.TSIGKeyName)
+        alg := u.conf.TSIGAlgorithm
+        if alg == "" {
+            alg = dns.HmacSHA256
+        }
+        secret := u.conf.TSIGSecret
+        if u.conf.TSIGSecretFrom != nil {
+            var err error
+            if secret, err = secrets.Resolve(ctx, u.conf.TSIGSecretFrom, u.store); err != nil {
+                return fmt.Errorf("ddns: tsigSecretFrom: %v", err)
+            }
+        }
+        c.TsigSecret = map[string]string{keyName: secret}
+        m.SetTsig(keyName, dns.Fqdn(alg), 300, time.Now().Unix())
+    }
+    
+    resp, _, err := c.ExchangeContext(ctx, m, u.conf.Server)
+    if err != nil {
+        return fmt.Errorf("ddns: update to %s failed: %v", u.conf.Server, err)
+    }
+    if resp.Rcode != dns.RcodeSuccess {
+        return fmt.Errorf("ddns: update to %s rejected: %s", u.conf.Server, dns.RcodeToString[resp.Rcode])
+    }
+    return nil
+}
@@ -0,0 +1,375 @@
+// Package dhcp6 is a DHCPv6 client (RFC 8415) for addresses (IA_NA) and
+// delegated prefixes (IA_PD). It talks from the interface's link-local
+// address, so it runs in the interface's network namespace once the link is
+// up.
+package dhcp6
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/binary"
+    "fmt"
+    "net"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+    "github.com/vishvananda/netlink"
+)
+
+const (
+    clientPort = 546
+    serverPort = 547
+)
+
+// allServers is All_DHCP_Relay_Agents_and_Servers
+var allServers = net.ParseIP("ff02::1:2")
+
+// Retransmission of RFC 8415 section 15, shortened for pods waiting on it
+const (
+    initialTimeout = time.Second
+    maxTimeout     = 8 * time.Second
+)
+
+// Address is a leased address and its lifetimes in seconds
+type Address struct {
+    IP        net.IP `json:"ip"`
+    Preferred uint32 `json:"preferred"`
+    Valid     uint32 `json:"valid"`
+}
+
+// Prefix is a delegated prefix and its lifetimes in seconds
+type Prefix struct {
+    Prefix    types.IPNet `json:"prefix"`
+    Preferred uint32      `json:"preferred"`
+    Valid     uint32      `json:"valid"`
+}
+
+// Lease is what a server granted, kept to renew and release it
+type Lease struct {
+    ClientID  []byte    `json:"clientID"`
+    ServerID  []byte    `json:"serverID"`
+    IAID      uint32    `json:"iaid"`
+    T1        uint32    `json:"t1"`
+    T2        uint32    `json:"t2"`
+    Addresses []Address `json:"addresses,omitempty"`
+    Prefixes  []Prefix  `json:"prefixes,omitempty"`
+    DNS       []net.IP  `json:"dns,omitempty"`
+    Obtained  time.Time `json:"obtained"`
+}
+
+// RenewAt is when the lease is due for renewal with its server
+func (l *Lease) RenewAt() time.Time {
+    t1 := l.T1
+    if t1 == 0 {
+        t1 = l.minPreferred() / 2
+    }
+    return l.Obtained.Add(time.Duration(t1) * time.Second)
+}
+
+// RebindAt is when any server may extend the lease instead
+func (l *Lease) RebindAt() time.Time {
+    t2 := l.T2
+    if t2 == 0 {
+        t2 = l.minPreferred() * 4 / 5
+    }
+    return l.Obtained.Add(time.Duration(t2) * time.Second)
+}
+
+func (l *Lease) minPreferred() uint32 {
+    min := uint32(0)
+    for _, a := range l.Addresses {
+        if min == 0 || a.Preferred < min {
+            min = a.Preferred
+        }
+    }
+    for _, p := range l.Prefixes {
+        if min == 0 || p.Preferred < min {
+            min = p.Preferred
+        }
+    }
+    return min
+}
+
+// Options are what the client asks for
+type Options struct {
+    // Ask for an address
+    Address bool
+
+    // Ask for a delegated prefix, of PrefixLength if set
+    PrefixDelegation bool
+    PrefixLength     int
+
+    // Accept a Reply to the Solicit, skipping Advertise and Request
+    RapidCommit bool
+}
+
+// Client exchanges messages on one interface
+type Client struct {
+    conn     *net.UDPConn
+    ifName   string
+    clientID []byte
+    iaid     uint32
+}
+
+// NewClient binds to the link-local address of the named interface, waiting
+// for duplicate address detection to finish with it
+func NewClient(ctx context.Context, ifName string, iaid uint32) (*Client, error) {
+    link, err := netlink.LinkByName(ifName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to lookup interface %q: %v", ifName, err)
+    }
+    ll, err := waitLinkLocal(ctx, link)
+    if err != nil {
+        return nil, err
+    }
+    conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: ll, Port: clientPort, Zone: ifName})
+    if err != nil {
+        return nil, fmt.Errorf("failed to listen on [%s%%%s]:%d: %v", ll, ifName, clientPort, err)
+    }
+    return &Client{
+        conn:     conn,
+        ifName:   ifName,
+        clientID: duidLL(link.Attrs().HardwareAddr),
+        iaid:     iaid,
+    }, nil
+}
+
+// Close releases the socket
+func (c *Client) Close() error {
+    return c.conn.Close()
+}
+
+// Acquire obtains a lease through Solicit, Advertise, Request and Reply
+func (c *Client) Acquire(ctx context.Context, opts Options) (*Lease, error) {
+    solicit := &message{msgType: msgSolicit}
+    c.addIAs(solicit, opts, nil)
+    if opts.RapidCommit {
+        solicit.add(optRapidCommit, nil)
+    }
+    
+    reply, err := c.exchange(ctx, solicit, func(m *message) bool {
+        return m.msgType == msgAdvertise || (opts.RapidCommit && m.msgType == msgReply && m.get(optRapidCommit) != nil)
+    })
+    if err != nil {
+        return nil, err
+    }
+    if reply.msgType == msgReply {
+        return c.lease(reply, opts)
+    }
+    
+    offer, err := c.lease(reply, opts)
+    if err != nil {
+        return nil, err
+    }
+    request := &message{msgType: msgRequest}
+    request.add(optServerID, offer.ServerID)
+    c.addIAs(request, opts, offer)
+    reply, err = c.exchange(ctx, request, func(m *message) bool { return m.msgType == msgReply })
+    if err != nil {
+        return nil, err
+    }
+    return c.lease(reply, opts)
+}
+
+// Renew extends the lease with the server that granted it or, when rebind
+// is set, with any server
+func (c *Client) Renew(ctx context.Context, l *Lease, rebind bool) (*Lease, error) {
+    opts := leaseOptions(l)
+    m := &message{msgType: msgRenew}
+    if rebind {
+        m.msgType = msgRebind
+    } else {
+        m.add(optServerID, l.ServerID)
+    }
+    c.clientID, c.iaid = l.ClientID, l.IAID
+    c.addIAs(m, opts, l)
+    reply, err := c.exchange(ctx, m, func(m *message) bool { return m.msgType == msgReply })
+    if err != nil {
+        return nil, err
+    }
+    return c.lease(reply, opts)
+}
+
+// Release gives the lease back
+func (c *Client) Release(ctx context.Context, l *Lease) error {
+    m := &message{msgType: msgRelease}
+    m.add(optServerID, l.ServerID)
+    c.clientID, c.iaid = l.ClientID, l.IAID
+    c.addIAs(m, leaseOptions(l), l)
+    _, err := c.exchange(ctx, m, func(m *message) bool { return m.msgType == msgReply })
+    return err
+}
+
+// leaseOptions are the options that got the lease
+func leaseOptions(l *Lease) Options {
+    return Options{Address: len(l.Addresses) > 0, PrefixDelegation: len(l.Prefixes) > 0}
+}
+
+// addIAs adds the identity associations, carrying what l holds when set
+func (c *Client) addIAs(m *message, opts Options, l *Lease) {
+    if opts.Address {
+        var sub []option
+        if l != nil {
+            for _, a := range l.Addresses {
+                sub = append(sub, option{optIAAddr, iaAddr(a)})
+            }
+        }
+        m.add(optIANA, ia(c.iaid, 0, 0, sub))
+    }
+    if opts.PrefixDelegation {
+        var sub []option
+        if l != nil {
+            for _, p := range l.Prefixes {
+                sub = append(sub, option{optIAPrefix, iaPrefix(p)})
+            }
+        } else if opts.PrefixLength > 0 {
+            hint := Prefix{Prefix: types.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(opts.PrefixLength, 128)}}
+            sub = append(sub, option{optIAPrefix, iaPrefix(hint)})
+        }
+        m.add(optIAPD, ia(c.iaid, 0, 0, sub))
+    }
+    oro := binary.BigEndian.AppendUint16(nil, optDNSServers)
+    m.add(optORO, binary.BigEndian.AppendUint16(oro, optDomainList))
+}
+
+// exchange sends m until a response accept takes arrives or ctx is done
+func (c *Client) exchange(ctx context.Context, m *message, accept func(*message) bool) (*message, error) {
+    if _, err := rand.Read(m.xid[:]); err != nil {
+        return nil, err
+    }
+    m.add(optClientID, c.clientID)
+    elapsed := len(m.options)
+    m.add(optElapsedTime, []byte{0, 0})
+    
+    dst := &net.UDPAddr{IP: allServers, Port: serverPort, Zone: c.ifName}
+    start := time.Now()
+    timeout := initialTimeout
+    buf := make([]byte, 65536)
+    for {
+        cs := time.Since(start).Milliseconds() / 10
+        if cs > 0xffff {
+            cs = 0xffff
+        }
+        m.options[elapsed].data = binary.BigEndian.AppendUint16(nil, uint16(cs))
+        if _, err := c.conn.WriteToUDP(m.marshal(), dst); err != nil {
+            return nil, fmt.Errorf("failed to send DHCPv6 message on %q: %v", c.ifName, err)
+        }
+        
+        deadline := time.Now().Add(timeout)
+        if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+            deadline = d
+        }
+        _ = c.conn.SetReadDeadline(deadline)
+        for {
+            n, _, err := c.conn.ReadFromUDP(buf)
+            if err != nil {
+                break
+            }
+            resp, err := parseMessage(buf[:n])
+            if err != nil || resp.xid != m.xid || string(resp.get(optClientID)) != string(c.clientID) {
+                continue
+            }
+            if accept(resp) {
+                return resp, nil
+            }
+        }
+        if err := ctx.Err(); err != nil {
+            return nil, fmt.Errorf("no DHCPv6 server answered on %q: %v", c.ifName, err)
+        }
+        if timeout *= 2; timeout > maxTimeout {
+            timeout = maxTimeout
+        }
+    }
+}
+
+// lease decodes what a server offered or granted
+func (c *Client) lease(m *message, opts Options) (*Lease, error) {
+    if code, msg := status(m.options); code != statusSuccess {
+        return nil, fmt.Errorf("DHCPv6 server refused: status %d %s", code, msg)
+    }
+    l := &Lease{
+        ClientID: c.clientID,
+        ServerID: m.get(optServerID),
+        IAID:     c.iaid,
+        DNS:      parseIPs(m.get(optDNSServers)),
+        Obtained: time.Now().UTC(),
+    }
+    if l.ServerID == nil {
+        return nil, fmt.Errorf("DHCPv6 response without a server identifier")
+    }
+    
+    for _, o := range m.options {
+        if o.code != optIANA && o.code != optIAPD {
+            continue
+        }
+        iaid, t1, t2, sub, err := parseIA(o.data)
+        if err != nil || iaid != c.iaid {
+            continue
+        }
+        if code, msg := status(sub); code != statusSuccess {
+            return nil, fmt.Errorf("DHCPv6 server refused the IA: status %d %s", code, msg)
+        }
+        l.T1, l.T2 = t1, t2
+        for _, s := range sub {
+            switch {
+            case s.code == optIAAddr && len(s.data) >= 24:
+                a := Address{
+                    IP:        net.IP(append([]byte{}, s.data[:16]...)),
+                    Preferred: binary.BigEndian.Uint32(s.data[16:20]),
+                    Valid:     binary.BigEndian.Uint32(s.data[20:24]),
+                }
+                if a.Valid > 0 {
+                    l.Addresses = append(l.Addresses, a)
+                }
+            // A length over 128 is malformed, net.CIDRMask has no mask for it
+            case s.code == optIAPrefix && len(s.data) >= 25 && s.data[8] <= 128:
+                p := Prefix{
+                    Preferred: binary.BigEndian.Uint32(s.data[0:4]),
+                    Valid:     binary.BigEndian.Uint32(s.data[4:8]),
+                    Prefix: types.IPNet{
+                        IP:   net.IP(append([]byte{}, s.data[9:25]...)),
+                        Mask: net.CIDRMask(int(s.data[8]), 128),
+                    },
+                }
+                if p.Valid > 0 {
+                    l.Prefixes = append(l.Prefixes, p)
+                }
+            }
+        }
+    }
+    if opts.Address && len(l.Addresses) == 0 {
+        return nil, fmt.Errorf("DHCPv6 server offered no address")
+    }
+    if opts.PrefixDelegation && len(l.Prefixes) == 0 {
+        return nil, fmt.Errorf("DHCPv6 server delegated no prefix")
+    }
+    return l, nil
+}
+
+// waitLinkLocal returns the link-local address once it is usable
+func waitLinkLocal(ctx context.Context, link netlink.Link) (net.IP, error) {
+    ticker := time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
+    for {
+        addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+        if err != nil {
+            return nil, fmt.Errorf("failed to list addresses of %q: %v", link.Attrs().Name, err)
+        }
+        for _, a := range addrs {
+            if a.IP.IsLinkLocalUnicast() && a.Flags&(ifaFTentative|ifaFDadFailed) == 0 {
+                return a.IP, nil
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return nil, fmt.Errorf("%q has no usable link-local address: %v", link.Attrs().Name, ctx.Err())
+        case <-ticker.C:
+        }
+    }
+}
+
+// Address flags of linux/if_addr.h
+const (
+    ifaFTentative = 0x40
+    ifaFDadFailed = 0x08
+)
@@ -0,0 +1,168 @@
+package dhcp6
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+)
+
+// Message types of RFC 8415
+const (
+    msgSolicit   = 1
+    msgAdvertise = 2
+    msgRequest   = 3
+    msgRenew     = 5
+    msgRebind    = 6
+    msgReply     = 7
+    msgRelease   = 8
+)
+
+// Option codes
+const (
+    optClientID    = 1
+    optServerID    = 2
+    optIANA        = 3
+    optIAAddr      = 5
+    optORO         = 6
+    optPreference  = 7
+    optElapsedTime = 8
+    optStatusCode  = 13
+    optRapidCommit = 14
+    optDNSServers  = 23
+    optDomainList  = 24
+    optIAPD        = 25
+    optIAPrefix    = 26
+)
+
+// Status codes
+const (
+    statusSuccess = 0
+)
+
+// option is a decoded code and value
+type option struct {
+    code uint16
+    data []byte
+}
+
+// message is a client or server message
+type message struct {
+    msgType uint8
+    xid     [3]byte
+    options []option
+}
+
+func (m *message) add(code uint16, data []byte) {
+    m.options = append(m.options, option{code, data})
+}
+
+func (m *message) get(code uint16) []byte {
+    for _, o := range m.options {
+        if o.code == code {
+            return o.data
+        }
+    }
+    return nil
+}
+
+func (m *message) marshal() []byte {
+    b := []byte{m.msgType, m.xid[0], m.xid[1], m.xid[2]}
+    return append(b, marshalOptions(m.options)...)
+}
+
+func marshalOptions(options []option) []byte {
+    var b []byte
+    for _, o := range options {
+        b = binary.BigEndian.AppendUint16(b, o.code)
+        b = binary.BigEndian.AppendUint16(b, uint16(len(o.data)))
+        b = append(b, o.data...)
+    }
+    return b
+}
+
+func parseMessage(b []byte) (*message, error) {
+    if len(b) < 4 {
+        return nil, fmt.Errorf("short message")
+    }
+    m := &message{msgType: b[0]}
+    copy(m.xid[:], b[1:4])
+    options, err := parseOptions(b[4:])
+    if err != nil {
+        return nil, err
+    }
+    m.options = options
+    return m, nil
+}
+
+func parseOptions(b []byte) ([]option, error) {
+    var options []option
+    for len(b) > 0 {
+        if len(b) < 4 {
+            return nil, fmt.Errorf("truncated option")
+        }
+        code, length := binary.BigEndian.Uint16(b[0:2]), int(binary.BigEndian.Uint16(b[2:4]))
+        if len(b) < 4+length {
+            return nil, fmt.Errorf("option %d overruns the message", code)
+        }
+        options = append(options, option{code, b[4 : 4+length]})
+        b = b[4+length:]
+    }
+    return options, nil
+}
+
+// duidLL is a DUID-LL (type 3) for an ethernet address
+func duidLL(mac net.HardwareAddr) []byte {
+    return append([]byte{0, 3, 0, 1}, mac...)
+}
+
+// ia encodes an IA_NA or IA_PD with the given sub-options
+func ia(iaid, t1, t2 uint32, sub []option) []byte {
+    b := binary.BigEndian.AppendUint32(nil, iaid)
+    b = binary.BigEndian.AppendUint32(b, t1)
+    b = binary.BigEndian.AppendUint32(b, t2)
+    return append(b, marshalOptions(sub)...)
+}
+
+func iaAddr(a Address) []byte {
+    b := append([]byte{}, a.IP.To16()...)
+    b = binary.BigEndian.AppendUint32(b, a.Preferred)
+    return binary.BigEndian.AppendUint32(b, a.Valid)
+}
+
+func iaPrefix(p Prefix) []byte {
+    b := binary.BigEndian.AppendUint32(nil, p.Preferred)
+    b = binary.BigEndian.AppendUint32(b, p.Valid)
+    ones, _ := p.Prefix.Mask.Size()
+    b = append(b, byte(ones))
+    return append(b, p.Prefix.IP.To16()...)
+}
+
+// status returns the status code option of the options, success when
+// there is none
+func status(options []option) (uint16, string) {
+    for _, o := range options {
+        if o.code == optStatusCode && len(o.data) >= 2 {
+            return binary.BigEndian.Uint16(o.data), string(o.data[2:])
+        }
+    }
+    return statusSuccess, ""
+}
+
+// parseIA decodes the T1, T2 and sub-options of an IA_NA or IA_PD
+func parseIA(data []byte) (iaid, t1, t2 uint32, sub []option, err error) {
+    if len(data) < 12 {
+        return 0, 0, 0, nil, fmt.Errorf("short IA option")
+    }
+    sub, err = parseOptions(data[12:])
+    return binary.BigEndian.Uint32(data[0:4]), binary.BigEndian.Uint32(data[4:8]), binary.BigEndian.Uint32(data[8:12]), sub, err
+}
+
+// parseIPs decodes a list of addresses, such as the DNS servers option
+func parseIPs(data []byte) []net.IP {
+    var ips []net.IP
+    for len(data) >= net.IPv6len {
+        ips = append(ips, net.IP(append([]byte{}, data[:net.IPv6len]...)))
+        data = data[net.IPv6len:]
+    }
+    return ips
+}
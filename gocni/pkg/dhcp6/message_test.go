@@ -0,0 +1,107 @@
+package dhcp6
+
+import (
+    "encoding/binary"
+    "net"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/containernetworking/cni/pkg/types"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+    c := &Client{clientID: duidLL(net.HardwareAddr{2, 0, 0, 0, 0, 1}), iaid: 7}
+    m := &message{msgType: msgRequest, xid: [3]byte{1, 2, 3}}
+    m.add(optClientID, c.clientID)
+    m.add(optServerID, []byte{0, 3, 0, 1, 2, 0, 0, 0, 0, 2})
+    m.add(optRapidCommit, nil)
+    l := &Lease{
+        Addresses: []Address{{IP: net.ParseIP("2001:db8::10"), Preferred: 300, Valid: 600}},
+        Prefixes:  []Prefix{{Prefix: types.IPNet{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(56, 128)}, Preferred: 300, Valid: 600}},
+    }
+    c.addIAs(m, leaseOptions(l), l)
+    
+    got, err := parseMessage(m.marshal())
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := range got.options {
+        if len(got.options[i].data) == 0 {
+            got.options[i].data = nil
+        }
+    }
+    if !reflect.DeepEqual(got, m) {
+        t.Errorf("round trip changed the message\n got %+v\nwant %+v", got, m)
+    }
+    
+    // A Reply carrying the same IAs is the lease asked for
+    reply := &message{msgType: msgReply, xid: m.xid, options: got.options}
+    lease, err := c.lease(reply, leaseOptions(l))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(lease.Addresses, l.Addresses) {
+        t.Errorf("addresses = %v, want %v", lease.Addresses, l.Addresses)
+    }
+    if len(lease.Prefixes) != 1 || (*net.IPNet)(&lease.Prefixes[0].Prefix).String() != "2001:db8:1::/56" {
+        t.Errorf("prefixes = %v, want 2001:db8:1::/56", lease.Prefixes)
+    }
+}
+
+func TestParseMalformed(t *testing.T) {
+    tests := []struct {
+        name string
+        data []byte
+        err  string
+    }{
+        {"short header", []byte{msgReply, 0, 0}, "short message"},
+        {"truncated option header", []byte{msgReply, 0, 0, 0, 0, 1, 0}, "truncated option"},
+        {"option overruns", []byte{msgReply, 0, 0, 0, 0, 1, 0, 8, 1, 2}, "overruns"},
+    }
+    for _, tt := range tests {
+        _, err := parseMessage(tt.data)
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+        }
+    }
+    
+    if _, _, _, _, err := parseIA(make([]byte, 11)); err == nil {
+        t.Error("short IA parsed")
+    }
+    if _, _, _, _, err := parseIA(append(make([]byte, 12), 0, 5, 0, 30)); err == nil {
+        t.Error("IA with an overrunning sub-option parsed")
+    }
+}
+
+func TestLeaseMalformed(t *testing.T) {
+    c := &Client{clientID: duidLL(net.HardwareAddr{2, 0, 0, 0, 0, 1}), iaid: 7}
+    serverID := option{optServerID, []byte{0, 3, 0, 1, 2, 0, 0, 0, 0, 2}}
+    prefix := func(length byte) option {
+        b := binary.BigEndian.AppendUint32(nil, 300)
+        b = binary.BigEndian.AppendUint32(b, 600)
+        b = append(b, length)
+        return option{optIAPrefix, append(b, net.ParseIP("2001:db8:1::")...)}
+    }
+    
+    tests := []struct {
+        name    string
+        options []option
+        opts    Options
+        err     string
+    }{
+        {"no server ID", []option{{optIAPD, ia(7, 0, 0, []option{prefix(56)})}}, Options{PrefixDelegation: true}, "server identifier"},
+        {"prefix length over 128", []option{serverID, {optIAPD, ia(7, 0, 0, []option{prefix(129)})}}, Options{PrefixDelegation: true}, "delegated no prefix"},
+        {"short prefix option", []option{serverID, {optIAPD, ia(7, 0, 0, []option{{optIAPrefix, prefix(56).data[:24]}})}}, Options{PrefixDelegation: true}, "delegated no prefix"},
+        {"short address option", []option{serverID, {optIANA, ia(7, 0, 0, []option{{optIAAddr, make([]byte, 23)}})}}, Options{Address: true}, "offered no address"},
+        {"other IAID", []option{serverID, {optIAPD, ia(8, 0, 0, []option{prefix(56)})}}, Options{PrefixDelegation: true}, "delegated no prefix"},
+        {"refused", []option{serverID, {optStatusCode, append([]byte{0, 2}, "NoAddrsAvail"...)}}, Options{Address: true}, "status 2 NoAddrsAvail"},
+        {"IA refused", []option{serverID, {optIANA, ia(7, 0, 0, []option{{optStatusCode, []byte{0, 2}}})}}, Options{Address: true}, "refused the IA"},
+    }
+    for _, tt := range tests {
+        _, err := c.lease(&message{msgType: msgReply, options: tt.options}, tt.opts)
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+        }
+    }
+}
@@ -0,0 +1,108 @@
+// Package ethtool reads and sets the ring sizes and queue counts of an
+// interface through the SIOCETHTOOL ioctl, in the calling thread's network
+// namespace. Failed ioctls return their errno, EOPNOTSUPP for devices without
+// rings of their own.
+package ethtool
+
+import (
+    "fmt"
+    "syscall"
+    "unsafe"
+)
+
+const siocEthtool = 0x8946
+
+// ethtool commands, from linux/ethtool.h
+const (
+    cmdGetRingParam = 0x10
+    cmdSetRingParam = 0x11
+    cmdGetChannels  = 0x3c
+    cmdSetChannels  = 0x3d
+)
+
+// Rings is struct ethtool_ringparam
+type Rings struct {
+    cmd        uint32
+    RxMax      uint32
+    RxMiniMax  uint32
+    RxJumboMax uint32
+    TxMax      uint32
+    Rx         uint32
+    RxMini     uint32
+    RxJumbo    uint32
+    Tx         uint32
+}
+
+// Channels is struct ethtool_channels
+type Channels struct {
+    cmd         uint32
+    RxMax       uint32
+    TxMax       uint32
+    OtherMax    uint32
+    CombinedMax uint32
+    Rx          uint32
+    Tx          uint32
+    Other       uint32
+    Combined    uint32
+}
+
+// ifreq is struct ifreq with ifr_data
+type ifreq struct {
+    name [syscall.IFNAMSIZ]byte
+    data unsafe.Pointer
+    _    [16]byte
+}
+
+// GetRings returns the ring sizes of the interface and their maximums
+func GetRings(ifName string) (*Rings, error) {
+    r := &Rings{cmd: cmdGetRingParam}
+    if err := ioctl(ifName, unsafe.Pointer(r)); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+// SetRings sets the ring sizes of r
+func SetRings(ifName string, r *Rings) error {
+    r.cmd = cmdSetRingParam
+    if err := ioctl(ifName, unsafe.Pointer(r)); err != nil {
+        return err
+    }
+    return nil
+}
+
+// GetChannels returns the queue counts of the interface and their maximums
+func GetChannels(ifName string) (*Channels, error) {
+    c := &Channels{cmd: cmdGetChannels}
+    if err := ioctl(ifName, unsafe.Pointer(c)); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// SetChannels sets the queue counts of c
+func SetChannels(ifName string, c *Channels) error {
+    c.cmd = cmdSetChannels
+    if err := ioctl(ifName, unsafe.Pointer(c)); err != nil {
+        return err
+    }
+    return nil
+}
+
+func ioctl(ifName string, data unsafe.Pointer) error {
+    if len(ifName) >= syscall.IFNAMSIZ {
+        return fmt.Errorf("interface name %q too long", ifName)
+    }
+    fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+    if err != nil {
+        return err
+    }
+    defer syscall.Close(fd)
+    
+    req := &ifreq{data: data}
+    copy(req.name[:], ifName)
+    if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(req))); errno != 0 {
+        return errno
+    }
+    return nil
+}
@@ -0,0 +1,131 @@
+// Package features gates subsystems of the node daemon by maturity, so
+// operators opt into risky ones node by node. Alpha gates are off unless
+// turned on, beta gates are on unless turned off, and GA gates are always
+// on. Deprecated gates still work but warn, ahead of their removal.
+package features
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Stage is the maturity of a gate
+type Stage string
+
+// Stages
+const (
+    Alpha      Stage = "alpha"
+    Beta       Stage = "beta"
+    GA         Stage = "ga"
+    Deprecated Stage = "deprecated"
+)
+
+// Gate names
+const (
+    DaemonMode     = "DaemonMode"
+    BGP            = "BGP"
+    WarmPools      = "WarmPools"
+    Sites          = "Sites"
+    FloatingIPs    = "FloatingIPs"
+    LoadBalancers  = "LoadBalancers"
+    MetricsAdapter = "MetricsAdapter"
+    LinkCache      = "LinkCache"
+    DelQueue       = "DelQueue"
+    UpstreamKeys   = "UpstreamKeys"
+)
+
+// Gate is a subsystem that can be turned on and off per node
+type Gate struct {
+    Name        string
+    Stage       Stage
+    Description string
+}
+
+// Default reports whether the gate is on when nothing sets it
+func (g Gate) Default() bool {
+    return g.Stage != Alpha
+}
+
+var gates = map[string]Gate{
+    DaemonMode:     {DaemonMode, Beta, "the plugin handing work to the daemon, which LinkCache and DelQueue need"},
+    BGP:            {BGP, Beta, "BGP speaker announcing pod addresses"},
+    WarmPools:      {WarmPools, Beta, "links pre-created for ADD to take"},
+    Sites:          {Sites, Beta, "VLANs stretched to other sites over IPsec"},
+    FloatingIPs:    {FloatingIPs, Beta, "addresses moved between nodes by health and Lease"},
+    LoadBalancers:  {LoadBalancers, Beta, "ARP and NDP answers for LoadBalancer addresses on VLANs"},
+    MetricsAdapter: {MetricsAdapter, Beta, "custom.metrics.k8s.io served from pod throughput"},
+    LinkCache:      {LinkCache, Alpha, "master lookups served from the daemon's netlink cache"},
+    DelQueue:       {DelQueue, Alpha, "DEL phases queued with bounded concurrency"},
+    UpstreamKeys:   {UpstreamKeys, Deprecated, "upstream vlan plugin keys such as vlanId in network configuration"},
+}
+
+// Gates returns the known gates by name
+func Gates() []Gate {
+    list := make([]Gate, 0, len(gates))
+    for _, g := range gates {
+        list = append(list, g)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    return list
+}
+
+// Set is the state of every gate on a node
+type Set struct {
+    enabled map[string]bool
+}
+
+// New applies overrides to the gates' defaults. Unknown gates and turning
+// off GA ones are errors; warnings name the deprecated gates left on.
+func New(overrides map[string]bool) (*Set, []string, error) {
+    s := &Set{enabled: map[string]bool{}}
+    for name, g := range gates {
+        s.enabled[name] = g.Default()
+    }
+    for name, on := range overrides {
+        g, ok := gates[name]
+        switch {
+        case !ok:
+            return nil, nil, fmt.Errorf("unknown feature gate %q", name)
+        case g.Stage == GA && !on:
+            return nil, nil, fmt.Errorf("feature gate %s is GA and cannot be turned off", name)
+        }
+        s.enabled[name] = on
+    }
+    
+    var warnings []string
+    for _, g := range Gates() {
+        if g.Stage == Deprecated && s.enabled[g.Name] {
+            warnings = append(warnings, fmt.Sprintf("feature gate %s is deprecated and will be removed", g.Name))
+        }
+    }
+    return s, warnings, nil
+}
+
+// Enabled reports whether the gate is on. Unknown gates are off.
+func (s *Set) Enabled(name string) bool {
+    return s.enabled[name]
+}
+
+// ParseFlag parses gates given as Name=bool pairs separated by commas, as
+// in BGP=false,LinkCache=true
+func ParseFlag(value string) (map[string]bool, error) {
+    overrides := map[string]bool{}
+    for _, pair := range strings.Split(value, ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" {
+            continue
+        }
+        name, v, ok := strings.Cut(pair, "=")
+        if !ok {
+            return nil, fmt.Errorf("feature gate %q has no value", pair)
+        }
+        on, err := strconv.ParseBool(v)
+        if err != nil {
+            return nil, fmt.Errorf("feature gate %s: invalid value %q", name, v)
+        }
+        overrides[strings.TrimSpace(name)] = on
+    }
+    return overrides, nil
+}
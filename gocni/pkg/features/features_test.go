@@ -0,0 +1,71 @@
+package features
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestNew(t *testing.T) {
+    // No gate has graduated yet
+    gates["TestGA"] = Gate{"TestGA", GA, "test gate"}
+    defer delete(gates, "TestGA")
+    
+    tests := []struct {
+        name      string
+        overrides map[string]bool
+        enabled   map[string]bool
+        warnings  int
+        ok        bool
+    }{
+        {"defaults", nil, map[string]bool{BGP: true, LinkCache: false, UpstreamKeys: true, "TestGA": true}, 1, true},
+        {"alpha on", map[string]bool{LinkCache: true}, map[string]bool{LinkCache: true, DelQueue: false}, 1, true},
+        {"beta off", map[string]bool{BGP: false}, map[string]bool{BGP: false, Sites: true}, 1, true},
+        {"deprecated off", map[string]bool{UpstreamKeys: false}, map[string]bool{UpstreamKeys: false}, 0, true},
+        {"ga on", map[string]bool{"TestGA": true}, map[string]bool{"TestGA": true}, 1, true},
+        {"ga off", map[string]bool{"TestGA": false}, nil, 0, false},
+        {"unknown", map[string]bool{"Nope": true}, nil, 0, false},
+    }
+    for _, tt := range tests {
+        s, warnings, err := New(tt.overrides)
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: got error %v, want ok %v", tt.name, err, tt.ok)
+            continue
+        }
+        if err != nil {
+            continue
+        }
+        for name, want := range tt.enabled {
+            if got := s.Enabled(name); got != want {
+                t.Errorf("%s: %s enabled %v, want %v", tt.name, name, got, want)
+            }
+        }
+        if len(warnings) != tt.warnings {
+            t.Errorf("%s: got warnings %q, want %d", tt.name, warnings, tt.warnings)
+        }
+    }
+}
+
+func TestParseFlag(t *testing.T) {
+    tests := []struct {
+        value string
+        want  map[string]bool
+        ok    bool
+    }{
+        {"", map[string]bool{}, true},
+        {"BGP=false", map[string]bool{BGP: false}, true},
+        {"BGP=false, LinkCache=true,", map[string]bool{BGP: false, LinkCache: true}, true},
+        {"LinkCache=1,DelQueue=t", map[string]bool{LinkCache: true, DelQueue: true}, true},
+        {"BGP", nil, false},
+        {"BGP=maybe", nil, false},
+    }
+    for _, tt := range tests {
+        got, err := ParseFlag(tt.value)
+        if (err == nil) != tt.ok {
+            t.Errorf("%q: got error %v, want ok %v", tt.value, err, tt.ok)
+            continue
+        }
+        if err == nil && !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%q: got %v, want %v", tt.value, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,96 @@
+package hooks
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os/exec"
+    "time"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/secrets"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// defaultTimeout applies to hooks that do not set timeoutSeconds
+const defaultTimeout = 10 * time.Second
+
+// Payload is the JSON document handed to hooks on stdin or as the POST body
+type Payload struct {
+    Event      string            `json:"event"`
+    Attachment *state.Attachment `json:"attachment"`
+    Result     *current.Result   `json:"result,omitempty"`
+}
+
+// Run invokes every hook registered for event. Hooks with the "fail" policy
+// abort on error; others are best effort. Each hook's timeout is bounded
+// by ctx. Bearer tokens read from Secrets are cached in store.
+func Run(ctx context.Context, hooks []config.HookConfig, store *state.Store, event string, a *state.Attachment, result *current.Result) error {
+    payload, err := json.Marshal(&Payload{Event: event, Attachment: a, Result: result})
+    if err != nil {
+        return fmt.Errorf("failed to encode %s hook payload: %v", event, err)
+    }
+    
+    for i := range hooks {
+        h := &hooks[i]
+        if h.Event != event {
+            continue
+        }
+        if err := run(ctx, h, store, payload); err != nil && h.FailurePolicy == config.HookFailurePolicyFail {
+            return fmt.Errorf("%s hook %q failed: %v", event, h.Name, err)
+        }
+    }
+    return nil
+}
+
+func run(ctx context.Context, h *config.HookConfig, store *state.Store, payload []byte) error {
+    timeout := defaultTimeout
+    if h.TimeoutSeconds > 0 {
+        timeout = time.Duration(h.TimeoutSeconds) * time.Second
+    }
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+    
+    if len(h.Exec) > 0 {
+        cmd := exec.CommandContext(ctx, h.Exec[0], h.Exec[1:]...)
+        cmd.Stdin = bytes.NewReader(payload)
+        if out, err := cmd.CombinedOutput(); err != nil {
+            return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+        }
+        return nil
+    }
+    
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if h.BearerTokenFrom != nil {
+        token, err := secrets.Resolve(ctx, h.BearerTokenFrom, store)
+        if err != nil {
+            return err
+        }
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    
+    tlsConfig, err := h.TLS.ClientConfig(false)
+    if err != nil {
+        return err
+    }
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = tlsConfig
+    
+    resp, err := (&http.Client{Transport: transport}).Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned %s", resp.Status)
+    }
+    return nil
+}
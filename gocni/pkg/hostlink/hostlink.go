@@ -0,0 +1,64 @@
+package hostlink
+
+import (
+    "strings"
+    "time"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// New builds the host-side link for an attachment. VLAN ID 0 means untagged:
+// the pod is attached directly to the master via macvlan or ipvlan. An
+// isolated untagged link reaches the uplink but not its siblings.
+func New(master netlink.Link, vlanID int, untaggedMode string, mtu int, name string, isolated bool) netlink.Link {
+    attrs := netlink.LinkAttrs{
+        Name:        name,
+        ParentIndex: master.Attrs().Index,
+        MTU:         mtu,
+    }
+    
+    if vlanID != 0 {
+        return &netlink.Vlan{LinkAttrs: attrs, VlanId: vlanID}
+    }
+    
+    switch untaggedMode {
+    case config.UntaggedModeIPVlan:
+        flag := netlink.IPVLAN_FLAG_BRIDGE
+        if isolated {
+            flag = netlink.IPVLAN_FLAG_PRIVATE
+        }
+        return &netlink.IPVlan{LinkAttrs: attrs, Mode: netlink.IPVLAN_MODE_L2, Flag: flag}
+    default:
+        mode := netlink.MACVLAN_MODE_BRIDGE
+        if isolated {
+            mode = netlink.MACVLAN_MODE_PRIVATE
+        }
+        return &netlink.Macvlan{LinkAttrs: attrs, Mode: mode}
+    }
+}
+
+// aliasPrefix starts the alias of links the plugin creates in the host
+// namespace, followed by their creation time
+const aliasPrefix = "vlan-cni created="
+
+// Mark tags a link the plugin created with its creation time, which the
+// kernel keeps nowhere else, so the daemon can tell when one left behind
+// has gone stale. Links reused by a later ADD are marked anew.
+func Mark(link netlink.Link) error {
+    return netlink.LinkSetAlias(link, aliasPrefix+time.Now().UTC().Format(time.RFC3339))
+}
+
+// Created returns when Mark tagged the link, false for links it did not
+func Created(link netlink.Link) (time.Time, bool) {
+    alias := link.Attrs().Alias
+    if !strings.HasPrefix(alias, aliasPrefix) {
+        return time.Time{}, false
+    }
+    t, err := time.Parse(time.RFC3339, strings.TrimPrefix(alias, aliasPrefix))
+    if err != nil {
+        return time.Time{}, false
+    }
+    return t, true
+}
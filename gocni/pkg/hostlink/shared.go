@@ -0,0 +1,130 @@
+package hostlink
+
+import (
+    "errors"
+    "fmt"
+    "syscall"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// sharedAlias tags the VLAN devices SharedVlan created, which
+// ReleaseSharedVlan may remove again
+const sharedAlias = "vlan-cni shared"
+
+// SharedVlan returns the host's shared device of the VLAN on master,
+// creating it when there is none. The kernel allows one device per VLAN ID
+// on a master, so daemon features hang links of their own off it, and once
+// it exists ADD hangs pods' macvlans off it too, see SharedParent. A pod
+// that took the VLAN ID first holds it until it goes, SharedVlan failing in
+// the meantime. The device is named apart from the plugin's host links, so
+// ADD never mistakes it for a pod's.
+func SharedVlan(master netlink.Link, vlanID int) (netlink.Link, error) {
+    link, err := findVlan(master, vlanID)
+    if err != nil {
+        return nil, err
+    }
+    if link != nil {
+        if !IsShared(link) {
+            return nil, fmt.Errorf("VLAN %d of %q is held by %q", vlanID, master.Attrs().Name, link.Attrs().Name)
+        }
+        return link, nil
+    }
+    
+    name := sharedName(master, vlanID)
+    vlan := New(master, vlanID, "", 0, name, false)
+    if err := netlink.LinkAdd(vlan); errors.Is(err, syscall.EEXIST) {
+        // The VLAN's device is not in the host namespace, so a pod has it
+        return nil, fmt.Errorf("VLAN %d of %q is held by a pod's interface", vlanID, master.Attrs().Name)
+    } else if err != nil {
+        return nil, fmt.Errorf("failed to add VLAN %d of %q: %v", vlanID, master.Attrs().Name, err)
+    }
+    link, err = netlink.LinkByName(name)
+    if err != nil {
+        return nil, fmt.Errorf("failed to look up %q: %v", name, err)
+    }
+    if err := netlink.LinkSetAlias(link, sharedAlias); err != nil {
+        return nil, fmt.Errorf("failed to tag %q: %v", name, err)
+    }
+    if err := netlink.LinkSetUp(link); err != nil {
+        return nil, fmt.Errorf("failed to set %q up: %v", name, err)
+    }
+    return link, nil
+}
+
+// SharedParent returns the shared device of the VLAN on master for a pod's
+// link to hang off, nil if there is none. A site bridges the device, which
+// leaves no room for pods.
+func SharedParent(master netlink.Link, vlanID int) (netlink.Link, error) {
+    link, err := findVlan(master, vlanID)
+    if link == nil || err != nil || !IsShared(link) {
+        return nil, err
+    }
+    if link.Attrs().MasterIndex != 0 {
+        return nil, fmt.Errorf("VLAN %d of %q is bridged to a site, which leaves no room for pods", vlanID, master.Attrs().Name)
+    }
+    return link, nil
+}
+
+// IsShared reports whether SharedVlan created the link
+func IsShared(link netlink.Link) bool {
+    return link.Attrs().Alias == sharedAlias
+}
+
+// ReleaseSharedVlan removes the VLAN's shared device once nothing uses it.
+// Pods' links off it live in their namespaces, out of sight, so it stays
+// while the store has attachments on the VLAN.
+func ReleaseSharedVlan(master netlink.Link, vlanID int, store *state.Store) error {
+    link, err := findVlan(master, vlanID)
+    if link == nil || err != nil {
+        return err
+    }
+    if !IsShared(link) || link.Attrs().MasterIndex != 0 {
+        return nil
+    }
+    links, err := netlink.LinkList()
+    if err != nil {
+        return fmt.Errorf("failed to list links: %v", err)
+    }
+    for _, l := range links {
+        if l.Attrs().ParentIndex == link.Attrs().Index {
+            return nil
+        }
+    }
+    attachments, err := store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    for _, a := range attachments {
+        if a.Master == master.Attrs().Name && a.VlanID == vlanID {
+            return nil
+        }
+    }
+    if err := netlink.LinkDel(link); err != nil {
+        return fmt.Errorf("failed to remove %q: %v", link.Attrs().Name, err)
+    }
+    return nil
+}
+
+// sharedName names the shared device by the master's index, which keeps it
+// within IFNAMSIZ and clear of "<master>.<vlan>", the host link default
+func sharedName(master netlink.Link, vlanID int) string {
+    return fmt.Sprintf("vcs%d.%d", master.Attrs().Index, vlanID)
+}
+
+// findVlan returns the host's device of the VLAN on master, nil if there
+// is none
+func findVlan(master netlink.Link, vlanID int) (netlink.Link, error) {
+    links, err := netlink.LinkList()
+    if err != nil {
+        return nil, fmt.Errorf("failed to list links: %v", err)
+    }
+    for _, l := range links {
+        if v, ok := l.(*netlink.Vlan); ok && v.ParentIndex == master.Attrs().Index && v.VlanId == vlanID {
+            return v, nil
+        }
+    }
+    return nil, nil
+}
@@ -0,0 +1,288 @@
+package install
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+var nadGVR = schema.GroupVersionResource{
+    Group:    "k8s.cni.cncf.io",
+    Version:  "v1",
+    Resource: "network-attachment-definitions",
+}
+
+// Segment is one network's use of a VLAN on a master
+type Segment struct {
+    Master  string
+    VlanID  int
+    Network string
+
+    // File path, or namespace/name of a NetworkAttachmentDefinition
+    Source string
+
+    // The IPAM type and subnets, which is what has to agree between uses
+    // of a VLAN; options such as routes or dataDir may differ
+    IPAM    string
+    Subnets []string
+}
+
+// Duplicate is a VLAN of a master that networks address differently,
+// which shows as flapping interfaces and addresses handed out twice
+type Duplicate struct {
+    Master   string
+    VlanID   int
+    Segments []*Segment
+}
+
+func (d *Duplicate) Error() string {
+    var uses []string
+    for _, s := range d.Segments {
+        subnets := strings.Join(s.Subnets, ",")
+        if subnets == "" {
+            subnets = "no subnets"
+        }
+        uses = append(uses, fmt.Sprintf("network %q of %s (%s)", s.Network, s.Source, subnets))
+    }
+    return fmt.Sprintf("VLAN %d on %s is used with different IPAM by %s", d.VlanID, d.Master, strings.Join(uses, " and "))
+}
+
+// FileSegments reads the VLANs a conf or conflist file uses, including
+// the networks directory of meta mode entries
+func FileSegments(path string) ([]*Segment, error) {
+    return fileSegments(path, map[string]bool{})
+}
+
+// fileSegments reads each file once, so networks directories that list
+// themselves or each other do not recurse forever
+func fileSegments(path string, visited map[string]bool) ([]*Segment, error) {
+    seen := path
+    if abs, err := filepath.Abs(path); err == nil {
+        seen = abs
+        if real, err := filepath.EvalSymlinks(abs); err == nil {
+            seen = real
+        }
+    }
+    if visited[seen] {
+        return nil, nil
+    }
+    visited[seen] = true
+    
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %s: %v", path, err)
+    }
+    name, plugins, err := vlanEntries(data)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+    }
+    var segments []*Segment
+    for _, p := range plugins {
+        if meta, ok := p["meta"].(map[string]interface{}); ok {
+            dir, _ := meta["networksDir"].(string)
+            if dir == "" {
+                dir = config.DefaultMetaNetworksDir
+            }
+            paths, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+            for _, path := range paths {
+                found, err := fileSegments(path, visited)
+                if err != nil {
+                    return nil, err
+                }
+                segments = append(segments, found...)
+            }
+            continue
+        }
+        segments = append(segments, entrySegments(path, name, p)...)
+    }
+    return segments, nil
+}
+
+// ConfigSegments reads the VLANs of a network configuration held
+// elsewhere, such as that of a NetworkAttachmentDefinition
+func ConfigSegments(source, name string, data []byte) ([]*Segment, error) {
+    docName, plugins, err := vlanEntries(data)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %v", source, err)
+    }
+    if docName != "" {
+        name = docName
+    }
+    var segments []*Segment
+    for _, p := range plugins {
+        segments = append(segments, entrySegments(source, name, p)...)
+    }
+    return segments, nil
+}
+
+// NADSegments reads the VLANs of every NetworkAttachmentDefinition, named
+// after the definition when their configuration carries no name
+func NADSegments(ctx context.Context, dyn dynamic.Interface) ([]*Segment, error) {
+    list, err := dyn.Resource(nadGVR).List(ctx, metav1.ListOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to list NetworkAttachmentDefinitions: %v", err)
+    }
+    var segments []*Segment
+    for i := range list.Items {
+        item := &list.Items[i]
+        raw, _, _ := unstructured.NestedString(item.Object, "spec", "config")
+        if raw == "" {
+            continue
+        }
+        found, err := ConfigSegments(item.GetNamespace()+"/"+item.GetName(), item.GetName(), []byte(raw))
+        if err != nil {
+            return nil, err
+        }
+        segments = append(segments, found...)
+    }
+    return segments, nil
+}
+
+// FindDuplicates returns the VLANs used by networks whose IPAM differs.
+// The same network defined in several places, as a file and a
+// NetworkAttachmentDefinition, is one use when its IPAM agrees.
+func FindDuplicates(segments []*Segment) []*Duplicate {
+    type key struct {
+        master string
+        vlan   int
+    }
+    byKey := map[key][]*Segment{}
+    for _, s := range segments {
+        if s.Master == "" {
+            continue
+        }
+        k := key{s.Master, s.VlanID}
+        byKey[k] = append(byKey[k], s)
+    }
+    
+    var found []*Duplicate
+    for k, uses := range byKey {
+        ipams := map[string]bool{}
+        for _, s := range uses {
+            ipams[s.IPAM] = true
+        }
+        if len(ipams) < 2 {
+            continue
+        }
+        sort.Slice(uses, func(i, j int) bool {
+            if uses[i].Network != uses[j].Network {
+                return uses[i].Network < uses[j].Network
+            }
+            return uses[i].Source < uses[j].Source
+        })
+        found = append(found, &Duplicate{Master: k.master, VlanID: k.vlan, Segments: uses})
+    }
+    sort.Slice(found, func(i, j int) bool {
+        if found[i].Master != found[j].Master {
+            return found[i].Master < found[j].Master
+        }
+        return found[i].VlanID < found[j].VlanID
+    })
+    return found
+}
+
+// entrySegments returns the VLANs of a plugin entry, one per attachment,
+// reading the upstream vlanId and the masters table as ParseConfig does.
+// Links that name no VLAN, which ParseConfig rejects, are left out.
+func entrySegments(source, name string, p map[string]interface{}) []*Segment {
+    masters, _ := p["masters"].(map[string]interface{})
+    links := []map[string]interface{}{p}
+    if attachments, ok := p["attachments"].([]interface{}); ok && len(attachments) > 0 {
+        links = nil
+        for _, a := range attachments {
+            if m, ok := a.(map[string]interface{}); ok {
+                links = append(links, m)
+            }
+        }
+    }
+    var segments []*Segment
+    for _, l := range links {
+        master, _ := l["master"].(string)
+        vlan, ok := l["vlan"].(float64)
+        if !ok {
+            if vlan, ok = l["vlanId"].(float64); !ok {
+                continue
+            }
+        }
+        if master == "" {
+            master = lookupMaster(masters, int(vlan))
+        }
+        s := &Segment{Master: master, VlanID: int(vlan), Network: name, Source: source}
+        if ipam, ok := l["ipam"].(map[string]interface{}); ok && len(ipam) > 0 {
+            ipamType, _ := ipam["type"].(string)
+            s.Subnets = ipamSubnets(ipam)
+            s.IPAM = ipamType + " " + strings.Join(s.Subnets, ",")
+        }
+        segments = append(segments, s)
+    }
+    return segments
+}
+
+// lookupMaster returns the master the masters table gives a VLAN, empty
+// when it gives none
+func lookupMaster(masters map[string]interface{}, vlanID int) string {
+    for key, master := range masters {
+        vlans, err := config.ParseVlanSet(key)
+        if err != nil {
+            continue
+        }
+        if m, ok := master.(string); ok && vlans.Contains(vlanID) {
+            return m
+        }
+    }
+    return ""
+}
+
+// ipamSubnets returns the subnets of host-local style IPAM sections, with
+// a single subnet or ranges
+func ipamSubnets(ipam map[string]interface{}) []string {
+    var subnets []string
+    if s, ok := ipam["subnet"].(string); ok {
+        subnets = append(subnets, s)
+    }
+    ranges, _ := ipam["ranges"].([]interface{})
+    for _, set := range ranges {
+        items, _ := set.([]interface{})
+        for _, r := range items {
+            if m, ok := r.(map[string]interface{}); ok {
+                if s, ok := m["subnet"].(string); ok {
+                    subnets = append(subnets, s)
+                }
+            }
+        }
+    }
+    sort.Strings(subnets)
+    return subnets
+}
+
+// vlanEntries returns the name of a conf or conflist document and its
+// entries of this plugin
+func vlanEntries(data []byte) (string, []map[string]interface{}, error) {
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return "", nil, err
+    }
+    name, _ := doc["name"].(string)
+    plugins := []interface{}{doc}
+    if list, ok := doc["plugins"].([]interface{}); ok {
+        plugins = list
+    }
+    var entries []map[string]interface{}
+    for _, p := range plugins {
+        if entry, ok := p.(map[string]interface{}); ok && entry["type"] == pluginType {
+            entries = append(entries, entry)
+        }
+    }
+    return name, entries, nil
+}
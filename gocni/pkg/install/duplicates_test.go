@@ -0,0 +1,61 @@
+package install
+
+import (
+    "testing"
+)
+
+func TestConfigSegments(t *testing.T) {
+    tests := []struct {
+        name   string
+        conf   string
+        master string
+        vlan   int
+    }{
+        {"vlan", `{"type": "vlan-cni", "master": "eth0", "vlan": 100}`, "eth0", 100},
+        {"upstream vlanId", `{"type": "vlan-cni", "master": "eth0", "vlanId": 100}`, "eth0", 100},
+        {"vlan over vlanId", `{"type": "vlan-cni", "master": "eth0", "vlan": 100, "vlanId": 100}`, "eth0", 100},
+        {"masters table", `{"type": "vlan-cni", "masters": {"1-99": "eth1", "100-199": "eth2"}, "vlan": 120}`, "eth2", 120},
+        {"masters table with vlanId", `{"type": "vlan-cni", "masters": {"100-199": "eth2"}, "vlanId": 150}`, "eth2", 150},
+        {"own master over table", `{"type": "vlan-cni", "master": "eth0", "masters": {"100-199": "eth2"}, "vlan": 120}`, "eth0", 120},
+        {"attachment from table", `{"type": "vlan-cni", "masters": {"300,310": "bond0"}, "attachments": [{"ifName": "net1", "vlan": 310}]}`, "bond0", 310},
+    }
+    for _, tt := range tests {
+        segments, err := ConfigSegments("test", "net", []byte(`{"cniVersion": "1.0.0", "name": "net", "plugins": [`+tt.conf+`]}`))
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        if len(segments) != 1 {
+            t.Fatalf("%s: got %d segments, want 1", tt.name, len(segments))
+        }
+        if s := segments[0]; s.Master != tt.master || s.VlanID != tt.vlan {
+            t.Errorf("%s: got %s VLAN %d, want %s VLAN %d", tt.name, s.Master, s.VlanID, tt.master, tt.vlan)
+        }
+    }
+}
+
+func TestFindDuplicatesMastersTable(t *testing.T) {
+    var segments []*Segment
+    for _, conf := range []string{
+        `{"cniVersion": "1.0.0", "name": "a", "type": "vlan-cni", "master": "eth2", "vlan": 120, "ipam": {"type": "host-local", "subnet": "10.0.0.0/24"}}`,
+        `{"cniVersion": "1.0.0", "name": "b", "type": "vlan-cni", "masters": {"100-199": "eth2"}, "vlanId": 120, "ipam": {"type": "host-local", "subnet": "10.1.0.0/24"}}`,
+    } {
+        found, err := ConfigSegments("test", "", []byte(conf))
+        if err != nil {
+            t.Fatal(err)
+        }
+        segments = append(segments, found...)
+    }
+    if d := FindDuplicates(segments); len(d) != 1 || d[0].Master != "eth2" || d[0].VlanID != 120 {
+        t.Errorf("got duplicates %v, want VLAN 120 on eth2", d)
+    }
+}
+
+func TestConfigSegmentsWithoutVlan(t *testing.T) {
+    segments, err := ConfigSegments("test", "", []byte(`{"cniVersion": "1.0.0", "name": "a", "type": "vlan-cni", "master": "eth0", "attachments": [{"ifName": "net1", "master": "eth0"}, {"ifName": "net2", "master": "eth0", "vlan": 0}]}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(segments) != 1 || segments[0].VlanID != 0 {
+        t.Errorf("got segments %v, want only net2's untagged attachment", segments)
+    }
+}
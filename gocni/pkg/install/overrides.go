@@ -0,0 +1,285 @@
+package install
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// Labels and annotations of override ConfigMaps
+const (
+    // DefaultOverridesSelector selects the ConfigMaps carrying overrides
+    DefaultOverridesSelector = "vlan-cni.io/overrides=true"
+
+    // PriorityAnnotation orders overrides, higher ones applied later and
+    // winning over lower ones; 0 by default
+    PriorityAnnotation = "vlan-cni.io/priority"
+)
+
+// OverridesKey is where a rendered conflist records the overrides applied
+// to it and their hashes. The runtime ignores unknown top-level keys.
+const OverridesKey = "vlan-cni.io/overrides"
+
+// pluginType is the type of this repository's plugin in a chain
+const pluginType = "vlan-cni"
+
+// overridable are the settings teams may contribute by plugin type, a
+// network's owner configuring everything else. Overrides of other plugin
+// types or keys are refused, since the plugins run as root on every node.
+var overridable = map[string]map[string]bool{
+    pluginType:  {"mtu": true},
+    "bandwidth": {"ingressRate": true, "ingressBurst": true, "egressRate": true, "egressBurst": true},
+    "firewall":  {"backend": true, "iptablesAdminChainName": true, "firewalldZone": true},
+}
+
+// Override is one network's settings from an override ConfigMap, per
+// plugin type of the chain. Its data has a key per network, named like
+// the network with an optional .json suffix, holding a JSON object such as
+//
+//   {"vlan-cni": {"mtu": 9000}, "bandwidth": {"ingressRate": 1000000000, "ingressBurst": 1000000}}
+//
+// Settings of plugins the chain lacks add the plugin at its end. Only the
+// plugins and keys of overridable may be set.
+type Override struct {
+    Source   string
+    Priority int
+    Network  string
+    Plugins  map[string]map[string]interface{}
+    Hash     string
+}
+
+// ListOverrides reads the override ConfigMaps in namespace matching
+// selector, ordered by priority, then namespace/name
+func ListOverrides(ctx context.Context, client kubernetes.Interface, namespace, selector string) ([]*Override, error) {
+    list, err := client.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+    if err != nil {
+        return nil, fmt.Errorf("failed to list override ConfigMaps: %v", err)
+    }
+    var overrides []*Override
+    for _, cm := range list.Items {
+        source := cm.Namespace + "/" + cm.Name
+        priority := 0
+        if v, ok := cm.Annotations[PriorityAnnotation]; ok {
+            if priority, err = strconv.Atoi(v); err != nil {
+                return nil, fmt.Errorf("ConfigMap %s: invalid %s %q", source, PriorityAnnotation, v)
+            }
+        }
+        for key, data := range cm.Data {
+            o := &Override{Source: source, Priority: priority, Network: strings.TrimSuffix(key, ".json")}
+            if err := json.Unmarshal([]byte(data), &o.Plugins); err != nil {
+                return nil, fmt.Errorf("ConfigMap %s: invalid overrides of %q: %v", source, o.Network, err)
+            }
+            // Marshalling sorts the keys, so equal settings hash alike
+            canonical, _ := json.Marshal(o.Plugins)
+            sum := sha256.Sum256(canonical)
+            o.Hash = hex.EncodeToString(sum[:])
+            overrides = append(overrides, o)
+        }
+    }
+    sort.SliceStable(overrides, func(i, j int) bool {
+        if overrides[i].Priority != overrides[j].Priority {
+            return overrides[i].Priority < overrides[j].Priority
+        }
+        if overrides[i].Source != overrides[j].Source {
+            return overrides[i].Source < overrides[j].Source
+        }
+        return overrides[i].Network < overrides[j].Network
+    })
+    return overrides, nil
+}
+
+// Merge records the overrides applied to a configuration
+type Merge struct {
+    Path    string
+    Network string
+    Applied []*Override
+    Hash    string
+    Changed bool
+}
+
+// String renders the merge for the installer log
+func (m *Merge) String() string {
+    if len(m.Applied) == 0 {
+        return fmt.Sprintf("%s: no overrides for network %q", m.Path, m.Network)
+    }
+    sources := make([]string, len(m.Applied))
+    for i, o := range m.Applied {
+        sources[i] = fmt.Sprintf("%s (priority %d)", o.Source, o.Priority)
+    }
+    state := "unchanged"
+    if m.Changed {
+        state = "rewritten"
+    }
+    return fmt.Sprintf("%s: merged %s into network %q, hash %s, %s", m.Path, strings.Join(sources, ", "), m.Network, m.Hash[:12], state)
+}
+
+// setting is who set a merged value, to tell conflicts from overrides
+type setting struct {
+    value    interface{}
+    source   string
+    priority int
+}
+
+// MergeOverrides merges the overrides of the file's network into its
+// plugin chain, in their order. Two overrides of the same priority setting
+// a value differently conflict, and nothing is written. The file, a conf
+// or conflist, is only rewritten when the result changes; a conf becomes a
+// conflist when an override adds a plugin.
+func MergeOverrides(path string, overrides []*Override) (*Merge, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %s: %v", path, err)
+    }
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+    }
+    network, _ := doc["name"].(string)
+    m := &Merge{Path: path, Network: network}
+    
+    var plugins []interface{}
+    list, isList := doc["plugins"].([]interface{})
+    if isList {
+        plugins = list
+    } else {
+        plugins = []interface{}{doc}
+    }
+    
+    set := map[string]setting{}
+    summary := map[string]string{}
+    for _, o := range overrides {
+        if o.Network != network {
+            continue
+        }
+        types := make([]string, 0, len(o.Plugins))
+        for t := range o.Plugins {
+            types = append(types, t)
+        }
+        sort.Strings(types)
+        for _, t := range types {
+            allowed, ok := overridable[t]
+            if !ok {
+                return nil, fmt.Errorf("%s: %s may not override plugin %s", path, o.Source, t)
+            }
+            for key := range o.Plugins[t] {
+                if !allowed[key] {
+                    return nil, fmt.Errorf("%s: %s may not override %s.%s", path, o.Source, t, key)
+                }
+            }
+            entry := findPlugin(plugins, t)
+            if entry == nil {
+                entry = map[string]interface{}{"type": t}
+                plugins = append(plugins, entry)
+            }
+            if err := mergeInto(entry, o.Plugins[t], t, o, set); err != nil {
+                return nil, fmt.Errorf("%s: %v", path, err)
+            }
+        }
+        m.Applied = append(m.Applied, o)
+        summary[o.Source] = o.Hash
+    }
+    
+    // Overrides that no longer parse would only fail at the next ADD
+    for _, p := range plugins {
+        entry := p.(map[string]interface{})
+        if entry["type"] != pluginType {
+            continue
+        }
+        raw, _ := json.Marshal(entry)
+        if err := json.Unmarshal(raw, &config.NetConf{}); err != nil {
+            return nil, fmt.Errorf("%s: overrides leave an invalid %s configuration: %v", path, pluginType, err)
+        }
+    }
+    
+    h := sha256.New()
+    for _, o := range m.Applied {
+        fmt.Fprintf(h, "%s %s\n", o.Source, o.Hash)
+    }
+    m.Hash = hex.EncodeToString(h.Sum(nil))
+    
+    if !isList && len(plugins) > 1 {
+        doc = asConflist(doc, plugins)
+    } else if isList {
+        doc["plugins"] = plugins
+    }
+    delete(doc, OverridesKey)
+    if len(m.Applied) > 0 {
+        doc[OverridesKey] = map[string]interface{}{"hash": m.Hash, "sources": summary}
+    }
+    
+    out, err := json.MarshalIndent(doc, "", "  ")
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode %s: %v", path, err)
+    }
+    out = append(out, '\n')
+    if string(out) == string(data) {
+        return m, nil
+    }
+    m.Changed = true
+    return m, writeFile(path, out)
+}
+
+// mergeInto merges src into dst, replacing values other than objects
+// and recording who set each
+func mergeInto(dst, src map[string]interface{}, prefix string, o *Override, set map[string]setting) error {
+    keys := make([]string, 0, len(src))
+    for k := range src {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        v := src[k]
+        path := prefix + "." + k
+        if obj, ok := v.(map[string]interface{}); ok {
+            sub, ok := dst[k].(map[string]interface{})
+            if !ok {
+                sub = map[string]interface{}{}
+                dst[k] = sub
+            }
+            if err := mergeInto(sub, obj, path, o, set); err != nil {
+                return err
+            }
+            continue
+        }
+        if prev, ok := set[path]; ok && prev.priority == o.Priority && !reflect.DeepEqual(prev.value, v) {
+            return fmt.Errorf("%s and %s both set %s; give one a higher %s", prev.source, o.Source, path, PriorityAnnotation)
+        }
+        set[path] = setting{value: v, source: o.Source, priority: o.Priority}
+        dst[k] = v
+    }
+    return nil
+}
+
+func findPlugin(plugins []interface{}, t string) map[string]interface{} {
+    for _, p := range plugins {
+        if entry, ok := p.(map[string]interface{}); ok && entry["type"] == t {
+            return entry
+        }
+    }
+    return nil
+}
+
+// asConflist turns a single plugin configuration into a list of plugins,
+// the list taking the network's name and version
+func asConflist(conf map[string]interface{}, plugins []interface{}) map[string]interface{} {
+    list := map[string]interface{}{"name": conf["name"]}
+    if v, ok := conf["cniVersion"]; ok {
+        list["cniVersion"] = v
+    }
+    delete(conf, "name")
+    delete(conf, "cniVersion")
+    list["plugins"] = plugins
+    return list
+}
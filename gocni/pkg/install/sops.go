@@ -0,0 +1,27 @@
+package install
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+)
+
+// CheckEncryption refuses a conflist encrypted with sops as a whole. The
+// runtime hands each plugin its own entry without the list's sops
+// metadata, so only .conf files, whose metadata reaches the plugin, can be
+// encrypted.
+func CheckEncryption(path string) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return fmt.Errorf("failed to read %s: %v", path, err)
+    }
+    var doc map[string]json.RawMessage
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return fmt.Errorf("failed to parse %s: %v", path, err)
+    }
+    _, isList := doc["plugins"]
+    if _, encrypted := doc["sops"]; isList && encrypted {
+        return fmt.Errorf("%s: sops-encrypted conflists are not supported, encrypt a .conf instead", path)
+    }
+    return nil
+}
@@ -0,0 +1,175 @@
+// Package install holds the node installer's work on CNI network
+// configuration files.
+package install
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "sort"
+    "strings"
+
+    "github.com/containernetworking/cni/pkg/invoke"
+    "github.com/containernetworking/cni/pkg/version"
+)
+
+// Negotiation records how a configuration's cniVersion was chosen
+type Negotiation struct {
+    Path    string
+    From    string
+    To      string
+    Runtime []string
+    Plugins map[string][]string
+    Mutual  []string
+}
+
+// String renders the negotiation for the installer log
+func (n *Negotiation) String() string {
+    types := make([]string, 0, len(n.Plugins))
+    for t := range n.Plugins {
+        types = append(types, t)
+    }
+    sort.Strings(types)
+    
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s: runtime supports [%s]", n.Path, strings.Join(n.Runtime, " "))
+    for _, t := range types {
+        fmt.Fprintf(&b, ", %s supports [%s]", t, strings.Join(n.Plugins[t], " "))
+    }
+    from := n.From
+    if from == "" {
+        from = "unset"
+    }
+    if n.From == n.To {
+        fmt.Fprintf(&b, "; keeping cniVersion %s", n.To)
+    } else {
+        fmt.Fprintf(&b, "; cniVersion %s -> %s", from, n.To)
+    }
+    return b.String()
+}
+
+// UpgradeVersion rewrites the cniVersion of a conf or conflist file to the
+// newest version the runtime and every plugin in it support. Plugins are
+// asked for their versions by running them with CNI_COMMAND=VERSION from
+// binDirs. The file is only written when the version changes.
+func UpgradeVersion(ctx context.Context, path string, binDirs, runtimeVersions []string) (*Negotiation, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %s: %v", path, err)
+    }
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+    }
+    
+    // A single plugin configuration is its own plugin list
+    plugins := []interface{}{doc}
+    if list, ok := doc["plugins"].([]interface{}); ok {
+        plugins = list
+    }
+    
+    n := &Negotiation{Path: path, Runtime: runtimeVersions, Plugins: map[string][]string{}}
+    n.From, _ = doc["cniVersion"].(string)
+    
+    mutual := runtimeVersions
+    for _, p := range plugins {
+        conf, ok := p.(map[string]interface{})
+        if !ok {
+            return nil, fmt.Errorf("%s: plugin entry is not an object", path)
+        }
+        pluginType, _ := conf["type"].(string)
+        if pluginType == "" {
+            return nil, fmt.Errorf("%s: plugin entry without a type", path)
+        }
+        if _, seen := n.Plugins[pluginType]; seen {
+            continue
+        }
+        
+        pluginPath, err := invoke.FindInPath(pluginType, binDirs)
+        if err != nil {
+            return nil, err
+        }
+        info, err := invoke.GetVersionInfo(ctx, pluginPath, nil)
+        if err != nil {
+            return nil, fmt.Errorf("failed to query %s for its CNI versions: %v", pluginType, err)
+        }
+        n.Plugins[pluginType] = info.SupportedVersions()
+        mutual = intersect(mutual, info.SupportedVersions())
+    }
+    n.Mutual = mutual
+    
+    n.To, err = newest(mutual)
+    if err != nil {
+        return nil, err
+    }
+    if n.To == "" {
+        n.To = n.From
+        return n, fmt.Errorf("%s: no CNI version is supported by the runtime and all plugins", path)
+    }
+    if n.To == n.From {
+        return n, nil
+    }
+    
+    // Plugins inherit the list's version; drop stale per-plugin ones
+    doc["cniVersion"] = n.To
+    if _, ok := doc["plugins"]; ok {
+        for _, p := range plugins {
+            delete(p.(map[string]interface{}), "cniVersion")
+        }
+    }
+    
+    out, err := json.MarshalIndent(doc, "", "  ")
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode %s: %v", path, err)
+    }
+    return n, writeFile(path, append(out, '\n'))
+}
+
+// writeFile replaces path with data, so the runtime never reads a partly
+// written configuration
+func writeFile(path string, data []byte) error {
+    tmp := path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return fmt.Errorf("failed to write %s: %v", path, err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("failed to replace %s: %v", path, err)
+    }
+    return nil
+}
+
+func intersect(a, b []string) []string {
+    in := map[string]bool{}
+    for _, v := range b {
+        in[v] = true
+    }
+    var both []string
+    for _, v := range a {
+        if in[v] {
+            both = append(both, v)
+        }
+    }
+    return both
+}
+
+// newest returns the highest version in versions, or "" when it is empty
+func newest(versions []string) (string, error) {
+    best := ""
+    for _, v := range versions {
+        if best == "" {
+            best = v
+            continue
+        }
+        gte, err := version.GreaterThanOrEqualTo(v, best)
+        if err != nil {
+            return "", err
+        }
+        if gte {
+            best = v
+        }
+    }
+    return best, nil
+}
@@ -0,0 +1,136 @@
+package ipam
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Circuit breaker defaults
+const (
+    DefaultBreakerFailureThreshold = 3
+    DefaultBreakerCooldown         = 30 * time.Second
+)
+
+// healthProbeTimeout bounds the health check run after a failed call
+const healthProbeTimeout = 2 * time.Second
+
+// breaker fails calls to a backend immediately while it keeps failing.
+// Its state lives in the plugin state store, as every CNI invocation is a
+// separate process.
+type breaker struct {
+    Driver
+    name      string
+    key       string
+    store     *state.Store
+    threshold int
+    cooldown  time.Duration
+}
+
+// WithBreaker wraps driver in a circuit breaker keyed by key. A call counts
+// as a failure when it runs out of time or when the backend fails its health
+// check afterwards, so errors such as an exhausted pool do not open the
+// breaker. A threshold below zero returns driver unchanged.
+func WithBreaker(driver Driver, name, key string, store *state.Store, threshold int, cooldown time.Duration) Driver {
+    if threshold < 0 {
+        return driver
+    }
+    if threshold == 0 {
+        threshold = DefaultBreakerFailureThreshold
+    }
+    if cooldown <= 0 {
+        cooldown = DefaultBreakerCooldown
+    }
+    return &breaker{Driver: driver, name: name, key: key, store: store, threshold: threshold, cooldown: cooldown}
+}
+
+func (b *breaker) Allocate(ctx context.Context, req *Request) (*current.Result, error) {
+    if err := b.admit(); err != nil {
+        return nil, err
+    }
+    result, err := b.Driver.Allocate(ctx, req)
+    return result, b.record(ctx, err)
+}
+
+func (b *breaker) Release(ctx context.Context, req *Request) error {
+    if err := b.admit(); err != nil {
+        return err
+    }
+    return b.record(ctx, b.Driver.Release(ctx, req))
+}
+
+func (b *breaker) Check(ctx context.Context, req *Request) error {
+    if err := b.admit(); err != nil {
+        return err
+    }
+    return b.record(ctx, b.Driver.Check(ctx, req))
+}
+
+// admit fails fast while the breaker is open. Once the cooldown has passed
+// calls go through again, and the first failure reopens it.
+func (b *breaker) admit() error {
+    st, err := b.store.GetBreaker(b.key)
+    if err != nil {
+        return err
+    }
+    if st.Failures >= b.threshold && time.Now().Before(st.OpenUntil) {
+        return types.NewError(types.ErrTryAgainLater,
+            fmt.Sprintf("IPAM backend %q is unavailable", b.name),
+            fmt.Sprintf("%d consecutive failures, retrying after %s", st.Failures, st.OpenUntil.Format(time.RFC3339)))
+    }
+    return nil
+}
+
+// record updates the breaker with the outcome of a call and returns the
+// call's error, reported as "try again later" when it ran out of time
+func (b *breaker) record(ctx context.Context, callErr error) error {
+    failed := false
+    if callErr != nil {
+        if ctx.Err() != nil {
+            failed = true
+            callErr = types.NewError(types.ErrTryAgainLater,
+                fmt.Sprintf("IPAM backend %q did not answer in time", b.name), callErr.Error())
+        } else {
+            probeCtx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+            failed = b.Driver.Health(probeCtx) != nil
+            cancel()
+        }
+    }
+    
+    if !failed {
+        // A call the backend answered only matters if it closes the breaker
+        if callErr != nil {
+            return callErr
+        }
+        if st, err := b.store.GetBreaker(b.key); err != nil || st.Failures == 0 {
+            return err
+        }
+    }
+    
+    if err := b.store.Lock(); err != nil {
+        return err
+    }
+    defer b.store.Unlock()
+    
+    st, err := b.store.GetBreaker(b.key)
+    if err != nil {
+        return err
+    }
+    if failed {
+        st.Failures++
+        if st.Failures >= b.threshold {
+            st.OpenUntil = time.Now().Add(b.cooldown)
+        }
+    } else {
+        st.Failures = 0
+    }
+    if err := b.store.SaveBreaker(b.key, st); err != nil {
+        return err
+    }
+    return callErr
+}
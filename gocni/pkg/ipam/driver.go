@@ -0,0 +1,86 @@
+package ipam
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "sort"
+    "sync"
+    "time"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Driver is an IPAM backend the plugin talks to in-process
+type Driver interface {
+    // Allocate returns the attachment's addresses, reusing an existing
+    // allocation for repeated calls
+    Allocate(ctx context.Context, req *Request) (*current.Result, error)
+    
+    // Release frees the attachment's addresses. Releasing an unknown
+    // attachment is not an error.
+    Release(ctx context.Context, req *Request) error
+    
+    // Check verifies the attachment still holds its allocation
+    Check(ctx context.Context, req *Request) error
+    
+    // Health reports whether the backend can serve allocations
+    Health(ctx context.Context) error
+}
+
+// Migrator is implemented by drivers whose allocations can be handed to
+// another pod ahead of its ADD, on any node
+type Migrator interface {
+    // Migrate releases the attachment's addresses and holds them for the
+    // pod named namespace/name until ttl passes
+    Migrate(ctx context.Context, req *Request, toPod string, ttl time.Duration) ([]net.IP, error)
+}
+
+// Factory builds a driver from the raw ipam section of the network config
+type Factory func(raw []byte, store *state.Store) (Driver, error)
+
+var (
+    driversMu sync.RWMutex
+    drivers   = map[string]Factory{}
+)
+
+// Register makes a driver selectable through ipam.type. It is meant to be
+// called from the driver package's init and panics on duplicate names.
+func Register(typeName string, factory Factory) {
+    driversMu.Lock()
+    defer driversMu.Unlock()
+    
+    if _, ok := drivers[typeName]; ok {
+        panic(fmt.Sprintf("ipam: driver %q registered twice", typeName))
+    }
+    drivers[typeName] = factory
+}
+
+// Registered returns the names of all registered drivers
+func Registered() []string {
+    driversMu.RLock()
+    defer driversMu.RUnlock()
+    
+    names := make([]string, 0, len(drivers))
+    for name := range drivers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// New builds the driver registered as typeName. It returns nil without an
+// error when no driver has that name, so callers can fall back to running
+// an IPAM plugin binary.
+func New(typeName string, raw []byte, store *state.Store) (Driver, error) {
+    driversMu.RLock()
+    factory, ok := drivers[typeName]
+    driversMu.RUnlock()
+    
+    if !ok {
+        return nil, nil
+    }
+    return factory(raw, store)
+}
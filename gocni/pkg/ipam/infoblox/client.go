@@ -0,0 +1,156 @@
+package infoblox
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+)
+
+// client is a minimal WAPI client
+type client struct {
+    base     string
+    username string
+    password string
+    http     *http.Client
+}
+
+func newClient(conf *Config) *client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = conf.tlsConfig
+    return &client{
+        base:     fmt.Sprintf("%s/wapi/v%s/", conf.URL, conf.WAPIVersion),
+        username: conf.Username,
+        password: conf.Password,
+        http:     &http.Client{Transport: transport, Timeout: conf.timeout()},
+    }
+}
+
+// extAttr is the WAPI representation of an extensible attribute value
+type extAttr struct {
+    Value string `json:"value"`
+}
+
+// fixedAddress is the subset of the fixedaddress object used here
+type fixedAddress struct {
+    Ref      string             `json:"_ref,omitempty"`
+    IPv4Addr string             `json:"ipv4addr"`
+    Comment  string             `json:"comment,omitempty"`
+    ExtAttrs map[string]extAttr `json:"extattrs,omitempty"`
+}
+
+// reserveNext reserves the next free address in network
+func (c *client) reserveNext(ctx context.Context, view, network, comment string, attrs map[string]extAttr) (*fixedAddress, error) {
+    body := map[string]interface{}{
+        "ipv4addr":     fmt.Sprintf("func:nextavailableip:%s,%s", network, view),
+        "network_view": view,
+        "match_client": "RESERVED",
+        "comment":      comment,
+        "extattrs":     attrs,
+    }
+    
+    fa := &fixedAddress{}
+    query := url.Values{"_return_fields": {"ipv4addr,extattrs"}}
+    if err := c.do(ctx, http.MethodPost, "fixedaddress?"+query.Encode(), body, fa); err != nil {
+        return nil, err
+    }
+    return fa, nil
+}
+
+// findReserved lists the fixed addresses of network in view whose
+// extensible attributes match attrs
+func (c *client) findReserved(ctx context.Context, view, network string, attrs map[string]string) ([]*fixedAddress, error) {
+    query := url.Values{
+        "network":        {network},
+        "network_view":   {view},
+        "_return_fields": {"ipv4addr,extattrs"},
+    }
+    for attr, value := range attrs {
+        query.Set("*"+attr, value)
+    }
+    var found []*fixedAddress
+    if err := c.do(ctx, http.MethodGet, "fixedaddress?"+query.Encode(), nil, &found); err != nil {
+        return nil, err
+    }
+    return found, nil
+}
+
+// lookupNetwork fails unless network exists in view
+func (c *client) lookupNetwork(ctx context.Context, view, network string) error {
+    query := url.Values{"network": {network}}
+    if view != "" {
+        query.Set("network_view", view)
+    }
+    var found []struct {
+        Ref string `json:"_ref"`
+    }
+    if err := c.do(ctx, http.MethodGet, "network?"+query.Encode(), nil, &found); err != nil {
+        return err
+    }
+    if len(found) == 0 {
+        return fmt.Errorf("infoblox: network %s not found", network)
+    }
+    return nil
+}
+
+// remove deletes an object by reference, treating a missing one as removed
+func (c *client) remove(ctx context.Context, ref string) error {
+    err := c.do(ctx, http.MethodDelete, ref, nil, nil)
+    if apiErr, ok := err.(*apiError); ok && apiErr.status == http.StatusNotFound {
+        return nil
+    }
+    return err
+}
+
+// apiError is a non-2xx WAPI response
+type apiError struct {
+    status int
+    body   string
+}
+
+func (e *apiError) Error() string {
+    return fmt.Sprintf("infoblox: WAPI returned %d: %s", e.status, e.body)
+}
+
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) error {
+    var data []byte
+    if body != nil {
+        var err error
+        if data, err = json.Marshal(body); err != nil {
+            return fmt.Errorf("infoblox: failed to encode request: %v", err)
+        }
+    }
+    
+    req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(data))
+    if err != nil {
+        return fmt.Errorf("infoblox: failed to build request: %v", err)
+    }
+    req.SetBasicAuth(c.username, c.password)
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return fmt.Errorf("infoblox: %s %s failed: %v", method, path, err)
+    }
+    defer resp.Body.Close()
+    
+    respData, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return fmt.Errorf("infoblox: failed to read response: %v", err)
+    }
+    if resp.StatusCode/100 != 2 {
+        return &apiError{status: resp.StatusCode, body: string(bytes.TrimSpace(respData))}
+    }
+    if out == nil || len(respData) == 0 {
+        return nil
+    }
+    if err := json.Unmarshal(respData, out); err != nil {
+        return fmt.Errorf("infoblox: failed to decode response: %v", err)
+    }
+    return nil
+}
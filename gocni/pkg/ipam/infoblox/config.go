@@ -0,0 +1,128 @@
+package infoblox
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "net"
+    "strings"
+    "time"
+
+    "example.com/vlan-cni/pkg/secrets"
+    "example.com/vlan-cni/pkg/state"
+    "example.com/vlan-cni/pkg/tlsconf"
+)
+
+// TypeName selects this backend as ipam.type
+const TypeName = "infoblox"
+
+// Config is the ipam section for the Infoblox backend
+type Config struct {
+    Type        string `json:"type"`
+    URL         string `json:"url"`
+    WAPIVersion string `json:"wapiVersion,omitempty"`
+    Username    string `json:"username"`
+    Password    string `json:"password,omitempty"`
+
+    // Reference to the password instead of carrying it inline
+    PasswordFrom *secrets.Ref `json:"passwordFrom,omitempty"`
+
+    // Network to allocate from and the network view it lives in
+    Network     string `json:"network"`
+    NetworkView string `json:"networkView,omitempty"`
+    Gateway     string `json:"gateway,omitempty"`
+
+    // Identity stamped on allocations as extensible attributes. The
+    // attribute definitions must exist in Infoblox.
+    ClusterName   string            `json:"clusterName,omitempty"`
+    ExtAttrPrefix string            `json:"extAttrPrefix,omitempty"`
+    ExtAttrs      map[string]string `json:"extAttrs,omitempty"`
+
+    // Reclaim allocations tagged with this cluster and node in the network
+    // that no longer belong to a local attachment, at most once per
+    // interval. It needs clusterName, and the node's name from the
+    // runtime's K8S_NODE_NAME or the network's nodeName.
+    Reclaim                bool `json:"reclaim,omitempty"`
+    ReclaimIntervalSeconds int  `json:"reclaimIntervalSeconds,omitempty"`
+
+    TimeoutSeconds     int  `json:"timeoutSeconds,omitempty"`
+    InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+    // Server verification and client certificates
+    TLS *tlsconf.Config `json:"tls,omitempty"`
+
+    tlsConfig *tls.Config
+}
+
+// ParseConfig decodes and validates the ipam section
+func ParseConfig(raw []byte) (*Config, error) {
+    conf := &Config{}
+    if err := json.Unmarshal(raw, conf); err != nil {
+        return nil, fmt.Errorf("infoblox: failed to parse ipam config: %v", err)
+    }
+    
+    if conf.URL == "" || conf.Username == "" {
+        return nil, fmt.Errorf("infoblox: url and username are required")
+    }
+    if conf.PasswordFrom != nil {
+        if err := conf.PasswordFrom.Validate(); err != nil {
+            return nil, fmt.Errorf("infoblox: passwordFrom: %v", err)
+        }
+    }
+    conf.URL = strings.TrimRight(conf.URL, "/")
+    if _, _, err := net.ParseCIDR(conf.Network); err != nil {
+        return nil, fmt.Errorf("infoblox: invalid network %q: %v", conf.Network, err)
+    }
+    if conf.Gateway != "" && net.ParseIP(conf.Gateway) == nil {
+        return nil, fmt.Errorf("infoblox: invalid gateway %q", conf.Gateway)
+    }
+    if conf.Reclaim && conf.ClusterName == "" {
+        return nil, fmt.Errorf("infoblox: reclaim needs clusterName to tell this cluster's reservations from others'")
+    }
+    
+    if conf.WAPIVersion == "" {
+        conf.WAPIVersion = "2.12"
+    }
+    if conf.NetworkView == "" {
+        conf.NetworkView = "default"
+    }
+    if conf.ExtAttrPrefix == "" {
+        conf.ExtAttrPrefix = "VLAN-CNI "
+    }
+    
+    tlsConfig, err := conf.TLS.ClientConfig(conf.InsecureSkipVerify)
+    if err != nil {
+        return nil, fmt.Errorf("infoblox: %v", err)
+    }
+    conf.tlsConfig = tlsConfig
+    
+    return conf, nil
+}
+
+func (c *Config) reclaimInterval() time.Duration {
+    if c.ReclaimIntervalSeconds > 0 {
+        return time.Duration(c.ReclaimIntervalSeconds) * time.Second
+    }
+    return 15 * time.Minute
+}
+
+func (c *Config) timeout() time.Duration {
+    if c.TimeoutSeconds > 0 {
+        return time.Duration(c.TimeoutSeconds) * time.Second
+    }
+    return 10 * time.Second
+}
+
+// resolveCredentials replaces the password reference with its value
+func (c *Config) resolveCredentials(store *state.Store) error {
+    if c.PasswordFrom == nil {
+        return nil
+    }
+    password, err := secrets.Resolve(context.Background(), c.PasswordFrom, store)
+    if err != nil {
+        return fmt.Errorf("infoblox: passwordFrom: %v", err)
+    }
+    c.Password = password
+    return nil
+}
@@ -0,0 +1,228 @@
+package infoblox
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/state"
+)
+
+const (
+    stateDir    = "infoblox"
+    reclaimFile = "infoblox/reclaim.json"
+)
+
+// Extensible attribute names, appended to the configured prefix
+const (
+    attrCluster   = "Cluster"
+    attrNode      = "Node"
+    attrPod       = "Pod"
+    attrContainer = "Attachment"
+)
+
+// allocation is what the node remembers about an address it reserved
+type allocation struct {
+    Ref     string `json:"ref"`
+    Address string `json:"address"`
+}
+
+type reclaimState struct {
+    Last time.Time `json:"last"`
+}
+
+// Allocator reserves addresses in an Infoblox network
+type Allocator struct {
+    conf   *Config
+    client *client
+    store  *state.Store
+}
+
+func init() {
+    ipam.Register(TypeName, func(raw []byte, store *state.Store) (ipam.Driver, error) {
+        conf, err := ParseConfig(raw)
+        if err != nil {
+            return nil, err
+        }
+        if err := conf.resolveCredentials(store); err != nil {
+            return nil, err
+        }
+        return New(conf, store), nil
+    })
+}
+
+// New returns an allocator for the given configuration
+func New(conf *Config, store *state.Store) *Allocator {
+    return &Allocator{conf: conf, client: newClient(conf), store: store}
+}
+
+// Allocate reserves an address tagged with the attachment identity.
+// Repeated calls for the same attachment return the same reservation.
+func (a *Allocator) Allocate(ctx context.Context, req *ipam.Request) (*current.Result, error) {
+    // Held from reclaim until the reservation is recorded, so a reclaim
+    // never finds a reservation the node has not written down yet
+    if err := a.store.Lock(); err != nil {
+        return nil, err
+    }
+    defer a.store.Unlock()
+    
+    if a.conf.Reclaim {
+        if req.GuessedNodeName {
+            return nil, fmt.Errorf("infoblox: reclaim needs the node's name, from the runtime's K8S_NODE_NAME or the network's nodeName")
+        }
+        a.reclaim(ctx, req.NodeName)
+    }
+    
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req.Key()), alloc); err != nil {
+        return nil, err
+    }
+    
+    if alloc.Ref == "" {
+        fa, err := a.client.reserveNext(ctx, a.conf.NetworkView, a.conf.Network, comment(req), a.attrs(req))
+        if err != nil {
+            return nil, err
+        }
+        alloc = &allocation{Ref: fa.Ref, Address: fa.IPv4Addr}
+        
+        if err := a.store.Save(allocationName(req.Key()), alloc); err != nil {
+            _ = a.client.remove(ctx, alloc.Ref)
+            return nil, err
+        }
+    }
+    
+    return a.result(alloc)
+}
+
+// Release removes the attachment's reservation
+func (a *Allocator) Release(ctx context.Context, req *ipam.Request) error {
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req.Key()), alloc); err != nil {
+        return err
+    }
+    if alloc.Ref == "" {
+        return nil
+    }
+    
+    if err := a.client.remove(ctx, alloc.Ref); err != nil {
+        return err
+    }
+    return a.store.Remove(allocationName(req.Key()))
+}
+
+// Check verifies the node still records a reservation for the attachment
+func (a *Allocator) Check(ctx context.Context, req *ipam.Request) error {
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req.Key()), alloc); err != nil {
+        return err
+    }
+    if alloc.Ref == "" {
+        return fmt.Errorf("infoblox: no reservation for %s", req.Key())
+    }
+    return nil
+}
+
+// Health looks the configured network up in WAPI
+func (a *Allocator) Health(ctx context.Context) error {
+    return a.client.lookupNetwork(ctx, a.conf.NetworkView, a.conf.Network)
+}
+
+// attrs builds the extensible attributes identifying an allocation
+func (a *Allocator) attrs(req *ipam.Request) map[string]extAttr {
+    attrs := map[string]extAttr{}
+    for k, v := range a.conf.ExtAttrs {
+        attrs[k] = extAttr{Value: v}
+    }
+    if a.conf.ClusterName != "" {
+        attrs[a.conf.ExtAttrPrefix+attrCluster] = extAttr{Value: a.conf.ClusterName}
+    }
+    attrs[a.conf.ExtAttrPrefix+attrNode] = extAttr{Value: req.NodeName}
+    attrs[a.conf.ExtAttrPrefix+attrPod] = extAttr{Value: req.PodNamespace + "/" + req.PodName}
+    attrs[a.conf.ExtAttrPrefix+attrContainer] = extAttr{Value: req.Key()}
+    return attrs
+}
+
+// reclaim deletes reservations of this cluster and node in the configured
+// network whose attachment is no longer known locally, for example after a
+// crash skipped DEL. Clusters reuse host names, so the cluster attribute,
+// which ParseConfig requires for reclaim, scopes the search. The caller
+// holds the store lock.
+func (a *Allocator) reclaim(ctx context.Context, nodeName string) {
+    last := &reclaimState{}
+    if err := a.store.Load(reclaimFile, last); err != nil || time.Since(last.Last) < a.conf.reclaimInterval() {
+        return
+    }
+    
+    scope := map[string]string{
+        a.conf.ExtAttrPrefix + attrCluster: a.conf.ClusterName,
+        a.conf.ExtAttrPrefix + attrNode:    nodeName,
+    }
+    found, err := a.client.findReserved(ctx, a.conf.NetworkView, a.conf.Network, scope)
+    if err != nil {
+        return
+    }
+    
+    for _, fa := range found {
+        if !matchesAttrs(fa, scope) {
+            continue
+        }
+        key := fa.ExtAttrs[a.conf.ExtAttrPrefix+attrContainer].Value
+        if key == "" || a.known(key) {
+            continue
+        }
+        _ = a.client.remove(ctx, fa.Ref)
+    }
+    
+    _ = a.store.Save(reclaimFile, &reclaimState{Last: time.Now()})
+}
+
+// matchesAttrs reports whether the reservation carries the attributes, in
+// case WAPI ignored a search term
+func matchesAttrs(fa *fixedAddress, attrs map[string]string) bool {
+    for attr, value := range attrs {
+        if fa.ExtAttrs[attr].Value != value {
+            return false
+        }
+    }
+    return true
+}
+
+// known reports whether an allocation key is still held by this node
+func (a *Allocator) known(key string) bool {
+    _, err := os.Stat(filepath.Join(a.store.Dir(), allocationName(key)))
+    return err == nil
+}
+
+// result converts an allocation into a CNI result
+func (a *Allocator) result(alloc *allocation) (*current.Result, error) {
+    ip := net.ParseIP(alloc.Address)
+    if ip == nil {
+        return nil, fmt.Errorf("infoblox: invalid address %q returned", alloc.Address)
+    }
+    _, network, _ := net.ParseCIDR(a.conf.Network)
+    
+    ipc := &current.IPConfig{Address: net.IPNet{IP: ip, Mask: network.Mask}}
+    if a.conf.Gateway != "" {
+        ipc.Gateway = net.ParseIP(a.conf.Gateway)
+    }
+    
+    return &current.Result{
+        CNIVersion: current.ImplementedSpecVersion,
+        IPs:        []*current.IPConfig{ipc},
+    }, nil
+}
+
+func allocationName(key string) string {
+    return filepath.Join(stateDir, key+".json")
+}
+
+func comment(req *ipam.Request) string {
+    return strings.TrimSpace(fmt.Sprintf("vlan-cni %s/%s on %s", req.PodNamespace, req.PodName, req.NodeName))
+}
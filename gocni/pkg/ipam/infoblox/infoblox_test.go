@@ -0,0 +1,185 @@
+package infoblox
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+
+    "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// fakeWAPI serves the fixedaddress and network objects of one network view
+type fakeWAPI struct {
+    mu       sync.Mutex
+    next     int
+    reserved map[string]*fixedAddress
+    failPost bool
+}
+
+func (f *fakeWAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    
+    path := strings.TrimPrefix(r.URL.Path, "/wapi/v2.12/")
+    switch {
+    case r.Method == http.MethodGet && path == "network":
+        fmt.Fprint(w, `[{"_ref": "network/1"}]`)
+    case r.Method == http.MethodPost && path == "fixedaddress":
+        if f.failPost {
+            http.Error(w, "unavailable", http.StatusServiceUnavailable)
+            return
+        }
+        fa := &fixedAddress{}
+        json.NewDecoder(r.Body).Decode(fa)
+        f.next++
+        fa.Ref = fmt.Sprintf("fixedaddress/%d", f.next)
+        fa.IPv4Addr = fmt.Sprintf("192.0.2.%d", f.next+10)
+        f.reserved[fa.Ref] = fa
+        json.NewEncoder(w).Encode(fa)
+    case r.Method == http.MethodGet && path == "fixedaddress":
+        found := []*fixedAddress{}
+        for _, fa := range f.reserved {
+            match := true
+            for k, v := range r.URL.Query() {
+                if strings.HasPrefix(k, "*") && fa.ExtAttrs[k[1:]].Value != v[0] {
+                    match = false
+                }
+            }
+            if match {
+                found = append(found, fa)
+            }
+        }
+        json.NewEncoder(w).Encode(found)
+    case r.Method == http.MethodDelete && strings.HasPrefix(path, "fixedaddress/"):
+        if _, ok := f.reserved[path]; !ok {
+            http.NotFound(w, r)
+            return
+        }
+        delete(f.reserved, path)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// attachments returns the sorted attachment keys of the reservations
+func (f *fakeWAPI) attachments() []string {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    keys := []string{}
+    for _, fa := range f.reserved {
+        keys = append(keys, fa.ExtAttrs["VLAN-CNI "+attrCluster].Value+":"+fa.ExtAttrs["VLAN-CNI "+attrContainer].Value)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// seed adds a reservation made by another node or an earlier crash
+func (f *fakeWAPI) seed(cluster, node, key string) {
+    f.next++
+    ref := fmt.Sprintf("fixedaddress/%d", f.next)
+    f.reserved[ref] = &fixedAddress{Ref: ref, IPv4Addr: fmt.Sprintf("192.0.2.%d", f.next+10), ExtAttrs: map[string]extAttr{
+        "VLAN-CNI " + attrCluster:   {Value: cluster},
+        "VLAN-CNI " + attrNode:      {Value: node},
+        "VLAN-CNI " + attrContainer: {Value: key},
+    }}
+}
+
+// op is one call of a test sequence, on attachment cN-net1
+type op struct {
+    call    string
+    id      int
+    wantErr bool
+}
+
+func TestAllocator(t *testing.T) {
+    tests := []struct {
+        name     string
+        extra    string
+        guessed  bool
+        failPost bool
+        
+        // Start with a stale reservation of this node and one of another
+        // cluster's node of the same name
+        seeded bool
+        ops      []op
+        want     []string
+    }{
+        {
+            name: "allocate is idempotent",
+            ops:  []op{{"allocate", 1, false}, {"allocate", 1, false}, {"check", 1, false}},
+            want: []string{"k1:c1-net1"},
+        },
+        {
+            name: "release frees the reservation",
+            ops:  []op{{"allocate", 1, false}, {"allocate", 2, false}, {"release", 1, false}, {"check", 1, true}, {"release", 1, false}},
+            want: []string{"k1:c2-net1"},
+        },
+        {
+            name:     "reservation failure",
+            failPost: true,
+            seeded:   true,
+            ops:      []op{{"allocate", 1, true}, {"check", 1, true}},
+            want:     []string{"k1:stale-net1", "k2:other-net1"},
+        },
+        {
+            name:  "reclaim removes this node's unknown reservations only",
+            extra:  `, "reclaim": true`,
+            seeded: true,
+            ops:    []op{{"allocate", 1, false}},
+            want:   []string{"k1:c1-net1", "k2:other-net1"},
+        },
+        {
+            name:    "reclaim refuses a guessed node name",
+            extra:   `, "reclaim": true`,
+            guessed: true,
+            seeded:  true,
+            ops:     []op{{"allocate", 1, true}},
+            want:    []string{"k1:stale-net1", "k2:other-net1"},
+        },
+    }
+    for _, tt := range tests {
+        fake := &fakeWAPI{reserved: map[string]*fixedAddress{}, failPost: tt.failPost}
+        srv := httptest.NewServer(fake)
+        if tt.seeded {
+            fake.seed("k1", "node1", "stale-net1")
+            fake.seed("k2", "node1", "other-net1")
+        }
+        
+        conf, err := ParseConfig([]byte(`{"type": "infoblox", "url": "` + srv.URL + `", "username": "u", "password": "p", "network": "192.0.2.0/24", "clusterName": "k1"` + tt.extra + `}`))
+        if err != nil {
+            t.Fatal(err)
+        }
+        store, err := state.NewStore(t.TempDir())
+        if err != nil {
+            t.Fatal(err)
+        }
+        a := New(conf, store)
+        
+        for _, o := range tt.ops {
+            req := &ipam.Request{ContainerID: fmt.Sprintf("c%d", o.id), IfName: "net1", Network: "net", NodeName: "node1", GuessedNodeName: tt.guessed}
+            var err error
+            switch o.call {
+            case "allocate":
+                _, err = a.Allocate(context.Background(), req)
+            case "release":
+                err = a.Release(context.Background(), req)
+            case "check":
+                err = a.Check(context.Background(), req)
+            }
+            if (err != nil) != o.wantErr {
+                t.Errorf("%s: %s c%d: got error %v, want error %v", tt.name, o.call, o.id, err, o.wantErr)
+            }
+        }
+        if got := fake.attachments(); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+            t.Errorf("%s: got reservations %v, want %v", tt.name, got, tt.want)
+        }
+        srv.Close()
+    }
+}
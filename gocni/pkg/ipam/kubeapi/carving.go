@@ -0,0 +1,183 @@
+package kubeapi
+
+import (
+    "context"
+    "fmt"
+    "net/netip"
+    "sort"
+    "strings"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+
+    "example.com/vlan-cni/pkg/ipam"
+)
+
+// NamespaceSubnet objects record which carved subnet belongs to which
+// namespace. They are cluster scoped and named after the pool and the CIDR,
+// so the API server rejects two namespaces claiming the same subnet.
+var namespaceSubnetGVR = schema.GroupVersionResource{
+    Group:    "vlan-cni.io",
+    Version:  "v1alpha1",
+    Resource: "namespacesubnets",
+}
+
+const (
+    labelPool      = "vlan-cni.io/pool"
+    labelNamespace = "vlan-cni.io/namespace"
+)
+
+// namespaceSpan returns the addresses of the namespace's subnet, claiming
+// the lowest free one on first use
+func (a *Allocator) namespaceSpan(ctx context.Context, req *ipam.Request) (span, error) {
+    if req.PodNamespace == "" {
+        return span{}, fmt.Errorf("kube ipam: namespace carving needs the pod namespace in CNI_ARGS")
+    }
+    pool := a.conf.configMapName(req.Network)
+    
+    for attempt := 0; attempt < 3; attempt++ {
+        claimed, err := a.listSubnets(ctx, labelPool+"="+pool)
+        if err != nil {
+            return span{}, err
+        }
+        
+        if prefix, ok := subnetOf(claimed, req.PodNamespace); ok {
+            return a.carvedSpan(prefix), nil
+        }
+        
+        prefix, ok := a.freeSubnet(claimed)
+        if !ok {
+            return span{}, fmt.Errorf("kube ipam: no free /%d left in %s for namespace %s", a.conf.NamespacePrefixLength, a.conf.prefix, req.PodNamespace)
+        }
+        
+        err = a.claimSubnet(ctx, pool, req.PodNamespace, prefix)
+        if apierrors.IsAlreadyExists(err) {
+            // Someone took that subnet first, look again
+            continue
+        }
+        if err != nil {
+            return span{}, err
+        }
+        
+        // Another node may have claimed a different subnet for the same
+        // namespace at the same time; everyone settles on the lowest
+        claimed, err = a.listSubnets(ctx, labelPool+"="+pool+","+labelNamespace+"="+req.PodNamespace)
+        if err != nil {
+            return span{}, err
+        }
+        winner, _ := subnetOf(claimed, req.PodNamespace)
+        if winner != prefix {
+            _ = a.dynamic.Resource(namespaceSubnetGVR).Delete(ctx, subnetName(pool, prefix), metav1.DeleteOptions{})
+        }
+        return a.carvedSpan(winner), nil
+    }
+    return span{}, fmt.Errorf("kube ipam: failed to claim a subnet for namespace %s", req.PodNamespace)
+}
+
+// claimedSubnet is the part of a NamespaceSubnet used here
+type claimedSubnet struct {
+    namespace string
+    prefix    netip.Prefix
+}
+
+func (a *Allocator) listSubnets(ctx context.Context, selector string) ([]claimedSubnet, error) {
+    list, err := a.dynamic.Resource(namespaceSubnetGVR).List(ctx, metav1.ListOptions{LabelSelector: selector})
+    if err != nil {
+        return nil, fmt.Errorf("kube ipam: failed to list NamespaceSubnets: %v", err)
+    }
+    
+    var claimed []claimedSubnet
+    for _, item := range list.Items {
+        namespace, _, _ := unstructured.NestedString(item.Object, "spec", "namespace")
+        cidr, _, _ := unstructured.NestedString(item.Object, "spec", "cidr")
+        prefix, err := netip.ParsePrefix(cidr)
+        if err != nil {
+            continue
+        }
+        claimed = append(claimed, claimedSubnet{namespace: namespace, prefix: prefix})
+    }
+    sort.Slice(claimed, func(i, j int) bool {
+        return claimed[i].prefix.Addr().Less(claimed[j].prefix.Addr())
+    })
+    return claimed, nil
+}
+
+func (a *Allocator) claimSubnet(ctx context.Context, pool, namespace string, prefix netip.Prefix) error {
+    obj := &unstructured.Unstructured{Object: map[string]interface{}{
+        "apiVersion": namespaceSubnetGVR.GroupVersion().String(),
+        "kind":       "NamespaceSubnet",
+        "metadata": map[string]interface{}{
+            "name": subnetName(pool, prefix),
+            "labels": map[string]interface{}{
+                labelPool:      pool,
+                labelNamespace: namespace,
+            },
+        },
+        "spec": map[string]interface{}{
+            "pool":      pool,
+            "namespace": namespace,
+            "cidr":      prefix.String(),
+        },
+    }}
+    _, err := a.dynamic.Resource(namespaceSubnetGVR).Create(ctx, obj, metav1.CreateOptions{})
+    if err != nil && !apierrors.IsAlreadyExists(err) {
+        return fmt.Errorf("kube ipam: failed to create NamespaceSubnet for %s: %v", namespace, err)
+    }
+    return err
+}
+
+// freeSubnet returns the lowest carved subnet nobody claimed that does not
+// overlap a reservation
+func (a *Allocator) freeSubnet(claimed []claimedSubnet) (netip.Prefix, bool) {
+    taken := map[netip.Prefix]bool{}
+    for _, c := range claimed {
+        taken[c.prefix] = true
+    }
+    
+    for addr := a.conf.prefix.Addr(); a.conf.prefix.Contains(addr); {
+        block := netip.PrefixFrom(addr, a.conf.NamespacePrefixLength)
+        if !taken[block] && !a.overlapsReservation(block) {
+            return block, true
+        }
+        addr = lastAddr(block).Next()
+    }
+    return netip.Prefix{}, false
+}
+
+func (a *Allocator) overlapsReservation(block netip.Prefix) bool {
+    for _, r := range a.conf.Reservations {
+        if block.Contains(r.span.start) || block.Contains(r.span.end) || r.span.contains(block.Addr()) {
+            return true
+        }
+    }
+    return false
+}
+
+// carvedSpan limits a carved subnet to the configured allocation range
+func (a *Allocator) carvedSpan(prefix netip.Prefix) span {
+    s := span{start: prefix.Addr(), end: lastAddr(prefix)}
+    if s.start.Less(a.conf.span.start) {
+        s.start = a.conf.span.start
+    }
+    if a.conf.span.end.Less(s.end) {
+        s.end = a.conf.span.end
+    }
+    return s
+}
+
+// subnetOf returns the lowest subnet claimed by a namespace
+func subnetOf(claimed []claimedSubnet, namespace string) (netip.Prefix, bool) {
+    for _, c := range claimed {
+        if c.namespace == namespace {
+            return c.prefix, true
+        }
+    }
+    return netip.Prefix{}, false
+}
+
+func subnetName(pool string, prefix netip.Prefix) string {
+    cidr := strings.NewReplacer(".", "-", ":", "-", "/", "-").Replace(prefix.String())
+    return strings.Trim(pool+"-"+cidr, "-")
+}
@@ -0,0 +1,222 @@
+package kubeapi
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/netip"
+    "regexp"
+    "strings"
+
+    "k8s.io/apimachinery/pkg/labels"
+)
+
+// TypeName selects this backend as ipam.type
+const TypeName = "kube"
+
+const defaultNamespace = "kube-system"
+
+// Sticky modes for StatefulSet pods
+const (
+    // StickyIndex gives ordinal N the Nth address of the range
+    StickyIndex = "index"
+    // StickyName keeps the first address a pod got reserved for its name
+    StickyName = "name"
+)
+
+// Config is the ipam section for the Kubernetes API backend
+type Config struct {
+    Type       string `json:"type"`
+    Kubeconfig string `json:"kubeconfig,omitempty"`
+    Namespace  string `json:"namespace,omitempty"`
+    
+    // Pool names the ConfigMap holding the allocations, defaulting to the
+    // network name so networks sharing a subnet can share a pool
+    Pool string `json:"pool,omitempty"`
+    
+    Range      string `json:"range"`
+    RangeStart string `json:"rangeStart,omitempty"`
+    RangeEnd   string `json:"rangeEnd,omitempty"`
+    Gateway    string `json:"gateway,omitempty"`
+    
+    // Sticky gives StatefulSet pods stable addresses across rescheduling
+    Sticky string `json:"sticky,omitempty"`
+    
+    // StickySlots is how many addresses at the start of each pool the
+    // index mode keeps for ordinals 0 to StickySlots-1. Other pods
+    // allocate after them.
+    StickySlots int `json:"stickySlots,omitempty"`
+    
+    // Reservations set sub-ranges aside for pods matching a label selector
+    Reservations []*Reservation `json:"reservations,omitempty"`
+    
+    // NamespacePrefixLength, when set, carves the range into subnets of this
+    // length and gives each namespace its own
+    NamespacePrefixLength int `json:"namespacePrefixLength,omitempty"`
+    
+    prefix  netip.Prefix
+    span    span
+    gateway netip.Addr
+}
+
+// Reservation is a sub-range only pods matching Selector allocate from
+type Reservation struct {
+    Selector   string `json:"selector"`
+    RangeStart string `json:"rangeStart"`
+    RangeEnd   string `json:"rangeEnd"`
+    
+    selector labels.Selector
+    span     span
+}
+
+// span is an inclusive range of addresses
+type span struct {
+    start, end netip.Addr
+}
+
+func (s span) contains(addr netip.Addr) bool {
+    return !addr.Less(s.start) && !s.end.Less(addr)
+}
+
+// invalidNameChars matches what may not appear in a ConfigMap name
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ParseConfig decodes and validates the ipam section
+func ParseConfig(raw []byte) (*Config, error) {
+    conf := &Config{}
+    if err := json.Unmarshal(raw, conf); err != nil {
+        return nil, fmt.Errorf("kube ipam: failed to parse ipam config: %v", err)
+    }
+    if conf.Namespace == "" {
+        conf.Namespace = defaultNamespace
+    }
+    
+    switch conf.Sticky {
+    case "", StickyIndex, StickyName:
+    default:
+        return nil, fmt.Errorf("kube ipam: invalid sticky mode %q", conf.Sticky)
+    }
+    switch {
+    case conf.Sticky == StickyIndex && conf.StickySlots <= 0:
+        return nil, fmt.Errorf("kube ipam: sticky mode %q needs stickySlots", StickyIndex)
+    case conf.Sticky != StickyIndex && conf.StickySlots != 0:
+        return nil, fmt.Errorf("kube ipam: stickySlots only applies to sticky mode %q", StickyIndex)
+    }
+    
+    prefix, err := netip.ParsePrefix(conf.Range)
+    if err != nil {
+        return nil, fmt.Errorf("kube ipam: invalid range %q: %v", conf.Range, err)
+    }
+    conf.prefix = prefix.Masked()
+    
+    // Skip the network address, and the broadcast address for IPv4
+    conf.span.start = conf.prefix.Addr().Next()
+    conf.span.end = lastAddr(conf.prefix)
+    if conf.prefix.Addr().Is4() {
+        conf.span.end = conf.span.end.Prev()
+    }
+    
+    if err := conf.parseBounds([]bound{
+        {"rangeStart", conf.RangeStart, &conf.span.start},
+        {"rangeEnd", conf.RangeEnd, &conf.span.end},
+        {"gateway", conf.Gateway, &conf.gateway},
+    }); err != nil {
+        return nil, err
+    }
+    if conf.span.end.Less(conf.span.start) {
+        return nil, fmt.Errorf("kube ipam: range %s has no usable addresses", conf.prefix)
+    }
+    
+    if n := conf.NamespacePrefixLength; n != 0 && (n <= conf.prefix.Bits() || n > conf.prefix.Addr().BitLen()) {
+        return nil, fmt.Errorf("kube ipam: namespacePrefixLength %d does not fit range %s", n, conf.prefix)
+    }
+    
+    for i, r := range conf.Reservations {
+        if r.RangeStart == "" || r.RangeEnd == "" {
+            return nil, fmt.Errorf("kube ipam: reservation %d needs rangeStart and rangeEnd", i)
+        }
+        if r.selector, err = labels.Parse(r.Selector); err != nil {
+            return nil, fmt.Errorf("kube ipam: reservation %d has invalid selector %q: %v", i, r.Selector, err)
+        }
+        if err := conf.parseBounds([]bound{
+            {"reservation rangeStart", r.RangeStart, &r.span.start},
+            {"reservation rangeEnd", r.RangeEnd, &r.span.end},
+        }); err != nil {
+            return nil, err
+        }
+        if !conf.span.contains(r.span.start) || !conf.span.contains(r.span.end) || r.span.end.Less(r.span.start) {
+            return nil, fmt.Errorf("kube ipam: reservation %s-%s is not inside the allocation range", r.RangeStart, r.RangeEnd)
+        }
+    }
+    
+    return conf, nil
+}
+
+// bound is an optional address field of the config
+type bound struct {
+    field, value string
+    addr         *netip.Addr
+}
+
+// parseBounds sets each bound that has a value, checking it is in the prefix
+func (c *Config) parseBounds(bounds []bound) error {
+    for _, b := range bounds {
+        if b.value == "" {
+            continue
+        }
+        addr, err := netip.ParseAddr(b.value)
+        if err != nil || !c.prefix.Contains(addr) {
+            return fmt.Errorf("kube ipam: %s %q is not in range %s", b.field, b.value, c.prefix)
+        }
+        *b.addr = addr
+    }
+    return nil
+}
+
+// reservationFor returns the first reservation whose selector matches the
+// pod labels, or nil
+func (c *Config) reservationFor(podLabels map[string]string) *Reservation {
+    for _, r := range c.Reservations {
+        if r.selector.Matches(labels.Set(podLabels)) {
+            return r
+        }
+    }
+    return nil
+}
+
+// reserved reports whether an address belongs to any reservation
+func (c *Config) reserved(addr netip.Addr) bool {
+    for _, r := range c.Reservations {
+        if r.span.contains(addr) {
+            return true
+        }
+    }
+    return false
+}
+
+// configMapName returns the ConfigMap holding a pool's allocations
+func (c *Config) configMapName(network string) string {
+    pool := c.Pool
+    if pool == "" {
+        pool = network
+    }
+    name := invalidNameChars.ReplaceAllString(strings.ToLower(pool), "-")
+    return "vlan-cni-ipam-" + strings.Trim(name, "-")
+}
+
+// lastAddr returns the highest address of a prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+    b := p.Addr().AsSlice()
+    for i := range b {
+        bits := p.Bits() - i*8
+        switch {
+        case bits >= 8:
+            continue
+        case bits <= 0:
+            b[i] = 0xff
+        default:
+            b[i] |= 0xff >> bits
+        }
+    }
+    addr, _ := netip.AddrFromSlice(b)
+    return addr
+}
@@ -0,0 +1,454 @@
+package kubeapi
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/netip"
+    "strconv"
+    "strings"
+    "time"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    corev1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/kubernetes"
+
+    "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/kube"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// entry is a ConfigMap value recording who holds an address. Sticky entries
+// outlive their attachment: on release only Attachment is cleared, keeping
+// the address for the next pod of the same name. Migrated entries are kept
+// for Pod the same way until MigratedUntil.
+type entry struct {
+    Attachment    string     `json:"attachment"`
+    Pod           string     `json:"pod,omitempty"`
+    Node          string     `json:"node,omitempty"`
+    Sticky        bool       `json:"sticky,omitempty"`
+    Allocated     time.Time  `json:"allocated"`
+    MigratedUntil *time.Time `json:"migratedUntil,omitempty"`
+}
+
+// expired reports whether the entry is a migration nobody claimed in time
+func (e *entry) expired(now time.Time) bool {
+    return e.Attachment == "" && e.MigratedUntil != nil && now.After(*e.MigratedUntil)
+}
+
+// Allocator keeps allocations in a ConfigMap so that they survive pods
+// moving between nodes. Concurrent writers are serialised by the API
+// server's resourceVersion check.
+type Allocator struct {
+    conf    *Config
+    client  kubernetes.Interface
+    dynamic dynamic.Interface
+}
+
+func init() {
+    ipam.Register(TypeName, func(raw []byte, store *state.Store) (ipam.Driver, error) {
+        conf, err := ParseConfig(raw)
+        if err != nil {
+            return nil, err
+        }
+        client, err := kube.NewClient(conf.Kubeconfig)
+        if err != nil {
+            return nil, err
+        }
+        dyn, err := kube.NewDynamicClient(conf.Kubeconfig)
+        if err != nil {
+            return nil, err
+        }
+        return New(conf, client, dyn), nil
+    })
+}
+
+// New returns an allocator for the given configuration. The dynamic client
+// is used for NamespaceSubnet objects when namespace carving is enabled.
+func New(conf *Config, client kubernetes.Interface, dyn dynamic.Interface) *Allocator {
+    return &Allocator{conf: conf, client: client, dynamic: dyn}
+}
+
+// Allocate records the first free address for the attachment. Repeated
+// calls for the same attachment return the same address.
+func (a *Allocator) Allocate(ctx context.Context, req *ipam.Request) (*current.Result, error) {
+    podObj, err := a.lookupPod(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    ordinal, sticky := a.statefulSetOrdinal(podObj)
+    pool, err := a.poolFor(ctx, req, a.reservationFor(podObj))
+    if err != nil {
+        return nil, err
+    }
+    pod := strings.Trim(req.PodNamespace+"/"+req.PodName, "/")
+    
+    var addr netip.Addr
+    err = a.update(ctx, req.Network, func(cm *corev1.ConfigMap) (bool, error) {
+        if held, ok := find(cm, req.Key()); ok {
+            addr = held
+            return false, nil
+        }
+        
+        migrated, ok := findMigrated(cm, pod)
+        switch {
+        case ok:
+            // Handed over by the pod this one replaces
+            addr = migrated
+        case sticky && a.conf.Sticky == StickyIndex:
+            if ordinal >= a.conf.StickySlots {
+                return false, fmt.Errorf("kube ipam: ordinal %d of %s is beyond stickySlots %d", ordinal, pod, a.conf.StickySlots)
+            }
+            addr, ok = a.nth(pool, ordinal)
+            if !ok {
+                return false, fmt.Errorf("kube ipam: ordinal %d of %s is outside range %s", ordinal, pod, a.conf.prefix)
+            }
+            if e := lookup(cm, addr); e != nil && e.Attachment != "" && e.Pod != pod {
+                return false, fmt.Errorf("kube ipam: %s for %s is held by %s", addr, pod, e.Pod)
+            }
+        case sticky:
+            addr, ok = findSticky(cm, pod)
+            if !ok {
+                addr, ok = a.firstFree(cm, pool)
+            }
+        default:
+            addr, ok = a.firstFree(cm, pool)
+        }
+        if !ok {
+            return false, fmt.Errorf("kube ipam: range %s is exhausted", a.conf.prefix)
+        }
+        
+        value, _ := json.Marshal(&entry{
+            Attachment: req.Key(),
+            Pod:        pod,
+            Node:       req.NodeName,
+            Sticky:     sticky && a.conf.Sticky == StickyName,
+            Allocated:  time.Now().UTC(),
+        })
+        cm.Data[dataKey(addr)] = string(value)
+        return true, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    return a.result(addr), nil
+}
+
+// Release forgets the attachment's address
+func (a *Allocator) Release(ctx context.Context, req *ipam.Request) error {
+    return a.update(ctx, req.Network, func(cm *corev1.ConfigMap) (bool, error) {
+        held, ok := find(cm, req.Key())
+        if !ok {
+            return false, nil
+        }
+        
+        // Keep sticky addresses reserved for the pod name
+        if e := lookup(cm, held); e != nil && e.Sticky {
+            e.Attachment = ""
+            value, _ := json.Marshal(e)
+            cm.Data[dataKey(held)] = string(value)
+            return true, nil
+        }
+        delete(cm.Data, dataKey(held))
+        return true, nil
+    })
+}
+
+// Migrate releases the attachment's address and holds it for toPod, a
+// namespace/name, until ttl passes, so a replacement started on another
+// node during a drain gets it without waiting for the old pod's DEL
+func (a *Allocator) Migrate(ctx context.Context, req *ipam.Request, toPod string, ttl time.Duration) ([]net.IP, error) {
+    var ips []net.IP
+    err := a.update(ctx, req.Network, func(cm *corev1.ConfigMap) (bool, error) {
+        held, ok := find(cm, req.Key())
+        if !ok {
+            return false, fmt.Errorf("kube ipam: no address allocated for %s", req.Key())
+        }
+        if _, taken := findMigrated(cm, toPod); taken {
+            return false, fmt.Errorf("kube ipam: an address is already migrating to %s", toPod)
+        }
+        
+        until := time.Now().UTC().Add(ttl)
+        value, _ := json.Marshal(&entry{
+            Pod:           toPod,
+            Allocated:     time.Now().UTC(),
+            MigratedUntil: &until,
+        })
+        cm.Data[dataKey(held)] = string(value)
+        ips = append(ips, net.IP(held.AsSlice()))
+        return true, nil
+    })
+    return ips, err
+}
+
+// Check verifies the pool still records an address for the attachment
+func (a *Allocator) Check(ctx context.Context, req *ipam.Request) error {
+    cm, err := a.client.CoreV1().ConfigMaps(a.conf.Namespace).Get(ctx, a.conf.configMapName(req.Network), metav1.GetOptions{})
+    if err != nil {
+        return fmt.Errorf("kube ipam: failed to read pool: %v", err)
+    }
+    if _, ok := find(cm, req.Key()); !ok {
+        return fmt.Errorf("kube ipam: no address allocated for %s", req.Key())
+    }
+    return nil
+}
+
+// Health confirms the API server is reachable and ConfigMaps can be listed
+func (a *Allocator) Health(ctx context.Context) error {
+    _, err := a.client.CoreV1().ConfigMaps(a.conf.Namespace).List(ctx, metav1.ListOptions{Limit: 1})
+    return err
+}
+
+// updateBackoff spaces out retries after losing a write to another node.
+// The jitter keeps nodes that collided from colliding again in lockstep;
+// the attempts add up to several seconds, and ctx bounds them further.
+func updateBackoff() wait.Backoff {
+    return wait.Backoff{Duration: 20 * time.Millisecond, Factor: 1.5, Jitter: 1, Steps: 12}
+}
+
+// update applies mutate to the pool's ConfigMap, creating it when missing
+// and retrying when another writer got there first
+func (a *Allocator) update(ctx context.Context, network string, mutate func(*corev1.ConfigMap) (bool, error)) error {
+    name := a.conf.configMapName(network)
+    
+    var lost error
+    err := wait.ExponentialBackoffWithContext(ctx, updateBackoff(), func(ctx context.Context) (bool, error) {
+        err := a.tryUpdate(ctx, name, mutate)
+        if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+            lost = err
+            return false, nil
+        }
+        return true, err
+    })
+    switch {
+    case err != nil && lost != nil:
+        return fmt.Errorf("kube ipam: failed to update pool %s/%s: %v (last attempt: %v)", a.conf.Namespace, name, err, lost)
+    case err != nil:
+        return fmt.Errorf("kube ipam: failed to update pool %s/%s: %v", a.conf.Namespace, name, err)
+    }
+    return nil
+}
+
+// tryUpdate makes one attempt at update
+func (a *Allocator) tryUpdate(ctx context.Context, name string, mutate func(*corev1.ConfigMap) (bool, error)) error {
+    configMaps := a.client.CoreV1().ConfigMaps(a.conf.Namespace)
+    cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+    create := apierrors.IsNotFound(err)
+    if err != nil && !create {
+        return err
+    }
+    if create {
+        cm = &corev1.ConfigMap{
+            ObjectMeta: metav1.ObjectMeta{
+                Name:      name,
+                Namespace: a.conf.Namespace,
+                Labels:    map[string]string{"app.kubernetes.io/managed-by": "vlan-cni"},
+            },
+        }
+    }
+    if cm.Data == nil {
+        cm.Data = map[string]string{}
+    }
+    
+    changed, err := mutate(cm)
+    if err != nil || !changed {
+        return err
+    }
+    
+    if create {
+        _, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+    } else {
+        _, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+    }
+    return err
+}
+
+// pool is the part of the range a pod allocates from
+type pool struct {
+    span span
+    
+    // shared pools skip addresses set aside by reservations
+    shared bool
+}
+
+// poolFor picks the pod's pool: its reservation, its namespace's carved
+// subnet, or the unreserved part of the range
+func (a *Allocator) poolFor(ctx context.Context, req *ipam.Request, r *Reservation) (*pool, error) {
+    switch {
+    case r != nil:
+        return &pool{span: r.span}, nil
+    case a.conf.NamespacePrefixLength > 0:
+        s, err := a.namespaceSpan(ctx, req)
+        if err != nil {
+            return nil, err
+        }
+        return &pool{span: s}, nil
+    default:
+        return &pool{span: a.conf.span, shared: true}, nil
+    }
+}
+
+// firstFree returns the lowest unallocated address of the pool, past the
+// slots kept for StatefulSet ordinals
+func (a *Allocator) firstFree(cm *corev1.ConfigMap, p *pool) (netip.Addr, bool) {
+    now := time.Now()
+    return a.walk(p, func(addr netip.Addr, i int) bool {
+        if i < a.conf.StickySlots {
+            return false
+        }
+        if e := lookup(cm, addr); e != nil && e.expired(now) {
+            return true
+        }
+        _, taken := cm.Data[dataKey(addr)]
+        return !taken
+    })
+}
+
+// nth returns the nth candidate address, as used for StatefulSet ordinals
+func (a *Allocator) nth(p *pool, n int) (netip.Addr, bool) {
+    return a.walk(p, func(_ netip.Addr, i int) bool {
+        return i == n
+    })
+}
+
+// walk visits the pool's addresses in order, returning the first one pick
+// accepts. The gateway is never visited.
+func (a *Allocator) walk(p *pool, pick func(addr netip.Addr, i int) bool) (netip.Addr, bool) {
+    i := 0
+    for addr := p.span.start; p.span.contains(addr); addr = addr.Next() {
+        if addr == a.conf.gateway || (p.shared && a.conf.reserved(addr)) {
+            continue
+        }
+        if pick(addr, i) {
+            return addr, true
+        }
+        i++
+    }
+    return netip.Addr{}, false
+}
+
+// lookupPod fetches the pod when sticky addressing or reservations need its
+// owner or labels
+func (a *Allocator) lookupPod(ctx context.Context, req *ipam.Request) (*corev1.Pod, error) {
+    if (a.conf.Sticky == "" && len(a.conf.Reservations) == 0) || req.PodName == "" {
+        return nil, nil
+    }
+    
+    pod, err := a.client.CoreV1().Pods(req.PodNamespace).Get(ctx, req.PodName, metav1.GetOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("kube ipam: failed to look up pod %s/%s: %v", req.PodNamespace, req.PodName, err)
+    }
+    return pod, nil
+}
+
+// reservationFor returns the reservation matching the pod's labels, or nil
+func (a *Allocator) reservationFor(pod *corev1.Pod) *Reservation {
+    if pod == nil {
+        return nil
+    }
+    return a.conf.reservationFor(pod.Labels)
+}
+
+// statefulSetOrdinal reports whether sticky addressing applies to the pod,
+// that is whether it is owned by a StatefulSet, and its ordinal
+func (a *Allocator) statefulSetOrdinal(pod *corev1.Pod) (int, bool) {
+    if a.conf.Sticky == "" || pod == nil {
+        return 0, false
+    }
+    
+    for _, owner := range pod.OwnerReferences {
+        if owner.Kind != "StatefulSet" || !strings.HasPrefix(pod.Name, owner.Name+"-") {
+            continue
+        }
+        ordinal, err := strconv.Atoi(strings.TrimPrefix(pod.Name, owner.Name+"-"))
+        if err != nil || ordinal < 0 {
+            continue
+        }
+        return ordinal, true
+    }
+    return 0, false
+}
+
+// result converts an address into a CNI result
+func (a *Allocator) result(addr netip.Addr) *current.Result {
+    ipc := &current.IPConfig{Address: net.IPNet{
+        IP:   net.IP(addr.AsSlice()),
+        Mask: net.CIDRMask(a.conf.prefix.Bits(), addr.BitLen()),
+    }}
+    if a.conf.gateway.IsValid() {
+        ipc.Gateway = net.IP(a.conf.gateway.AsSlice())
+    }
+    
+    return &current.Result{
+        CNIVersion: current.ImplementedSpecVersion,
+        IPs:        []*current.IPConfig{ipc},
+    }
+}
+
+// find returns the address held by an attachment
+func find(cm *corev1.ConfigMap, key string) (netip.Addr, bool) {
+    for k, v := range cm.Data {
+        e := &entry{}
+        if json.Unmarshal([]byte(v), e) != nil || e.Attachment != key {
+            continue
+        }
+        if addr, err := netip.ParseAddr(strings.ReplaceAll(k, "-", ":")); err == nil {
+            return addr, true
+        }
+    }
+    return netip.Addr{}, false
+}
+
+// findSticky returns the address reserved for a pod name
+func findSticky(cm *corev1.ConfigMap, pod string) (netip.Addr, bool) {
+    for k, v := range cm.Data {
+        e := &entry{}
+        if json.Unmarshal([]byte(v), e) != nil || !e.Sticky || e.Pod != pod {
+            continue
+        }
+        if addr, err := netip.ParseAddr(strings.ReplaceAll(k, "-", ":")); err == nil {
+            return addr, true
+        }
+    }
+    return netip.Addr{}, false
+}
+
+// findMigrated returns an unexpired address migrating to a pod
+func findMigrated(cm *corev1.ConfigMap, pod string) (netip.Addr, bool) {
+    now := time.Now()
+    for k, v := range cm.Data {
+        e := &entry{}
+        if json.Unmarshal([]byte(v), e) != nil || e.MigratedUntil == nil || e.Attachment != "" || e.Pod != pod || e.expired(now) {
+            continue
+        }
+        if addr, err := netip.ParseAddr(strings.ReplaceAll(k, "-", ":")); err == nil {
+            return addr, true
+        }
+    }
+    return netip.Addr{}, false
+}
+
+// lookup returns the entry recorded for an address
+func lookup(cm *corev1.ConfigMap, addr netip.Addr) *entry {
+    v, ok := cm.Data[dataKey(addr)]
+    if !ok {
+        return nil
+    }
+    e := &entry{}
+    if json.Unmarshal([]byte(v), e) != nil {
+        return nil
+    }
+    return e
+}
+
+// dataKey turns an address into a valid ConfigMap key; IPv6 colons are not
+// allowed there
+func dataKey(addr netip.Addr) string {
+    return strings.ReplaceAll(addr.String(), ":", "-")
+}
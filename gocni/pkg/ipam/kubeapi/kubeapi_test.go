@@ -0,0 +1,187 @@
+package kubeapi
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    corev1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/kubernetes/fake"
+    k8stesting "k8s.io/client-go/testing"
+
+    "example.com/vlan-cni/pkg/ipam"
+)
+
+// statefulSetPod returns pod name-ordinal owned by StatefulSet name
+func statefulSetPod(name string, ordinal int) *corev1.Pod {
+    return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+        Name:            fmt.Sprintf("%s-%d", name, ordinal),
+        Namespace:       "default",
+        OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: name}},
+    }}
+}
+
+func TestParseConfigStickySlots(t *testing.T) {
+    tests := []struct {
+        name string
+        conf string
+        ok   bool
+    }{
+        {"index with slots", `{"range": "10.0.0.0/24", "sticky": "index", "stickySlots": 4}`, true},
+        {"index without slots", `{"range": "10.0.0.0/24", "sticky": "index"}`, false},
+        {"slots without index", `{"range": "10.0.0.0/24", "sticky": "name", "stickySlots": 4}`, false},
+    }
+    for _, tt := range tests {
+        _, err := ParseConfig([]byte(tt.conf))
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: got error %v, want ok %v", tt.name, err, tt.ok)
+        }
+    }
+}
+
+// TestStickySlots allocates in order: ordinals take their slots, other pods
+// never do, even before the StatefulSet has started
+func TestStickySlots(t *testing.T) {
+    conf, err := ParseConfig([]byte(`{"range": "10.0.0.0/24", "sticky": "index", "stickySlots": 4}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    plain := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+    client := fake.NewSimpleClientset(plain, statefulSetPod("db", 2), statefulSetPod("db", 4))
+    a := New(conf, client, nil)
+    
+    tests := []struct {
+        pod  string
+        want string
+    }{
+        {"web", "10.0.0.5/24"},
+        {"db-2", "10.0.0.3/24"},
+        {"db-4", ""},
+    }
+    for i, tt := range tests {
+        result, err := a.Allocate(context.Background(), &ipam.Request{
+            ContainerID:  fmt.Sprintf("c%d", i),
+            IfName:       "net1",
+            Network:      "net",
+            PodName:      tt.pod,
+            PodNamespace: "default",
+        })
+        if tt.want == "" {
+            if err == nil {
+                t.Errorf("%s: got %s, want an error", tt.pod, result.IPs[0].Address.String())
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", tt.pod, err)
+            continue
+        }
+        if got := result.IPs[0].Address.String(); got != tt.want {
+            t.Errorf("%s: got %s, want %s", tt.pod, got, tt.want)
+        }
+    }
+}
+
+// TestUpdateRetriesConflicts loses the write to other nodes several times,
+// more than the client-go default retry allows, and then for longer than ctx
+func TestUpdateRetriesConflicts(t *testing.T) {
+    conf, err := ParseConfig([]byte(`{"range": "10.0.0.0/24"}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name      string
+        conflicts int
+        timeout   time.Duration
+        ok        bool
+    }{
+        {"no conflict", 0, time.Minute, true},
+        {"repeated conflicts", 8, time.Minute, true},
+        {"conflicts past ctx", 1000, 200 * time.Millisecond, false},
+    }
+    for _, tt := range tests {
+        client := fake.NewSimpleClientset()
+        conflicts := tt.conflicts
+        client.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+            if conflicts == 0 {
+                return false, nil, nil
+            }
+            conflicts--
+            return true, nil, apierrors.NewAlreadyExists(schema.GroupResource{Resource: "configmaps"}, "vlan-cni-ipam-net")
+        })
+        a := New(conf, client, nil)
+        
+        ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+        start := time.Now()
+        _, err := a.Allocate(ctx, &ipam.Request{ContainerID: "c", IfName: "net1", Network: "net"})
+        cancel()
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: got error %v, want ok %v", tt.name, err, tt.ok)
+        }
+        if elapsed := time.Since(start); elapsed > tt.timeout+time.Second {
+            t.Errorf("%s: took %s, past the %s deadline", tt.name, elapsed, tt.timeout)
+        }
+    }
+}
+
+// TestAllocateRelease runs ADD, CHECK and DEL of several attachments in
+// order against one small range
+func TestAllocateRelease(t *testing.T) {
+    conf, err := ParseConfig([]byte(`{"range": "10.0.0.0/24", "rangeStart": "10.0.0.10", "rangeEnd": "10.0.0.11", "sticky": "name"}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    client := fake.NewSimpleClientset(statefulSetPod("db", 0))
+    a := New(conf, client, nil)
+    
+    tests := []struct {
+        call      string
+        container string
+        pod       string
+        want      string
+    }{
+        {"allocate", "c1", "", "10.0.0.10/24"},
+        {"allocate", "c1", "", "10.0.0.10/24"},
+        {"allocate", "c2", "db-0", "10.0.0.11/24"},
+        {"allocate", "c3", "", ""},
+        {"check", "c3", "", ""},
+        {"release", "c1", "", "ok"},
+        {"release", "c1", "", "ok"},
+        {"check", "c1", "", ""},
+        {"allocate", "c3", "", "10.0.0.10/24"},
+        // db-0's address stays reserved for its replacement
+        {"release", "c2", "db-0", "ok"},
+        {"allocate", "c4", "", ""},
+        {"allocate", "c5", "db-0", "10.0.0.11/24"},
+        {"check", "c5", "db-0", "ok"},
+    }
+    for i, tt := range tests {
+        req := &ipam.Request{ContainerID: tt.container, IfName: "net1", Network: "net", PodName: tt.pod, PodNamespace: "default"}
+        var got string
+        var err error
+        switch tt.call {
+        case "allocate":
+            var result *current.Result
+            if result, err = a.Allocate(context.Background(), req); err == nil {
+                got = result.IPs[0].Address.String()
+            }
+        case "release":
+            if err = a.Release(context.Background(), req); err == nil {
+                got = "ok"
+            }
+        case "check":
+            if err = a.Check(context.Background(), req); err == nil {
+                got = "ok"
+            }
+        }
+        if got != tt.want {
+            t.Errorf("%d: %s %s: got %q (%v), want %q", i, tt.call, tt.container, got, err, tt.want)
+        }
+    }
+}
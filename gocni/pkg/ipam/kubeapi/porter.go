@@ -0,0 +1,148 @@
+package kubeapi
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/netip"
+    "sort"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+    "example.com/vlan-cni/pkg/ipam"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// Export returns the pool's allocations in address order. Migrations in
+// flight are left out; they lapse on their own.
+func (a *Allocator) Export(ctx context.Context, network string) ([]*vlanv1.Allocation, error) {
+    cm, err := a.client.CoreV1().ConfigMaps(a.conf.Namespace).Get(ctx, a.conf.configMapName(network), metav1.GetOptions{})
+    if apierrors.IsNotFound(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("kube ipam: failed to read pool: %v", err)
+    }
+    
+    var addrs []netip.Addr
+    for k := range cm.Data {
+        if addr, err := netip.ParseAddr(strings.ReplaceAll(k, "-", ":")); err == nil {
+            addrs = append(addrs, addr)
+        }
+    }
+    sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+    
+    var allocations []*vlanv1.Allocation
+    for _, addr := range addrs {
+        e := lookup(cm, addr)
+        if e == nil || e.MigratedUntil != nil {
+            continue
+        }
+        // Keys are containerID-ifName and container IDs hold no dashes
+        containerID, ifName, _ := strings.Cut(e.Attachment, "-")
+        allocated := e.Allocated
+        allocations = append(allocations, &vlanv1.Allocation{
+            IP:          addr.String(),
+            ContainerID: containerID,
+            IfName:      ifName,
+            Pod:         e.Pod,
+            Node:        e.Node,
+            Sticky:      e.Sticky,
+            Allocated:   &allocated,
+        })
+    }
+    return allocations, nil
+}
+
+// Import records the allocations in a single update of the pool, so that
+// either all of them land or, on any conflict, none do
+func (a *Allocator) Import(ctx context.Context, network string, allocations []*vlanv1.Allocation, dryRun bool) (int, error) {
+    var imported int
+    var conflicts ipam.ImportConflicts
+    err := a.update(ctx, network, func(cm *corev1.ConfigMap) (bool, error) {
+        imported, conflicts = 0, nil
+        now := time.Now()
+        addrs := map[netip.Addr]bool{}
+        keys := map[string]bool{}
+        for _, alloc := range allocations {
+            conflict := func(format string, args ...interface{}) {
+                conflicts = append(conflicts, ipam.Conflict{Allocation: alloc, Reason: fmt.Sprintf(format, args...)})
+            }
+            addr, err := netip.ParseAddr(alloc.IP)
+            switch {
+            case err != nil:
+                conflict("invalid address")
+                continue
+            case !a.conf.span.contains(addr):
+                conflict("outside range %s", a.conf.prefix)
+                continue
+            case addr == a.conf.gateway:
+                conflict("is the gateway")
+                continue
+            case addrs[addr]:
+                conflict("listed twice")
+                continue
+            }
+            addrs[addr] = true
+            
+            var key string
+            if alloc.ContainerID != "" {
+                key = alloc.ContainerID + "-" + alloc.IfName
+            }
+            if e := lookup(cm, addr); e != nil && !e.expired(now) {
+                if e.Attachment != key || e.Pod != alloc.Pod {
+                    conflict("held by %s", holder(e))
+                }
+                continue
+            }
+            if key != "" {
+                if held, ok := find(cm, key); ok {
+                    conflict("%s holds %s already", key, held)
+                    continue
+                }
+                if keys[key] {
+                    conflict("%s is listed twice", key)
+                    continue
+                }
+                keys[key] = true
+            }
+            
+            allocated := now.UTC()
+            if alloc.Allocated != nil {
+                allocated = alloc.Allocated.UTC()
+            }
+            value, _ := json.Marshal(&entry{
+                Attachment: key,
+                Pod:        alloc.Pod,
+                Node:       alloc.Node,
+                Sticky:     alloc.Sticky,
+                Allocated:  allocated,
+            })
+            cm.Data[dataKey(addr)] = string(value)
+            imported++
+        }
+        return len(conflicts) == 0 && imported > 0 && !dryRun, nil
+    })
+    if err != nil {
+        return 0, err
+    }
+    if len(conflicts) > 0 {
+        return 0, conflicts
+    }
+    return imported, nil
+}
+
+// holder describes who an entry reserves its address for
+func holder(e *entry) string {
+    switch {
+    case e.Pod != "":
+        return e.Pod
+    case e.Attachment != "":
+        return e.Attachment
+    }
+    return "an unnamed reservation"
+}
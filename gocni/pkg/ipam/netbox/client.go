@@ -0,0 +1,201 @@
+package netbox
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+)
+
+// client is a minimal NetBox REST API client
+type client struct {
+    base  string
+    token string
+    http  *http.Client
+}
+
+func newClient(conf *Config) *client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = conf.tlsConfig
+    return &client{
+        base:  conf.URL,
+        token: conf.Token,
+        http:  &http.Client{Transport: transport, Timeout: conf.timeout()},
+    }
+}
+
+type prefix struct {
+    ID     int    `json:"id"`
+    Prefix string `json:"prefix"`
+}
+
+type ipAddress struct {
+    ID      int    `json:"id"`
+    Address string `json:"address"`
+}
+
+type device struct {
+    ID int `json:"id"`
+}
+
+type iface struct {
+    ID int `json:"id"`
+}
+
+type list struct {
+    Count   int             `json:"count"`
+    Results json.RawMessage `json:"results"`
+}
+
+// lookupPrefix returns the NetBox ID of a prefix
+func (c *client) lookupPrefix(ctx context.Context, cidr string) (int, error) {
+    var prefixes []prefix
+    if err := c.list(ctx, "/api/ipam/prefixes/", url.Values{"prefix": {cidr}}, &prefixes); err != nil {
+        return 0, err
+    }
+    if len(prefixes) != 1 {
+        return 0, fmt.Errorf("netbox: expected one prefix %s, found %d", cidr, len(prefixes))
+    }
+    return prefixes[0].ID, nil
+}
+
+// allocateIP claims the next free address in a prefix
+func (c *client) allocateIP(ctx context.Context, prefixID int, description, dnsName string) (*ipAddress, error) {
+    body := map[string]interface{}{
+        "status":      "active",
+        "description": description,
+    }
+    if dnsName != "" {
+        body["dns_name"] = dnsName
+    }
+    
+    ip := &ipAddress{}
+    path := fmt.Sprintf("/api/ipam/prefixes/%d/available-ips/", prefixID)
+    if err := c.do(ctx, http.MethodPost, path, body, ip); err != nil {
+        return nil, err
+    }
+    return ip, nil
+}
+
+// assignIP attaches an address to a device interface
+func (c *client) assignIP(ctx context.Context, ipID, ifaceID int) error {
+    body := map[string]interface{}{
+        "assigned_object_type": "dcim.interface",
+        "assigned_object_id":   ifaceID,
+    }
+    return c.do(ctx, http.MethodPatch, fmt.Sprintf("/api/ipam/ip-addresses/%d/", ipID), body, nil)
+}
+
+// releaseIP deletes an address, treating an already missing one as released
+func (c *client) releaseIP(ctx context.Context, ipID int) error {
+    return c.delete(ctx, fmt.Sprintf("/api/ipam/ip-addresses/%d/", ipID))
+}
+
+// lookupDevice returns the NetBox ID of a device by name
+func (c *client) lookupDevice(ctx context.Context, name string) (int, error) {
+    var devices []device
+    if err := c.list(ctx, "/api/dcim/devices/", url.Values{"name": {name}}, &devices); err != nil {
+        return 0, err
+    }
+    if len(devices) != 1 {
+        return 0, fmt.Errorf("netbox: expected one device %q, found %d", name, len(devices))
+    }
+    return devices[0].ID, nil
+}
+
+// createInterface adds a virtual interface carrying mac to a device
+func (c *client) createInterface(ctx context.Context, deviceID int, name, mac, description string) (int, error) {
+    body := map[string]interface{}{
+        "device":      deviceID,
+        "name":        name,
+        "type":        "virtual",
+        "description": description,
+    }
+    if mac != "" {
+        body["mac_address"] = mac
+    }
+    
+    created := &iface{}
+    if err := c.do(ctx, http.MethodPost, "/api/dcim/interfaces/", body, created); err != nil {
+        return 0, err
+    }
+    return created.ID, nil
+}
+
+// deleteInterface removes an interface created by createInterface
+func (c *client) deleteInterface(ctx context.Context, ifaceID int) error {
+    return c.delete(ctx, fmt.Sprintf("/api/dcim/interfaces/%d/", ifaceID))
+}
+
+func (c *client) list(ctx context.Context, path string, query url.Values, out interface{}) error {
+    page := &list{}
+    if err := c.do(ctx, http.MethodGet, path+"?"+query.Encode(), nil, page); err != nil {
+        return err
+    }
+    return json.Unmarshal(page.Results, out)
+}
+
+func (c *client) delete(ctx context.Context, path string) error {
+    err := c.do(ctx, http.MethodDelete, path, nil, nil)
+    if apiErr, ok := err.(*apiError); ok && apiErr.status == http.StatusNotFound {
+        return nil
+    }
+    return err
+}
+
+// apiError is a non-2xx NetBox response
+type apiError struct {
+    status int
+    body   string
+}
+
+func (e *apiError) Error() string {
+    return fmt.Sprintf("netbox: API returned %d: %s", e.status, e.body)
+}
+
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) error {
+    var reader *bytes.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return fmt.Errorf("netbox: failed to encode request: %v", err)
+        }
+        reader = bytes.NewReader(data)
+    } else {
+        reader = bytes.NewReader(nil)
+    }
+    
+    req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+    if err != nil {
+        return fmt.Errorf("netbox: failed to build request: %v", err)
+    }
+    req.Header.Set("Authorization", "Token "+c.token)
+    req.Header.Set("Accept", "application/json")
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return fmt.Errorf("netbox: %s %s failed: %v", method, path, err)
+    }
+    defer resp.Body.Close()
+    
+    data, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return fmt.Errorf("netbox: failed to read response: %v", err)
+    }
+    if resp.StatusCode/100 != 2 {
+        return &apiError{status: resp.StatusCode, body: string(bytes.TrimSpace(data))}
+    }
+    if out == nil || len(data) == 0 {
+        return nil
+    }
+    if err := json.Unmarshal(data, out); err != nil {
+        return fmt.Errorf("netbox: failed to decode response: %v", err)
+    }
+    return nil
+}
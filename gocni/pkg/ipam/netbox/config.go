@@ -0,0 +1,126 @@
+package netbox
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "net"
+    "strings"
+    "time"
+
+    "example.com/vlan-cni/pkg/secrets"
+    "example.com/vlan-cni/pkg/state"
+    "example.com/vlan-cni/pkg/tlsconf"
+)
+
+// TypeName selects this backend as ipam.type
+const TypeName = "netbox"
+
+// Failure modes
+const (
+    // FailureModeClosed fails the CNI operation on any NetBox error
+    FailureModeClosed = "closed"
+
+    // FailureModeOpen tolerates interface registration and release errors;
+    // failed releases are retried on later invocations
+    FailureModeOpen = "open"
+)
+
+// Config is the ipam section for the NetBox backend
+type Config struct {
+    Type  string `json:"type"`
+    URL   string `json:"url"`
+    Token string `json:"token,omitempty"`
+
+    // Reference to the token instead of carrying it inline
+    TokenFrom *secrets.Ref `json:"tokenFrom,omitempty"`
+
+    // Prefix to allocate from, as it appears in NetBox
+    Prefix  string `json:"prefix"`
+    Gateway string `json:"gateway,omitempty"`
+
+    // Create a NetBox interface carrying the pod's MAC on Device (the node
+    // name by default) and assign the address to it
+    RegisterInterface bool   `json:"registerInterface,omitempty"`
+    Device            string `json:"device,omitempty"`
+
+    // How long prefix lookups are cached on the node
+    CacheTTLSeconds int `json:"cacheTTLSeconds,omitempty"`
+
+    FailureMode        string `json:"failureMode,omitempty"`
+    TimeoutSeconds     int    `json:"timeoutSeconds,omitempty"`
+    InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+
+    // Server verification and client certificates
+    TLS *tlsconf.Config `json:"tls,omitempty"`
+
+    tlsConfig *tls.Config
+}
+
+// ParseConfig decodes and validates the ipam section
+func ParseConfig(raw []byte) (*Config, error) {
+    conf := &Config{}
+    if err := json.Unmarshal(raw, conf); err != nil {
+        return nil, fmt.Errorf("netbox: failed to parse ipam config: %v", err)
+    }
+    
+    if conf.URL == "" || (conf.Token == "" && conf.TokenFrom == nil) {
+        return nil, fmt.Errorf("netbox: url and token or tokenFrom are required")
+    }
+    if conf.TokenFrom != nil {
+        if err := conf.TokenFrom.Validate(); err != nil {
+            return nil, fmt.Errorf("netbox: tokenFrom: %v", err)
+        }
+    }
+    conf.URL = strings.TrimRight(conf.URL, "/")
+    if _, _, err := net.ParseCIDR(conf.Prefix); err != nil {
+        return nil, fmt.Errorf("netbox: invalid prefix %q: %v", conf.Prefix, err)
+    }
+    if conf.Gateway != "" && net.ParseIP(conf.Gateway) == nil {
+        return nil, fmt.Errorf("netbox: invalid gateway %q", conf.Gateway)
+    }
+    
+    switch conf.FailureMode {
+    case "":
+        conf.FailureMode = FailureModeClosed
+    case FailureModeClosed, FailureModeOpen:
+    default:
+        return nil, fmt.Errorf("netbox: unknown failureMode %q", conf.FailureMode)
+    }
+    
+    tlsConfig, err := conf.TLS.ClientConfig(conf.InsecureSkipVerify)
+    if err != nil {
+        return nil, fmt.Errorf("netbox: %v", err)
+    }
+    conf.tlsConfig = tlsConfig
+    
+    return conf, nil
+}
+
+func (c *Config) cacheTTL() time.Duration {
+    if c.CacheTTLSeconds > 0 {
+        return time.Duration(c.CacheTTLSeconds) * time.Second
+    }
+    return 10 * time.Minute
+}
+
+func (c *Config) timeout() time.Duration {
+    if c.TimeoutSeconds > 0 {
+        return time.Duration(c.TimeoutSeconds) * time.Second
+    }
+    return 10 * time.Second
+}
+
+// resolveCredentials replaces the token reference with its value
+func (c *Config) resolveCredentials(store *state.Store) error {
+    if c.TokenFrom == nil {
+        return nil
+    }
+    token, err := secrets.Resolve(context.Background(), c.TokenFrom, store)
+    if err != nil {
+        return fmt.Errorf("netbox: tokenFrom: %v", err)
+    }
+    c.Token = token
+    return nil
+}
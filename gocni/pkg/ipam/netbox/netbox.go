@@ -0,0 +1,292 @@
+package netbox
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "path/filepath"
+    "time"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/state"
+)
+
+const (
+    stateDir    = "netbox"
+    prefixCache = "netbox/prefixes.json"
+    pendingFile = "netbox/pending-releases.json"
+)
+
+// allocation is what the node remembers about an address it claimed
+type allocation struct {
+    IPID        int    `json:"ipID"`
+    Address     string `json:"address"`
+    InterfaceID int    `json:"interfaceID,omitempty"`
+}
+
+type cachedPrefix struct {
+    ID      int       `json:"id"`
+    Fetched time.Time `json:"fetched"`
+}
+
+// Allocator hands out addresses from a NetBox prefix
+type Allocator struct {
+    conf   *Config
+    client *client
+    store  *state.Store
+}
+
+func init() {
+    ipam.Register(TypeName, func(raw []byte, store *state.Store) (ipam.Driver, error) {
+        conf, err := ParseConfig(raw)
+        if err != nil {
+            return nil, err
+        }
+        if err := conf.resolveCredentials(store); err != nil {
+            return nil, err
+        }
+        return New(conf, store), nil
+    })
+}
+
+// New returns an allocator for the given configuration
+func New(conf *Config, store *state.Store) *Allocator {
+    return &Allocator{conf: conf, client: newClient(conf), store: store}
+}
+
+// Allocate claims an address for the attachment. Repeated calls for the same
+// attachment return the address already claimed.
+func (a *Allocator) Allocate(ctx context.Context, req *ipam.Request) (*current.Result, error) {
+    a.retryPending(ctx)
+    
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req), alloc); err != nil {
+        return nil, err
+    }
+    
+    if alloc.IPID == 0 {
+        prefixID, err := a.prefixID(ctx)
+        if err != nil {
+            return nil, err
+        }
+        
+        ip, err := a.client.allocateIP(ctx, prefixID, description(req), "")
+        if err != nil {
+            return nil, err
+        }
+        alloc = &allocation{IPID: ip.ID, Address: ip.Address}
+        
+        if a.conf.RegisterInterface {
+            if err := a.registerInterface(ctx, req, alloc); err != nil {
+                if a.conf.FailureMode == FailureModeClosed {
+                    a.discard(ctx, alloc)
+                    return nil, err
+                }
+            }
+        }
+        
+        if err := a.store.Save(allocationName(req), alloc); err != nil {
+            a.discard(ctx, alloc)
+            return nil, err
+        }
+    }
+    
+    return a.result(alloc)
+}
+
+// Release returns the attachment's address to NetBox
+func (a *Allocator) Release(ctx context.Context, req *ipam.Request) error {
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req), alloc); err != nil {
+        return err
+    }
+    if alloc.IPID == 0 {
+        return nil
+    }
+    
+    if err := a.release(ctx, alloc); err != nil {
+        if a.conf.FailureMode == FailureModeClosed {
+            return err
+        }
+        if err := a.queuePending(alloc); err != nil {
+            return err
+        }
+    }
+    
+    return a.store.Remove(allocationName(req))
+}
+
+// Check verifies the node still records an address for the attachment
+func (a *Allocator) Check(ctx context.Context, req *ipam.Request) error {
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req), alloc); err != nil {
+        return err
+    }
+    if alloc.IPID == 0 {
+        return fmt.Errorf("netbox: no address allocated for %s", req.Key())
+    }
+    return nil
+}
+
+// Health looks the configured prefix up, bypassing the cache
+func (a *Allocator) Health(ctx context.Context) error {
+    _, err := a.client.lookupPrefix(ctx, a.conf.Prefix)
+    return err
+}
+
+func (a *Allocator) release(ctx context.Context, alloc *allocation) error {
+    if alloc.InterfaceID != 0 {
+        if err := a.client.deleteInterface(ctx, alloc.InterfaceID); err != nil {
+            return err
+        }
+    }
+    return a.client.releaseIP(ctx, alloc.IPID)
+}
+
+// discard undoes an allocation that ADD is abandoning. Unlike release it
+// goes on to the address when the interface cannot be deleted, leaving as
+// little as possible behind.
+func (a *Allocator) discard(ctx context.Context, alloc *allocation) {
+    if alloc.InterfaceID != 0 {
+        _ = a.client.deleteInterface(ctx, alloc.InterfaceID)
+    }
+    _ = a.client.releaseIP(ctx, alloc.IPID)
+}
+
+// registerInterface records the pod interface and its MAC in NetBox
+func (a *Allocator) registerInterface(ctx context.Context, req *ipam.Request, alloc *allocation) error {
+    deviceName := a.conf.Device
+    if deviceName == "" {
+        deviceName = req.NodeName
+    }
+    
+    deviceID, err := a.client.lookupDevice(ctx, deviceName)
+    if err != nil {
+        return err
+    }
+    
+    ifName := req.ContainerID
+    if len(ifName) > 12 {
+        ifName = ifName[:12]
+    }
+    ifaceID, err := a.client.createInterface(ctx, deviceID, ifName+"-"+req.IfName, req.MAC, description(req))
+    if err != nil {
+        return err
+    }
+    alloc.InterfaceID = ifaceID
+    
+    return a.client.assignIP(ctx, alloc.IPID, ifaceID)
+}
+
+// prefixID resolves the configured prefix, using the node-local cache
+func (a *Allocator) prefixID(ctx context.Context) (int, error) {
+    cache := map[string]*cachedPrefix{}
+    if err := a.store.Load(prefixCache, &cache); err != nil {
+        return 0, err
+    }
+    
+    key := a.conf.URL + " " + a.conf.Prefix
+    if c, ok := cache[key]; ok && time.Since(c.Fetched) < a.conf.cacheTTL() {
+        return c.ID, nil
+    }
+    
+    id, err := a.client.lookupPrefix(ctx, a.conf.Prefix)
+    if err != nil {
+        return 0, err
+    }
+    
+    cache[key] = &cachedPrefix{ID: id, Fetched: time.Now()}
+    _ = a.store.Save(prefixCache, cache)
+    return id, nil
+}
+
+// queuePending remembers a release that could not reach NetBox
+func (a *Allocator) queuePending(alloc *allocation) error {
+    if err := a.store.Lock(); err != nil {
+        return err
+    }
+    defer a.store.Unlock()
+    
+    var pending []*allocation
+    if err := a.store.Load(pendingFile, &pending); err != nil {
+        return err
+    }
+    return a.store.Save(pendingFile, append(pending, alloc))
+}
+
+// retryPending replays queued releases, keeping those that still fail.
+// The store lock is only held to read and rewrite the queue, not across
+// the requests to NetBox, so a slow NetBox does not hold up every other
+// invocation on the node. Releases are idempotent, so two invocations
+// replaying the same entry is harmless.
+func (a *Allocator) retryPending(ctx context.Context) {
+    var pending []*allocation
+    if err := a.loadPending(&pending); err != nil || len(pending) == 0 {
+        return
+    }
+    
+    released := map[int]bool{}
+    for _, alloc := range pending {
+        if err := a.release(ctx, alloc); err == nil {
+            released[alloc.IPID] = true
+        }
+    }
+    if len(released) == 0 {
+        return
+    }
+    
+    if err := a.store.Lock(); err != nil {
+        return
+    }
+    defer a.store.Unlock()
+    
+    // Reread the queue, which may have grown meanwhile
+    var queued, remaining []*allocation
+    if err := a.store.Load(pendingFile, &queued); err != nil {
+        return
+    }
+    for _, alloc := range queued {
+        if !released[alloc.IPID] {
+            remaining = append(remaining, alloc)
+        }
+    }
+    _ = a.store.Save(pendingFile, remaining)
+}
+
+// loadPending reads the queue of failed releases under the store lock
+func (a *Allocator) loadPending(pending *[]*allocation) error {
+    if err := a.store.Lock(); err != nil {
+        return err
+    }
+    defer a.store.Unlock()
+    return a.store.Load(pendingFile, pending)
+}
+
+// result converts an allocation into a CNI result
+func (a *Allocator) result(alloc *allocation) (*current.Result, error) {
+    ip, ipnet, err := net.ParseCIDR(alloc.Address)
+    if err != nil {
+        return nil, fmt.Errorf("netbox: invalid address %q returned: %v", alloc.Address, err)
+    }
+    ipnet.IP = ip
+    
+    ipc := &current.IPConfig{Address: *ipnet}
+    if a.conf.Gateway != "" {
+        ipc.Gateway = net.ParseIP(a.conf.Gateway)
+    }
+    
+    return &current.Result{
+        CNIVersion: current.ImplementedSpecVersion,
+        IPs:        []*current.IPConfig{ipc},
+    }, nil
+}
+
+func allocationName(req *ipam.Request) string {
+    return filepath.Join(stateDir, req.Key()+".json")
+}
+
+func description(req *ipam.Request) string {
+    return fmt.Sprintf("vlan-cni %s/%s %s/%s", req.PodNamespace, req.PodName, req.ContainerID, req.IfName)
+}
@@ -0,0 +1,241 @@
+package netbox
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "syscall"
+    "testing"
+
+    "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// fakeNetBox serves the part of the NetBox API the allocator uses, for one
+// prefix and one device
+type fakeNetBox struct {
+    mu         sync.Mutex
+    nextID     int
+    ips        map[int]string
+    interfaces map[int]string
+
+    // Requests matching these fail with a 500
+    failMethod, failPath string
+
+    // Called on each DELETE, before it is served
+    onDelete func()
+}
+
+func newFakeNetBox() *fakeNetBox {
+    return &fakeNetBox{nextID: 100, ips: map[int]string{}, interfaces: map[int]string{}}
+}
+
+func (f *fakeNetBox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    
+    if r.Method == f.failMethod && strings.HasPrefix(r.URL.Path, f.failPath) {
+        http.Error(w, "unavailable", http.StatusInternalServerError)
+        return
+    }
+    if r.Method == http.MethodDelete && f.onDelete != nil {
+        f.onDelete()
+    }
+    
+    var id int
+    switch {
+    case r.Method == http.MethodGet && r.URL.Path == "/api/ipam/prefixes/":
+        fmt.Fprint(w, `{"count": 1, "results": [{"id": 7, "prefix": "192.0.2.0/24"}]}`)
+    case r.Method == http.MethodGet && r.URL.Path == "/api/dcim/devices/":
+        fmt.Fprint(w, `{"count": 1, "results": [{"id": 3}]}`)
+    case r.Method == http.MethodPost && r.URL.Path == "/api/ipam/prefixes/7/available-ips/":
+        f.nextID++
+        addr := fmt.Sprintf("192.0.2.%d/24", len(f.ips)+10)
+        f.ips[f.nextID] = addr
+        json.NewEncoder(w).Encode(&ipAddress{ID: f.nextID, Address: addr})
+    case r.Method == http.MethodPost && r.URL.Path == "/api/dcim/interfaces/":
+        var body map[string]interface{}
+        json.NewDecoder(r.Body).Decode(&body)
+        f.nextID++
+        f.interfaces[f.nextID], _ = body["name"].(string)
+        json.NewEncoder(w).Encode(&iface{ID: f.nextID})
+    case r.Method == http.MethodPatch && sscan(r.URL.Path, "/api/ipam/ip-addresses/%d/", &id):
+        w.Write([]byte(`{}`))
+    case r.Method == http.MethodDelete && sscan(r.URL.Path, "/api/ipam/ip-addresses/%d/", &id):
+        delete(f.ips, id)
+        w.WriteHeader(http.StatusNoContent)
+    case r.Method == http.MethodDelete && sscan(r.URL.Path, "/api/dcim/interfaces/%d/", &id):
+        delete(f.interfaces, id)
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// fail makes requests for method with paths under prefix fail, or none
+// when method is empty
+func (f *fakeNetBox) fail(method, prefix string) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.failMethod, f.failPath = method, prefix
+}
+
+func (f *fakeNetBox) counts() (int, int) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return len(f.ips), len(f.interfaces)
+}
+
+func sscan(path, format string, id *int) bool {
+    n, err := fmt.Sscanf(path, format, id)
+    return err == nil && n == 1
+}
+
+// newTestAllocator returns an allocator for the fake with the extra config
+// keys given
+func newTestAllocator(t *testing.T, fake *fakeNetBox, extra string) (*Allocator, *state.Store) {
+    t.Helper()
+    srv := httptest.NewServer(fake)
+    t.Cleanup(srv.Close)
+    
+    conf, err := ParseConfig([]byte(`{"type": "netbox", "url": "` + srv.URL + `", "token": "t", "prefix": "192.0.2.0/24", "device": "node1"` + extra + `}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    store, err := state.NewStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    return New(conf, store), store
+}
+
+func testRequest(id string) *ipam.Request {
+    return &ipam.Request{ContainerID: id, IfName: "net1", Network: "net", PodNamespace: "default", PodName: "web", MAC: "02:00:00:00:00:01"}
+}
+
+// TestAllocateClosedCleansUp has interface registration fail after the
+// interface was created, which must leave neither it nor the address behind
+func TestAllocateClosedCleansUp(t *testing.T) {
+    fake := newFakeNetBox()
+    fake.fail(http.MethodPatch, "/api/ipam/ip-addresses/")
+    a, _ := newTestAllocator(t, fake, `, "registerInterface": true`)
+    
+    if _, err := a.Allocate(context.Background(), testRequest("c1")); err == nil {
+        t.Fatal("Allocate succeeded with a failing address assignment")
+    }
+    if ips, ifaces := fake.counts(); ips != 0 || ifaces != 0 {
+        t.Errorf("left %d addresses and %d interfaces in NetBox, want none", ips, ifaces)
+    }
+}
+
+// TestRetryPendingUnlocked queues a release while NetBox is down and checks
+// the next Allocate replays it without holding the store lock
+func TestRetryPendingUnlocked(t *testing.T) {
+    fake := newFakeNetBox()
+    a, store := newTestAllocator(t, fake, `, "failureMode": "open"`)
+    ctx := context.Background()
+    
+    if _, err := a.Allocate(ctx, testRequest("c1")); err != nil {
+        t.Fatal(err)
+    }
+    fake.fail(http.MethodDelete, "/api/")
+    if err := a.Release(ctx, testRequest("c1")); err != nil {
+        t.Fatal(err)
+    }
+    var pending []*allocation
+    if err := store.Load(pendingFile, &pending); err != nil || len(pending) != 1 {
+        t.Fatalf("got pending releases %v (%v), want one", pending, err)
+    }
+    
+    locked := false
+    fake.mu.Lock()
+    fake.onDelete = func() {
+        f, err := os.OpenFile(filepath.Join(store.Dir(), ".lock"), os.O_CREATE|os.O_RDWR, 0600)
+        if err != nil {
+            t.Error(err)
+            return
+        }
+        defer f.Close()
+        if syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) != nil {
+            locked = true
+            return
+        }
+        syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+    }
+    fake.failMethod = ""
+    fake.mu.Unlock()
+    if _, err := a.Allocate(ctx, testRequest("c2")); err != nil {
+        t.Fatal(err)
+    }
+    if locked {
+        t.Error("the store lock was held while replaying releases")
+    }
+    pending = nil
+    if err := store.Load(pendingFile, &pending); err != nil || len(pending) != 0 {
+        t.Errorf("got pending releases %v (%v), want none", pending, err)
+    }
+    if ips, _ := fake.counts(); ips != 1 {
+        t.Errorf("NetBox holds %d addresses, want only c2's", ips)
+    }
+}
+
+// TestFailureModes has one NetBox call fail during ADD or DEL under each
+// failure mode, and counts what is left in NetBox
+func TestFailureModes(t *testing.T) {
+    tests := []struct {
+        name        string
+        extra       string
+        failMethod  string
+        failPath    string
+        del         bool
+        wantErr     bool
+        ips, ifaces int
+    }{
+        {"allocate", ``, "", "", false, false, 1, 0},
+        {"allocate with interface", `, "registerInterface": true`, "", "", false, false, 1, 1},
+        {"release with interface", `, "registerInterface": true`, "", "", true, false, 0, 0},
+        {"prefix lookup fails", ``, http.MethodGet, "/api/ipam/prefixes/", false, true, 0, 0},
+        {"no free address", ``, http.MethodPost, "/api/ipam/prefixes/", false, true, 0, 0},
+        {"interface fails, closed", `, "registerInterface": true`, http.MethodPost, "/api/dcim/interfaces/", false, true, 0, 0},
+        {"interface fails, open", `, "registerInterface": true, "failureMode": "open"`, http.MethodPost, "/api/dcim/interfaces/", false, false, 1, 0},
+        {"release fails, closed", ``, http.MethodDelete, "/api/", true, true, 1, 0},
+        {"release fails, open", `, "failureMode": "open"`, http.MethodDelete, "/api/", true, false, 1, 0},
+    }
+    for _, tt := range tests {
+        fake := newFakeNetBox()
+        a, _ := newTestAllocator(t, fake, tt.extra)
+        ctx := context.Background()
+        
+        var err error
+        if tt.del {
+            if _, err := a.Allocate(ctx, testRequest("c1")); err != nil {
+                t.Fatalf("%s: %v", tt.name, err)
+            }
+            fake.fail(tt.failMethod, tt.failPath)
+            err = a.Release(ctx, testRequest("c1"))
+        } else {
+            fake.fail(tt.failMethod, tt.failPath)
+            _, err = a.Allocate(ctx, testRequest("c1"))
+        }
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+        }
+        if ips, ifaces := fake.counts(); ips != tt.ips || ifaces != tt.ifaces {
+            t.Errorf("%s: NetBox holds %d addresses and %d interfaces, want %d and %d", tt.name, ips, ifaces, tt.ips, tt.ifaces)
+        }
+        
+        // What DEL failed to release stays recorded for CHECK, or queued
+        if tt.del {
+            checkErr := a.Check(ctx, testRequest("c1"))
+            if (checkErr == nil) != tt.wantErr {
+                t.Errorf("%s: CHECK after DEL got %v", tt.name, checkErr)
+            }
+        }
+    }
+}
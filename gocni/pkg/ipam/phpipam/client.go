@@ -0,0 +1,167 @@
+package phpipam
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+)
+
+// client is a minimal phpIPAM REST API client using app token auth
+type client struct {
+    base  string
+    token string
+    http  *http.Client
+}
+
+func newClient(conf *Config) *client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = conf.tlsConfig
+    return &client{
+        base:  fmt.Sprintf("%s/api/%s", conf.URL, conf.App),
+        token: conf.Token,
+        http:  &http.Client{Transport: transport, Timeout: conf.timeout()},
+    }
+}
+
+// envelope wraps every phpIPAM response
+type envelope struct {
+    Code    int             `json:"code"`
+    Success bool            `json:"success"`
+    Message string          `json:"message"`
+    ID      json.Number     `json:"id"`
+    Data    json.RawMessage `json:"data"`
+}
+
+type vlan struct {
+    ID     string `json:"vlanId"`
+    Number string `json:"number"`
+}
+
+type subnet struct {
+    ID        string `json:"id"`
+    Subnet    string `json:"subnet"`
+    Mask      string `json:"mask"`
+    SectionID string `json:"sectionId"`
+}
+
+// findVLAN returns the phpIPAM IDs of VLANs with the given number
+func (c *client) findVLAN(ctx context.Context, number int) ([]vlan, error) {
+    var vlans []vlan
+    if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/vlan/search/%d/", number), nil, &vlans); err != nil {
+        return nil, err
+    }
+    return vlans, nil
+}
+
+// vlanSubnets lists the subnets attached to a VLAN
+func (c *client) vlanSubnets(ctx context.Context, vlanID string) ([]subnet, error) {
+    var subnets []subnet
+    if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/vlan/%s/subnets/", vlanID), nil, &subnets); err != nil {
+        return nil, err
+    }
+    return subnets, nil
+}
+
+// searchSubnet looks a subnet up by CIDR
+func (c *client) searchSubnet(ctx context.Context, cidr string) ([]subnet, error) {
+    var subnets []subnet
+    if _, err := c.do(ctx, http.MethodGet, "/subnets/cidr/"+cidr+"/", nil, &subnets); err != nil {
+        return nil, err
+    }
+    return subnets, nil
+}
+
+// firstFree creates an address on the first free IP of a subnet, returning
+// its ID and address
+func (c *client) firstFree(ctx context.Context, subnetID, hostname, description, mac string) (string, string, error) {
+    body := map[string]string{
+        "hostname":    hostname,
+        "description": description,
+    }
+    if mac != "" {
+        body["mac"] = mac
+    }
+    
+    var address string
+    env, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/addresses/first_free/%s/", subnetID), body, &address)
+    if err != nil {
+        return "", "", err
+    }
+    return env.ID.String(), address, nil
+}
+
+// ping makes an authenticated request that every token may perform
+func (c *client) ping(ctx context.Context) error {
+    _, err := c.do(ctx, http.MethodGet, "/sections/", nil, nil)
+    return err
+}
+
+// deleteAddress removes an address, treating a missing one as removed
+func (c *client) deleteAddress(ctx context.Context, id string) error {
+    _, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/addresses/%s/", id), nil, nil)
+    if apiErr, ok := err.(*apiError); ok && apiErr.code == http.StatusNotFound {
+        return nil
+    }
+    return err
+}
+
+// apiError is an unsuccessful phpIPAM response
+type apiError struct {
+    code    int
+    message string
+}
+
+func (e *apiError) Error() string {
+    return fmt.Sprintf("phpipam: API returned %d: %s", e.code, e.message)
+}
+
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) (*envelope, error) {
+    var data []byte
+    if body != nil {
+        var err error
+        if data, err = json.Marshal(body); err != nil {
+            return nil, fmt.Errorf("phpipam: failed to encode request: %v", err)
+        }
+    }
+    
+    req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(data))
+    if err != nil {
+        return nil, fmt.Errorf("phpipam: failed to build request: %v", err)
+    }
+    req.Header.Set("token", c.token)
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("phpipam: %s %s failed: %v", method, path, err)
+    }
+    defer resp.Body.Close()
+    
+    respData, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, fmt.Errorf("phpipam: failed to read response: %v", err)
+    }
+    
+    env := &envelope{}
+    if err := json.Unmarshal(respData, env); err != nil {
+        return nil, fmt.Errorf("phpipam: failed to decode response (%s): %v", resp.Status, err)
+    }
+    if !env.Success {
+        code := env.Code
+        if code == 0 {
+            code = resp.StatusCode
+        }
+        return nil, &apiError{code: code, message: env.Message}
+    }
+    if out != nil && len(env.Data) > 0 {
+        if err := json.Unmarshal(env.Data, out); err != nil {
+            return nil, fmt.Errorf("phpipam: failed to decode response data: %v", err)
+        }
+    }
+    return env, nil
+}
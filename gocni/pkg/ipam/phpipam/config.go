@@ -0,0 +1,98 @@
+package phpipam
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "net"
+    "strings"
+    "time"
+
+    "example.com/vlan-cni/pkg/secrets"
+    "example.com/vlan-cni/pkg/state"
+    "example.com/vlan-cni/pkg/tlsconf"
+)
+
+// TypeName selects this backend as ipam.type
+const TypeName = "phpipam"
+
+// Config is the ipam section for the phpIPAM backend
+type Config struct {
+    Type  string `json:"type"`
+    URL   string `json:"url"`
+    App   string `json:"app"`
+    Token string `json:"token,omitempty"`
+
+    // Reference to the token instead of carrying it inline
+    TokenFrom *secrets.Ref `json:"tokenFrom,omitempty"`
+
+    // Subnet selection: the subnet attached to VLAN (the network's VLAN ID
+    // by default), optionally narrowed to a section or pinned by CIDR
+    VLAN      int    `json:"vlan,omitempty"`
+    SectionID string `json:"sectionId,omitempty"`
+    Subnet    string `json:"subnet,omitempty"`
+    Gateway   string `json:"gateway,omitempty"`
+
+    TimeoutSeconds     int  `json:"timeoutSeconds,omitempty"`
+    InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+    // Server verification and client certificates
+    TLS *tlsconf.Config `json:"tls,omitempty"`
+
+    tlsConfig *tls.Config
+}
+
+// ParseConfig decodes and validates the ipam section
+func ParseConfig(raw []byte) (*Config, error) {
+    conf := &Config{}
+    if err := json.Unmarshal(raw, conf); err != nil {
+        return nil, fmt.Errorf("phpipam: failed to parse ipam config: %v", err)
+    }
+    
+    if conf.URL == "" || conf.App == "" || (conf.Token == "" && conf.TokenFrom == nil) {
+        return nil, fmt.Errorf("phpipam: url, app and token or tokenFrom are required")
+    }
+    if conf.TokenFrom != nil {
+        if err := conf.TokenFrom.Validate(); err != nil {
+            return nil, fmt.Errorf("phpipam: tokenFrom: %v", err)
+        }
+    }
+    conf.URL = strings.TrimRight(conf.URL, "/")
+    if conf.Subnet != "" {
+        if _, _, err := net.ParseCIDR(conf.Subnet); err != nil {
+            return nil, fmt.Errorf("phpipam: invalid subnet %q: %v", conf.Subnet, err)
+        }
+    }
+    if conf.Gateway != "" && net.ParseIP(conf.Gateway) == nil {
+        return nil, fmt.Errorf("phpipam: invalid gateway %q", conf.Gateway)
+    }
+    
+    tlsConfig, err := conf.TLS.ClientConfig(conf.InsecureSkipVerify)
+    if err != nil {
+        return nil, fmt.Errorf("phpipam: %v", err)
+    }
+    conf.tlsConfig = tlsConfig
+    
+    return conf, nil
+}
+
+func (c *Config) timeout() time.Duration {
+    if c.TimeoutSeconds > 0 {
+        return time.Duration(c.TimeoutSeconds) * time.Second
+    }
+    return 10 * time.Second
+}
+
+// resolveCredentials replaces the token reference with its value
+func (c *Config) resolveCredentials(store *state.Store) error {
+    if c.TokenFrom == nil {
+        return nil
+    }
+    token, err := secrets.Resolve(context.Background(), c.TokenFrom, store)
+    if err != nil {
+        return fmt.Errorf("phpipam: tokenFrom: %v", err)
+    }
+    c.Token = token
+    return nil
+}
@@ -0,0 +1,182 @@
+package phpipam
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "path/filepath"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/state"
+)
+
+const stateDir = "phpipam"
+
+// allocation is what the node remembers about an address it created
+type allocation struct {
+    ID      string `json:"id"`
+    Address string `json:"address"`
+    Subnet  string `json:"subnet"`
+}
+
+// Allocator creates addresses in the phpIPAM subnet of a VLAN
+type Allocator struct {
+    conf   *Config
+    client *client
+    store  *state.Store
+}
+
+func init() {
+    ipam.Register(TypeName, func(raw []byte, store *state.Store) (ipam.Driver, error) {
+        conf, err := ParseConfig(raw)
+        if err != nil {
+            return nil, err
+        }
+        if err := conf.resolveCredentials(store); err != nil {
+            return nil, err
+        }
+        return New(conf, store), nil
+    })
+}
+
+// New returns an allocator for the given configuration
+func New(conf *Config, store *state.Store) *Allocator {
+    return &Allocator{conf: conf, client: newClient(conf), store: store}
+}
+
+// Allocate takes the first free address of the selected subnet. Repeated
+// calls for the same attachment return the same address.
+func (a *Allocator) Allocate(ctx context.Context, req *ipam.Request) (*current.Result, error) {
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req), alloc); err != nil {
+        return nil, err
+    }
+    
+    if alloc.ID == "" {
+        sub, err := a.pickSubnet(ctx, req)
+        if err != nil {
+            return nil, err
+        }
+        
+        hostname := req.PodName
+        if req.PodNamespace != "" {
+            hostname = req.PodName + "." + req.PodNamespace
+        }
+        id, address, err := a.client.firstFree(ctx, sub.ID, hostname, description(req), req.MAC)
+        if err != nil {
+            return nil, err
+        }
+        alloc = &allocation{ID: id, Address: address, Subnet: sub.Subnet + "/" + sub.Mask}
+        
+        if err := a.store.Save(allocationName(req), alloc); err != nil {
+            _ = a.client.deleteAddress(ctx, alloc.ID)
+            return nil, err
+        }
+    }
+    
+    return a.result(alloc)
+}
+
+// Release deletes the attachment's address
+func (a *Allocator) Release(ctx context.Context, req *ipam.Request) error {
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req), alloc); err != nil {
+        return err
+    }
+    if alloc.ID == "" {
+        return nil
+    }
+    
+    if err := a.client.deleteAddress(ctx, alloc.ID); err != nil {
+        return err
+    }
+    return a.store.Remove(allocationName(req))
+}
+
+// Check verifies the node still records an address for the attachment
+func (a *Allocator) Check(ctx context.Context, req *ipam.Request) error {
+    alloc := &allocation{}
+    if err := a.store.Load(allocationName(req), alloc); err != nil {
+        return err
+    }
+    if alloc.ID == "" {
+        return fmt.Errorf("phpipam: no address allocated for %s", req.Key())
+    }
+    return nil
+}
+
+// Health lists sections to confirm the API and token work
+func (a *Allocator) Health(ctx context.Context) error {
+    return a.client.ping(ctx)
+}
+
+// pickSubnet finds the subnet to allocate from: the pinned CIDR, or the
+// subnet attached to the VLAN
+func (a *Allocator) pickSubnet(ctx context.Context, req *ipam.Request) (*subnet, error) {
+    var candidates []subnet
+    
+    if a.conf.Subnet != "" {
+        found, err := a.client.searchSubnet(ctx, a.conf.Subnet)
+        if err != nil {
+            return nil, err
+        }
+        candidates = found
+    } else {
+        number := a.conf.VLAN
+        if number == 0 {
+            number = req.VlanID
+        }
+        vlans, err := a.client.findVLAN(ctx, number)
+        if err != nil {
+            return nil, err
+        }
+        for _, v := range vlans {
+            subnets, err := a.client.vlanSubnets(ctx, v.ID)
+            if err != nil {
+                return nil, err
+            }
+            candidates = append(candidates, subnets...)
+        }
+    }
+    
+    var matches []subnet
+    for _, sub := range candidates {
+        if a.conf.SectionID == "" || sub.SectionID == a.conf.SectionID {
+            matches = append(matches, sub)
+        }
+    }
+    if len(matches) != 1 {
+        return nil, fmt.Errorf("phpipam: expected one subnet for VLAN %d, found %d", req.VlanID, len(matches))
+    }
+    return &matches[0], nil
+}
+
+// result converts an allocation into a CNI result
+func (a *Allocator) result(alloc *allocation) (*current.Result, error) {
+    ip := net.ParseIP(alloc.Address)
+    _, network, err := net.ParseCIDR(alloc.Subnet)
+    if ip == nil || err != nil {
+        return nil, fmt.Errorf("phpipam: invalid address %q in subnet %q", alloc.Address, alloc.Subnet)
+    }
+    
+    ipc := &current.IPConfig{Address: net.IPNet{IP: ip, Mask: network.Mask}}
+    if a.conf.Gateway != "" {
+        ipc.Gateway = net.ParseIP(a.conf.Gateway)
+    }
+    
+    return &current.Result{
+        CNIVersion: current.ImplementedSpecVersion,
+        IPs:        []*current.IPConfig{ipc},
+    }, nil
+}
+
+func allocationName(req *ipam.Request) string {
+    return filepath.Join(stateDir, req.Key()+".json")
+}
+
+// description carries the pod UID so reservations can be traced to pods
+func description(req *ipam.Request) string {
+    return fmt.Sprintf("vlan-cni pod %s/%s uid=%s container=%s", req.PodNamespace, req.PodName, req.PodUID, req.Key())
+}
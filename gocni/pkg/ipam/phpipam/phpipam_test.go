@@ -0,0 +1,189 @@
+package phpipam
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+
+    "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// fakeSubnets are the subnets of the fake, by VLAN. VLAN 200 has one in
+// each of two sections.
+var fakeSubnets = map[string][]subnet{
+    "7": {{ID: "11", Subnet: "192.0.2.0", Mask: "24", SectionID: "1"}},
+    "8": {{ID: "12", Subnet: "198.51.100.0", Mask: "24", SectionID: "1"}, {ID: "13", Subnet: "203.0.113.0", Mask: "24", SectionID: "2"}},
+}
+
+// fakePHPIPAM serves VLAN and subnet lookups and address creation for app
+// "vlan-cni"
+type fakePHPIPAM struct {
+    mu        sync.Mutex
+    next      int
+    addresses map[string]string
+}
+
+func (f *fakePHPIPAM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    
+    reply := func(id int, data interface{}) {
+        raw, _ := json.Marshal(data)
+        json.NewEncoder(w).Encode(&envelope{Code: 200, Success: true, ID: json.Number(fmt.Sprint(id)), Data: raw})
+    }
+    path := strings.TrimPrefix(r.URL.Path, "/api/vlan-cni")
+    parts := strings.Split(strings.Trim(path, "/"), "/")
+    switch {
+    case r.Header.Get("token") != "t":
+        w.WriteHeader(http.StatusForbidden)
+        json.NewEncoder(w).Encode(&envelope{Code: 403, Message: "invalid token"})
+    case r.Method == http.MethodGet && path == "/sections/":
+        reply(0, []string{})
+    case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "vlan" && parts[1] == "search":
+        ids := map[string]string{"100": "7", "200": "8"}
+        if id, ok := ids[parts[2]]; ok {
+            reply(0, []vlan{{ID: id, Number: parts[2]}})
+            return
+        }
+        reply(0, []vlan{})
+    case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "vlan" && parts[2] == "subnets":
+        reply(0, fakeSubnets[parts[1]])
+    case r.Method == http.MethodGet && strings.HasPrefix(path, "/subnets/cidr/"):
+        cidr := strings.Trim(strings.TrimPrefix(path, "/subnets/cidr/"), "/")
+        found := []subnet{}
+        for _, subnets := range fakeSubnets {
+            for _, s := range subnets {
+                if s.Subnet+"/"+s.Mask == cidr {
+                    found = append(found, s)
+                }
+            }
+        }
+        reply(0, found)
+    case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "addresses" && parts[1] == "first_free":
+        for _, subnets := range fakeSubnets {
+            for _, s := range subnets {
+                if s.ID != parts[2] {
+                    continue
+                }
+                f.next++
+                addr := strings.TrimSuffix(s.Subnet, "0") + fmt.Sprint(f.next+9)
+                f.addresses[fmt.Sprint(f.next)] = addr
+                reply(f.next, addr)
+                return
+            }
+        }
+        w.WriteHeader(http.StatusNotFound)
+        json.NewEncoder(w).Encode(&envelope{Code: 404, Message: "subnet not found"})
+    case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "addresses":
+        if _, ok := f.addresses[parts[1]]; !ok {
+            w.WriteHeader(http.StatusNotFound)
+            json.NewEncoder(w).Encode(&envelope{Code: 404, Message: "address not found"})
+            return
+        }
+        delete(f.addresses, parts[1])
+        reply(0, nil)
+    default:
+        w.WriteHeader(http.StatusNotFound)
+        json.NewEncoder(w).Encode(&envelope{Code: 404, Message: "no route"})
+    }
+}
+
+func TestAllocate(t *testing.T) {
+    tests := []struct {
+        name  string
+        extra string
+        vlan  int
+        want  string
+    }{
+        {"subnet of the attachment's VLAN", ``, 100, "192.0.2.10/24"},
+        {"configured VLAN over the attachment's", `, "vlan": 100`, 300, "192.0.2.10/24"},
+        {"VLAN without a subnet", ``, 300, ""},
+        {"VLAN with a subnet per section", ``, 200, ""},
+        {"section narrows the VLAN's subnets", `, "sectionId": "2"`, 200, "203.0.113.10/24"},
+        {"pinned subnet", `, "subnet": "198.51.100.0/24"`, 100, "198.51.100.10/24"},
+        {"wrong token", `, "token": "x"`, 100, ""},
+    }
+    for _, tt := range tests {
+        fake := &fakePHPIPAM{addresses: map[string]string{}}
+        srv := httptest.NewServer(fake)
+        raw := `{"type": "phpipam", "url": "` + srv.URL + `", "app": "vlan-cni", "token": "t"` + tt.extra + `}`
+        if strings.Contains(tt.extra, `"token"`) {
+            raw = strings.Replace(raw, `, "token": "t"`, ``, 1)
+        }
+        conf, err := ParseConfig([]byte(raw))
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        store, err := state.NewStore(t.TempDir())
+        if err != nil {
+            t.Fatal(err)
+        }
+        a := New(conf, store)
+        
+        req := &ipam.Request{ContainerID: "c1", IfName: "net1", Network: "net", VlanID: tt.vlan}
+        result, err := a.Allocate(context.Background(), req)
+        switch {
+        case tt.want == "" && err == nil:
+            t.Errorf("%s: got %s, want an error", tt.name, result.IPs[0].Address.String())
+        case tt.want != "" && err != nil:
+            t.Errorf("%s: %v", tt.name, err)
+        case tt.want != "" && result.IPs[0].Address.String() != tt.want:
+            t.Errorf("%s: got %s, want %s", tt.name, result.IPs[0].Address.String(), tt.want)
+        }
+        if err == nil && len(fake.addresses) != 1 {
+            t.Errorf("%s: phpIPAM holds %d addresses, want 1", tt.name, len(fake.addresses))
+        }
+        srv.Close()
+    }
+}
+
+// TestAllocateRelease repeats ADD and DEL, as runtimes do
+func TestAllocateRelease(t *testing.T) {
+    fake := &fakePHPIPAM{addresses: map[string]string{}}
+    srv := httptest.NewServer(fake)
+    defer srv.Close()
+    conf, err := ParseConfig([]byte(`{"type": "phpipam", "url": "` + srv.URL + `", "app": "vlan-cni", "token": "t"}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    store, err := state.NewStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    a := New(conf, store)
+    ctx := context.Background()
+    req := &ipam.Request{ContainerID: "c1", IfName: "net1", Network: "net", VlanID: 100}
+    
+    first, err := a.Allocate(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    again, err := a.Allocate(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first.IPs[0].Address.String() != again.IPs[0].Address.String() || len(fake.addresses) != 1 {
+        t.Errorf("repeated ADD got %s after %s with %d addresses, want the same one", again.IPs[0].Address.String(), first.IPs[0].Address.String(), len(fake.addresses))
+    }
+    if err := a.Check(ctx, req); err != nil {
+        t.Errorf("Check: %v", err)
+    }
+    
+    for i := 0; i < 2; i++ {
+        if err := a.Release(ctx, req); err != nil {
+            t.Fatalf("Release %d: %v", i, err)
+        }
+    }
+    if len(fake.addresses) != 0 {
+        t.Errorf("phpIPAM holds %d addresses after DEL, want none", len(fake.addresses))
+    }
+    if err := a.Check(ctx, req); err == nil {
+        t.Error("Check after DEL succeeded")
+    }
+}
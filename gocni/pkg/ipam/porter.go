@@ -0,0 +1,79 @@
+package ipam
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "strings"
+
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// pluginType is the type of this plugin's entries in network configs
+const pluginType = "vlan-cni"
+
+// Porter is implemented by drivers whose allocations can be exported and
+// restored, as done by vlan-cni ipam export and import
+type Porter interface {
+    // Export returns the network's allocations
+    Export(ctx context.Context, network string) ([]*vlanv1.Allocation, error)
+
+    // Import records the allocations in the network, skipping those it
+    // holds already. Any conflict fails the import as a whole with an
+    // *ImportConflicts; dryRun only looks for them.
+    Import(ctx context.Context, network string, allocations []*vlanv1.Allocation, dryRun bool) (imported int, err error)
+}
+
+// Conflict is an allocation that cannot be imported
+type Conflict struct {
+    Allocation *vlanv1.Allocation
+    Reason     string
+}
+
+// ImportConflicts lists every allocation that failed an import
+type ImportConflicts []Conflict
+
+func (c ImportConflicts) Error() string {
+    msgs := make([]string, 0, len(c))
+    for _, conflict := range c {
+        msgs = append(msgs, fmt.Sprintf("%s: %s", conflict.Allocation.IP, conflict.Reason))
+    }
+    return fmt.Sprintf("%d conflicting allocations: %s", len(c), strings.Join(msgs, "; "))
+}
+
+// Section returns the name and the ipam section of the network defined by
+// a conf or conflist document. Entries with attachments take the section
+// of the attachment named ifName.
+func Section(data []byte, ifName string) (string, []byte, error) {
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return "", nil, err
+    }
+    name, _ := doc["name"].(string)
+    plugins := []interface{}{doc}
+    if list, ok := doc["plugins"].([]interface{}); ok {
+        plugins = list
+    }
+    
+    for _, p := range plugins {
+        entry, ok := p.(map[string]interface{})
+        if !ok || entry["type"] != pluginType {
+            continue
+        }
+        section := entry["ipam"]
+        if list, ok := entry["attachments"].([]interface{}); ok && ifName != "" {
+            section = nil
+            for _, item := range list {
+                if sub, ok := item.(map[string]interface{}); ok && sub["ifName"] == ifName {
+                    section = sub["ipam"]
+                }
+            }
+        }
+        if section == nil {
+            return "", nil, fmt.Errorf("network %q has no ipam section", name)
+        }
+        raw, err := json.Marshal(section)
+        return name, raw, err
+    }
+    return "", nil, fmt.Errorf("no %s entry in network configuration", pluginType)
+}
@@ -0,0 +1,144 @@
+package remote
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/credentials/insecure"
+
+    "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/state"
+    "example.com/vlan-cni/pkg/tlsconf"
+)
+
+// TypeName selects an out-of-process driver as ipam.type
+const TypeName = "grpc"
+
+// Config is the ipam section for out-of-process drivers. Any other keys are
+// passed to the driver untouched.
+type Config struct {
+    Type string `json:"type"`
+
+    // Either a local unix socket or the host:port of a remote driver, which
+    // is always reached over TLS
+    Socket  string `json:"socket,omitempty"`
+    Address string `json:"address,omitempty"`
+
+    TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+    // TLS settings, also applied to the socket when set
+    TLS *tlsconf.Config `json:"tls,omitempty"`
+
+    tlsConfig *tls.Config
+}
+
+// ParseConfig decodes and validates the ipam section
+func ParseConfig(raw []byte) (*Config, error) {
+    conf := &Config{}
+    if err := json.Unmarshal(raw, conf); err != nil {
+        return nil, fmt.Errorf("grpc ipam: failed to parse ipam config: %v", err)
+    }
+    if (conf.Socket == "") == (conf.Address == "") {
+        return nil, fmt.Errorf("grpc ipam: exactly one of socket or address is required")
+    }
+    if conf.TLS != nil || conf.Address != "" {
+        tlsConfig, err := conf.TLS.ClientConfig(false)
+        if err != nil {
+            return nil, fmt.Errorf("grpc ipam: %v", err)
+        }
+        conf.tlsConfig = tlsConfig
+    }
+    return conf, nil
+}
+
+func (c *Config) timeout() time.Duration {
+    if c.TimeoutSeconds > 0 {
+        return time.Duration(c.TimeoutSeconds) * time.Second
+    }
+    return 30 * time.Second
+}
+
+func init() {
+    ipam.Register(TypeName, func(raw []byte, store *state.Store) (ipam.Driver, error) {
+        conf, err := ParseConfig(raw)
+        if err != nil {
+            return nil, err
+        }
+        return New(conf, raw), nil
+    })
+}
+
+// Client calls a driver daemon over its unix socket or TLS
+type Client struct {
+    conf *Config
+    raw  json.RawMessage
+}
+
+// New returns a client for the driver behind conf.Socket or conf.Address.
+// raw is the ipam section forwarded with every call.
+func New(conf *Config, raw []byte) *Client {
+    return &Client{conf: conf, raw: raw}
+}
+
+// Allocate asks the driver for the attachment's addresses
+func (c *Client) Allocate(ctx context.Context, req *ipam.Request) (*current.Result, error) {
+    resp := &AllocateResponse{}
+    if err := c.call(ctx, methodAllocate, &AllocateRequest{Config: c.raw, Attachment: req}, resp); err != nil {
+        return nil, err
+    }
+    if resp.Result == nil {
+        return nil, fmt.Errorf("grpc ipam: driver returned no result")
+    }
+    return resp.Result, nil
+}
+
+// Release asks the driver to free the attachment's addresses
+func (c *Client) Release(ctx context.Context, req *ipam.Request) error {
+    return c.call(ctx, methodRelease, &AttachmentRequest{Config: c.raw, Attachment: req}, &Empty{})
+}
+
+// Check asks the driver whether the attachment still holds its addresses
+func (c *Client) Check(ctx context.Context, req *ipam.Request) error {
+    return c.call(ctx, methodCheck, &AttachmentRequest{Config: c.raw, Attachment: req}, &Empty{})
+}
+
+// Health asks the driver whether it can serve allocations
+func (c *Client) Health(ctx context.Context) error {
+    return c.call(ctx, methodHealth, &HealthRequest{Config: c.raw}, &Empty{})
+}
+
+// call dials the socket for a single RPC; plugin invocations are short-lived
+// so there is no connection to keep
+func (c *Client) call(ctx context.Context, method string, req, resp interface{}) error {
+    ctx, cancel := context.WithTimeout(ctx, c.conf.timeout())
+    defer cancel()
+    
+    target := c.conf.Address
+    if c.conf.Socket != "" {
+        target = "unix://" + c.conf.Socket
+    }
+    creds := insecure.NewCredentials()
+    if c.conf.tlsConfig != nil {
+        creds = credentials.NewTLS(c.conf.tlsConfig)
+    }
+    
+    conn, err := grpc.DialContext(ctx, target,
+        grpc.WithTransportCredentials(creds),
+        grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+    )
+    if err != nil {
+        return fmt.Errorf("grpc ipam: failed to dial %s: %v", target, err)
+    }
+    defer conn.Close()
+    
+    if err := conn.Invoke(ctx, fullMethod(method), req, resp); err != nil {
+        return fmt.Errorf("grpc ipam: %s failed: %v", method, err)
+    }
+    return nil
+}
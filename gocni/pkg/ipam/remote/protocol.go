@@ -0,0 +1,88 @@
+// Package remote implements the out-of-process IPAM driver protocol: a small
+// gRPC service, served on a unix socket by a node daemon, that the plugin
+// calls for ipam.type "grpc". Messages are JSON encoded, so drivers need no
+// generated code; any gRPC implementation that registers a codec for the
+// content-subtype "json" can serve it. protocol.schema.json describes the
+// messages. Go drivers pass ServerCodec to grpc.NewServer.
+//
+// Service vlancni.ipam.v1.Driver:
+//
+//    Allocate(AllocateRequest) returns (AllocateResponse)
+//    Release(AttachmentRequest) returns (Empty)
+//    Check(AttachmentRequest) returns (Empty)
+//    Health(HealthRequest) returns (Empty)
+//
+// Every request carries the network's ipam section verbatim so drivers can
+// read their own options from it.
+package remote
+
+import (
+    "encoding/json"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "google.golang.org/grpc"
+
+    "example.com/vlan-cni/pkg/ipam"
+)
+
+const (
+    serviceName = "vlancni.ipam.v1.Driver"
+    codecName   = "json"
+
+    methodAllocate = "Allocate"
+    methodRelease  = "Release"
+    methodCheck    = "Check"
+    methodHealth   = "Health"
+)
+
+// AllocateRequest asks for the attachment's addresses
+type AllocateRequest struct {
+    Config     json.RawMessage `json:"config"`
+    Attachment *ipam.Request   `json:"attachment"`
+}
+
+// AllocateResponse carries the CNI result for the attachment
+type AllocateResponse struct {
+    Result *current.Result `json:"result"`
+}
+
+// AttachmentRequest names an attachment for Release and Check
+type AttachmentRequest struct {
+    Config     json.RawMessage `json:"config"`
+    Attachment *ipam.Request   `json:"attachment"`
+}
+
+// HealthRequest asks whether the driver can serve the given configuration
+type HealthRequest struct {
+    Config json.RawMessage `json:"config"`
+}
+
+// Empty is the response of calls that only report success
+type Empty struct{}
+
+func fullMethod(method string) string {
+    return "/" + serviceName + "/" + method
+}
+
+// jsonCodec encodes protocol messages as JSON. It is given to the calls and
+// servers of this protocol rather than registered, which would take the
+// "json" content-subtype for every gRPC service in the process.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+    return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+    return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+    return codecName
+}
+
+// ServerCodec makes a gRPC server decode and encode messages as JSON. The
+// server should only serve this protocol.
+func ServerCodec() grpc.ServerOption {
+    return grpc.ForceServerCodec(jsonCodec{})
+}
@@ -0,0 +1,218 @@
+package remote
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/encoding"
+
+    "example.com/vlan-cni/pkg/ipam"
+)
+
+// fakeDriver holds one address per attachment, and refuses untagged ones
+type fakeDriver struct {
+    mu    sync.Mutex
+    held  map[string]bool
+    extra string
+}
+
+func (d *fakeDriver) Allocate(ctx context.Context, req *ipam.Request) (*current.Result, error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if req.VlanID == 0 {
+        return nil, fmt.Errorf("no pool for untagged attachments")
+    }
+    d.held[req.Key()] = true
+    _, ipnet, _ := net.ParseCIDR("192.0.2.10/24")
+    ipnet.IP = net.ParseIP("192.0.2.10")
+    return &current.Result{CNIVersion: current.ImplementedSpecVersion, IPs: []*current.IPConfig{{Address: *ipnet}}}, nil
+}
+
+func (d *fakeDriver) Release(ctx context.Context, req *ipam.Request) error {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    delete(d.held, req.Key())
+    return nil
+}
+
+func (d *fakeDriver) Check(ctx context.Context, req *ipam.Request) error {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if !d.held[req.Key()] {
+        return fmt.Errorf("no address allocated for %s", req.Key())
+    }
+    return nil
+}
+
+func (d *fakeDriver) Health(ctx context.Context) error {
+    if d.extra != "x" {
+        return fmt.Errorf("got option %q, want the ipam section's", d.extra)
+    }
+    return nil
+}
+
+// serveFake serves a fake driver on a unix socket and returns a client of it
+func serveFake(t *testing.T) *Client {
+    t.Helper()
+    socket := filepath.Join(t.TempDir(), "driver.sock")
+    l, err := net.Listen("unix", socket)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := grpc.NewServer(ServerCodec())
+    driver := &fakeDriver{held: map[string]bool{}}
+    RegisterServer(s, func(config json.RawMessage) (ipam.Driver, error) {
+        var opts struct {
+            Extra string `json:"extra"`
+        }
+        if err := json.Unmarshal(config, &opts); err != nil {
+            return nil, err
+        }
+        driver.extra = opts.Extra
+        return driver, nil
+    })
+    go s.Serve(l)
+    t.Cleanup(s.Stop)
+    
+    raw := []byte(`{"type": "grpc", "socket": "` + socket + `", "extra": "x"}`)
+    conf, err := ParseConfig(raw)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return New(conf, raw)
+}
+
+// TestCodecScoped checks the JSON codec is not registered for the process
+func TestCodecScoped(t *testing.T) {
+    if c := encoding.GetCodec(codecName); c != nil {
+        t.Errorf("a %q codec is registered globally", codecName)
+    }
+}
+
+// TestClientServer runs a sequence of calls through the client, checking
+// results and driver errors both cross the socket
+func TestClientServer(t *testing.T) {
+    c := serveFake(t)
+    tagged := &ipam.Request{ContainerID: "c1", IfName: "net1", Network: "net", VlanID: 100}
+    untagged := &ipam.Request{ContainerID: "c2", IfName: "net1", Network: "net"}
+    
+    tests := []struct {
+        call string
+        req  *ipam.Request
+        want string
+    }{
+        {"allocate", tagged, "192.0.2.10/24"},
+        {"check", tagged, "ok"},
+        {"health", nil, "ok"},
+        {"allocate", untagged, "no pool for untagged attachments"},
+        {"check", untagged, "no address allocated for c2-net1"},
+        {"release", tagged, "ok"},
+        {"check", tagged, "no address allocated for c1-net1"},
+    }
+    for i, tt := range tests {
+        ctx := context.Background()
+        var err error
+        got := "ok"
+        switch tt.call {
+        case "allocate":
+            var result *current.Result
+            if result, err = c.Allocate(ctx, tt.req); err == nil {
+                got = result.IPs[0].Address.String()
+            }
+        case "release":
+            err = c.Release(ctx, tt.req)
+        case "check":
+            err = c.Check(ctx, tt.req)
+        case "health":
+            err = c.Health(ctx)
+        }
+        if err != nil {
+            got = err.Error()
+        }
+        if !strings.Contains(got, tt.want) {
+            t.Errorf("%d: %s: got %q, want %q", i, tt.call, got, tt.want)
+        }
+    }
+}
+
+// TestSchema checks protocol.schema.json describes the message types
+func TestSchema(t *testing.T) {
+    data, err := os.ReadFile("protocol.schema.json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var schema struct {
+        Title string `json:"title"`
+        Defs  map[string]struct {
+            Properties map[string]json.RawMessage `json:"properties"`
+            Required   []string                   `json:"required"`
+        } `json:"$defs"`
+    }
+    if err := json.Unmarshal(data, &schema); err != nil {
+        t.Fatalf("invalid schema: %v", err)
+    }
+    if schema.Title != serviceName {
+        t.Errorf("schema is titled %q, want %q", schema.Title, serviceName)
+    }
+    
+    for name, msg := range map[string]interface{}{
+        "AllocateRequest":   AllocateRequest{},
+        "AllocateResponse":  AllocateResponse{},
+        "AttachmentRequest": AttachmentRequest{},
+        "HealthRequest":     HealthRequest{},
+        "Empty":             Empty{},
+        "Attachment":        ipam.Request{},
+    } {
+        def, ok := schema.Defs[name]
+        if !ok {
+            t.Errorf("%s: not in the schema", name)
+            continue
+        }
+        props := []string{}
+        for p := range def.Properties {
+            props = append(props, p)
+        }
+        sort.Strings(props)
+        if def.Required == nil {
+            def.Required = []string{}
+        }
+        sort.Strings(def.Required)
+        fields, required := jsonFields(reflect.TypeOf(msg))
+        if !reflect.DeepEqual(props, fields) {
+            t.Errorf("%s: schema has properties %v, type has %v", name, props, fields)
+        }
+        if !reflect.DeepEqual(def.Required, required) {
+            t.Errorf("%s: schema requires %v, type %v", name, def.Required, required)
+        }
+    }
+}
+
+// jsonFields returns the sorted names of a struct's encoded fields, and of
+// those without omitempty
+func jsonFields(typ reflect.Type) ([]string, []string) {
+    fields, required := []string{}, []string{}
+    for i := 0; i < typ.NumField(); i++ {
+        parts := strings.Split(typ.Field(i).Tag.Get("json"), ",")
+        if parts[0] == "-" || parts[0] == "" {
+            continue
+        }
+        fields = append(fields, parts[0])
+        if len(parts) == 1 || parts[1] != "omitempty" {
+            required = append(required, parts[0])
+        }
+    }
+    sort.Strings(fields)
+    sort.Strings(required)
+    return fields, required
+}
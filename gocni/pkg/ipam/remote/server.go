@@ -0,0 +1,110 @@
+package remote
+
+import (
+    "context"
+    "encoding/json"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "example.com/vlan-cni/pkg/ipam"
+)
+
+// DriverFactory builds the driver serving a call from the ipam section the
+// plugin forwarded
+type DriverFactory func(config json.RawMessage) (ipam.Driver, error)
+
+// RegisterServer adds the driver service to s, which must have been created
+// with ServerCodec. Driver authors embed this in their daemon and serve s on
+// a unix socket.
+func RegisterServer(s *grpc.Server, factory DriverFactory) {
+    s.RegisterService(&grpc.ServiceDesc{
+        ServiceName: serviceName,
+        HandlerType: (*interface{})(nil),
+        Methods: []grpc.MethodDesc{
+            {MethodName: methodAllocate, Handler: handler(factory, methodAllocate, allocate)},
+            {MethodName: methodRelease, Handler: handler(factory, methodRelease, release)},
+            {MethodName: methodCheck, Handler: handler(factory, methodCheck, check)},
+            {MethodName: methodHealth, Handler: handler(factory, methodHealth, health)},
+        },
+        Streams:  []grpc.StreamDesc{},
+        Metadata: "vlancni/ipam/v1",
+    }, struct{}{})
+}
+
+// rpc is one method body: it decodes its request and calls the driver
+type rpc func(ctx context.Context, factory DriverFactory, dec func(interface{}) error) (interface{}, error)
+
+func handler(factory DriverFactory, method string, call rpc) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+    return func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+        if interceptor == nil {
+            return call(ctx, factory, dec)
+        }
+        info := &grpc.UnaryServerInfo{FullMethod: fullMethod(method)}
+        return interceptor(ctx, nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+            return call(ctx, factory, dec)
+        })
+    }
+}
+
+func allocate(ctx context.Context, factory DriverFactory, dec func(interface{}) error) (interface{}, error) {
+    req := &AllocateRequest{}
+    driver, err := decode(factory, dec, req, &req.Config)
+    if err != nil {
+        return nil, err
+    }
+    result, err := driver.Allocate(ctx, req.Attachment)
+    if err != nil {
+        return nil, status.Error(codes.Unavailable, err.Error())
+    }
+    return &AllocateResponse{Result: result}, nil
+}
+
+func release(ctx context.Context, factory DriverFactory, dec func(interface{}) error) (interface{}, error) {
+    req := &AttachmentRequest{}
+    driver, err := decode(factory, dec, req, &req.Config)
+    if err != nil {
+        return nil, err
+    }
+    if err := driver.Release(ctx, req.Attachment); err != nil {
+        return nil, status.Error(codes.Unavailable, err.Error())
+    }
+    return &Empty{}, nil
+}
+
+func check(ctx context.Context, factory DriverFactory, dec func(interface{}) error) (interface{}, error) {
+    req := &AttachmentRequest{}
+    driver, err := decode(factory, dec, req, &req.Config)
+    if err != nil {
+        return nil, err
+    }
+    if err := driver.Check(ctx, req.Attachment); err != nil {
+        return nil, status.Error(codes.FailedPrecondition, err.Error())
+    }
+    return &Empty{}, nil
+}
+
+func health(ctx context.Context, factory DriverFactory, dec func(interface{}) error) (interface{}, error) {
+    req := &HealthRequest{}
+    driver, err := decode(factory, dec, req, &req.Config)
+    if err != nil {
+        return nil, err
+    }
+    if err := driver.Health(ctx); err != nil {
+        return nil, status.Error(codes.Unavailable, err.Error())
+    }
+    return &Empty{}, nil
+}
+
+// decode reads a request and builds the driver for its configuration
+func decode(factory DriverFactory, dec func(interface{}) error, req interface{}, config *json.RawMessage) (ipam.Driver, error) {
+    if err := dec(req); err != nil {
+        return nil, status.Errorf(codes.InvalidArgument, "failed to decode request: %v", err)
+    }
+    driver, err := factory(*config)
+    if err != nil {
+        return nil, status.Errorf(codes.InvalidArgument, "invalid driver config: %v", err)
+    }
+    return driver, nil
+}
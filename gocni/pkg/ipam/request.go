@@ -0,0 +1,23 @@
+package ipam
+
+// Request identifies the attachment an IPAM driver allocates for
+type Request struct {
+    ContainerID  string `json:"containerID"`
+    IfName       string `json:"ifName"`
+    Network      string `json:"network"`
+    PodName      string `json:"podName,omitempty"`
+    PodNamespace string `json:"podNamespace,omitempty"`
+    PodUID       string `json:"podUID,omitempty"`
+    NodeName     string `json:"nodeName,omitempty"`
+    MAC          string `json:"mac,omitempty"`
+    VlanID       int    `json:"vlanID"`
+
+    // NodeName is the host name, the invocation naming no node. Drivers
+    // that act on everything tagged with the node must not trust it.
+    GuessedNodeName bool `json:"-"`
+}
+
+// Key is a stable identifier for the attachment, used to name cached state
+func (r *Request) Key() string {
+    return r.ContainerID + "-" + r.IfName
+}
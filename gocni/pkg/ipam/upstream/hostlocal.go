@@ -0,0 +1,63 @@
+// Package upstream reads the allocations of other IPAM plugins, for
+// importing them into vlan-cni's: host-local's files and whereabouts'
+// IPPools.
+package upstream
+
+import (
+    "fmt"
+    "io/ioutil"
+    "net/netip"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// DefaultHostLocalDir is where host-local keeps a directory per network
+const DefaultHostLocalDir = "/var/lib/cni/networks"
+
+// ReadHostLocal returns the allocations host-local recorded for a network
+// under dir. Each address is a file holding the container ID and, since
+// plugins v0.8, the interface name on a second line.
+func ReadHostLocal(dir, network string) ([]*vlanv1.Allocation, error) {
+    if dir == "" {
+        dir = DefaultHostLocalDir
+    }
+    path := filepath.Join(dir, network)
+    files, err := ioutil.ReadDir(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read host-local allocations: %v", err)
+    }
+    
+    var allocations []*vlanv1.Allocation
+    for _, f := range files {
+        // Skips last_reserved_ip.N and the lock
+        addr, err := netip.ParseAddr(f.Name())
+        if err != nil || f.IsDir() {
+            continue
+        }
+        data, err := ioutil.ReadFile(filepath.Join(path, f.Name()))
+        if err != nil {
+            return nil, fmt.Errorf("failed to read host-local allocation %s: %v", addr, err)
+        }
+        lines := strings.Split(strings.ReplaceAll(string(data), "\r", ""), "\n")
+        a := &vlanv1.Allocation{IP: addr.String(), ContainerID: strings.TrimSpace(lines[0])}
+        if len(lines) > 1 {
+            a.IfName = strings.TrimSpace(lines[1])
+        }
+        modified := f.ModTime().UTC()
+        a.Allocated = &modified
+        allocations = append(allocations, a)
+    }
+    sortByIP(allocations)
+    return allocations, nil
+}
+
+func sortByIP(allocations []*vlanv1.Allocation) {
+    sort.Slice(allocations, func(i, j int) bool {
+        a, _ := netip.ParseAddr(allocations[i].IP)
+        b, _ := netip.ParseAddr(allocations[j].IP)
+        return a.Less(b)
+    })
+}
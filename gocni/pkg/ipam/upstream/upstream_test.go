@@ -0,0 +1,114 @@
+package upstream
+
+import (
+    "context"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestReadHostLocal(t *testing.T) {
+    tests := []struct {
+        name  string
+        files map[string]string
+        want  []string
+    }{
+        {"container and interface", map[string]string{"10.0.0.2": "c1\r\nnet1"}, []string{"10.0.0.2 c1 net1"}},
+        {"container only, before plugins v0.8", map[string]string{"10.0.0.2": "c1"}, []string{"10.0.0.2 c1 "}},
+        {"skips bookkeeping", map[string]string{"last_reserved_ip.0": "10.0.0.3", "lock": "", "10.0.0.3": "c2\nnet1"}, []string{"10.0.0.3 c2 net1"}},
+        {"sorted by address", map[string]string{"10.0.0.10": "c2\nnet1", "10.0.0.9": "c1\nnet1", "fd00::2": "c3\nnet1"}, []string{"10.0.0.9 c1 net1", "10.0.0.10 c2 net1", "fd00::2 c3 net1"}},
+    }
+    for _, tt := range tests {
+        dir := t.TempDir()
+        if err := os.Mkdir(filepath.Join(dir, "net"), 0755); err != nil {
+            t.Fatal(err)
+        }
+        for name, data := range tt.files {
+            if err := ioutil.WriteFile(filepath.Join(dir, "net", name), []byte(data), 0644); err != nil {
+                t.Fatal(err)
+            }
+        }
+        allocations, err := ReadHostLocal(dir, "net")
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        var got []string
+        for _, a := range allocations {
+            got = append(got, a.IP+" "+a.ContainerID+" "+a.IfName)
+            if a.Allocated == nil {
+                t.Errorf("%s: %s has no allocation time", tt.name, a.IP)
+            }
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+        }
+    }
+    
+    if _, err := ReadHostLocal(t.TempDir(), "missing"); err == nil {
+        t.Error("missing network: got no error")
+    }
+}
+
+func ipPool(name, r string, allocations map[string]interface{}) runtime.Object {
+    return &unstructured.Unstructured{Object: map[string]interface{}{
+        "apiVersion": "whereabouts.cni.cncf.io/v1alpha1",
+        "kind":       "IPPool",
+        "metadata":   map[string]interface{}{"name": name, "namespace": "kube-system"},
+        "spec":       map[string]interface{}{"range": r, "allocations": allocations},
+    }}
+}
+
+func entry(id, ifName, podRef string) map[string]interface{} {
+    return map[string]interface{}{"id": id, "ifname": ifName, "podref": podRef}
+}
+
+func TestReadWhereabouts(t *testing.T) {
+    pools := []runtime.Object{
+        ipPool("10.0.0.0-24", "10.0.0.0/24", map[string]interface{}{"5": entry("c1", "net1", "default/a"), "1": entry("c2", "net1", "default/b")}),
+        ipPool("10.0.1.0-23", "10.0.1.0/23", map[string]interface{}{"256": entry("c3", "net2", "default/c")}),
+        ipPool("fd00-64", "fd00::/64", map[string]interface{}{"255": entry("c4", "net1", "default/d")}),
+    }
+    tests := []struct {
+        name  string
+        cidr  string
+        pools []runtime.Object
+        want  []string
+        err   bool
+    }{
+        {"all pools", "", pools, []string{"10.0.0.1 c2 net1 default/b", "10.0.0.5 c1 net1 default/a", "10.0.1.0 c3 net2 default/c", "fd00::ff c4 net1 default/d"}, false},
+        // The range is unmasked, so offsets count from the network address
+        {"one range", "10.0.1.0/23", pools, []string{"10.0.1.0 c3 net2 default/c"}, false},
+        {"no such range", "10.9.0.0/16", pools, nil, false},
+        {"invalid range", "", []runtime.Object{ipPool("bad", "10.0.0.0", nil)}, nil, true},
+        {"invalid offset", "", []runtime.Object{ipPool("bad", "10.0.0.0/24", map[string]interface{}{"x": entry("c1", "net1", "")})}, nil, true},
+    }
+    for _, tt := range tests {
+        dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{ipPoolGVR: "IPPoolList"}, tt.pools...)
+        allocations, err := ReadWhereabouts(context.Background(), dyn, "kube-system", tt.cidr)
+        if tt.err {
+            if err == nil {
+                t.Errorf("%s: got no error", tt.name)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        var got []string
+        for _, a := range allocations {
+            got = append(got, a.IP+" "+a.ContainerID+" "+a.IfName+" "+a.Pod)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
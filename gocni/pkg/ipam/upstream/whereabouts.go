@@ -0,0 +1,67 @@
+package upstream
+
+import (
+    "context"
+    "fmt"
+    "math/big"
+    "net/netip"
+    "strconv"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+var ipPoolGVR = schema.GroupVersionResource{Group: "whereabouts.cni.cncf.io", Version: "v1alpha1", Resource: "ippools"}
+
+// ReadWhereabouts returns the allocations of the whereabouts IPPools in
+// namespace whose range is cidr, or of all of them when cidr is empty.
+// Pools record allocations by their offset from the range's first address.
+func ReadWhereabouts(ctx context.Context, dyn dynamic.Interface, namespace, cidr string) ([]*vlanv1.Allocation, error) {
+    list, err := dyn.Resource(ipPoolGVR).Namespace(namespace).List(ctx, metav1.ListOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to list whereabouts IPPools: %v", err)
+    }
+    
+    var allocations []*vlanv1.Allocation
+    for _, pool := range list.Items {
+        r, _, _ := unstructured.NestedString(pool.Object, "spec", "range")
+        if cidr != "" && r != cidr {
+            continue
+        }
+        prefix, err := netip.ParsePrefix(r)
+        if err != nil {
+            return nil, fmt.Errorf("IPPool %s: invalid range %q: %v", pool.GetName(), r, err)
+        }
+        entries, _, _ := unstructured.NestedMap(pool.Object, "spec", "allocations")
+        for offset, v := range entries {
+            n, err := strconv.ParseInt(offset, 10, 64)
+            if err != nil {
+                return nil, fmt.Errorf("IPPool %s: invalid offset %q", pool.GetName(), offset)
+            }
+            fields, _ := v.(map[string]interface{})
+            id, _ := fields["id"].(string)
+            ifName, _ := fields["ifname"].(string)
+            podRef, _ := fields["podref"].(string)
+            allocations = append(allocations, &vlanv1.Allocation{
+                IP:          addOffset(prefix.Masked().Addr(), n).String(),
+                ContainerID: id,
+                IfName:      ifName,
+                Pod:         podRef,
+            })
+        }
+    }
+    sortByIP(allocations)
+    return allocations, nil
+}
+
+func addOffset(addr netip.Addr, n int64) netip.Addr {
+    sum := new(big.Int).Add(new(big.Int).SetBytes(addr.AsSlice()), big.NewInt(n))
+    b := make([]byte, addr.BitLen()/8)
+    sum.FillBytes(b)
+    next, _ := netip.AddrFromSlice(b)
+    return next
+}
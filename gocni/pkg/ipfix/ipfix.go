@@ -0,0 +1,228 @@
+// Package ipfix exports flow records to an IPFIX collector (RFC 7011) over
+// UDP. Every message carries the templates, as collectors restarting
+// between template refreshes otherwise drop records until the next one.
+package ipfix
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "net"
+    "time"
+)
+
+// Record is one direction of a flow over an interval
+type Record struct {
+    Src, Dst         net.IP
+    SrcPort, DstPort uint16
+    Protocol         uint8
+    Octets, Packets  uint64
+    VlanID           uint16
+    Start, End       time.Time
+
+    // Interface and "namespace/pod" the flow belongs to, exported as
+    // interfaceName and interfaceDescription
+    IfName string
+    Pod    string
+}
+
+// Information elements of the IANA registry
+const (
+    ieOctetDeltaCount          = 1
+    iePacketDeltaCount         = 2
+    ieProtocolIdentifier       = 4
+    ieSourceTransportPort      = 7
+    ieSourceIPv4Address        = 8
+    ieDestinationTransportPort = 11
+    ieDestinationIPv4Address   = 12
+    ieSourceIPv6Address        = 27
+    ieDestinationIPv6Address   = 28
+    ieVlanID                   = 58
+    ieInterfaceName            = 82
+    ieInterfaceDescription     = 83
+    ieFlowStartMilliseconds    = 152
+    ieFlowEndMilliseconds      = 153
+)
+
+const (
+    version          = 10
+    templateSetID    = 2
+    templateIPv4     = 256
+    templateIPv6     = 257
+    variableLength   = 0xffff
+    headerLen        = 16
+    maxMessageLength = 1400
+)
+
+type field struct {
+    id, length uint16
+}
+
+func template(addrLen uint16) []field {
+    src, dst := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address)
+    if addrLen == net.IPv6len {
+        src, dst = ieSourceIPv6Address, ieDestinationIPv6Address
+    }
+    return []field{
+        {src, addrLen},
+        {dst, addrLen},
+        {ieSourceTransportPort, 2},
+        {ieDestinationTransportPort, 2},
+        {ieProtocolIdentifier, 1},
+        {ieOctetDeltaCount, 8},
+        {iePacketDeltaCount, 8},
+        {ieVlanID, 2},
+        {ieFlowStartMilliseconds, 8},
+        {ieFlowEndMilliseconds, 8},
+        {ieInterfaceName, variableLength},
+        {ieInterfaceDescription, variableLength},
+    }
+}
+
+// Exporter sends records to one collector
+type Exporter struct {
+    conn      net.Conn
+    domain    uint32
+    sequence  uint32
+    templates []byte
+}
+
+// Dial connects to the collector at host:port
+func Dial(addr string, domain uint32) (*Exporter, error) {
+    conn, err := net.Dial("udp", addr)
+    if err != nil {
+        return nil, fmt.Errorf("failed to connect to IPFIX collector %q: %v", addr, err)
+    }
+    e := &Exporter{conn: conn, domain: domain}
+    
+    var set bytes.Buffer
+    for _, t := range []struct {
+        id      uint16
+        addrLen uint16
+    }{{templateIPv4, net.IPv4len}, {templateIPv6, net.IPv6len}} {
+        fields := template(t.addrLen)
+        writeUint16(&set, t.id, uint16(len(fields)))
+        for _, f := range fields {
+            writeUint16(&set, f.id, f.length)
+        }
+    }
+    e.templates = setBytes(templateSetID, set.Bytes())
+    return e, nil
+}
+
+// Close closes the connection
+func (e *Exporter) Close() error {
+    return e.conn.Close()
+}
+
+// Export sends the records, in as many messages as they need
+func (e *Exporter) Export(records []Record) error {
+    var v4, v6 bytes.Buffer
+    count := 0
+    flush := func() error {
+        if count == 0 {
+            return nil
+        }
+        err := e.send(&v4, &v6, count)
+        v4.Reset()
+        v6.Reset()
+        count = 0
+        return err
+    }
+    
+    for i := range records {
+        r := &records[i]
+        buf, rec := &v4, encode(r)
+        if r.Src.To4() == nil {
+            buf = &v6
+        }
+        if headerLen+len(e.templates)+8+v4.Len()+v6.Len()+len(rec) > maxMessageLength {
+            if err := flush(); err != nil {
+                return err
+            }
+        }
+        buf.Write(rec)
+        count++
+    }
+    return flush()
+}
+
+func (e *Exporter) send(v4, v6 *bytes.Buffer, count int) error {
+    var body bytes.Buffer
+    body.Write(e.templates)
+    if v4.Len() > 0 {
+        body.Write(setBytes(templateIPv4, v4.Bytes()))
+    }
+    if v6.Len() > 0 {
+        body.Write(setBytes(templateIPv6, v6.Bytes()))
+    }
+    
+    msg := make([]byte, headerLen, headerLen+body.Len())
+    binary.BigEndian.PutUint16(msg[0:2], version)
+    binary.BigEndian.PutUint16(msg[2:4], uint16(headerLen+body.Len()))
+    binary.BigEndian.PutUint32(msg[4:8], uint32(time.Now().Unix()))
+    binary.BigEndian.PutUint32(msg[8:12], e.sequence)
+    binary.BigEndian.PutUint32(msg[12:16], e.domain)
+    msg = append(msg, body.Bytes()...)
+    
+    // The sequence counts data records sent before this message
+    e.sequence += uint32(count)
+    if _, err := e.conn.Write(msg); err != nil {
+        return fmt.Errorf("failed to send IPFIX message: %v", err)
+    }
+    return nil
+}
+
+// encode lays out a record in the field order of its template
+func encode(r *Record) []byte {
+    var b bytes.Buffer
+    if src4, dst4 := r.Src.To4(), r.Dst.To4(); src4 != nil && dst4 != nil {
+        b.Write(src4)
+        b.Write(dst4)
+    } else {
+        b.Write(r.Src.To16())
+        b.Write(r.Dst.To16())
+    }
+    writeUint16(&b, r.SrcPort, r.DstPort)
+    b.WriteByte(r.Protocol)
+    writeUint64(&b, r.Octets, r.Packets)
+    writeUint16(&b, r.VlanID)
+    writeUint64(&b, uint64(r.Start.UnixMilli()), uint64(r.End.UnixMilli()))
+    writeString(&b, r.IfName)
+    writeString(&b, r.Pod)
+    return b.Bytes()
+}
+
+func setBytes(id uint16, records []byte) []byte {
+    var b bytes.Buffer
+    writeUint16(&b, id, uint16(4+len(records)))
+    b.Write(records)
+    return b.Bytes()
+}
+
+func writeUint16(b *bytes.Buffer, vs ...uint16) {
+    for _, v := range vs {
+        _ = binary.Write(b, binary.BigEndian, v)
+    }
+}
+
+func writeUint64(b *bytes.Buffer, vs ...uint64) {
+    for _, v := range vs {
+        _ = binary.Write(b, binary.BigEndian, v)
+    }
+}
+
+// writeString writes a variable length field, with the short length
+// encoding below 255 bytes
+func writeString(b *bytes.Buffer, s string) {
+    if len(s) < 255 {
+        b.WriteByte(byte(len(s)))
+    } else {
+        if len(s) > 0xffff {
+            s = s[:0xffff]
+        }
+        b.WriteByte(255)
+        writeUint16(b, uint16(len(s)))
+    }
+    b.WriteString(s)
+}
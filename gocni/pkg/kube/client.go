@@ -0,0 +1,56 @@
+package kube
+
+import (
+    "fmt"
+
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/rest"
+    "k8s.io/client-go/tools/clientcmd"
+)
+
+// RestConfig loads client configuration from a kubeconfig, or from the
+// in-cluster service account when kubeconfig is empty
+func RestConfig(kubeconfig string) (*rest.Config, error) {
+    var (
+        cfg *rest.Config
+        err error
+    )
+    if kubeconfig != "" {
+        cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+    } else {
+        cfg, err = rest.InClusterConfig()
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to load Kubernetes client config: %v", err)
+    }
+    return cfg, nil
+}
+
+// NewClient builds a typed Kubernetes client
+func NewClient(kubeconfig string) (kubernetes.Interface, error) {
+    cfg, err := RestConfig(kubeconfig)
+    if err != nil {
+        return nil, err
+    }
+    
+    client, err := kubernetes.NewForConfig(cfg)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+    }
+    return client, nil
+}
+
+// NewDynamicClient builds a client for custom resources
+func NewDynamicClient(kubeconfig string) (dynamic.Interface, error) {
+    cfg, err := RestConfig(kubeconfig)
+    if err != nil {
+        return nil, err
+    }
+    
+    client, err := dynamic.NewForConfig(cfg)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create Kubernetes dynamic client: %v", err)
+    }
+    return client, nil
+}
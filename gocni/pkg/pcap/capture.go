@@ -0,0 +1,125 @@
+// Package pcap captures the frames of one interface into the classic
+// libpcap file format, which tcpdump and wireshark read, without either
+// installed on the node or in the pod.
+package pcap
+
+import (
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "net"
+    "syscall"
+    "time"
+)
+
+// DefaultSnapLen keeps whole frames of jumbo MTUs
+const DefaultSnapLen = 65535
+
+const (
+    ethPAll       = 0x0003
+    linkTypeEther = 1
+    pcapMagic     = 0xa1b2c3d4
+)
+
+// pollInterval bounds how long a capture overruns its end
+const pollInterval = 200 * time.Millisecond
+
+// Handle is a packet socket bound to one interface. The socket stays in
+// the network namespace it was opened in, so it is opened inside the pod's
+// and read from anywhere.
+type Handle struct {
+    fd      int
+    ifName  string
+    snapLen int
+}
+
+// Stats is what a capture saw
+type Stats struct {
+    Packets int `json:"packets"`
+    Bytes   int `json:"bytes"`
+}
+
+// Open binds a packet socket to the named interface, in both directions
+func Open(ifName string, snapLen int) (*Handle, error) {
+    if snapLen <= 0 {
+        snapLen = DefaultSnapLen
+    }
+    iface, err := net.InterfaceByName(ifName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to lookup interface %q: %v", ifName, err)
+    }
+    
+    fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPAll)))
+    if err != nil {
+        return nil, fmt.Errorf("failed to open packet socket: %v", err)
+    }
+    addr := &syscall.SockaddrLinklayer{Protocol: htons(ethPAll), Ifindex: iface.Index}
+    if err := syscall.Bind(fd, addr); err != nil {
+        syscall.Close(fd)
+        return nil, fmt.Errorf("failed to bind packet socket to %q: %v", ifName, err)
+    }
+    tv := syscall.NsecToTimeval(pollInterval.Nanoseconds())
+    if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+        syscall.Close(fd)
+        return nil, fmt.Errorf("failed to set receive timeout: %v", err)
+    }
+    return &Handle{fd: fd, ifName: ifName, snapLen: snapLen}, nil
+}
+
+// Close releases the socket
+func (h *Handle) Close() error {
+    return syscall.Close(h.fd)
+}
+
+// WriteTo writes the file header, then the frames seen until ctx is done
+// or, when maxPackets is positive, that many were written
+func (h *Handle) WriteTo(ctx context.Context, w io.Writer, maxPackets int) (Stats, error) {
+    var stats Stats
+    
+    hdr := make([]byte, 24)
+    binary.LittleEndian.PutUint32(hdr[0:4], pcapMagic)
+    binary.LittleEndian.PutUint16(hdr[4:6], 2)
+    binary.LittleEndian.PutUint16(hdr[6:8], 4)
+    binary.LittleEndian.PutUint32(hdr[16:20], uint32(h.snapLen))
+    binary.LittleEndian.PutUint32(hdr[20:24], linkTypeEther)
+    if _, err := w.Write(hdr); err != nil {
+        return stats, err
+    }
+    
+    buf := make([]byte, h.snapLen)
+    rec := make([]byte, 16)
+    for ctx.Err() == nil && (maxPackets <= 0 || stats.Packets < maxPackets) {
+        // MSG_TRUNC returns the frame's length rather than what was copied
+        n, _, err := syscall.Recvfrom(h.fd, buf, syscall.MSG_TRUNC)
+        if err != nil {
+            if err == syscall.EAGAIN || err == syscall.EINTR {
+                continue
+            }
+            return stats, fmt.Errorf("failed to read from %q: %v", h.ifName, err)
+        }
+        captured := n
+        if captured > len(buf) {
+            captured = len(buf)
+        }
+        
+        now := time.Now()
+        binary.LittleEndian.PutUint32(rec[0:4], uint32(now.Unix()))
+        binary.LittleEndian.PutUint32(rec[4:8], uint32(now.Nanosecond()/1000))
+        binary.LittleEndian.PutUint32(rec[8:12], uint32(captured))
+        binary.LittleEndian.PutUint32(rec[12:16], uint32(n))
+        if _, err := w.Write(rec); err != nil {
+            return stats, err
+        }
+        if _, err := w.Write(buf[:captured]); err != nil {
+            return stats, err
+        }
+        stats.Packets++
+        stats.Bytes += n
+    }
+    return stats, nil
+}
+
+func htons(v uint16) uint16 {
+    return v<<8 | v>>8
+}
@@ -0,0 +1,57 @@
+package plugin
+
+import (
+    "fmt"
+    "net"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/arp"
+)
+
+// addSecondaryAddrs applies extra addresses to the container interface and
+// records them in the result next to the IPAM-assigned ones
+func addSecondaryAddrs(link netlink.Link, addrs []*net.IPNet, result *current.Result) error {
+    ifIndex := podInterface(result)
+    
+    for _, ipnet := range addrs {
+        addr := &netlink.Addr{
+            IPNet: ipnet,
+            Scope: int(addrScope(ipnet.IP)),
+        }
+        if err := netlink.AddrAdd(link, addr); err != nil {
+            return fmt.Errorf("failed to add secondary address %s to %q: %v", ipnet, link.Attrs().Name, err)
+        }
+        
+        result.IPs = append(result.IPs, &current.IPConfig{
+            Interface: ifIndex,
+            Address:   *ipnet,
+        })
+    }
+    
+    return nil
+}
+
+// addrScope picks the address scope matching the kind of address
+func addrScope(ip net.IP) netlink.Scope {
+    switch {
+    case ip.IsLoopback():
+        return netlink.SCOPE_HOST
+    case ip.IsLinkLocalUnicast():
+        return netlink.SCOPE_LINK
+    default:
+        return netlink.SCOPE_UNIVERSE
+    }
+}
+
+// announceAddrs sends gratuitous ARPs for every IPv4 address in the result so
+// the physical network learns the pod's addresses straight away
+func announceAddrs(ifName string, result *current.Result) error {
+    for _, ipc := range result.IPs {
+        if err := arp.SendGratuitous(ifName, ipc.Address.IP); err != nil {
+            return err
+        }
+    }
+    return nil
+}
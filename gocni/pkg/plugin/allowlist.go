@@ -0,0 +1,61 @@
+package plugin
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+
+    "github.com/containernetworking/cni/pkg/types"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// ErrVlanNotAllowed is the CNI error code of ADDs refused by the node's
+// VLAN allowlist; codes from 100 are plugin specific
+const ErrVlanNotAllowed = 100
+
+// vlanAllowlistFile is read for the node administrator's allowlist
+var vlanAllowlistFile = config.DefaultVlanAllowlistFile
+
+// checkVlanAllowed refuses VLANs missing from the node's allowlists: the
+// administrator's file and the one the daemon syncs from the node
+// annotation. Each present list must contain the VLAN; a list that cannot
+// be read or parsed refuses every VLAN.
+func checkVlanAllowed(store *state.Store, conf *config.NetConf) error {
+    deny := func(source, reason string) error {
+        return types.NewError(ErrVlanNotAllowed,
+            fmt.Sprintf("VLAN %d is not allowed on this node", conf.VlanID),
+            fmt.Sprintf("%s: %s", source, reason))
+    }
+    
+    data, err := ioutil.ReadFile(vlanAllowlistFile)
+    switch {
+    case os.IsNotExist(err):
+    case err != nil:
+        return deny(vlanAllowlistFile, err.Error())
+    default:
+        set, err := config.ParseVlanSet(string(data))
+        if err != nil {
+            return deny(vlanAllowlistFile, err.Error())
+        }
+        if !set.Contains(conf.VlanID) {
+            return deny(vlanAllowlistFile, "not listed")
+        }
+    }
+    
+    synced, err := store.GetVlanAllowlist()
+    if err != nil {
+        return deny("node annotation", err.Error())
+    }
+    if synced != nil {
+        set, err := config.ParseVlanSet(synced.VLANs)
+        if err != nil {
+            return deny("node annotation", err.Error())
+        }
+        if !set.Contains(conf.VlanID) {
+            return deny("node annotation", "not listed")
+        }
+    }
+    return nil
+}
@@ -0,0 +1,129 @@
+package plugin
+
+import (
+    "fmt"
+    "net"
+    "syscall"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/vishvananda/netlink"
+    "github.com/vishvananda/netlink/nl"
+)
+
+// Ethertypes the anti-spoofing filters pass
+const (
+    ethPIPv4 = 0x0800
+    ethPARP  = 0x0806
+    ethPIPv6 = 0x86dd
+)
+
+// antiSpoofDropPriority is below every pass filter
+const antiSpoofDropPriority = 0xffff
+
+// pinSource installs clsact egress filters on the container interface that
+// only let frames out with the interface's MAC address and, for IPv4, ARP
+// and IPv6, with one of the attachment's addresses as the sender; anything
+// else is dropped. Link-local and unspecified IPv6 sources stay allowed for
+// neighbour discovery and DAD, as do ARP probes. It runs inside the
+// container namespace, where a pod without NET_ADMIN cannot remove the
+// filters.
+func pinSource(link netlink.Link, result *current.Result) error {
+    ifName := link.Attrs().Name
+    
+    clsact := &netlink.GenericQdisc{
+        QdiscAttrs: netlink.QdiscAttrs{
+            LinkIndex: link.Attrs().Index,
+            Handle:    netlink.MakeHandle(0xffff, 0),
+            Parent:    netlink.HANDLE_CLSACT,
+        },
+        QdiscType: "clsact",
+    }
+    if err := netlink.QdiscReplace(clsact); err != nil {
+        return fmt.Errorf("failed to add clsact qdisc to %q: %v", ifName, err)
+    }
+    
+    type source struct {
+        ethType uint16
+        key     uint16
+        maskKey uint16
+        ipnet   *net.IPNet
+    }
+    sources := []source{
+        {ethPARP, nl.TCA_FLOWER_KEY_ARP_SIP, nl.TCA_FLOWER_KEY_ARP_SIP_MASK, &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(32, 32)}},
+        {ethPIPv6, nl.TCA_FLOWER_KEY_IPV6_SRC, nl.TCA_FLOWER_KEY_IPV6_SRC_MASK, &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(128, 128)}},
+        {ethPIPv6, nl.TCA_FLOWER_KEY_IPV6_SRC, nl.TCA_FLOWER_KEY_IPV6_SRC_MASK, &net.IPNet{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(10, 128)}},
+    }
+    for _, ipc := range result.IPs {
+        if ip4 := ipc.Address.IP.To4(); ip4 != nil {
+            host := &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+            sources = append(sources,
+                source{ethPIPv4, nl.TCA_FLOWER_KEY_IPV4_SRC, nl.TCA_FLOWER_KEY_IPV4_SRC_MASK, host},
+                source{ethPARP, nl.TCA_FLOWER_KEY_ARP_SIP, nl.TCA_FLOWER_KEY_ARP_SIP_MASK, host})
+            continue
+        }
+        host := &net.IPNet{IP: ipc.Address.IP.To16(), Mask: net.CIDRMask(128, 128)}
+        sources = append(sources, source{ethPIPv6, nl.TCA_FLOWER_KEY_IPV6_SRC, nl.TCA_FLOWER_KEY_IPV6_SRC_MASK, host})
+    }
+    
+    mac := link.Attrs().HardwareAddr
+    for i, s := range sources {
+        if err := addSourceFilter(link, uint16(i+1), mac, s.ethType, s.key, s.maskKey, s.ipnet); err != nil {
+            return fmt.Errorf("failed to pin %s source %s on %q: %v", ethTypeName(s.ethType), s.ipnet, ifName, err)
+        }
+    }
+    
+    drop := &netlink.MatchAll{
+        FilterAttrs: netlink.FilterAttrs{
+            LinkIndex: link.Attrs().Index,
+            Parent:    netlink.HANDLE_MIN_EGRESS,
+            Priority:  antiSpoofDropPriority,
+            Protocol:  syscall.ETH_P_ALL,
+        },
+        Actions: []netlink.Action{&netlink.GenericAction{
+            ActionAttrs: netlink.ActionAttrs{Action: netlink.TC_ACT_SHOT},
+        }},
+    }
+    if err := netlink.FilterReplace(drop); err != nil {
+        return fmt.Errorf("failed to add anti-spoofing drop filter to %q: %v", ifName, err)
+    }
+    return nil
+}
+
+// addSourceFilter passes frames of one ethertype from mac and ipnet. It is
+// the netlink equivalent of "tc filter add dev <link> egress prio <prio>
+// protocol <type> flower src_mac <mac> src_ip <ipnet>"; the netlink library
+// cannot express MAC or ARP keys yet. A flower filter without actions
+// passes what it matches.
+func addSourceFilter(link netlink.Link, prio uint16, mac net.HardwareAddr, ethType, key, maskKey uint16, ipnet *net.IPNet) error {
+    proto := []byte{byte(ethType >> 8), byte(ethType)}
+    
+    req := nl.NewNetlinkRequest(syscall.RTM_NEWTFILTER, syscall.NLM_F_CREATE|syscall.NLM_F_ACK)
+    req.AddData(&nl.TcMsg{
+        Family:  nl.FAMILY_ALL,
+        Ifindex: int32(link.Attrs().Index),
+        Parent:  netlink.HANDLE_MIN_EGRESS,
+        Info:    netlink.MakeHandle(prio, nl.Swap16(ethType)),
+    })
+    req.AddData(nl.NewRtAttr(nl.TCA_KIND, nl.ZeroTerminated("flower")))
+    
+    options := nl.NewRtAttr(nl.TCA_OPTIONS, nil)
+    options.AddRtAttr(nl.TCA_FLOWER_KEY_ETH_TYPE, proto)
+    options.AddRtAttr(nl.TCA_FLOWER_KEY_ETH_SRC, []byte(mac))
+    options.AddRtAttr(nl.TCA_FLOWER_KEY_ETH_SRC_MASK, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+    options.AddRtAttr(int(key), []byte(ipnet.IP))
+    options.AddRtAttr(int(maskKey), []byte(ipnet.Mask))
+    req.AddData(options)
+    
+    _, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+    return err
+}
+
+func ethTypeName(ethType uint16) string {
+    switch ethType {
+    case ethPIPv4:
+        return "IPv4"
+    case ethPARP:
+        return "ARP"
+    }
+    return "IPv6"
+}
@@ -0,0 +1,67 @@
+package plugin
+
+import (
+    "fmt"
+
+    "github.com/containernetworking/plugins/pkg/utils/sysctl"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// arpSettings are the per-interface ARP sysctls set by a preset
+type arpSettings struct {
+    announce, ignore, notify string
+}
+
+// arpPresets gives each arpMode its settings by link type. Tagged VLAN and
+// macvlan links own their MAC address, so the pod announces itself with
+// arp_notify; ipvlan children share the master's MAC, which the network
+// already knows, so they do not. Cloud fabrics answer ARP themselves and
+// ignore or drop gratuitous ARP.
+var arpPresets = map[string]map[string]arpSettings{
+    config.ArpModeStrict: {
+        linkTypeVlan:               {announce: "2", ignore: "1", notify: "1"},
+        config.UntaggedModeMacvlan: {announce: "2", ignore: "1", notify: "1"},
+        config.UntaggedModeIPVlan:  {announce: "2", ignore: "1", notify: "0"},
+    },
+    config.ArpModeLoose: {
+        linkTypeVlan:               {announce: "0", ignore: "0", notify: "1"},
+        config.UntaggedModeMacvlan: {announce: "0", ignore: "0", notify: "1"},
+        config.UntaggedModeIPVlan:  {announce: "0", ignore: "0", notify: "0"},
+    },
+    config.ArpModeCloud: {
+        linkTypeVlan:               {announce: "2", ignore: "2", notify: "0"},
+        config.UntaggedModeMacvlan: {announce: "2", ignore: "2", notify: "0"},
+        config.UntaggedModeIPVlan:  {announce: "2", ignore: "2", notify: "0"},
+    },
+}
+
+// linkTypeVlan keys the presets for tagged attachments
+const linkTypeVlan = "vlan"
+
+// configureARP applies the arpMode preset to the container interface. It
+// runs inside the container namespace, before addresses are added. Without
+// IPv4 it leaves the interface to disableFamilies, which silences ARP; the
+// configuration rejects arpMode there, this keeps the two from racing.
+func configureARP(ifName string, conf *config.NetConf) error {
+    if conf.ArpMode == "" || !conf.IPv4Enabled() {
+        return nil
+    }
+    
+    linkType := linkTypeVlan
+    if conf.VlanID == 0 {
+        linkType = conf.UntaggedMode
+    }
+    s := arpPresets[conf.ArpMode][linkType]
+    
+    for name, value := range map[string]string{
+        "arp_announce": s.announce,
+        "arp_ignore":   s.ignore,
+        "arp_notify":   s.notify,
+    } {
+        if _, err := sysctl.Sysctl(fmt.Sprintf("net/ipv4/conf/%s/%s", ifName, name), value); err != nil {
+            return fmt.Errorf("failed to set %s on %q: %v", name, ifName, err)
+        }
+    }
+    return nil
+}
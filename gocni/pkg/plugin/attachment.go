@@ -0,0 +1,97 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/cri"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// recordAttachment saves what ADD set up so DEL, CHECK and the node daemon
+// can find it later
+func recordAttachment(ctx context.Context, store *state.Store, args *skel.CmdArgs, conf *config.NetConf, data *ifNameData, hostName, podName string, result *current.Result, delegated []string) (*state.Attachment, error) {
+    a := &state.Attachment{
+        ContainerID:    args.ContainerID,
+        IfName:         args.IfName,
+        PodIfName:      podName,
+        Network:        conf.Name,
+        Master:         conf.Master,
+        VlanID:         conf.VlanID,
+        HostIfName:     hostName,
+        Netns:          cri.Netns(args.Netns),
+        Identity:       podIdentity(ctx, conf, data),
+        TrafficClass:   conf.TrafficClass(),
+        KeepaliveUntil: keepaliveUntil(conf),
+        Created:        time.Now().UTC(),
+        ConfigHash:     conf.Hash(),
+    }
+    for _, ipc := range result.IPs {
+        a.IPs = append(a.IPs, ipc.Address.String())
+    }
+    a.DelegatedPrefixes = delegated
+    if g := conf.Gateway; g != nil {
+        a.Gateway = &state.GatewayRef{Address: g.Address, VRID: g.VRID}
+    }
+    if err := store.SaveAttachment(a); err != nil {
+        return nil, err
+    }
+    return a, nil
+}
+
+// podIfName returns the name ADD gave the interface in the pod, from the
+// attachment record, or else rendered anew for records without it
+func podIfName(args *skel.CmdArgs, conf *config.NetConf) string {
+    if conf.ContainerIfNameTemplate == "" {
+        return args.IfName
+    }
+    if store, err := state.NewStore(conf.StateDir); err == nil {
+        if a, err := store.GetAttachment(args.ContainerID, args.IfName); err == nil && a != nil && a.PodIfName != "" {
+            return a.PodIfName
+        }
+    }
+    data, err := newIfNameData(args, conf)
+    if err != nil {
+        return args.IfName
+    }
+    name, err := containerIfName(args, conf, data)
+    if err != nil {
+        return args.IfName
+    }
+    return name
+}
+
+// recordCheck keeps the outcome of CHECK on the attachment record when it
+// changes, for the daemon to report failures and recoveries. The store lock
+// keeps a concurrent update of the record from being lost.
+func recordCheck(args *skel.CmdArgs, conf *config.NetConf, checkErr error) {
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return
+    }
+    if err := store.Lock(); err != nil {
+        return
+    }
+    defer store.Unlock()
+    
+    a, err := store.GetAttachment(args.ContainerID, args.IfName)
+    if err != nil || a == nil {
+        return
+    }
+    msg := ""
+    if checkErr != nil {
+        msg = checkErr.Error()
+    }
+    if a.CheckError == msg {
+        return
+    }
+    a.CheckError = msg
+    if err := store.SaveAttachment(a); err != nil {
+        fmt.Fprintf(warnLog, "level=warn msg=%q container=%s ifname=%s error=%q\n", "failed to record CHECK outcome", args.ContainerID, args.IfName, err)
+    }
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/containernetworking/cni/pkg/types"
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/hostlink"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// addBondChildren creates the VLAN on the bond's other masters and moves
+// the children to the pod. It returns the host names of all children in
+// the order of the bond's masters, vlanName being the first one's.
+func addBondChildren(conf *config.NetConf, data *ifNameData, store *state.Store, containerID, vlanName string, netns ns.NetNS) ([]string, error) {
+    names := []string{vlanName}
+    for _, m := range conf.Bond.Masters[1:] {
+        master, err := netlink.LinkByName(m)
+        if err != nil {
+            return nil, fmt.Errorf("failed to lookup bond master interface %q: %v", m, err)
+        }
+        childData := *data
+        childData.Master = m
+        name, err := hostIfName(conf, &childData, store, containerID)
+        if err != nil {
+            return nil, err
+        }
+        
+        child := hostlink.New(master, conf.VlanID, conf.UntaggedMode, conf.MTU, name, conf.Isolated)
+        if err := netlink.LinkAdd(child); err != nil {
+            return nil, fmt.Errorf("failed to create VLAN interface on bond master %q: %v", m, err)
+        }
+        _ = hostlink.Mark(child)
+        if err := netlink.LinkSetNsFd(child, int(netns.Fd())); err != nil {
+            _ = netlink.LinkDel(child)
+            return nil, fmt.Errorf("failed to move VLAN interface %q to container namespace: %v", name, err)
+        }
+        names = append(names, name)
+    }
+    return names, nil
+}
+
+// bondChildren enslaves the children to an active-backup bond named ifName,
+// from within the pod. The first child is enslaved first so the bond takes
+// the MAC address IPAM saw.
+func bondChildren(conf *config.NetConf, ifName string, children []string) error {
+    first, err := netlink.LinkByName(children[0])
+    if err != nil {
+        return fmt.Errorf("failed to find VLAN interface in container: %v", err)
+    }
+    
+    // Slaves take the bond's MTU, so it has to be the VLAN's
+    bond := netlink.NewLinkBond(netlink.LinkAttrs{Name: ifName, MTU: first.Attrs().MTU})
+    bond.Mode = netlink.BOND_MODE_ACTIVE_BACKUP
+    bond.Miimon = conf.Bond.MIIMonMs
+    if err := netlink.LinkAdd(bond); err != nil {
+        return fmt.Errorf("failed to create bond %q: %v", ifName, err)
+    }
+    link, err := netlink.LinkByName(ifName)
+    if err != nil {
+        return fmt.Errorf("failed to lookup bond %q: %v", ifName, err)
+    }
+    
+    primary := -1
+    for i, name := range children {
+        slave, err := netlink.LinkByName(name)
+        if err != nil {
+            return fmt.Errorf("failed to find VLAN interface %q in container: %v", name, err)
+        }
+        if err := netlink.LinkSetDown(slave); err != nil {
+            return fmt.Errorf("failed to set %q down: %v", name, err)
+        }
+        if err := netlink.LinkSetMasterByIndex(slave, link.Attrs().Index); err != nil {
+            return fmt.Errorf("failed to enslave %q to bond %q: %v", name, ifName, err)
+        }
+        if conf.Bond.Masters[i] == conf.Bond.Primary {
+            primary = slave.Attrs().Index
+        }
+    }
+    
+    // Fail back to the primary once its link returns
+    update := netlink.NewLinkBond(netlink.LinkAttrs{Index: link.Attrs().Index})
+    update.Primary = primary
+    if err := netlink.LinkModify(update); err != nil {
+        return fmt.Errorf("failed to set primary of bond %q: %v", ifName, err)
+    }
+    return nil
+}
+
+// checkBond fails CHECK when no slave of the pod's bond carries traffic and
+// warns about slaves that are down
+func checkBond(bond netlink.Link) error {
+    links, err := netlink.LinkList()
+    if err != nil {
+        return fmt.Errorf("failed to list links: %v", err)
+    }
+    var active bool
+    var states []string
+    for _, l := range links {
+        attrs := l.Attrs()
+        if attrs.MasterIndex != bond.Attrs().Index {
+            continue
+        }
+        slave, ok := attrs.Slave.(*netlink.BondSlave)
+        if !ok {
+            continue
+        }
+        up := slave.MiiStatus == netlink.BondLinkUp
+        if up && slave.State == netlink.BondStateActive {
+            active = true
+        }
+        if !up {
+            fmt.Fprintf(warnLog, "level=warn msg=%q bond=%s slave=%s\n", "bond slave is down", bond.Attrs().Name, attrs.Name)
+        }
+        states = append(states, fmt.Sprintf("%s %s,%s", attrs.Name, slave.MiiStatus, slave.State))
+    }
+    if !active {
+        return types.NewError(ErrTeamDown, "bond has no active slaves", fmt.Sprintf("bond %s: %s", bond.Attrs().Name, strings.Join(states, ", ")))
+    }
+    return nil
+}
@@ -0,0 +1,57 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// ErrCarrierTimeout is the CNI error code of ADDs whose interface did not
+// report carrier within carrierWaitSeconds
+const ErrCarrierTimeout = 103
+
+// iffLowerUp is IFF_LOWER_UP, set while the link has carrier
+const iffLowerUp = 0x10000
+
+const carrierPollInterval = 100 * time.Millisecond
+
+// waitCarrier waits for the interface to become operationally up, or for
+// carrier on drivers that leave the operational state unknown. It runs in
+// the container's namespace.
+func waitCarrier(ctx context.Context, ifName string, conf *config.NetConf) error {
+    if conf.CarrierWaitSeconds == 0 {
+        return nil
+    }
+    wait := time.Duration(conf.CarrierWaitSeconds) * time.Second
+    ctx, cancel := context.WithTimeout(ctx, wait)
+    defer cancel()
+    
+    ticker := time.NewTicker(carrierPollInterval)
+    defer ticker.Stop()
+    
+    for {
+        link, err := netlink.LinkByName(ifName)
+        if err != nil {
+            return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+        }
+        attrs := link.Attrs()
+        switch {
+        case attrs.OperState == netlink.OperUp:
+            return nil
+        case attrs.OperState == netlink.OperUnknown && attrs.RawFlags&iffLowerUp != 0:
+            return nil
+        }
+        
+        select {
+        case <-ctx.Done():
+            return types.NewError(ErrCarrierTimeout, "timed out waiting for carrier",
+                fmt.Sprintf("%s is still %s after %s", ifName, attrs.OperState, wait))
+        case <-ticker.C:
+        }
+    }
+}
@@ -0,0 +1,64 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// chainedMaster takes the master of a masterFromPrevResult network from the
+// previous result of the chain: the pod interface named ifName, else the
+// last one in a sandbox. It returns the previous result, which ADD extends.
+func chainedMaster(args *skel.CmdArgs, conf *config.NetConf) (*current.Result, error) {
+    prev, err := prevResult(conf)
+    if err != nil {
+        return nil, err
+    }
+    var master string
+    for _, iface := range prev.Interfaces {
+        if iface.Sandbox == "" {
+            continue
+        }
+        if iface.Name == args.IfName {
+            master = iface.Name
+            break
+        }
+        master = iface.Name
+    }
+    if master == "" {
+        return nil, fmt.Errorf("masterFromPrevResult: the previous result has no pod interface to create the VLAN on")
+    }
+    conf.Master = master
+    return prev, nil
+}
+
+// podLink returns the pod's interface of the attachment, named ifName in
+// the pod, from within the pod's namespace. With masterFromPrevResult the
+// interface named ifName is the previous plugin's, so the VLAN is found by
+// its parent instead.
+func podLink(ctx context.Context, conf *config.NetConf, netns ns.NetNS, ifName string) (netlink.Link, error) {
+    if !conf.MasterFromPrevResult {
+        return netlink.LinkByName(ifName)
+    }
+    // The VLAN goes with a master that left
+    master, err := lookupMaster(ctx, conf, netns)
+    if err != nil {
+        return nil, err
+    }
+    links, err := netlink.LinkList()
+    if err != nil {
+        return nil, fmt.Errorf("failed to list links: %v", err)
+    }
+    for _, l := range links {
+        if vlan, ok := l.(*netlink.Vlan); ok && vlan.ParentIndex == master.Attrs().Index && vlan.VlanId == conf.VlanID {
+            return l, nil
+        }
+    }
+    return nil, netlink.LinkNotFoundError{}
+}
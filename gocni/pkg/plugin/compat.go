@@ -0,0 +1,134 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    "github.com/containernetworking/cni/pkg/types"
+
+    "example.com/vlan-cni/pkg/compat"
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// ErrIncompatible is the CNI error code of ADDs the node's kernel or NIC
+// driver cannot serve
+const ErrIncompatible = 101
+
+// ErrTeamDown is the CNI error code of CHECKs on a bond or team master
+// without active slaves
+const ErrTeamDown = 102
+
+// ErrPluginNotAvailable is the CNI 1.1 STATUS code of a plugin that cannot
+// serve ADDs
+const ErrPluginNotAvailable = 50
+
+// checkCompat loads the module the link needs, then refuses links the
+// node's kernel or the master's driver cannot support, rather than
+// creating one that half works
+func checkCompat(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    kind := compat.LinkKind(conf.VlanID, conf.UntaggedMode)
+    if !conf.DisableModuleLoading {
+        err := timed(ctx, args, conf, "modprobe", func() error {
+            return compat.LoadModule(ctx, kind)
+        })
+        if err != nil {
+            // The link may still work, the kernel can autoload it
+            fmt.Fprintf(warnLog, "level=warn msg=%q error=%q\n", "failed to load kernel module", err)
+        }
+    }
+    
+    // Probes look at the host's links
+    if conf.LinkInContainer {
+        return nil
+    }
+    report := compat.Probe([]string{conf.Master}, probeStore(conf))
+    if err := report.Check(kind, conf.Master, conf.Isolated); err != nil {
+        return types.NewError(ErrIncompatible, "unsupported on this node", err.Error())
+    }
+    return nil
+}
+
+// probeStore is where the compatibility probe keeps the kernel's module
+// index, nil when the state directory cannot be used
+func probeStore(conf *config.NetConf) *state.Store {
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return nil
+    }
+    return store
+}
+
+// StatusVlanNetwork reports whether the node can serve ADDs for conf, and
+// is the CNI STATUS command
+func StatusVlanNetwork(conf *config.NetConf) error {
+    if conf.Meta != nil {
+        // Delegated networks are only known per pod
+        return nil
+    }
+    confs := []*config.NetConf{conf}
+    if len(conf.Attachments) > 0 {
+        confs = nil
+        for i := range conf.Attachments {
+            confs = append(confs, conf.ForAttachment(i))
+        }
+    }
+    
+    var masters []string
+    for _, c := range confs {
+        masters = append(masters, c.Master)
+    }
+    report := compat.Probe(masters, probeStore(conf))
+    
+    var problems []string
+    for _, c := range confs {
+        if err := report.Check(compat.LinkKind(c.VlanID, c.UntaggedMode), c.Master, c.Isolated); err != nil {
+            problems = append(problems, err.Error())
+        }
+    }
+    for _, m := range report.Masters {
+        if m.Team != nil {
+            if err := m.Team.Check(m.Name); err != nil {
+                problems = append(problems, err.Error())
+            }
+        }
+    }
+    if len(problems) > 0 {
+        return types.NewError(ErrPluginNotAvailable, "unsupported on this node", strings.Join(problems, "; "))
+    }
+    for _, m := range report.Masters {
+        for _, w := range m.Warnings {
+            fmt.Fprintf(warnLog, "level=warn msg=%q master=%s driver=%s\n", w, m.Name, m.Driver)
+        }
+        if m.Team != nil {
+            logTeam(m.Name, m.Team)
+        }
+    }
+    return nil
+}
+
+// checkTeam fails CHECK when the master is a bond or team none of whose
+// slaves carries traffic, which pods otherwise only notice as lost packets
+func checkTeam(ctx context.Context, conf *config.NetConf) error {
+    if conf.Master == "" || simulating(ctx, conf) || conf.LinkInContainer {
+        return nil
+    }
+    team := compat.ProbeTeam(conf.Master)
+    if team == nil {
+        return nil
+    }
+    if err := team.Check(conf.Master); err != nil {
+        return types.NewError(ErrTeamDown, "master has no active slaves", fmt.Sprintf("%v: %s", err, team.Summary()))
+    }
+    logTeam(conf.Master, team)
+    return nil
+}
+
+// logTeam warns about degraded slaves of a working team
+func logTeam(master string, team *compat.TeamReport) {
+    for _, w := range team.Warnings() {
+        fmt.Fprintf(warnLog, "level=warn msg=%q master=%s %s\n", w, master, team.Summary())
+    }
+}
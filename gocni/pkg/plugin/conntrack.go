@@ -0,0 +1,41 @@
+package plugin
+
+import (
+    "net"
+
+    "github.com/vishvananda/netlink"
+    "github.com/vishvananda/netlink/nl"
+)
+
+// conntrackIPFilters are the tuple fields checked against a released address
+var conntrackIPFilters = []netlink.ConntrackFilterType{
+    netlink.ConntrackOrigSrcIP,
+    netlink.ConntrackOrigDstIP,
+    netlink.ConntrackReplySrcIP,
+    netlink.ConntrackReplyDstIP,
+}
+
+// flushConntrack drops host conntrack entries referencing the released
+// addresses, so a pod that is handed the same address does not inherit
+// stale NAT or bridge state. Flushing is best effort.
+func flushConntrack(cidrs []string) {
+    for _, cidr := range cidrs {
+        ip, _, err := net.ParseCIDR(cidr)
+        if err != nil {
+            continue
+        }
+        
+        family := netlink.InetFamily(nl.FAMILY_V4)
+        if ip.To4() == nil {
+            family = netlink.InetFamily(nl.FAMILY_V6)
+        }
+        
+        for _, field := range conntrackIPFilters {
+            filter := &netlink.ConntrackFilter{}
+            if err := filter.AddIP(field, ip); err != nil {
+                continue
+            }
+            _, _ = netlink.ConntrackDeleteFilter(netlink.ConntrackTable, family, filter)
+        }
+    }
+}
@@ -0,0 +1,35 @@
+package plugin
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "path/filepath"
+    "time"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// daemonSocketName is the daemon API socket in the state directory
+const daemonSocketName = "daemon.sock"
+
+// daemonDialTimeout bounds reaching the daemon, which the plugin does
+// without when it has to
+const daemonDialTimeout = time.Second
+
+// daemonClient talks to the daemon API on the state directory's socket.
+// Features relying on it fall back to working alone when the daemon is
+// unreachable or has them off.
+func daemonClient(stateDir string) *http.Client {
+    if stateDir == "" {
+        stateDir = state.DefaultDir
+    }
+    socket := filepath.Join(stateDir, daemonSocketName)
+    return &http.Client{Transport: &http.Transport{
+        DisableKeepAlives: true,
+        DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+            d := net.Dialer{Timeout: daemonDialTimeout}
+            return d.DialContext(ctx, "unix", socket)
+        },
+    }}
+}
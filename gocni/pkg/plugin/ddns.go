@@ -0,0 +1,80 @@
+package plugin
+
+import (
+    "context"
+    "net"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/ddns"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// ddnsTimeout bounds how long registration may hold up ADD or DEL, within
+// the invocation's own deadline
+const ddnsTimeout = 10 * time.Second
+
+// registerDNS publishes the attachment's addresses. Failures only fail ADD
+// when the configuration marks registration as required.
+func registerDNS(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf, data *ifNameData, result *current.Result) error {
+    if conf.DDNS == nil {
+        return nil
+    }
+    
+    var ips []net.IP
+    for _, ipc := range result.IPs {
+        ips = append(ips, ipc.Address.IP)
+    }
+    
+    err := withRegistrar(ctx, conf, data, args.IfName, ips, ddns.Registrar.Register)
+    if err != nil && conf.DDNS.Required {
+        return err
+    }
+    return nil
+}
+
+// deregisterDNS retracts what registerDNS published, using the attachment
+// record saved at ADD. It is best effort.
+func deregisterDNS(ctx context.Context, conf *config.NetConf, a *state.Attachment) {
+    if conf.DDNS == nil || a == nil {
+        return
+    }
+    
+    var ips []net.IP
+    for _, cidr := range a.IPs {
+        if ip, _, err := net.ParseCIDR(cidr); err == nil {
+            ips = append(ips, ip)
+        }
+    }
+    
+    _ = withRegistrar(ctx, conf, attachmentNameData(a), a.IfName, ips, ddns.Registrar.Deregister)
+}
+
+func withRegistrar(ctx context.Context, conf *config.NetConf, data *ifNameData, ifName string, ips []net.IP, op func(ddns.Registrar, context.Context, *ddns.Record) error) error {
+    name, err := renderTemplate("ddns.nameTemplate", conf.DDNS.NameTemplate, data)
+    if err != nil {
+        return err
+    }
+    
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return err
+    }
+    registrar, err := ddns.New(conf.DDNS, conf.Kubeconfig, store)
+    if err != nil {
+        return err
+    }
+    
+    ctx, cancel := context.WithTimeout(ctx, ddnsTimeout)
+    defer cancel()
+    
+    return op(registrar, ctx, &ddns.Record{
+        ID:        data.ContainerID + "-" + ifName,
+        FQDN:      ddns.FQDN(name, conf.DDNS.Zone),
+        IPs:       ips,
+        Namespace: data.PodNamespace,
+    })
+}
@@ -0,0 +1,36 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/containernetworking/cni/pkg/types"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// WithDeadline runs op with a context that expires after the configured
+// timeout. Netlink and namespace calls cannot be interrupted, so when op is
+// still stuck at the deadline WithDeadline stops waiting and returns "try
+// again later"; the plugin process exits with it and the runtime's retry or
+// DEL cleans up whatever was left half done.
+func WithDeadline(conf *config.NetConf, op func(ctx context.Context) error) error {
+    ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout())
+    defer cancel()
+    
+    done := make(chan error, 1)
+    go func() {
+        var err error
+        defer func() { done <- err }()
+        defer recoverInto(conf, &err)
+        err = op(ctx)
+    }()
+    
+    select {
+    case err := <-done:
+        return err
+    case <-ctx.Done():
+        return types.NewError(types.ErrTryAgainLater, "operation timed out",
+            fmt.Sprintf("no result after %s", conf.Timeout()))
+    }
+}
@@ -0,0 +1,164 @@
+package plugin
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "syscall"
+
+    "github.com/containernetworking/cni/pkg/types"
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// defaultRoutesElsewhere returns the pod's default routes on interfaces
+// other than link. It runs inside the container network namespace.
+func defaultRoutesElsewhere(link netlink.Link) ([]netlink.Route, error) {
+    routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list routes: %v", err)
+    }
+    var others []netlink.Route
+    for _, r := range routes {
+        if isDefaultRoute(r) && r.LinkIndex != link.Attrs().Index {
+            others = append(others, r)
+        }
+    }
+    return others, nil
+}
+
+// clusterNetworks returns the configured cluster CIDRs and, beside
+// flannel, its networks
+func clusterNetworks(conf *config.NetConf, flannel *flannelEnv) []*net.IPNet {
+    var networks []*net.IPNet
+    for _, cidr := range conf.ClusterCIDRs {
+        _, network, _ := net.ParseCIDR(cidr)
+        networks = append(networks, network)
+    }
+    if flannel != nil {
+        networks = append(networks, flannel.networks...)
+    }
+    return networks
+}
+
+// routeClusterNetworks keeps the cluster networks on the routes being
+// taken over, through the same interface and gateway at their metric. It
+// runs inside the container network namespace.
+func routeClusterNetworks(others []netlink.Route, networks []*net.IPNet) error {
+    for _, network := range networks {
+        for _, r := range others {
+            if routeFamily(r) != familyOf(network.IP) {
+                continue
+            }
+            route := &netlink.Route{LinkIndex: r.LinkIndex, Dst: network, Gw: r.Gw, Priority: r.Priority}
+            if err := netlink.RouteReplace(route); err != nil {
+                return fmt.Errorf("failed to route cluster network %s via interface %d: %v", network, r.LinkIndex, err)
+            }
+            break
+        }
+    }
+    return nil
+}
+
+// takeDefaultRoutes leaves link the pod's only default routes. IPAM's
+// default routes have already replaced those at their metric; families
+// without one get a default route via the IPAM gateway before the
+// others' routes of the family are removed, so there is no moment
+// without one. It runs inside the container network namespace.
+func takeDefaultRoutes(link netlink.Link, others []netlink.Route, result *current.Result) error {
+    taken := 0
+    for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+        has, err := hasDefaultRoute(link, family)
+        if err != nil {
+            return err
+        }
+        if !has {
+            gw := familyGateway(family, result)
+            if gw == nil {
+                continue
+            }
+            dst := defaultDst(family)
+            route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Gw: gw}
+            if err := netlink.RouteReplace(route); err != nil {
+                return fmt.Errorf("failed to add default route via %s on %q: %v", gw, link.Attrs().Name, err)
+            }
+            result.Routes = append(result.Routes, &types.Route{Dst: *dst, GW: gw})
+        }
+        taken++
+        
+        for _, r := range others {
+            if routeFamily(r) != family {
+                continue
+            }
+            // Routes replaced in place are gone already
+            if err := netlink.RouteDel(&r); err != nil && !errors.Is(err, syscall.ESRCH) {
+                return fmt.Errorf("failed to remove default route of interface %d: %v", r.LinkIndex, err)
+            }
+        }
+    }
+    if taken == 0 {
+        return fmt.Errorf("defaultRoute: IPAM returned no gateway to route via %q", link.Attrs().Name)
+    }
+    return nil
+}
+
+// restoreDefaultRoutes puts back the routes taken over, best effort
+func restoreDefaultRoutes(link netlink.Link, others []netlink.Route) {
+    routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+    if err == nil {
+        for _, r := range routes {
+            if isDefaultRoute(r) {
+                _ = netlink.RouteDel(&r)
+            }
+        }
+    }
+    for _, r := range others {
+        if err := netlink.RouteReplace(&r); err != nil {
+            fmt.Fprintf(warnLog, "level=warn msg=%q dst=%v gw=%s error=%q\n", "failed to restore default route", r.Dst, r.Gw, err)
+        }
+    }
+}
+
+// hasDefaultRoute reports whether link has a default route of family
+func hasDefaultRoute(link netlink.Link, family int) (bool, error) {
+    routes, err := netlink.RouteList(link, family)
+    if err != nil {
+        return false, fmt.Errorf("failed to list routes of %q: %v", link.Attrs().Name, err)
+    }
+    for _, r := range routes {
+        if isDefaultRoute(r) {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// familyGateway returns the IPAM gateway of family, if any
+func familyGateway(family int, result *current.Result) net.IP {
+    if family == netlink.FAMILY_V4 {
+        return gatewayFor(net.IPv4zero, result)
+    }
+    return gatewayFor(net.IPv6zero, result)
+}
+
+func defaultDst(family int) *net.IPNet {
+    if family == netlink.FAMILY_V4 {
+        return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+    }
+    return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+}
+
+// routeFamily returns the address family of r
+func routeFamily(r netlink.Route) int {
+    switch {
+    case r.Family != 0:
+        return r.Family
+    case r.Gw != nil && r.Gw.To4() == nil:
+        return netlink.FAMILY_V6
+    case r.Dst != nil && r.Dst.IP.To4() == nil:
+        return netlink.FAMILY_V6
+    }
+    return netlink.FAMILY_V4
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "syscall"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    "github.com/containernetworking/cni/pkg/types"
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// goneMessages is how backends and the kernel word "already gone" once the
+// error has been flattened into a message
+var goneMessages = []string{
+    "not found",
+    "no such file or directory",
+    "no such process",
+    "no such device",
+    "does not exist",
+}
+
+// delFailure applies the DEL error policy to a failed teardown step. Strict
+// mode returns err; permissive mode logs and returns nil when what the step
+// removes is already gone, so a lost record or a sandbox torn down first
+// does not keep the pod from terminating. Anything else, such as a backend
+// that cannot be reached, is returned so the runtime retries the DEL.
+func delFailure(args *skel.CmdArgs, conf *config.NetConf, step string, err error) error {
+    if err == nil || conf.DelPolicy == config.DelPolicyStrict || !delGone(err) {
+        return err
+    }
+    fmt.Fprintf(warnLog, "level=warn msg=%q step=%s container=%s ifname=%s error=%q\n",
+        "ignoring DEL failure", step, shortID(args.ContainerID), args.IfName, err.Error())
+    return nil
+}
+
+// delGone reports whether a DEL step failed only because what it removes
+// no longer exists. "Try again later" and timeouts never count.
+func delGone(err error) bool {
+    var cniErr *types.Error
+    if errors.As(err, &cniErr) {
+        switch cniErr.Code {
+        case types.ErrTryAgainLater:
+            return false
+        case types.ErrUnknownContainer:
+            return true
+        }
+    }
+    if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+        return false
+    }
+    
+    var linkErr netlink.LinkNotFoundError
+    var nsErr ns.NSPathNotExistErr
+    if errors.As(err, &linkErr) || errors.As(err, &nsErr) ||
+        errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ESRCH) || errors.Is(err, syscall.ENODEV) {
+        return true
+    }
+    
+    msg := strings.ToLower(err.Error())
+    for _, gone := range goneMessages {
+        if strings.Contains(msg, gone) {
+            return true
+        }
+    }
+    return false
+}
+
+// delRun applies the DEL error policy to the steps of one DEL. A step
+// that fails on something still present returns its error, so the DEL is
+// never recorded as finished after one.
+type delRun struct {
+    args *skel.CmdArgs
+    conf *config.NetConf
+}
+
+func (d *delRun) step(step string, err error) error {
+    return delFailure(d.args, d.conf, step, err)
+}
@@ -0,0 +1,63 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Phases of a DEL queued by the node daemon, see daemon.DelQueueConfig
+const (
+    delPhaseIPAM     = "ipam"
+    delPhaseTeardown = "teardown"
+)
+
+// delSlot waits for the daemon's DEL queue to admit a phase of the DEL and
+// returns the function ending it. DELs run unqueued when there is no daemon
+// or it has no queue. Like ADDs waiting for a rate limit token, they wait
+// while at least half their deadline would be left for the work itself,
+// and are told to try again later otherwise.
+func delSlot(ctx context.Context, store *state.Store, phase string) (func(), error) {
+    client := daemonClient(store.Dir())
+    
+    // The slot is held while the request is, so only the wait is bounded
+    reqCtx, cancel := context.WithCancel(ctx)
+    var timer *time.Timer
+    if deadline, ok := ctx.Deadline(); ok {
+        timer = time.AfterFunc(time.Until(deadline)/2, cancel)
+    }
+    req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, "http://daemon/v1/delqueue?phase="+phase, nil)
+    if err != nil {
+        cancel()
+        return nil, err
+    }
+    start := time.Now()
+    resp, err := client.Do(req)
+    timedOut := timer != nil && !timer.Stop()
+    if timedOut {
+        if resp != nil {
+            resp.Body.Close()
+        }
+        cancel()
+        return nil, types.NewError(types.ErrTryAgainLater, "node DEL queue is full",
+            fmt.Sprintf("waited %s for the %s phase", time.Since(start).Round(time.Millisecond), phase))
+    }
+    if err != nil {
+        cancel()
+        return func() {}, nil
+    }
+    if resp.StatusCode != http.StatusOK {
+        resp.Body.Close()
+        cancel()
+        return func() {}, nil
+    }
+    return func() {
+        resp.Body.Close()
+        cancel()
+    }, nil
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+    "encoding/json"
+    "fmt"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// DHCP option codes set from pod metadata
+const (
+    dhcpOptionHostname    = "12"
+    dhcpOptionVendorClass = "60"
+    dhcpOptionClientID    = "61"
+)
+
+// defaultDHCPSocketPath is where the dhcp IPAM daemon listens by default
+const defaultDHCPSocketPath = "/run/cni/dhcp.sock"
+
+// dhcpProvide is an entry of the dhcp IPAM plugin's "provide" list
+type dhcpProvide struct {
+    Option string `json:"option"`
+    Value  string `json:"value"`
+}
+
+// ipamStdin returns the network configuration handed to the IPAM plugin.
+// For the dhcp plugin the configured hostname, client-id and vendor class
+// templates are rendered and added to the options it sends.
+func ipamStdin(stdin []byte, conf *config.NetConf, data *ifNameData) ([]byte, error) {
+    if conf.IPAMConfig.Type != "dhcp" || conf.DHCP == nil {
+        return stdin, nil
+    }
+    
+    var provide []dhcpProvide
+    for _, opt := range []struct {
+        field, code, tmpl string
+    }{
+        {"dhcp.hostname", dhcpOptionHostname, conf.DHCP.Hostname},
+        {"dhcp.vendorClass", dhcpOptionVendorClass, conf.DHCP.VendorClass},
+        {"dhcp.clientID", dhcpOptionClientID, conf.DHCP.ClientID},
+    } {
+        if opt.tmpl == "" {
+            continue
+        }
+        value, err := renderTemplate(opt.field, opt.tmpl, data)
+        if err != nil {
+            return nil, err
+        }
+        if value == "" {
+            continue
+        }
+        provide = append(provide, dhcpProvide{Option: opt.code, Value: value})
+    }
+    if len(provide) == 0 {
+        return stdin, nil
+    }
+    
+    var netconf map[string]interface{}
+    if err := json.Unmarshal(stdin, &netconf); err != nil {
+        return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+    }
+    ipamSection, ok := netconf["ipam"].(map[string]interface{})
+    if !ok {
+        return stdin, nil
+    }
+    
+    // Keep options the operator listed explicitly
+    existing, _ := ipamSection["provide"].([]interface{})
+    for _, p := range provide {
+        existing = append(existing, p)
+    }
+    ipamSection["provide"] = existing
+    
+    out, err := json.Marshal(netconf)
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode IPAM configuration: %v", err)
+    }
+    return out, nil
+}
@@ -0,0 +1,114 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "hash/fnv"
+    "net"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/dhcp6"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// dhcpv6ReleaseTimeout bounds the Release sent on DEL, which is best effort
+const dhcpv6ReleaseTimeout = 2 * time.Second
+
+// dhcpv6IAID ties the identity associations to the attachment
+func dhcpv6IAID(args *skel.CmdArgs) uint32 {
+    h := fnv.New32a()
+    h.Write([]byte(args.ContainerID + "/" + args.IfName))
+    return h.Sum32()
+}
+
+// acquireDHCPv6 leases the pod's address and prefix and adds the address to
+// the interface with the lease's lifetimes. It runs inside the container
+// network namespace with the link up.
+func acquireDHCPv6(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf, store *state.Store, link netlink.Link, result *current.Result) (*dhcp6.Lease, error) {
+    ctx, cancel := context.WithTimeout(ctx, conf.DHCPv6.Timeout())
+    defer cancel()
+    
+    client, err := dhcp6.NewClient(ctx, link.Attrs().Name, dhcpv6IAID(args))
+    if err != nil {
+        return nil, err
+    }
+    defer client.Close()
+    
+    lease, err := client.Acquire(ctx, dhcp6.Options{
+        Address:          !conf.DHCPv6.PrefixOnly,
+        PrefixDelegation: conf.DHCPv6.PrefixDelegation,
+        PrefixLength:     conf.DHCPv6.PrefixLength,
+        RapidCommit:      conf.DHCPv6.RapidCommit,
+    })
+    if err != nil {
+        return nil, err
+    }
+    if err := store.SaveDHCPv6Lease(args.ContainerID, args.IfName, lease); err != nil {
+        return nil, err
+    }
+    
+    ones := conf.DHCPv6.AddressPrefixLength
+    if ones == 0 {
+        ones = 128
+    }
+    for _, a := range lease.Addresses {
+        ipn := net.IPNet{IP: a.IP, Mask: net.CIDRMask(ones, 128)}
+        if err := netlink.AddrReplace(link, leasedAddr(ipn, a)); err != nil {
+            return nil, fmt.Errorf("failed to add leased address %s to %q: %v", ipn.String(), link.Attrs().Name, err)
+        }
+        result.IPs = append(result.IPs, &current.IPConfig{Address: ipn, Interface: podInterface(result)})
+    }
+    for _, ip := range lease.DNS {
+        result.DNS.Nameservers = append(result.DNS.Nameservers, ip.String())
+    }
+    return lease, nil
+}
+
+// leasedAddr is an address that expires with its lease unless renewed
+func leasedAddr(ipn net.IPNet, a dhcp6.Address) *netlink.Addr {
+    return &netlink.Addr{
+        IPNet:       &ipn,
+        PreferedLft: int(a.Preferred),
+        ValidLft:    int(a.Valid),
+        Flags:       ifaFNoDad,
+    }
+}
+
+// ifaFNoDad skips duplicate address detection, the server vouches for the
+// address
+const ifaFNoDad = 0x02
+
+// releaseDHCPv6 gives the attachment's lease back, while its namespace is
+// still there to send from. Servers reclaim unreleased leases when they
+// expire, so failing to is only a warning.
+func releaseDHCPv6(ctx context.Context, args *skel.CmdArgs, store *state.Store, ifName string) error {
+    lease := &dhcp6.Lease{}
+    found, err := store.GetDHCPv6Lease(args.ContainerID, args.IfName, lease)
+    if err != nil || !found {
+        return err
+    }
+    
+    if args.Netns != "" {
+        ctx, cancel := context.WithTimeout(ctx, dhcpv6ReleaseTimeout)
+        defer cancel()
+        
+        err := ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
+            client, err := dhcp6.NewClient(ctx, ifName, lease.IAID)
+            if err != nil {
+                return err
+            }
+            defer client.Close()
+            return client.Release(ctx, lease)
+        })
+        if err != nil {
+            fmt.Fprintf(warnLog, "level=warn msg=%q error=%q\n", "failed to release DHCPv6 lease", err)
+        }
+    }
+    return store.DeleteDHCPv6Lease(args.ContainerID, args.IfName)
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import (
+    "fmt"
+
+    "github.com/containernetworking/cni/pkg/types"
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/containernetworking/plugins/pkg/utils/sysctl"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// dropDisabledFamilies removes addresses and routes of disabled address
+// families from an IPAM result, so dual-stack backends can serve
+// single-family attachments. The allocation itself is kept and released
+// on DEL as usual.
+func dropDisabledFamilies(conf *config.NetConf, result *current.Result) {
+    if conf.IPv4Enabled() && conf.IPv6Enabled() {
+        return
+    }
+    
+    ips := result.IPs[:0]
+    for _, ipc := range result.IPs {
+        if conf.FamilyEnabled(ipc.Address.IP) {
+            ips = append(ips, ipc)
+        }
+    }
+    result.IPs = ips
+    
+    var routes []*types.Route
+    for _, r := range result.Routes {
+        if conf.FamilyEnabled(r.Dst.IP) {
+            routes = append(routes, r)
+        }
+    }
+    result.Routes = routes
+}
+
+// disableFamilies turns off disabled address families on the container
+// interface. It runs inside the container namespace before the link comes
+// up, so a disabled IPv6 never gets a link-local address.
+func disableFamilies(ifName string, conf *config.NetConf) error {
+    if !conf.IPv6Enabled() {
+        if _, err := sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/disable_ipv6", ifName), "1"); err != nil {
+            return fmt.Errorf("failed to disable IPv6 on %q: %v", ifName, err)
+        }
+    }
+    
+    // IPv4 has no per-interface switch; without addresses the interface
+    // only has to stop answering and sending ARP
+    if !conf.IPv4Enabled() {
+        for name, value := range map[string]string{
+            "arp_ignore": "8",
+            "arp_notify": "0",
+        } {
+            if _, err := sysctl.Sysctl(fmt.Sprintf("net/ipv4/conf/%s/%s", ifName, name), value); err != nil {
+                return fmt.Errorf("failed to set %s on %q: %v", name, ifName, err)
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+    "os"
+    "testing"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/containernetworking/plugins/pkg/testutils"
+    "github.com/containernetworking/plugins/pkg/utils/sysctl"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+func TestARPSilencedWithoutIPv4(t *testing.T) {
+    if os.Geteuid() != 0 {
+        t.Skip("needs root to create network namespaces")
+    }
+    
+    podNS, err := testutils.NewNS()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer testutils.UnmountNS(podNS)
+    
+    disabled := false
+    conf := &config.NetConf{ArpMode: config.ArpModeLoose, EnableIPv4: &disabled, UntaggedMode: config.UntaggedModeMacvlan}
+    err = podNS.Do(func(ns.NetNS) error {
+        veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "fam0"}, PeerName: "fam1"}
+        if err := netlink.LinkAdd(veth); err != nil {
+            return err
+        }
+        
+        // The order of ADD
+        if err := disableFamilies("fam0", conf); err != nil {
+            return err
+        }
+        if err := configureARP("fam0", conf); err != nil {
+            return err
+        }
+        for name, want := range map[string]string{"arp_ignore": "8", "arp_notify": "0"} {
+            got, err := sysctl.Sysctl("net/ipv4/conf/fam0/" + name)
+            if err != nil {
+                return err
+            }
+            if got != want {
+                t.Errorf("%s = %s, want %s", name, got, want)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,57 @@
+package plugin
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/features"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// checkUpstreamKeys fails ADD of a network configured with upstream vlan
+// plugin keys once the node daemon has turned the deprecated UpstreamKeys
+// gate off. Without a daemon to ask the keys are still taken.
+func checkUpstreamKeys(ctx context.Context, conf *config.NetConf) error {
+    warning := conf.CompatWarning()
+    if warning == "" {
+        return nil
+    }
+    if on, ok := daemonGate(ctx, conf.StateDir, features.UpstreamKeys); ok && !on {
+        return fmt.Errorf("%s, and feature gate %s is off on this node", warning, features.UpstreamKeys)
+    }
+    return nil
+}
+
+// daemonGate asks the daemon whether a feature gate is on, ok is false
+// when it cannot answer
+func daemonGate(ctx context.Context, stateDir, name string) (on, ok bool) {
+    ctx, cancel := context.WithTimeout(ctx, daemonDialTimeout)
+    defer cancel()
+    
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://daemon/v1/features", nil)
+    if err != nil {
+        return false, false
+    }
+    resp, err := daemonClient(stateDir).Do(req)
+    if err != nil {
+        return false, false
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return false, false
+    }
+    var list vlanv1.FeatureGateList
+    if err := json.NewDecoder(resp.Body).Decode(&list); err != nil || list.Check(vlanv1.KindFeatureGateList) != nil {
+        return false, false
+    }
+    
+    for _, g := range list.Gates {
+        if g.Name == name {
+            return g.Enabled, true
+        }
+    }
+    return false, false
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+    "bufio"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+    "syscall"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// flannelSubnetFile is written by flannel, k3s's embedded one included, on
+// every node it runs on
+const flannelSubnetFile = "/run/flannel/subnet.env"
+
+// flannelEnv is what the attachment has to stay clear of beside flannel
+type flannelEnv struct {
+    // Cluster networks flannel routes and masquerades
+    networks []*net.IPNet
+}
+
+// besideFlannel returns flannel's settings when the attachment is a
+// secondary network next to a flannel primary, nil otherwise
+func besideFlannel(args *skel.CmdArgs, conf *config.NetConf) (*flannelEnv, error) {
+    switch conf.Integration {
+    case config.IntegrationNone:
+        return nil, nil
+    case config.IntegrationAuto:
+        // Multus gives the primary network eth0 and secondaries net1...
+        if args.IfName == "eth0" {
+            return nil, nil
+        }
+        if _, err := os.Stat(flannelSubnetFile); err != nil {
+            return nil, nil
+        }
+    }
+    
+    f, err := os.Open(flannelSubnetFile)
+    if err != nil {
+        return nil, fmt.Errorf("integration %q needs flannel's subnet file: %v", conf.Integration, err)
+    }
+    defer f.Close()
+    
+    env := &flannelEnv{}
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+        if !ok || (key != "FLANNEL_NETWORK" && key != "FLANNEL_IPV6_NETWORK") {
+            continue
+        }
+        // Dual-stack k3s lists several networks per family
+        for _, cidr := range strings.Split(value, ",") {
+            if _, network, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil {
+                env.networks = append(env.networks, network)
+            }
+        }
+    }
+    return env, scanner.Err()
+}
+
+// checkOverlap refuses addresses inside flannel's networks, which its
+// iptables rules forward and masquerade as cluster traffic
+func (f *flannelEnv) checkOverlap(result *current.Result) error {
+    for _, ipc := range result.IPs {
+        for _, network := range f.networks {
+            if network.Contains(ipc.Address.IP) {
+                return fmt.Errorf("address %s is inside flannel's network %s, whose iptables rules would claim its traffic", ipc.Address.IP, network)
+            }
+        }
+    }
+    return nil
+}
+
+// dropDefaultRoutes leaves the IPAM default routes out, so pod egress
+// stays on flannel. Default routes in ipam.routes or taken over with
+// defaultRoute are kept, they were asked for.
+func (f *flannelEnv) dropDefaultRoutes(conf *config.NetConf, result *current.Result) {
+    if conf.DefaultRoute {
+        return
+    }
+    for _, r := range conf.IPAMConfig.Routes {
+        if isDefaultDst(r.Dst) {
+            return
+        }
+    }
+    routes := result.Routes[:0]
+    for _, r := range result.Routes {
+        if ones, _ := r.Dst.Mask.Size(); ones != 0 {
+            routes = append(routes, r)
+        }
+    }
+    result.Routes = routes
+}
+
+// warnDefaultRoutes warns when the pod has default routes of the same
+// family on the VLAN interface and another one at the same metric, which
+// makes its egress interface depend on route order. It runs inside the
+// container network namespace.
+func warnDefaultRoutes(link netlink.Link) {
+    for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+        routes, err := netlink.RouteList(nil, family)
+        if err != nil {
+            continue
+        }
+        ours := map[int]bool{}
+        for _, r := range routes {
+            if isDefaultRoute(r) && r.LinkIndex == link.Attrs().Index {
+                ours[r.Priority] = true
+            }
+        }
+        for _, r := range routes {
+            if !isDefaultRoute(r) || r.LinkIndex == link.Attrs().Index || !ours[r.Priority] {
+                continue
+            }
+            other := fmt.Sprint(r.LinkIndex)
+            if l, err := netlink.LinkByIndex(r.LinkIndex); err == nil {
+                other = l.Attrs().Name
+            }
+            fmt.Fprintf(warnLog, "level=warn msg=%q interface=%s other=%s metric=%d\n", "conflicting default routes", link.Attrs().Name, other, r.Priority)
+        }
+    }
+}
+
+// isDefaultRoute reports whether r is a default route of the main table
+func isDefaultRoute(r netlink.Route) bool {
+    if r.Table != 0 && r.Table != syscall.RT_TABLE_MAIN {
+        return false
+    }
+    if r.Dst == nil {
+        return true
+    }
+    ones, _ := r.Dst.Mask.Size()
+    return ones == 0
+}
+
+// isDefaultDst reports whether a configured route destination is a
+// default route
+func isDefaultDst(dst string) bool {
+    _, network, err := net.ParseCIDR(dst)
+    if err != nil {
+        return false
+    }
+    ones, _ := network.Mask.Size()
+    return ones == 0
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    "github.com/containernetworking/plugins/pkg/ns"
+
+    "example.com/vlan-cni/pkg/arp"
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+    "example.com/vlan-cni/pkg/vrrp"
+)
+
+// gatewayProbeInterval is how often the health check ARPs for the gateway
+const gatewayProbeInterval = 500 * time.Millisecond
+
+// checkGateway listens from the pod for the master of the network's
+// virtual router, records it and logs failovers. Only a failed health
+// check fails CHECK; silent advertisements may just be filtered.
+func checkGateway(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    g := conf.Gateway
+    vip := net.ParseIP(g.Address)
+    
+    ifName := podIfName(args, conf)
+    var advert *vrrp.Advert
+    var listenErr, healthErr error
+    err := ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
+        listenCtx, cancel := context.WithTimeout(ctx, g.ListenTimeout())
+        advert, listenErr = vrrp.Listen(listenCtx, ifName, g.VRID, vip)
+        cancel()
+        
+        if g.HealthCheck {
+            probeCtx, cancel := context.WithTimeout(ctx, g.ListenTimeout())
+            healthErr = arp.Probe(probeCtx, ifName, vip, gatewayProbeInterval)
+            cancel()
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    
+    router := ""
+    if listenErr != nil {
+        fmt.Fprintf(warnLog, "level=warn msg=%q network=%s gateway=%s error=%q\n", "no VRRP master heard", conf.Name, g.Address, listenErr)
+    } else {
+        router = advert.Router.String()
+    }
+    
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return err
+    }
+    previous, changed, err := store.RecordGatewayRouter(conf.Name, g.Address, g.VRID, router)
+    if err != nil {
+        return err
+    }
+    if changed {
+        fmt.Fprintf(warnLog, "level=warn msg=%q network=%s gateway=%s from=%q to=%q\n", "gateway failed over", conf.Name, g.Address, previous, router)
+    }
+    
+    if healthErr != nil {
+        return fmt.Errorf("gateway %s of network %q is unreachable: %v", g.Address, conf.Name, healthErr)
+    }
+    return nil
+}
@@ -0,0 +1,129 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "net"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/arp"
+    "example.com/vlan-cni/pkg/config"
+)
+
+// gatewayProbeTimeout is how long gatewaySelection probe waits for each
+// router to answer
+const gatewayProbeTimeout = time.Second
+
+// selectGateways routes the pod via one of the configured gateways of each
+// family, replacing the gateway IPAM returned. It runs inside the
+// container network namespace once the link is up, so probes reach the
+// routers.
+func selectGateways(ctx context.Context, ifName string, conf *config.NetConf, result *current.Result) {
+    for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+        var candidates []net.IP
+        for _, g := range conf.Gateways {
+            ip := net.ParseIP(g)
+            if (ip.To4() != nil) == (family == netlink.FAMILY_V4) {
+                candidates = append(candidates, ip)
+            }
+        }
+        if len(candidates) == 0 {
+            continue
+        }
+        gw := pickGateway(ctx, ifName, conf, candidates)
+        setGateway(family, gw, result)
+    }
+}
+
+// pickGateway applies the network's gatewaySelection to the candidates of
+// one family. Probing only ARPs, so IPv6 routers are taken in order, as are
+// IPv4 routers when none answers.
+func pickGateway(ctx context.Context, ifName string, conf *config.NetConf, candidates []net.IP) net.IP {
+    switch conf.GatewaySelection {
+    case config.GatewaySelectionRandom:
+        return candidates[rand.Intn(len(candidates))]
+    case config.GatewaySelectionProbe:
+        if candidates[0].To4() == nil {
+            return candidates[0]
+        }
+        if gw := fastestGateway(ctx, ifName, candidates); gw != nil {
+            return gw
+        }
+        fmt.Fprintf(warnLog, "level=warn msg=%q network=%s gateway=%s\n", "no gateway answered probes, using the first", conf.Name, candidates[0])
+    }
+    return candidates[0]
+}
+
+// fastestGateway probes the candidates in turn and returns the one that
+// answered soonest, nil if none did. Probes stay on the calling thread,
+// which is in the container network namespace.
+func fastestGateway(ctx context.Context, ifName string, candidates []net.IP) net.IP {
+    var fastest net.IP
+    var best time.Duration
+    for _, gw := range candidates {
+        probeCtx, cancel := context.WithTimeout(ctx, gatewayProbeTimeout)
+        start := time.Now()
+        err := arp.Probe(probeCtx, ifName, gw, gatewayProbeTimeout)
+        rtt := time.Since(start)
+        cancel()
+        if err == nil && (fastest == nil || rtt < best) {
+            fastest, best = gw, rtt
+        }
+    }
+    return fastest
+}
+
+// setGateway points the gateways of the result's addresses of family at
+// gw, along with its default routes and the routes via the gateways IPAM
+// returned. Routes via other routers are left alone. A default route via gw
+// is added when IPAM gave the family none.
+func setGateway(family int, gw net.IP, result *current.Result) {
+    v4 := family == netlink.FAMILY_V4
+    hasAddr, hasDefault := false, false
+    var replaced []net.IP
+    for _, ipc := range result.IPs {
+        if (ipc.Address.IP.To4() != nil) != v4 {
+            continue
+        }
+        hasAddr = true
+        if ipc.Gateway != nil {
+            replaced = append(replaced, ipc.Gateway)
+        }
+        if ipc.Gateway != nil || ipc.Address.Contains(gw) {
+            ipc.Gateway = gw
+        }
+    }
+    if !hasAddr {
+        return
+    }
+    for _, r := range result.Routes {
+        if (r.Dst.IP.To4() != nil) != v4 {
+            continue
+        }
+        ones, _ := r.Dst.Mask.Size()
+        if ones == 0 {
+            hasDefault = true
+        }
+        if r.GW != nil && (ones == 0 || containsIP(replaced, r.GW)) {
+            r.GW = gw
+        }
+    }
+    if !hasDefault {
+        result.Routes = append(result.Routes, &types.Route{Dst: *defaultDst(family), GW: gw})
+    }
+}
+
+// containsIP reports whether ip is one of ips
+func containsIP(ips []net.IP, ip net.IP) bool {
+    for _, i := range ips {
+        if i.Equal(ip) {
+            return true
+        }
+    }
+    return false
+}
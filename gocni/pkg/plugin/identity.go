@@ -0,0 +1,78 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/kube"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// podIdentity returns the workload an attachment is attributed to. The
+// service account is not part of CNI_ARGS, so it is looked up only when
+// the configuration names a kubeconfig; a failed lookup leaves it empty
+// rather than failing ADD.
+func podIdentity(ctx context.Context, conf *config.NetConf, data *ifNameData) state.Identity {
+    if conf.Kubeconfig == "" || data.PodName == "" {
+        return identityOf(data, nil)
+    }
+    pod, err := getPod(ctx, conf, data)
+    if err != nil {
+        fmt.Fprintf(warnLog, "level=warn msg=%q pod=%s/%s error=%q\n",
+            "service account lookup failed", data.PodNamespace, data.PodName, err)
+    }
+    return identityOf(data, pod)
+}
+
+// identityOf combines the CNI_ARGS pod metadata with the pod object, if any
+func identityOf(data *ifNameData, pod *corev1.Pod) state.Identity {
+    id := state.Identity{
+        PodName:      data.PodName,
+        PodNamespace: data.PodNamespace,
+        PodUID:       data.PodUID,
+    }
+    if pod != nil {
+        id.ServiceAccount = pod.Spec.ServiceAccountName
+        if id.PodUID == "" {
+            id.PodUID = string(pod.UID)
+        }
+    }
+    return id
+}
+
+// getPod fetches the pod being attached from the API server, through the
+// privsep worker when there is one
+func getPod(ctx context.Context, conf *config.NetConf, data *ifNameData) (*corev1.Pod, error) {
+    if worker != nil {
+        wire, err := conf.MarshalWire()
+        if err != nil {
+            return nil, err
+        }
+        pod := &corev1.Pod{}
+        if err := worker.Call(ctx, "pod", podCall{Conf: wire, Data: data}, pod); err != nil {
+            return nil, err
+        }
+        return pod, nil
+    }
+    return localGetPod(ctx, conf, data)
+}
+
+func localGetPod(ctx context.Context, conf *config.NetConf, data *ifNameData) (*corev1.Pod, error) {
+    client, err := kube.NewClient(conf.Kubeconfig)
+    if err != nil {
+        return nil, err
+    }
+    
+    ctx, cancel := context.WithTimeout(ctx, metaPodTimeout)
+    defer cancel()
+    
+    pod, err := client.CoreV1().Pods(data.PodNamespace).Get(ctx, data.PodName, metav1.GetOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to look up pod %s/%s: %v", data.PodNamespace, data.PodName, err)
+    }
+    return pod, nil
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// inLinkNS runs fn where the VLAN is created: in the pod's namespace for
+// linkInContainer networks, whose master is there, and in the host's
+// otherwise. Link indexes only mean something in their own namespace.
+func inLinkNS(conf *config.NetConf, netns ns.NetNS, fn func() error) error {
+    if !conf.LinkInContainer {
+        return fn()
+    }
+    return netns.Do(func(ns.NetNS) (err error) {
+        defer recoverInto(conf, &err)
+        return fn()
+    })
+}
+
+// delContainerLink removes the VLAN of a linkInContainer network. Its
+// master was pushed in by someone else and may stay in the pod after this
+// attachment, so the link does not go with the namespace as host-side
+// VLANs do. Chained networks find their master in the attachment record,
+// or else in the previous result.
+func delContainerLink(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf, a *state.Attachment) error {
+    if args.Netns == "" {
+        return nil
+    }
+    if conf.MasterFromPrevResult {
+        if a != nil {
+            conf.Master = a.Master
+        } else if _, err := chainedMaster(args, conf); err != nil {
+            return nil
+        }
+    }
+    ifName := podIfName(args, conf)
+    if a != nil {
+        ifName = a.PodInterface()
+    }
+    return ns.WithNetNSPath(args.Netns, func(netns ns.NetNS) error {
+        link, err := podLink(ctx, conf, netns, ifName)
+        if _, ok := err.(netlink.LinkNotFoundError); ok {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+        }
+        if err := netlink.LinkDel(link); err != nil {
+            return fmt.Errorf("failed to delete %q: %v", ifName, err)
+        }
+        return nil
+    })
+}
+
+// checkContainerParent fails CHECK when the pod's link no longer hangs off
+// its master in the pod's namespace
+func checkContainerParent(ctx context.Context, conf *config.NetConf, netns ns.NetNS, link netlink.Link) error {
+    master, err := lookupMaster(ctx, conf, netns)
+    if err != nil {
+        return fmt.Errorf("failed to find master %q in container: %v", conf.Master, err)
+    }
+    if link.Attrs().ParentIndex != master.Attrs().Index {
+        return fmt.Errorf("interface %q is not on master %q", link.Attrs().Name, conf.Master)
+    }
+    return nil
+}
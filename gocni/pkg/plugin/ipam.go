@@ -0,0 +1,400 @@
+package plugin
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/json"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    "github.com/containernetworking/cni/pkg/invoke"
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    vlanipam "example.com/vlan-cni/pkg/ipam"
+    _ "example.com/vlan-cni/pkg/ipam/infoblox"
+    _ "example.com/vlan-cni/pkg/ipam/kubeapi"
+    _ "example.com/vlan-cni/pkg/ipam/netbox"
+    _ "example.com/vlan-cni/pkg/ipam/phpipam"
+    _ "example.com/vlan-cni/pkg/ipam/remote"
+    "example.com/vlan-cni/pkg/state"
+    vlantypes "example.com/vlan-cni/pkg/types"
+)
+
+// ConfigureIPAM allocates addresses for the attachment from the driver
+// selected by ipam.type. It must be called from the host network namespace.
+func ConfigureIPAM(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf, data *ifNameData, mac string) (*current.Result, error) {
+    driver, err := ipamDriver(args, conf, data)
+    if err != nil {
+        return nil, err
+    }
+    
+    ctx, cancel := context.WithTimeout(ctx, conf.IPAMConfig.Timeout())
+    defer cancel()
+    
+    var result *current.Result
+    err = timed(ctx, args, conf, "ipam.Allocate", func() (err error) {
+        result, err = driver.Allocate(ctx, ipamRequest(args, conf, data, mac))
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    dropDisabledFamilies(conf, result)
+    if len(result.IPs) == 0 {
+        _ = driver.Release(ctx, ipamRequest(args, conf, data, ""))
+        return nil, fmt.Errorf("IPAM %q returned no addresses of an enabled family", conf.IPAMConfig.Type)
+    }
+    
+    return result, nil
+}
+
+// ReleaseIPAllocation returns the attachment's addresses to the IPAM backend
+func ReleaseIPAllocation(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    data, err := newIfNameData(args, conf)
+    if err != nil {
+        return err
+    }
+    
+    driver, err := ipamDriver(args, conf, data)
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(ctx, conf.IPAMConfig.Timeout())
+    defer cancel()
+    
+    return timed(ctx, args, conf, "ipam.Release", func() error {
+        return driver.Release(ctx, ipamRequest(args, conf, data, ""))
+    })
+}
+
+// CheckIPAllocation asks the IPAM backend whether the attachment still holds
+// its addresses
+func CheckIPAllocation(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    data, err := newIfNameData(args, conf)
+    if err != nil {
+        return err
+    }
+    
+    driver, err := ipamDriver(args, conf, data)
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(ctx, conf.IPAMConfig.Timeout())
+    defer cancel()
+    
+    return timed(ctx, args, conf, "ipam.Check", func() error {
+        return driver.Check(ctx, ipamRequest(args, conf, data, ""))
+    })
+}
+
+// ipamDriver returns the driver registered for ipam.type, or one running the
+// IPAM plugin binary of that name when no in-process driver claims it,
+// behind the backend's circuit breaker. With a privsep worker the driver
+// runs there.
+func ipamDriver(args *skel.CmdArgs, conf *config.NetConf, data *ifNameData) (vlanipam.Driver, error) {
+    if worker != nil {
+        wire, err := conf.MarshalWire()
+        if err != nil {
+            return nil, err
+        }
+        return &workerDriver{call: ipamCall{Args: args, Conf: wire, Data: data}}, nil
+    }
+    return localIPAMDriver(args, conf, data)
+}
+
+func localIPAMDriver(args *skel.CmdArgs, conf *config.NetConf, data *ifNameData) (vlanipam.Driver, error) {
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return nil, err
+    }
+    
+    driver, err := vlanipam.New(conf.IPAMConfig.Type, conf.IPAMConfig.Raw(), store)
+    if err != nil {
+        return nil, err
+    }
+    if driver == nil {
+        stdin, err := ipamStdin(args.StdinData, conf, data)
+        if err != nil {
+            return nil, err
+        }
+        d := &execDriver{
+            plugin:      conf.IPAMConfig.Type,
+            stdin:       stdin,
+            raw:         conf.IPAMConfig.Raw(),
+            containerID: args.ContainerID,
+            netns:       args.Netns,
+            ifName:      args.IfName,
+            pluginArgs:  args.Args,
+        }
+        // host-local keys allocations by CNI_IFNAME, so only dhcp is told
+        // the pod interface
+        if d.plugin == "dhcp" {
+            d.ifName = podIfName(args, conf)
+        }
+        driver = d
+    }
+    
+    threshold, cooldown := 0, time.Duration(0)
+    if cb := conf.IPAMConfig.CircuitBreaker; cb != nil {
+        threshold = cb.FailureThreshold
+        cooldown = time.Duration(cb.CooldownSeconds) * time.Second
+    }
+    return vlanipam.WithBreaker(driver, conf.IPAMConfig.Type, breakerKey(conf), store, threshold, cooldown), nil
+}
+
+// breakerKey identifies a backend by its type and settings, so networks
+// sharing a backend share its breaker
+func breakerKey(conf *config.NetConf) string {
+    // Compacted, so the key does not change with the section's formatting
+    // or with the worker re-encoding it
+    var raw bytes.Buffer
+    if err := json.Compact(&raw, conf.IPAMConfig.Raw()); err != nil {
+        raw.Write(conf.IPAMConfig.Raw())
+    }
+    sum := sha256.Sum256(raw.Bytes())
+    return fmt.Sprintf("%s-%x", conf.IPAMConfig.Type, sum[:8])
+}
+
+// execDriver delegates to an IPAM plugin binary such as host-local or dhcp
+type execDriver struct {
+    plugin string
+    stdin  []byte
+    raw    []byte
+
+    // The invocation, passed explicitly rather than inherited from the
+    // environment: attachments each run IPAM under their own interface,
+    // which host-local keys its allocations by
+    containerID string
+    netns       string
+    ifName      string
+    pluginArgs  string
+}
+
+func (d *execDriver) Allocate(ctx context.Context, req *vlanipam.Request) (*current.Result, error) {
+    path, err := invoke.FindInPath(d.plugin, filepath.SplitList(os.Getenv("CNI_PATH")))
+    if err != nil {
+        return nil, fmt.Errorf("IPAM plugin %q failed: %v", d.plugin, err)
+    }
+    r, err := invoke.ExecPluginWithResult(ctx, path, d.stdin, d.args("ADD"), nil)
+    if err != nil {
+        return nil, fmt.Errorf("IPAM plugin %q failed: %v", d.plugin, err)
+    }
+    
+    result, err := current.NewResultFromResult(r)
+    if err != nil {
+        return nil, fmt.Errorf("failed to convert IPAM result: %v", err)
+    }
+    return result, nil
+}
+
+func (d *execDriver) Release(ctx context.Context, req *vlanipam.Request) error {
+    if err := d.exec(ctx, "DEL"); err != nil {
+        return fmt.Errorf("IPAM plugin %q failed to release: %v", d.plugin, err)
+    }
+    return nil
+}
+
+func (d *execDriver) Check(ctx context.Context, req *vlanipam.Request) error {
+    if err := d.exec(ctx, "CHECK"); err != nil {
+        return fmt.Errorf("IPAM plugin %q check failed: %v", d.plugin, err)
+    }
+    return nil
+}
+
+func (d *execDriver) exec(ctx context.Context, command string) error {
+    path, err := invoke.FindInPath(d.plugin, filepath.SplitList(os.Getenv("CNI_PATH")))
+    if err != nil {
+        return err
+    }
+    return invoke.ExecPluginWithoutResult(ctx, path, d.stdin, d.args(command), nil)
+}
+
+// args passes the plugin the attachment's invocation
+func (d *execDriver) args(command string) invoke.CNIArgs {
+    return &invoke.Args{
+        Command:       command,
+        ContainerID:   d.containerID,
+        NetNS:         d.netns,
+        IfName:        d.ifName,
+        PluginArgsStr: d.pluginArgs,
+        Path:          os.Getenv("CNI_PATH"),
+    }
+}
+
+// Health reports whether the plugin binary can be found on CNI_PATH and,
+// for dhcp, whether its daemon accepts connections
+func (d *execDriver) Health(ctx context.Context) error {
+    if _, err := invoke.FindInPath(d.plugin, filepath.SplitList(os.Getenv("CNI_PATH"))); err != nil {
+        return err
+    }
+    if d.plugin != "dhcp" {
+        return nil
+    }
+    
+    dhcpConf := struct {
+        DaemonSocketPath string `json:"daemonSocketPath"`
+    }{DaemonSocketPath: defaultDHCPSocketPath}
+    if len(d.raw) > 0 {
+        if err := json.Unmarshal(d.raw, &dhcpConf); err != nil {
+            return fmt.Errorf("failed to parse dhcp ipam config: %v", err)
+        }
+    }
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "unix", dhcpConf.DaemonSocketPath)
+    if err != nil {
+        return fmt.Errorf("dhcp daemon is not reachable: %v", err)
+    }
+    return conn.Close()
+}
+
+// ipamRequest describes the attachment to IPAM drivers
+func ipamRequest(args *skel.CmdArgs, conf *config.NetConf, data *ifNameData, mac string) *vlanipam.Request {
+    req := &vlanipam.Request{
+        ContainerID:  args.ContainerID,
+        IfName:       args.IfName,
+        Network:      conf.Name,
+        PodName:      data.PodName,
+        PodNamespace: data.PodNamespace,
+        PodUID:       data.PodUID,
+        NodeName:     nodeName(args, conf),
+        MAC:          mac,
+        VlanID:       conf.VlanID,
+    }
+    if req.NodeName == "" {
+        req.NodeName, _ = os.Hostname()
+        req.GuessedNodeName = true
+    }
+    return req
+}
+
+// nodeName returns the node's name from the runtime's K8S_NODE_NAME arg or
+// the network's nodeName, empty when neither says
+func nodeName(args *skel.CmdArgs, conf *config.NetConf) string {
+    if args.Args != "" {
+        if k8sArgs, err := config.LoadK8sArgs(args.Args); err == nil && k8sArgs.K8S_NODE_NAME != "" {
+            return string(k8sArgs.K8S_NODE_NAME)
+        }
+    }
+    return conf.NodeName
+}
+
+// applyIPAM adds the allocated addresses and routes to the container
+// interface. It runs inside the container network namespace.
+func applyIPAM(link netlink.Link, conf *config.NetConf, result *current.Result) error {
+    for _, ipc := range result.IPs {
+        addr := &netlink.Addr{IPNet: &net.IPNet{IP: ipc.Address.IP, Mask: ipc.Address.Mask}}
+        if err := netlink.AddrAdd(link, addr); err != nil {
+            return fmt.Errorf("failed to add address %s to %q: %v", addr.IPNet, link.Attrs().Name, err)
+        }
+    }
+    
+    for _, route := range mergeRoutes(conf.IPAMConfig.Routes, result) {
+        if _, dst, err := net.ParseCIDR(route.Dst); err == nil && !conf.FamilyEnabled(dst.IP) {
+            continue
+        }
+        // A default route taken over replaces the one at its metric in
+        // place, so the pod is never without one
+        replace := conf.DefaultRoute && isDefaultDst(route.Dst)
+        if err := addRoute(link, route, result, replace); err != nil {
+            return err
+        }
+    }
+    
+    return nil
+}
+
+// mergeRoutes combines the routes returned by the IPAM plugin with the
+// configured ones, letting configured metric, table and next hops win for
+// matching destinations
+func mergeRoutes(configured []*vlantypes.Route, result *current.Result) []*vlantypes.Route {
+    byDst := map[string]*vlantypes.Route{}
+    var routes []*vlantypes.Route
+    
+    for _, r := range configured {
+        _, dst, _ := net.ParseCIDR(r.Dst)
+        byDst[dst.String()] = r
+        routes = append(routes, r)
+    }
+    
+    for _, r := range result.Routes {
+        dst := r.Dst.String()
+        if c, ok := byDst[dst]; ok {
+            // Fill a gateway the IPAM plugin knows but the config left out
+            if c.GW == "" && len(c.NextHops) == 0 && r.GW != nil {
+                c.GW = r.GW.String()
+            }
+            continue
+        }
+        route := &vlantypes.Route{Dst: dst}
+        if r.GW != nil {
+            route.GW = r.GW.String()
+        }
+        byDst[dst] = route
+        routes = append(routes, route)
+    }
+    
+    return routes
+}
+
+// addRoute installs a single, possibly multipath, route, replacing the one
+// with its destination and metric if replace is set
+func addRoute(link netlink.Link, r *vlantypes.Route, result *current.Result, replace bool) error {
+    _, dst, err := net.ParseCIDR(r.Dst)
+    if err != nil {
+        return fmt.Errorf("invalid route destination %q: %v", r.Dst, err)
+    }
+    
+    route := &netlink.Route{
+        LinkIndex: link.Attrs().Index,
+        Dst:       dst,
+        Priority:  r.Metric,
+        Table:     r.Table,
+    }
+    
+    switch {
+    case len(r.NextHops) > 0:
+        route.LinkIndex = 0
+        for _, nh := range r.NextHops {
+            hops := 0
+            if nh.Weight > 0 {
+                hops = nh.Weight - 1
+            }
+            route.MultiPath = append(route.MultiPath, &netlink.NexthopInfo{
+                LinkIndex: link.Attrs().Index,
+                Gw:        net.ParseIP(nh.GW),
+                Hops:      hops,
+            })
+        }
+    case r.GW != "":
+        route.Gw = net.ParseIP(r.GW)
+    default:
+        route.Gw = gatewayFor(dst.IP, result)
+    }
+    
+    add := netlink.RouteAdd
+    if replace {
+        add = netlink.RouteReplace
+    }
+    if err := add(route); err != nil {
+        return fmt.Errorf("failed to add route %s on %q: %v", r.Dst, link.Attrs().Name, err)
+    }
+    return nil
+}
+
+// gatewayFor returns the IPAM gateway of the same family as ip, if any
+func gatewayFor(ip net.IP, result *current.Result) net.IP {
+    v4 := ip.To4() != nil
+    for _, ipc := range result.IPs {
+        if ipc.Gateway != nil && (ipc.Gateway.To4() != nil) == v4 {
+            return ipc.Gateway
+        }
+    }
+    return nil
+}
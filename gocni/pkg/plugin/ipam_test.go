@@ -0,0 +1,93 @@
+package plugin
+
+import (
+    "context"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "testing"
+
+    "github.com/containernetworking/cni/pkg/skel"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// hostLocal finds the host-local plugin on CNI_PATH, or builds it from the
+// module cache, and points CNI_PATH at it
+func hostLocal(t *testing.T) {
+    t.Helper()
+    for _, dir := range filepath.SplitList(os.Getenv("CNI_PATH")) {
+        if _, err := os.Stat(filepath.Join(dir, "host-local")); err == nil {
+            return
+        }
+    }
+    
+    dir := t.TempDir()
+    build := exec.Command("go", "build", "-o", filepath.Join(dir, "host-local"), "github.com/containernetworking/plugins/plugins/ipam/host-local")
+    if out, err := build.CombinedOutput(); err != nil {
+        t.Skipf("host-local is not on CNI_PATH and cannot be built: %v\n%s", err, out)
+    }
+    t.Setenv("CNI_PATH", dir)
+}
+
+// TestAttachmentsHostLocal allocates two attachments from one host-local
+// range and releases one, which must leave the other's address alone
+func TestAttachmentsHostLocal(t *testing.T) {
+    hostLocal(t)
+    dir := t.TempDir()
+    
+    ipam := `{"type": "host-local", "dataDir": "` + filepath.Join(dir, "ipam") + `", "ranges": [[{"subnet": "192.0.2.0/24"}]]}`
+    stdin := []byte(`{
+        "cniVersion": "1.0.0",
+        "name": "multi",
+        "type": "vlan-cni",
+        "attachments": [
+            {"ifName": "net1", "master": "eth1", "vlan": 100, "ipam": ` + ipam + `},
+            {"ifName": "net2", "master": "eth1", "vlan": 100, "ipam": ` + ipam + `}
+        ],
+        "stateDir": "` + filepath.Join(dir, "state") + `"
+    }`)
+    conf, err := config.ParseConfig(stdin)
+    if err != nil {
+        t.Fatal(err)
+    }
+    args := &skel.CmdArgs{
+        ContainerID: "0123456789abcdef",
+        Netns:       "/var/run/netns/multi",
+        IfName:      "eth0",
+        StdinData:   stdin,
+    }
+    ctx := context.Background()
+    
+    addrs := map[string]string{}
+    for i := range conf.Attachments {
+        subArgs, subConf, err := attachmentInvocation(args, conf, i)
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, err := newIfNameData(subArgs, subConf)
+        if err != nil {
+            t.Fatal(err)
+        }
+        result, err := ConfigureIPAM(ctx, subArgs, subConf, data, "")
+        if err != nil {
+            t.Fatalf("attachment %q: %v", subArgs.IfName, err)
+        }
+        addr := result.IPs[0].Address.String()
+        for ifName, other := range addrs {
+            if other == addr {
+                t.Fatalf("attachments %q and %q both got %s", ifName, subArgs.IfName, addr)
+            }
+        }
+        addrs[subArgs.IfName] = addr
+    }
+    
+    subArgs, subConf, _ := attachmentInvocation(args, conf, 0)
+    if err := ReleaseIPAllocation(ctx, subArgs, subConf); err != nil {
+        t.Fatalf("release %q: %v", subArgs.IfName, err)
+    }
+    subArgs, subConf, _ = attachmentInvocation(args, conf, 1)
+    if err := CheckIPAllocation(ctx, subArgs, subConf); err != nil {
+        t.Errorf("releasing net1 released net2: %v", err)
+    }
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "net"
+    "syscall"
+
+    "github.com/containernetworking/plugins/pkg/utils/sysctl"
+    "github.com/vishvananda/netlink"
+    "github.com/vishvananda/netlink/nl"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// Link attributes the netlink and syscall packages do not export
+const (
+    iflaAfSpec     = 26 // IFLA_AF_SPEC
+    iflaInet6Token = 7  // IFLA_INET6_TOKEN
+)
+
+// addrGenModes maps addrGenMode values to the kernel's addr_gen_mode sysctl
+var addrGenModes = map[string]string{
+    config.AddrGenModeEUI64:         "0",
+    config.AddrGenModeNone:          "1",
+    config.AddrGenModeStablePrivacy: "2",
+    config.AddrGenModeRandom:        "3",
+}
+
+// configureIPv6AddrGen applies the address generation mode and interface
+// token to the container interface. It must run before the link is set up so
+// the first SLAAC and link-local addresses already honour the settings.
+func configureIPv6AddrGen(link netlink.Link, conf *config.NetConf, data *ifNameData) error {
+    ifName := link.Attrs().Name
+    
+    if conf.AddrGenMode != "" {
+        // stable-privacy needs a secret; derive it from the pod identity so
+        // the pod keeps its addresses across restarts
+        if conf.AddrGenMode == config.AddrGenModeStablePrivacy {
+            secret := identityIPv6(data, "stable-secret", 0)
+            if _, err := sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/stable_secret", ifName), secret.String()); err != nil {
+                return fmt.Errorf("failed to set stable_secret on %q: %v", ifName, err)
+            }
+        }
+        if _, err := sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/addr_gen_mode", ifName), addrGenModes[conf.AddrGenMode]); err != nil {
+            return fmt.Errorf("failed to set addr_gen_mode on %q: %v", ifName, err)
+        }
+    }
+    
+    if conf.IPv6Token != "" {
+        token := net.ParseIP(conf.IPv6Token)
+        if conf.IPv6Token == config.IPv6TokenAuto {
+            token = identityIPv6(data, "token", 8)
+        }
+        if err := setIPv6Token(link, token); err != nil {
+            return err
+        }
+    }
+    
+    return nil
+}
+
+// identityIPv6 hashes the pod identity into an IPv6 value, zeroing the first
+// keepZero bytes (8 leaves a valid interface identifier). Without K8S_* args
+// the container ID stands in for the pod, so such containers still differ.
+func identityIPv6(data *ifNameData, purpose string, keepZero int) net.IP {
+    identity := data.PodNamespace + "/" + data.PodName
+    if data.PodName == "" {
+        identity = "container/" + data.ContainerID
+    }
+    sum := sha256.Sum256([]byte(purpose + "/" + identity))
+    ip := make(net.IP, net.IPv6len)
+    copy(ip[keepZero:], sum[keepZero:net.IPv6len])
+    return ip
+}
+
+// setIPv6Token is the netlink equivalent of "ip token set <token> dev <link>".
+// The kernel takes the token only nested as IFLA_AF_SPEC > AF_INET6.
+func setIPv6Token(link netlink.Link, token net.IP) error {
+    token = token.To16()
+    if token == nil {
+        return fmt.Errorf("invalid IPv6 token")
+    }
+    
+    req := nl.NewNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+    msg := nl.NewIfInfomsg(syscall.AF_INET6)
+    msg.Index = int32(link.Attrs().Index)
+    req.AddData(msg)
+    
+    afSpec := nl.NewRtAttr(iflaAfSpec|syscall.NLA_F_NESTED, nil)
+    inet6 := afSpec.AddRtAttr(syscall.AF_INET6|syscall.NLA_F_NESTED, nil)
+    inet6.AddRtAttr(iflaInet6Token, []byte(token))
+    req.AddData(afSpec)
+    
+    if _, err := req.Execute(syscall.NETLINK_ROUTE, 0); err != nil {
+        return fmt.Errorf("failed to set IPv6 token %s on %q: %v", token, link.Attrs().Name, err)
+    }
+    return nil
+}
+
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+    "fmt"
+    "net"
+    "os"
+    "syscall"
+    "testing"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/containernetworking/plugins/pkg/testutils"
+    "github.com/vishvananda/netlink"
+    "github.com/vishvananda/netlink/nl"
+)
+
+func TestSetIPv6Token(t *testing.T) {
+    if os.Geteuid() != 0 {
+        t.Skip("needs root to create network namespaces")
+    }
+    
+    podNS, err := testutils.NewNS()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer testutils.UnmountNS(podNS)
+    
+    // The kernel refuses tokens on NOARP links such as dummies, so use a veth
+    err = podNS.Do(func(ns.NetNS) error {
+        veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "tok0"}, PeerName: "tok1"}
+        if err := netlink.LinkAdd(veth); err != nil {
+            return err
+        }
+        link, err := netlink.LinkByName("tok0")
+        if err != nil {
+            return err
+        }
+        
+        want := net.ParseIP("::1a:2b:3c:4d")
+        if err := setIPv6Token(link, want); err != nil {
+            return err
+        }
+        got, err := getIPv6Token(link)
+        if err != nil {
+            return err
+        }
+        if !got.Equal(want) {
+            t.Errorf("token = %s, want %s", got, want)
+        }
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestIdentityIPv6(t *testing.T) {
+    pod := identityIPv6(&ifNameData{ContainerID: "a", PodNamespace: "ns", PodName: "pod"}, "token", 8)
+    if !pod.Equal(identityIPv6(&ifNameData{ContainerID: "b", PodNamespace: "ns", PodName: "pod"}, "token", 8)) {
+        t.Errorf("token of a pod changed with its container ID")
+    }
+    if !pod.Mask(net.CIDRMask(64, 128)).Equal(net.IPv6zero) {
+        t.Errorf("token %s sets prefix bits", pod)
+    }
+    
+    a := identityIPv6(&ifNameData{ContainerID: "a"}, "token", 8)
+    b := identityIPv6(&ifNameData{ContainerID: "b"}, "token", 8)
+    if a.Equal(b) {
+        t.Errorf("containers without K8S_* args share token %s", a)
+    }
+}
+
+// getIPv6Token is the netlink equivalent of "ip token get dev <link>". The
+// kernel reports the token in the AF_INET6 link dump, under IFLA_PROTINFO.
+func getIPv6Token(link netlink.Link) (net.IP, error) {
+    req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_DUMP)
+    req.AddData(nl.NewIfInfomsg(syscall.AF_INET6))
+    
+    msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get IPv6 token of %q: %v", link.Attrs().Name, err)
+    }
+    for _, m := range msgs {
+        msg := nl.DeserializeIfInfomsg(m)
+        if int(msg.Index) != link.Attrs().Index {
+            continue
+        }
+        attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse link attributes of %q: %v", link.Attrs().Name, err)
+        }
+        for _, attr := range attrs {
+            if attr.Attr.Type != syscall.IFLA_PROTINFO {
+                continue
+            }
+            infos, err := nl.ParseRouteAttr(attr.Value)
+            if err != nil {
+                return nil, fmt.Errorf("failed to parse IPv6 attributes of %q: %v", link.Attrs().Name, err)
+            }
+            for _, info := range infos {
+                if info.Attr.Type == iflaInet6Token && len(info.Value) == net.IPv6len {
+                    return net.IP(info.Value), nil
+                }
+            }
+        }
+    }
+    return nil, fmt.Errorf("no IPv6 token reported for %q", link.Attrs().Name)
+}
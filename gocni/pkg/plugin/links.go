@@ -0,0 +1,71 @@
+package plugin
+
+import (
+    "context"
+    "encoding/json"
+    "net"
+    "net/http"
+    "net/url"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// lookupMaster returns the master link from the daemon's link cache, or
+// from the kernel when there is no cache. Cached links carry the attributes
+// of the master only, not those of its kind. linkInContainer masters are
+// out of the daemon's sight, in netns.
+func lookupMaster(ctx context.Context, conf *config.NetConf, netns ns.NetNS) (netlink.Link, error) {
+    if conf.LinkInContainer {
+        var link netlink.Link
+        err := netns.Do(func(ns.NetNS) (err error) {
+            link, err = netlink.LinkByName(conf.Master)
+            return err
+        })
+        return link, err
+    }
+    if link := cachedLink(ctx, conf.StateDir, conf.Master); link != nil {
+        return link, nil
+    }
+    return netlink.LinkByName(conf.Master)
+}
+
+// cachedLink asks the daemon for a link, nil when it cannot answer
+func cachedLink(ctx context.Context, stateDir, name string) netlink.Link {
+    ctx, cancel := context.WithTimeout(ctx, daemonDialTimeout)
+    defer cancel()
+    
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://daemon/v1/links?name="+url.QueryEscape(name), nil)
+    if err != nil {
+        return nil
+    }
+    resp, err := daemonClient(stateDir).Do(req)
+    if err != nil {
+        return nil
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil
+    }
+    var l vlanv1.Link
+    if err := json.NewDecoder(resp.Body).Decode(&l); err != nil || l.Check(vlanv1.KindLink) != nil || l.Index == 0 {
+        return nil
+    }
+    
+    attrs := netlink.LinkAttrs{
+        Name:        l.Name,
+        Index:       l.Index,
+        MTU:         l.MTU,
+        MasterIndex: l.MasterIndex,
+    }
+    if mac, err := net.ParseMAC(l.HardwareAddr); err == nil {
+        attrs.HardwareAddr = mac
+    }
+    if l.Up {
+        attrs.Flags |= net.FlagUp
+    }
+    return &netlink.Device{LinkAttrs: attrs}
+}
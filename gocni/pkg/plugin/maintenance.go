@@ -0,0 +1,30 @@
+package plugin
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// checkMaintenance fails ADDs with ErrTryAgainLater while the node daemon
+// has the node's networking in maintenance, so runtimes retry them once it
+// ends. DELs are served as usual to let the node drain.
+func checkMaintenance(conf *config.NetConf) error {
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return err
+    }
+    m, err := store.GetMaintenance()
+    if err != nil {
+        return fmt.Errorf("failed to read maintenance state: %v", err)
+    }
+    if m == nil {
+        return nil
+    }
+    return types.NewError(types.ErrTryAgainLater, "node networking is in maintenance",
+        fmt.Sprintf("%s, since %s", m.Reason, m.Since.Format(time.RFC3339)))
+}
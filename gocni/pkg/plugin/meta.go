@@ -0,0 +1,298 @@
+package plugin
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/invoke"
+    "github.com/containernetworking/cni/pkg/skel"
+    "github.com/containernetworking/cni/pkg/types"
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/containernetworking/cni/pkg/version"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// metaPodTimeout bounds the pod lookup done in delegating mode
+const metaPodTimeout = 10 * time.Second
+
+// networkSelection is one entry of the networks annotation
+type networkSelection struct {
+    Name      string `json:"name"`
+    Interface string `json:"interface,omitempty"`
+}
+
+// addDelegated attaches every network the pod's annotation asks for and
+// returns them merged into the previous result of the chain, if any
+func addDelegated(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) (*current.Result, error) {
+    result, err := prevResult(conf)
+    if err != nil {
+        return nil, err
+    }
+    
+    selections, identity, err := podNetworks(ctx, args, conf)
+    if err != nil {
+        return nil, err
+    }
+    
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return nil, err
+    }
+    
+    var delegations []*state.Delegation
+    for i, sel := range selections {
+        d, err := loadDelegation(conf, sel, i)
+        if err != nil {
+            _ = delDelegated(ctx, args, conf)
+            return nil, err
+        }
+        d.Identity = identity
+        
+        // Record before invoking so DEL cleans up a half-finished ADD
+        delegations = append(delegations, d)
+        if err := store.SaveDelegations(args.ContainerID, delegations); err != nil {
+            _ = delDelegated(ctx, args, conf)
+            return nil, err
+        }
+        
+        r, err := execDelegate(ctx, args, "ADD", d)
+        if err != nil {
+            _ = delDelegated(ctx, args, conf)
+            return nil, fmt.Errorf("network %q: %v", d.Network, err)
+        }
+        
+        res, err := current.NewResultFromResult(r)
+        if err != nil {
+            _ = delDelegated(ctx, args, conf)
+            return nil, fmt.Errorf("network %q: failed to convert result: %v", d.Network, err)
+        }
+        
+        // Kept in the delegate's own version for its CHECK and DEL
+        if d.Result, err = json.Marshal(r); err != nil {
+            _ = delDelegated(ctx, args, conf)
+            return nil, fmt.Errorf("network %q: failed to encode result: %v", d.Network, err)
+        }
+        if err := store.SaveDelegations(args.ContainerID, delegations); err != nil {
+            _ = delDelegated(ctx, args, conf)
+            return nil, err
+        }
+        mergeResult(result, res)
+    }
+    
+    return result, nil
+}
+
+// delDelegated removes the recorded delegated networks in reverse order,
+// carrying on past failures
+func delDelegated(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return err
+    }
+    
+    delegations, err := store.GetDelegations(args.ContainerID)
+    if err != nil {
+        return err
+    }
+    
+    var firstErr error
+    for i := len(delegations) - 1; i >= 0; i-- {
+        _, err := execDelegate(ctx, args, "DEL", delegations[i])
+        if err != nil {
+            err = fmt.Errorf("network %q: %v", delegations[i].Network, err)
+        }
+        if err := delFailure(args, conf, "delegate.DEL", err); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    if firstErr != nil {
+        return firstErr
+    }
+    return store.DeleteDelegations(args.ContainerID)
+}
+
+// checkDelegated runs CHECK against every recorded delegated network
+func checkDelegated(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return err
+    }
+    
+    delegations, err := store.GetDelegations(args.ContainerID)
+    if err != nil {
+        return err
+    }
+    for _, d := range delegations {
+        if _, err := execDelegate(ctx, args, "CHECK", d); err != nil {
+            return fmt.Errorf("network %q: %v", d.Network, err)
+        }
+    }
+    return nil
+}
+
+// prevResult returns the result handed down the chain, or an empty one
+func prevResult(conf *config.NetConf) (*current.Result, error) {
+    if conf.RawPrevResult == nil {
+        return &current.Result{CNIVersion: conf.CNIVersion}, nil
+    }
+    if err := version.ParsePrevResult(&conf.NetConf); err != nil {
+        return nil, fmt.Errorf("failed to parse prevResult: %v", err)
+    }
+    result, err := current.NewResultFromResult(conf.PrevResult)
+    if err != nil {
+        return nil, fmt.Errorf("failed to convert prevResult: %v", err)
+    }
+    return result, nil
+}
+
+// podNetworks reads the networks annotation of the pod being attached,
+// along with the identity the delegations are recorded under
+func podNetworks(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) ([]networkSelection, state.Identity, error) {
+    data, err := newIfNameData(args, conf)
+    if err != nil {
+        return nil, state.Identity{}, err
+    }
+    if data.PodName == "" {
+        return nil, identityOf(data, nil), nil
+    }
+    
+    pod, err := getPod(ctx, conf, data)
+    if err != nil {
+        return nil, state.Identity{}, err
+    }
+    selections, err := parseNetworkSelections(pod.Annotations[conf.Meta.Annotation])
+    return selections, identityOf(data, pod), err
+}
+
+// parseNetworkSelections accepts "a,b@eth2" or a JSON list of selections
+func parseNetworkSelections(value string) ([]networkSelection, error) {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return nil, nil
+    }
+    
+    var selections []networkSelection
+    if strings.HasPrefix(value, "[") {
+        if err := json.Unmarshal([]byte(value), &selections); err != nil {
+            return nil, fmt.Errorf("invalid networks annotation: %v", err)
+        }
+        return selections, nil
+    }
+    
+    for _, item := range strings.Split(value, ",") {
+        item = strings.TrimSpace(item)
+        if item == "" {
+            continue
+        }
+        sel := networkSelection{Name: item}
+        if at := strings.LastIndex(item, "@"); at > 0 {
+            sel.Name, sel.Interface = item[:at], item[at+1:]
+        }
+        selections = append(selections, sel)
+    }
+    return selections, nil
+}
+
+// loadDelegation reads a selected network's configuration file. Interfaces
+// not named in the annotation are called net1, net2 and so on.
+func loadDelegation(conf *config.NetConf, sel networkSelection, i int) (*state.Delegation, error) {
+    if sel.Name == "" || strings.ContainsAny(sel.Name, "/\\") {
+        return nil, fmt.Errorf("invalid network name %q", sel.Name)
+    }
+    
+    path := filepath.Join(conf.Meta.NetworksDir, sel.Name+".conf")
+    raw, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read network %q: %v", sel.Name, err)
+    }
+    
+    var netconf map[string]interface{}
+    if err := json.Unmarshal(raw, &netconf); err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+    }
+    pluginType, _ := netconf["type"].(string)
+    if pluginType == "" {
+        return nil, fmt.Errorf("%s: type is required", path)
+    }
+    if _, ok := netconf["name"]; !ok {
+        netconf["name"] = sel.Name
+    }
+    if _, ok := netconf["cniVersion"]; !ok {
+        netconf["cniVersion"] = conf.CNIVersion
+    }
+    
+    delegateConf, err := json.Marshal(netconf)
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode network %q: %v", sel.Name, err)
+    }
+    
+    ifName := sel.Interface
+    if ifName == "" {
+        ifName = fmt.Sprintf("net%d", i+1)
+    }
+    if _, err := checkIfName(ifName); err != nil {
+        return nil, err
+    }
+    
+    return &state.Delegation{Network: sel.Name, IfName: ifName, Type: pluginType, Config: delegateConf}, nil
+}
+
+// execDelegate runs the delegate plugin found on CNI_PATH with the
+// delegation's interface name. CHECK and DEL get the recorded ADD result
+// as prevResult.
+func execDelegate(ctx context.Context, args *skel.CmdArgs, command string, d *state.Delegation) (types.Result, error) {
+    paths := filepath.SplitList(args.Path)
+    if len(paths) == 0 {
+        paths = filepath.SplitList(os.Getenv("CNI_PATH"))
+    }
+    pluginPath, err := invoke.FindInPath(d.Type, paths)
+    if err != nil {
+        return nil, err
+    }
+    
+    invokeArgs := &invoke.Args{
+        Command:       command,
+        ContainerID:   args.ContainerID,
+        NetNS:         args.Netns,
+        PluginArgsStr: args.Args,
+        IfName:        d.IfName,
+        Path:          args.Path,
+    }
+    
+    if command != "ADD" {
+        netconf, err := delegateConfig(d)
+        if err != nil {
+            return nil, err
+        }
+        return nil, invoke.ExecPluginWithoutResult(ctx, pluginPath, netconf, invokeArgs, nil)
+    }
+    return invoke.ExecPluginWithResult(ctx, pluginPath, d.Config, invokeArgs, nil)
+}
+
+// delegateConfig returns the delegation's configuration with its ADD
+// result set as prevResult, when one was recorded
+func delegateConfig(d *state.Delegation) ([]byte, error) {
+    if len(d.Result) == 0 {
+        return d.Config, nil
+    }
+    
+    var netconf map[string]interface{}
+    if err := json.Unmarshal(d.Config, &netconf); err != nil {
+        return nil, fmt.Errorf("network %q: failed to parse config: %v", d.Network, err)
+    }
+    netconf["prevResult"] = d.Result
+    
+    out, err := json.Marshal(netconf)
+    if err != nil {
+        return nil, fmt.Errorf("network %q: failed to encode config: %v", d.Network, err)
+    }
+    return out, nil
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// addAttachments creates every attachment of a multi-NIC configuration and
+// merges their results. Attachments already created are removed again when
+// a later one fails, their pod links included, which DEL leaves to the
+// namespace's removal.
+func addAttachments(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) (*current.Result, error) {
+    result := &current.Result{CNIVersion: conf.CNIVersion}
+    
+    for i := range conf.Attachments {
+        subArgs, subConf, err := attachmentInvocation(args, conf, i)
+        if err != nil {
+            return nil, err
+        }
+        
+        r, err := AddVlanNetwork(ctx, subArgs, subConf)
+        if err != nil {
+            for j := i - 1; j >= 0; j-- {
+                if undoArgs, undoConf, e := attachmentInvocation(args, conf, j); e == nil {
+                    if !undoConf.LinkInContainer {
+                        _ = delContainerLink(ctx, undoArgs, undoConf, nil)
+                    }
+                    _ = DelVlanNetwork(ctx, undoArgs, undoConf)
+                }
+            }
+            return nil, fmt.Errorf("attachment %q: %v", subArgs.IfName, err)
+        }
+        mergeResult(result, r)
+    }
+    
+    return result, nil
+}
+
+// delAttachments removes every attachment, carrying on past failures
+func delAttachments(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    var firstErr error
+    for i := range conf.Attachments {
+        subArgs, subConf, err := attachmentInvocation(args, conf, i)
+        if err == nil {
+            err = DelVlanNetwork(ctx, subArgs, subConf)
+        }
+        if err != nil && firstErr == nil {
+            firstErr = fmt.Errorf("attachment %q: %v", conf.Attachments[i].IfName, err)
+        }
+    }
+    return firstErr
+}
+
+// checkAttachments checks every attachment
+func checkAttachments(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    for i := range conf.Attachments {
+        subArgs, subConf, err := attachmentInvocation(args, conf, i)
+        if err != nil {
+            return err
+        }
+        if err := CheckVlanNetwork(ctx, subArgs, subConf); err != nil {
+            return fmt.Errorf("attachment %q: %v", subArgs.IfName, err)
+        }
+    }
+    return nil
+}
+
+// attachmentInvocation builds the arguments and configuration of a single
+// attachment. The stdin handed to IPAM plugins is rewritten to carry the
+// attachment's own ipam section.
+func attachmentInvocation(args *skel.CmdArgs, conf *config.NetConf, i int) (*skel.CmdArgs, *config.NetConf, error) {
+    sub := conf.ForAttachment(i)
+    
+    var doc map[string]json.RawMessage
+    if err := json.Unmarshal(args.StdinData, &doc); err != nil {
+        return nil, nil, fmt.Errorf("failed to parse network configuration: %v", err)
+    }
+    delete(doc, "attachments")
+    delete(doc, "ipam")
+    if sub.IPAMConfig != nil {
+        doc["ipam"] = sub.IPAMConfig.Raw()
+    }
+    doc["master"], _ = json.Marshal(sub.Master)
+    doc["vlan"], _ = json.Marshal(sub.VlanID)
+    
+    stdin, err := json.Marshal(doc)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to encode attachment configuration: %v", err)
+    }
+    
+    subArgs := *args
+    subArgs.IfName = conf.Attachments[i].IfName
+    subArgs.StdinData = stdin
+    return &subArgs, sub, nil
+}
+
+// mergeResult appends one attachment's result to the combined one, keeping
+// IP to interface references pointing at the right entry
+func mergeResult(into, r *current.Result) {
+    offset := len(into.Interfaces)
+    into.Interfaces = append(into.Interfaces, r.Interfaces...)
+    
+    for _, ipc := range r.IPs {
+        if ipc.Interface != nil {
+            ipc.Interface = current.Int(*ipc.Interface + offset)
+        }
+        into.IPs = append(into.IPs, ipc)
+    }
+    into.Routes = append(into.Routes, r.Routes...)
+    
+    into.DNS.Nameservers = append(into.DNS.Nameservers, r.DNS.Nameservers...)
+    into.DNS.Search = append(into.DNS.Search, r.DNS.Search...)
+    into.DNS.Options = append(into.DNS.Options, r.DNS.Options...)
+    if into.DNS.Domain == "" {
+        into.DNS.Domain = r.DNS.Domain
+    }
+}
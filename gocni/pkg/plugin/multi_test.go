@@ -0,0 +1,172 @@
+package plugin
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "sort"
+    "testing"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/containernetworking/plugins/pkg/testutils"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// podLinks lists the links of the pod namespace other than loopback
+func podLinks(t *testing.T, podNS ns.NetNS) []string {
+    t.Helper()
+    var names []string
+    if err := podNS.Do(func(ns.NetNS) error {
+        links, err := netlink.LinkList()
+        if err != nil {
+            return err
+        }
+        for _, l := range links {
+            if l.Attrs().Name != "lo" {
+                names = append(names, l.Attrs().Name)
+            }
+        }
+        return nil
+    }); err != nil {
+        t.Fatal(err)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// TestAttachmentsAddDel runs ADD and DEL of multi-attachment configurations
+// against veth masters in throwaway namespaces. A failed ADD must leave
+// nothing behind, and DEL must be repeatable and release every address. It
+// needs root.
+func TestAttachmentsAddDel(t *testing.T) {
+    if os.Geteuid() != 0 {
+        t.Skip("needs root to create network namespaces")
+    }
+    hostLocal(t)
+    
+    tests := []struct {
+        name    string
+        masters [][2]string
+        wantErr bool
+        want    []string
+    }{
+        {"one master", [][2]string{{"net1", "mst0"}, {"net2", "mst0"}}, false, []string{"net1", "net2"}},
+        {"two masters", [][2]string{{"net1", "mst0"}, {"net2", "mst1"}}, false, []string{"net1", "net2"}},
+        {"later attachment fails", [][2]string{{"net1", "mst0"}, {"net2", "mst0"}, {"net3", "missing0"}}, true, nil},
+    }
+    for _, tt := range tests {
+        hostNS, err := testutils.NewNS()
+        if err != nil {
+            t.Fatal(err)
+        }
+        podNS, err := testutils.NewNS()
+        if err != nil {
+            t.Fatal(err)
+        }
+        dir := t.TempDir()
+        
+        ipam := `{"type": "host-local", "dataDir": "` + filepath.Join(dir, "ipam") + `", "ranges": [[{"subnet": "192.0.2.0/24"}]]}`
+        attachments := ""
+        for i, m := range tt.masters {
+            if i > 0 {
+                attachments += ", "
+            }
+            attachments += `{"ifName": "` + m[0] + `", "master": "` + m[1] + `", "vlan": 0, "ipam": ` + ipam + `}`
+        }
+        stdin := []byte(`{"cniVersion": "1.0.0", "name": "multi", "type": "vlan-cni", "offload": "off", "stateDir": "` + filepath.Join(dir, "state") + `", "attachments": [` + attachments + `]}`)
+        conf, err := config.ParseConfig(stdin)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        args := &skel.CmdArgs{
+            ContainerID: "0123456789abcdef",
+            Netns:       podNS.Path(),
+            IfName:      "eth0",
+            StdinData:   stdin,
+        }
+        ctx := context.Background()
+        
+        var noVeth error
+        err = hostNS.Do(func(ns.NetNS) error {
+            for _, name := range []string{"mst0", "mst1"} {
+                veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"}
+                if err := netlink.LinkAdd(veth); err != nil {
+                    noVeth = err
+                    return nil
+                }
+                if err := netlink.LinkSetUp(veth); err != nil {
+                    return err
+                }
+            }
+            
+            result, err := AddVlanNetwork(ctx, args, conf)
+            if tt.wantErr {
+                if err == nil {
+                    t.Errorf("%s: ADD succeeded", tt.name)
+                }
+            } else if err != nil {
+                return err
+            } else {
+                pod := 0
+                for _, iface := range result.Interfaces {
+                    if iface.Sandbox != "" {
+                        pod++
+                    }
+                }
+                if pod != len(tt.want) || len(result.IPs) != len(tt.want) {
+                    t.Errorf("%s: got %d pod interfaces and %d addresses, want %d of each", tt.name, pod, len(result.IPs), len(tt.want))
+                }
+                seen := map[string]bool{}
+                for _, ipc := range result.IPs {
+                    if seen[ipc.Address.String()] {
+                        t.Errorf("%s: %s allocated twice", tt.name, ipc.Address.String())
+                    }
+                    seen[ipc.Address.String()] = true
+                    if ipc.Interface == nil || result.Interfaces[*ipc.Interface].Sandbox != podNS.Path() {
+                        t.Errorf("%s: %s does not point at a pod interface", tt.name, ipc.Address.String())
+                    }
+                }
+            }
+            if got := podLinks(t, podNS); !equalStrings(got, tt.want) {
+                t.Errorf("%s: after ADD got links %q, want %q", tt.name, got, tt.want)
+            }
+            
+            // The pod links go with the namespace
+            for i := 0; i < 2; i++ {
+                if err := DelVlanNetwork(ctx, args, conf); err != nil {
+                    t.Errorf("%s: DEL %d: %v", tt.name, i, err)
+                }
+            }
+            return nil
+        })
+        testutils.UnmountNS(podNS)
+        testutils.UnmountNS(hostNS)
+        if noVeth != nil {
+            t.Skipf("cannot create veth master: %v", noVeth)
+        }
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        
+        // host-local keeps a file per address, and a lock and cursor
+        leases, _ := filepath.Glob(filepath.Join(dir, "ipam", "multi", "192.0.2.*"))
+        if len(leases) != 0 {
+            t.Errorf("%s: addresses still allocated after DEL: %q", tt.name, leases)
+        }
+    }
+}
+
+func equalStrings(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,34 @@
+package plugin
+
+import (
+    "fmt"
+
+    "github.com/containernetworking/cni/pkg/skel"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// checkNamespace refuses ADD for pods outside the network's allowed
+// namespaces. Without pod metadata in CNI_ARGS the caller cannot be placed,
+// so a restricted network is refused as well.
+func checkNamespace(args *skel.CmdArgs, conf *config.NetConf) error {
+    if len(conf.AllowedNamespaces) == 0 {
+        return nil
+    }
+    
+    var namespace string
+    if args.Args != "" {
+        k8sArgs, err := config.LoadK8sArgs(args.Args)
+        if err != nil {
+            return err
+        }
+        namespace = string(k8sArgs.K8S_POD_NAMESPACE)
+    }
+    if namespace == "" {
+        return fmt.Errorf("network %q is restricted to allowed namespaces and CNI_ARGS has no K8S_POD_NAMESPACE", conf.Name)
+    }
+    if !conf.AllowsNamespace(namespace) {
+        return fmt.Errorf("namespace %q may not attach to network %q", namespace, conf.Name)
+    }
+    return nil
+}
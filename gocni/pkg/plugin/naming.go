@@ -0,0 +1,137 @@
+package plugin
+
+import (
+    "bytes"
+    "fmt"
+    "text/template"
+
+    "github.com/containernetworking/cni/pkg/skel"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+const (
+    // maxIfNameLen is the longest interface name the kernel accepts (IFNAMSIZ - 1)
+    maxIfNameLen = 15
+
+    // shortContainerIDLen is how much of the container ID templates get to see
+    shortContainerIDLen = 8
+
+    // untaggedIfNamePrefix prefixes default host names of untagged attachments,
+    // which need one link per pod rather than one per VLAN
+    untaggedIfNamePrefix = "vlu"
+)
+
+// ifNameData is the set of pod values exposed to naming and metadata templates
+type ifNameData struct {
+    Master       string
+    VlanID       int
+    ContainerID  string
+    PodName      string
+    PodNamespace string
+    PodUID       string
+}
+
+// newIfNameData collects template values from the CNI invocation
+func newIfNameData(args *skel.CmdArgs, conf *config.NetConf) (*ifNameData, error) {
+    data := &ifNameData{
+        Master:      conf.Master,
+        VlanID:      conf.VlanID,
+        ContainerID: args.ContainerID,
+    }
+    if len(data.ContainerID) > shortContainerIDLen {
+        data.ContainerID = data.ContainerID[:shortContainerIDLen]
+    }
+    
+    if args.Args != "" {
+        k8sArgs, err := config.LoadK8sArgs(args.Args)
+        if err != nil {
+            return nil, err
+        }
+        data.PodName = string(k8sArgs.K8S_POD_NAME)
+        data.PodNamespace = string(k8sArgs.K8S_POD_NAMESPACE)
+        data.PodUID = string(k8sArgs.K8S_POD_UID)
+    }
+    
+    return data, nil
+}
+
+// attachmentNameData rebuilds template values from a saved attachment, for
+// DEL where the pod metadata may no longer be passed
+func attachmentNameData(a *state.Attachment) *ifNameData {
+    data := &ifNameData{
+        Master:       a.Master,
+        VlanID:       a.VlanID,
+        ContainerID:  a.ContainerID,
+        PodName:      a.PodName,
+        PodNamespace: a.PodNamespace,
+        PodUID:       a.PodUID,
+    }
+    if len(data.ContainerID) > shortContainerIDLen {
+        data.ContainerID = data.ContainerID[:shortContainerIDLen]
+    }
+    return data
+}
+
+// hostIfName returns the name of the VLAN interface while it lives on the
+// host. Names longer than IFNAMSIZ allows are replaced by a hashed name whose
+// mapping is kept in the state store.
+func hostIfName(conf *config.NetConf, data *ifNameData, store *state.Store, containerID string) (string, error) {
+    name := fmt.Sprintf("%s.%d", data.Master, data.VlanID)
+    if data.VlanID == 0 {
+        name = untaggedIfNamePrefix + data.ContainerID
+    }
+    if conf.HostIfNameTemplate != "" {
+        var err error
+        name, err = renderTemplate("hostIfNameTemplate", conf.HostIfNameTemplate, data)
+        if err != nil {
+            return "", err
+        }
+    }
+    
+    if len(name) <= maxIfNameLen {
+        return checkIfName(name)
+    }
+    
+    return store.HashedName(name, data.Master, maxIfNameLen, containerID)
+}
+
+// containerIfName returns the name of the interface inside the container
+func containerIfName(args *skel.CmdArgs, conf *config.NetConf, data *ifNameData) (string, error) {
+    if conf.ContainerIfNameTemplate == "" {
+        return args.IfName, nil
+    }
+    
+    name, err := renderTemplate("containerIfNameTemplate", conf.ContainerIfNameTemplate, data)
+    if err != nil {
+        return "", err
+    }
+    return checkIfName(name)
+}
+
+// renderTemplate executes a naming or metadata template against the pod values
+func renderTemplate(field, tmpl string, data *ifNameData) (string, error) {
+    t, err := template.New(field).Option("missingkey=error").Parse(tmpl)
+    if err != nil {
+        return "", fmt.Errorf("invalid %s %q: %v", field, tmpl, err)
+    }
+    
+    var buf bytes.Buffer
+    if err := t.Execute(&buf, data); err != nil {
+        return "", fmt.Errorf("failed to render %s %q: %v", field, tmpl, err)
+    }
+    
+    return buf.String(), nil
+}
+
+// checkIfName rejects names the kernel would refuse or silently truncate
+func checkIfName(name string) (string, error) {
+    if name == "" {
+        return "", fmt.Errorf("interface name is empty")
+    }
+    if len(name) > maxIfNameLen {
+        return "", fmt.Errorf("interface name %q exceeds %d characters", name, maxIfNameLen)
+    }
+    return name, nil
+}
@@ -0,0 +1,48 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "os"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/kube"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// NodeValues returns the node's labels and annotations for rendering
+// templated configuration, as the daemon synced them into the state
+// directory. Without a sync it falls back to reading the node from the API
+// server when the configuration names a kubeconfig.
+func NodeValues(stateDir, kubeconfig string) (*config.NodeValues, error) {
+    store, err := state.NewStore(stateDir)
+    if err != nil {
+        return nil, err
+    }
+    synced, err := store.GetNodeValues()
+    if err != nil {
+        return nil, err
+    }
+    if synced != nil {
+        return &config.NodeValues{Name: synced.Name, Labels: synced.Labels, Annotations: synced.Annotations}, nil
+    }
+    if kubeconfig == "" {
+        return nil, fmt.Errorf("the daemon has not synced node values and no kubeconfig is configured")
+    }
+    
+    nodeName, _ := os.Hostname()
+    client, err := kube.NewClient(kubeconfig)
+    if err != nil {
+        return nil, err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), metaPodTimeout)
+    defer cancel()
+    
+    node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to look up node %s: %v", nodeName, err)
+    }
+    return &config.NodeValues{Name: node.Name, Labels: node.Labels, Annotations: node.Annotations}, nil
+}
@@ -0,0 +1,126 @@
+package plugin
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "syscall"
+
+    "github.com/vishvananda/netlink"
+    "github.com/vishvananda/netlink/nl"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+const (
+    // tcaClsFlagsSkipSW asks for a filter that only exists in hardware
+    tcaClsFlagsSkipSW = 1 << 1
+    
+    // tcHMinPriority is where hardware traffic class IDs start (hw_tc)
+    tcHMinPriority = 0xffe0
+    
+    // ethP8021Q is the ethertype of tagged frames
+    ethP8021Q = 0x8100
+)
+
+// steerVlan programs a hardware-only flower filter on the master's ingress
+// that steers frames of the attachment's VLAN into the configured hardware
+// traffic class. The filter is shared by every attachment of the VLAN. With
+// offload "auto" a NIC without tc offload leaves the software path alone.
+func steerVlan(ctx context.Context, conf *config.NetConf) error {
+    if conf.Offload == config.OffloadOff || conf.VlanID == 0 {
+        return nil
+    }
+    
+    master, err := lookupMaster(ctx, conf, nil)
+    if err != nil {
+        err = fmt.Errorf("failed to lookup master interface %q: %v", conf.Master, err)
+    } else {
+        err = addSteeringFilter(master, conf.VlanID, conf.OffloadTrafficClass)
+    }
+    if err != nil && conf.Offload == config.OffloadAuto {
+        return nil
+    }
+    return err
+}
+
+// unsteerVlan removes the VLAN's filter once no attachment uses it
+func unsteerVlan(ctx context.Context, store *state.Store, conf *config.NetConf) error {
+    if conf.Offload == config.OffloadOff || conf.VlanID == 0 {
+        return nil
+    }
+    
+    attachments, err := store.ListAttachments()
+    if err != nil {
+        return err
+    }
+    for _, a := range attachments {
+        if a.Master == conf.Master && a.VlanID == conf.VlanID {
+            return nil
+        }
+    }
+    
+    master, err := lookupMaster(ctx, conf, nil)
+    if err != nil {
+        return nil
+    }
+    err = netlink.FilterDel(steeringFilter(master, conf.VlanID))
+    if err != nil && !errors.Is(err, syscall.ENOENT) && conf.Offload == config.OffloadOn {
+        return fmt.Errorf("failed to remove offload filter for VLAN %d: %v", conf.VlanID, err)
+    }
+    return nil
+}
+
+func steeringFilter(master netlink.Link, vlanID int) *netlink.GenericFilter {
+    return &netlink.GenericFilter{
+        FilterAttrs: netlink.FilterAttrs{
+            LinkIndex: master.Attrs().Index,
+            Parent:    netlink.HANDLE_MIN_INGRESS,
+            Handle:    1,
+            Priority:  uint16(vlanID),
+            Protocol:  ethP8021Q,
+        },
+        FilterType: "flower",
+    }
+}
+
+// addSteeringFilter is the netlink equivalent of
+// "tc filter replace dev <master> ingress prio <vlan> protocol 802.1Q
+// flower skip_sw vlan_id <vlan> hw_tc <tc>"; the netlink library cannot
+// express VLAN keys or hw_tc yet
+func addSteeringFilter(master netlink.Link, vlanID, trafficClass int) error {
+    masterName := master.Attrs().Name
+    clsact := &netlink.GenericQdisc{
+        QdiscAttrs: netlink.QdiscAttrs{
+            LinkIndex: master.Attrs().Index,
+            Handle:    netlink.MakeHandle(0xffff, 0),
+            Parent:    netlink.HANDLE_CLSACT,
+        },
+        QdiscType: "clsact",
+    }
+    if err := netlink.QdiscReplace(clsact); err != nil {
+        return fmt.Errorf("failed to add clsact qdisc to %q: %v", masterName, err)
+    }
+    
+    req := nl.NewNetlinkRequest(syscall.RTM_NEWTFILTER, syscall.NLM_F_CREATE|syscall.NLM_F_ACK)
+    req.AddData(&nl.TcMsg{
+        Family:  nl.FAMILY_ALL,
+        Ifindex: int32(master.Attrs().Index),
+        Handle:  1,
+        Parent:  netlink.HANDLE_MIN_INGRESS,
+        Info:    netlink.MakeHandle(uint16(vlanID), nl.Swap16(ethP8021Q)),
+    })
+    req.AddData(nl.NewRtAttr(nl.TCA_KIND, nl.ZeroTerminated("flower")))
+    
+    options := nl.NewRtAttr(nl.TCA_OPTIONS, nil)
+    options.AddRtAttr(nl.TCA_FLOWER_KEY_VLAN_ID, nl.Uint16Attr(uint16(vlanID)))
+    options.AddRtAttr(nl.TCA_FLOWER_FLAGS, nl.Uint32Attr(tcaClsFlagsSkipSW))
+    options.AddRtAttr(nl.TCA_FLOWER_CLASSID, nl.Uint32Attr(netlink.MakeHandle(0xffff, uint16(tcHMinPriority+trafficClass))))
+    req.AddData(options)
+    
+    if _, err := req.Execute(syscall.NETLINK_ROUTE, 0); err != nil {
+        return fmt.Errorf("failed to offload VLAN %d steering on %q: %v", vlanID, masterName, err)
+    }
+    return nil
+}
@@ -0,0 +1,157 @@
+package plugin
+
+import (
+    "context"
+    "crypto/sha1"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/compat"
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// plan collects the kernel operations of one invocation in plan mode
+type plan struct {
+    lines []string
+}
+
+func (p *plan) add(format string, a ...interface{}) {
+    p.lines = append(p.lines, fmt.Sprintf(format, a...))
+}
+
+// write appends the plan to path, one operation per line
+func (p *plan) write(path string) error {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to open plan file: %v", err)
+    }
+    defer f.Close()
+    if _, err := f.WriteString(strings.Join(p.lines, "\n") + "\n"); err != nil {
+        return fmt.Errorf("failed to write plan file: %v", err)
+    }
+    return nil
+}
+
+// planMAC derives a locally administered unicast MAC from the attachment
+func planMAC(containerID, ifName string) net.HardwareAddr {
+    sum := sha1.Sum([]byte(containerID + "/" + ifName))
+    mac := net.HardwareAddr(sum[:6])
+    mac[0] = mac[0]&0xfc | 0x02
+    return mac
+}
+
+// planAdd is ADD in plan mode
+func planAdd(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) (*current.Result, error) {
+    p := &plan{}
+    p.add("ADD container=%s netns=%s ifname=%s", args.ContainerID, args.Netns, args.IfName)
+    
+    nameData, err := newIfNameData(args, conf)
+    if err != nil {
+        return nil, err
+    }
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return nil, err
+    }
+    vlanName, err := hostIfName(conf, nameData, store, args.ContainerID)
+    if err != nil {
+        return nil, err
+    }
+    contIfName, err := containerIfName(args, conf, nameData)
+    if err != nil {
+        return nil, err
+    }
+    secondaryAddrs, err := conf.SecondaryAddrs()
+    if err != nil {
+        return nil, err
+    }
+    mac := planMAC(args.ContainerID, contIfName)
+    
+    if conf.Simulation == config.SimulationOn {
+        p.add("link add bridge %s%d", simBridgePrefix, conf.VlanID)
+        p.add("link add veth %s mtu %d isolated %t", vlanName, conf.MTU, conf.Isolated)
+    } else {
+        p.add("link add %s %s master %s vlan %d mtu %d isolated %t",
+            compat.LinkKind(conf.VlanID, conf.UntaggedMode), vlanName, conf.Master, conf.VlanID, conf.MTU, conf.Isolated)
+    }
+    p.add("link set %s up", vlanName)
+    p.add("link set %s netns %s", vlanName, args.Netns)
+    
+    p.add("netns: link set %s name %s", vlanName, contIfName)
+    p.add("netns: link set %s up", contIfName)
+    
+    // IPAM works on the named pod interface, as in ADD
+    result := &current.Result{CNIVersion: conf.CNIVersion}
+    if conf.IPAMConfig != nil {
+        p.add("ipam add %s ifname %s", conf.IPAMConfig.Type, contIfName)
+        r, err := ConfigureIPAM(ctx, args, conf, nameData, mac.String())
+        if err != nil {
+            return nil, err
+        }
+        result = r
+    }
+    setInterfaces(result, conf, &current.Interface{Name: conf.Master}, &current.Interface{
+        Name:    contIfName,
+        Mac:     mac.String(),
+        Sandbox: args.Netns,
+    })
+    for _, ipc := range result.IPs {
+        p.add("netns: addr add %s dev %s", ipc.Address.String(), contIfName)
+    }
+    for _, r := range result.Routes {
+        p.add("netns: route add %s via %s dev %s", r.Dst.String(), r.GW, contIfName)
+    }
+    for _, a := range secondaryAddrs {
+        p.add("netns: addr add %s dev %s", a, contIfName)
+    }
+    if conf.AntiSpoof {
+        p.add("netns: tc pin sources of %s", contIfName)
+    }
+    
+    return result, p.write(conf.PlanFile)
+}
+
+// planDel is DEL in plan mode
+func planDel(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    p := &plan{}
+    p.add("DEL container=%s netns=%s ifname=%s", args.ContainerID, args.Netns, args.IfName)
+    
+    if conf.IPAMConfig != nil {
+        if err := ReleaseIPAllocation(ctx, args, conf); err != nil {
+            return err
+        }
+    }
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return err
+    }
+    if err := store.ReleaseNames(args.ContainerID); err != nil {
+        return err
+    }
+    if !conf.DisableConntrackFlush {
+        p.add("conntrack flush")
+    }
+    return p.write(conf.PlanFile)
+}
+
+// planCheck is CHECK in plan mode
+func planCheck(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    p := &plan{}
+    p.add("CHECK container=%s netns=%s ifname=%s", args.ContainerID, args.Netns, args.IfName)
+    ifName := podIfName(args, conf)
+    p.add("netns: link show %s", ifName)
+    
+    if conf.IPAMConfig != nil {
+        p.add("netns: addr show %s", ifName)
+        if err := CheckIPAllocation(ctx, args, conf); err != nil {
+            return err
+        }
+    }
+    return p.write(conf.PlanFile)
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+    "context"
+    "flag"
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+
+    "github.com/containernetworking/cni/pkg/skel"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// TestPlanGolden runs ADD, CHECK and DEL in plan mode and compares the
+// recorded operations with testdata/plan.golden. It needs no privileges.
+func TestPlanGolden(t *testing.T) {
+    dir := t.TempDir()
+    planFile := filepath.Join(dir, "plan")
+    
+    conf, err := config.ParseConfig([]byte(`{
+        "cniVersion": "1.0.0",
+        "name": "plan",
+        "type": "vlan-cni",
+        "attachments": [
+            {"ifName": "net1", "master": "eth1", "vlan": 100, "mtu": 1500},
+            {"ifName": "net2", "master": "eth2", "vlan": 0, "untaggedMode": "ipvlan"}
+        ],
+        "secondaryIPs": ["192.0.2.10/32"],
+        "antiSpoof": true,
+        "stateDir": "` + filepath.Join(dir, "state") + `",
+        "planFile": "` + planFile + `"
+    }`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    args := &skel.CmdArgs{
+        ContainerID: "0123456789abcdef",
+        Netns:       "/var/run/netns/plan",
+        IfName:      "net1",
+        StdinData:   []byte(`{}`),
+    }
+    result, err := AddVlanNetwork(context.Background(), args, conf)
+    if err != nil {
+        t.Fatalf("ADD: %v", err)
+    }
+    // Each attachment lists its master, then its pod interface
+    if len(result.Interfaces) != 4 || result.Interfaces[0].Name != "eth1" || result.Interfaces[0].Sandbox != "" ||
+        result.Interfaces[1].Mac != planMAC(args.ContainerID, "net1").String() || result.Interfaces[1].Sandbox != args.Netns {
+        t.Fatalf("unexpected interfaces %+v", result.Interfaces)
+    }
+    if err := CheckVlanNetwork(context.Background(), args, conf); err != nil {
+        t.Fatalf("CHECK: %v", err)
+    }
+    if err := DelVlanNetwork(context.Background(), args, conf); err != nil {
+        t.Fatalf("DEL: %v", err)
+    }
+    
+    got, err := ioutil.ReadFile(planFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    golden := filepath.Join("testdata", "plan.golden")
+    if *update {
+        if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    want, err := ioutil.ReadFile(golden)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(got) != string(want) {
+        t.Errorf("plan differs from %s, rerun with -update if intended:\n%s", golden, got)
+    }
+}
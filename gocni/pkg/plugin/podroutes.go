@@ -0,0 +1,118 @@
+package plugin
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+
+    "example.com/vlan-cni/pkg/config"
+    vlantypes "example.com/vlan-cni/pkg/types"
+)
+
+// addPodRoutes merges the routes of the pod's annotation into the
+// configuration's, which win for the same destination
+func addPodRoutes(ctx context.Context, conf *config.NetConf, data *ifNameData) error {
+    if data.PodName == "" {
+        return nil
+    }
+    pod, err := getPod(ctx, conf, data)
+    if err != nil {
+        return err
+    }
+    value := pod.Annotations[conf.PodRoutes.Annotation]
+    if strings.TrimSpace(value) == "" {
+        return nil
+    }
+    routes, err := parsePodRoutes(value)
+    if err != nil {
+        return fmt.Errorf("invalid %s annotation of pod %s/%s: %v", conf.PodRoutes.Annotation, data.PodNamespace, data.PodName, err)
+    }
+    
+    ipamConf := *conf.IPAMConfig
+    configured := map[string]bool{}
+    for _, r := range ipamConf.Routes {
+        _, dst, _ := net.ParseCIDR(r.Dst)
+        configured[dst.String()] = true
+    }
+    for _, r := range routes {
+        _, dst, _ := net.ParseCIDR(r.Dst)
+        if !destinationAllowed(conf.PodRoutes, dst) {
+            return fmt.Errorf("pod %s/%s may not route %s", data.PodNamespace, data.PodName, dst)
+        }
+        if configured[dst.String()] {
+            continue
+        }
+        configured[dst.String()] = true
+        ipamConf.Routes = append(ipamConf.Routes, r)
+    }
+    conf.IPAMConfig = &ipamConf
+    return nil
+}
+
+// parsePodRoutes reads either a JSON list of routes or "dst [via gw]
+// [metric n] [table n]" entries separated by newlines or ";"
+func parsePodRoutes(value string) ([]*vlantypes.Route, error) {
+    value = strings.TrimSpace(value)
+    var routes []*vlantypes.Route
+    if strings.HasPrefix(value, "[") {
+        if err := json.Unmarshal([]byte(value), &routes); err != nil {
+            return nil, err
+        }
+    } else {
+        for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == ';' }) {
+            fields := strings.Fields(entry)
+            if len(fields) == 0 {
+                continue
+            }
+            r := &vlantypes.Route{Dst: fields[0]}
+            for i := 1; i < len(fields); i += 2 {
+                if i+1 >= len(fields) {
+                    return nil, fmt.Errorf("%q: %s needs a value", entry, fields[i])
+                }
+                switch fields[i] {
+                case "via":
+                    r.GW = fields[i+1]
+                case "metric", "table":
+                    n, err := strconv.Atoi(fields[i+1])
+                    if err != nil {
+                        return nil, fmt.Errorf("%q: invalid %s %q", entry, fields[i], fields[i+1])
+                    }
+                    if fields[i] == "metric" {
+                        r.Metric = n
+                    } else {
+                        r.Table = n
+                    }
+                default:
+                    return nil, fmt.Errorf("%q: unknown keyword %q", entry, fields[i])
+                }
+            }
+            routes = append(routes, r)
+        }
+    }
+    for _, r := range routes {
+        if err := r.Validate(); err != nil {
+            return nil, err
+        }
+    }
+    return routes, nil
+}
+
+// destinationAllowed reports whether dst lies within the allowed
+// destinations, if any are configured
+func destinationAllowed(pr *config.PodRoutesConfig, dst *net.IPNet) bool {
+    if len(pr.AllowedDestinations) == 0 {
+        return true
+    }
+    ones, _ := dst.Mask.Size()
+    for _, cidr := range pr.AllowedDestinations {
+        _, allowed, _ := net.ParseCIDR(cidr)
+        allowedOnes, _ := allowed.Mask.Size()
+        if allowed.Contains(dst.IP) && ones >= allowedOnes {
+            return true
+        }
+    }
+    return false
+}
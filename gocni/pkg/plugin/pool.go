@@ -0,0 +1,40 @@
+package plugin
+
+import (
+    "fmt"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// takePooledLink claims an idle link from the daemon's warm pool for the
+// attachment's master and VLAN, saving the create on the ADD path. It
+// returns nil when the pool is empty or its links have gone.
+func takePooledLink(store *state.Store, conf *config.NetConf) (netlink.Link, error) {
+    // Pools hold bridge mode links only
+    if conf.Isolated && conf.VlanID == 0 {
+        return nil, nil
+    }
+    
+    key := state.PoolKey(conf.Master, conf.VlanID, conf.UntaggedMode)
+    for {
+        name, err := store.TakePoolLink(key)
+        if err != nil || name == "" {
+            return nil, err
+        }
+        
+        link, err := netlink.LinkByName(name)
+        if err != nil {
+            // Removed behind the pool's back, try the next one
+            continue
+        }
+        if conf.MTU != 0 && link.Attrs().MTU != conf.MTU {
+            if err := netlink.LinkSetMTU(link, conf.MTU); err != nil {
+                return nil, fmt.Errorf("failed to set MTU of pooled link %q: %v", name, err)
+            }
+        }
+        return link, nil
+    }
+}
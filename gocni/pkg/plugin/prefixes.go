@@ -0,0 +1,83 @@
+package plugin
+
+import (
+    "fmt"
+    "net"
+    "syscall"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/containernetworking/plugins/pkg/utils/sysctl"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/dhcp6"
+)
+
+// ipamDelegatedPrefixes takes the IPv6 routes via the pod's own addresses
+// out of the IPAM result and returns their destinations
+func ipamDelegatedPrefixes(result *current.Result) []*net.IPNet {
+    var prefixes []*net.IPNet
+    routes := result.Routes[:0]
+    for _, r := range result.Routes {
+        if r.Dst.IP.To4() == nil && r.GW != nil && ownAddress(result, r.GW) {
+            dst := r.Dst
+            prefixes = append(prefixes, &dst)
+            continue
+        }
+        routes = append(routes, r)
+    }
+    result.Routes = routes
+    return prefixes
+}
+
+// ownAddress reports whether ip is one of the result's addresses
+func ownAddress(result *current.Result, ip net.IP) bool {
+    for _, ipc := range result.IPs {
+        if ipc.Address.IP.Equal(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// routeDelegatedPrefixes makes the pod the router of its delegated
+// prefixes, fromIPAM being those ipamDelegatedPrefixes took, and returns
+// them. It runs inside the container network namespace.
+func routeDelegatedPrefixes(link netlink.Link, conf *config.NetConf, lease *dhcp6.Lease, fromIPAM []*net.IPNet) ([]string, error) {
+    prefixes, err := conf.ArgsDelegatedPrefixes()
+    if err != nil {
+        return nil, err
+    }
+    prefixes = append(prefixes, fromIPAM...)
+    if conf.DelegatedPrefixes.FromDHCPv6 && lease != nil {
+        for _, p := range lease.Prefixes {
+            ipn := net.IPNet(p.Prefix)
+            prefixes = append(prefixes, &ipn)
+        }
+    }
+    if len(prefixes) == 0 {
+        return nil, nil
+    }
+    
+    // Forwarding would otherwise stop the interface from taking its
+    // default route from router advertisements
+    ifName := link.Attrs().Name
+    if _, err := sysctl.Sysctl(fmt.Sprintf("net/ipv6/conf/%s/accept_ra", ifName), "2"); err != nil {
+        return nil, fmt.Errorf("failed to keep accepting router advertisements on %q: %v", ifName, err)
+    }
+    if _, err := sysctl.Sysctl("net/ipv6/conf/all/forwarding", "1"); err != nil {
+        return nil, fmt.Errorf("failed to enable IPv6 forwarding: %v", err)
+    }
+    
+    var delegated []string
+    for _, p := range prefixes {
+        // Traffic for parts of the prefix no workload claims is dropped
+        // rather than bounced back upstream
+        route := &netlink.Route{Dst: p, Type: syscall.RTN_UNREACHABLE}
+        if err := netlink.RouteReplace(route); err != nil {
+            return nil, fmt.Errorf("failed to add unreachable route for delegated prefix %s: %v", p, err)
+        }
+        delegated = append(delegated, p.String())
+    }
+    return delegated, nil
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+    "context"
+    "encoding/json"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/config"
+    vlanipam "example.com/vlan-cni/pkg/ipam"
+    "example.com/vlan-cni/pkg/privsep"
+)
+
+// worker runs config parsing, IPAM and API server lookups without
+// capabilities, see pkg/privsep. Nil runs them in this process.
+var worker *privsep.Worker
+
+// UseWorker routes the untrusted-input work through w
+func UseWorker(w *privsep.Worker) {
+    worker = w
+}
+
+// ParseConfig parses the runtime's network configuration, rendering node
+// templates, in the worker when there is one
+func ParseConfig(ctx context.Context, stdin []byte) (*config.NetConf, error) {
+    if worker == nil {
+        return config.ParseConfigForNode(stdin, NodeValues)
+    }
+    var wire json.RawMessage
+    if err := worker.Call(ctx, "parse", stdin, &wire); err != nil {
+        return nil, err
+    }
+    return config.UnmarshalWire(wire)
+}
+
+// WorkerHandlers are the calls the privsep worker serves
+func WorkerHandlers() map[string]privsep.Handler {
+    return map[string]privsep.Handler{
+        "parse": serveParse,
+        "ipam":  serveIPAM,
+        "pod":   servePod,
+    }
+}
+
+func serveParse(ctx context.Context, params json.RawMessage) (interface{}, error) {
+    var stdin []byte
+    if err := json.Unmarshal(params, &stdin); err != nil {
+        return nil, err
+    }
+    conf, err := config.ParseConfigForNode(stdin, NodeValues)
+    if err != nil {
+        return nil, err
+    }
+    wire, err := conf.MarshalWire()
+    if err != nil {
+        return nil, err
+    }
+    return json.RawMessage(wire), nil
+}
+
+type podCall struct {
+    Conf json.RawMessage `json:"conf"`
+    Data *ifNameData     `json:"data"`
+}
+
+func servePod(ctx context.Context, params json.RawMessage) (interface{}, error) {
+    var call podCall
+    if err := json.Unmarshal(params, &call); err != nil {
+        return nil, err
+    }
+    conf, err := config.UnmarshalWire(call.Conf)
+    if err != nil {
+        return nil, err
+    }
+    return localGetPod(ctx, conf, call.Data)
+}
+
+// ipamCall is one driver call, with what the worker needs to build the
+// driver
+type ipamCall struct {
+    Op      string            `json:"op"`
+    Args    *skel.CmdArgs     `json:"args"`
+    Conf    json.RawMessage   `json:"conf"`
+    Data    *ifNameData       `json:"data"`
+    Request *vlanipam.Request `json:"request,omitempty"`
+
+    // Request leaves it out of its encoding
+    GuessedNodeName bool `json:"guessedNodeName,omitempty"`
+}
+
+func serveIPAM(ctx context.Context, params json.RawMessage) (interface{}, error) {
+    var call ipamCall
+    if err := json.Unmarshal(params, &call); err != nil {
+        return nil, err
+    }
+    conf, err := config.UnmarshalWire(call.Conf)
+    if err != nil {
+        return nil, err
+    }
+    driver, err := localIPAMDriver(call.Args, conf, call.Data)
+    if err != nil {
+        return nil, err
+    }
+    if call.Request != nil {
+        call.Request.GuessedNodeName = call.GuessedNodeName
+    }
+    
+    switch call.Op {
+    case "allocate":
+        return driver.Allocate(ctx, call.Request)
+    case "release":
+        return nil, driver.Release(ctx, call.Request)
+    case "check":
+        return nil, driver.Check(ctx, call.Request)
+    default:
+        return nil, driver.Health(ctx)
+    }
+}
+
+// workerDriver is the privileged side of a driver running in the worker
+type workerDriver struct {
+    call ipamCall
+}
+
+func (d *workerDriver) do(ctx context.Context, op string, req *vlanipam.Request, result interface{}) error {
+    call := d.call
+    call.Op = op
+    call.Request = req
+    if req != nil {
+        call.GuessedNodeName = req.GuessedNodeName
+    }
+    return worker.Call(ctx, "ipam", call, result)
+}
+
+func (d *workerDriver) Allocate(ctx context.Context, req *vlanipam.Request) (*current.Result, error) {
+    result := &current.Result{}
+    if err := d.do(ctx, "allocate", req, result); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+func (d *workerDriver) Release(ctx context.Context, req *vlanipam.Request) error {
+    return d.do(ctx, "release", req, nil)
+}
+
+func (d *workerDriver) Check(ctx context.Context, req *vlanipam.Request) error {
+    return d.do(ctx, "check", req, nil)
+}
+
+func (d *workerDriver) Health(ctx context.Context) error {
+    return d.do(ctx, "health", nil, nil)
+}
@@ -0,0 +1,146 @@
+package plugin
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "syscall"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// nodeNetworks returns the networks kubelet probes come from: the
+// configured ones, or those of the interface of the host's default route.
+// It runs in the host network namespace.
+func nodeNetworks(conf *config.NetConf) ([]*net.IPNet, error) {
+    p := conf.ProbeRouting
+    var networks []*net.IPNet
+    for _, cidr := range p.NodeCIDRs {
+        _, network, _ := net.ParseCIDR(cidr)
+        networks = append(networks, network)
+    }
+    if len(networks) > 0 {
+        return networks, nil
+    }
+    
+    routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list host routes: %v", err)
+    }
+    links := map[int]bool{}
+    for _, r := range routes {
+        if isDefaultRoute(r) && r.LinkIndex > 0 && !links[r.LinkIndex] {
+            links[r.LinkIndex] = true
+            link, err := netlink.LinkByIndex(r.LinkIndex)
+            if err != nil {
+                continue
+            }
+            addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+            if err != nil {
+                return nil, fmt.Errorf("failed to list addresses of %q: %v", link.Attrs().Name, err)
+            }
+            for _, a := range addrs {
+                if a.Scope == int(netlink.SCOPE_UNIVERSE) {
+                    networks = append(networks, &net.IPNet{IP: a.IP.Mask(a.Mask), Mask: a.Mask})
+                }
+            }
+        }
+    }
+    if len(networks) == 0 {
+        fmt.Fprintf(warnLog, "level=warn msg=%q\n", "no host default route to take the node networks from, set probeRouting.nodeCIDRs")
+    }
+    return networks, nil
+}
+
+// keepProbesOnPrimary copies the routes of the interfaces whose default
+// routes are about to be taken into the probe routing table, and sends
+// their replies, traffic to the node and to the kubelet there. It runs
+// inside the container network namespace, before the default routes move.
+func keepProbesOnPrimary(conf *config.NetConf, others []netlink.Route, node []*net.IPNet) error {
+    p := conf.ProbeRouting
+    families := map[int]bool{}
+    primaries := map[int]bool{}
+    for _, r := range others {
+        families[routeFamily(r)] = true
+        primaries[r.LinkIndex] = true
+    }
+    
+    for index := range primaries {
+        link, err := netlink.LinkByIndex(index)
+        if err != nil {
+            return fmt.Errorf("failed to look up interface %d: %v", index, err)
+        }
+        routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+        if err != nil {
+            return fmt.Errorf("failed to list routes of %q: %v", link.Attrs().Name, err)
+        }
+        for _, r := range routes {
+            r.Table = p.Table
+            if err := netlink.RouteReplace(&r); err != nil {
+                return fmt.Errorf("failed to copy route %v of %q to table %d: %v", r.Dst, link.Attrs().Name, p.Table, err)
+            }
+        }
+        
+        addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+        if err != nil {
+            return fmt.Errorf("failed to list addresses of %q: %v", link.Attrs().Name, err)
+        }
+        for _, a := range addrs {
+            if a.Scope != int(netlink.SCOPE_UNIVERSE) {
+                continue
+            }
+            rule := probeRule(conf, familyOf(a.IP))
+            rule.Src = &net.IPNet{IP: a.IP, Mask: net.CIDRMask(len(a.IP)*8, len(a.IP)*8)}
+            if err := addRule(rule); err != nil {
+                return err
+            }
+        }
+    }
+    
+    for _, network := range node {
+        family := familyOf(network.IP)
+        if !families[family] {
+            continue
+        }
+        rule := probeRule(conf, family)
+        rule.Dst = network
+        if err := addRule(rule); err != nil {
+            return err
+        }
+    }
+    
+    for family := range families {
+        rule := probeRule(conf, family)
+        rule.IPProto = syscall.IPPROTO_TCP
+        rule.Dport = netlink.NewRulePortRange(uint16(p.KubeletPort), uint16(p.KubeletPort))
+        if err := addRule(rule); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// probeRule is a rule of family looking up the probe routing table
+func probeRule(conf *config.NetConf, family int) *netlink.Rule {
+    rule := netlink.NewRule()
+    rule.Family = family
+    rule.Table = conf.ProbeRouting.Table
+    rule.Priority = conf.ProbeRouting.Priority
+    return rule
+}
+
+func addRule(rule *netlink.Rule) error {
+    if err := netlink.RuleAdd(rule); err != nil && !errors.Is(err, syscall.EEXIST) {
+        return fmt.Errorf("failed to add rule %s: %v", rule, err)
+    }
+    return nil
+}
+
+func familyOf(ip net.IP) int {
+    if ip.To4() != nil {
+        return netlink.FAMILY_V4
+    }
+    return netlink.FAMILY_V6
+}
@@ -0,0 +1,109 @@
+package plugin
+
+import (
+    "fmt"
+    "net"
+    "os/exec"
+    "strconv"
+    "strings"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/hostlink"
+)
+
+// greKeyFlag is GRE_KEY of the tunnel's i/o flags
+const greKeyFlag = 0x2000
+
+// ensurePseudowire gives the VLAN's bridge its pseudowire as uplink,
+// creating it on the first ADD. Concurrent ADDs may race to create it, so
+// a failure only counts when the link is still missing.
+func ensurePseudowire(conf *config.NetConf, bridge netlink.Link) error {
+    name := conf.Master
+    link, err := netlink.LinkByName(name)
+    if err != nil {
+        addErr := addPseudowire(conf, name)
+        if link, err = netlink.LinkByName(name); err != nil {
+            if addErr != nil {
+                return addErr
+            }
+            return fmt.Errorf("failed to lookup pseudowire %q: %v", name, err)
+        }
+    }
+    _ = hostlink.Mark(link)
+    if link.Attrs().MasterIndex != bridge.Attrs().Index {
+        if err := netlink.LinkSetMaster(link, bridge); err != nil {
+            return fmt.Errorf("failed to attach pseudowire %q to %q: %v", name, bridge.Attrs().Name, err)
+        }
+    }
+    if err := netlink.LinkSetUp(link); err != nil {
+        return fmt.Errorf("failed to set %q up: %v", name, err)
+    }
+    return nil
+}
+
+func addPseudowire(conf *config.NetConf, name string) error {
+    p := conf.Pseudowire
+    if p.Type == config.PseudowireL2TPv3 {
+        return addL2TPSession(conf, name)
+    }
+    
+    remote := net.ParseIP(p.Remote)
+    local := net.ParseIP(p.Local)
+    if local == nil {
+        // The family of Local picks gretap or ip6gretap
+        local = net.IPv4zero
+        if remote.To4() == nil {
+            local = net.IPv6zero
+        }
+    }
+    tap := &netlink.Gretap{
+        LinkAttrs: netlink.LinkAttrs{Name: name, MTU: conf.MTU},
+        Remote:    remote,
+        Local:     local,
+        IKey:      uint32(p.Key),
+        OKey:      uint32(p.Key),
+        IFlags:    greKeyFlag,
+        OFlags:    greKeyFlag,
+        PMtuDisc:  1,
+    }
+    if err := netlink.LinkAdd(tap); err != nil {
+        return fmt.Errorf("failed to create pseudowire %q: %v", name, err)
+    }
+    return nil
+}
+
+// addL2TPSession creates the L2TPv3 tunnel, unless another session holds
+// it, and the session's ethernet device. netlink has no L2TP support, so
+// this goes through ip.
+func addL2TPSession(conf *config.NetConf, name string) error {
+    p := conf.Pseudowire
+    tunnelID := strconv.Itoa(p.TunnelID)
+    argv := []string{"l2tp", "add", "tunnel", "tunnel_id", tunnelID, "peer_tunnel_id", tunnelID,
+        "encap", p.Encap, "local", p.Local, "remote", p.Remote}
+    if p.Encap == "udp" {
+        port := strconv.Itoa(p.Port)
+        argv = append(argv, "udp_sport", port, "udp_dport", port)
+    }
+    if out, err := exec.Command("ip", argv...).CombinedOutput(); err != nil && !strings.Contains(string(out), "exists") {
+        return fmt.Errorf("failed to create L2TPv3 tunnel %s: %v: %s (is the l2tp_eth module available?)", tunnelID, err, strings.TrimSpace(string(out)))
+    }
+    
+    sessionID := strconv.Itoa(p.Key)
+    argv = []string{"l2tp", "add", "session", "name", name, "tunnel_id", tunnelID,
+        "session_id", sessionID, "peer_session_id", sessionID}
+    if out, err := exec.Command("ip", argv...).CombinedOutput(); err != nil {
+        return fmt.Errorf("failed to create L2TPv3 session %s: %v: %s", sessionID, err, strings.TrimSpace(string(out)))
+    }
+    if conf.MTU != 0 {
+        link, err := netlink.LinkByName(name)
+        if err != nil {
+            return fmt.Errorf("failed to lookup pseudowire %q: %v", name, err)
+        }
+        if err := netlink.LinkSetMTU(link, conf.MTU); err != nil {
+            return fmt.Errorf("failed to set MTU %d on %q: %v", conf.MTU, name, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// admitAdd takes a token from the network's bucket, set up by the node
+// daemon when the network is rate limited. ADDs wait for a token while at
+// least half their deadline would be left for the work itself, and are
+// told to try again later otherwise.
+func admitAdd(ctx context.Context, store *state.Store, conf *config.NetConf) error {
+    for {
+        wait, err := takeToken(store, conf.Name)
+        if err != nil || wait == 0 {
+            return err
+        }
+        if deadline, ok := ctx.Deadline(); ok && wait > time.Until(deadline)/2 {
+            return types.NewError(types.ErrTryAgainLater,
+                fmt.Sprintf("network %q is rate limited on this node", conf.Name),
+                fmt.Sprintf("next token in %s", wait.Round(time.Millisecond)))
+        }
+        
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return ctx.Err()
+        case <-timer.C:
+        }
+    }
+}
+
+// takeToken takes a token, or returns how long until one is available
+func takeToken(store *state.Store, network string) (time.Duration, error) {
+    if err := store.Lock(); err != nil {
+        return 0, err
+    }
+    defer store.Unlock()
+    
+    b, err := store.GetTokenBucket(network)
+    if err != nil || b == nil {
+        return 0, err
+    }
+    now := time.Now()
+    b.Refill(now)
+    if b.Tokens < 1 {
+        if b.Rate <= 0 {
+            return 0, fmt.Errorf("invalid rate limit for network %q: rate %v", network, b.Rate)
+        }
+        // Rounded up, zero would mean the token was taken
+        return time.Duration((1-b.Tokens)/b.Rate*float64(time.Second)) + time.Millisecond, nil
+    }
+    b.Tokens--
+    return 0, store.SaveTokenBucket(network, b)
+}
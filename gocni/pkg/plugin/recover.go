@@ -0,0 +1,58 @@
+package plugin
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "runtime/debug"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// RecoverPanic is deferred by the plugin commands. It turns a panic into a
+// CNI error the runtime reports, instead of a crash with nothing on stdout,
+// and appends the stack to the configured log file.
+func RecoverPanic(stdin []byte, errp *error) {
+    if r := recover(); r != nil {
+        *errp = panicError(logFile(stdin), r, debug.Stack())
+    }
+}
+
+// recoverInto is RecoverPanic for goroutines started by the plugin, which
+// the commands' own recovery does not cover
+func recoverInto(conf *config.NetConf, errp *error) {
+    if r := recover(); r != nil {
+        *errp = panicError(conf.LogFile, r, debug.Stack())
+    }
+}
+
+// logFile picks the log file out of a configuration that may not parse
+func logFile(stdin []byte) string {
+    conf := struct {
+        LogFile string `json:"logFile"`
+    }{}
+    if err := json.Unmarshal(stdin, &conf); err != nil || conf.LogFile == "" {
+        return config.DefaultLogFile
+    }
+    return conf.LogFile
+}
+
+func panicError(path string, r interface{}, stack []byte) error {
+    details := fmt.Sprintf("stack written to %s", path)
+    
+    entry := fmt.Sprintf("%s panic: %v\n%s\n", time.Now().Format(time.RFC3339), r, stack)
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+    if err == nil {
+        _, err = f.WriteString(entry)
+        f.Close()
+    }
+    if err != nil {
+        // Fall back to stderr, which the runtime keeps in its own log
+        fmt.Fprint(os.Stderr, entry)
+        details = fmt.Sprintf("stack written to stderr, log file %s: %v", path, err)
+    }
+    return types.NewError(types.ErrInternal, fmt.Sprintf("vlan-cni panicked: %v", r), details)
+}
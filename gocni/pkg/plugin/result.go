@@ -0,0 +1,116 @@
+package plugin
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+    "github.com/containernetworking/cni/pkg/version"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// setInterfaces reports the pod's interface, after the host link it hangs
+// off unless the configuration omits host entries, and points the addresses
+// at the pod's. Only the pod's has a sandbox, which is how consumers such as
+// Istio CNI tell them apart.
+func setInterfaces(result *current.Result, conf *config.NetConf, host, pod *current.Interface) {
+    result.Interfaces = nil
+    if host != nil && !conf.OmitHostInterfaces {
+        result.Interfaces = append(result.Interfaces, host)
+    }
+    result.Interfaces = append(result.Interfaces, pod)
+    for _, ipc := range result.IPs {
+        ipc.Interface = podInterface(result)
+    }
+}
+
+// podInterface is the index of the pod's interface, the last one
+func podInterface(result *current.Result) *int {
+    return current.Int(len(result.Interfaces) - 1)
+}
+
+// hostInterface is the result entry of the host link the pod's hangs off:
+// the master, or the host end of a simulated VLAN's veth pair
+func hostInterface(master, link netlink.Link, simulated bool) *current.Interface {
+    if simulated {
+        peer, err := netlink.LinkByIndex(link.Attrs().ParentIndex)
+        if err != nil {
+            return nil
+        }
+        master = peer
+    }
+    if master == nil {
+        return nil
+    }
+    return &current.Interface{Name: master.Attrs().Name, Mac: master.Attrs().HardwareAddr.String()}
+}
+
+// PrintResult writes the ADD result in the configuration's version. The
+// result holds spec fields only, as runtimes and Multus decode it into the
+// spec's types and drop anything else: the master is the host interface
+// entry, and the network and VLAN of each pod interface are in its
+// attachment record in the state store. CNI 1.1 results also carry the
+// pod interfaces' configured MTU.
+func PrintResult(result *current.Result, conf *config.NetConf) error {
+    data, err := formatResult(result, conf)
+    if err != nil {
+        return err
+    }
+    _, err = fmt.Fprintf(os.Stdout, "%s", data)
+    return err
+}
+
+func formatResult(result *current.Result, conf *config.NetConf) ([]byte, error) {
+    versioned, err := result.GetAsVersion(conf.CNIVersion)
+    if err != nil {
+        return nil, err
+    }
+    data, err := json.Marshal(versioned)
+    if err != nil {
+        return nil, err
+    }
+    
+    // mtu is new in CNI 1.1
+    if newer, err := version.GreaterThanOrEqualTo(conf.CNIVersion, "1.1.0"); err != nil || !newer || conf.Meta != nil {
+        return data, nil
+    }
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, err
+    }
+    if interfaces, ok := doc["interfaces"].([]interface{}); ok {
+        setInterfaceMTUs(interfaces, conf)
+        if data, err = json.Marshal(doc); err != nil {
+            return nil, err
+        }
+    }
+    return data, nil
+}
+
+// setInterfaceMTUs adds the configured MTU to the pod interfaces of a
+// result
+func setInterfaceMTUs(interfaces []interface{}, conf *config.NetConf) {
+    confs := map[string]*config.NetConf{}
+    for i, a := range conf.Attachments {
+        confs[a.IfName] = conf.ForAttachment(i)
+    }
+    for _, item := range interfaces {
+        iface, ok := item.(map[string]interface{})
+        if !ok || iface["sandbox"] == nil {
+            continue
+        }
+        c := conf
+        if len(conf.Attachments) > 0 {
+            name, _ := iface["name"].(string)
+            if c = confs[name]; c == nil {
+                continue
+            }
+        }
+        if c.MTU != 0 {
+            iface["mtu"] = c.MTU
+        }
+    }
+}
@@ -0,0 +1,49 @@
+package plugin
+
+import (
+    "encoding/json"
+    "net"
+    "testing"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+func TestFormatResultSpecFieldsOnly(t *testing.T) {
+    _, addr, _ := net.ParseCIDR("10.100.0.2/24")
+    result := &current.Result{
+        CNIVersion: "1.0.0",
+        Interfaces: []*current.Interface{
+            {Name: "eth0", Mac: "02:00:00:00:00:01"},
+            {Name: "net1", Mac: "02:00:00:00:00:02", Sandbox: "/var/run/netns/pod"},
+        },
+        IPs: []*current.IPConfig{{Address: *addr, Interface: current.Int(1)}},
+    }
+    spec := map[string]bool{"name": true, "mac": true, "sandbox": true}
+    
+    for _, v := range []string{"0.3.1", "0.4.0", "1.0.0"} {
+        conf := &config.NetConf{Master: "eth0", VlanID: 100, MTU: 1400}
+        conf.CNIVersion = v
+        data, err := formatResult(result, conf)
+        if err != nil {
+            t.Fatalf("%s: %v", v, err)
+        }
+        var doc struct {
+            Interfaces []map[string]interface{} `json:"interfaces"`
+        }
+        if err := json.Unmarshal(data, &doc); err != nil {
+            t.Fatalf("%s: %v", v, err)
+        }
+        if len(doc.Interfaces) != 2 {
+            t.Fatalf("%s: got %d interfaces, want 2", v, len(doc.Interfaces))
+        }
+        for _, iface := range doc.Interfaces {
+            for key := range iface {
+                if !spec[key] {
+                    t.Errorf("%s: interface %v has key %q, not in the %s spec", v, iface["name"], key, v)
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+    "context"
+    "crypto/sha1"
+    "encoding/hex"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/compat"
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/hostlink"
+)
+
+// simBridgePrefix names the bridge standing in for a simulated VLAN
+const simBridgePrefix = "vsim"
+
+// simulating reports whether the attachment uses a simulated VLAN. VLANs
+// reached over a pseudowire use its bridge too.
+func simulating(ctx context.Context, conf *config.NetConf) bool {
+    if conf.Pseudowire != nil {
+        return true
+    }
+    // The master is in the pod, not missing
+    if conf.LinkInContainer {
+        return false
+    }
+    switch conf.Simulation {
+    case config.SimulationOn:
+        return true
+    case config.SimulationAuto:
+        if _, err := lookupMaster(ctx, conf, nil); err == nil {
+            return false
+        }
+        return compat.NestedNode()
+    }
+    return false
+}
+
+// addSimulatedLink creates a veth pair for a simulated VLAN and returns the
+// end that goes into the pod, named like the VLAN link would be. The host
+// end joins the VLAN's bridge, created on first use; pods on the same VLAN
+// meet there regardless of master, and only on this node.
+func addSimulatedLink(conf *config.NetConf, name, containerID string) (netlink.Link, error) {
+    bridgeName := fmt.Sprintf("%s%d", simBridgePrefix, conf.VlanID)
+    bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: bridgeName, MTU: conf.MTU}}
+    if err := netlink.LinkAdd(bridge); err != nil && !os.IsExist(err) {
+        return nil, fmt.Errorf("failed to create simulated VLAN bridge %q: %v", bridgeName, err)
+    }
+    br, err := netlink.LinkByName(bridgeName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to lookup simulated VLAN bridge %q: %v", bridgeName, err)
+    }
+    // Marked on every use, so the janitor only ages out a bridge idle
+    // since its last ADD
+    _ = hostlink.Mark(br)
+    if err := netlink.LinkSetUp(br); err != nil {
+        return nil, fmt.Errorf("failed to set %q up: %v", bridgeName, err)
+    }
+    if conf.Pseudowire != nil {
+        if err := ensurePseudowire(conf, br); err != nil {
+            return nil, err
+        }
+    }
+    
+    sum := sha1.Sum([]byte(containerID + "/" + name))
+    peerName := "vsh" + hex.EncodeToString(sum[:])[:10]
+    veth := &netlink.Veth{
+        LinkAttrs: netlink.LinkAttrs{Name: name, MTU: conf.MTU},
+        PeerName:  peerName,
+    }
+    if err := netlink.LinkAdd(veth); err != nil {
+        return nil, fmt.Errorf("failed to create simulated VLAN link: %v", err)
+    }
+    
+    peer, err := netlink.LinkByName(peerName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to lookup %q: %v", peerName, err)
+    }
+    _ = hostlink.Mark(peer)
+    if err := netlink.LinkSetMaster(peer, br); err != nil {
+        return nil, fmt.Errorf("failed to attach %q to %q: %v", peerName, bridgeName, err)
+    }
+    // Isolated ports only forward to ports that are not, here the uplink
+    // only a pseudowire provides, so isolated pods of simulated VLANs reach
+    // nobody
+    if conf.Isolated {
+        path := filepath.Join("/sys/class/net", peerName, "brport", "isolated")
+        if err := ioutil.WriteFile(path, []byte("1"), 0644); err != nil {
+            return nil, fmt.Errorf("failed to isolate %q: %v", peerName, err)
+        }
+    }
+    if err := netlink.LinkSetUp(peer); err != nil {
+        return nil, fmt.Errorf("failed to set %q up: %v", peerName, err)
+    }
+    return netlink.LinkByName(name)
+}
@@ -0,0 +1,48 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "os"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/skel"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// warnLog receives warnings such as slow operations. The runtime keeps
+// the plugin's stderr in its log, stdout is reserved for the CNI result.
+var warnLog io.Writer = os.Stderr
+
+// timed runs fn and logs a warning in logfmt when it takes longer than the
+// configured threshold, so the slow stage of an ADD or DEL can be found.
+// Once ctx is done no further stages are started.
+func timed(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf, op string, fn func() error) error {
+    if err := ctx.Err(); err != nil {
+        return fmt.Errorf("%s not started: %v", op, err)
+    }
+    
+    start := time.Now()
+    err := fn()
+    
+    threshold := conf.SlowOpThreshold()
+    if elapsed := time.Since(start); threshold > 0 && elapsed > threshold {
+        outcome := "ok"
+        if err != nil {
+            outcome = "error"
+        }
+        fmt.Fprintf(warnLog, "level=warn msg=%q op=%s duration=%s threshold=%s container=%s ifname=%s vlan=%d outcome=%s\n",
+            "slow operation", op, elapsed, threshold, shortID(args.ContainerID), args.IfName, conf.VlanID, outcome)
+    }
+    return err
+}
+
+// shortID truncates a container ID the way runtimes print them
+func shortID(id string) string {
+    if len(id) > 12 {
+        return id[:12]
+    }
+    return id
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "time"
+
+    current "github.com/containernetworking/cni/pkg/types/100"
+
+    "example.com/vlan-cni/pkg/arp"
+    "example.com/vlan-cni/pkg/config"
+)
+
+const forwardingProbeInterval = 500 * time.Millisecond
+
+// waitForwarding holds ADD until the IPv4 gateway answers from behind the
+// switch port, meaning spanning tree lets the pod's frames through. It runs
+// in the container's namespace before addresses are applied.
+func waitForwarding(ctx context.Context, ifName string, conf *config.NetConf, result *current.Result) {
+    if conf.STP == nil || !conf.STP.WaitForwarding {
+        return
+    }
+    gw4 := gatewayFor(net.IPv4zero, result)
+    if gw4 == nil {
+        fmt.Fprintf(warnLog, "level=warn msg=%q ifname=%s\n", "stp waitForwarding needs an IPv4 gateway, not waiting", ifName)
+        return
+    }
+    
+    ctx, cancel := context.WithTimeout(ctx, conf.STP.ForwardingTimeout())
+    defer cancel()
+    
+    start := time.Now()
+    if err := arp.Probe(ctx, ifName, gw4, forwardingProbeInterval); err != nil {
+        fmt.Fprintf(warnLog, "level=warn msg=%q ifname=%s gateway=%s error=%q\n", "port did not forward in time", ifName, gw4, err)
+        return
+    }
+    if waited := time.Since(start); waited > forwardingProbeInterval {
+        fmt.Fprintf(warnLog, "level=warn msg=%q ifname=%s gateway=%s duration=%s\n", "waited for the switch port to forward", ifName, gw4, waited)
+    }
+}
+
+// keepaliveUntil is when the daemon stops sending keepalives for a new
+// attachment, nil when it sends none
+func keepaliveUntil(conf *config.NetConf) *time.Time {
+    if conf.STP == nil || conf.STP.KeepaliveSeconds == 0 {
+        return nil
+    }
+    until := time.Now().UTC().Add(time.Duration(conf.STP.KeepaliveSeconds) * time.Second)
+    return &until
+}
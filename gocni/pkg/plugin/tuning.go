@@ -0,0 +1,182 @@
+package plugin
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+    "syscall"
+
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/containernetworking/plugins/pkg/utils/sysctl"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/ethtool"
+)
+
+// tuneHostLink sets the MAC address and MTU of the tuning block while the
+// link is still on the host and down, before IPAM sees the MAC
+func tuneHostLink(link netlink.Link, conf *config.NetConf) error {
+    mac := conf.RuntimeConfig.Mac
+    if mac == "" && conf.Tuning != nil {
+        mac = conf.Tuning.Mac
+    }
+    if mac != "" {
+        hw, err := net.ParseMAC(mac)
+        if err != nil {
+            return err
+        }
+        if err := netlink.LinkSetHardwareAddr(link, hw); err != nil {
+            return fmt.Errorf("failed to set MAC address %s on %q: %v", mac, link.Attrs().Name, err)
+        }
+    }
+    if conf.Tuning != nil && conf.Tuning.MTU != 0 {
+        if err := netlink.LinkSetMTU(link, conf.Tuning.MTU); err != nil {
+            return fmt.Errorf("failed to set MTU %d on %q: %v", conf.Tuning.MTU, link.Attrs().Name, err)
+        }
+    }
+    return nil
+}
+
+// tuneContainerLink applies the rest of the tuning block once the link is
+// named inside the container
+func tuneContainerLink(link netlink.Link, conf *config.NetConf) error {
+    t := conf.Tuning
+    if t == nil {
+        return nil
+    }
+    ifName := link.Attrs().Name
+    if t.Promisc {
+        if err := netlink.SetPromiscOn(link); err != nil {
+            return fmt.Errorf("failed to set %q promiscuous: %v", ifName, err)
+        }
+    }
+    if t.AllMulticast {
+        if err := netlink.LinkSetAllmulticastOn(link); err != nil {
+            return fmt.Errorf("failed to set allmulti on %q: %v", ifName, err)
+        }
+    }
+    for key, value := range t.Sysctl {
+        key = strings.ReplaceAll(key, "IFNAME", ifName)
+        if err := config.CheckSysctl(key); err != nil {
+            return err
+        }
+        if _, err := sysctl.Sysctl(key, value); err != nil {
+            return fmt.Errorf("failed to set sysctl %s: %v", key, err)
+        }
+    }
+    return nil
+}
+
+// tuneMaster applies the ethtool settings to the master, which ParseConfig
+// made sure is in the pod
+func tuneMaster(conf *config.NetConf, netns ns.NetNS) error {
+    return inLinkNS(conf, netns, func() error {
+        return applyEthtool(conf.Master, conf.Tuning.Ethtool)
+    })
+}
+
+// checkMaster fails CHECK when the master lost an ethtool setting
+func checkMaster(conf *config.NetConf, netns ns.NetNS) error {
+    return inLinkNS(conf, netns, func() error {
+        return checkEthtool(conf.Master, conf.Tuning.Ethtool)
+    })
+}
+
+// applyEthtool sets the rings and queues the configuration sizes, within
+// the maximums the driver reports
+func applyEthtool(ifName string, e *config.EthtoolConfig) error {
+    if e.RxRing != 0 || e.TxRing != 0 {
+        r, err := ethtool.GetRings(ifName)
+        if err != nil {
+            return ethtoolError(ifName, "get rings", err)
+        }
+        if err := ethtoolSetting(ifName, "rxRing", &r.Rx, e.RxRing, r.RxMax); err != nil {
+            return err
+        }
+        if err := ethtoolSetting(ifName, "txRing", &r.Tx, e.TxRing, r.TxMax); err != nil {
+            return err
+        }
+        if err := ethtool.SetRings(ifName, r); err != nil {
+            return ethtoolError(ifName, "set rings", err)
+        }
+    }
+    if e.RxQueues != 0 || e.TxQueues != 0 || e.CombinedQueues != 0 {
+        c, err := ethtool.GetChannels(ifName)
+        if err != nil {
+            return ethtoolError(ifName, "get queues", err)
+        }
+        if err := ethtoolSetting(ifName, "rxQueues", &c.Rx, e.RxQueues, c.RxMax); err != nil {
+            return err
+        }
+        if err := ethtoolSetting(ifName, "txQueues", &c.Tx, e.TxQueues, c.TxMax); err != nil {
+            return err
+        }
+        if err := ethtoolSetting(ifName, "combinedQueues", &c.Combined, e.CombinedQueues, c.CombinedMax); err != nil {
+            return err
+        }
+        if err := ethtool.SetChannels(ifName, c); err != nil {
+            return ethtoolError(ifName, "set queues", err)
+        }
+    }
+    return nil
+}
+
+// checkEthtool fails CHECK when the interface lost a configured setting
+func checkEthtool(ifName string, e *config.EthtoolConfig) error {
+    var got []string
+    if e.RxRing != 0 || e.TxRing != 0 {
+        r, err := ethtool.GetRings(ifName)
+        if err != nil {
+            return ethtoolError(ifName, "get rings", err)
+        }
+        got = append(got, ethtoolMismatch("rxRing", e.RxRing, r.Rx), ethtoolMismatch("txRing", e.TxRing, r.Tx))
+    }
+    if e.RxQueues != 0 || e.TxQueues != 0 || e.CombinedQueues != 0 {
+        c, err := ethtool.GetChannels(ifName)
+        if err != nil {
+            return ethtoolError(ifName, "get queues", err)
+        }
+        got = append(got, ethtoolMismatch("rxQueues", e.RxQueues, c.Rx), ethtoolMismatch("txQueues", e.TxQueues, c.Tx),
+            ethtoolMismatch("combinedQueues", e.CombinedQueues, c.Combined))
+    }
+    var mismatches []string
+    for _, m := range got {
+        if m != "" {
+            mismatches = append(mismatches, m)
+        }
+    }
+    if len(mismatches) > 0 {
+        return fmt.Errorf("interface %q has %s", ifName, strings.Join(mismatches, ", "))
+    }
+    return nil
+}
+
+// ethtoolSetting sets *field to want, unless want is 0
+func ethtoolSetting(ifName, name string, field *uint32, want int, max uint32) error {
+    if want == 0 {
+        return nil
+    }
+    if uint32(want) > max {
+        return fmt.Errorf("ethtool %s %d exceeds the maximum %d of %q", name, want, max, ifName)
+    }
+    *field = uint32(want)
+    return nil
+}
+
+func ethtoolMismatch(name string, want int, got uint32) string {
+    if want == 0 || uint32(want) == got {
+        return ""
+    }
+    return fmt.Sprintf("%s %d instead of %d", name, got, want)
+}
+
+// ethtoolError explains that the master has no rings to size, as when it
+// is a VLAN or bridge itself
+func ethtoolError(ifName, op string, err error) error {
+    if errors.Is(err, syscall.EOPNOTSUPP) {
+        return fmt.Errorf("failed to %s of %q: %v (the master has no rings of its own)", op, ifName, err)
+    }
+    return fmt.Errorf("failed to %s of %q: %v", op, ifName, err)
+}
@@ -1,100 +1,465 @@
 package plugin
 
 import (
+    "context"
+    "errors"
     "fmt"
     "net"
+    "syscall"
+    "time"
     
     "github.com/containernetworking/cni/pkg/skel"
-    "github.com/containernetworking/cni/pkg/types"
     current "github.com/containernetworking/cni/pkg/types/100"
-    "github.com/containernetworking/plugins/pkg/ip"
     "github.com/containernetworking/plugins/pkg/ns"
     "github.com/vishvananda/netlink"
     
+    "example.com/vlan-cni/pkg/chain"
+    "example.com/vlan-cni/pkg/compat"
     "example.com/vlan-cni/pkg/config"
+    "example.com/vlan-cni/pkg/cri"
+    "example.com/vlan-cni/pkg/dhcp6"
+    "example.com/vlan-cni/pkg/hooks"
+    "example.com/vlan-cni/pkg/hostlink"
+    "example.com/vlan-cni/pkg/state"
 )
 
 // AddVlanNetwork creates a VLAN interface and moves it to the container's network namespace
-func AddVlanNetwork(args *skel.CmdArgs, conf *config.NetConf) (*current.Result, error) {
-    // Get master interface
-    master, err := netlink.LinkByName(conf.Master)
+func AddVlanNetwork(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) (_ *current.Result, retErr error) {
+    if err := checkNamespace(args, conf); err != nil {
+        return nil, err
+    }
+    if err := checkMaintenance(conf); err != nil {
+        return nil, err
+    }
+    if err := checkUpstreamKeys(ctx, conf); err != nil {
+        return nil, err
+    }
+    
+    if conf.Meta != nil {
+        return addDelegated(ctx, args, conf)
+    }
+    if len(conf.Attachments) > 0 {
+        return addAttachments(ctx, args, conf)
+    }
+    if conf.PlanFile != "" {
+        return planAdd(ctx, args, conf)
+    }
+    
+    // Chained after a plugin such as sriov, the VLAN goes on its interface
+    var prev *current.Result
+    if conf.MasterFromPrevResult {
+        var err error
+        if prev, err = chainedMaster(args, conf); err != nil {
+            return nil, err
+        }
+    }
+    
+    netns, err := ns.GetNS(args.Netns)
     if err != nil {
-        return nil, fmt.Errorf("failed to lookup master interface %q: %v", conf.Master, err)
+        return nil, fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
     }
+    defer netns.Close()
     
-    // Create VLAN interface
-    vlanName := fmt.Sprintf("%s.%d", master.Attrs().Name, conf.VlanID)
-    vlan := &netlink.Vlan{
-        LinkAttrs: netlink.LinkAttrs{
-            Name:        vlanName,
-            ParentIndex: master.Attrs().Index,
-            MTU:         conf.MTU,
-        },
-        VlanId: conf.VlanID,
+    // Get master interface, which simulated VLANs do without
+    var master netlink.Link
+    simulated := simulating(ctx, conf)
+    if !simulated {
+        err := timed(ctx, args, conf, "netlink.LinkByName", func() (err error) {
+            master, err = lookupMaster(ctx, conf, netns)
+            return err
+        })
+        if err != nil {
+            return nil, fmt.Errorf("failed to lookup master interface %q: %v", conf.Master, err)
+        }
+        
+        if err := checkCompat(ctx, args, conf); err != nil {
+            return nil, err
+        }
+    }
+    
+    // Resolve host and container interface names
+    nameData, err := newIfNameData(args, conf)
+    if err != nil {
+        return nil, err
+    }
+    
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return nil, err
+    }
+    if err := cri.StartAdd(store, args.ContainerID, args.IfName); err != nil {
+        return nil, err
     }
     
-    // Create the VLAN interface on the host
-    if err := netlink.LinkAdd(vlan); err != nil {
-        if err.Error() != "file exists" {
-            return nil, fmt.Errorf("failed to create VLAN interface: %v", err)
+    if err := checkVlanAllowed(store, conf); err != nil {
+        return nil, err
+    }
+    
+    // Spread pod churn out before it reaches the switch and DHCP servers
+    if err := admitAdd(ctx, store, conf); err != nil {
+        return nil, err
+    }
+    
+    vlanName, err := hostIfName(conf, nameData, store, args.ContainerID)
+    if err != nil {
+        return nil, err
+    }
+    
+    contIfName, err := containerIfName(args, conf, nameData)
+    if err != nil {
+        return nil, err
+    }
+    
+    secondaryAddrs, err := conf.SecondaryAddrs()
+    if err != nil {
+        return nil, err
+    }
+    
+    // Take a link pre-created by the node daemon when one is ready
+    var vlan netlink.Link
+    if !simulated && !conf.LinkInContainer {
+        vlan, err = takePooledLink(store, conf)
+        if err != nil {
+            return nil, err
+        }
+    }
+    switch {
+    case vlan != nil:
+        vlanName = vlan.Attrs().Name
+    case simulated:
+        vlan, err = addSimulatedLink(conf, vlanName, args.ContainerID)
+        if err != nil {
+            return nil, err
+        }
+    default:
+        // Create VLAN interface, or a macvlan/ipvlan for untagged attachments.
+        // When the daemon shares the VLAN's device, the pod gets a macvlan
+        // off it instead, the VLAN ID being taken.
+        parent, vlanID, untaggedMode := master, conf.VlanID, conf.UntaggedMode
+        if conf.VlanID != 0 && !conf.LinkInContainer {
+            shared, err := hostlink.SharedParent(master, conf.VlanID)
+            if err != nil {
+                return nil, err
+            }
+            if shared != nil {
+                parent, vlanID, untaggedMode = shared, 0, config.UntaggedModeMacvlan
+            }
         }
-        // If it already exists, retrieve it
-        vlan, err = netlink.LinkByName(vlanName)
+        vlan = hostlink.New(parent, vlanID, untaggedMode, conf.MTU, vlanName, conf.Isolated)
+        
+        // Create the VLAN interface on the host
+        err := timed(ctx, args, conf, "netlink.LinkAdd", func() error {
+            return inLinkNS(conf, netns, func() error {
+                return netlink.LinkAdd(vlan)
+            })
+        })
         if err != nil {
-            return nil, fmt.Errorf("failed to lookup existing VLAN interface: %v", err)
+            if errors.Is(err, syscall.EOPNOTSUPP) {
+                return nil, fmt.Errorf("failed to create VLAN interface: %v (is the %s kernel module available?)",
+                    err, compat.Module(compat.LinkKind(conf.VlanID, conf.UntaggedMode)))
+            }
+            if err.Error() != "file exists" {
+                return nil, fmt.Errorf("failed to create VLAN interface: %v", err)
+            }
+            // If it already exists, retrieve it
+            err = inLinkNS(conf, netns, func() (err error) {
+                vlan, err = netlink.LinkByName(vlanName)
+                return err
+            })
+            if err != nil {
+                return nil, fmt.Errorf("failed to lookup existing VLAN interface: %v", err)
+            }
+            if hostlink.IsShared(vlan) {
+                return nil, fmt.Errorf("%q is the VLAN device the daemon shares, not a pod's", vlanName)
+            }
         }
     }
     
-    // Set link up
-    if err := netlink.LinkSetUp(vlan); err != nil {
-        return nil, fmt.Errorf("failed to set VLAN interface %q up: %v", vlanName, err)
+    // Stamp the link so the daemon's janitor can age it out should ADD
+    // leave it behind in the host namespace
+    if !conf.LinkInContainer {
+        _ = hostlink.Mark(vlan)
     }
     
-    // Move interface to container namespace
-    netns, err := ns.GetNS(args.Netns)
+    err = inLinkNS(conf, netns, func() error {
+        // The MAC address has to be settled before IPAM sees it
+        if err := tuneHostLink(vlan, conf); err != nil {
+            return err
+        }
+        
+        // Set link up
+        err := timed(ctx, args, conf, "netlink.LinkSetUp", func() error {
+            return netlink.LinkSetUp(vlan)
+        })
+        if err != nil {
+            return fmt.Errorf("failed to set VLAN interface %q up: %v", vlanName, err)
+        }
+        
+        // Refresh attributes the kernel filled in, such as the MAC address
+        if link, err := netlink.LinkByName(vlanName); err == nil {
+            vlan = link
+        }
+        return nil
+    })
     if err != nil {
-        return nil, fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+        return nil, err
     }
-    defer netns.Close()
     
-    if err := netlink.LinkSetNsFd(vlan, int(netns.Fd())); err != nil {
-        return nil, fmt.Errorf("failed to move VLAN interface to container namespace: %v", err)
+    if t := conf.Tuning; t != nil && t.Ethtool != nil && !simulated {
+        if err := tuneMaster(conf, netns); err != nil {
+            return nil, err
+        }
+    }
+    
+    host := hostInterface(master, vlan, simulated)
+    switch {
+    case prev != nil:
+        // The previous result lists the master already
+        host = nil
+    case conf.LinkInContainer && host != nil:
+        host.Sandbox = args.Netns
+    }
+    
+    // Move interface to container namespace, unless it was created there
+    if !conf.LinkInContainer {
+        err = timed(ctx, args, conf, "netlink.LinkSetNsFd", func() error {
+            return netlink.LinkSetNsFd(vlan, int(netns.Fd()))
+        })
+        if err != nil {
+            return nil, fmt.Errorf("failed to move VLAN interface to container namespace: %v", err)
+        }
+    }
+    
+    // The bond's other masters get a child of their own
+    var children []string
+    if conf.Bond != nil {
+        if simulated {
+            return nil, fmt.Errorf("bond cannot be simulated")
+        }
+        if children, err = addBondChildren(conf, nameData, store, args.ContainerID, vlanName, netns); err != nil {
+            return nil, err
+        }
     }
     
-    // Configure IP addressing inside the container
     result := &current.Result{
         CNIVersion: conf.CNIVersion,
     }
     
+    flannel, err := besideFlannel(args, conf)
+    if err != nil {
+        return nil, err
+    }
+    
+    // Kubelet probes come from the node's networks
+    var node []*net.IPNet
+    if conf.DefaultRoute && !conf.ProbeRouting.Disabled {
+        if node, err = nodeNetworks(conf); err != nil {
+            return nil, err
+        }
+    }
+    
+    // Name the pod interface and bring it up before IPAM runs, as IPAM
+    // plugins such as dhcp work on it
+    var contMAC string
+    err = netns.Do(func(hostNS ns.NetNS) (err error) {
+        // ns.Do runs this on its own goroutine
+        defer recoverInto(conf, &err)
+        
+        // Rename interface to a standard name inside container, or bond
+        // the children under it
+        if conf.Bond != nil {
+            err = timed(ctx, args, conf, "netlink.bond", func() error {
+                return bondChildren(conf, contIfName, children)
+            })
+            if err != nil {
+                return err
+            }
+        } else {
+            contVlan, err := netlink.LinkByName(vlanName)
+            if err != nil {
+                return fmt.Errorf("failed to find VLAN interface in container: %v", err)
+            }
+            
+            err = timed(ctx, args, conf, "netlink.LinkSetName", func() error {
+                return netlink.LinkSetName(contVlan, contIfName)
+            })
+            if err != nil {
+                return fmt.Errorf("failed to rename VLAN interface: %v", err)
+            }
+        }
+        
+        // Set interface up inside container
+        contIface, err := netlink.LinkByName(contIfName)
+        if err != nil {
+            return fmt.Errorf("failed to lookup container interface %q: %v", contIfName, err)
+        }
+        
+        // IPv6 address generation has to be settled before the link comes up
+        if err := configureIPv6AddrGen(contIface, conf, nameData); err != nil {
+            return err
+        }
+        
+        if err := disableFamilies(contIfName, conf); err != nil {
+            return err
+        }
+        
+        if err := configureARP(contIfName, conf); err != nil {
+            return err
+        }
+        
+        if err := tuneContainerLink(contIface, conf); err != nil {
+            return err
+        }
+        
+        if err := netlink.LinkSetUp(contIface); err != nil {
+            return fmt.Errorf("failed to set %q up: %v", contIfName, err)
+        }
+        contMAC = contIface.Attrs().HardwareAddr.String()
+        
+        return timed(ctx, args, conf, "carrier", func() error {
+            return waitCarrier(ctx, contIfName, conf)
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    // Allocate addresses from the host namespace
+    if conf.IPAMConfig != nil && conf.PodRoutes != nil {
+        if err := addPodRoutes(ctx, conf, nameData); err != nil {
+            return nil, err
+        }
+    }
+    if conf.IPAMConfig != nil {
+        r, err := ConfigureIPAM(ctx, args, conf, nameData, contMAC)
+        if err != nil {
+            return nil, err
+        }
+        result = r
+        
+        // Give the addresses back if the rest of ADD fails, with time of
+        // its own as ADD may have failed by running out of it
+        defer func() {
+            if retErr != nil {
+                releaseCtx, cancel := context.WithTimeout(context.Background(), conf.IPAMConfig.Timeout())
+                defer cancel()
+                _ = ReleaseIPAllocation(releaseCtx, args, conf)
+            }
+        }()
+    }
+    if flannel != nil && conf.IPAMConfig != nil {
+        if err := flannel.checkOverlap(result); err != nil {
+            return nil, err
+        }
+        flannel.dropDefaultRoutes(conf, result)
+    }
+    
     // Execute inside container network namespace
-    err = netns.Do(func(hostNS ns.NetNS) error {
-        // Rename interface to a standard name inside container
-        contVlan, err := netlink.LinkByName(vlanName)
+    var delegated []string
+    err = netns.Do(func(hostNS ns.NetNS) (err error) {
+        // ns.Do runs this on its own goroutine
+        defer recoverInto(conf, &err)
+        
+        contIface, err := netlink.LinkByName(contIfName)
         if err != nil {
-            return fmt.Errorf("failed to find VLAN interface in container: %v", err)
+            return fmt.Errorf("failed to lookup container interface %q: %v", contIfName, err)
+        }
+        
+        // Report the container interface and tie the addresses to it
+        setInterfaces(result, conf, host, &current.Interface{
+            Name:    contIfName,
+            Mac:     contIface.Attrs().HardwareAddr.String(),
+            Sandbox: args.Netns,
+        })
+        
+        // Addresses applied while the port is blocked would be announced
+        // into the void
+        waitForwarding(ctx, contIfName, conf, result)
+        
+        if len(conf.Gateways) > 0 && conf.IPAMConfig != nil {
+            selectGateways(ctx, contIfName, conf, result)
         }
         
-        if err := netlink.LinkSetName(contVlan, args.IfName); err != nil {
-            return fmt.Errorf("failed to rename VLAN interface: %v", err)
+        // Put the pod's default routes back if taking them over fails
+        var saved []netlink.Route
+        if conf.DefaultRoute {
+            if saved, err = defaultRoutesElsewhere(contIface); err != nil {
+                return err
+            }
+            defer func() {
+                if err != nil {
+                    restoreDefaultRoutes(contIface, saved)
+                }
+            }()
+        }
+        if conf.DefaultRoute && !conf.ProbeRouting.Disabled {
+            if err := keepProbesOnPrimary(conf, saved, node); err != nil {
+                return err
+            }
+        }
+        if conf.DefaultRoute {
+            if err := routeClusterNetworks(saved, clusterNetworks(conf, flannel)); err != nil {
+                return err
+            }
         }
         
-        // Configure IPAM - allocate IP, set up routes
+        // Routes IPAM gives via the pod itself delegate prefixes to it
+        var ipamPrefixes []*net.IPNet
+        if conf.DelegatedPrefixes != nil && conf.DelegatedPrefixes.FromIPAM {
+            ipamPrefixes = ipamDelegatedPrefixes(result)
+        }
+        
+        // Configure IPAM - apply addresses, set up routes
         if conf.IPAMConfig != nil {
-            r, err := ConfigureIPAM(args.IfName, conf.IPAMConfig, args.ContainerID)
+            err := timed(ctx, args, conf, "netlink.applyIPAM", func() error {
+                return applyIPAM(contIface, conf, result)
+            })
             if err != nil {
                 return err
             }
-            result = r
+        }
+        if conf.DefaultRoute {
+            if err := takeDefaultRoutes(contIface, saved, result); err != nil {
+                return err
+            }
+        }
+        if flannel != nil {
+            warnDefaultRoutes(contIface)
         }
         
-        // Set interface up inside container
-        contIface, err := netlink.LinkByName(args.IfName)
-        if err != nil {
-            return fmt.Errorf("failed to lookup container interface %q: %v", args.IfName, err)
+        var lease *dhcp6.Lease
+        if conf.DHCPv6 != nil {
+            err := timed(ctx, args, conf, "dhcpv6.Acquire", func() (err error) {
+                lease, err = acquireDHCPv6(ctx, args, conf, store, contIface, result)
+                return err
+            })
+            if err != nil {
+                return err
+            }
         }
         
-        if err := netlink.LinkSetUp(contIface); err != nil {
-            return fmt.Errorf("failed to set %q up: %v", args.IfName, err)
+        if conf.DelegatedPrefixes != nil {
+            if delegated, err = routeDelegatedPrefixes(contIface, conf, lease, ipamPrefixes); err != nil {
+                return err
+            }
+        }
+        
+        // Apply secondary addresses such as service VIPs
+        if err := addSecondaryAddrs(contIface, secondaryAddrs, result); err != nil {
+            return err
+        }
+        
+        // Pin the pod to the addresses it was just given
+        if conf.AntiSpoof {
+            if err := pinSource(contIface, result); err != nil {
+                return err
+            }
+        }
+        
+        // Announce the new addresses, failures here are not fatal. Cloud
+        // fabrics learn addresses through their own control plane.
+        if conf.ArpMode != config.ArpModeCloud {
+            _ = announceAddrs(contIfName, result)
         }
         
         return nil
@@ -104,25 +469,205 @@ func AddVlanNetwork(args *skel.CmdArgs, conf *config.NetConf) (*current.Result,
         return nil, err
     }
     
+    attachment, err := recordAttachment(ctx, store, args, conf, nameData, vlanName, contIfName, result, delegated)
+    if err != nil {
+        return nil, err
+    }
+    
+    if !simulated && !conf.LinkInContainer {
+        if err := steerVlan(ctx, conf); err != nil {
+            return nil, err
+        }
+    }
+    
+    if err := registerDNS(ctx, args, conf, nameData, result); err != nil {
+        return nil, err
+    }
+    
+    if err := hooks.Run(ctx, conf.Hooks, store, config.HookEventPostAdd, attachment, result); err != nil {
+        return nil, err
+    }
+    
+    if prev != nil {
+        mergeResult(prev, result)
+        result = prev
+    }
     return result, nil
 }
 
 // DelVlanNetwork removes VLAN interfaces and performs cleanup
-func DelVlanNetwork(args *skel.CmdArgs, conf *config.NetConf) error {
-    // Clean up IPAM allocations
-    if conf.IPAMConfig != nil {
-        err := ReleaseIPAllocation(args.IfName, conf.IPAMConfig, args.ContainerID)
+func DelVlanNetwork(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) error {
+    if conf.Meta != nil {
+        return delDelegated(ctx, args, conf)
+    }
+    if len(conf.Attachments) > 0 {
+        return delAttachments(ctx, args, conf)
+    }
+    if conf.PlanFile != "" {
+        return planDel(ctx, args, conf)
+    }
+    
+    store, err := state.NewStore(conf.StateDir)
+    if err != nil {
+        return err
+    }
+    
+    // Runtimes repeat DEL, and the repeat finds nothing left to release
+    repeated, err := cri.RepeatedDel(store, args.ContainerID, args.IfName, time.Now())
+    if err != nil {
+        return err
+    }
+    if repeated {
+        return nil
+    }
+    
+    // CRI-O leaves CNI_NETNS out once the sandbox is gone, or passes the
+    // path of a namespace already removed, so enter the one recorded at ADD
+    // while it exists, or none
+    run := &delRun{args: args, conf: conf}
+    recorded, err := store.GetAttachment(args.ContainerID, args.IfName)
+    if err := run.step("state.GetAttachment", err); err != nil {
+        return err
+    }
+    var recordedNetns string
+    if recorded != nil {
+        recordedNetns = recorded.Netns
+    }
+    delArgs := *args
+    delArgs.Netns = cri.DelNetns(args.Netns, recordedNetns)
+    args = &delArgs
+    run.args = args
+    
+    // Let pre-DEL hooks see the attachment before anything is torn down
+    if len(conf.Hooks) > 0 {
+        existing, err := store.GetAttachment(args.ContainerID, args.IfName)
+        if err := run.step("state.GetAttachment", err); err != nil {
+            return err
+        }
+        if existing != nil {
+            if err := hooks.Run(ctx, conf.Hooks, store, config.HookEventPreDel, existing, nil); err != nil {
+                return err
+            }
+        }
+    }
+    
+    // A drain's DELs release addresses ahead of tearing down host state
+    if conf.DHCPv6 != nil || conf.IPAMConfig != nil {
+        done, err := delSlot(ctx, store, delPhaseIPAM)
+        if err != nil {
+            return err
+        }
+        err = releaseAddresses(ctx, run, store)
+        done()
         if err != nil {
             return err
         }
     }
     
+    // Taken before anything is forgotten, so a DEL told to try again
+    // later still finds the attachment
+    done, err := delSlot(ctx, store, delPhaseTeardown)
+    if err != nil {
+        return err
+    }
+    defer done()
+    
+    // Forget any hashed host interface names held by this container
+    err = store.ReleaseNames(args.ContainerID)
+    if err := run.step("state.ReleaseNames", err); err != nil {
+        return err
+    }
+    
+    attachment, err := store.DeleteAttachment(args.ContainerID, args.IfName)
+    if err := run.step("state.DeleteAttachment", err); err != nil {
+        return err
+    }
+    
+    // Drop the hardware steering filter with the VLAN's last attachment
+    if !simulating(ctx, conf) && !conf.LinkInContainer {
+        err = unsteerVlan(ctx, store, conf)
+        if err := run.step("offload.unsteer", err); err != nil {
+            return err
+        }
+    }
+    
+    // Remove the pod's DNS records
+    deregisterDNS(ctx, conf, attachment)
+    
+    // Drop stale conntrack entries for the released addresses
+    if attachment != nil && !conf.DisableConntrackFlush {
+        flushConntrack(attachment.IPs)
+    }
+    
+    if conf.LinkInContainer {
+        err = delContainerLink(ctx, args, conf, attachment)
+        if err := run.step("netlink.LinkDel", err); err != nil {
+            return err
+        }
+    }
+    
     // The VLAN link should already be removed when the container's netns is deleted
+    return cri.FinishDel(store, args.ContainerID, args.IfName, time.Now())
+}
+
+// releaseAddresses returns the attachment's DHCPv6 lease and IPAM
+// allocation
+func releaseAddresses(ctx context.Context, run *delRun, store *state.Store) error {
+    args, conf := run.args, run.conf
+    if conf.DHCPv6 != nil {
+        err := releaseDHCPv6(ctx, args, store, podIfName(args, conf))
+        if err := run.step("dhcpv6.Release", err); err != nil {
+            return err
+        }
+    }
+    
+    // Clean up IPAM allocations
+    if conf.IPAMConfig != nil {
+        err := ReleaseIPAllocation(ctx, args, conf)
+        if err := run.step("ipam.Release", err); err != nil {
+            return err
+        }
+    }
     return nil
 }
 
 // CheckVlanNetwork verifies the VLAN network is correctly configured
-func CheckVlanNetwork(args *skel.CmdArgs, conf *config.NetConf) error {
+func CheckVlanNetwork(ctx context.Context, args *skel.CmdArgs, conf *config.NetConf) (retErr error) {
+    if conf.Meta != nil {
+        return checkDelegated(ctx, args, conf)
+    }
+    if len(conf.Attachments) > 0 {
+        return checkAttachments(ctx, args, conf)
+    }
+    if conf.PlanFile != "" {
+        return planCheck(ctx, args, conf)
+    }
+    
+    defer func() {
+        recordCheck(args, conf, retErr)
+    }()
+    
+    if conf.MasterFromPrevResult {
+        if _, err := chainedMaster(args, conf); err != nil {
+            return err
+        }
+    }
+    
+    // In a chain, CHECK gets the chain's final result, of which only the
+    // pod interface and what hangs off it are this plugin's to verify
+    var final *current.Result
+    if conf.RawPrevResult != nil {
+        var err error
+        if final, err = prevResult(conf); err != nil {
+            return err
+        }
+    }
+    
+    flannel, err := besideFlannel(args, conf)
+    if err != nil {
+        return err
+    }
+    
     netns, err := ns.GetNS(args.Netns)
     if err != nil {
         return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
@@ -130,10 +675,38 @@ func CheckVlanNetwork(args *skel.CmdArgs, conf *config.NetConf) error {
     defer netns.Close()
     
     // Check interface exists and has correct VLAN configuration
-    err = netns.Do(func(hostNS ns.NetNS) error {
-        link, err := netlink.LinkByName(args.IfName)
+    ifName := podIfName(args, conf)
+    err = netns.Do(func(hostNS ns.NetNS) (err error) {
+        defer recoverInto(conf, &err)
+        
+        link, err := podLink(ctx, conf, netns, ifName)
         if err != nil {
-            return fmt.Errorf("failed to find interface %q: %v", args.IfName, err)
+            return fmt.Errorf("failed to find interface %q: %v", ifName, err)
+        }
+        if flannel != nil {
+            warnDefaultRoutes(link)
+        }
+        if conf.Bond != nil {
+            if err := checkBond(link); err != nil {
+                return err
+            }
+        }
+        if conf.LinkInContainer {
+            if err := checkContainerParent(ctx, conf, netns, link); err != nil {
+                return err
+            }
+        }
+        
+        // L2-only pods address themselves, so the link only has to be up
+        if conf.L2Only && link.Attrs().Flags&net.FlagUp == 0 {
+            return fmt.Errorf("interface %q is down", ifName)
+        }
+        
+        // Results older than 0.3.0 name no interfaces to tell ours by
+        if final != nil {
+            if own := chain.Owned(final, link.Attrs().Name); len(own.Interfaces) > 0 {
+                return own.Verify()
+            }
         }
         
         // Check IP configuration if IPAM was specified
@@ -143,12 +716,33 @@ func CheckVlanNetwork(args *skel.CmdArgs, conf *config.NetConf) error {
             if err != nil {
                 return fmt.Errorf("failed to list interface addresses: %v", err)
             }
-            
-            // Additional IP verification logic would go here
+            if len(addrs) == 0 {
+                return fmt.Errorf("interface %q has no addresses", ifName)
+            }
         }
         
         return nil
     })
+    if err != nil {
+        return err
+    }
+    if err := checkTeam(ctx, conf); err != nil {
+        return err
+    }
+    if t := conf.Tuning; t != nil && t.Ethtool != nil && !simulating(ctx, conf) {
+        if err := checkMaster(conf, netns); err != nil {
+            return err
+        }
+    }
+    if conf.Gateway != nil {
+        if err := checkGateway(ctx, args, conf); err != nil {
+            return err
+        }
+    }
     
-    return err
+    // Confirm the IPAM backend still holds the allocation
+    if conf.IPAMConfig != nil {
+        return CheckIPAllocation(ctx, args, conf)
+    }
+    return nil
 }
\ No newline at end of file
@@ -0,0 +1,87 @@
+package plugin
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "testing"
+
+    "github.com/containernetworking/cni/pkg/skel"
+    "github.com/containernetworking/plugins/pkg/ns"
+    "github.com/containernetworking/plugins/pkg/testutils"
+    "github.com/vishvananda/netlink"
+
+    "example.com/vlan-cni/pkg/config"
+)
+
+// BenchmarkAddDel measures the netlink path of ADD and DEL against a dummy
+// master in a throwaway host namespace. It needs root.
+func BenchmarkAddDel(b *testing.B) {
+    if os.Geteuid() != 0 {
+        b.Skip("needs root to create network namespaces")
+    }
+    
+    hostNS, err := testutils.NewNS()
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer testutils.UnmountNS(hostNS)
+    
+    podNS, err := testutils.NewNS()
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer testutils.UnmountNS(podNS)
+    
+    conf := &config.NetConf{
+        Master:       "bench0",
+        VlanID:       100,
+        UntaggedMode: config.UntaggedModeMacvlan,
+        Offload:      config.OffloadOff,
+        StateDir:     b.TempDir(),
+    }
+    conf.Name = "bench"
+    conf.CNIVersion = "1.0.0"
+    
+    var noDummy error
+    err = hostNS.Do(func(ns.NetNS) error {
+        if err := netlink.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: conf.Master}}); err != nil {
+            noDummy = err
+            return nil
+        }
+        
+        b.ResetTimer()
+        for i := 0; i < b.N; i++ {
+            args := &skel.CmdArgs{
+                ContainerID: fmt.Sprintf("bench-%d", i),
+                Netns:       podNS.Path(),
+                IfName:      "net1",
+                StdinData:   []byte(`{}`),
+            }
+            if _, err := AddVlanNetwork(context.Background(), args, conf); err != nil {
+                return err
+            }
+            if err := DelVlanNetwork(context.Background(), args, conf); err != nil {
+                return err
+            }
+            
+            // The pod netns outlives the cycle, remove the link by hand
+            if err := podNS.Do(func(ns.NetNS) error {
+                link, err := netlink.LinkByName(args.IfName)
+                if err != nil {
+                    return err
+                }
+                return netlink.LinkDel(link)
+            }); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if noDummy != nil {
+        b.Skipf("cannot create dummy master: %v", noDummy)
+    }
+    if err != nil {
+        b.Fatal(err)
+    }
+}
@@ -0,0 +1,277 @@
+// Package privsep runs the parts of the plugin that handle untrusted input
+// in a worker process without capabilities. The runtime invokes the plugin
+// as root with every capability, and pkg/caps keeps the few the kernel
+// calls need; config parsing, sops decryption, templates, IPAM backends and
+// API server responses need none of them, so they run in the worker and
+// reach the privileged process only as the narrow calls it serves.
+//
+// The worker is the plugin binary itself, started with an empty bounding
+// set, no_new_privs and no capabilities. It keeps uid 0 so it can read and
+// write the root-owned state and IPAM files it had before. Calls are JSON
+// over a pipe pair, one at a time.
+package privsep
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "runtime"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+
+    "example.com/vlan-cni/pkg/caps"
+)
+
+// envWorker marks the worker process. It is removed from the worker's own
+// environment so IPAM plugins it runs are not taken for workers.
+const envWorker = "VLAN_CNI_PRIVSEP_WORKER"
+
+// The worker's request and response pipes
+const (
+    workerIn  = 3
+    workerOut = 4
+)
+
+// Handler serves one method in the worker
+type Handler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+type request struct {
+    Method   string          `json:"method"`
+    Params   json.RawMessage `json:"params"`
+    Deadline time.Time       `json:"deadline,omitempty"`
+}
+
+type response struct {
+    Result json.RawMessage `json:"result,omitempty"`
+    Error  *types.Error    `json:"error,omitempty"`
+}
+
+// IsWorker reports whether this process was started as the worker
+func IsWorker() bool {
+    return os.Getenv(envWorker) != ""
+}
+
+// Worker is the privileged side of a running worker
+type Worker struct {
+    mu   sync.Mutex
+    cmd  *exec.Cmd
+    in   io.WriteCloser
+    out  *bufio.Reader
+    exit chan error
+}
+
+// Start runs the worker. It returns nil without error when not running as
+// root, where there is nothing to separate.
+func Start() (*Worker, error) {
+    if os.Geteuid() != 0 {
+        return nil, nil
+    }
+    exe, err := os.Executable()
+    if err != nil {
+        return nil, fmt.Errorf("failed to find own executable: %v", err)
+    }
+    
+    reqR, reqW, err := os.Pipe()
+    if err != nil {
+        return nil, fmt.Errorf("failed to create worker pipe: %v", err)
+    }
+    respR, respW, err := os.Pipe()
+    if err != nil {
+        reqR.Close()
+        reqW.Close()
+        return nil, fmt.Errorf("failed to create worker pipe: %v", err)
+    }
+    defer reqR.Close()
+    defer respW.Close()
+    
+    cmd := exec.Command(exe)
+    cmd.Env = append(os.Environ(), envWorker+"=1")
+    cmd.ExtraFiles = []*os.File{reqR, respW}
+    // stdout carries the CNI result, the worker only logs
+    cmd.Stdout = os.Stderr
+    cmd.Stderr = os.Stderr
+    
+    // The child inherits the bounding set of the thread that forks it, so
+    // that thread is emptied and then retired with its goroutine
+    started := make(chan error, 1)
+    go func() {
+        runtime.LockOSThread()
+        if err := caps.Clear(); err != nil {
+            started <- err
+            return
+        }
+        started <- cmd.Start()
+    }()
+    if err := <-started; err != nil {
+        reqW.Close()
+        respR.Close()
+        return nil, fmt.Errorf("failed to start privsep worker: %v", err)
+    }
+    
+    w := &Worker{cmd: cmd, in: reqW, out: bufio.NewReader(respR), exit: make(chan error, 1)}
+    go func() {
+        w.exit <- cmd.Wait()
+        respR.Close()
+    }()
+    return w, nil
+}
+
+// Call runs method in the worker and decodes its result into result. Errors
+// the handler returned as *types.Error keep their code.
+func (w *Worker) Call(ctx context.Context, method string, params, result interface{}) error {
+    data, err := json.Marshal(params)
+    if err != nil {
+        return fmt.Errorf("failed to encode %s call: %v", method, err)
+    }
+    req := request{Method: method, Params: data}
+    if deadline, ok := ctx.Deadline(); ok {
+        req.Deadline = deadline
+    }
+    line, err := json.Marshal(req)
+    if err != nil {
+        return fmt.Errorf("failed to encode %s call: %v", method, err)
+    }
+    
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    
+    done := make(chan error, 1)
+    var resp response
+    go func() {
+        if _, err := w.in.Write(append(line, '\n')); err != nil {
+            done <- err
+            return
+        }
+        reply, err := w.out.ReadBytes('\n')
+        if err != nil {
+            done <- err
+            return
+        }
+        done <- json.Unmarshal(reply, &resp)
+    }()
+    
+    select {
+    case err := <-done:
+        if err != nil {
+            return fmt.Errorf("failed to call privsep worker: %v", err)
+        }
+    case <-ctx.Done():
+        // The pipe is left mid-call, so the worker cannot serve again
+        w.kill()
+        return ctx.Err()
+    }
+    
+    if resp.Error != nil {
+        return resp.Error
+    }
+    if result == nil {
+        return nil
+    }
+    if err := json.Unmarshal(resp.Result, result); err != nil {
+        return fmt.Errorf("failed to decode %s result: %v", method, err)
+    }
+    return nil
+}
+
+// Close stops the worker
+func (w *Worker) Close() error {
+    w.in.Close()
+    select {
+    case <-w.exit:
+    case <-time.After(time.Second):
+        w.kill()
+    }
+    return nil
+}
+
+func (w *Worker) kill() {
+    w.in.Close()
+    if w.cmd.Process != nil {
+        w.cmd.Process.Kill()
+    }
+}
+
+// Serve runs the worker side until the privileged process closes the
+// pipe. It refuses to serve while holding any capability, so a worker
+// started some other way does not run the handlers privileged.
+func Serve(handlers map[string]Handler) error {
+    os.Unsetenv(envWorker)
+    if err := checkNoCaps(); err != nil {
+        return err
+    }
+    
+    in := bufio.NewReader(os.NewFile(workerIn, "privsep-in"))
+    out := os.NewFile(workerOut, "privsep-out")
+    for {
+        line, err := in.ReadBytes('\n')
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("failed to read privsep request: %v", err)
+        }
+        
+        reply, err := json.Marshal(serveOne(handlers, line))
+        if err != nil {
+            reply, _ = json.Marshal(response{Error: types.NewError(types.ErrInternal, "failed to encode privsep result", err.Error())})
+        }
+        if _, err := out.Write(append(reply, '\n')); err != nil {
+            return fmt.Errorf("failed to write privsep response: %v", err)
+        }
+    }
+}
+
+func serveOne(handlers map[string]Handler, line []byte) response {
+    var req request
+    if err := json.Unmarshal(line, &req); err != nil {
+        return response{Error: types.NewError(types.ErrInternal, "failed to decode privsep request", err.Error())}
+    }
+    handler, ok := handlers[req.Method]
+    if !ok {
+        return response{Error: types.NewError(types.ErrInternal, fmt.Sprintf("unknown privsep method %q", req.Method), "")}
+    }
+    
+    ctx := context.Background()
+    if !req.Deadline.IsZero() {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithDeadline(ctx, req.Deadline)
+        defer cancel()
+    }
+    result, err := handler(ctx, req.Params)
+    if err != nil {
+        e, ok := err.(*types.Error)
+        if !ok {
+            e = types.NewError(types.ErrInternal, err.Error(), "")
+        }
+        return response{Error: e}
+    }
+    data, err := json.Marshal(result)
+    if err != nil {
+        return response{Error: types.NewError(types.ErrInternal, fmt.Sprintf("failed to encode %s result", req.Method), err.Error())}
+    }
+    return response{Result: data}
+}
+
+// checkNoCaps verifies the process holds no capabilities
+func checkNoCaps() error {
+    status, err := ioutil.ReadFile("/proc/self/status")
+    if err != nil {
+        return fmt.Errorf("failed to read process status: %v", err)
+    }
+    for _, line := range strings.Split(string(status), "\n") {
+        for _, set := range []string{"CapPrm:", "CapEff:", "CapBnd:", "CapAmb:"} {
+            if strings.HasPrefix(line, set) && strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, set)), "0") != "" {
+                return fmt.Errorf("privsep worker holds capabilities (%s), refusing to serve", strings.TrimSpace(line))
+            }
+        }
+    }
+    return nil
+}
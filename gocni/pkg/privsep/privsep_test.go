@@ -0,0 +1,89 @@
+package privsep
+
+import (
+    "context"
+    "encoding/json"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/containernetworking/cni/pkg/types"
+)
+
+func TestMain(m *testing.M) {
+    if IsWorker() {
+        if err := Serve(testHandlers); err != nil {
+            os.Stderr.WriteString(err.Error() + "\n")
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+    os.Exit(m.Run())
+}
+
+var testHandlers = map[string]Handler{
+    "echo": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+        return params, nil
+    },
+    "fail": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+        return nil, types.NewError(types.ErrTryAgainLater, "busy", "details")
+    },
+    "deadline": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+        _, ok := ctx.Deadline()
+        return ok, nil
+    },
+}
+
+func startWorker(t *testing.T) *Worker {
+    t.Helper()
+    if os.Geteuid() != 0 {
+        t.Skip("needs root")
+    }
+    w, err := Start()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { w.Close() })
+    return w
+}
+
+func TestCall(t *testing.T) {
+    w := startWorker(t)
+    
+    // The worker refuses to serve while holding capabilities, so any answer
+    // shows it runs without them
+    var got map[string]string
+    if err := w.Call(context.Background(), "echo", map[string]string{"a": "b"}, &got); err != nil {
+        t.Fatal(err)
+    }
+    if got["a"] != "b" {
+        t.Errorf("echo returned %v", got)
+    }
+    
+    err := w.Call(context.Background(), "fail", nil, nil)
+    e, ok := err.(*types.Error)
+    if !ok || e.Code != types.ErrTryAgainLater || e.Msg != "busy" || e.Details != "details" {
+        t.Errorf("got error %#v, want the handler's", err)
+    }
+    
+    if err := w.Call(context.Background(), "missing", nil, nil); err == nil {
+        t.Error("unknown method succeeded")
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    var hasDeadline bool
+    if err := w.Call(ctx, "deadline", nil, &hasDeadline); err != nil {
+        t.Fatal(err)
+    }
+    if !hasDeadline {
+        t.Error("the caller's deadline did not reach the worker")
+    }
+}
+
+func TestCallerKeepsCapabilities(t *testing.T) {
+    startWorker(t)
+    if err := checkNoCaps(); err == nil {
+        t.Error("starting the worker dropped the caller's capabilities")
+    }
+}
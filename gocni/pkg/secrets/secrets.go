@@ -0,0 +1,140 @@
+// Package secrets resolves credentials that the network configuration
+// references instead of carrying inline, since conflists are readable by
+// every user on the node.
+package secrets
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+    "example.com/vlan-cni/pkg/kube"
+    "example.com/vlan-cni/pkg/state"
+)
+
+// Defaults for Secret references
+const (
+    DefaultCacheTTLSeconds = 300
+    fetchTimeout           = 10 * time.Second
+)
+
+// Ref points at a credential: a file, read on every use so a rotated file
+// takes effect immediately, or a key of a Kubernetes Secret
+type Ref struct {
+    File   string        `json:"file,omitempty"`
+    Secret *SecretKeyRef `json:"secret,omitempty"`
+}
+
+// SecretKeyRef selects a key of a Kubernetes Secret
+type SecretKeyRef struct {
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    Key       string `json:"key"`
+
+    // Kubeconfig allowed to get the Secret, the in-cluster service account
+    // when empty. The plugin itself runs on the host and needs one.
+    Kubeconfig string `json:"kubeconfig,omitempty"`
+
+    // How long a fetched value is used before the Secret is read again; a
+    // stale value is still used when the API server cannot be reached
+    CacheTTLSeconds int `json:"cacheTTLSeconds,omitempty"`
+}
+
+// Validate checks that exactly one source is set
+func (r *Ref) Validate() error {
+    if (r.File == "") == (r.Secret == nil) {
+        return fmt.Errorf("exactly one of file or secret is required")
+    }
+    if s := r.Secret; s != nil {
+        if s.Namespace == "" || s.Name == "" || s.Key == "" {
+            return fmt.Errorf("secret namespace, name and key are required")
+        }
+        if s.CacheTTLSeconds < 0 {
+            return fmt.Errorf("invalid secret cacheTTLSeconds %d", s.CacheTTLSeconds)
+        }
+    }
+    return nil
+}
+
+// Resolve returns the credential r points at. Secret values are cached in
+// store, which may be nil to always read the Secret.
+func Resolve(ctx context.Context, r *Ref, store *state.Store) (string, error) {
+    if r.File != "" {
+        data, err := ioutil.ReadFile(r.File)
+        if err != nil {
+            return "", fmt.Errorf("failed to read credential file: %v", err)
+        }
+        return strings.TrimRight(string(data), "\r\n"), nil
+    }
+    
+    s := r.Secret
+    key := cacheKey(s)
+    var cached *state.CachedSecret
+    if store != nil {
+        c, err := store.GetSecret(key)
+        if err != nil {
+            return "", err
+        }
+        cached = c
+    }
+    if cached != nil && time.Since(cached.Fetched) < s.cacheTTL() {
+        return cached.Value, nil
+    }
+    
+    value, err := fetch(ctx, s)
+    if err != nil {
+        if cached != nil {
+            fmt.Fprintf(os.Stderr, "level=warn msg=%q secret=%s/%s error=%q\n",
+                "using cached credential", s.Namespace, s.Name, err)
+            return cached.Value, nil
+        }
+        return "", err
+    }
+    if store != nil {
+        if err := store.SaveSecret(key, &state.CachedSecret{Value: value, Fetched: time.Now().UTC()}); err != nil {
+            return "", err
+        }
+    }
+    return value, nil
+}
+
+func fetch(ctx context.Context, s *SecretKeyRef) (string, error) {
+    client, err := kube.NewClient(s.Kubeconfig)
+    if err != nil {
+        return "", err
+    }
+    
+    ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+    defer cancel()
+    
+    secret, err := client.CoreV1().Secrets(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+    if err != nil {
+        return "", fmt.Errorf("failed to get secret %s/%s: %v", s.Namespace, s.Name, err)
+    }
+    value, ok := secret.Data[s.Key]
+    if !ok {
+        return "", fmt.Errorf("secret %s/%s has no key %q", s.Namespace, s.Name, s.Key)
+    }
+    return string(value), nil
+}
+
+func (s *SecretKeyRef) cacheTTL() time.Duration {
+    if s.CacheTTLSeconds > 0 {
+        return time.Duration(s.CacheTTLSeconds) * time.Second
+    }
+    return DefaultCacheTTLSeconds * time.Second
+}
+
+// cacheKey names the cache document without putting the Secret's
+// coordinates in a file name
+func cacheKey(s *SecretKeyRef) string {
+    sum := sha256.Sum256([]byte(s.Namespace + "/" + s.Name + "/" + s.Key))
+    return hex.EncodeToString(sum[:8])
+}
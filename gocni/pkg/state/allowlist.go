@@ -0,0 +1,32 @@
+package state
+
+import "time"
+
+const vlanAllowlistName = "allowed-vlans.json"
+
+// VlanAllowlist is the node's VLAN allowlist as the daemon last read it
+// from the node annotation, in ParseVlanSet syntax
+type VlanAllowlist struct {
+    VLANs   string    `json:"vlans"`
+    Updated time.Time `json:"updated"`
+}
+
+// GetVlanAllowlist returns the synced allowlist, or nil if there is none
+func (s *Store) GetVlanAllowlist() (*VlanAllowlist, error) {
+    a := &VlanAllowlist{}
+    if err := s.Load(vlanAllowlistName, a); err != nil {
+        return nil, err
+    }
+    if a.Updated.IsZero() {
+        return nil, nil
+    }
+    return a, nil
+}
+
+// SaveVlanAllowlist records the allowlist, removing it when a is nil
+func (s *Store) SaveVlanAllowlist(a *VlanAllowlist) error {
+    if a == nil {
+        return s.Remove(vlanAllowlistName)
+    }
+    return s.Save(vlanAllowlistName, a)
+}
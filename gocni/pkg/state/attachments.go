@@ -0,0 +1,113 @@
+package state
+
+import (
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+const attachmentsDir = "attachments"
+
+// warnLog receives records skipped while listing, kept on stderr like the
+// plugin's warnings
+var warnLog io.Writer = os.Stderr
+
+// The attachment records are versioned documents other components read,
+// see package v1
+type (
+    Identity   = vlanv1.Identity
+    Attachment = vlanv1.Attachment
+    GatewayRef = vlanv1.GatewayRef
+)
+
+func attachmentName(containerID, ifName string) string {
+    return filepath.Join(attachmentsDir, containerID+"-"+ifName+".json")
+}
+
+// SaveAttachment writes or replaces an attachment record, as a v1 document
+func (s *Store) SaveAttachment(a *Attachment) error {
+    a.TypeMeta = vlanv1.Meta(vlanv1.KindAttachment)
+    if err := os.MkdirAll(filepath.Join(s.dir, attachmentsDir), 0700); err != nil {
+        return fmt.Errorf("failed to create attachment state directory: %v", err)
+    }
+    return s.Save(attachmentName(a.ContainerID, a.IfName), a)
+}
+
+// GetAttachment returns the attachment record, or nil if there is none
+func (s *Store) GetAttachment(containerID, ifName string) (*Attachment, error) {
+    a, err := s.loadAttachment(attachmentName(containerID, ifName))
+    if err != nil || a.ContainerID == "" {
+        return nil, err
+    }
+    return a, nil
+}
+
+// loadAttachment reads a record, refusing versions it does not know
+func (s *Store) loadAttachment(name string) (*Attachment, error) {
+    a := &Attachment{}
+    if err := s.Load(name, a); err != nil {
+        return nil, err
+    }
+    if err := a.Check(vlanv1.KindAttachment); err != nil {
+        return nil, &versionError{name: name, err: err}
+    }
+    return a, nil
+}
+
+// versionError is a record of a version or kind this build does not know,
+// such as one written by a newer plugin
+type versionError struct {
+    name string
+    err  error
+}
+
+func (e *versionError) Error() string {
+    return fmt.Sprintf("failed to read state %q: %v", e.name, e.err)
+}
+
+// DeleteAttachment removes an attachment record, returning what was stored
+func (s *Store) DeleteAttachment(containerID, ifName string) (*Attachment, error) {
+    a, err := s.GetAttachment(containerID, ifName)
+    if err != nil || a == nil {
+        return nil, err
+    }
+    if err := os.Remove(filepath.Join(s.dir, attachmentName(containerID, ifName))); err != nil && !os.IsNotExist(err) {
+        return nil, fmt.Errorf("failed to remove attachment state: %v", err)
+    }
+    return a, nil
+}
+
+// ListAttachments returns every recorded attachment on this node. Records
+// of a version it does not know are logged and left out, so one newer
+// record does not hide the others.
+func (s *Store) ListAttachments() ([]*Attachment, error) {
+    entries, err := ioutil.ReadDir(filepath.Join(s.dir, attachmentsDir))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to list attachment state: %v", err)
+    }
+    
+    var attachments []*Attachment
+    for _, e := range entries {
+        if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+            continue
+        }
+        a, err := s.loadAttachment(filepath.Join(attachmentsDir, e.Name()))
+        if vErr, ok := err.(*versionError); ok {
+            fmt.Fprintf(warnLog, "level=warn msg=%q error=%q\n", "skipping attachment record", vErr.Error())
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        attachments = append(attachments, a)
+    }
+    return attachments, nil
+}
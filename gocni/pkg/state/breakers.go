@@ -0,0 +1,39 @@
+package state
+
+import (
+    "path/filepath"
+    "time"
+)
+
+const breakersDir = "breakers"
+
+// Breaker is the circuit breaker state of one IPAM backend, shared by all
+// plugin invocations on the node
+type Breaker struct {
+    // Consecutive failed calls
+    Failures int `json:"failures"`
+
+    // Calls fail fast until this time once the breaker has opened
+    OpenUntil time.Time `json:"openUntil,omitempty"`
+}
+
+func breakerName(key string) string {
+    return filepath.Join(breakersDir, key+".json")
+}
+
+// GetBreaker returns the state of the named breaker, closed when unknown
+func (s *Store) GetBreaker(key string) (*Breaker, error) {
+    b := &Breaker{}
+    if err := s.Load(breakerName(key), b); err != nil {
+        return nil, err
+    }
+    return b, nil
+}
+
+// SaveBreaker records the state of the named breaker, forgetting closed ones
+func (s *Store) SaveBreaker(key string, b *Breaker) error {
+    if b.Failures == 0 {
+        return s.Remove(breakerName(key))
+    }
+    return s.Save(breakerName(key), b)
+}
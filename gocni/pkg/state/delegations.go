@@ -0,0 +1,44 @@
+package state
+
+import (
+    "encoding/json"
+    "path/filepath"
+)
+
+const delegationsDir = "delegations"
+
+// Delegation is a network attached in delegating mode, kept so DEL can
+// undo it after the pod and its annotation are gone
+type Delegation struct {
+    Network string          `json:"network"`
+    IfName  string          `json:"ifName"`
+    Type    string          `json:"type"`
+    Config  json.RawMessage `json:"config"`
+    // Result is what the delegate returned from ADD, handed back to it
+    // as prevResult on CHECK and DEL
+    Result json.RawMessage `json:"result,omitempty"`
+    Identity
+}
+
+func delegationsName(containerID string) string {
+    return filepath.Join(delegationsDir, containerID+".json")
+}
+
+// SaveDelegations records the delegated networks of a container
+func (s *Store) SaveDelegations(containerID string, delegations []*Delegation) error {
+    return s.Save(delegationsName(containerID), delegations)
+}
+
+// GetDelegations returns the delegated networks of a container
+func (s *Store) GetDelegations(containerID string) ([]*Delegation, error) {
+    var delegations []*Delegation
+    if err := s.Load(delegationsName(containerID), &delegations); err != nil {
+        return nil, err
+    }
+    return delegations, nil
+}
+
+// DeleteDelegations forgets the delegated networks of a container
+func (s *Store) DeleteDelegations(containerID string) error {
+    return s.Remove(delegationsName(containerID))
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+const deletionsDir = "deletions"
+
+// Deletion records when DEL of an attachment finished
+type Deletion struct {
+    Finished time.Time `json:"finished"`
+}
+
+func deletionName(containerID, ifName string) string {
+    return filepath.Join(deletionsDir, containerID+"-"+ifName+".json")
+}
+
+// SaveDeletion records that DEL of the attachment finished at t
+func (s *Store) SaveDeletion(containerID, ifName string, t time.Time) error {
+    return s.Save(deletionName(containerID, ifName), &Deletion{Finished: t})
+}
+
+// GetDeletion returns when DEL of the attachment finished, the zero time
+// if it did not
+func (s *Store) GetDeletion(containerID, ifName string) (time.Time, error) {
+    d := &Deletion{}
+    if err := s.Load(deletionName(containerID, ifName), d); err != nil {
+        return time.Time{}, err
+    }
+    return d.Finished, nil
+}
+
+// ForgetDeletion removes the record of a DEL
+func (s *Store) ForgetDeletion(containerID, ifName string) error {
+    return s.Remove(deletionName(containerID, ifName))
+}
+
+// PruneDeletions removes records of DELs finished before t
+func (s *Store) PruneDeletions(t time.Time) error {
+    entries, err := ioutil.ReadDir(filepath.Join(s.dir, deletionsDir))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return fmt.Errorf("failed to list deletions: %v", err)
+    }
+    for _, e := range entries {
+        d := &Deletion{}
+        name := filepath.Join(deletionsDir, e.Name())
+        if err := s.Load(name, d); err != nil || d.Finished.Before(t) {
+            if err := s.Remove(name); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,33 @@
+package state
+
+import (
+    "os"
+    "path/filepath"
+)
+
+const dhcpv6Dir = "dhcpv6"
+
+func dhcpv6LeaseName(containerID, ifName string) string {
+    return filepath.Join(dhcpv6Dir, containerID+"-"+ifName+".json")
+}
+
+// SaveDHCPv6Lease records the DHCPv6 lease of an attachment, for the daemon
+// to renew and DEL to release. The store keeps it opaque.
+func (s *Store) SaveDHCPv6Lease(containerID, ifName string, lease interface{}) error {
+    return s.Save(dhcpv6LeaseName(containerID, ifName), lease)
+}
+
+// GetDHCPv6Lease reads the attachment's lease into lease, reporting whether
+// there is one
+func (s *Store) GetDHCPv6Lease(containerID, ifName string, lease interface{}) (bool, error) {
+    name := dhcpv6LeaseName(containerID, ifName)
+    if _, err := os.Stat(filepath.Join(s.dir, name)); os.IsNotExist(err) {
+        return false, nil
+    }
+    return true, s.Load(name, lease)
+}
+
+// DeleteDHCPv6Lease forgets the attachment's lease
+func (s *Store) DeleteDHCPv6Lease(containerID, ifName string) error {
+    return s.Remove(dhcpv6LeaseName(containerID, ifName))
+}
@@ -0,0 +1,47 @@
+package state
+
+import (
+    "path/filepath"
+    "time"
+)
+
+const gatewaysDir = "gateways"
+
+// Gateway is the physical router last seen holding a network's virtual
+// gateway address
+type Gateway struct {
+    Network string `json:"network"`
+    Address string `json:"address"`
+    VRID    int    `json:"vrid"`
+
+    // Address of the VRRP master, empty while none advertises
+    ActiveRouter string    `json:"activeRouter"`
+    Since        time.Time `json:"since"`
+    Updated      time.Time `json:"updated"`
+}
+
+func gatewayName(network string) string {
+    return filepath.Join(gatewaysDir, network+".json")
+}
+
+// RecordGatewayRouter stores the router seen holding the network's gateway
+// and returns the one it replaces, when it changed
+func (s *Store) RecordGatewayRouter(network, address string, vrid int, router string) (previous string, changed bool, err error) {
+    if err := s.Lock(); err != nil {
+        return "", false, err
+    }
+    defer s.Unlock()
+    
+    g := &Gateway{}
+    if err := s.Load(gatewayName(network), g); err != nil {
+        return "", false, err
+    }
+    now := time.Now().UTC()
+    known := !g.Updated.IsZero() && g.Address == address && g.VRID == vrid
+    changed = known && g.ActiveRouter != router
+    if !known || changed {
+        previous, g.Since = g.ActiveRouter, now
+    }
+    *g = Gateway{Network: network, Address: address, VRID: vrid, ActiveRouter: router, Since: g.Since, Updated: now}
+    return previous, changed, s.Save(gatewayName(network), g)
+}
@@ -0,0 +1,32 @@
+package state
+
+import "time"
+
+const maintenanceName = "maintenance.json"
+
+// Maintenance is recorded by the daemon while the node's networking is in
+// maintenance, during which the plugin fails ADDs and keeps serving DELs
+type Maintenance struct {
+    Reason string    `json:"reason"`
+    Since  time.Time `json:"since"`
+}
+
+// GetMaintenance returns the node's maintenance, or nil if there is none
+func (s *Store) GetMaintenance() (*Maintenance, error) {
+    m := &Maintenance{}
+    if err := s.Load(maintenanceName, m); err != nil {
+        return nil, err
+    }
+    if m.Since.IsZero() {
+        return nil, nil
+    }
+    return m, nil
+}
+
+// SaveMaintenance records the maintenance, ending it when m is nil
+func (s *Store) SaveMaintenance(m *Maintenance) error {
+    if m == nil {
+        return s.Remove(maintenanceName)
+    }
+    return s.Save(maintenanceName, m)
+}
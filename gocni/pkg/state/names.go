@@ -0,0 +1,119 @@
+package state
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+)
+
+const namesFile = "names.json"
+
+// NameMapping records a hashed interface name and the name it stands in for
+type NameMapping struct {
+    Name         string   `json:"name"`
+    Source       string   `json:"source"`
+    ContainerIDs []string `json:"containerIDs,omitempty"`
+}
+
+// nameTable is the on-disk form of the name mappings, keyed by hashed name
+type nameTable map[string]*NameMapping
+
+// HashedName returns a deterministic interface name of at most maxLen
+// characters standing in for source, recording the mapping against
+// containerID. Names already claimed by a different source are skipped.
+func (s *Store) HashedName(source, prefix string, maxLen int, containerID string) (string, error) {
+    if err := s.Lock(); err != nil {
+        return "", err
+    }
+    defer s.Unlock()
+    
+    table := nameTable{}
+    if err := s.Load(namesFile, &table); err != nil {
+        return "", err
+    }
+    
+    for attempt := 0; attempt < 16; attempt++ {
+        name := hashName(source, prefix, maxLen, attempt)
+        m, ok := table[name]
+        if ok && m.Source != source {
+            // Collision with another long name, try the next candidate
+            continue
+        }
+        if !ok {
+            m = &NameMapping{Name: name, Source: source}
+            table[name] = m
+        }
+        m.addContainer(containerID)
+        if err := s.Save(namesFile, table); err != nil {
+            return "", err
+        }
+        return name, nil
+    }
+    
+    return "", fmt.Errorf("failed to derive a unique interface name for %q", source)
+}
+
+// ReleaseNames drops containerID from every mapping, forgetting mappings no
+// longer used by any container
+func (s *Store) ReleaseNames(containerID string) error {
+    if err := s.Lock(); err != nil {
+        return err
+    }
+    defer s.Unlock()
+    
+    table := nameTable{}
+    if err := s.Load(namesFile, &table); err != nil {
+        return err
+    }
+    
+    changed := false
+    for name, m := range table {
+        if !m.removeContainer(containerID) {
+            continue
+        }
+        changed = true
+        if len(m.ContainerIDs) == 0 {
+            delete(table, name)
+        }
+    }
+    
+    if !changed {
+        return nil
+    }
+    return s.Save(namesFile, table)
+}
+
+// hashName builds the candidate name for a given collision attempt
+func hashName(source, prefix string, maxLen, attempt int) string {
+    input := source
+    if attempt > 0 {
+        input = fmt.Sprintf("%s#%d", source, attempt)
+    }
+    sum := sha256.Sum256([]byte(input))
+    digest := hex.EncodeToString(sum[:])
+    
+    // Keep up to half of the budget for a recognizable prefix
+    if len(prefix) > maxLen/2 {
+        prefix = prefix[:maxLen/2]
+    }
+    return prefix + digest[:maxLen-len(prefix)]
+}
+
+func (m *NameMapping) addContainer(containerID string) {
+    for _, id := range m.ContainerIDs {
+        if id == containerID {
+            return
+        }
+    }
+    m.ContainerIDs = append(m.ContainerIDs, containerID)
+}
+
+func (m *NameMapping) removeContainer(containerID string) bool {
+    for i, id := range m.ContainerIDs {
+        if id == containerID {
+            m.ContainerIDs = append(m.ContainerIDs[:i], m.ContainerIDs[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
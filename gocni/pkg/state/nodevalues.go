@@ -0,0 +1,31 @@
+package state
+
+import "time"
+
+const nodeValuesName = "node-values.json"
+
+// NodeValues are the node's labels and annotations as the daemon last read
+// them, for rendering templated network configuration at ADD
+type NodeValues struct {
+    Name        string            `json:"name"`
+    Labels      map[string]string `json:"labels,omitempty"`
+    Annotations map[string]string `json:"annotations,omitempty"`
+    Updated     time.Time         `json:"updated"`
+}
+
+// GetNodeValues returns the synced node values, or nil if there are none
+func (s *Store) GetNodeValues() (*NodeValues, error) {
+    v := &NodeValues{}
+    if err := s.Load(nodeValuesName, v); err != nil {
+        return nil, err
+    }
+    if v.Updated.IsZero() {
+        return nil, nil
+    }
+    return v, nil
+}
+
+// SaveNodeValues records the node values
+func (s *Store) SaveNodeValues(v *NodeValues) error {
+    return s.Save(nodeValuesName, v)
+}
@@ -0,0 +1,53 @@
+package state
+
+import (
+    "fmt"
+    "path/filepath"
+)
+
+const poolDir = "pool"
+
+// PoolKey names the warm pool of links for a master, VLAN and untagged mode
+func PoolKey(master string, vlanID int, untaggedMode string) string {
+    if vlanID != 0 {
+        untaggedMode = "vlan"
+    }
+    return fmt.Sprintf("%s-%d-%s", master, vlanID, untaggedMode)
+}
+
+func poolName(key string) string {
+    return filepath.Join(poolDir, key+".json")
+}
+
+// PoolLinks returns the names of the idle links in a pool. The caller is
+// expected to hold the store lock when it goes on to change the pool.
+func (s *Store) PoolLinks(key string) ([]string, error) {
+    var names []string
+    if err := s.Load(poolName(key), &names); err != nil {
+        return nil, err
+    }
+    return names, nil
+}
+
+// SetPoolLinks replaces the idle links of a pool
+func (s *Store) SetPoolLinks(key string, names []string) error {
+    return s.Save(poolName(key), names)
+}
+
+// TakePoolLink removes and returns one idle link of the pool, or "" when the
+// pool is empty
+func (s *Store) TakePoolLink(key string) (string, error) {
+    if err := s.Lock(); err != nil {
+        return "", err
+    }
+    defer s.Unlock()
+    
+    names, err := s.PoolLinks(key)
+    if err != nil || len(names) == 0 {
+        return "", err
+    }
+    if err := s.SetPoolLinks(key, names[1:]); err != nil {
+        return "", err
+    }
+    return names[0], nil
+}
@@ -0,0 +1,79 @@
+package state
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const rateLimitsDir = "ratelimits"
+
+// TokenBucket limits how fast pods attach to one network on this node. The
+// daemon sets Rate and Burst; each ADD takes a token, refilling the bucket
+// for the time passed since Updated.
+type TokenBucket struct {
+    // Tokens added per second and the most the bucket holds
+    Rate  float64 `json:"rate"`
+    Burst int     `json:"burst"`
+
+    Tokens  float64   `json:"tokens"`
+    Updated time.Time `json:"updated"`
+}
+
+// Refill adds the tokens earned up to now
+func (b *TokenBucket) Refill(now time.Time) {
+    if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+        b.Tokens += elapsed * b.Rate
+    }
+    if b.Tokens > float64(b.Burst) {
+        b.Tokens = float64(b.Burst)
+    }
+    b.Updated = now
+}
+
+func tokenBucketName(network string) string {
+    return filepath.Join(rateLimitsDir, network+".json")
+}
+
+// GetTokenBucket returns the network's bucket, or nil if it is not limited
+func (s *Store) GetTokenBucket(network string) (*TokenBucket, error) {
+    b := &TokenBucket{}
+    if err := s.Load(tokenBucketName(network), b); err != nil {
+        return nil, err
+    }
+    if b.Updated.IsZero() {
+        return nil, nil
+    }
+    return b, nil
+}
+
+// SaveTokenBucket records the network's bucket, removing it when b is nil
+func (s *Store) SaveTokenBucket(network string, b *TokenBucket) error {
+    if b == nil {
+        return s.Remove(tokenBucketName(network))
+    }
+    return s.Save(tokenBucketName(network), b)
+}
+
+// RateLimitedNetworks returns the networks that have a bucket
+func (s *Store) RateLimitedNetworks() ([]string, error) {
+    entries, err := ioutil.ReadDir(filepath.Join(s.dir, rateLimitsDir))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to list rate limit state: %v", err)
+    }
+    
+    var networks []string
+    for _, e := range entries {
+        if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+            continue
+        }
+        networks = append(networks, strings.TrimSuffix(e.Name(), ".json"))
+    }
+    return networks, nil
+}
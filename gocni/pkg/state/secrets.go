@@ -0,0 +1,36 @@
+package state
+
+import (
+    "path/filepath"
+    "time"
+)
+
+const secretsDir = "secrets"
+
+// CachedSecret is a Kubernetes Secret value kept so plugin invocations do
+// not each hit the API server, and so a short API outage does not fail them
+type CachedSecret struct {
+    Value   string    `json:"value"`
+    Fetched time.Time `json:"fetched"`
+}
+
+func secretName(key string) string {
+    return filepath.Join(secretsDir, key+".json")
+}
+
+// GetSecret returns the cached secret, or nil if there is none
+func (s *Store) GetSecret(key string) (*CachedSecret, error) {
+    c := &CachedSecret{}
+    if err := s.Load(secretName(key), c); err != nil {
+        return nil, err
+    }
+    if c.Fetched.IsZero() {
+        return nil, nil
+    }
+    return c, nil
+}
+
+// SaveSecret caches a secret value
+func (s *Store) SaveSecret(key string, c *CachedSecret) error {
+    return s.Save(secretName(key), c)
+}
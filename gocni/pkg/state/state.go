@@ -0,0 +1,147 @@
+package state
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "syscall"
+)
+
+// DefaultDir is where the plugin keeps node-local state between invocations.
+// It is on tmpfs on most hosts, so the state lasts until the node reboots,
+// as the pods' network namespaces do.
+const DefaultDir = "/var/run/vlan-cni"
+
+// Store persists plugin state as JSON documents in a directory, serialized
+// across concurrent plugin invocations with an exclusive file lock
+type Store struct {
+    dir  string
+    lock *os.File
+}
+
+// NewStore returns a store rooted at dir, creating it if necessary
+func NewStore(dir string) (*Store, error) {
+    if dir == "" {
+        dir = DefaultDir
+    }
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, fmt.Errorf("failed to create state directory %q: %v", dir, err)
+    }
+    return &Store{dir: dir}, nil
+}
+
+// Dir returns the directory backing the store
+func (s *Store) Dir() string {
+    return s.dir
+}
+
+// Lock takes the store-wide lock, blocking until it is available
+func (s *Store) Lock() error {
+    f, err := os.OpenFile(filepath.Join(s.dir, ".lock"), os.O_CREATE|os.O_RDWR, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to open state lock: %v", err)
+    }
+    if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+        f.Close()
+        return fmt.Errorf("failed to lock state: %v", err)
+    }
+    s.lock = f
+    return nil
+}
+
+// Unlock releases the store-wide lock
+func (s *Store) Unlock() error {
+    if s.lock == nil {
+        return nil
+    }
+    defer func() { s.lock = nil }()
+    syscall.Flock(int(s.lock.Fd()), syscall.LOCK_UN)
+    return s.lock.Close()
+}
+
+// Load reads the named document into v. A missing document leaves v untouched.
+func (s *Store) Load(name string, v interface{}) error {
+    data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return fmt.Errorf("failed to read state %q: %v", name, err)
+    }
+    if err := json.Unmarshal(data, v); err != nil {
+        return fmt.Errorf("failed to decode state %q: %v", name, err)
+    }
+    return nil
+}
+
+// Save atomically replaces the named document with v, so a crash leaves
+// either document whole. On a disk-backed state directory the new document
+// is synced before it replaces the old one, and the rename before Save
+// returns, so the same holds across a power loss; on tmpfs, the default,
+// the syncs do nothing and the store does not outlive the boot anyway.
+// Documents are replaced whole rather than journaled.
+func (s *Store) Save(name string, v interface{}) error {
+    data, err := json.MarshalIndent(v, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to encode state %q: %v", name, err)
+    }
+    
+    path := filepath.Join(s.dir, name)
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return fmt.Errorf("failed to create state directory for %q: %v", name, err)
+    }
+    tmp, err := writeSynced(path, data)
+    if err != nil {
+        return fmt.Errorf("failed to write state %q: %v", name, err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("failed to commit state %q: %v", name, err)
+    }
+    if err := syncDir(filepath.Dir(path)); err != nil {
+        return fmt.Errorf("failed to commit state %q: %v", name, err)
+    }
+    return nil
+}
+
+// writeSynced writes data to a new temporary file next to path, unique to
+// this call so concurrent writers cannot truncate each other's, and returns
+// its name
+func writeSynced(path string, data []byte) (string, error) {
+    f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return "", err
+    }
+    _, err = f.Write(data)
+    if err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(f.Name())
+        return "", err
+    }
+    return f.Name(), nil
+}
+
+// syncDir makes the renames in dir durable
+func syncDir(dir string) error {
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    defer d.Close()
+    return d.Sync()
+}
+
+// Remove deletes the named document, ignoring documents that do not exist
+func (s *Store) Remove(name string) error {
+    if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("failed to remove state %q: %v", name, err)
+    }
+    return nil
+}
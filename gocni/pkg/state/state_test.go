@@ -0,0 +1,44 @@
+package state
+
+import (
+    "io/ioutil"
+    "sync"
+    "testing"
+)
+
+// TestSaveConcurrent has writers replace one document at once, which must
+// leave one of their documents whole and no temporary files behind
+func TestSaveConcurrent(t *testing.T) {
+    s, err := NewStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    var wg sync.WaitGroup
+    for i := 0; i < 16; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            doc := map[string]int{}
+            for j := 0; j <= i*100; j++ {
+                doc[string(rune('a'+j%26))+string(rune('a'+j/26%26))] = i
+            }
+            if err := s.Save("doc.json", doc); err != nil {
+                t.Error(err)
+            }
+        }(i)
+    }
+    wg.Wait()
+    
+    doc := map[string]int{}
+    if err := s.Load("doc.json", &doc); err != nil {
+        t.Fatal(err)
+    }
+    entries, err := ioutil.ReadDir(s.Dir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 1 {
+        t.Errorf("got %d files in the store, want only doc.json", len(entries))
+    }
+}
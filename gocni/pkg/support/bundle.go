@@ -0,0 +1,253 @@
+// Package support collects what upstream needs to triage an issue from a
+// node into one tarball: plugin logs, the state store, the kernel's view of
+// links, addresses, routes and rules, nftables rules and the network and
+// daemon configuration with credentials redacted. manifest.json lists the
+// contents as a versioned document.
+package support
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/vishvananda/netlink"
+
+    vlanv1 "example.com/vlan-cni/pkg/types/v1"
+)
+
+// Options are where the bundle's sources live on the node
+type Options struct {
+    ConfDir      string
+    StateDir     string
+    LogFiles     []string
+    DaemonConfig string
+}
+
+// redacted replaces the values of credential fields
+const redacted = "REDACTED"
+
+// sensitiveKey matches fields holding credentials. References to them
+// (tsigSecretFrom, bearerTokenFrom) are kept, they name secrets rather
+// than carry them.
+var sensitiveKey = regexp.MustCompile(`(?i)(secret|password|passphrase|token|privatekey|^key$)`)
+
+// referenceKey matches fields naming where a credential lives
+var referenceKey = regexp.MustCompile(`(?i)(from|file|ref)$`)
+
+// Collect writes the bundle to w as a gzipped tarball. Sources that are
+// missing or fail are noted in errors.txt rather than failing the bundle.
+func Collect(ctx context.Context, opts Options, w io.Writer) error {
+    gz := gzip.NewWriter(w)
+    tw := tar.NewWriter(gz)
+    b := &bundle{tw: tw, now: time.Now(), files: []string{}}
+    
+    for _, path := range opts.LogFiles {
+        b.file("logs/"+filepath.Base(path), path)
+    }
+    b.state(opts.StateDir)
+    b.configs(opts.ConfDir, opts.DaemonConfig)
+    b.netlink()
+    b.command(ctx, "nftables.txt", "nft", "list", "ruleset")
+    
+    if len(b.errors) > 0 {
+        b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+    }
+    b.manifest()
+    if err := tw.Close(); err != nil {
+        return err
+    }
+    return gz.Close()
+}
+
+type bundle struct {
+    tw     *tar.Writer
+    now    time.Time
+    files  []string
+    errors []string
+}
+
+func (b *bundle) fail(format string, args ...interface{}) {
+    b.errors = append(b.errors, fmt.Sprintf(format, args...))
+}
+
+func (b *bundle) add(name string, data []byte) {
+    hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: b.now}
+    if err := b.tw.WriteHeader(hdr); err != nil {
+        b.fail("%s: %v", name, err)
+        return
+    }
+    if _, err := b.tw.Write(data); err != nil {
+        b.fail("%s: %v", name, err)
+        return
+    }
+    b.files = append(b.files, name)
+}
+
+// manifest adds manifest.json, last so it lists every other file
+func (b *bundle) manifest() {
+    hostname, _ := os.Hostname()
+    b.json("manifest.json", &vlanv1.BundleManifest{
+        TypeMeta: vlanv1.Meta(vlanv1.KindBundleManifest),
+        Created:  b.now.UTC(),
+        Hostname: hostname,
+        Files:    b.files,
+        Errors:   b.errors,
+    })
+}
+
+func (b *bundle) file(name, path string) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        b.fail("%s: %v", path, err)
+        return
+    }
+    b.add(name, data)
+}
+
+// state adds the state store, less cached secret values and sockets.
+// Records such as the delegations carry network configurations, so JSON
+// files are redacted like the configuration files.
+func (b *bundle) state(dir string) {
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            b.fail("%s: %v", path, err)
+            return nil
+        }
+        rel, _ := filepath.Rel(dir, path)
+        if info.IsDir() && rel == "secrets" {
+            return filepath.SkipDir
+        }
+        if !info.Mode().IsRegular() {
+            return nil
+        }
+        if filepath.Ext(path) == ".json" {
+            b.config(filepath.Join("state", rel), path)
+        } else {
+            b.file(filepath.Join("state", rel), path)
+        }
+        return nil
+    })
+    if err != nil {
+        b.fail("%s: %v", dir, err)
+    }
+}
+
+// configs adds the network configuration files and the daemon's, redacted
+func (b *bundle) configs(confDir, daemonConfig string) {
+    paths, _ := filepath.Glob(filepath.Join(confDir, "*"))
+    for _, path := range paths {
+        if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+            continue
+        }
+        b.config(filepath.Join("config", "net.d", filepath.Base(path)), path)
+    }
+    if daemonConfig != "" {
+        b.config("config/daemon.json", daemonConfig)
+    }
+}
+
+func (b *bundle) config(name, path string) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        b.fail("%s: %v", path, err)
+        return
+    }
+    clean, err := Sanitize(data)
+    if err != nil {
+        // Not JSON, so no telling what is sensitive in it
+        b.fail("%s: left out, cannot redact: %v", path, err)
+        return
+    }
+    b.add(name, clean)
+}
+
+// Sanitize redacts the credentials of a JSON configuration
+func Sanitize(data []byte) ([]byte, error) {
+    var doc interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, err
+    }
+    return json.MarshalIndent(redact("", doc), "", "  ")
+}
+
+func redact(key string, v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for k, item := range v {
+            v[k] = redact(k, item)
+        }
+        return v
+    case []interface{}:
+        for i, item := range v {
+            v[i] = redact(key, item)
+        }
+        return v
+    case string:
+        if sensitiveKey.MatchString(key) && !referenceKey.MatchString(key) {
+            return redacted
+        }
+    }
+    return v
+}
+
+// netlink adds the host's links, addresses, routes of every table and rules
+func (b *bundle) netlink() {
+    links, err := netlink.LinkList()
+    if err != nil {
+        b.fail("links: %v", err)
+    }
+    b.json("netlink/links.json", links)
+    
+    addrs, err := netlink.AddrList(nil, netlink.FAMILY_ALL)
+    if err != nil {
+        b.fail("addresses: %v", err)
+    }
+    b.json("netlink/addrs.json", addrs)
+    
+    routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: 0}, netlink.RT_FILTER_TABLE)
+    if err != nil {
+        b.fail("routes: %v", err)
+    }
+    b.json("netlink/routes.json", routes)
+    
+    rules, err := netlink.RuleList(netlink.FAMILY_ALL)
+    if err != nil {
+        b.fail("rules: %v", err)
+    }
+    b.json("netlink/rules.json", rules)
+}
+
+func (b *bundle) json(name string, v interface{}) {
+    data, err := json.MarshalIndent(v, "", "  ")
+    if err != nil {
+        b.fail("%s: %v", name, err)
+        return
+    }
+    b.add(name, data)
+}
+
+// command adds the output of a tool that may not be installed
+func (b *bundle) command(ctx context.Context, name string, argv ...string) {
+    ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+    defer cancel()
+    
+    var out bytes.Buffer
+    cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+    cmd.Stdout, cmd.Stderr = &out, &out
+    if err := cmd.Run(); err != nil {
+        b.fail("%s: %v: %s", strings.Join(argv, " "), err, strings.TrimSpace(out.String()))
+        return
+    }
+    b.add(name, out.Bytes())
+}
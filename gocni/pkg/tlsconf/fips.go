@@ -0,0 +1,9 @@
+//go:build boringcrypto
+
+package tlsconf
+
+// Restricts crypto/tls to FIPS-approved settings for the whole binary
+import _ "crypto/tls/fipsonly"
+
+// FIPS reports a build with the BoringCrypto module, see "make build-fips"
+const FIPS = true
@@ -0,0 +1,6 @@
+//go:build !boringcrypto
+
+package tlsconf
+
+// FIPS reports a build with the BoringCrypto module, see "make build-fips"
+const FIPS = false
@@ -0,0 +1,124 @@
+// Package tlsconf builds the TLS configuration of outbound clients: IPAM
+// backends, out-of-process IPAM drivers and webhooks. Kubernetes clients
+// take theirs from the kubeconfig, and client-go already requires TLS 1.2.
+package tlsconf
+
+import (
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/base64"
+    "fmt"
+    "io/ioutil"
+)
+
+// Supported minimum versions
+const (
+    Version12 = "1.2"
+    Version13 = "1.3"
+)
+
+// Config is the "tls" section of a client
+type Config struct {
+    // Lowest protocol version accepted, 1.2 by default
+    MinVersion string `json:"minVersion,omitempty"`
+
+    // CA bundle verifying the server instead of the system pool
+    CAFile string `json:"caFile,omitempty"`
+
+    // Client certificate and key presented for mTLS
+    CertFile string `json:"certFile,omitempty"`
+    KeyFile  string `json:"keyFile,omitempty"`
+
+    // Name verified in the server certificate, the dialled host by default
+    ServerName string `json:"serverName,omitempty"`
+
+    // Base64 SHA-256 digests of a subject public key the server's chain
+    // must contain, as printed by
+    // "openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64"
+    PinnedSHA256 []string `json:"pinnedSHA256,omitempty"`
+}
+
+// ClientConfig returns the tls.Config for c, which may be nil. insecure
+// skips chain verification for backends that offer the legacy option; pins
+// are still enforced then.
+func (c *Config) ClientConfig(insecure bool) (*tls.Config, error) {
+    if c == nil {
+        c = &Config{}
+    }
+    cfg := &tls.Config{
+        MinVersion:         tls.VersionTLS12,
+        ServerName:         c.ServerName,
+        InsecureSkipVerify: insecure,
+    }
+    
+    switch c.MinVersion {
+    case "", Version12:
+    case Version13:
+        if FIPS {
+            return nil, fmt.Errorf("tls minVersion %s is not available in FIPS mode", Version13)
+        }
+        cfg.MinVersion = tls.VersionTLS13
+    default:
+        return nil, fmt.Errorf("invalid tls minVersion %q (must be %q or %q)", c.MinVersion, Version12, Version13)
+    }
+    
+    if c.CAFile != "" {
+        pem, err := ioutil.ReadFile(c.CAFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read tls caFile: %v", err)
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("tls caFile %s has no certificates", c.CAFile)
+        }
+        cfg.RootCAs = pool
+    }
+    
+    if (c.CertFile == "") != (c.KeyFile == "") {
+        return nil, fmt.Errorf("tls certFile and keyFile must be set together")
+    }
+    if c.CertFile != "" {
+        cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to load tls client certificate: %v", err)
+        }
+        cfg.Certificates = []tls.Certificate{cert}
+    }
+    
+    if len(c.PinnedSHA256) > 0 {
+        pins := make(map[string]bool, len(c.PinnedSHA256))
+        for _, pin := range c.PinnedSHA256 {
+            digest, err := base64.StdEncoding.DecodeString(pin)
+            if err != nil || len(digest) != sha256.Size {
+                return nil, fmt.Errorf("invalid tls pin %q: must be a base64 SHA-256 digest", pin)
+            }
+            pins[string(digest)] = true
+        }
+        cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+            return checkPins(cs, pins)
+        }
+    }
+    
+    return cfg, nil
+}
+
+// checkPins accepts the connection when a certificate of the server's
+// verified chain, or of the presented one when verification is skipped,
+// carries a pinned key
+func checkPins(cs tls.ConnectionState, pins map[string]bool) error {
+    certs := cs.PeerCertificates
+    if len(cs.VerifiedChains) > 0 {
+        certs = nil
+        for _, chain := range cs.VerifiedChains {
+            certs = append(certs, chain...)
+        }
+    }
+    for _, cert := range certs {
+        digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+        if pins[string(digest[:])] {
+            return nil
+        }
+    }
+    return fmt.Errorf("server certificate for %q matches no pinned key", cs.ServerName)
+}
@@ -0,0 +1,131 @@
+package types
+
+import (
+    "encoding/json"
+    "fmt"
+    "net"
+    "time"
+)
+
+// IPAMConfig is the "ipam" section of the network configuration. Type picks
+// the IPAM plugin, which receives the section unchanged; Routes may carry
+// extensions the plugin ignores but the VLAN plugin applies.
+type IPAMConfig struct {
+    Type   string   `json:"type"`
+    Routes []*Route `json:"routes,omitempty"`
+
+    // Budget for each call to the backend, defaults to
+    // DefaultIPAMTimeoutSeconds. In-process backends also use it for their
+    // requests.
+    TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+    // Fail fast while the backend keeps failing
+    CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+
+    raw json.RawMessage
+}
+
+// DefaultIPAMTimeoutSeconds bounds IPAM calls when no timeout is configured
+const DefaultIPAMTimeoutSeconds = 30
+
+// CircuitBreakerConfig opens the breaker after FailureThreshold consecutive
+// failed calls; while open, calls fail immediately with "try again later"
+// until CooldownSeconds have passed and a trial call is let through
+type CircuitBreakerConfig struct {
+    // Defaults to 3, a negative value disables the breaker
+    FailureThreshold int `json:"failureThreshold,omitempty"`
+
+    // Defaults to 30
+    CooldownSeconds int `json:"cooldownSeconds,omitempty"`
+}
+
+// Empty reports whether the section is {}, which asks for no IPAM at all
+func (c *IPAMConfig) Empty() bool {
+    var doc map[string]interface{}
+    return json.Unmarshal(c.raw, &doc) == nil && len(doc) == 0
+}
+
+// ipamConfigFields avoids recursing into the custom (un)marshalers
+type ipamConfigFields struct {
+    Type           string                `json:"type"`
+    Routes         []*Route              `json:"routes,omitempty"`
+    TimeoutSeconds int                   `json:"timeoutSeconds,omitempty"`
+    CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+}
+
+// UnmarshalJSON decodes the known fields and keeps the original section
+func (c *IPAMConfig) UnmarshalJSON(b []byte) error {
+    var f ipamConfigFields
+    if err := json.Unmarshal(b, &f); err != nil {
+        return err
+    }
+    c.Type = f.Type
+    c.Routes = f.Routes
+    c.TimeoutSeconds = f.TimeoutSeconds
+    c.CircuitBreaker = f.CircuitBreaker
+    c.raw = append(json.RawMessage{}, b...)
+    return nil
+}
+
+// Raw returns the section exactly as supplied, for backends that decode
+// their own settings
+func (c *IPAMConfig) Raw() []byte {
+    return c.raw
+}
+
+// MarshalJSON returns the section as it was supplied
+func (c IPAMConfig) MarshalJSON() ([]byte, error) {
+    if c.raw != nil {
+        return c.raw, nil
+    }
+    return json.Marshal(ipamConfigFields{Type: c.Type, Routes: c.Routes, TimeoutSeconds: c.TimeoutSeconds, CircuitBreaker: c.CircuitBreaker})
+}
+
+// Timeout returns the budget for one IPAM call
+func (c *IPAMConfig) Timeout() time.Duration {
+    if c.TimeoutSeconds > 0 {
+        return time.Duration(c.TimeoutSeconds) * time.Second
+    }
+    return DefaultIPAMTimeoutSeconds * time.Second
+}
+
+// Route is a route installed in the pod. Beyond the standard dst/gw it can
+// carry a metric, a routing table and several weighted next hops for ECMP.
+type Route struct {
+    Dst      string    `json:"dst"`
+    GW       string    `json:"gw,omitempty"`
+    Metric   int       `json:"metric,omitempty"`
+    Table    int       `json:"table,omitempty"`
+    NextHops []NextHop `json:"nexthops,omitempty"`
+}
+
+// NextHop is one leg of a multipath route
+type NextHop struct {
+    GW     string `json:"gw"`
+    Weight int    `json:"weight,omitempty"`
+}
+
+// Validate checks addresses and option combinations
+func (r *Route) Validate() error {
+    if _, _, err := net.ParseCIDR(r.Dst); err != nil {
+        return fmt.Errorf("invalid route destination %q: %v", r.Dst, err)
+    }
+    if r.GW != "" && net.ParseIP(r.GW) == nil {
+        return fmt.Errorf("invalid gateway %q for route %s", r.GW, r.Dst)
+    }
+    if r.GW != "" && len(r.NextHops) > 0 {
+        return fmt.Errorf("route %s sets both gw and nexthops", r.Dst)
+    }
+    if r.Metric < 0 || r.Table < 0 {
+        return fmt.Errorf("route %s has a negative metric or table", r.Dst)
+    }
+    for _, nh := range r.NextHops {
+        if net.ParseIP(nh.GW) == nil {
+            return fmt.Errorf("invalid next hop %q for route %s", nh.GW, r.Dst)
+        }
+        if nh.Weight < 0 || nh.Weight > 256 {
+            return fmt.Errorf("next hop %s weight %d out of range (1-256)", nh.GW, nh.Weight)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,109 @@
+package v1
+
+import "time"
+
+// Attachment event types
+const (
+    EventAttached    = "Attached"
+    EventDetached    = "Detached"
+    EventCheckFailed = "CheckFailed"
+    EventRepaired    = "Repaired"
+)
+
+// Event is a change in the lifecycle of an attachment on a node, streamed
+// by /v1/events
+type Event struct {
+    TypeMeta
+    Type         string    `json:"type"`
+    Time         time.Time `json:"time"`
+    Node         string    `json:"node"`
+    ContainerID  string    `json:"containerID"`
+    IfName       string    `json:"ifName"`
+    Network      string    `json:"network"`
+    Master       string    `json:"master"`
+    VlanID       int       `json:"vlan"`
+    PodNamespace string    `json:"podNamespace,omitempty"`
+    PodName      string    `json:"podName,omitempty"`
+    IPs          []string  `json:"ips,omitempty"`
+
+    // Why CHECK failed, for CheckFailed
+    Error string `json:"error,omitempty"`
+}
+
+// Migration is the answer of /v1/migrate
+type Migration struct {
+    TypeMeta
+    Network string    `json:"network"`
+    IfName  string    `json:"ifName"`
+    To      string    `json:"to"`
+    IPs     []string  `json:"ips"`
+    Until   time.Time `json:"until"`
+}
+
+// RolloutReport lists the pods attached with configurations their
+// networks no longer have, the answer of /v1/rollout
+type RolloutReport struct {
+    TypeMeta
+    Attachments int `json:"attachments"`
+
+    // Attachments made before configurations were hashed
+    Unversioned int `json:"unversioned"`
+
+    Outdated []*OutdatedAttachment `json:"outdated"`
+}
+
+// OutdatedAttachment is an attachment whose configuration hash matches no
+// current definition of its network
+type OutdatedAttachment struct {
+    Namespace  string   `json:"namespace"`
+    Pod        string   `json:"pod"`
+    Network    string   `json:"network"`
+    IfName     string   `json:"ifName"`
+    ConfigHash string   `json:"configHash"`
+    Current    []string `json:"currentHashes"`
+
+    // Set by POST, which evicts the pod for its controller to recreate
+    Evicted bool   `json:"evicted,omitempty"`
+    Error   string `json:"error,omitempty"`
+}
+
+// CaptureResult answers /v1/capture for captures written to a file
+type CaptureResult struct {
+    TypeMeta
+    Path    string `json:"path"`
+    Packets int    `json:"packets"`
+    Bytes   int    `json:"bytes"`
+}
+
+// Link is a host link as the daemon's link cache last read it, the answer
+// of /v1/links
+type Link struct {
+    TypeMeta
+    Name         string `json:"name"`
+    Index        int    `json:"index"`
+    Type         string `json:"type"`
+    MTU          int    `json:"mtu"`
+    HardwareAddr string `json:"hardwareAddr,omitempty"`
+    Up           bool   `json:"up"`
+    OperState    string `json:"operState"`
+    MasterIndex  int    `json:"masterIndex,omitempty"`
+
+    // When the cache read the link from the kernel
+    Cached time.Time `json:"cached"`
+}
+
+// FeatureGateList is the state of the node's feature gates, the answer of
+// /v1/features
+type FeatureGateList struct {
+    TypeMeta
+    Gates []*FeatureGate `json:"gates"`
+}
+
+// FeatureGate is a subsystem of the daemon gated by maturity
+type FeatureGate struct {
+    Name    string `json:"name"`
+    Stage   string `json:"stage"`
+    Enabled bool   `json:"enabled"`
+
+    Description string `json:"description,omitempty"`
+}
@@ -0,0 +1,61 @@
+package v1
+
+import "time"
+
+// Identity is the workload a record was created for, so whatever the
+// plugin set up on the host can be attributed to a pod
+type Identity struct {
+    PodName        string `json:"podName,omitempty"`
+    PodNamespace   string `json:"podNamespace,omitempty"`
+    PodUID         string `json:"podUID,omitempty"`
+    ServiceAccount string `json:"serviceAccount,omitempty"`
+}
+
+// Attachment records a pod interface set up by the plugin, so the daemon and
+// later invocations can find it
+type Attachment struct {
+    TypeMeta
+    ContainerID  string    `json:"containerID"`
+    IfName       string    `json:"ifName"`
+    PodIfName    string    `json:"podIfName,omitempty"`
+    Network      string    `json:"network"`
+    Master       string    `json:"master"`
+    VlanID       int       `json:"vlan"`
+    HostIfName   string    `json:"hostIfName"`
+    Netns        string    `json:"netns"`
+    Identity
+    IPs          []string  `json:"ips,omitempty"`
+    TrafficClass string    `json:"trafficClass,omitempty"`
+    Created      time.Time `json:"created"`
+
+    // The daemon sends keepalives from the pod until then
+    KeepaliveUntil *time.Time `json:"keepaliveUntil,omitempty"`
+
+    // IPv6 prefixes routed to the pod as a whole
+    DelegatedPrefixes []string `json:"delegatedPrefixes,omitempty"`
+
+    // Virtual gateway whose active router the daemon tracks
+    Gateway *GatewayRef `json:"gateway,omitempty"`
+
+    // Hash of the network configuration that made the attachment, see
+    // config.Hash
+    ConfigHash string `json:"configHash,omitempty"`
+
+    // Why the attachment's last CHECK failed, empty once one passes
+    CheckError string `json:"checkError,omitempty"`
+}
+
+// PodInterface is the name of the interface in the pod, which
+// containerIfNameTemplate may have made other than the runtime's ifName
+func (a *Attachment) PodInterface() string {
+    if a.PodIfName != "" {
+        return a.PodIfName
+    }
+    return a.IfName
+}
+
+// GatewayRef is the VRRP virtual router of an attachment's network
+type GatewayRef struct {
+    Address string `json:"address"`
+    VRID    int    `json:"vrid"`
+}
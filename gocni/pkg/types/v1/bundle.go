@@ -0,0 +1,13 @@
+package v1
+
+import "time"
+
+// BundleManifest is manifest.json of a support bundle, listing what it
+// holds and the sources that could not be collected
+type BundleManifest struct {
+    TypeMeta
+    Created  time.Time `json:"created"`
+    Hostname string    `json:"hostname"`
+    Files    []string  `json:"files"`
+    Errors   []string  `json:"errors,omitempty"`
+}
@@ -0,0 +1,33 @@
+package v1
+
+import "time"
+
+// Sources of exported allocations
+const (
+    SourceVlanCNI     = "vlan-cni"
+    SourceHostLocal   = "host-local"
+    SourceWhereabouts = "whereabouts"
+)
+
+// AllocationList is the addresses of a network as exported by vlan-cni
+// ipam export, for backups, node replacement and migrations from other
+// IPAM plugins
+type AllocationList struct {
+    TypeMeta
+    Network     string        `json:"network"`
+    Source      string        `json:"source"`
+    Exported    time.Time     `json:"exported"`
+    Allocations []*Allocation `json:"allocations"`
+}
+
+// Allocation is an address and who holds it. Addresses reserved for a
+// pod name have no container.
+type Allocation struct {
+    IP          string     `json:"ip"`
+    ContainerID string     `json:"containerID,omitempty"`
+    IfName      string     `json:"ifName,omitempty"`
+    Pod         string     `json:"pod,omitempty"`
+    Node        string     `json:"node,omitempty"`
+    Sticky      bool       `json:"sticky,omitempty"`
+    Allocated   *time.Time `json:"allocated,omitempty"`
+}
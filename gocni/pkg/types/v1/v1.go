@@ -0,0 +1,69 @@
+// Package v1 holds the versioned types vlan-cni persists and serves: the
+// attachment records of the state store, the payloads of the daemon API,
+// the manifest of support bundles and exported IPAM allocations. Documents
+// carry their apiVersion and kind, so readers refuse versions they do not
+// know rather than misread them. Fields may be added to v1; renaming or removing one takes a v2.
+package v1
+
+import (
+    "embed"
+    "fmt"
+    "strings"
+)
+
+// Version is the apiVersion of the documents of this package
+const Version = "vlan-cni.io/v1"
+
+// Kinds of the versioned documents
+const (
+    KindAttachment      = "Attachment"
+    KindEvent           = "Event"
+    KindMigration       = "Migration"
+    KindRolloutReport   = "RolloutReport"
+    KindCaptureResult   = "CaptureResult"
+    KindBundleManifest  = "BundleManifest"
+    KindLink            = "Link"
+    KindAllocationList  = "AllocationList"
+    KindFeatureGateList = "FeatureGateList"
+)
+
+// Kinds lists every kind, each with a schema
+var Kinds = []string{KindAttachment, KindEvent, KindMigration, KindRolloutReport, KindCaptureResult, KindBundleManifest, KindLink, KindAllocationList, KindFeatureGateList}
+
+// TypeMeta names the version and kind of a document
+type TypeMeta struct {
+    APIVersion string `json:"apiVersion,omitempty"`
+    Kind       string `json:"kind,omitempty"`
+}
+
+// Meta returns the TypeMeta of a v1 document of kind
+func Meta(kind string) TypeMeta {
+    return TypeMeta{APIVersion: Version, Kind: kind}
+}
+
+// Check accepts v1 documents of kind, and unversioned ones written before
+// documents were versioned
+func (t TypeMeta) Check(kind string) error {
+    if t.APIVersion == "" && t.Kind == "" {
+        return nil
+    }
+    if t.APIVersion != Version {
+        return fmt.Errorf("unsupported apiVersion %q of %s, want %s", t.APIVersion, kind, Version)
+    }
+    if t.Kind != kind {
+        return fmt.Errorf("document is a %s, want %s", t.Kind, kind)
+    }
+    return nil
+}
+
+//go:embed schemas/*.json
+var schemas embed.FS
+
+// Schema returns the JSON schema of a kind's documents
+func Schema(kind string) ([]byte, error) {
+    data, err := schemas.ReadFile("schemas/" + strings.ToLower(kind) + ".json")
+    if err != nil {
+        return nil, fmt.Errorf("no schema for kind %q", kind)
+    }
+    return data, nil
+}
@@ -0,0 +1,282 @@
+package v1
+
+import (
+    "encoding/json"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+)
+
+var created = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// samples has a document of every kind with all fields set
+func samples() map[string]interface{} {
+    until := created.Add(time.Hour)
+    return map[string]interface{}{
+        KindAttachment: &Attachment{
+            TypeMeta:    Meta(KindAttachment),
+            ContainerID: "0123abcd",
+            IfName:      "net1",
+            PodIfName:   "vlan100",
+            Network:     "vlan100",
+            Master:      "eth0",
+            VlanID:      100,
+            HostIfName:  "vl100-0123abcd",
+            Netns:       "/run/netns/cni-1234",
+            Identity: Identity{
+                PodName:        "web-0",
+                PodNamespace:   "default",
+                PodUID:         "5f1c",
+                ServiceAccount: "web",
+            },
+            IPs:               []string{"10.0.0.5/24", "2001:db8::5/64"},
+            TrafficClass:      "gold",
+            Created:           created,
+            KeepaliveUntil:    &until,
+            DelegatedPrefixes: []string{"2001:db8:1::/64"},
+            Gateway:           &GatewayRef{Address: "10.0.0.1", VRID: 7},
+            ConfigHash:        "sha256:abc",
+            CheckError:        "address missing",
+        },
+        KindEvent: &Event{
+            TypeMeta:     Meta(KindEvent),
+            Type:         EventCheckFailed,
+            Time:         created,
+            Node:         "node-1",
+            ContainerID:  "0123abcd",
+            IfName:       "net1",
+            Network:      "vlan100",
+            Master:       "eth0",
+            VlanID:       100,
+            PodNamespace: "default",
+            PodName:      "web-0",
+            IPs:          []string{"10.0.0.5/24"},
+            Error:        "address missing",
+        },
+        KindMigration: &Migration{
+            TypeMeta: Meta(KindMigration),
+            Network:  "vlan100",
+            IfName:   "net1",
+            To:       "default/web-1",
+            IPs:      []string{"10.0.0.5/24"},
+            Until:    until,
+        },
+        KindRolloutReport: &RolloutReport{
+            TypeMeta:    Meta(KindRolloutReport),
+            Attachments: 3,
+            Unversioned: 1,
+            Outdated: []*OutdatedAttachment{{
+                Namespace:  "default",
+                Pod:        "web-0",
+                Network:    "vlan100",
+                IfName:     "net1",
+                ConfigHash: "sha256:abc",
+                Current:    []string{"sha256:def"},
+                Evicted:    true,
+                Error:      "blocked by PodDisruptionBudget",
+            }},
+        },
+        KindCaptureResult: &CaptureResult{
+            TypeMeta: Meta(KindCaptureResult),
+            Path:     "/var/lib/vlan-cni/captures/web-0.pcap",
+            Packets:  12,
+            Bytes:    3456,
+        },
+        KindBundleManifest: &BundleManifest{
+            TypeMeta: Meta(KindBundleManifest),
+            Created:  created,
+            Hostname: "node-1",
+            Files:    []string{"state/attachments/0123abcd-net1.json", "netlink/links.json"},
+            Errors:   []string{"nft list ruleset: executable file not found"},
+        },
+        KindLink: &Link{
+            TypeMeta:     Meta(KindLink),
+            Name:         "eth0",
+            Index:        2,
+            Type:         "device",
+            MTU:          9000,
+            HardwareAddr: "52:54:00:12:34:56",
+            Up:           true,
+            OperState:    "up",
+            MasterIndex:  5,
+            Cached:       created,
+        },
+        KindAllocationList: &AllocationList{
+            TypeMeta: Meta(KindAllocationList),
+            Network:  "vlan100",
+            Source:   SourceHostLocal,
+            Exported: created,
+            Allocations: []*Allocation{{
+                IP:          "10.0.0.5",
+                ContainerID: "0123abcd",
+                IfName:      "net1",
+                Pod:         "default/web-0",
+                Node:        "node-1",
+                Sticky:      true,
+                Allocated:   &created,
+            }},
+        },
+        KindFeatureGateList: &FeatureGateList{
+            TypeMeta: Meta(KindFeatureGateList),
+            Gates: []*FeatureGate{{
+                Name:        "LinkCache",
+                Stage:       "alpha",
+                Enabled:     true,
+                Description: "master lookups served from the daemon's netlink cache",
+            }},
+        },
+    }
+}
+
+// TestRoundTrip checks that every field of every kind survives encoding
+func TestRoundTrip(t *testing.T) {
+    for kind, want := range samples() {
+        data, err := json.Marshal(want)
+        if err != nil {
+            t.Fatalf("%s: %v", kind, err)
+        }
+        got := reflect.New(reflect.TypeOf(want).Elem()).Interface()
+        if err := json.Unmarshal(data, got); err != nil {
+            t.Fatalf("%s: %v", kind, err)
+        }
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: round trip of %s gave %+v, want %+v", kind, data, got, want)
+        }
+    }
+}
+
+// TestSchemas checks that the schemas describe the Go types: the same
+// properties, and the fields without omitempty as required
+func TestSchemas(t *testing.T) {
+    all := samples()
+    for _, kind := range Kinds {
+        data, err := Schema(kind)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var schema map[string]interface{}
+        if err := json.Unmarshal(data, &schema); err != nil {
+            t.Fatalf("%s: invalid schema: %v", kind, err)
+        }
+        if schema["title"] != kind {
+            t.Errorf("%s: schema is titled %v", kind, schema["title"])
+        }
+        sample, ok := all[kind]
+        if !ok {
+            t.Fatalf("%s: no sample", kind)
+        }
+        compareSchema(t, kind, reflect.TypeOf(sample), schema)
+    }
+}
+
+func compareSchema(t *testing.T, path string, typ reflect.Type, schema map[string]interface{}) {
+    for typ.Kind() == reflect.Ptr {
+        typ = typ.Elem()
+    }
+    switch {
+    case typ == reflect.TypeOf(time.Time{}):
+        if schema["format"] != "date-time" {
+            t.Errorf("%s: want a date-time", path)
+        }
+    case typ.Kind() == reflect.Slice:
+        items, _ := schema["items"].(map[string]interface{})
+        if items == nil {
+            t.Errorf("%s: array without items", path)
+            return
+        }
+        compareSchema(t, path+"[]", typ.Elem(), items)
+    case typ.Kind() == reflect.Struct:
+        props, _ := schema["properties"].(map[string]interface{})
+        fields, required := jsonFields(typ)
+        var names []string
+        for name := range fields {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        var schemaNames []string
+        for name := range props {
+            schemaNames = append(schemaNames, name)
+        }
+        sort.Strings(schemaNames)
+        if !reflect.DeepEqual(names, schemaNames) {
+            t.Errorf("%s: schema has properties %v, type has %v", path, schemaNames, names)
+        }
+        var schemaRequired []string
+        for _, r := range schema["required"].([]interface{}) {
+            schemaRequired = append(schemaRequired, r.(string))
+        }
+        sort.Strings(schemaRequired)
+        if !reflect.DeepEqual(required, schemaRequired) {
+            t.Errorf("%s: schema requires %v, type %v", path, schemaRequired, required)
+        }
+        for name, field := range fields {
+            if prop, ok := props[name].(map[string]interface{}); ok {
+                compareSchema(t, path+"."+name, field, prop)
+            }
+        }
+    }
+}
+
+// jsonFields returns the encoded fields of a struct, embedded ones
+// inlined, and the sorted names of those without omitempty
+func jsonFields(typ reflect.Type) (map[string]reflect.Type, []string) {
+    fields := map[string]reflect.Type{}
+    var required []string
+    for i := 0; i < typ.NumField(); i++ {
+        f := typ.Field(i)
+        tag := f.Tag.Get("json")
+        if f.Anonymous && tag == "" {
+            embedded, req := jsonFields(f.Type)
+            for name, t := range embedded {
+                fields[name] = t
+            }
+            required = append(required, req...)
+            continue
+        }
+        if f.PkgPath != "" || tag == "-" {
+            continue
+        }
+        parts := strings.Split(tag, ",")
+        fields[parts[0]] = f.Type
+        if len(parts) == 1 || parts[1] != "omitempty" {
+            required = append(required, parts[0])
+        }
+    }
+    sort.Strings(required)
+    return fields, required
+}
+
+func TestCheck(t *testing.T) {
+    for _, c := range []struct {
+        meta TypeMeta
+        ok   bool
+    }{
+        {TypeMeta{}, true},
+        {Meta(KindAttachment), true},
+        {TypeMeta{APIVersion: "vlan-cni.io/v2", Kind: KindAttachment}, false},
+        {Meta(KindEvent), false},
+    } {
+        if err := c.meta.Check(KindAttachment); (err == nil) != c.ok {
+            t.Errorf("Check(%+v) = %v, want ok %v", c.meta, err, c.ok)
+        }
+    }
+}
+
+// TestLegacyAttachment checks that records from before versioning still
+// decode into the v1 type
+func TestLegacyAttachment(t *testing.T) {
+    data := []byte(`{"containerID":"0123abcd","ifName":"net1","network":"vlan100","master":"eth0","vlan":100,` +
+        `"hostIfName":"vl100-0123abcd","netns":"/run/netns/cni-1234","podName":"web-0","created":"2024-03-01T12:00:00Z"}`)
+    var a Attachment
+    if err := json.Unmarshal(data, &a); err != nil {
+        t.Fatal(err)
+    }
+    if err := a.Check(KindAttachment); err != nil {
+        t.Error(err)
+    }
+    if a.PodName != "web-0" || a.VlanID != 100 || !a.Created.Equal(created) {
+        t.Errorf("decoded %+v", a)
+    }
+}
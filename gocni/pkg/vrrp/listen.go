@@ -0,0 +1,124 @@
+// Package vrrp watches the VRRP advertisements (RFC 3768, RFC 5798) of a
+// virtual router. Only its master advertises, from its own address, which
+// tells which physical router currently holds the virtual address.
+package vrrp
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "syscall"
+    "time"
+)
+
+const protoVRRP = 112
+
+var (
+    groupV4 = net.IPv4(224, 0, 0, 18)
+    groupV6 = net.ParseIP("ff02::12")
+)
+
+// pollInterval bounds how long Listen overruns ctx
+const pollInterval = 200 * time.Millisecond
+
+// Advert is an advertisement of the virtual router's master
+type Advert struct {
+    Router   net.IP
+    Version  int
+    Priority int
+
+    // Addresses the virtual router holds
+    Addresses []net.IP
+}
+
+// Listen waits on the named interface for an advertisement of virtual
+// router vrid, of the family of vip
+func Listen(ctx context.Context, ifName string, vrid int, vip net.IP) (*Advert, error) {
+    iface, err := net.InterfaceByName(ifName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to lookup interface %q: %v", ifName, err)
+    }
+    
+    v4 := vip.To4() != nil
+    family := syscall.AF_INET
+    if !v4 {
+        family = syscall.AF_INET6
+    }
+    fd, err := syscall.Socket(family, syscall.SOCK_RAW, protoVRRP)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open VRRP socket: %v", err)
+    }
+    defer syscall.Close(fd)
+    
+    if err := syscall.BindToDevice(fd, ifName); err != nil {
+        return nil, fmt.Errorf("failed to bind VRRP socket to %q: %v", ifName, err)
+    }
+    if v4 {
+        mreq := &syscall.IPMreqn{Ifindex: int32(iface.Index)}
+        copy(mreq.Multiaddr[:], groupV4.To4())
+        err = syscall.SetsockoptIPMreqn(fd, syscall.IPPROTO_IP, syscall.IP_ADD_MEMBERSHIP, mreq)
+    } else {
+        mreq := &syscall.IPv6Mreq{Interface: uint32(iface.Index)}
+        copy(mreq.Multiaddr[:], groupV6)
+        err = syscall.SetsockoptIPv6Mreq(fd, syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, mreq)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to join the VRRP group on %q: %v", ifName, err)
+    }
+    tv := syscall.NsecToTimeval(pollInterval.Nanoseconds())
+    if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+        return nil, fmt.Errorf("failed to set receive timeout: %v", err)
+    }
+    
+    buf := make([]byte, 1500)
+    for {
+        if err := ctx.Err(); err != nil {
+            return nil, fmt.Errorf("no advertisement of VRRP router %d on %q: %v", vrid, ifName, err)
+        }
+        n, from, err := syscall.Recvfrom(fd, buf, 0)
+        if err != nil {
+            continue
+        }
+        var router net.IP
+        packet := buf[:n]
+        switch sa := from.(type) {
+        case *syscall.SockaddrInet4:
+            router = net.IP(append([]byte{}, sa.Addr[:]...))
+            // IPv4 raw sockets deliver the IP header too
+            if len(packet) > 0 {
+                ihl := int(packet[0]&0x0f) * 4
+                if len(packet) < ihl {
+                    continue
+                }
+                packet = packet[ihl:]
+            }
+        case *syscall.SockaddrInet6:
+            router = net.IP(append([]byte{}, sa.Addr[:]...))
+        }
+        advert, ok := parse(packet, vrid, v4)
+        if !ok {
+            continue
+        }
+        advert.Router = router
+        return advert, nil
+    }
+}
+
+// parse decodes an advertisement of vrid
+func parse(b []byte, vrid int, v4 bool) (*Advert, bool) {
+    if len(b) < 8 || b[0]&0x0f != 1 || int(b[1]) != vrid {
+        return nil, false
+    }
+    a := &Advert{Version: int(b[0] >> 4), Priority: int(b[2])}
+    count := int(b[3])
+    addrLen := net.IPv4len
+    if !v4 {
+        addrLen = net.IPv6len
+    }
+    addrs := b[8:]
+    for i := 0; i < count && len(addrs) >= addrLen; i++ {
+        a.Addresses = append(a.Addresses, net.IP(append([]byte{}, addrs[:addrLen]...)))
+        addrs = addrs[addrLen:]
+    }
+    return a, a.Version == 2 || a.Version == 3
+}
@@ -10,6 +10,13 @@ CNI_CONF_DIR=${CNI_CONF_DIR:-"/etc/cni/net.d"}
 VLAN_CNI_CONFIG_DIR=${VLAN_CNI_CONFIG_DIR:-"/etc/vlan-cni/config"}
 VLAN_CNI_RUN_DIR=${VLAN_CNI_RUN_DIR:-"/var/run/vlan-cni"}
 LOG_FILE=${LOG_FILE:-"/var/log/vlan-cni-install.log"}
+# CNI versions the container runtime understands, detected when unset
+RUNTIME_CNI_VERSIONS=${RUNTIME_CNI_VERSIONS:-""}
+# Label selector of ConfigMaps with per-network overrides, off when empty
+OVERRIDES_SELECTOR=${OVERRIDES_SELECTOR:-""}
+OVERRIDES_NAMESPACE=${OVERRIDES_NAMESPACE:-"kube-system"}
+# Also check the cluster's NetworkAttachmentDefinitions for duplicate VLANs
+CHECK_NADS=${CHECK_NADS:-"false"}
 
 # Ensure we're running as root
 if [[ $EUID -ne 0 ]]; then
@@ -31,6 +38,9 @@ log "Starting VLAN CNI plugin installation"
 log "Creating required directories"
 mkdir -p $CNI_BIN_DIR $CNI_CONF_DIR $VLAN_CNI_CONFIG_DIR $VLAN_CNI_RUN_DIR
 
+# The daemon marks the node ready again once the new configuration checks out
+rm -f $VLAN_CNI_RUN_DIR/ready
+
 # Copy binary to CNI bin directory if running outside container
 if [[ -f ./bin/vlan-cni ]]; then
     log "Copying binary from local build"
@@ -53,14 +63,18 @@ fi
 # Configure CNI
 log "Configuring VLAN CNI plugin"
 
-# If running in Kubernetes with ConfigMap
-if [[ -f $VLAN_CNI_CONFIG_DIR/vlan-cni.conf ]]; then
-    log "Using configuration from ConfigMap"
-    cp $VLAN_CNI_CONFIG_DIR/vlan-cni.conf $CNI_CONF_DIR/10-vlan.conflist
-# Otherwise, create a default configuration
-else
-    log "Creating default VLAN CNI configuration"
-    cat > $CNI_CONF_DIR/10-vlan.conflist <<EOF
+# Staged where the runtime does not look for configuration
+STAGED_CONF=$CNI_CONF_DIR/.10-vlan.staged
+
+stage_base_config() {
+    # If running in Kubernetes with ConfigMap
+    if [[ -f $VLAN_CNI_CONFIG_DIR/vlan-cni.conf ]]; then
+        note "Using configuration from ConfigMap"
+        cp $VLAN_CNI_CONFIG_DIR/vlan-cni.conf $STAGED_CONF
+    # Otherwise, create a default configuration
+    else
+        note "Creating default VLAN CNI configuration"
+        cat > $STAGED_CONF <<EOF
 {
   "cniVersion": "0.3.1",
   "name": "vlan-network",
@@ -68,12 +82,111 @@ else
     {
       "type": "vlan-cni",
       "master": "eth0",
+      "vlan": 0,
       "mappings": []
     }
   ]
 }
 EOF
-fi
+    fi
+}
+
+# Merge the overrides other teams contribute through labeled ConfigMaps.
+# Conflicting or invalid overrides keep the installed configuration.
+merge_overrides() {
+    [[ -n "$OVERRIDES_SELECTOR" ]] || return 0
+    if ! command -v vlan-cni-conf &>/dev/null; then
+        note "WARNING: vlan-cni-conf not found, skipping network overrides"
+        return 0
+    fi
+    if ! MERGE=$(vlan-cni-conf merge -namespace $OVERRIDES_NAMESPACE -selector "$OVERRIDES_SELECTOR" $STAGED_CONF 2>&1); then
+        log "ERROR: network overrides rejected: $MERGE"
+        return 1
+    fi
+    note "$MERGE"
+}
+
+# Runtimes on libcni 1.2, such as containerd 2.0 and later, also understand
+# CNI 1.1.0. An older containerd on the node drops it; without a runtime to
+# ask, keep it, since negotiation only picks versions every plugin supports.
+detect_runtime_cni_versions() {
+    [[ -z "$RUNTIME_CNI_VERSIONS" ]] || return 0
+    RUNTIME_CNI_VERSIONS="0.3.0,0.3.1,0.4.0,1.0.0,1.1.0"
+    local version=""
+    if command -v containerd &>/dev/null; then
+        version=$(containerd --version 2>/dev/null | grep -o ' v\?[0-9][0-9.]*' | head -1 | tr -d ' v')
+    elif command -v k3s &>/dev/null; then
+        version=$(k3s ctr version 2>/dev/null | grep -o 'Version: *v\?[0-9][0-9.]*' | head -1 | sed 's/Version: *v\?//')
+    fi
+    if [[ -n "$version" && ${version%%.*} -lt 2 ]]; then
+        RUNTIME_CNI_VERSIONS="0.3.0,0.3.1,0.4.0,1.0.0"
+    fi
+    note "Runtime CNI versions: $RUNTIME_CNI_VERSIONS${version:+ (containerd $version)}"
+}
+
+# Negotiate cniVersion with the runtime and the listed plugins
+upgrade_cni_version() {
+    detect_runtime_cni_versions
+    if command -v vlan-cni-conf &>/dev/null; then
+        if ! NEGOTIATION=$(vlan-cni-conf upgrade -bin-dir $CNI_BIN_DIR -runtime-versions "$RUNTIME_CNI_VERSIONS" $STAGED_CONF 2>&1); then
+            note "WARNING: cniVersion negotiation failed, keeping configuration as is"
+        fi
+        note "$NEGOTIATION"
+    else
+        note "WARNING: vlan-cni-conf not found, skipping cniVersion negotiation"
+    fi
+}
+
+# Refuse networks that put the same VLAN of a master under different IPAM,
+# which leaves their interfaces flapping and their addresses conflicting
+check_duplicates() {
+    if ! command -v vlan-cni-conf &>/dev/null; then
+        note "WARNING: vlan-cni-conf not found, skipping duplicate network check"
+        return 0
+    fi
+    local FILES=($STAGED_CONF)
+    for FILE in $CNI_CONF_DIR/*.conf $CNI_CONF_DIR/*.conflist; do
+        [[ -f "$FILE" && "$FILE" != "$CNI_CONF_DIR/10-vlan.conflist" ]] && FILES+=("$FILE")
+    done
+    local FLAGS=()
+    [[ "$CHECK_NADS" == "true" ]] && FLAGS+=(-nads)
+    if ! DUPLICATES=$(vlan-cni-conf check "${FLAGS[@]}" "${FILES[@]}" 2>&1); then
+        log "ERROR: duplicate networks, not rendering: $DUPLICATES"
+        return 1
+    fi
+}
+
+# Rendering notes are logged only when the configuration changes, as the
+# monitoring loop renders it every pass when overrides are enabled
+note() {
+    RENDER_NOTES+=("$1")
+}
+
+# Render the configuration and install it only when it changed, so the
+# runtime never reads a half-rendered file
+render_config() {
+    RENDER_NOTES=()
+    stage_base_config
+    if ! merge_overrides; then
+        rm -f $STAGED_CONF
+        return 1
+    fi
+    upgrade_cni_version
+    if ! check_duplicates; then
+        rm -f $STAGED_CONF
+        return 1
+    fi
+    if cmp -s $STAGED_CONF $CNI_CONF_DIR/10-vlan.conflist; then
+        rm -f $STAGED_CONF
+        return 1
+    fi
+    for NOTE in "${RENDER_NOTES[@]}"; do
+        log "$NOTE"
+    done
+    mv $STAGED_CONF $CNI_CONF_DIR/10-vlan.conflist
+    log "Installed $CNI_CONF_DIR/10-vlan.conflist"
+}
+render_config || true
 
 # Setup host networking (VLAN interfaces on the host)
 setup_host_vlans() {
@@ -172,12 +285,15 @@ if [[ -f /.dockerenv ]]; then
     while true; do
         sleep 30
         
-        # Check if any VLANs need to be reconfigured
+        # Check if any VLANs need to be reconfigured. Overrides change
+        # without touching the file, so with them the chain is re-rendered
+        # on every pass.
         if [[ -f $VLAN_CNI_CONFIG_DIR/vlan-cni.conf ]]; then
-            if [[ $VLAN_CNI_CONFIG_DIR/vlan-cni.conf -nt $CNI_CONF_DIR/10-vlan.conflist ]]; then
-                log "Configuration updated, reconfiguring..."
-                cp $VLAN_CNI_CONFIG_DIR/vlan-cni.conf $CNI_CONF_DIR/10-vlan.conflist
-                setup_host_vlans
+            if [[ -n "$OVERRIDES_SELECTOR" || $VLAN_CNI_CONFIG_DIR/vlan-cni.conf -nt $CNI_CONF_DIR/10-vlan.conflist ]]; then
+                if render_config; then
+                    log "Configuration updated, reconfiguring..."
+                    setup_host_vlans
+                fi
             fi
         fi
     done
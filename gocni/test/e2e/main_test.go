@@ -0,0 +1,190 @@
+//go:build e2e
+
+// Package e2e runs the plugin on a real k3s cluster. It needs kubectl and
+// either a cluster to use (E2E_KUBECONFIG), a hook that provisions one
+// (E2E_SETUP_HOOK, for example around vagrant), or k3d and docker to create
+// a throwaway one. k3d nodes are containers without physical VLANs, so the
+// networks under test use simulation mode.
+//
+//	go test -tags e2e -v -timeout 30m ./test/e2e
+package e2e
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+const (
+    clusterName = "vlan-cni-e2e"
+    image       = "vlan-cni:e2e"
+    namespace   = "vlan-cni-e2e"
+
+    defaultMultusManifest = "https://raw.githubusercontent.com/k8snetworkplumbingwg/multus-cni/v4.0.2/deployments/multus-daemonset-thick.yml"
+
+    // Where k3s keeps CNI plugins and configuration; releases before
+    // v1.28 use /var/lib/rancher/k3s/data/current/bin for plugins
+    defaultCNIBinDir  = "/var/lib/rancher/k3s/data/cni"
+    defaultCNIConfDir = "/var/lib/rancher/k3s/agent/etc/cni/net.d"
+)
+
+// kubeconfig is the cluster the suite runs against
+var kubeconfig string
+
+func TestMain(m *testing.M) {
+    teardown, err := setup()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "e2e setup failed: %v\n", err)
+        if teardown != nil {
+            teardown()
+        }
+        os.Exit(1)
+    }
+    code := m.Run()
+    if os.Getenv("E2E_KEEP") == "" {
+        teardown()
+    }
+    os.Exit(code)
+}
+
+// setup provides a cluster with the plugin installed and returns how to
+// dispose of it
+func setup() (func(), error) {
+    repo, err := filepath.Abs("../..")
+    if err != nil {
+        return nil, err
+    }
+    
+    teardown := func() {}
+    switch {
+    case os.Getenv("E2E_KUBECONFIG") != "":
+        kubeconfig = os.Getenv("E2E_KUBECONFIG")
+    case os.Getenv("E2E_SETUP_HOOK") != "":
+        // The hook writes the kubeconfig of the cluster it provisioned
+        dir, err := ioutil.TempDir("", "vlan-cni-e2e")
+        if err != nil {
+            return nil, err
+        }
+        kubeconfig = filepath.Join(dir, "kubeconfig")
+        if _, err := hook("E2E_SETUP_HOOK"); err != nil {
+            return nil, err
+        }
+        teardown = func() {
+            if os.Getenv("E2E_TEARDOWN_HOOK") != "" {
+                hook("E2E_TEARDOWN_HOOK")
+            }
+            os.RemoveAll(dir)
+        }
+    default:
+        if _, err := run("", "docker", "build", "-t", image, "-f", "Dockerfile.gocni", repo); err != nil {
+            return nil, err
+        }
+        if _, err := run("", "k3d", "cluster", "create", clusterName, "--wait", "--no-lb"); err != nil {
+            return nil, err
+        }
+        teardown = func() {
+            run("", "k3d", "cluster", "delete", clusterName)
+        }
+        if _, err := run("", "k3d", "image", "import", image, "-c", clusterName); err != nil {
+            return teardown, err
+        }
+        out, err := run("", "k3d", "kubeconfig", "write", clusterName)
+        if err != nil {
+            return teardown, err
+        }
+        kubeconfig = strings.TrimSpace(out)
+    }
+    
+    return teardown, install(repo)
+}
+
+// install deploys multus and the plugin, pointed at k3s' CNI directories
+func install(repo string) error {
+    multus := envOr("E2E_MULTUS_MANIFEST", defaultMultusManifest)
+    if _, err := kubectl("apply", "-f", multus); err != nil {
+        return err
+    }
+    
+    deployments := filepath.Join(repo, "..", "deployments")
+    for _, f := range []string{"rbac.yaml", "configmap.yaml"} {
+        if _, err := kubectl("apply", "-f", filepath.Join(deployments, f)); err != nil {
+            return err
+        }
+    }
+    daemonset, err := ioutil.ReadFile(filepath.Join(deployments, "daemonset.yaml"))
+    if err != nil {
+        return err
+    }
+    manifest := strings.NewReplacer(
+        "image: vlan-cni:latest", "image: "+envOr("E2E_IMAGE", image),
+        "path: /opt/cni/bin", "path: "+envOr("E2E_CNI_BIN_DIR", defaultCNIBinDir),
+        "path: /etc/cni/net.d", "path: "+envOr("E2E_CNI_CONF_DIR", defaultCNIConfDir),
+    ).Replace(string(daemonset))
+    if _, err := kubectlStdin(manifest, "apply", "-f", "-"); err != nil {
+        return err
+    }
+    
+    for _, ds := range []string{"kube-multus-ds", "vlan-cni-plugin"} {
+        if _, err := kubectl("-n", "kube-system", "rollout", "status", "daemonset/"+ds, "--timeout=5m"); err != nil {
+            return err
+        }
+    }
+    _, err = kubectlStdin("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: "+namespace+"\n", "apply", "-f", "-")
+    return err
+}
+
+func hook(env string) (string, error) {
+    cmd := exec.Command("sh", "-c", os.Getenv(env))
+    cmd.Env = append(os.Environ(), "E2E_KUBECONFIG_OUT="+kubeconfig)
+    return output(cmd)
+}
+
+func kubectl(args ...string) (string, error) {
+    return run("", "kubectl", append([]string{"--kubeconfig", kubeconfig}, args...)...)
+}
+
+func kubectlStdin(stdin string, args ...string) (string, error) {
+    return run(stdin, "kubectl", append([]string{"--kubeconfig", kubeconfig}, args...)...)
+}
+
+func run(stdin, name string, args ...string) (string, error) {
+    cmd := exec.Command(name, args...)
+    if stdin != "" {
+        cmd.Stdin = strings.NewReader(stdin)
+    }
+    return output(cmd)
+}
+
+func output(cmd *exec.Cmd) (string, error) {
+    var stdout, stderr bytes.Buffer
+    cmd.Stdout, cmd.Stderr = &stdout, &stderr
+    if err := cmd.Run(); err != nil {
+        return stdout.String(), fmt.Errorf("%s: %v: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(stderr.String()))
+    }
+    return stdout.String(), nil
+}
+
+// eventually retries fn until it succeeds or timeout passes
+func eventually(timeout time.Duration, fn func() error) error {
+    deadline := time.Now().Add(timeout)
+    for {
+        err := fn()
+        if err == nil || time.Now().After(deadline) {
+            return err
+        }
+        time.Sleep(2 * time.Second)
+    }
+}
+
+func envOr(key, def string) string {
+    if v := os.Getenv(key); v != "" {
+        return v
+    }
+    return def
+}
@@ -0,0 +1,161 @@
+//go:build e2e
+
+package e2e
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+// nad returns a NetworkAttachmentDefinition for a simulated VLAN using
+// host-local addresses from rangeStart to rangeEnd
+func nad(name string, vlan int, rangeStart, rangeEnd string) string {
+    return fmt.Sprintf(`apiVersion: k8s.cni.cncf.io/v1
+kind: NetworkAttachmentDefinition
+metadata:
+  name: %s
+  namespace: %s
+spec:
+  config: '{
+    "cniVersion": "0.4.0",
+    "name": "%s",
+    "type": "vlan-cni",
+    "master": "eth1",
+    "vlan": %d,
+    "simulation": "auto",
+    "ipam": {
+      "type": "host-local",
+      "ranges": [[{"subnet": "10.%d.0.0/24", "rangeStart": "%s", "rangeEnd": "%s"}]]
+    }
+  }'
+`, name, namespace, name, vlan, vlan, rangeStart, rangeEnd)
+}
+
+// pod returns a pod attached to network. Simulated VLANs are node local,
+// so every pod is pinned to the first node.
+func pod(name, network, node string) string {
+    return fmt.Sprintf(`apiVersion: v1
+kind: Pod
+metadata:
+  name: %s
+  namespace: %s
+  annotations:
+    k8s.v1.cni.cncf.io/networks: %s
+spec:
+  nodeName: %s
+  terminationGracePeriodSeconds: 0
+  containers:
+  - name: main
+    image: busybox:1.36
+    command: ["sleep", "3600"]
+`, name, namespace, network, node)
+}
+
+func firstNode(t *testing.T) string {
+    out, err := kubectl("get", "nodes", "-o", "jsonpath={.items[0].metadata.name}")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return strings.TrimSpace(out)
+}
+
+func apply(t *testing.T, manifest string) {
+    t.Helper()
+    if _, err := kubectlStdin(manifest, "apply", "-f", "-"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func createPod(t *testing.T, name, network, node string) {
+    t.Helper()
+    apply(t, pod(name, network, node))
+    if _, err := kubectl("-n", namespace, "wait", "--for=condition=Ready", "pod/"+name, "--timeout=3m"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func deletePod(t *testing.T, name string) {
+    t.Helper()
+    if _, err := kubectl("-n", namespace, "delete", "pod", name, "--wait=true", "--timeout=2m"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// vlanAddr returns the IPv4 address of the pod's VLAN interface
+func vlanAddr(t *testing.T, name string) string {
+    t.Helper()
+    out, err := kubectl("-n", namespace, "exec", name, "--", "ip", "-4", "-o", "addr", "show", "net1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, field := range strings.Fields(out) {
+        if strings.Count(field, ".") == 3 && strings.Contains(field, "/") {
+            return strings.SplitN(field, "/", 2)[0]
+        }
+    }
+    t.Fatalf("pod %s has no address on net1: %s", name, out)
+    return ""
+}
+
+// daemonExec runs a command in the plugin daemon on node
+func daemonExec(node string, command ...string) (string, error) {
+    out, err := kubectl("-n", "kube-system", "get", "pods", "-l", "app=vlan-cni-plugin",
+        "--field-selector", "spec.nodeName="+node, "-o", "jsonpath={.items[0].metadata.name}")
+    if err != nil {
+        return "", err
+    }
+    args := append([]string{"-n", "kube-system", "exec", strings.TrimSpace(out), "-c", "vlan-cni-daemon", "--"}, command...)
+    return kubectl(args...)
+}
+
+// The subtests share the cluster and run in order; cleanup checks that
+// the earlier ones left nothing behind.
+func TestVlanNetworks(t *testing.T) {
+    node := firstNode(t)
+    apply(t, nad("e2e-vlan100", 100, "10.100.0.10", "10.100.0.50"))
+    apply(t, nad("e2e-reuse", 101, "10.101.0.10", "10.101.0.10"))
+    
+    t.Run("connectivity", func(t *testing.T) {
+        createPod(t, "ping-a", "e2e-vlan100", node)
+        createPod(t, "ping-b", "e2e-vlan100", node)
+        defer deletePod(t, "ping-a")
+        defer deletePod(t, "ping-b")
+        
+        target := vlanAddr(t, "ping-b")
+        if _, err := kubectl("-n", namespace, "exec", "ping-a", "--", "ping", "-c", "3", "-W", "2", "-I", "net1", target); err != nil {
+            t.Fatalf("ping-a cannot reach ping-b at %s: %v", target, err)
+        }
+    })
+    
+    // The range holds a single address, so the second pod only starts if
+    // DEL released it
+    t.Run("ipam reuse", func(t *testing.T) {
+        createPod(t, "reuse-1", "e2e-reuse", node)
+        first := vlanAddr(t, "reuse-1")
+        deletePod(t, "reuse-1")
+        
+        createPod(t, "reuse-2", "e2e-reuse", node)
+        defer deletePod(t, "reuse-2")
+        if second := vlanAddr(t, "reuse-2"); second != first {
+            t.Fatalf("expected the released address %s again, got %s", first, second)
+        }
+    })
+    
+    t.Run("cleanup", func(t *testing.T) {
+        err := eventually(time.Minute, func() error {
+            out, err := daemonExec(node, "sh", "-c", "ls /var/run/vlan-cni/attachments 2>/dev/null; ip -o link show type veth | grep -o 'vsh[0-9a-f]*' || true")
+            if err != nil {
+                return err
+            }
+            if strings.TrimSpace(out) != "" {
+                return fmt.Errorf("attachments or host links left behind: %s", out)
+            }
+            return nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+    })
+}